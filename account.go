@@ -32,17 +32,22 @@ func NewAccount(email string, conf *Configuration) *Account {
 	var privKey crypto.PrivateKey
 	if _, err := os.Stat(accKeyPath); os.IsNotExist(err) {
 
-		log.Printf("No key found for account %s. Generating a curve P384 EC key.", email)
-		privKey, err = generatePrivateKey(accKeyPath)
+		keyType, err := conf.AccountKeyType()
 		if err != nil {
-			log.Fatalf("Could not generate RSA private account key for account %s: %v", email, err)
+			log.Fatal(err)
+		}
+
+		log.Printf("No key found for account %s. Generating a %s key.", email, conf.context.GlobalString("account-key-type"))
+		privKey, err = generatePrivateKey(accKeyPath, keyType)
+		if err != nil {
+			log.Fatalf("Could not generate private account key for account %s: %v", email, err)
 		}
 
 		log.Printf("Saved key to %s", accKeyPath)
 	} else {
 		privKey, err = loadPrivateKey(accKeyPath)
 		if err != nil {
-			log.Fatalf("Could not load RSA private key from file %s: %v", accKeyPath, err)
+			log.Fatalf("Could not load private key from file %s: %v", accKeyPath, err)
 		}
 	}
 
@@ -87,7 +92,7 @@ func NewAccount(email string, conf *Configuration) *Account {
 
 func tryRecoverAccount(privKey crypto.PrivateKey, conf *Configuration) (*acme.RegistrationResource, error) {
 	// couldn't load account but got a key. Try to look the account up.
-	serverURL := conf.context.GlobalString("server")
+	serverURL := conf.ServerURL()
 	client, err := acme.NewClient(serverURL, &Account{key: privKey, conf: conf}, acme.RSA2048)
 	if err != nil {
 		return nil, err
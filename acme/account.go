@@ -0,0 +1,120 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Account is an ACME account saved by Client.SaveAccount and reloaded by LoadAccount.
+// It implements User, so it can be used to create a client with NewClient.
+type Account struct {
+	Email        string
+	Registration *RegistrationResource
+	// DirectoryURL is the directory of the CA the account is registered with.
+	DirectoryURL string
+
+	key crypto.PrivateKey
+}
+
+// GetEmail returns the email of the account.
+func (a *Account) GetEmail() string {
+	return a.Email
+}
+
+// GetRegistration returns the registration of the account.
+func (a *Account) GetRegistration() *RegistrationResource {
+	return a.Registration
+}
+
+// GetPrivateKey returns the private key of the account.
+func (a *Account) GetPrivateKey() crypto.PrivateKey {
+	return a.key
+}
+
+// accountFile is the JSON representation of an Account.
+type accountFile struct {
+	Email        string                `json:"email,omitempty"`
+	DirectoryURL string                `json:"directoryURL"`
+	Registration *RegistrationResource `json:"registration"`
+	Key          string                `json:"key"`
+}
+
+// SaveAccount writes the account of the client as JSON: its email, CA directory,
+// registration (URL and contacts) and PEM encoded private key.
+// The account must be registered. The output contains the private key and must be kept secret.
+func (c *Client) SaveAccount(w io.Writer) error {
+	if c.user == nil || c.user.GetRegistration() == nil || c.user.GetRegistration().URI == "" {
+		return errors.New("acme: cannot save an account which is not registered")
+	}
+
+	// the key of the account is the one of the client, it was replaced if the key was rolled over.
+	key := c.jws.privKey
+
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return errors.New("acme: cannot save an account with an unsupported private key")
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+
+	return encoder.Encode(accountFile{
+		Email:        c.user.GetEmail(),
+		DirectoryURL: c.directoryURL,
+		Registration: c.user.GetRegistration(),
+		Key:          string(pemEncode(key)),
+	})
+}
+
+// LoadAccount reads an account saved by Client.SaveAccount.
+// The account is looked up by key at its CA, to check that the key matches the registration.
+func LoadAccount(r io.Reader) (*Account, error) {
+	var file accountFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("acme: could not read the account: %v", err)
+	}
+
+	if file.Registration == nil || file.Registration.URI == "" {
+		return nil, errors.New("acme: the account has no registration")
+	}
+
+	if _, err := pemDecode([]byte(file.Key)); err != nil {
+		return nil, fmt.Errorf("acme: could not read the account key: %v", err)
+	}
+
+	key, err := parsePEMPrivateKey([]byte(file.Key))
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not read the account key: %v", err)
+	}
+
+	account := &Account{
+		Email:        file.Email,
+		Registration: file.Registration,
+		DirectoryURL: file.DirectoryURL,
+		key:          key,
+	}
+
+	client, err := NewClient(account.DirectoryURL, account, "")
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := client.ResolveAccountByKey()
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not find the account at the CA: %v", err)
+	}
+
+	if reg.URI != account.Registration.URI {
+		return nil, fmt.Errorf("acme: the key belongs to the account %s, not to %s", reg.URI, account.Registration.URI)
+	}
+
+	return account, nil
+}
@@ -0,0 +1,260 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	caKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var certificate []byte
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		var payload []byte
+		if r.Method == http.MethodPost {
+			signed := readJWS(t, r)
+			if r.URL.Path != "/newAccount" && signed.Signatures[0].Protected.KeyID != ts.URL+"/account/1" {
+				t.Errorf("Expected the request to %s to be signed with the account kid, got %q", r.URL.Path, signed.Signatures[0].Protected.KeyID)
+			}
+			if payload, err = signed.Verify(&key.PublicKey); err != nil {
+				t.Errorf("Expected the request to %s to be signed with the account key: %v", r.URL.Path, err)
+			}
+		}
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/newAccount",
+				NewOrderURL:   ts.URL + "/newOrder",
+			})
+		case "/nonce":
+		case "/newAccount":
+			w.Header().Add("Location", ts.URL+"/account/1")
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		case "/account/1":
+			writeJSONResponse(w, accountMessage{Status: "valid", Contact: []string{"mailto:test@test.com"}})
+		case "/newOrder":
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{
+				Status:         "pending",
+				Identifiers:    []identifier{{Type: "dns", Value: "example.com"}},
+				Authorizations: []string{ts.URL + "/authz/1"},
+				Finalize:       ts.URL + "/finalize/1",
+			})
+		case "/authz/1":
+			writeJSONResponse(w, authorization{Status: "valid", Identifier: identifier{Type: "dns", Value: "example.com"}})
+		case "/finalize/1":
+			var msg csrMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Fatalf("Could not read the finalize request: %v", err)
+			}
+			der, _ := base64.RawURLEncoding.DecodeString(msg.Csr)
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				t.Fatalf("Could not parse the CSR: %v", err)
+			}
+
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			certDER, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+			if err != nil {
+				t.Fatalf("Could not issue the certificate: %v", err)
+			}
+			certificate = pemEncode(derCertificateBytes(certDER))
+
+			writeJSONResponse(w, orderMessage{Status: "valid", Certificate: ts.URL + "/cert/1"})
+		case "/cert/1":
+			w.Write(certificate)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1", Body: accountMessage{Contact: []string{"mailto:test@test.com"}}},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := client.SaveAccount(buf); err != nil {
+		t.Fatalf("Could not save the account: %v", err)
+	}
+	if !strings.Contains(buf.String(), "RSA PRIVATE KEY") {
+		t.Errorf("Expected the account key to be saved as PEM, got %s", buf.String())
+	}
+
+	account, err := LoadAccount(buf)
+	if err != nil {
+		t.Fatalf("Could not load the account: %v", err)
+	}
+
+	if account.GetEmail() != "test@test.com" || account.GetRegistration().URI != ts.URL+"/account/1" || account.DirectoryURL != ts.URL+"/directory" {
+		t.Errorf("Unexpected account: %+v", account)
+	}
+	if len(account.GetRegistration().Body.Contact) != 1 || account.GetRegistration().Body.Contact[0] != "mailto:test@test.com" {
+		t.Errorf("Expected the contact to be reloaded, got %v", account.GetRegistration().Body.Contact)
+	}
+	if account.GetPrivateKey().(*rsa.PrivateKey).N.Cmp(key.N) != 0 {
+		t.Error("Expected the account key to be reloaded")
+	}
+
+	reloaded, err := NewClient(account.DirectoryURL, account, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	cert, err := reloaded.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if err != nil {
+		t.Fatalf("Could not obtain a certificate with the reloaded account: %v", err)
+	}
+	if !bytes.Equal(cert.Certificate, certificate) {
+		t.Error("Expected the issued certificate to be returned")
+	}
+}
+
+func TestSaveAccountAfterRollover(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	// the CA finds the account by its current key only.
+	accountKey := &oldKey.PublicKey
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/newAccount",
+				NewOrderURL:   ts.URL + "/newOrder",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+		case "/keyChange":
+			if _, err := readJWS(t, r).Verify(accountKey); err != nil {
+				t.Errorf("Expected the key change to be signed with the old key: %v", err)
+			}
+			accountKey = &newKey.PublicKey
+		case "/newAccount":
+			if _, err := readJWS(t, r).Verify(accountKey); err != nil {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"type":"urn:ietf:params:acme:error:accountDoesNotExist","detail":"no account for the key"}`))
+				return
+			}
+			w.Header().Add("Location", ts.URL+"/account/1")
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		case "/account/1":
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: oldKey,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	if err = client.RolloverAccountKey(newKey); err != nil {
+		t.Fatalf("Could not rollover the account key: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = client.SaveAccount(buf); err != nil {
+		t.Fatalf("Could not save the account: %v", err)
+	}
+
+	account, err := LoadAccount(buf)
+	if err != nil {
+		t.Fatalf("Could not load the account: %v", err)
+	}
+	if account.GetPrivateKey().(*rsa.PrivateKey).N.Cmp(newKey.N) != 0 {
+		t.Error("Expected the new account key to be saved")
+	}
+}
+
+func TestLoadAccountKeyMismatch(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/newAccount",
+				NewOrderURL:   ts.URL + "/newOrder",
+			})
+		case "/newAccount":
+			// the key is registered to another account
+			w.Header().Add("Location", ts.URL+"/account/2")
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		case "/account/2":
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		}
+	}))
+	defer ts.Close()
+
+	saved, _ := json.Marshal(accountFile{
+		DirectoryURL: ts.URL + "/directory",
+		Registration: &RegistrationResource{URI: ts.URL + "/account/1"},
+		Key:          string(pemEncode(key)),
+	})
+
+	_, err := LoadAccount(bytes.NewReader(saved))
+	if err == nil || !strings.Contains(err.Error(), "the key belongs to the account") {
+		t.Errorf("Expected a key mismatch error, got %v", err)
+	}
+
+	_, err = LoadAccount(strings.NewReader(`{"registration": {"uri": "https://example.com/account/1"}, "key": "not a key"}`))
+	if err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+}
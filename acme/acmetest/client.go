@@ -0,0 +1,62 @@
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// User is a minimal acme.User for the tests.
+type User struct {
+	Email        string
+	Registration *acme.RegistrationResource
+	Key          crypto.PrivateKey
+}
+
+// GetEmail returns the email of the user.
+func (u *User) GetEmail() string {
+	return u.Email
+}
+
+// GetRegistration returns the registration of the user.
+func (u *User) GetRegistration() *acme.RegistrationResource {
+	return u.Registration
+}
+
+// GetPrivateKey returns the account key of the user.
+func (u *User) GetPrivateKey() crypto.PrivateKey {
+	return u.Key
+}
+
+// NewTestClient returns a client registered to the Server with a new account,
+// solving the http-01 challenges with the FakeProvider of the Server.
+// The certificates keys are P-256 keys to keep the tests fast.
+func NewTestClient(server *Server) (*acme.Client, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Email: "test@example.com", Key: key}
+
+	client, err := acme.NewClient(server.DirectoryURL(), user, acme.EC256)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.SetChallengeProvider(acme.HTTP01, server.Provider())
+	if err != nil {
+		return nil, err
+	}
+	client.ExcludeChallenges([]acme.Challenge{acme.TLSALPN01, acme.DNS01})
+
+	user.Registration, err = client.Register(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,46 @@
+package acmetest_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+
+	"github.com/xenolf/lego/acme/acmetest"
+)
+
+// Example obtains a certificate from the in-process CA,
+// the http-01 challenges being presented to the fake provider.
+func Example() {
+	provider := acmetest.NewFakeProvider()
+
+	server, err := acmetest.NewServer(provider)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := acmetest.NewTestClient(server)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	certRes, err := client.ObtainCertificate([]string{"example.com", "www.example.com"}, true, nil, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certRes.Certificate)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: server.Roots()})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(cert.DNSNames)
+	fmt.Println(len(provider.Presented()), "challenges presented,", len(provider.CleanedUp()), "cleaned up")
+}
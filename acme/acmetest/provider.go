@@ -0,0 +1,100 @@
+package acmetest
+
+import (
+	"sync"
+)
+
+// Challenge is a challenge presented to, or cleaned up from, a FakeProvider.
+type Challenge struct {
+	Domain  string
+	Token   string
+	KeyAuth string
+}
+
+// FakeProvider is an in-memory acme.ChallengeProvider.
+// It records the presented and cleaned up challenges for the assertions of the tests,
+// the challenges presented and not yet cleaned up are the ones a Server validates.
+type FakeProvider struct {
+	// PresentErr, if set, is returned by Present without presenting the challenge.
+	PresentErr error
+	// CleanUpErr, if set, is returned by CleanUp after cleaning the challenge up.
+	CleanUpErr error
+
+	mu        sync.Mutex
+	active    map[string]string
+	presented []Challenge
+	cleanedUp []Challenge
+}
+
+// NewFakeProvider returns an empty FakeProvider.
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{active: make(map[string]string)}
+}
+
+// Present records the challenge and makes it available to the validation of the Server.
+func (p *FakeProvider) Present(domain, token, keyAuth string) error {
+	if p.PresentErr != nil {
+		return p.PresentErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == nil {
+		p.active = make(map[string]string)
+	}
+	p.active[challengeKey(domain, token)] = keyAuth
+	p.presented = append(p.presented, Challenge{Domain: domain, Token: token, KeyAuth: keyAuth})
+
+	return nil
+}
+
+// CleanUp records the challenge and removes it from the presented ones.
+func (p *FakeProvider) CleanUp(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.active, challengeKey(domain, token))
+	p.cleanedUp = append(p.cleanedUp, Challenge{Domain: domain, Token: token, KeyAuth: keyAuth})
+
+	return p.CleanUpErr
+}
+
+// Presented returns the challenges presented so far, in order.
+func (p *FakeProvider) Presented() []Challenge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Challenge(nil), p.presented...)
+}
+
+// CleanedUp returns the challenges cleaned up so far, in order.
+func (p *FakeProvider) CleanedUp() []Challenge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Challenge(nil), p.cleanedUp...)
+}
+
+// Reset forgets the recorded challenges.
+func (p *FakeProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active = make(map[string]string)
+	p.presented = nil
+	p.cleanedUp = nil
+}
+
+// keyAuthorization returns the key authorization currently presented for the token of the domain.
+func (p *FakeProvider) keyAuthorization(domain, token string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keyAuth, ok := p.active[challengeKey(domain, token)]
+	return keyAuth, ok
+}
+
+func challengeKey(domain, token string) string {
+	return domain + " " + token
+}
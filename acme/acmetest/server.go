@@ -0,0 +1,789 @@
+// Package acmetest provides an in-process ACME CA and an in-memory challenge provider
+// to test the code using lego without a network access to a real CA.
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// certificateValidity is the validity of the certificates issued by the Server.
+const certificateValidity = 90 * 24 * time.Hour
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+type challenge struct {
+	URL    string   `json:"url"`
+	Type   string   `json:"type"`
+	Status string   `json:"status"`
+	Token  string   `json:"token"`
+	Error  *problem `json:"error,omitempty"`
+	authz  *authorization
+}
+
+type authorization struct {
+	Status     string       `json:"status"`
+	Expires    time.Time    `json:"expires"`
+	Identifier identifier   `json:"identifier"`
+	Challenges []*challenge `json:"challenges"`
+	account    string
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	authzs         []*authorization
+	account        string
+}
+
+type account struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+	Orders  string   `json:"orders,omitempty"`
+	key     *jose.JSONWebKey
+}
+
+// Server is an in-process ACME CA validating the http-01 challenges
+// presented to its FakeProvider, the other challenge types are not offered.
+// It implements the subset of the protocol used by the lego client: accounts, orders,
+// authorizations, finalization, certificate download and revocation.
+type Server struct {
+	// NewOrderLimit, if positive, is the number of orders the Server creates,
+	// the following new orders are refused with a rate limit error.
+	NewOrderLimit int
+
+	server   *httptest.Server
+	provider *FakeProvider
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	caPEM  []byte
+
+	mu       sync.Mutex
+	nextID   int
+	nonces   map[string]bool
+	accounts map[string]*account
+	orders   map[string]*order
+	authzs   map[string]*authorization
+	chlngs   map[string]*challenge
+	certs    map[string][]byte
+	revoked  map[string]bool
+	// requests are the numbers of requests, by resource.
+	requests map[string]int
+}
+
+// NewServer starts a Server validating the challenges against the provider.
+// It should be closed when the test is done.
+func NewServer(provider *FakeProvider) (*Server, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lego acmetest root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * certificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		provider: provider,
+		caKey:    caKey,
+		caCert:   caCert,
+		caPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		nonces:   make(map[string]bool),
+		accounts: make(map[string]*account),
+		orders:   make(map[string]*order),
+		authzs:   make(map[string]*authorization),
+		chlngs:   make(map[string]*challenge),
+		certs:    make(map[string][]byte),
+		revoked:  make(map[string]bool),
+		requests: make(map[string]int),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s, nil
+}
+
+// Close shuts the Server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// DirectoryURL returns the URL of the ACME directory of the Server.
+func (s *Server) DirectoryURL() string {
+	return s.server.URL + "/directory"
+}
+
+// Provider returns the FakeProvider the challenges are validated against.
+func (s *Server) Provider() *FakeProvider {
+	return s.provider
+}
+
+// Roots returns a pool with the root certificate of the Server, to verify the issued certificates.
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.caCert)
+	return pool
+}
+
+// Revoked reports whether the certificate with the given serial number has been revoked.
+func (s *Server) Revoked(serial *big.Int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revoked[serial.String()]
+}
+
+// Requests returns the number of requests received for the resource, e.g. "directory",
+// "nonce", "new-account" or "new-order".
+func (s *Server) Requests(resource string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[resource]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	resource, id := parts[0], ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+	s.requests[resource]++
+
+	switch {
+	case resource == "directory" && r.Method == http.MethodGet:
+		s.writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   s.url("nonce"),
+			"newAccount": s.url("new-account"),
+			"newOrder":   s.url("new-order"),
+			"revokeCert": s.url("revoke-cert"),
+			"keyChange":  s.url("key-change"),
+		})
+	case resource == "nonce" && (r.Method == http.MethodHead || r.Method == http.MethodGet):
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost:
+		s.handlePost(w, r, resource, id)
+	case r.Method == http.MethodGet:
+		s.handleGet(w, resource, id)
+	default:
+		s.writeProblem(w, http.StatusMethodNotAllowed, "malformed", "method %s not allowed on %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, resource, id string) {
+	switch resource {
+	case "order":
+		if o, ok := s.orders[id]; ok {
+			s.writeJSON(w, http.StatusOK, o)
+			return
+		}
+	case "authz":
+		if authz, ok := s.authzs[id]; ok {
+			s.writeJSON(w, http.StatusOK, authz)
+			return
+		}
+	case "challenge":
+		if chlng, ok := s.chlngs[id]; ok {
+			s.writeJSON(w, http.StatusOK, chlng)
+			return
+		}
+	case "cert":
+		if cert, ok := s.certs[id]; ok {
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+			w.WriteHeader(http.StatusOK)
+			w.Write(cert)
+			return
+		}
+	}
+
+	s.writeProblem(w, http.StatusNotFound, "malformed", "no resource %s/%s", resource, id)
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, resource, id string) {
+	payload, header, prob := s.verifyJWS(r)
+	if prob != nil {
+		s.sendProblem(w, prob)
+		return
+	}
+
+	if resource == "new-account" {
+		s.newAccount(w, payload, header)
+		return
+	}
+
+	acct, ok := s.accounts[header.KeyID]
+	if !ok || acct.Status != "valid" {
+		s.writeProblem(w, http.StatusUnauthorized, "accountDoesNotExist", "unknown account %s", header.KeyID)
+		return
+	}
+
+	switch resource {
+	case "new-order":
+		s.newOrder(w, payload, header.KeyID)
+	case "challenge":
+		s.validateChallenge(w, id, acct)
+	case "authz":
+		s.updateAuthorization(w, payload, id, header.KeyID)
+	case "finalize":
+		s.finalize(w, payload, id, header.KeyID)
+	case "account":
+		s.updateAccount(w, payload, header.KeyID)
+	case "revoke-cert":
+		s.revokeCertificate(w, payload)
+	default:
+		// POST-as-GET
+		if len(payload) == 0 {
+			s.handleGet(w, resource, id)
+			return
+		}
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no resource %s/%s", resource, id)
+	}
+}
+
+// verifyJWS checks the signature, nonce and URL of a JWS request and returns its payload.
+func (s *Server) verifyJWS(r *http.Request) ([]byte, jose.Header, *problem) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, jose.Header{}, newProblem(http.StatusBadRequest, "malformed", "could not read the request: %v", err)
+	}
+
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil || len(jws.Signatures) != 1 {
+		return nil, jose.Header{}, newProblem(http.StatusBadRequest, "malformed", "invalid JWS: %v", err)
+	}
+
+	header := jws.Signatures[0].Protected
+
+	if !s.nonces[header.Nonce] {
+		return nil, header, newProblem(http.StatusBadRequest, "badNonce", "invalid nonce %q", header.Nonce)
+	}
+	delete(s.nonces, header.Nonce)
+
+	if u, _ := header.ExtraHeaders["url"].(string); u != s.server.URL+r.URL.Path {
+		return nil, header, newProblem(http.StatusUnauthorized, "unauthorized", "the url header %q does not match the request URL", u)
+	}
+
+	key := header.JSONWebKey
+	if header.KeyID != "" {
+		acct, ok := s.accounts[header.KeyID]
+		if !ok {
+			return nil, header, newProblem(http.StatusUnauthorized, "accountDoesNotExist", "unknown account %s", header.KeyID)
+		}
+		key = acct.key
+	}
+	if key == nil {
+		return nil, header, newProblem(http.StatusBadRequest, "malformed", "the JWS has neither a jwk nor a kid")
+	}
+
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, header, newProblem(http.StatusUnauthorized, "unauthorized", "invalid JWS signature: %v", err)
+	}
+
+	return payload, header, nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, payload []byte, header jose.Header) {
+	if header.JSONWebKey == nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "a new account request must carry a jwk")
+		return
+	}
+
+	var msg struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid account: %v", err)
+		return
+	}
+
+	thumbprint, err := keyThumbprint(header.JSONWebKey)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badPublicKey", "%v", err)
+		return
+	}
+
+	for accountURL, acct := range s.accounts {
+		if existing, _ := keyThumbprint(acct.key); existing == thumbprint {
+			w.Header().Set("Location", accountURL)
+			s.writeJSON(w, http.StatusOK, acct)
+			return
+		}
+	}
+
+	if msg.OnlyReturnExisting {
+		s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for this key")
+		return
+	}
+
+	acct := &account{Status: "valid", Contact: msg.Contact, key: header.JSONWebKey}
+	accountURL := s.url("account", s.newID())
+	s.accounts[accountURL] = acct
+
+	w.Header().Set("Location", accountURL)
+	s.writeJSON(w, http.StatusCreated, acct)
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, payload []byte, accountURL string) {
+	acct := s.accounts[accountURL]
+
+	var msg struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact"`
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid account: %v", err)
+			return
+		}
+	}
+
+	if msg.Status == "deactivated" {
+		acct.Status = msg.Status
+	}
+	if msg.Contact != nil {
+		acct.Contact = msg.Contact
+	}
+
+	s.writeJSON(w, http.StatusOK, acct)
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, payload []byte, accountURL string) {
+	var msg struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || len(msg.Identifiers) == 0 {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid order: %v", err)
+		return
+	}
+
+	if s.NewOrderLimit > 0 && len(s.orders) >= s.NewOrderLimit {
+		s.writeProblem(w, http.StatusTooManyRequests, "rateLimited", "too many new orders recently")
+		return
+	}
+
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+	id := s.newID()
+	o := &order{
+		Status:      "pending",
+		Expires:     expires,
+		Identifiers: msg.Identifiers,
+		Finalize:    s.url("finalize", id),
+		account:     accountURL,
+	}
+
+	for _, ident := range msg.Identifiers {
+		if ident.Type != "dns" && ident.Type != "ip" {
+			s.writeProblem(w, http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type %q", ident.Type)
+			return
+		}
+		if strings.HasPrefix(ident.Value, "*.") {
+			s.writeProblem(w, http.StatusBadRequest, "rejectedIdentifier", "wildcard identifiers require a dns-01 challenge, not offered by this server")
+			return
+		}
+
+		authzID := s.newID()
+		authz := &authorization{
+			Status:     "pending",
+			Expires:    expires,
+			Identifier: ident,
+			account:    accountURL,
+		}
+
+		chlngID := s.newID()
+		chlng := &challenge{
+			URL:    s.url("challenge", chlngID),
+			Type:   "http-01",
+			Status: "pending",
+			Token:  newToken(),
+			authz:  authz,
+		}
+		authz.Challenges = []*challenge{chlng}
+
+		s.authzs[authzID] = authz
+		s.chlngs[chlngID] = chlng
+
+		o.authzs = append(o.authzs, authz)
+		o.Authorizations = append(o.Authorizations, s.url("authz", authzID))
+	}
+
+	s.orders[id] = o
+
+	w.Header().Set("Location", s.url("order", id))
+	s.writeJSON(w, http.StatusCreated, o)
+}
+
+// validateChallenge validates the challenge against the key authorization presented to the FakeProvider.
+func (s *Server) validateChallenge(w http.ResponseWriter, id string, acct *account) {
+	chlng, ok := s.chlngs[id]
+	if !ok || s.accounts[chlng.authz.account] != acct {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no challenge %s", id)
+		return
+	}
+
+	if chlng.Status == "pending" {
+		thumbprint, err := keyThumbprint(acct.key)
+		if err != nil {
+			s.writeProblem(w, http.StatusInternalServerError, "serverInternal", "%v", err)
+			return
+		}
+
+		expected := chlng.Token + "." + thumbprint
+		keyAuth, presented := s.provider.keyAuthorization(chlng.authz.Identifier.Value, chlng.Token)
+
+		switch {
+		case !presented:
+			chlng.Status = "invalid"
+			chlng.Error = newProblem(http.StatusForbidden, "unauthorized", "the challenge of %s is not presented", chlng.authz.Identifier.Value)
+		case keyAuth != expected:
+			chlng.Status = "invalid"
+			chlng.Error = newProblem(http.StatusForbidden, "unauthorized", "the key authorization %q of %s does not match %q", keyAuth, chlng.authz.Identifier.Value, expected)
+		default:
+			chlng.Status = "valid"
+		}
+
+		chlng.authz.Status = chlng.Status
+		s.updateOrders()
+	}
+
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="up"`, s.authzURL(chlng.authz)))
+	s.writeJSON(w, http.StatusOK, chlng)
+}
+
+func (s *Server) updateAuthorization(w http.ResponseWriter, payload []byte, id, accountURL string) {
+	authz, ok := s.authzs[id]
+	if !ok || authz.account != accountURL {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no authorization %s", id)
+		return
+	}
+
+	var msg struct {
+		Status string `json:"status"`
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid authorization: %v", err)
+			return
+		}
+	}
+
+	if msg.Status == "deactivated" {
+		authz.Status = msg.Status
+		s.updateOrders()
+	}
+
+	s.writeJSON(w, http.StatusOK, authz)
+}
+
+// updateOrders moves the pending orders to ready once all their authorizations are valid,
+// and to invalid once one of them is not.
+func (s *Server) updateOrders() {
+	for _, o := range s.orders {
+		if o.Status != "pending" {
+			continue
+		}
+
+		ready := true
+		for _, authz := range o.authzs {
+			switch authz.Status {
+			case "valid":
+			case "pending":
+				ready = false
+			default:
+				o.Status = "invalid"
+			}
+		}
+
+		if ready && o.Status == "pending" {
+			o.Status = "ready"
+		}
+	}
+}
+
+func (s *Server) finalize(w http.ResponseWriter, payload []byte, id, accountURL string) {
+	o, ok := s.orders[id]
+	if !ok || o.account != accountURL {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no order %s", id)
+		return
+	}
+
+	if o.Status != "ready" {
+		s.writeProblem(w, http.StatusForbidden, "orderNotReady", "the order is %s", o.Status)
+		return
+	}
+
+	var msg struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid finalization: %v", err)
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(msg.CSR)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "invalid CSR encoding: %v", err)
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "invalid CSR: %v", err)
+		return
+	}
+
+	if names, expected := csrNames(csr), orderNames(o); strings.Join(names, ",") != strings.Join(expected, ",") {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "the CSR names %v do not match the order identifiers %v", names, expected)
+		return
+	}
+
+	cert, err := s.issue(csr)
+	if err != nil {
+		s.writeProblem(w, http.StatusInternalServerError, "serverInternal", "could not issue the certificate: %v", err)
+		return
+	}
+
+	certID := s.newID()
+	s.certs[certID] = cert
+
+	o.Status = "valid"
+	o.Certificate = s.url("cert", certID)
+
+	w.Header().Set("Location", s.url("order", id))
+	s.writeJSON(w, http.StatusOK, o)
+}
+
+// issue signs a certificate for the CSR and returns the PEM chain of the certificate and the root.
+func (s *Server) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	// Keep the requested extensions (e.g. OCSP must staple) but the SAN one,
+	// which would override the names of the template.
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal([]int{2, 5, 29, 17}) {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(leaf, s.caPEM...), nil
+}
+
+func (s *Server) revokeCertificate(w http.ResponseWriter, payload []byte) {
+	var msg struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid revocation: %v", err)
+		return
+	}
+
+	// The lego client pads the encoded certificate.
+	der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(msg.Certificate, "="))
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid certificate encoding: %v", err)
+		return
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil || cert.CheckSignatureFrom(s.caCert) != nil {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "unknown certificate")
+		return
+	}
+
+	if s.revoked[cert.SerialNumber.String()] {
+		s.writeProblem(w, http.StatusBadRequest, "alreadyRevoked", "the certificate is already revoked")
+		return
+	}
+	s.revoked[cert.SerialNumber.String()] = true
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) authzURL(authz *authorization) string {
+	for id, a := range s.authzs {
+		if a == authz {
+			return s.url("authz", id)
+		}
+	}
+	return ""
+}
+
+func (s *Server) url(parts ...string) string {
+	return s.server.URL + "/" + strings.Join(parts, "/")
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *Server) newNonce() string {
+	nonce := newToken()
+	s.nonces[nonce] = true
+	return nonce
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) writeProblem(w http.ResponseWriter, status int, typ, format string, args ...interface{}) {
+	s.sendProblem(w, newProblem(status, typ, format, args...))
+}
+
+func (s *Server) sendProblem(w http.ResponseWriter, prob *problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(prob.Status)
+	json.NewEncoder(w).Encode(prob)
+}
+
+func newProblem(status int, typ, format string, args ...interface{}) *problem {
+	return &problem{
+		Type:   "urn:ietf:params:acme:error:" + typ,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func keyThumbprint(key *jose.JSONWebKey) (string, error) {
+	// go-jose computes invalid thumbprints of the Ed25519 keys (RFC 8037).
+	if publicKey, ok := key.Key.(ed25519.PublicKey); ok {
+		jwk := fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(publicKey))
+		thumbprint := sha256.Sum256([]byte(jwk))
+		return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// csrNames returns the sorted and deduplicated names and IP addresses of the CSR, including its common name.
+func csrNames(csr *x509.CertificateRequest) []string {
+	names := append([]string(nil), csr.DNSNames...)
+	if csr.Subject.CommonName != "" {
+		names = append(names, csr.Subject.CommonName)
+	}
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return uniqueSorted(names)
+}
+
+func orderNames(o *order) []string {
+	var names []string
+	for _, ident := range o.Identifiers {
+		if ip := net.ParseIP(ident.Value); ip != nil {
+			names = append(names, ip.String())
+		} else {
+			names = append(names, ident.Value)
+		}
+	}
+	return uniqueSorted(names)
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		v = strings.ToLower(v)
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
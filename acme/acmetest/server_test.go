@@ -0,0 +1,348 @@
+package acmetest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestObtainCertificate(t *testing.T) {
+	provider := NewFakeProvider()
+	server, err := NewServer(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+
+	domains := []string{"example.com", "www.example.com"}
+
+	certRes, err := client.ObtainCertificate(domains, false, nil, false)
+	if err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+
+	cert := parseCertificate(t, certRes.Certificate)
+	if _, err = cert.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: server.Roots()}); err != nil {
+		t.Errorf("The certificate does not verify against the roots of the server: %v", err)
+	}
+	if cert.Subject.CommonName != "example.com" {
+		t.Errorf("Expected the common name example.com, got %q", cert.Subject.CommonName)
+	}
+	if issuer := parseCertificate(t, certRes.IssuerCertificate); !issuer.IsCA {
+		t.Errorf("Expected the issuer certificate to be the CA, got %s", issuer.Subject)
+	}
+
+	presented := provider.Presented()
+	if got := challengeDomains(presented); strings.Join(got, ",") != strings.Join(domains, ",") {
+		t.Errorf("Expected the challenges of %v to be presented, got %v", domains, got)
+	}
+	for _, chlng := range presented {
+		if !strings.HasPrefix(chlng.KeyAuth, chlng.Token+".") {
+			t.Errorf("Expected the key authorization of %s to start with its token, got %q", chlng.Domain, chlng.KeyAuth)
+		}
+	}
+	if got := challengeDomains(provider.CleanedUp()); strings.Join(got, ",") != strings.Join(domains, ",") {
+		t.Errorf("Expected the challenges of %v to be cleaned up, got %v", domains, got)
+	}
+
+	if err = client.RevokeCertificate(certRes.Certificate); err != nil {
+		t.Fatalf("Could not revoke the certificate: %v", err)
+	}
+	if !server.Revoked(cert.SerialNumber) {
+		t.Error("Expected the certificate to be revoked")
+	}
+}
+
+func TestObtainBatch(t *testing.T) {
+	server, err := NewServer(NewFakeProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+	client.SetBatchConcurrency(4)
+	client.SetNewOrderRateLimit(1000, 10)
+
+	var requests []acme.ObtainRequest
+	for i := 0; i < 10; i++ {
+		requests = append(requests, acme.ObtainRequest{Domains: []string{fmt.Sprintf("site%d.example.com", i)}})
+	}
+
+	results := client.ObtainBatch(requests)
+	if len(results) != len(requests) {
+		t.Fatalf("Expected %d results, got %d", len(requests), len(results))
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("Could not obtain the certificate of %v: %v", result.Request.Domains, result.Err)
+			continue
+		}
+		if result.Request.Domains[0] != requests[i].Domains[0] {
+			t.Errorf("Expected the result %d to be the one of %v, got %v", i, requests[i].Domains, result.Request.Domains)
+		}
+		if cert := parseCertificate(t, result.Certificate.Certificate); cert.Subject.CommonName != requests[i].Domains[0] {
+			t.Errorf("Expected the certificate of %s, got %s", requests[i].Domains[0], cert.Subject.CommonName)
+		}
+	}
+
+	// the directory and the account of the client are reused by all the requests.
+	for resource, expected := range map[string]int{"directory": 1, "new-account": 1, "new-order": 10} {
+		if got := server.Requests(resource); got != expected {
+			t.Errorf("Expected %d %s requests, got %d", expected, resource, got)
+		}
+	}
+	// the nonces are taken from the previous responses, only the first concurrent requests may fetch one.
+	if got := server.Requests("nonce"); got > 1+4 {
+		t.Errorf("Expected the nonces to be reused, got %d nonce requests", got)
+	}
+}
+
+func TestObtainCertificateWithContextNewOrderRateLimit(t *testing.T) {
+	server, err := NewServer(NewFakeProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+	// a new order every 100 seconds.
+	client.SetNewOrderRateLimit(0.01, 1)
+
+	if _, err = client.ObtainCertificate([]string{"example.com"}, false, nil, false); err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the wait for the next order is bounded by the context.
+	start := time.Now()
+	_, err = client.ObtainCertificateWithContext(ctx, []string{"www.example.com"}, false, nil, false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wait to stop at the deadline, took %v", elapsed)
+	}
+	if got := server.Requests("new-order"); got != 1 {
+		t.Errorf("Expected 1 new-order request, got %d", got)
+	}
+}
+
+func TestObtainBatchRateLimited(t *testing.T) {
+	server, err := NewServer(NewFakeProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.NewOrderLimit = 2
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+
+	var requests []acme.ObtainRequest
+	for i := 0; i < 5; i++ {
+		requests = append(requests, acme.ObtainRequest{Domains: []string{fmt.Sprintf("site%d.example.com", i)}})
+	}
+
+	results := client.ObtainBatch(requests)
+
+	for i, result := range results {
+		if i < 2 {
+			if result.Err != nil {
+				t.Errorf("Could not obtain the certificate of %v: %v", result.Request.Domains, result.Err)
+			}
+			continue
+		}
+
+		rlErr, ok := result.Err.(acme.RateLimitError)
+		if !ok || rlErr.Limit != "new orders" {
+			t.Errorf("Expected the new orders rate limit error for %v, got %v", result.Request.Domains, result.Err)
+		}
+	}
+
+	// once rate limited, the following requests are not sent.
+	if got := server.Requests("new-order"); got != 3 {
+		t.Errorf("Expected 3 new-order requests, got %d", got)
+	}
+}
+
+func TestObtainCertificatePresentError(t *testing.T) {
+	provider := NewFakeProvider()
+	provider.PresentErr = errors.New("no luck")
+
+	server, err := NewServer(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+
+	_, err = client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "no luck") {
+		t.Fatalf("Expected the error of the provider, got %v", err)
+	}
+	if len(provider.Presented()) != 0 {
+		t.Errorf("Expected no challenge to be recorded, got %v", provider.Presented())
+	}
+}
+
+func TestObtainCertificateWrongKeyAuthorization(t *testing.T) {
+	server, err := NewServer(NewFakeProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := NewTestClient(server)
+	if err != nil {
+		t.Fatalf("Could not create the client: %v", err)
+	}
+
+	// Present a key authorization which does not match the account key.
+	client.SetChallengeProvider("http-01", &tamperingProvider{FakeProvider: server.Provider()})
+
+	_, err = client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Expected the challenge to be invalid, got %v", err)
+	}
+}
+
+type tamperingProvider struct {
+	*FakeProvider
+}
+
+func (p *tamperingProvider) Present(domain, token, keyAuth string) error {
+	return p.FakeProvider.Present(domain, token, keyAuth+"x")
+}
+
+func parseCertificate(t *testing.T, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("No PEM block in %q", data)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func challengeDomains(challenges []Challenge) []string {
+	var domains []string
+	for _, chlng := range challenges {
+		domains = append(domains, chlng.Domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func TestResolveAccountByKey(t *testing.T) {
+	server, err := NewServer(NewFakeProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Email: "test@example.com", Key: key}
+
+	client, err := acme.NewClient(server.DirectoryURL(), user, acme.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.ResolveAccountByKey(); err != acme.ErrNoExistingAccount {
+		t.Fatalf("Expected ErrNoExistingAccount before the registration, got %v", err)
+	}
+
+	reg, err := client.Register(true)
+	if err != nil {
+		t.Fatalf("Could not register: %v", err)
+	}
+
+	// A new client with the same key finds the account instead of registering another one.
+	client, err = acme.NewClient(server.DirectoryURL(), &User{Key: key}, acme.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := client.ResolveAccountByKey()
+	if err != nil {
+		t.Fatalf("Could not resolve the account: %v", err)
+	}
+	if resolved.URI != reg.URI {
+		t.Errorf("Expected the account %s, got %s", reg.URI, resolved.URI)
+	}
+	if resolved.Body.Status != "valid" {
+		t.Errorf("Expected a valid account, got %q", resolved.Body.Status)
+	}
+}
+
+func TestObtainCertificateEd25519Account(t *testing.T) {
+	provider := NewFakeProvider()
+	server, err := NewServer(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Email: "test@example.com", Key: key}
+
+	client, err := acme.NewClient(server.DirectoryURL(), user, acme.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetChallengeProvider(acme.HTTP01, provider); err != nil {
+		t.Fatal(err)
+	}
+	client.ExcludeChallenges([]acme.Challenge{acme.TLSALPN01, acme.DNS01})
+
+	if user.Registration, err = client.Register(true); err != nil {
+		t.Fatalf("Could not register with an Ed25519 key: %v", err)
+	}
+
+	// The server checks the key authorization of the challenge with the thumbprint of the Ed25519 key.
+	if _, err = client.ObtainCertificate([]string{"example.com"}, false, nil, false); err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+}
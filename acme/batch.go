@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"sync"
+)
+
+// ObtainRequest is a certificate to obtain with ObtainBatch,
+// its fields are the parameters of ObtainCertificate.
+type ObtainRequest struct {
+	Domains    []string
+	Bundle     bool
+	PrivateKey crypto.PrivateKey
+	MustStaple bool
+}
+
+// ObtainResult is the outcome of an ObtainRequest: the certificate, or the error of the request.
+type ObtainResult struct {
+	Request     ObtainRequest
+	Certificate *CertificateResource
+	Err         error
+}
+
+// ObtainBatch obtains independent certificates, e.g. one per hosted domain.
+// The account, the directory and the nonces of the client are shared by all the requests,
+// which are processed by up to SetBatchConcurrency requests at a time, the new orders being
+// paced by SetNewOrderRateLimit. If the CA refuses a new order because of its rate limit,
+// the requests not started yet fail with the same error instead of being sent.
+// The results are in the order of the requests.
+func (c *Client) ObtainBatch(requests []ObtainRequest) []ObtainResult {
+	return c.ObtainBatchWithContext(context.Background(), requests)
+}
+
+// ObtainBatchWithContext is like ObtainBatch, the requests not started when the context
+// is done fail with its error.
+func (c *Client) ObtainBatchWithContext(ctx context.Context, requests []ObtainRequest) []ObtainResult {
+	results := make([]ObtainResult, len(requests))
+
+	concurrency := c.batchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var rateLimited error
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(requests); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].Request = requests[i]
+
+				mu.Lock()
+				err := rateLimited
+				mu.Unlock()
+				if err == nil {
+					err = ctx.Err()
+				}
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				req := requests[i]
+				results[i].Certificate, results[i].Err = c.ObtainCertificateWithContext(ctx, req.Domains, req.Bundle, req.PrivateKey, req.MustStaple)
+
+				if rlErr, ok := results[i].Err.(RateLimitError); ok && rlErr.Limit == "new orders" {
+					mu.Lock()
+					rateLimited = rlErr
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
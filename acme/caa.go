@@ -0,0 +1,180 @@
+package acme
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/log"
+)
+
+// CAACheckMode selects the behavior of the CAA pre-check, run before ordering a certificate.
+type CAACheckMode string
+
+const (
+	// CAACheckOff disables the CAA pre-check. It is the default mode of the client.
+	CAACheckOff CAACheckMode = "off"
+	// CAACheckAdvisory logs a warning for the domains whose CAA records would block the issuance.
+	CAACheckAdvisory CAACheckMode = "advisory"
+	// CAACheckStrict fails before ordering if the CAA records of a domain would block the issuance.
+	CAACheckStrict CAACheckMode = "strict"
+)
+
+// caaCriticalFlag is the issuer critical flag of a CAA record (RFC 6844, section 5.1).
+const caaCriticalFlag = 128
+
+// SetCAACheck selects the behavior of the CAA pre-check.
+// The check relies on the CAA identities advertised by the directory of the CA,
+// it is skipped if the CA has none.
+func (c *Client) SetCAACheck(mode CAACheckMode) error {
+	switch mode {
+	case CAACheckOff, CAACheckAdvisory, CAACheckStrict:
+		c.caaCheck = mode
+		return nil
+	default:
+		return fmt.Errorf("unsupported CAA check mode %q, must be %s, %s or %s", mode, CAACheckOff, CAACheckAdvisory, CAACheckStrict)
+	}
+}
+
+// CheckCAA checks that the CAA records of the domains allow the CA to issue a certificate for them,
+// whatever the mode set with SetCAACheck. The CAA records are looked up with the recursive nameservers,
+// climbing the DNS tree up to the first name having CAA records (RFC 6844, section 4).
+// The domains whose issuance would be blocked are returned together in an ObtainError.
+func (c *Client) CheckCAA(domains []string) error {
+	identities := c.directory.Meta.CaaIdentities
+	if len(identities) == 0 {
+		return nil
+	}
+
+	failures := make(ObtainError)
+	for _, domain := range domains {
+		if err := checkCAA(domain, identities); err != nil {
+			failures[domain] = err
+		}
+	}
+
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// preCheckCAA runs the CAA pre-check of the domains according to the mode of the client.
+func (c *Client) preCheckCAA(domains []string) error {
+	if c.caaCheck == "" || c.caaCheck == CAACheckOff {
+		return nil
+	}
+
+	err := c.CheckCAA(domains)
+	if err == nil || c.caaCheck == CAACheckStrict {
+		return err
+	}
+
+	if failures, ok := err.(ObtainError); ok {
+		for domain, failure := range failures {
+			log.Warnf("[%s] %v", domain, failure)
+		}
+	}
+	return nil
+}
+
+// checkCAA checks that the CAA records of the domain allow one of the identities to issue a certificate.
+func checkCAA(domain string, identities []string) error {
+	name := strings.TrimPrefix(domain, "*.")
+	wildcard := name != domain
+
+	if net.ParseIP(name) != nil {
+		return nil
+	}
+
+	records, owner, err := lookupCAA(name)
+	if err != nil {
+		return err
+	}
+
+	tag := "issue"
+	if wildcard && hasCAATag(records, "issuewild") {
+		tag = "issuewild"
+	}
+
+	var restricted bool
+	for _, record := range records {
+		switch record.Tag {
+		case "issue", "issuewild", "iodef":
+		default:
+			if record.Flag&caaCriticalFlag != 0 {
+				return fmt.Errorf("acme: the CAA records of %s have the unknown critical property %q, no CA may issue a certificate", owner, record.Tag)
+			}
+		}
+
+		if record.Tag != tag {
+			continue
+		}
+		restricted = true
+
+		if caaIssuerAllowed(record.Value, identities) {
+			return nil
+		}
+	}
+
+	if restricted {
+		return fmt.Errorf("acme: the CAA %s records of %s do not allow the CA (%s) to issue a certificate", tag, owner, strings.Join(identities, ", "))
+	}
+	return nil
+}
+
+// lookupCAA returns the CAA records of the closest name having some, starting with the domain
+// and climbing up to its parents, with the name owning them.
+func lookupCAA(domain string) ([]*dns.CAA, string, error) {
+	labels := dns.SplitDomainName(domain)
+
+	for i := range labels {
+		name := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		in, err := dnsQuery(name, dns.TypeCAA, RecursiveNameservers, true)
+		if err != nil {
+			return nil, "", fmt.Errorf("acme: could not look up the CAA records of %s: %v", name, err)
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			return nil, "", fmt.Errorf("acme: could not look up the CAA records of %s: %s", name, dns.RcodeToString[in.Rcode])
+		}
+
+		var records []*dns.CAA
+		for _, rr := range in.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return records, UnFqdn(name), nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+func hasCAATag(records []*dns.CAA, tag string) bool {
+	for _, record := range records {
+		if record.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// caaIssuerAllowed checks if the issuer domain of the value of an issue or issuewild property,
+// ignoring its parameters, is one of the identities. An empty issuer allows no CA.
+func caaIssuerAllowed(value string, identities []string) bool {
+	issuer := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+	if issuer == "" {
+		return false
+	}
+
+	for _, identity := range identities {
+		if strings.EqualFold(issuer, identity) {
+			return true
+		}
+	}
+	return false
+}
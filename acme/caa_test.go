@@ -0,0 +1,163 @@
+package acme
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// runStubCAAServer starts a resolver answering the CAA queries with the records of the zone,
+// the other names have no CAA records.
+func runStubCAAServer(t *testing.T, zone string) func() {
+	records := make(map[string][]dns.RR)
+	for _, line := range strings.Split(strings.TrimSpace(zone), "\n") {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("Could not parse the record %q: %v", line, err)
+		}
+		records[rr.Header().Name] = append(records[rr.Header().Name], rr)
+	}
+
+	addr, shutdown := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		if q.Name == "servfail.org." {
+			m.Rcode = dns.RcodeServerFailure
+		} else if q.Qtype == dns.TypeCAA {
+			m.Answer = records[q.Name]
+		}
+
+		w.WriteMsg(m)
+	})
+
+	saved := RecursiveNameservers
+	RecursiveNameservers = []string{addr}
+
+	return func() {
+		RecursiveNameservers = saved
+		shutdown()
+	}
+}
+
+const testCAAZone = `
+example.com.      60 IN CAA 0 issue "ca.example.net"
+example.com.      60 IN CAA 0 iodef "mailto:security@example.com"
+blocked.org.      60 IN CAA 0 issue "other-ca.example; account=1234"
+wild.org.         60 IN CAA 0 issue "ca.example.net"
+wild.org.         60 IN CAA 0 issuewild ";"
+noissuewild.org.  60 IN CAA 0 issue "ca.example.net; validationmethods=dns-01"
+critical.org.     60 IN CAA 128 tbs "unknown"
+noncritical.org.  60 IN CAA 0 tbs "unknown"
+`
+
+func TestCheckCAA(t *testing.T) {
+	defer runStubCAAServer(t, testCAAZone)()
+
+	identities := []string{"ca.example.net"}
+
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "example.com"},
+		// the CAA records of the parent apply, per the tree climbing.
+		{domain: "www.sub.example.com"},
+		{domain: "*.example.com"},
+		{domain: "free.org"},
+		{domain: "192.0.2.1"},
+		{domain: "noissuewild.org"},
+		// issue applies to the wildcards without issuewild records.
+		{domain: "*.noissuewild.org"},
+		{domain: "noncritical.org"},
+		{domain: "wild.org"},
+		{
+			domain:   "*.wild.org",
+			expected: "acme: the CAA issuewild records of wild.org do not allow the CA (ca.example.net) to issue a certificate",
+		},
+		{
+			domain:   "www.blocked.org",
+			expected: "acme: the CAA issue records of blocked.org do not allow the CA (ca.example.net) to issue a certificate",
+		},
+		{
+			domain:   "critical.org",
+			expected: `acme: the CAA records of critical.org have the unknown critical property "tbs", no CA may issue a certificate`,
+		},
+		{
+			domain:   "servfail.org",
+			expected: "acme: could not look up the CAA records of servfail.org.: SERVFAIL",
+		},
+	}
+
+	for _, test := range testCases {
+		err := checkCAA(test.domain, identities)
+		if test.expected == "" && err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", test.domain, err)
+		}
+		if test.expected != "" && (err == nil || err.Error() != test.expected) {
+			t.Errorf("Expected %q for %s, got %v", test.expected, test.domain, err)
+		}
+	}
+}
+
+func TestClientCheckCAA(t *testing.T) {
+	defer runStubCAAServer(t, testCAAZone)()
+
+	client := &Client{}
+
+	// the CA has no CAA identities: nothing can be checked.
+	if err := client.CheckCAA([]string{"blocked.org"}); err != nil {
+		t.Errorf("Expected no check without CAA identities, got %v", err)
+	}
+
+	client.directory.Meta.CaaIdentities = []string{"ca.example.net"}
+
+	err := client.CheckCAA([]string{"example.com", "blocked.org", "*.wild.org"})
+	failures, ok := err.(ObtainError)
+	if !ok || len(failures) != 2 || failures["blocked.org"] == nil || failures["*.wild.org"] == nil {
+		t.Errorf("Expected blocked.org and *.wild.org to be blocked, got %v", err)
+	}
+}
+
+func TestPreCheckCAA(t *testing.T) {
+	defer runStubCAAServer(t, testCAAZone)()
+
+	client := &Client{}
+	client.directory.Meta.CaaIdentities = []string{"ca.example.net"}
+
+	domains := []string{"example.com", "blocked.org"}
+
+	// the check is off by default.
+	if err := client.preCheckCAA(domains); err != nil {
+		t.Errorf("Expected no check by default, got %v", err)
+	}
+
+	if err := client.SetCAACheck(CAACheckAdvisory); err != nil {
+		t.Fatalf("Could not set the CAA check: %v", err)
+	}
+	if err := client.preCheckCAA(domains); err != nil {
+		t.Errorf("Expected the advisory check to only warn, got %v", err)
+	}
+
+	if err := client.SetCAACheck(CAACheckStrict); err != nil {
+		t.Fatalf("Could not set the CAA check: %v", err)
+	}
+	if err := client.preCheckCAA(domains); err == nil {
+		t.Error("Expected the strict check to fail")
+	}
+
+	// no order is created when the strict check fails.
+	if _, err := client.createOrderForIdentifiers(context.Background(), domains); err == nil {
+		t.Error("Expected the order not to be created")
+	}
+
+	if err := client.SetCAACheck("warn"); err == nil {
+		t.Error("Expected an error for an unsupported mode")
+	}
+	if client.caaCheck != CAACheckStrict {
+		t.Errorf("Expected the mode to be unchanged, got %s", client.caaCheck)
+	}
+}
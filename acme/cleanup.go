@@ -0,0 +1,59 @@
+package acme
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/xenolf/lego/log"
+)
+
+// disableCleanupEnvVar is the environment variable name that can be used to
+// keep the records of the failed challenges in place, for debugging.
+const disableCleanupEnvVar = "LEGO_DISABLE_CLEANUP"
+
+// SetKeepFailedChallenges keeps (or not) the records presented for the challenges which failed,
+// e.g. the TXT record of a dns-01 challenge, instead of cleaning them up, so that they can be inspected.
+// They must then be removed manually, as logged. The records can also be kept with LEGO_DISABLE_CLEANUP=1.
+func (c *Client) SetKeepFailedChallenges(keep bool) {
+	c.keepFailedChallenges = keep
+
+	for _, s := range c.solvers {
+		setKeepOnFailure(s, keep)
+	}
+
+	c.selectedSolversMu.Lock()
+	for _, selected := range c.selectedSolvers {
+		setKeepOnFailure(selected.solver, keep)
+	}
+	c.selectedSolversMu.Unlock()
+}
+
+func setKeepOnFailure(s solver, keep bool) {
+	switch chlng := s.(type) {
+	case *httpChallenge:
+		chlng.keepOnFailure = keep
+	case *dnsChallenge:
+		chlng.keepOnFailure = keep
+	case *tlsALPNChallenge:
+		chlng.keepOnFailure = keep
+	}
+}
+
+// skipCleanUp reports whether the record presented for a challenge is kept in place because the challenge failed,
+// either by the solver setting or by the LEGO_DISABLE_CLEANUP environment variable.
+// The record to remove manually is then logged.
+func skipCleanUp(keepOnFailure bool, failure error, domain, record string) bool {
+	if failure == nil {
+		return false
+	}
+
+	if !keepOnFailure {
+		keepOnFailure, _ = strconv.ParseBool(os.Getenv(disableCleanupEnvVar))
+		if !keepOnFailure {
+			return false
+		}
+	}
+
+	log.Warnf("[%s] acme: The challenge failed, its cleanup is SKIPPED for debugging: %s must be removed manually", domain, record)
+	return true
+}
@@ -0,0 +1,115 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func failingValidate(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+	return errors.New("invalid response")
+}
+
+func TestSetKeepFailedChallenges(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &mockTimeoutProvider{}
+
+	client := &Client{jws: &jws{privKey: privKey}, solvers: map[Challenge]solver{
+		DNS01:  &dnsChallenge{jws: &jws{privKey: privKey}, provider: provider, validate: failingValidate, disableCP: true},
+		HTTP01: &httpChallenge{jws: &jws{privKey: privKey}, provider: provider, validate: failingValidate},
+	}}
+	client.SetKeepFailedChallenges(true)
+
+	err := client.solvers[DNS01].Solve(context.Background(), challenge{Type: string(DNS01), Token: "token"}, "example.com")
+	if err == nil {
+		t.Fatal("Expected the validation to fail")
+	}
+	if !provider.presented || provider.cleanedUp {
+		t.Error("Expected the record of the failed challenge to be presented and not cleaned up")
+	}
+
+	provider.presented = false
+	err = client.solvers[HTTP01].Solve(context.Background(), challenge{Type: string(HTTP01), Token: "token"}, "example.com")
+	if err == nil {
+		t.Fatal("Expected the validation to fail")
+	}
+	if !provider.presented || provider.cleanedUp {
+		t.Error("Expected the token of the failed challenge to be presented and not cleaned up")
+	}
+
+	// the new solvers keep the setting.
+	solver, err := client.newSolver(TLSALPN01, provider)
+	if err != nil {
+		t.Fatalf("Could not create the solver: %v", err)
+	}
+	if !solver.(*tlsALPNChallenge).keepOnFailure {
+		t.Error("Expected the new solver to keep the failed challenges")
+	}
+
+	// the records of the successful challenges are still cleaned up.
+	dnsSolver := client.solvers[DNS01].(*dnsChallenge)
+	dnsSolver.validate = stubValidate
+	if err = dnsSolver.Solve(context.Background(), challenge{Type: string(DNS01), Token: "token"}, "example.com"); err != nil {
+		t.Fatalf("Expected the challenge to be solved, got %v", err)
+	}
+	if !provider.cleanedUp {
+		t.Error("Expected the record of the successful challenge to be cleaned up")
+	}
+
+	client.SetKeepFailedChallenges(false)
+	provider.cleanedUp = false
+	dnsSolver.validate = failingValidate
+	dnsSolver.Solve(context.Background(), challenge{Type: string(DNS01), Token: "token"}, "example.com")
+	if !provider.cleanedUp {
+		t.Error("Expected the record of the failed challenge to be cleaned up by default")
+	}
+}
+
+func TestKeepFailedChallengesEnvVar(t *testing.T) {
+	os.Setenv(disableCleanupEnvVar, "1")
+	defer os.Unsetenv(disableCleanupEnvVar)
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &mockTimeoutProvider{}
+
+	solver := &tlsALPNChallenge{jws: &jws{privKey: privKey}, provider: provider, validate: failingValidate}
+
+	err := solver.Solve(context.Background(), challenge{Type: string(TLSALPN01), Token: "token"}, "example.com")
+	if err == nil {
+		t.Fatal("Expected the validation to fail")
+	}
+	if !provider.presented || provider.cleanedUp {
+		t.Errorf("Expected %s to keep the certificate of the failed challenge", disableCleanupEnvVar)
+	}
+}
+
+func TestKeepFailedChallengesGroups(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &mockFailingProvider{}
+
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, provider: provider, disableCP: true, keepOnFailure: true,
+		validate: func(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+			if domain == "fail.example.com" {
+				return errors.New("invalid response")
+			}
+			return nil
+		}}
+
+	groups := []*dnsChallengeGroup{
+		{domain: "example.com", chlngs: []challenge{{Type: string(DNS01), Token: "token1"}}},
+		{domain: "fail.example.com", chlngs: []challenge{{Type: string(DNS01), Token: "token2"}}},
+	}
+
+	failures, cleanUpFailures := solver.SolveGroups(context.Background(), groups)
+	if len(failures) != 1 || failures["fail.example.com"] == nil || len(cleanUpFailures) != 0 {
+		t.Fatalf("Expected only fail.example.com to fail, got %v and %v", failures, cleanUpFailures)
+	}
+
+	if !reflect.DeepEqual(provider.cleaned, []string{"example.com"}) {
+		t.Errorf("Expected only the record of example.com to be cleaned up, got %v", provider.cleaned)
+	}
+}
@@ -2,20 +2,29 @@
 package acme
 
 import (
+	"bytes"
+	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xenolf/lego/log"
+	"github.com/xenolf/lego/platform/wait"
 )
 
 const (
@@ -26,8 +35,25 @@ const (
 	// “new-reg”, “new-authz” and “new-cert” endpoints. From the documentation the
 	// limitation is 20 requests per second, but using 20 as value doesn't work but 18 do
 	overallRequestLimit = 18
+
+	// defaultOrderPollInterval and defaultOrderPollTimeout are used to poll the order
+	// until the certificate is issued, unless set with SetOrderPollInterval and SetOrderPollTimeout.
+	defaultOrderPollInterval = 500 * time.Millisecond
+	defaultOrderPollTimeout  = 30 * time.Second
 )
 
+const (
+	// LEDirectoryProduction is the URL of the Let's Encrypt production directory.
+	LEDirectoryProduction = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// LEDirectoryStaging is the URL of the Let's Encrypt staging directory.
+	// Its rate limits are far higher than the production ones, but its certificates are not publicly trusted.
+	LEDirectoryStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// stagingWarning makes the staging warning emitted only once per process.
+var stagingWarning sync.Once
+
 // User interface is to be implemented by users of this library.
 // It is used by the client type to get user specific information.
 type User interface {
@@ -38,43 +64,118 @@ type User interface {
 
 // Interface for all challenge solvers to implement.
 type solver interface {
-	Solve(challenge challenge, domain string) error
+	Solve(ctx context.Context, challenge challenge, domain string) error
 }
 
-type validateFunc func(j *jws, domain, uri string, chlng challenge) error
+type validateFunc func(ctx context.Context, j *jws, domain, uri string, chlng challenge) error
+
+// Authorization describes an authorization to solve, for a ChallengeSelector.
+type Authorization struct {
+	// Identifier is the domain or IP address to validate, without the "*." of a wildcard.
+	Identifier string
+	Wildcard   bool
+	// Challenges are the types of the challenges offered by the CA.
+	Challenges []Challenge
+}
+
+// ChallengeSelector chooses the type of the challenge to solve for an authorization of the domain,
+// and its provider. A nil provider uses the one of the client for this type (see SetChallengeProvider).
+// An empty type uses the default selection.
+type ChallengeSelector func(domain string, authz Authorization) (Challenge, ChallengeProvider)
+
+// PreCSRHook modifies the CSR generated for an order before it is signed,
+// e.g. to add extensions. The names and IP addresses must still match the identifiers of the order.
+type PreCSRHook func(csr *x509.CertificateRequest) error
+
+// CertificateHook is called with each certificate obtained or renewed by the client,
+// e.g. to reload a server or to push the certificate to a secret store.
+type CertificateHook func(cert *CertificateResource) error
+
+// defaultChallengeOrder is the order of preference of the challenges without a ChallengeSelector.
+var defaultChallengeOrder = []Challenge{TLSALPN01, HTTP01, DNS01}
+
+// selectedSolver is a solver created for a provider returned by the ChallengeSelector.
+type selectedSolver struct {
+	challenge Challenge
+	provider  ChallengeProvider
+	solver    solver
+}
 
 // Client is the user-friendy way to ACME
 type Client struct {
-	directory directory
-	user      User
-	jws       *jws
-	keyType   KeyType
-	solvers   map[Challenge]solver
+	directory    directory
+	directoryURL string
+	user         User
+	jws          *jws
+	keyType      KeyType
+	solvers      map[Challenge]solver
+	disableCP    bool
+
+	preferredChain string
+	notBefore      time.Time
+	notAfter       time.Time
+
+	challengeSelector ChallengeSelector
+	selectedSolvers   []selectedSolver
+	selectedSolversMu sync.Mutex
+
+	batchConcurrency int
+	newOrderLimiter  *wait.Limiter
+
+	preCSRHook    PreCSRHook
+	onCertificate CertificateHook
+
+	caaCheck CAACheckMode
+
+	keepFailedChallenges bool
+
+	orderPollInterval time.Duration
+	orderPollTimeout  time.Duration
+
+	// clock returns the current time, it is only replaced by the tests.
+	clock func() time.Time
 }
 
 // NewClient creates a new ACME client on behalf of the user. The client will depend on
 // the ACME directory located at caDirURL for the rest of its actions.  A private
 // key of type keyType (see KeyType contants) will be generated when requesting a new
 // certificate if one isn't provided.
+// The account key of the user can be an RSA, ECDSA or Ed25519 (golang.org/x/crypto/ed25519) key,
+// the latter being only accepted by some CAs.
 func NewClient(caDirURL string, user User, keyType KeyType) (*Client, error) {
+	return newClient(caDirURL, user, keyType, nil)
+}
+
+// NewClientWithCertPool creates a new ACME client like NewClient, trusting the CA certificates
+// of certPool instead of the system ones for all the requests to the ACME server,
+// e.g. for an internal CA with a private root (see NewCertPool).
+func NewClientWithCertPool(caDirURL string, user User, keyType KeyType, certPool *x509.CertPool) (*Client, error) {
+	if certPool == nil {
+		return nil, errors.New("the cert pool was nil")
+	}
+
+	return newClient(caDirURL, user, keyType, newHTTPClientWithCertPool(certPool))
+}
+
+// newClient creates a new ACME client using httpClient, or HTTPClient if nil.
+func newClient(caDirURL string, user User, keyType KeyType, httpClient *http.Client) (*Client, error) {
+	if keyType == Ed25519 {
+		return nil, errors.New("acme: Ed25519 keys are only supported as account keys")
+	}
+
 	privKey := user.GetPrivateKey()
 	if privKey == nil {
 		return nil, errors.New("private key was nil")
 	}
 
-	var dir directory
-	if _, err := getJSON(caDirURL, &dir); err != nil {
-		return nil, fmt.Errorf("get directory at '%s': %v", caDirURL, err)
-	}
+	jws := &jws{privKey: privKey, client: httpClient}
 
-	if dir.NewAccountURL == "" {
-		return nil, errors.New("directory missing new registration URL")
-	}
-	if dir.NewOrderURL == "" {
-		return nil, errors.New("directory missing new order URL")
+	dir, err := getDirectory(jws.httpClient(), caDirURL)
+	if err != nil {
+		return nil, err
 	}
 
-	jws := &jws{privKey: privKey, getNonceURL: dir.NewNonceURL}
+	jws.getNonceURL = dir.NewNonceURL
 	if reg := user.GetRegistration(); reg != nil {
 		jws.kid = reg.URI
 	}
@@ -87,22 +188,218 @@ func NewClient(caDirURL string, user User, keyType KeyType) (*Client, error) {
 		TLSALPN01: &tlsALPNChallenge{jws: jws, validate: validate, provider: &TLSALPNProviderServer{}},
 	}
 
-	return &Client{directory: dir, user: user, jws: jws, keyType: keyType, solvers: solvers}, nil
+	if keyType == "" {
+		keyType = RSA2048
+	}
+
+	warnKeyTypeSupport(caDirURL, keyType)
+
+	if caDirURL == LEDirectoryStaging {
+		stagingWarning.Do(func() {
+			log.Warnf("acme: Using the Let's Encrypt staging directory, the certificates are not publicly trusted")
+		})
+	}
+
+	return &Client{directory: dir, directoryURL: caDirURL, user: user, jws: jws, keyType: keyType, solvers: solvers, clock: time.Now}, nil
+}
+
+// warnKeyTypeSupport warns if the CA of the directory is known to reject the certificates of the key type.
+func warnKeyTypeSupport(caDirURL string, keyType KeyType) {
+	if keyType == EC521 && (caDirURL == LEDirectoryProduction || caDirURL == LEDirectoryStaging) {
+		log.Warnf("acme: Let's Encrypt does not issue certificates for P-521 keys, the orders will be rejected with the key type %s", keyType)
+	}
+}
+
+// getDirectory fetches and checks the ACME directory located at caDirURL.
+func getDirectory(client *http.Client, caDirURL string) (directory, error) {
+	var dir directory
+	if _, err := getJSON(context.Background(), client, caDirURL, &dir); err != nil {
+		return dir, fmt.Errorf("get directory at '%s': %v", caDirURL, err)
+	}
+
+	if dir.NewAccountURL == "" {
+		return dir, errors.New("directory missing new registration URL")
+	}
+	if dir.NewOrderURL == "" {
+		return dir, errors.New("directory missing new order URL")
+	}
+
+	return dir, nil
+}
+
+// SetPreferredChain sets the chain to download when the CA offers alternate chains:
+// the first chain having an intermediate or root with this common name (e.g. "ISRG Root X1")
+// or authority key identifier (hex encoded) is used, the default chain otherwise.
+func (c *Client) SetPreferredChain(preferred string) {
+	c.preferredChain = preferred
+}
+
+// SetRequestedValidity sets the validity window requested in the next orders (RFC 8555 notBefore and notAfter).
+// A zero time is not requested. The CA may ignore the requested window, as Let's Encrypt does.
+func (c *Client) SetRequestedValidity(notBefore, notAfter time.Time) error {
+	if !notBefore.IsZero() && !notAfter.IsZero() && !notAfter.After(notBefore) {
+		return fmt.Errorf("the requested notAfter %s is not after notBefore %s", notAfter.Format(time.RFC3339), notBefore.Format(time.RFC3339))
+	}
+
+	c.notBefore = notBefore
+	c.notAfter = notAfter
+	return nil
+}
+
+// Staging reports whether the client uses the Let's Encrypt staging directory.
+func (c *Client) Staging() bool {
+	return c.directoryURL == LEDirectoryStaging
+}
+
+// RefreshDirectory fetches the ACME directory again, e.g. after a CA changed its endpoints.
+// The directory is otherwise fetched only once, by NewClient, and reused by all the operations
+// of the client, as are the nonces returned by the server.
+func (c *Client) RefreshDirectory() error {
+	dir, err := getDirectory(c.jws.httpClient(), c.directoryURL)
+	if err != nil {
+		return err
+	}
+
+	c.directory = dir
+	c.jws.getNonceURL = dir.NewNonceURL
+
+	return nil
+}
+
+// now returns the current time according to the clock of the client.
+func (c *Client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock()
+}
+
+// SetHTTPClient sets the HTTP client used for all the subsequent requests to the ACME server
+// (nonces, account, orders, authorizations, challenge validations and certificates),
+// e.g. to use a custom transport for a proxy, mTLS to the CA or request tracing.
+// The directory is fetched by NewClient with the package HTTPClient,
+// RefreshDirectory can be used to fetch it again with the custom client.
+// The User-Agent is still set on every request, and the timeout of HTTPClient is used
+// if the given client has none.
+func (c *Client) SetHTTPClient(client *http.Client) {
+	if client != nil && client.Timeout == 0 && HTTPClient.Timeout != 0 {
+		withTimeout := *client
+		withTimeout.Timeout = HTTPClient.Timeout
+		client = &withTimeout
+	}
+
+	c.jws.client = client
+}
+
+// SetKeyType changes the type of the private keys generated for the next certificate requests,
+// e.g. to request an ECDSA P-384 certificate while keeping an RSA account key.
+func (c *Client) SetKeyType(keyType KeyType) error {
+	switch keyType {
+	case EC256, EC384, EC521, RSA2048, RSA4096, RSA8192:
+		warnKeyTypeSupport(c.directoryURL, keyType)
+		c.keyType = keyType
+		return nil
+	default:
+		return fmt.Errorf("invalid KeyType: %s", keyType)
+	}
 }
 
 // SetChallengeProvider specifies a custom provider p that can solve the given challenge type.
 func (c *Client) SetChallengeProvider(challenge Challenge, p ChallengeProvider) error {
+	solver, err := c.newSolver(challenge, p)
+	if err != nil {
+		return err
+	}
+
+	c.solvers[challenge] = solver
+	return nil
+}
+
+// SetBatchConcurrency sets the number of requests of ObtainBatch processed at the same time, 1 by default.
+// The challenge providers must then support solving several challenges concurrently, which is not
+// the case of the built-in http-01 and tls-alpn-01 servers listening on a single port.
+func (c *Client) SetBatchConcurrency(concurrency int) {
+	c.batchConcurrency = concurrency
+}
+
+// SetNewOrderRateLimit paces the creation of the orders to ordersPerSecond, with bursts of up to burst
+// orders, to stay below the rate limit of the CA (e.g. 300 new orders per account per 3 hours
+// for Let's Encrypt). A rate of 0 removes the limit, which is the default.
+func (c *Client) SetNewOrderRateLimit(ordersPerSecond float64, burst int) {
+	c.newOrderLimiter = wait.NewLimiter(ordersPerSecond, burst)
+}
+
+// SetOrderPollInterval sets the interval between the polls of the order after its finalization,
+// until the certificate is issued. The Retry-After header sent by the CA takes precedence.
+// A zero interval restores the default of 500ms.
+func (c *Client) SetOrderPollInterval(interval time.Duration) {
+	c.orderPollInterval = interval
+}
+
+// SetOrderPollTimeout sets how long to poll the order after its finalization before failing
+// with an OrderTimeoutError. A zero timeout restores the default of 30s.
+func (c *Client) SetOrderPollTimeout(timeout time.Duration) {
+	c.orderPollTimeout = timeout
+}
+
+// SetChallengeSelector sets a function choosing the challenge, and optionally its provider,
+// for each authorization, e.g. to solve the wildcards with dns-01 and the other domains with http-01.
+// Without selector, or if it returns no challenge, the first challenge offered by the CA
+// in the order tls-alpn-01, http-01, dns-01 having a provider is used.
+func (c *Client) SetChallengeSelector(selector ChallengeSelector) {
+	c.challengeSelector = selector
+}
+
+// SetPreCSRHook sets a hook modifying the CSRs generated by ObtainCertificate and RenewCertificate
+// before they are signed. The CSRs passed to ObtainCertificateForCSR are not modified.
+func (c *Client) SetPreCSRHook(hook PreCSRHook) {
+	c.preCSRHook = hook
+}
+
+// SetOnCertificate sets a hook called synchronously, once, with each certificate obtained or renewed.
+// If the hook fails, the certificate is still returned, with a CertificateHookError: it was issued
+// and must be saved by the caller.
+func (c *Client) SetOnCertificate(hook CertificateHook) {
+	c.onCertificate = hook
+}
+
+func (c *Client) newSolver(challenge Challenge, p ChallengeProvider) (solver, error) {
 	switch challenge {
 	case HTTP01:
-		c.solvers[challenge] = &httpChallenge{jws: c.jws, validate: validate, provider: p}
+		return &httpChallenge{jws: c.jws, validate: validate, provider: p, keepOnFailure: c.keepFailedChallenges}, nil
 	case DNS01:
-		c.solvers[challenge] = &dnsChallenge{jws: c.jws, validate: validate, provider: p}
+		return &dnsChallenge{jws: c.jws, validate: validate, provider: p, disableCP: c.disableCP, keepOnFailure: c.keepFailedChallenges}, nil
 	case TLSALPN01:
-		c.solvers[challenge] = &tlsALPNChallenge{jws: c.jws, validate: validate, provider: p}
+		return &tlsALPNChallenge{jws: c.jws, validate: validate, provider: p, keepOnFailure: c.keepFailedChallenges}, nil
 	default:
-		return fmt.Errorf("unknown challenge %v", challenge)
+		return nil, fmt.Errorf("unknown challenge %v", challenge)
 	}
-	return nil
+}
+
+// selectedSolver returns the solver of a provider returned by the ChallengeSelector.
+// The solvers are reused for the same provider, so its dns-01 challenges are solved together.
+func (c *Client) selectedSolver(challenge Challenge, p ChallengeProvider) (solver, error) {
+	c.selectedSolversMu.Lock()
+	defer c.selectedSolversMu.Unlock()
+
+	comparable := reflect.TypeOf(p).Comparable()
+	if comparable {
+		for _, selected := range c.selectedSolvers {
+			if selected.challenge == challenge && reflect.TypeOf(selected.provider) == reflect.TypeOf(p) && selected.provider == p {
+				return selected.solver, nil
+			}
+		}
+	}
+
+	solver, err := c.newSolver(challenge, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if comparable {
+		c.selectedSolvers = append(c.selectedSolvers, selectedSolver{challenge: challenge, provider: p, solver: solver})
+	}
+	return solver, nil
 }
 
 // SetHTTPAddress specifies a custom interface:port to be used for HTTP based challenges.
@@ -124,6 +421,24 @@ func (c *Client) SetHTTPAddress(iface string) error {
 	return nil
 }
 
+// SetHTTPProxyHeader specifies the header holding the original host of the HTTP-01 validation
+// requests, for the default HTTP challenge provider running behind a reverse proxy.
+// See HTTPProviderServer.SetProxyHeader.
+func (c *Client) SetHTTPProxyHeader(headerName string) error {
+	chlng, ok := c.solvers[HTTP01]
+	if !ok {
+		return errors.New("the HTTP-01 challenge is excluded")
+	}
+
+	server, ok := chlng.(*httpChallenge).provider.(*HTTPProviderServer)
+	if !ok {
+		return errors.New("the proxy header can only be set on the default HTTP challenge provider")
+	}
+
+	server.SetProxyHeader(headerName)
+	return nil
+}
+
 // SetTLSAddress specifies a custom interface:port to be used for TLS based challenges.
 // If this option is not used, the default port 443 and all interfaces will be used.
 // To only specify a port and no interface use the ":port" notation.
@@ -142,6 +457,25 @@ func (c *Client) SetTLSAddress(iface string) error {
 	return nil
 }
 
+// SetDNSPropagationCheck enables or disables the DNS propagation pre-check of the DNS-01 challenge.
+// When disabled, the providers' timeouts are ignored and the ACME server is notified as soon as
+// the record is presented. The check can also be disabled with LEGO_DISABLE_CP=1.
+func (c *Client) SetDNSPropagationCheck(enabled bool) {
+	c.disableCP = !enabled
+
+	if chlng, ok := c.solvers[DNS01].(*dnsChallenge); ok {
+		chlng.SetDisableCP(c.disableCP)
+	}
+
+	c.selectedSolversMu.Lock()
+	for _, selected := range c.selectedSolvers {
+		if chlng, ok := selected.solver.(*dnsChallenge); ok {
+			chlng.SetDisableCP(c.disableCP)
+		}
+	}
+	c.selectedSolversMu.Unlock()
+}
+
 // ExcludeChallenges explicitly removes challenges from the pool for solving.
 func (c *Client) ExcludeChallenges(challenges []Challenge) {
 	// Loop through all challenges and delete the requested one if found.
@@ -150,6 +484,53 @@ func (c *Client) ExcludeChallenges(challenges []Challenge) {
 	}
 }
 
+// Preflight checks that the challenge of the given type can be solved for the domains, to fail
+// before ordering a certificate. No order is created and nothing is presented: the challenge must
+// be enabled and suitable for the domains (wildcards require dns-01, IP addresses can't use it),
+// and its provider checks each domain if it implements ProviderPreflight, e.g. the credentials
+// and the zone of a DNS provider or the port of the built-in servers.
+// The problems of all the domains are returned together in an ObtainError.
+func (c *Client) Preflight(domains []string, challengeType string) error {
+	challenge := Challenge(challengeType)
+
+	solver, ok := c.solvers[challenge]
+	if !ok {
+		return fmt.Errorf("acme: the %s challenge is not enabled", challengeType)
+	}
+
+	var provider ChallengeProvider
+	switch s := solver.(type) {
+	case *httpChallenge:
+		provider = s.provider
+	case *dnsChallenge:
+		provider = s.provider
+	case *tlsALPNChallenge:
+		provider = s.provider
+	}
+	preflight, _ := provider.(ProviderPreflight)
+
+	failures := make(ObtainError)
+	for _, domain := range domains {
+		name := strings.TrimPrefix(domain, "*.")
+
+		switch {
+		case name != domain && challenge != DNS01:
+			failures[domain] = fmt.Errorf("acme: wildcard domains can only be validated with %s", DNS01)
+		case net.ParseIP(name) != nil && challenge == DNS01:
+			failures[domain] = fmt.Errorf("acme: IP addresses can only be validated with %s or %s", HTTP01, TLSALPN01)
+		case preflight != nil:
+			if err := preflight.Preflight(name); err != nil {
+				failures[domain] = err
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
 // GetToSURL returns the current ToS URL from the Directory
 func (c *Client) GetToSURL() string {
 	return c.directory.Meta.TermsOfService
@@ -160,11 +541,30 @@ func (c *Client) GetExternalAccountRequired() bool {
 	return c.directory.Meta.ExternalAccountRequired
 }
 
+// RegisterOptions are the options of the registration of an account.
+type RegisterOptions struct {
+	// TermsOfServiceAgreed is true if the user agreed to the terms of service of the CA.
+	TermsOfServiceAgreed bool
+}
+
 // Register the current account to the ACME server.
+// It is equivalent to RegisterWithOptions with the given agreement to the terms of service.
 func (c *Client) Register(tosAgreed bool) (*RegistrationResource, error) {
+	return c.RegisterWithOptions(RegisterOptions{TermsOfServiceAgreed: tosAgreed})
+}
+
+// RegisterWithOptions registers the current account to the ACME server.
+// The terms of service of the CA are never agreed on behalf of the user: if the directory
+// has terms of service and options.TermsOfServiceAgreed is false, nothing is sent and
+// ErrTermsOfServiceRequired is returned with their URL. The caller must then register
+// again with the agreement of the user.
+func (c *Client) RegisterWithOptions(options RegisterOptions) (*RegistrationResource, error) {
 	if c == nil || c.user == nil {
 		return nil, errors.New("acme: cannot register a nil client or user")
 	}
+	if err := c.checkTermsOfService(options.TermsOfServiceAgreed); err != nil {
+		return nil, err
+	}
 	log.Infof("acme: Registering account for %s", c.user.GetEmail())
 
 	accMsg := accountMessage{}
@@ -173,10 +573,10 @@ func (c *Client) Register(tosAgreed bool) (*RegistrationResource, error) {
 	} else {
 		accMsg.Contact = []string{}
 	}
-	accMsg.TermsOfServiceAgreed = tosAgreed
+	accMsg.TermsOfServiceAgreed = options.TermsOfServiceAgreed
 
 	var serverReg accountMessage
-	hdr, err := postJSON(c.jws, c.directory.NewAccountURL, accMsg, &serverReg)
+	hdr, err := postJSON(context.Background(), c.jws, c.directory.NewAccountURL, accMsg, &serverReg)
 	if err != nil {
 		remoteErr, ok := err.(RemoteError)
 		if ok && remoteErr.StatusCode == 409 {
@@ -195,10 +595,15 @@ func (c *Client) Register(tosAgreed bool) (*RegistrationResource, error) {
 }
 
 // RegisterWithExternalAccountBinding Register the current account to the ACME server.
+// As with RegisterWithOptions, ErrTermsOfServiceRequired is returned if tosAgreed is false
+// and the CA has terms of service.
 func (c *Client) RegisterWithExternalAccountBinding(tosAgreed bool, kid string, hmacEncoded string) (*RegistrationResource, error) {
 	if c == nil || c.user == nil {
 		return nil, errors.New("acme: cannot register a nil client or user")
 	}
+	if err := c.checkTermsOfService(tosAgreed); err != nil {
+		return nil, err
+	}
 	log.Infof("acme: Registering account (EAB) for %s", c.user.GetEmail())
 
 	accMsg := accountMessage{}
@@ -209,9 +614,13 @@ func (c *Client) RegisterWithExternalAccountBinding(tosAgreed bool, kid string,
 	}
 	accMsg.TermsOfServiceAgreed = tosAgreed
 
-	hmac, err := base64.RawURLEncoding.DecodeString(hmacEncoded)
+	if kid == "" {
+		return nil, errors.New("acme: the external account binding key ID is required")
+	}
+
+	hmac, err := decodeEABHMAC(hmacEncoded)
 	if err != nil {
-		return nil, fmt.Errorf("acme: could not decode hmac key: %s", err.Error())
+		return nil, err
 	}
 
 	eabJWS, err := c.jws.signEABContent(c.directory.NewAccountURL, kid, hmac)
@@ -224,13 +633,15 @@ func (c *Client) RegisterWithExternalAccountBinding(tosAgreed bool, kid string,
 	accMsg.ExternalAccountBinding = []byte(eabPayload)
 
 	var serverReg accountMessage
-	hdr, err := postJSON(c.jws, c.directory.NewAccountURL, accMsg, &serverReg)
+	hdr, err := postJSON(context.Background(), c.jws, c.directory.NewAccountURL, accMsg, &serverReg)
 	if err != nil {
 		remoteErr, ok := err.(RemoteError)
-		if ok && remoteErr.StatusCode == 409 {
-		} else {
+		if !ok {
 			return nil, err
 		}
+		if remoteErr.StatusCode != http.StatusConflict {
+			return nil, fmt.Errorf("acme: the CA rejected the external account binding of key ID %q: %v", kid, remoteErr)
+		}
 	}
 
 	reg := &RegistrationResource{
@@ -242,14 +653,45 @@ func (c *Client) RegisterWithExternalAccountBinding(tosAgreed bool, kid string,
 	return reg, nil
 }
 
+// checkTermsOfService returns ErrTermsOfServiceRequired if the CA has terms of service
+// and the user did not agree to them.
+func (c *Client) checkTermsOfService(agreed bool) error {
+	if !agreed && c.directory.Meta.TermsOfService != "" {
+		return ErrTermsOfServiceRequired{URL: c.directory.Meta.TermsOfService}
+	}
+	return nil
+}
+
+// decodeEABHMAC decodes the base64url encoded HMAC key of an external account binding.
+// Trailing padding is tolerated as some CAs display the key padded.
+func decodeEABHMAC(hmacEncoded string) ([]byte, error) {
+	hmac, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmacEncoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("acme: the external account binding HMAC key is not valid base64url: %s", err.Error())
+	}
+	if len(hmac) == 0 {
+		return nil, errors.New("acme: the external account binding HMAC key is required")
+	}
+	return hmac, nil
+}
+
 // ResolveAccountByKey will attempt to look up an account using the given account key
 // and return its registration resource.
+// The lookup sends onlyReturnExisting to the new-account endpoint: it never creates an account
+// nor requires to agree to the terms of service again. On success the client is bound to the
+// account, if the server has no account for the key ErrNoExistingAccount is returned.
 func (c *Client) ResolveAccountByKey() (*RegistrationResource, error) {
+	if c == nil || c.user == nil {
+		return nil, errors.New("acme: cannot resolve the account of a nil client or user")
+	}
 	log.Infof("acme: Trying to resolve account by key")
 
 	acc := accountMessage{OnlyReturnExisting: true}
-	hdr, err := postJSON(c.jws, c.directory.NewAccountURL, acc, nil)
+	hdr, err := postJSON(context.Background(), c.jws, c.directory.NewAccountURL, acc, nil)
 	if err != nil {
+		if remoteErr, ok := err.(RemoteError); ok && remoteErr.Type == accountDoesNotExistError {
+			return nil, ErrNoExistingAccount
+		}
 		return nil, err
 	}
 
@@ -260,7 +702,7 @@ func (c *Client) ResolveAccountByKey() (*RegistrationResource, error) {
 
 	var retAccount accountMessage
 	c.jws.kid = accountLink
-	_, err = postJSON(c.jws, accountLink, accountMessage{}, &retAccount)
+	_, err = postJSON(context.Background(), c.jws, accountLink, accountMessage{}, &retAccount)
 	if err != nil {
 		return nil, err
 	}
@@ -268,6 +710,44 @@ func (c *Client) ResolveAccountByKey() (*RegistrationResource, error) {
 	return &RegistrationResource{URI: accountLink, Body: retAccount}, nil
 }
 
+// RolloverAccountKey replaces the key of the current account by newKey using the key-change
+// endpoint of the ACME server. On success the client signs all subsequent requests with
+// newKey, the caller is responsible for persisting it in place of the old account key.
+// If newKey is already used by another account, a KeyInUseError is returned.
+func (c *Client) RolloverAccountKey(newKey crypto.PrivateKey) error {
+	if c == nil || c.user == nil {
+		return errors.New("acme: cannot rollover the key of a nil client or user")
+	}
+	if c.jws.kid == "" {
+		return errors.New("acme: cannot rollover the key of an unregistered account")
+	}
+	if c.directory.KeyChangeURL == "" {
+		return errors.New("acme: the server does not support key rollover")
+	}
+	if newKey == nil {
+		return errors.New("acme: the new account key was nil")
+	}
+	log.Infof("acme: Rolling over the key of account %s", c.jws.kid)
+
+	innerJWS, err := c.jws.signKeyChangeContent(c.directory.KeyChangeURL, newKey)
+	if err != nil {
+		return err
+	}
+
+	hdr, err := postJSON(context.Background(), c.jws, c.directory.KeyChangeURL, json.RawMessage(innerJWS.FullSerialize()), nil)
+	if err != nil {
+		remoteErr, ok := err.(RemoteError)
+		if ok && remoteErr.StatusCode == http.StatusConflict {
+			return KeyInUseError{RemoteError: remoteErr, AccountURL: hdr.Get("Location")}
+		}
+		return err
+	}
+
+	c.jws.privKey = newKey
+
+	return nil
+}
+
 // DeleteRegistration deletes the client's user registration from the ACME
 // server.
 func (c *Client) DeleteRegistration() error {
@@ -280,7 +760,7 @@ func (c *Client) DeleteRegistration() error {
 		Status: "deactivated",
 	}
 
-	_, err := postJSON(c.jws, c.user.GetRegistration().URI, accMsg, nil)
+	_, err := postJSON(context.Background(), c.jws, c.user.GetRegistration().URI, accMsg, nil)
 	return err
 }
 
@@ -299,7 +779,7 @@ func (c *Client) QueryRegistration() (*RegistrationResource, error) {
 	accMsg := accountMessage{}
 
 	var serverReg accountMessage
-	_, err := postJSON(c.jws, c.user.GetRegistration().URI, accMsg, &serverReg)
+	_, err := postJSON(context.Background(), c.jws, c.user.GetRegistration().URI, accMsg, &serverReg)
 	if err != nil {
 		return nil, err
 	}
@@ -314,29 +794,28 @@ func (c *Client) QueryRegistration() (*RegistrationResource, error) {
 }
 
 // ObtainCertificateForCSR tries to obtain a certificate matching the CSR passed into it.
-// The domains are inferred from the CommonName and SubjectAltNames, if any. The private key
-// for this CSR is not required.
+// The domains are inferred from the CommonName and the DNS and IP SubjectAltNames, if any.
+// The private key for this CSR is not required, e.g. it can be kept in a HSM:
+// the returned CertificateResource has no private key.
 // If bundle is true, the []byte contains both the issuer certificate and
 // your issued certificate as a bundle.
 // This function will never return a partial certificate. If one domain in the list fails,
 // the whole certificate will fail.
 func (c *Client) ObtainCertificateForCSR(csr x509.CertificateRequest, bundle bool) (*CertificateResource, error) {
-	// figure out what domains it concerns
-	// start with the common name
-	domains := []string{csr.Subject.CommonName}
-
-	// loop over the SubjectAltName DNS names
-DNSNames:
-	for _, sanName := range csr.DNSNames {
-		for _, existingName := range domains {
-			if existingName == sanName {
-				// duplicate; skip this name
-				continue DNSNames
-			}
-		}
+	return c.ObtainCertificateForCSRWithContext(context.Background(), csr, bundle)
+}
 
-		// name is unique
-		domains = append(domains, sanName)
+// ObtainCertificateForCSRWithContext is like ObtainCertificateForCSR, bounding the whole flow by the context.
+// The requests to the CA and the challenge polling are stopped when the context is done,
+// the presented challenges are cleaned up and the error of the context is returned.
+func (c *Client) ObtainCertificateForCSRWithContext(ctx context.Context, csr x509.CertificateRequest, bundle bool) (*CertificateResource, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("acme: invalid CSR signature: %v", err)
+	}
+
+	domains := csrIdentifiers(&csr)
+	if len(domains) == 0 {
+		return nil, errors.New("acme: the CSR contains no domain or IP address")
 	}
 
 	if bundle {
@@ -345,44 +824,49 @@ DNSNames:
 		log.Infof("[%s] acme: Obtaining SAN certificate given a CSR", strings.Join(domains, ", "))
 	}
 
-	order, err := c.createOrderForIdentifiers(domains)
+	order, err := c.createOrderForIdentifiers(ctx, domains)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
-	authz, err := c.getAuthzForOrder(order)
+	authz, err := c.getAuthzForOrder(ctx, order)
 	if err != nil {
 		// If any challenge fails, return. Do not generate partial SAN certificates.
 		/*for _, auth := range authz {
 			c.disableAuthz(auth)
 		}*/
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
-	err = c.solveChallengeForAuthz(authz)
+	err = c.solveChallengeForAuthz(ctx, authz)
 	if err != nil {
 		// If any challenge fails, return. Do not generate partial SAN certificates.
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
 	log.Infof("[%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
 
 	failures := make(ObtainError)
-	cert, err := c.requestCertificateForCsr(order, bundle, csr.Raw, nil)
+	cert, err := c.requestCertificateForCsr(ctx, order, bundle, csr.Raw, nil)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		for _, chln := range authz {
 			failures[chln.Identifier.Value] = err
 		}
 	}
 
 	// Add the CSR to the certificate so that it can be used for renewals.
-	cert.CSR = pemEncode(&csr)
+	if cert != nil {
+		cert.CSR = pemEncode(&csr)
+	}
 
 	// do not return an empty failures map, because
 	// it would still be a non-nil error value
 	if len(failures) > 0 {
 		return cert, failures
 	}
-	return cert, nil
+	return cert, c.runCertificateHook(cert)
 }
 
 // ObtainCertificate tries to obtain a single certificate using all domains passed into it.
@@ -395,6 +879,13 @@ DNSNames:
 // This function will never return a partial certificate. If one domain in the list fails,
 // the whole certificate will fail.
 func (c *Client) ObtainCertificate(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (*CertificateResource, error) {
+	return c.ObtainCertificateWithContext(context.Background(), domains, bundle, privKey, mustStaple)
+}
+
+// ObtainCertificateWithContext is like ObtainCertificate, bounding the whole flow by the context.
+// The requests to the CA and the challenge polling are stopped when the context is done,
+// the presented challenges are cleaned up and the error of the context is returned.
+func (c *Client) ObtainCertificateWithContext(ctx context.Context, domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (*CertificateResource, error) {
 	if len(domains) == 0 {
 		return nil, errors.New("No domains to obtain a certificate for")
 	}
@@ -405,30 +896,33 @@ func (c *Client) ObtainCertificate(domains []string, bundle bool, privKey crypto
 		log.Infof("[%s] acme: Obtaining SAN certificate", strings.Join(domains, ", "))
 	}
 
-	order, err := c.createOrderForIdentifiers(domains)
+	order, err := c.createOrderForIdentifiers(ctx, domains)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
-	authz, err := c.getAuthzForOrder(order)
+	authz, err := c.getAuthzForOrder(ctx, order)
 	if err != nil {
 		// If any challenge fails, return. Do not generate partial SAN certificates.
 		/*for _, auth := range authz {
 			c.disableAuthz(auth)
 		}*/
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
-	err = c.solveChallengeForAuthz(authz)
+	err = c.solveChallengeForAuthz(ctx, authz)
 	if err != nil {
 		// If any challenge fails, return. Do not generate partial SAN certificates.
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
 	log.Infof("[%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
 
 	failures := make(ObtainError)
-	cert, err := c.requestCertificateForOrder(order, bundle, privKey, mustStaple)
+	cert, err := c.requestCertificateForOrder(ctx, order, bundle, privKey, mustStaple)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		for _, auth := range authz {
 			failures[auth.Identifier.Value] = err
 		}
@@ -439,11 +933,36 @@ func (c *Client) ObtainCertificate(domains []string, bundle bool, privKey crypto
 	if len(failures) > 0 {
 		return cert, failures
 	}
-	return cert, nil
+	return cert, c.runCertificateHook(cert)
+}
+
+// runCertificateHook calls the hook set with SetOnCertificate with the issued certificate.
+func (c *Client) runCertificateHook(cert *CertificateResource) error {
+	if c.onCertificate == nil || cert == nil {
+		return nil
+	}
+
+	if err := c.onCertificate(cert); err != nil {
+		return &CertificateHookError{Domain: cert.Domain, Err: err}
+	}
+	return nil
 }
 
 // RevokeCertificate takes a PEM encoded certificate or bundle and tries to revoke it at the CA.
 func (c *Client) RevokeCertificate(certificate []byte) error {
+	return c.revokeCertificate(certificate, nil)
+}
+
+// RevokeCertificateWithReason takes a PEM encoded certificate or bundle and tries to revoke it
+// at the CA, giving the RFC 5280 reason code of the revocation (see the RevocationReason constants).
+func (c *Client) RevokeCertificateWithReason(certificate []byte, reason uint) error {
+	if !isValidRevocationReason(reason) {
+		return fmt.Errorf("invalid revocation reason code: %d", reason)
+	}
+	return c.revokeCertificate(certificate, &reason)
+}
+
+func (c *Client) revokeCertificate(certificate []byte, reason *uint) error {
 	certificates, err := parsePEMBundle(certificate)
 	if err != nil {
 		return err
@@ -456,10 +975,22 @@ func (c *Client) RevokeCertificate(certificate []byte) error {
 
 	encodedCert := base64.URLEncoding.EncodeToString(x509Cert.Raw)
 
-	_, err = postJSON(c.jws, c.directory.RevokeCertURL, revokeCertMessage{Certificate: encodedCert}, nil)
+	_, err = postJSON(context.Background(), c.jws, c.directory.RevokeCertURL, revokeCertMessage{Certificate: encodedCert, Reason: reason}, nil)
 	return err
 }
 
+// RenewOptions are the options of the renewal of a certificate.
+type RenewOptions struct {
+	// Bundle bundles the issuer certificate with the new certificate.
+	Bundle     bool
+	MustStaple bool
+	// ReusePrivateKey builds the CSR of the new certificate from the private key of the renewed one
+	// instead of a new key, e.g. to keep a pinned public key. The key is PrivateKey if set, or the
+	// PEM encoded PrivateKey of the CertificateResource otherwise, and must match the renewed certificate.
+	ReusePrivateKey bool
+	PrivateKey      crypto.PrivateKey
+}
+
 // RenewCertificate takes a CertificateResource and tries to renew the certificate.
 // If the renewal process succeeds, the new certificate will ge returned in a new CertResource.
 // Please be aware that this function will return a new certificate in ANY case that is not an error.
@@ -469,6 +1000,23 @@ func (c *Client) RevokeCertificate(certificate []byte) error {
 // your issued certificate as a bundle.
 // For private key reuse the PrivateKey property of the passed in CertificateResource should be non-nil.
 func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple bool) (*CertificateResource, error) {
+	return c.RenewCertificateWithContext(context.Background(), cert, bundle, mustStaple)
+}
+
+// RenewCertificateWithContext is like RenewCertificate, bounding the whole flow by the context.
+func (c *Client) RenewCertificateWithContext(ctx context.Context, cert CertificateResource, bundle, mustStaple bool) (*CertificateResource, error) {
+	options := RenewOptions{
+		Bundle:          bundle,
+		MustStaple:      mustStaple,
+		ReusePrivateKey: cert.PrivateKey != nil,
+	}
+	return c.RenewCertificateWithOptions(ctx, cert, options)
+}
+
+// RenewCertificateWithOptions is like RenewCertificateWithContext, the private key of the
+// renewed certificate being only reused if options.ReusePrivateKey is true.
+// The certificates renewed from a CSR always keep the key of the CSR.
+func (c *Client) RenewCertificateWithOptions(ctx context.Context, cert CertificateResource, options RenewOptions) (*CertificateResource, error) {
 	// Input certificate is PEM encoded. Decode it here as we may need the decoded
 	// cert later on in the renewal process. The input may be a bundle or a single certificate.
 	certificates, err := parsePEMBundle(cert.Certificate)
@@ -482,7 +1030,7 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 	}
 
 	// This is just meant to be informal for the user.
-	timeLeft := x509Cert.NotAfter.Sub(time.Now().UTC())
+	timeLeft := x509Cert.NotAfter.Sub(c.now())
 	log.Infof("[%s] acme: Trying renewal with %d hours remaining", cert.Domain, int(timeLeft.Hours()))
 
 	// We always need to request a new certificate to renew.
@@ -493,15 +1041,15 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 		if err != nil {
 			return nil, err
 		}
-		newCert, failures := c.ObtainCertificateForCSR(*csr, bundle)
+		newCert, failures := c.ObtainCertificateForCSRWithContext(ctx, *csr, options.Bundle)
 		return newCert, failures
 	}
 
 	var privKey crypto.PrivateKey
-	if cert.PrivateKey != nil {
-		privKey, err = parsePEMPrivateKey(cert.PrivateKey)
+	if options.ReusePrivateKey {
+		privKey, err = reusablePrivateKey(cert, x509Cert, options.PrivateKey)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("[%s] acme: Could not reuse the private key: %v", cert.Domain, err)
 		}
 	}
 
@@ -515,27 +1063,86 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 			}
 			domains = append(domains, sanDomain)
 		}
-	} else {
+	} else if x509Cert.Subject.CommonName != "" {
 		domains = append(domains, x509Cert.Subject.CommonName)
 	}
 
-	newCert, err := c.ObtainCertificate(domains, bundle, privKey, mustStaple)
+	for _, ip := range x509Cert.IPAddresses {
+		if ip.String() != x509Cert.Subject.CommonName {
+			domains = append(domains, ip.String())
+		}
+	}
+
+	newCert, err := c.ObtainCertificateWithContext(ctx, domains, options.Bundle, privKey, options.MustStaple)
 	return newCert, err
 }
 
-func (c *Client) createOrderForIdentifiers(domains []string) (orderResource, error) {
+// reusablePrivateKey returns the private key to reuse for the renewal of the certificate:
+// privKey if not nil, the key of the CertificateResource otherwise.
+// The key must match the public key of the certificate, so its type and size are kept.
+func reusablePrivateKey(cert CertificateResource, x509Cert *x509.Certificate, privKey crypto.PrivateKey) (crypto.PrivateKey, error) {
+	if privKey == nil {
+		if len(cert.PrivateKey) == 0 {
+			return nil, errors.New("no private key to reuse")
+		}
+
+		var err error
+		privKey, err = parsePEMPrivateKey(cert.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privKey)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	certPublic, err := x509.MarshalPKIXPublicKey(x509Cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(public, certPublic) {
+		return nil, errors.New("the private key does not match the certificate")
+	}
+
+	return privKey, nil
+}
+
+func (c *Client) createOrderForIdentifiers(ctx context.Context, domains []string) (orderResource, error) {
+	if err := c.preCheckCAA(domains); err != nil {
+		return orderResource{}, err
+	}
 
 	var identifiers []identifier
 	for _, domain := range domains {
-		identifiers = append(identifiers, identifier{Type: "dns", Value: domain})
+		ident, err := newIdentifier(domain)
+		if err != nil {
+			return orderResource{}, err
+		}
+		identifiers = append(identifiers, ident)
 	}
 
 	order := orderMessage{
 		Identifiers: identifiers,
 	}
+	if !c.notBefore.IsZero() {
+		order.NotBefore = c.notBefore.Format(time.RFC3339)
+	}
+	if !c.notAfter.IsZero() {
+		order.NotAfter = c.notAfter.Format(time.RFC3339)
+	}
+
+	if err := c.newOrderLimiter.WaitContext(ctx); err != nil {
+		return orderResource{}, err
+	}
 
 	var response orderMessage
-	hdr, err := postJSON(c.jws, c.directory.NewOrderURL, order, &response)
+	hdr, err := postJSON(ctx, c.jws, c.directory.NewOrderURL, order, &response)
 	if err != nil {
 		return orderResource{}, err
 	}
@@ -548,10 +1155,40 @@ func (c *Client) createOrderForIdentifiers(domains []string) (orderResource, err
 	return orderRes, nil
 }
 
+// newIdentifier returns the identifier of a domain: an "ip" identifier for an IPv4 or IPv6 address,
+// a "dns" identifier otherwise. Values mixing a hostname and an IP address
+// (e.g. a wildcard IP, an IP with a port or a zone) are rejected.
+func newIdentifier(domain string) (identifier, error) {
+	if ip := net.ParseIP(domain); ip != nil {
+		return identifier{Type: "ip", Value: ip.String()}, nil
+	}
+
+	name := strings.TrimPrefix(domain, "*.")
+	labels := strings.Split(name, ".")
+	if name == "" || strings.ContainsAny(name, ":[]%/") || net.ParseIP(name) != nil || isNumeric(labels[len(labels)-1]) {
+		return identifier{}, fmt.Errorf("acme: %q is neither a valid IP address nor a valid domain name", domain)
+	}
+
+	return identifier{Type: "dns", Value: domain}, nil
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
 // Looks through the challenge combinations to find a solvable match.
 // Then solves the challenges in series and returns.
-func (c *Client) solveChallengeForAuthz(authorizations []authorization) error {
+func (c *Client) solveChallengeForAuthz(ctx context.Context, authorizations []authorization) error {
 	failures := make(ObtainError)
+	cleanUpFailures := make(map[string]error)
+
+	// dns-01 challenges are solved at the end, all together, to check their propagation concurrently.
+	var dnsSolvers []*dnsChallenge
+	dnsDomains := make(map[*dnsChallenge][]string)
+	dnsChallenges := make(map[*dnsChallenge]map[string][]challenge)
+	// dnsNames are the names of the domains in the errors: the wildcard if it is requested without its base domain.
+	dnsNames := make(map[string]string)
 
 	// loop through the resources, basically through the domains.
 	for _, authz := range authorizations {
@@ -563,38 +1200,173 @@ func (c *Client) solveChallengeForAuthz(authorizations []authorization) error {
 
 		// no solvers - no solving
 		if i, solver := c.chooseSolver(authz, authz.Identifier.Value); solver != nil {
-			err := solver.Solve(authz.Challenges[i], authz.Identifier.Value)
+			if dns, ok := solver.(*dnsChallenge); ok {
+				if _, seen := dnsChallenges[dns]; !seen {
+					dnsSolvers = append(dnsSolvers, dns)
+					dnsChallenges[dns] = make(map[string][]challenge)
+				}
+
+				domain := authz.Identifier.Value
+				if _, seen := dnsChallenges[dns][domain]; !seen {
+					dnsDomains[dns] = append(dnsDomains[dns], domain)
+				}
+				dnsChallenges[dns][domain] = append(dnsChallenges[dns][domain], authz.Challenges[i])
+				if authz.Wildcard {
+					log.Infof("[*.%s] acme: The wildcard is validated with the record _acme-challenge.%s of %s", domain, domain, domain)
+					if _, seen := dnsNames[domain]; !seen {
+						dnsNames[domain] = "*." + domain
+					}
+				} else {
+					dnsNames[domain] = domain
+				}
+				continue
+			}
+
+			err := solver.Solve(ctx, authz.Challenges[i], authz.Identifier.Value)
 			if err != nil {
 				//c.disableAuthz(authz.Identifier)
 				failures[authz.Identifier.Value] = err
 			}
+		} else if authz.Identifier.Type == "ip" {
+			failures[authz.Identifier.Value] = fmt.Errorf("[%s] acme: Could not determine solvers, IP addresses can only be validated with %s or %s", authz.Identifier.Value, HTTP01, TLSALPN01)
 		} else {
 			//c.disableAuthz(authz)
 			failures[authz.Identifier.Value] = fmt.Errorf("[%s] acme: Could not determine solvers", authz.Identifier.Value)
 		}
 	}
 
+	for _, dnsSolver := range dnsSolvers {
+		for _, groups := range groupDNSChallenges(dnsDomains[dnsSolver], dnsChallenges[dnsSolver], dnsSolver) {
+			solveFailures, cleanUpErrs := dnsSolver.SolveGroups(ctx, groups)
+			for domain, err := range solveFailures {
+				failures[dnsNames[domain]] = err
+			}
+			for domain, err := range cleanUpErrs {
+				cleanUpFailures[dnsNames[domain]] = err
+			}
+		}
+	}
+
 	// be careful not to return an empty failures map, for
 	// even an empty ObtainError is a non-nil error value
 	if len(failures) > 0 {
+		if len(cleanUpFailures) > 0 {
+			return &CleanUpError{Err: failures, CleanUpErrors: cleanUpFailures}
+		}
 		return failures
 	}
 	return nil
 }
 
-// Checks all challenges from the server in order and returns the first matching solver.
+// groupDNSChallenges splits the dns-01 challenges in rounds of groups solved together.
+// The challenges sharing the same record (a domain and its wildcard) are in the same group
+// when the provider supports multiple values, otherwise they are spread over several rounds.
+func groupDNSChallenges(domains []string, chlngs map[string][]challenge, solver *dnsChallenge) [][]*dnsChallengeGroup {
+	var rounds [][]*dnsChallengeGroup
+
+	if len(domains) > 0 && solver.supportsMultiValue() {
+		var groups []*dnsChallengeGroup
+		for _, domain := range domains {
+			groups = append(groups, &dnsChallengeGroup{domain: domain, chlngs: chlngs[domain]})
+		}
+		return append(rounds, groups)
+	}
+
+	for _, domain := range domains {
+		for i, chlng := range chlngs[domain] {
+			if i == len(rounds) {
+				rounds = append(rounds, nil)
+			}
+			rounds[i] = append(rounds[i], &dnsChallengeGroup{domain: domain, chlngs: []challenge{chlng}})
+		}
+	}
+
+	return rounds
+}
+
+// chooseSolver returns the challenge chosen by the ChallengeSelector if any,
+// otherwise the first challenge offered by the server in the default order having a solver.
+// IP identifiers can't be validated with DNS-01, its challenges are skipped for them.
 func (c *Client) chooseSolver(auth authorization, domain string) (int, solver) {
-	for i, challenge := range auth.Challenges {
-		if solver, ok := c.solvers[Challenge(challenge.Type)]; ok {
+	if c.challengeSelector != nil {
+		if i, solver, ok := c.selectSolver(auth, domain); ok {
 			return i, solver
 		}
+	}
+
+	for _, preferred := range defaultChallengeOrder {
+		for i, challenge := range auth.Challenges {
+			if Challenge(challenge.Type) != preferred {
+				continue
+			}
+			if auth.Identifier.Type == "ip" && preferred == DNS01 {
+				log.Infof("[%s] acme: Skipping %s, it does not support IP addresses", domain, challenge.Type)
+				continue
+			}
+			if solver, ok := c.solvers[preferred]; ok {
+				return i, solver
+			}
+		}
+	}
+
+	for _, challenge := range auth.Challenges {
 		log.Infof("[%s] acme: Could not find solver for: %s", domain, challenge.Type)
 	}
 	return 0, nil
 }
 
+// selectSolver returns the challenge and the solver chosen by the ChallengeSelector,
+// ok is false if the default selection must be used.
+func (c *Client) selectSolver(auth authorization, domain string) (int, solver, bool) {
+	authz := Authorization{Identifier: auth.Identifier.Value, Wildcard: auth.Wildcard}
+	for _, challenge := range auth.Challenges {
+		authz.Challenges = append(authz.Challenges, Challenge(challenge.Type))
+	}
+
+	name := domain
+	if auth.Wildcard {
+		name = "*." + domain
+	}
+
+	selected, provider := c.challengeSelector(name, authz)
+	if selected == "" {
+		return 0, nil, false
+	}
+
+	for i, challenge := range auth.Challenges {
+		if Challenge(challenge.Type) != selected {
+			continue
+		}
+
+		if provider == nil {
+			solver, ok := c.solvers[selected]
+			if !ok {
+				log.Warnf("[%s] acme: No provider for the selected challenge %s", name, selected)
+			}
+			return i, solver, true
+		}
+
+		solver, err := c.selectedSolver(selected, provider)
+		if err != nil {
+			log.Warnf("[%s] acme: %v", name, err)
+		}
+		return i, solver, true
+	}
+
+	log.Warnf("[%s] acme: The selected challenge %s is not offered by the server", name, selected)
+	return 0, nil, true
+}
+
+// contextError returns the error of the context if it is done, as it caused err, otherwise err.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // Get the challenges needed to proof our identifier to the ACME server.
-func (c *Client) getAuthzForOrder(order orderResource) ([]authorization, error) {
+func (c *Client) getAuthzForOrder(ctx context.Context, order orderResource) ([]authorization, error) {
 	resc, errc := make(chan authorization), make(chan domainError)
 
 	delay := time.Second / overallRequestLimit
@@ -604,7 +1376,7 @@ func (c *Client) getAuthzForOrder(order orderResource) ([]authorization, error)
 
 		go func(authzURL string) {
 			var authz authorization
-			_, err := getJSON(authzURL, &authz)
+			_, err := getJSON(ctx, c.jws.httpClient(), authzURL, &authz)
 			if err != nil {
 				errc <- domainError{Domain: authz.Identifier.Value, Error: err}
 				return
@@ -647,11 +1419,11 @@ func logAuthz(order orderResource) {
 // cleanAuthz loops through the passed in slice and disables any auths which are not "valid"
 func (c *Client) disableAuthz(authURL string) error {
 	var disabledAuth authorization
-	_, err := postJSON(c.jws, authURL, deactivateAuthMessage{Status: "deactivated"}, &disabledAuth)
+	_, err := postJSON(context.Background(), c.jws, authURL, deactivateAuthMessage{Status: "deactivated"}, &disabledAuth)
 	return err
 }
 
-func (c *Client) requestCertificateForOrder(order orderResource, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (*CertificateResource, error) {
+func (c *Client) requestCertificateForOrder(ctx context.Context, order orderResource, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (*CertificateResource, error) {
 
 	var err error
 	if privKey == nil {
@@ -677,21 +1449,30 @@ func (c *Client) requestCertificateForOrder(order orderResource, bundle bool, pr
 		}
 	}
 
-	// TODO: should the CSR be customizable?
-	csr, err := generateCsr(privKey, commonName, san, mustStaple)
+	template := newCsrTemplate(commonName, san, mustStaple)
+	if c.preCSRHook != nil {
+		if err := c.preCSRHook(template); err != nil {
+			return nil, fmt.Errorf("acme: the CSR hook failed: %v", err)
+		}
+		if err := checkCsrIdentifiers(template, san); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, privKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.requestCertificateForCsr(order, bundle, csr, pemEncode(privKey))
+	return c.requestCertificateForCsr(ctx, order, bundle, csr, pemEncode(privKey))
 }
 
-func (c *Client) requestCertificateForCsr(order orderResource, bundle bool, csr []byte, privateKeyPem []byte) (*CertificateResource, error) {
+func (c *Client) requestCertificateForCsr(ctx context.Context, order orderResource, bundle bool, csr []byte, privateKeyPem []byte) (*CertificateResource, error) {
 	commonName := order.Domains[0]
 
 	csrString := base64.RawURLEncoding.EncodeToString(csr)
 	var retOrder orderMessage
-	_, err := postJSON(c.jws, order.Finalize, csrMessage{Csr: csrString}, &retOrder)
+	hdr, err := postJSON(ctx, c.jws, order.Finalize, csrMessage{Csr: csrString}, &retOrder)
 	if err != nil {
 		return nil, err
 	}
@@ -708,7 +1489,7 @@ func (c *Client) requestCertificateForCsr(order orderResource, bundle bool, csr
 
 	if retOrder.Status == "valid" {
 		// if the certificate is available right away, short cut!
-		ok, err := c.checkCertResponse(retOrder, &certRes, bundle)
+		ok, err := c.checkCertResponse(ctx, retOrder, &certRes, bundle)
 		if err != nil {
 			return nil, err
 		}
@@ -718,28 +1499,46 @@ func (c *Client) requestCertificateForCsr(order orderResource, bundle bool, csr
 		}
 	}
 
-	stopTimer := time.NewTimer(30 * time.Second)
+	interval, timeout := c.orderPollInterval, c.orderPollTimeout
+	if interval <= 0 {
+		interval = defaultOrderPollInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultOrderPollTimeout
+	}
+
+	stopTimer := time.NewTimer(timeout)
 	defer stopTimer.Stop()
-	retryTick := time.NewTicker(500 * time.Millisecond)
-	defer retryTick.Stop()
 
 	for {
+		// The CA may ask to wait longer with a Retry-After header on the order.
+		delay := interval
+		if retryAfter := parseRetryAfter(hdr.Get("Retry-After"), c.now()); retryAfter > 0 {
+			delay = retryAfter
+		}
+
+		retryTimer := time.NewTimer(delay)
 		select {
 		case <-stopTimer.C:
-			return nil, errors.New("certificate polling timed out")
-		case <-retryTick.C:
-			_, err := getJSON(order.URL, &retOrder)
-			if err != nil {
-				return nil, err
-			}
+			retryTimer.Stop()
+			return nil, OrderTimeoutError{OrderURL: order.URL, Timeout: timeout}
+		case <-ctx.Done():
+			retryTimer.Stop()
+			return nil, ctx.Err()
+		case <-retryTimer.C:
+		}
 
-			done, err := c.checkCertResponse(retOrder, &certRes, bundle)
-			if err != nil {
-				return nil, err
-			}
-			if done {
-				return &certRes, nil
-			}
+		hdr, err = getJSON(ctx, c.jws.httpClient(), order.URL, &retOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		done, err := c.checkCertResponse(ctx, retOrder, &certRes, bundle)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return &certRes, nil
 		}
 	}
 }
@@ -749,26 +1548,25 @@ func (c *Client) requestCertificateForCsr(order orderResource, bundle bool, csr
 // is not yet ready, it returns false. The certRes input
 // should already have the Domain (common name) field populated. If bundle is
 // true, the certificate will be bundled with the issuer's cert.
-func (c *Client) checkCertResponse(order orderMessage, certRes *CertificateResource, bundle bool) (bool, error) {
+func (c *Client) checkCertResponse(ctx context.Context, order orderMessage, certRes *CertificateResource, bundle bool) (bool, error) {
 
 	switch order.Status {
 	case "valid":
-		resp, err := httpGet(order.Certificate)
+		cert, rawLinks, err := c.getCertificateChain(ctx, order.Certificate)
 		if err != nil {
 			return false, err
 		}
 
-		cert, err := ioutil.ReadAll(limitReader(resp.Body, maxBodySize))
-		if err != nil {
-			return false, err
+		if c.preferredChain != "" {
+			cert, rawLinks = c.selectPreferredChain(ctx, certRes.Domain, cert, rawLinks)
 		}
 
 		// The issuer certificate link may be supplied via an "up" link
 		// in the response headers of a new certificate.  See
 		// https://tools.ietf.org/html/draft-ietf-acme-acme-12#section-7.4.2
-		links := parseLinks(resp.Header["Link"])
+		links := parseLinks(rawLinks)
 		if link, ok := links["up"]; ok {
-			issuerCert, err := c.getIssuerCertificate(link)
+			issuerCert, err := c.getIssuerCertificate(ctx, link)
 
 			if err != nil {
 				// If we fail to acquire the issuer cert, return the issued certificate - do not fail.
@@ -808,10 +1606,71 @@ func (c *Client) checkCertResponse(order orderMessage, certRes *CertificateResou
 	}
 }
 
+// getCertificateChain downloads a certificate chain and returns it with the Link headers of the response.
+func (c *Client) getCertificateChain(ctx context.Context, url string) ([]byte, []string, error) {
+	resp, err := httpGet(ctx, c.jws.httpClient(), url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	cert, err := ioutil.ReadAll(limitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, resp.Header["Link"], nil
+}
+
+// selectPreferredChain returns the first chain matching the preferred chain among the default one
+// and the alternates linked from its response, or the default chain if none matches.
+func (c *Client) selectPreferredChain(ctx context.Context, domain string, cert []byte, links []string) ([]byte, []string) {
+	if chainMatches(cert, c.preferredChain) {
+		return cert, links
+	}
+
+	for _, alternate := range parseLinkURLs(links, "alternate") {
+		altCert, altLinks, err := c.getCertificateChain(ctx, alternate)
+		if err != nil {
+			log.Warnf("[%s] acme: Could not download the alternate chain %s: %v", domain, alternate, err)
+			continue
+		}
+
+		if chainMatches(altCert, c.preferredChain) {
+			log.Infof("[%s] acme: Using the alternate chain %s matching %q", domain, alternate, c.preferredChain)
+			return altCert, altLinks
+		}
+	}
+
+	log.Warnf("[%s] acme: No certificate chain matches the preferred chain %q, using the default one", domain, c.preferredChain)
+	return cert, links
+}
+
+// chainMatches reports whether one of the issuers of the chain (intermediates or root)
+// has the preferred common name or authority key identifier (hex encoded, colons allowed).
+func chainMatches(bundle []byte, preferred string) bool {
+	certificates, err := parsePEMBundle(bundle)
+	if err != nil {
+		return false
+	}
+
+	keyID := strings.ToLower(strings.Replace(preferred, ":", "", -1))
+	for _, cert := range certificates {
+		if cert.Issuer.CommonName == preferred {
+			return true
+		}
+		if len(cert.AuthorityKeyId) > 0 && hex.EncodeToString(cert.AuthorityKeyId) == keyID {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getIssuerCertificate requests the issuer certificate
-func (c *Client) getIssuerCertificate(url string) ([]byte, error) {
+func (c *Client) getIssuerCertificate(ctx context.Context, url string) ([]byte, error) {
 	log.Infof("acme: Requesting issuer cert from %s", url)
-	resp, err := httpGet(url)
+	resp, err := httpGet(ctx, c.jws.httpClient(), url)
 	if err != nil {
 		return nil, err
 	}
@@ -849,12 +1708,30 @@ func parseLinks(links []string) map[string]string {
 	return linkMap
 }
 
+// parseLinkURLs returns the URLs of all the links with the given relation,
+// the links may be in several headers or comma separated in the same header.
+func parseLinkURLs(links []string, rel string) []string {
+	var urls []string
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && kv[0] == "rel" && strings.Trim(kv[1], `"`) == rel {
+					urls = append(urls, strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+				}
+			}
+		}
+	}
+	return urls
+}
+
 // validate makes the ACME server start validating a
 // challenge response, only returning once it is done.
-func validate(j *jws, domain, uri string, c challenge) error {
+func validate(ctx context.Context, j *jws, domain, uri string, c challenge) error {
 	var chlng challenge
 
-	hdr, err := postJSON(j, uri, c, &chlng)
+	hdr, err := postJSON(ctx, j, uri, c, &chlng)
 	if err != nil {
 		return err
 	}
@@ -880,9 +1757,13 @@ func validate(j *jws, domain, uri string, c challenge) error {
 			// If it doesn't, we'll just poll hard.
 			ra = 5
 		}
-		time.Sleep(time.Duration(ra) * time.Second)
+		select {
+		case <-time.After(time.Duration(ra) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 
-		hdr, err = getJSON(uri, &chlng)
+		hdr, err = getJSON(ctx, j.httpClient(), uri, &chlng)
 		if err != nil {
 			return err
 		}
@@ -1,16 +1,36 @@
 package acme
 
 import (
+	"bytes"
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/xenolf/lego/log"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
 )
 
 func TestNewClient(t *testing.T) {
@@ -58,6 +78,96 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+// redirectTransport sends all the requests to the host of the target server.
+type redirectTransport struct {
+	target string
+}
+
+func (r *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(r.target)
+	if err != nil {
+		return nil, err
+	}
+
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewClientStaging(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, directory{
+			NewNonceURL:   LEDirectoryStaging + "/nonce",
+			NewAccountURL: LEDirectoryStaging + "/account",
+			NewOrderURL:   LEDirectoryStaging + "/newOrder",
+		})
+	}))
+	defer ts.Close()
+
+	defer func(transport http.RoundTripper) { HTTPClient.Transport = transport }(HTTPClient.Transport)
+	HTTPClient.Transport = &redirectTransport{target: ts.URL}
+
+	defer log.SetLogger(nil)
+	buf := &bytes.Buffer{}
+	log.SetLogger(stdlog.New(buf, "", 0))
+
+	stagingWarning = sync.Once{}
+
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+	user := mockUser{email: "test@test.com", privatekey: key}
+
+	for i := 0; i < 2; i++ {
+		client, err := NewClient(LEDirectoryStaging, user, RSA2048)
+		if err != nil {
+			t.Fatalf("Could not create client: %v", err)
+		}
+		if !client.Staging() {
+			t.Error("Expected the client to use the staging directory")
+		}
+		if client.directoryURL != LEDirectoryStaging {
+			t.Errorf("Expected the staging directory URL, got %s", client.directoryURL)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "not publicly trusted"); n != 1 {
+		t.Errorf("Expected the staging warning to be emitted once, got %d times: %q", n, buf.String())
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if client.Staging() {
+		t.Error("Expected the client not to use the staging directory")
+	}
+}
+
+func TestClientSetKeyType(t *testing.T) {
+	client := &Client{keyType: RSA2048}
+
+	if err := client.SetKeyType(EC384); err != nil {
+		t.Fatalf("Unexpected error setting the key type: %v", err)
+	}
+	if client.keyType != EC384 {
+		t.Errorf("Expected keyType to be %s but was %s", EC384, client.keyType)
+	}
+
+	if err := client.SetKeyType(KeyType("1024")); err == nil {
+		t.Error("Expected an invalid key type to be rejected")
+	}
+	if client.keyType != EC384 {
+		t.Errorf("Expected keyType to stay %s but was %s", EC384, client.keyType)
+	}
+
+	// the account key is independent of the key type of the certificates.
+	if err := client.SetKeyType(EC521); err != nil {
+		t.Fatalf("Unexpected error setting the key type: %v", err)
+	}
+	if client.keyType != EC521 {
+		t.Errorf("Expected keyType to be %s but was %s", EC521, client.keyType)
+	}
+}
+
 func TestClientOptPort(t *testing.T) {
 	keyBits := 32 // small value keeps test fast
 	key, err := rsa.GenerateKey(rand.Reader, keyBits)
@@ -187,7 +297,7 @@ func TestValidate(t *testing.T) {
 
 	for _, tst := range tsts {
 		statuses = tst.statuses
-		if err := validate(j, "example.com", ts.URL, challenge{Type: "http-01", Token: "token"}); err == nil && tst.want != "" {
+		if err := validate(context.Background(), j, "example.com", ts.URL, challenge{Type: "http-01", Token: "token"}); err == nil && tst.want != "" {
 			t.Errorf("[%s] validate: got error %v, want something with %q", tst.name, err, tst.want)
 		} else if err != nil && !strings.Contains(err.Error(), tst.want) {
 			t.Errorf("[%s] validate: got error %v, want something with %q", tst.name, err, tst.want)
@@ -232,7 +342,7 @@ func TestGetChallenges(t *testing.T) {
 		t.Fatalf("Could not create client: %v", err)
 	}
 
-	_, err = client.createOrderForIdentifiers([]string{"example.com"})
+	_, err = client.createOrderForIdentifiers(context.Background(), []string{"example.com"})
 	if err != nil {
 		t.Fatal("Expecting \"Server did not provide next link to proceed\" error, got nil")
 	}
@@ -284,33 +394,2222 @@ func TestResolveAccountByKey(t *testing.T) {
 	} else if res.Body.Status != "valid" {
 		t.Errorf("Unexpected account status: %v", res.Body.Status)
 	}
+
+	if client.jws.kid != ts.URL+"/account_recovery" {
+		t.Errorf("Expected the client to use the account URL, got %q", client.jws.kid)
+	}
 }
 
-// writeJSONResponse marshals the body as JSON and writes it to the response.
-func writeJSONResponse(w http.ResponseWriter, body interface{}) {
-	bs, err := json.Marshal(body)
+func TestResolveAccountByKeyNoAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     new(RegistrationResource),
+		privatekey: key,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(bs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.RequestURI {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/account":
+			w.Header().Add("Replay-Nonce", "12345")
+
+			payload, err := readJWS(t, r).Verify(&key.PublicKey)
+			if err != nil {
+				t.Errorf("Could not verify the request: %v", err)
+			}
+			var msg accountMessage
+			if err = json.Unmarshal(payload, &msg); err != nil {
+				t.Errorf("Could not decode the account message: %v", err)
+			}
+			if !msg.OnlyReturnExisting {
+				t.Error("Expected onlyReturnExisting to be set")
+			}
+
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"type":"urn:ietf:params:acme:error:accountDoesNotExist","detail":"No account exists with the provided key"}`))
+		default:
+			t.Errorf("Unexpected request to %s", r.RequestURI)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	if _, err = client.ResolveAccountByKey(); err != ErrNoExistingAccount {
+		t.Errorf("Expected ErrNoExistingAccount, got %v", err)
+	}
+	if client.jws.kid != "" {
+		t.Errorf("Expected the client not to be bound to an account, got %q", client.jws.kid)
 	}
 }
 
-// stubValidate is like validate, except it does nothing.
-func stubValidate(j *jws, domain, uri string, chlng challenge) error {
-	return nil
+func TestRolloverAccountKey(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/keyChange":
+			w.Header().Add("Replay-Nonce", "12345")
+
+			outer := readJWS(t, r)
+			if outer.Signatures[0].Protected.KeyID != ts.URL+"/account/1" {
+				t.Errorf("Expected the outer JWS to use the account kid, got %q", outer.Signatures[0].Protected.KeyID)
+			}
+			payload, err := outer.Verify(&oldKey.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected the outer JWS to be signed with the old key: %v", err)
+			}
+
+			inner, err := jose.ParseSigned(string(payload))
+			if err != nil {
+				t.Fatalf("Could not parse inner JWS: %v", err)
+			}
+			innerHeader := inner.Signatures[0].Protected
+			if innerHeader.Nonce != "" {
+				t.Error("Expected the inner JWS to have no nonce")
+			}
+			if innerHeader.ExtraHeaders["url"] != ts.URL+r.RequestURI {
+				t.Errorf("Expected the inner JWS url to be the keyChange URL, got %v", innerHeader.ExtraHeaders["url"])
+			}
+			if innerHeader.JSONWebKey == nil {
+				t.Fatal("Expected the inner JWS to embed the new key")
+			}
+			innerPayload, err := inner.Verify(&newKey.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected the inner JWS to be signed with the new key: %v", err)
+			}
+
+			var msg keyChangeMessage
+			if err := json.Unmarshal(innerPayload, &msg); err != nil {
+				t.Fatalf("Could not decode key change message: %v", err)
+			}
+			if msg.Account != ts.URL+"/account/1" {
+				t.Errorf("Expected the account URL in the key change message, got %q", msg.Account)
+			}
+			if pub, ok := msg.OldKey.Key.(*rsa.PublicKey); !ok || pub.N.Cmp(oldKey.N) != 0 {
+				t.Errorf("Expected the old key in the key change message, got %v", msg.OldKey.Key)
+			}
+
+			if r.URL.Query().Get("conflict") != "" {
+				w.Header().Set("Location", ts.URL+"/account/2")
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"type":"urn:ietf:params:acme:error:malformed","detail":"key in use"}`))
+			}
+		case "/account/1":
+			w.Header().Add("Replay-Nonce", "12345")
+
+			if _, err := readJWS(t, r).Verify(&newKey.PublicKey); err != nil {
+				t.Errorf("Expected the request to be signed with the new key: %v", err)
+			}
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: oldKey,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	if err := client.RolloverAccountKey(newKey); err != nil {
+		t.Fatalf("Unexpected error rolling over the account key: %v", err)
+	}
+	if client.jws.privKey != newKey {
+		t.Error("Expected the client to use the new key")
+	}
+
+	if _, err := client.QueryRegistration(); err != nil {
+		t.Fatalf("Unexpected error querying the registration: %v", err)
+	}
+
+	client.jws.privKey = oldKey
+	client.directory.KeyChangeURL = ts.URL + "/keyChange?conflict=1"
+
+	err = client.RolloverAccountKey(newKey)
+	keyInUse, ok := err.(KeyInUseError)
+	if !ok {
+		t.Fatalf("Expected a KeyInUseError, got %v", err)
+	}
+	if keyInUse.AccountURL != ts.URL+"/account/2" {
+		t.Errorf("Expected the conflicting account URL, got %q", keyInUse.AccountURL)
+	}
+	if client.jws.privKey != oldKey {
+		t.Error("Expected the client to keep the old key")
+	}
 }
 
-type mockUser struct {
-	email      string
-	regres     *RegistrationResource
-	privatekey *rsa.PrivateKey
+func TestRegisterEd25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	testCases := []struct {
+		desc string
+		// algorithms are the signature algorithms accepted by the CA.
+		algorithms []string
+		expectErr  bool
+	}{
+		{desc: "EdDSA supported", algorithms: []string{"RS256", "ES256", "ES384", "EdDSA"}},
+		{desc: "EdDSA not supported", algorithms: []string{"RS256", "ES256", "ES384"}, expectErr: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			var ts *httptest.Server
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/directory":
+					writeJSONResponse(w, directory{
+						NewNonceURL:   ts.URL + "/nonce",
+						NewAccountURL: ts.URL + "/account",
+						NewOrderURL:   ts.URL + "/newOrder",
+					})
+				case "/nonce":
+					w.Header().Add("Replay-Nonce", "12345")
+					w.Header().Add("Retry-After", "0")
+				case "/account":
+					w.Header().Add("Replay-Nonce", "12345")
+
+					signed := readJWS(t, r)
+					header := signed.Signatures[0].Protected
+
+					supported := false
+					for _, alg := range test.algorithms {
+						supported = supported || alg == header.Algorithm
+					}
+					if !supported {
+						w.Header().Set("Content-Type", "application/problem+json")
+						w.WriteHeader(http.StatusBadRequest)
+						algorithms, _ := json.Marshal(test.algorithms)
+						fmt.Fprintf(w, `{"type":"urn:ietf:params:acme:error:badSignatureAlgorithm","detail":"unsupported algorithm %s","algorithms":%s}`, header.Algorithm, algorithms)
+						return
+					}
+
+					if header.Algorithm != string(jose.EdDSA) {
+						t.Errorf("Expected the EdDSA algorithm, got %s", header.Algorithm)
+					}
+					jwk, ok := header.JSONWebKey.Key.(ed25519.PublicKey)
+					if !ok || !bytes.Equal(jwk, key.Public().(ed25519.PublicKey)) {
+						t.Errorf("Expected the embedded JWK to be the public key, got %v", header.JSONWebKey.Key)
+					}
+					if _, err := signed.Verify(key.Public()); err != nil {
+						t.Errorf("Expected the request to be signed with the account key: %v", err)
+					}
+
+					w.Header().Set("Location", ts.URL+"/account/1")
+					w.WriteHeader(http.StatusCreated)
+					writeJSONResponse(w, accountMessage{Status: "valid"})
+				default:
+					t.Errorf("Unexpected request to %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+
+			client, err := NewClient(ts.URL+"/directory", mockUser{email: "test@test.com", privatekey: key}, EC256)
+			if err != nil {
+				t.Fatalf("Could not create client: %v", err)
+			}
+
+			reg, err := client.Register(true)
+
+			if !test.expectErr {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if reg.URI != ts.URL+"/account/1" {
+					t.Errorf("Unexpected account URL %s", reg.URI)
+				}
+				return
+			}
+
+			algErr, ok := err.(BadSignatureAlgorithmError)
+			if !ok {
+				t.Fatalf("Expected a BadSignatureAlgorithmError, got %v", err)
+			}
+			if !reflect.DeepEqual(algErr.Algorithms, test.algorithms) {
+				t.Errorf("Expected the algorithms of the CA, got %v", algErr.Algorithms)
+			}
+			if !strings.HasSuffix(err.Error(), "(supported algorithms: RS256, ES256, ES384)") {
+				t.Errorf("Expected the supported algorithms in the error, got %q", err.Error())
+			}
+		})
+	}
 }
 
-func (u mockUser) GetEmail() string                       { return u.email }
-func (u mockUser) GetRegistration() *RegistrationResource { return u.regres }
-func (u mockUser) GetPrivateKey() crypto.PrivateKey       { return u.privatekey }
+func TestRegisterWithOptionsTermsOfService(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var accountRequests int
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			dir := directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+			}
+			dir.Meta.TermsOfService = ts.URL + "/terms"
+			writeJSONResponse(w, dir)
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/account":
+			accountRequests++
+			w.Header().Add("Replay-Nonce", "12345")
+
+			payload, err := readJWS(t, r).Verify(&key.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected the account request to be signed with the account key: %v", err)
+			}
+
+			var acc accountMessage
+			if err = json.Unmarshal(payload, &acc); err != nil {
+				t.Fatalf("Could not decode the account request: %v", err)
+			}
+			if !acc.TermsOfServiceAgreed {
+				t.Error("Expected the terms of service to be agreed")
+			}
+
+			w.Header().Set("Location", ts.URL+"/account/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, accountMessage{Status: "valid", TermsOfServiceAgreed: true})
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL+"/directory", mockUser{email: "test@test.com", privatekey: key}, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	_, err = client.RegisterWithOptions(RegisterOptions{})
+	tosErr, ok := err.(ErrTermsOfServiceRequired)
+	if !ok {
+		t.Fatalf("Expected an ErrTermsOfServiceRequired, got %v", err)
+	}
+	if tosErr.URL != ts.URL+"/terms" {
+		t.Errorf("Expected the URL of the terms of service, got %q", tosErr.URL)
+	}
+	if _, err = client.Register(false); err != tosErr {
+		t.Errorf("Expected Register to require the terms of service too, got %v", err)
+	}
+	if _, err = client.RegisterWithExternalAccountBinding(false, "kid-1", "c2VjcmV0"); err != tosErr {
+		t.Errorf("Expected the external account binding to require the terms of service too, got %v", err)
+	}
+	if accountRequests != 0 {
+		t.Fatalf("Expected no account request without the agreement, got %d", accountRequests)
+	}
+
+	reg, err := client.RegisterWithOptions(RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reg.URI != ts.URL+"/account/1" || !reg.Body.TermsOfServiceAgreed {
+		t.Errorf("Unexpected registration %+v", reg)
+	}
+	if accountRequests != 1 {
+		t.Errorf("Expected one account request, got %d", accountRequests)
+	}
+}
+
+func TestNewClientEd25519CertificateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	_, err = NewClient("http://127.0.0.1:1/directory", mockUser{privatekey: key}, Ed25519)
+	if err == nil || err.Error() != "acme: Ed25519 keys are only supported as account keys" {
+		t.Errorf("Expected the Ed25519 certificate keys to be rejected, got %v", err)
+	}
+}
+
+func TestRegisterWithExternalAccountBinding(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	hmacKey := []byte("a-very-secret-hmac-key-of-the-ca")
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			dir := directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			}
+			dir.Meta.ExternalAccountRequired = true
+			writeJSONResponse(w, dir)
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/account":
+			w.Header().Add("Replay-Nonce", "12345")
+
+			payload, err := readJWS(t, r).Verify(&key.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected the account request to be signed with the account key: %v", err)
+			}
+
+			var acc accountMessage
+			if err := json.Unmarshal(payload, &acc); err != nil {
+				t.Fatalf("Could not decode account message: %v", err)
+			}
+
+			eab, err := jose.ParseSigned(string(acc.ExternalAccountBinding))
+			if err != nil {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"type":"urn:ietf:params:acme:error:externalAccountRequired","detail":"missing binding"}`))
+				return
+			}
+
+			header := eab.Signatures[0].Protected
+			if header.KeyID != "kid-1" {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"type":"urn:ietf:params:acme:error:unauthorized","detail":"unknown key ID"}`))
+				return
+			}
+			if header.Algorithm != string(jose.HS256) {
+				t.Errorf("Expected the binding to use HS256, got %s", header.Algorithm)
+			}
+			if header.ExtraHeaders["url"] != ts.URL+"/account" {
+				t.Errorf("Expected the binding url to be the newAccount URL, got %v", header.ExtraHeaders["url"])
+			}
+
+			eabPayload, err := eab.Verify(hmacKey)
+			if err != nil {
+				t.Fatalf("Expected the binding to be signed with the HMAC key: %v", err)
+			}
+
+			var jwk jose.JSONWebKey
+			if err := jwk.UnmarshalJSON(eabPayload); err != nil {
+				t.Fatalf("Could not decode the bound JWK: %v", err)
+			}
+			if pub, ok := jwk.Key.(*rsa.PublicKey); !ok || pub.N.Cmp(key.N) != 0 {
+				t.Errorf("Expected the binding to contain the account key, got %v", jwk.Key)
+			}
+
+			w.Header().Set("Location", ts.URL+"/account/1")
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     new(RegistrationResource),
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	if !client.GetExternalAccountRequired() {
+		t.Error("Expected the directory to require an external account binding")
+	}
+
+	hmacEncoded := base64.RawURLEncoding.EncodeToString(hmacKey)
+
+	reg, err := client.RegisterWithExternalAccountBinding(true, "kid-1", hmacEncoded)
+	if err != nil {
+		t.Fatalf("Unexpected error registering: %v", err)
+	}
+	if reg.URI != ts.URL+"/account/1" {
+		t.Errorf("Unexpected account URI: %v", reg.URI)
+	}
+
+	_, err = client.RegisterWithExternalAccountBinding(true, "kid-1", hmacEncoded+"=")
+	if err != nil {
+		t.Errorf("Expected a padded HMAC key to be accepted, got %v", err)
+	}
+
+	_, err = client.RegisterWithExternalAccountBinding(true, "kid-2", hmacEncoded)
+	if err == nil || !strings.Contains(err.Error(), "unknown key ID") || !strings.Contains(err.Error(), "kid-2") {
+		t.Errorf("Expected the rejection of the CA to be surfaced, got %v", err)
+	}
+
+	invalid := []struct{ kid, hmac string }{
+		{kid: "", hmac: hmacEncoded},
+		{kid: "kid-1", hmac: ""},
+		{kid: "kid-1", hmac: "not+base64/url"},
+	}
+	for _, test := range invalid {
+		if _, err := client.RegisterWithExternalAccountBinding(true, test.kid, test.hmac); err == nil {
+			t.Errorf("Expected kid %q and HMAC %q to be rejected", test.kid, test.hmac)
+		}
+	}
+}
+
+func TestRevokeCertificateWithReason(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var revocations []revokeCertMessage
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/revokeCert":
+			w.Header().Add("Replay-Nonce", "12345")
+
+			payload, err := readJWS(t, r).Verify(&key.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected the revocation to be signed with the account key: %v", err)
+			}
+
+			var msg revokeCertMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Fatalf("Could not decode revocation message: %v", err)
+			}
+			revocations = append(revocations, msg)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	bundle, _, _ := generateTestChain(t, nil)
+
+	if err := client.RevokeCertificateWithReason(bundle, RevocationReasonKeyCompromise); err != nil {
+		t.Fatalf("Unexpected error revoking the certificate: %v", err)
+	}
+	if err := client.RevokeCertificate(bundle); err != nil {
+		t.Fatalf("Unexpected error revoking the certificate: %v", err)
+	}
+
+	for _, reason := range []uint{7, 11} {
+		if err := client.RevokeCertificateWithReason(bundle, reason); err == nil {
+			t.Errorf("Expected the reason %d to be rejected", reason)
+		}
+	}
+
+	if len(revocations) != 2 {
+		t.Fatalf("Expected 2 revocation requests, got %d", len(revocations))
+	}
+	if reason := revocations[0].Reason; reason == nil || *reason != RevocationReasonKeyCompromise {
+		t.Errorf("Expected the keyCompromise reason to be transmitted, got %v", reason)
+	}
+	if reason := revocations[1].Reason; reason != nil {
+		t.Errorf("Expected no reason to be transmitted by default, got %d", *reason)
+	}
+}
+
+func TestClientSetHTTPClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+		case "/account/1":
+			w.Header().Add("Replay-Nonce", "12345")
+			writeJSONResponse(w, accountMessage{Status: "valid"})
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	recorder := &recordingTransport{}
+	client.SetHTTPClient(&http.Client{Transport: recorder})
+
+	if _, err := client.QueryRegistration(); err != nil {
+		t.Fatalf("Unexpected error querying the registration: %v", err)
+	}
+
+	expected := []string{"HEAD /nonce", "POST /account/1"}
+	if strings.Join(recorder.requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the requests %v to go through the custom client, got %v", expected, recorder.requests)
+	}
+	for _, ua := range recorder.userAgents {
+		if !strings.Contains(ua, ourUserAgent) {
+			t.Errorf("Expected the User-Agent to be preserved, got %q", ua)
+		}
+	}
+
+	defer func(timeout time.Duration) { HTTPClient.Timeout = timeout }(HTTPClient.Timeout)
+	HTTPClient.Timeout = 42 * time.Second
+
+	custom := &http.Client{Transport: recorder}
+	client.SetHTTPClient(custom)
+	if client.jws.httpClient().Timeout != 42*time.Second {
+		t.Errorf("Expected the default timeout to be applied, got %v", client.jws.httpClient().Timeout)
+	}
+	if custom.Timeout != 0 {
+		t.Error("Expected the custom client to be left untouched")
+	}
+}
+
+func TestClientReusesDirectoryAndNonces(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var directoryFetches, nonceFetches, orders, badNonces int
+	var usedNonces []string
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			directoryFetches++
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			nonceFetches++
+			w.Header().Add("Replay-Nonce", "nonce-0")
+		case "/newOrder":
+			nonce := readJWS(t, r).Signatures[0].Protected.Nonce
+			usedNonces = append(usedNonces, nonce)
+
+			orders++
+			w.Header().Add("Replay-Nonce", fmt.Sprintf("nonce-%d", orders))
+
+			if r.URL.Query().Get("badNonce") != "" && badNonces < 1 {
+				badNonces++
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"type":"` + invalidNonceError + `","detail":"JWS has an invalid anti-replay nonce"}`))
+				return
+			}
+
+			w.Header().Set("Location", ts.URL+"/order/1")
+			writeJSONResponse(w, orderMessage{Status: "pending"})
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com"}); err != nil {
+			t.Fatalf("Unexpected error creating order: %v", err)
+		}
+	}
+
+	if directoryFetches != 1 {
+		t.Errorf("Expected the directory to be fetched once, got %d", directoryFetches)
+	}
+	if nonceFetches != 1 {
+		t.Errorf("Expected a single newNonce request, got %d", nonceFetches)
+	}
+	if expected := "nonce-0,nonce-1,nonce-2"; strings.Join(usedNonces, ",") != expected {
+		t.Errorf("Expected the nonces of the responses to be reused (%s), got %v", expected, usedNonces)
+	}
+
+	// A badNonce error is retried exactly once, with the nonce of the error response.
+	usedNonces = nil
+	client.directory.NewOrderURL = ts.URL + "/newOrder?badNonce=1"
+
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com"}); err != nil {
+		t.Fatalf("Unexpected error creating order: %v", err)
+	}
+	if expected := "nonce-3,nonce-4"; strings.Join(usedNonces, ",") != expected {
+		t.Errorf("Expected a single retry with a new nonce (%s), got %v", expected, usedNonces)
+	}
+
+	if err := client.RefreshDirectory(); err != nil {
+		t.Fatalf("Unexpected error refreshing the directory: %v", err)
+	}
+	if directoryFetches != 2 {
+		t.Errorf("Expected the directory to be fetched again, got %d fetches", directoryFetches)
+	}
+	if client.directory.NewOrderURL != ts.URL+"/newOrder" {
+		t.Errorf("Expected the refreshed directory to be used, got %s", client.directory.NewOrderURL)
+	}
+}
+
+type recordingTransport struct {
+	requests   []string
+	userAgents []string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.userAgents = append(r.userAgents, req.Header.Get("User-Agent"))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func readJWS(t *testing.T, r *http.Request) *jose.JSONWebSignature {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("Could not read request body: %v", err)
+	}
+
+	signed, err := jose.ParseSigned(string(body))
+	if err != nil {
+		t.Fatalf("Could not parse JWS: %v", err)
+	}
+	return signed
+}
+
+func TestNewIdentifier(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected identifier
+		invalid  bool
+	}{
+		{domain: "example.com", expected: identifier{Type: "dns", Value: "example.com"}},
+		{domain: "*.example.com", expected: identifier{Type: "dns", Value: "*.example.com"}},
+		{domain: "192.0.2.1", expected: identifier{Type: "ip", Value: "192.0.2.1"}},
+		{domain: "2001:DB8:0::1", expected: identifier{Type: "ip", Value: "2001:db8::1"}},
+		{domain: "*.192.0.2.1", invalid: true},
+		{domain: "192.0.2.1:443", invalid: true},
+		{domain: "[2001:db8::1]", invalid: true},
+		{domain: "fe80::1%eth0", invalid: true},
+		{domain: "192.0.2.256", invalid: true},
+		{domain: "", invalid: true},
+	}
+
+	for _, test := range testCases {
+		ident, err := newIdentifier(test.domain)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("Expected %q to be rejected, got %v", test.domain, ident)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.domain, err)
+		}
+		if ident != test.expected {
+			t.Errorf("Expected %v for %q, got %v", test.expected, test.domain, ident)
+		}
+	}
+}
+
+func TestCreateOrderForIPIdentifiers(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	var order orderMessage
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+		case "/newOrder":
+			payload, err := readJWS(t, r).Verify(&privKey.PublicKey)
+			if err != nil {
+				t.Fatalf("Could not verify the order request: %v", err)
+			}
+			if err := json.Unmarshal(payload, &order); err != nil {
+				t.Fatalf("Could not parse the order: %v", err)
+			}
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{Status: "pending", Identifiers: order.Identifiers})
+		}
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		directory: directory{NewOrderURL: ts.URL + "/newOrder"},
+		jws:       &jws{privKey: privKey, getNonceURL: ts.URL + "/nonce", kid: ts.URL + "/account/1"},
+	}
+
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com", "192.0.2.1", "2001:db8::1"}); err != nil {
+		t.Fatalf("Unexpected error creating the order: %v", err)
+	}
+
+	expected := []identifier{
+		{Type: "dns", Value: "example.com"},
+		{Type: "ip", Value: "192.0.2.1"},
+		{Type: "ip", Value: "2001:db8::1"},
+	}
+	if fmt.Sprint(order.Identifiers) != fmt.Sprint(expected) {
+		t.Errorf("Expected the identifiers %v, got %v", expected, order.Identifiers)
+	}
+
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"*.192.0.2.1"}); err == nil {
+		t.Error("Expected a wildcard IP address to be rejected")
+	}
+}
+
+func TestCreateOrderRequestedValidity(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	var order map[string]interface{}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+		case "/newOrder":
+			payload, err := readJWS(t, r).Verify(&privKey.PublicKey)
+			if err != nil {
+				t.Fatalf("Could not verify the order request: %v", err)
+			}
+			order = nil
+			if err := json.Unmarshal(payload, &order); err != nil {
+				t.Fatalf("Could not parse the order: %v", err)
+			}
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{Status: "pending"})
+		}
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		directory: directory{NewOrderURL: ts.URL + "/newOrder"},
+		jws:       &jws{privKey: privKey, getNonceURL: ts.URL + "/nonce", kid: ts.URL + "/account/1"},
+	}
+
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com"}); err != nil {
+		t.Fatalf("Unexpected error creating the order: %v", err)
+	}
+	if _, ok := order["notBefore"]; ok {
+		t.Errorf("Expected no notBefore in the order, got %v", order)
+	}
+	if _, ok := order["notAfter"]; ok {
+		t.Errorf("Expected no notAfter in the order, got %v", order)
+	}
+
+	notBefore := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2030, 1, 2, 12, 0, 0, 0, time.UTC)
+	if err := client.SetRequestedValidity(notBefore, notAfter); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com"}); err != nil {
+		t.Fatalf("Unexpected error creating the order: %v", err)
+	}
+	if order["notBefore"] != "2030-01-01T00:00:00Z" || order["notAfter"] != "2030-01-02T12:00:00Z" {
+		t.Errorf("Expected the requested validity in the order, got %v", order)
+	}
+
+	if err := client.SetRequestedValidity(time.Time{}, notAfter); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.createOrderForIdentifiers(context.Background(), []string{"example.com"}); err != nil {
+		t.Fatalf("Unexpected error creating the order: %v", err)
+	}
+	if _, ok := order["notBefore"]; ok || order["notAfter"] != "2030-01-02T12:00:00Z" {
+		t.Errorf("Expected only notAfter in the order, got %v", order)
+	}
+
+	if err := client.SetRequestedValidity(notAfter, notBefore); err == nil {
+		t.Error("Expected an inverted validity window to be rejected")
+	}
+}
+
+func TestSolveChallengeForAuthzSkipsDNS01ForIP(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+	client := &Client{jws: j, solvers: map[Challenge]solver{
+		DNS01: &dnsChallenge{jws: j, provider: &mockTimeoutProvider{}, validate: stubValidate},
+	}}
+
+	authorizations := []authorization{{
+		Identifier: identifier{Type: "ip", Value: "192.0.2.1"},
+		Challenges: []challenge{{Type: string(DNS01), Token: "token"}},
+	}}
+
+	err := client.solveChallengeForAuthz(context.Background(), authorizations)
+	obtainErr, ok := err.(ObtainError)
+	if !ok || obtainErr["192.0.2.1"] == nil {
+		t.Fatalf("Expected an error for 192.0.2.1, got %v", err)
+	}
+	if !strings.Contains(obtainErr["192.0.2.1"].Error(), "IP addresses can only be validated with") {
+		t.Errorf("Unexpected error: %v", obtainErr["192.0.2.1"])
+	}
+}
+
+// mockPreflightProvider is a DNS provider managing the zones, it fails if anything is presented.
+type mockPreflightProvider struct {
+	zones   []string
+	domains []string
+}
+
+func (p *mockPreflightProvider) Present(domain, token, keyAuth string) error {
+	return errors.New("unexpected Present")
+}
+
+func (p *mockPreflightProvider) CleanUp(domain, token, keyAuth string) error {
+	return errors.New("unexpected CleanUp")
+}
+
+func (p *mockPreflightProvider) Preflight(domain string) error {
+	p.domains = append(p.domains, domain)
+	for _, zone := range p.zones {
+		if domain == zone || strings.HasSuffix(domain, "."+zone) {
+			return nil
+		}
+	}
+	return fmt.Errorf("zone of %s not found", domain)
+}
+
+func TestPreflight(t *testing.T) {
+	provider := &mockPreflightProvider{zones: []string{"example.com"}}
+	client := &Client{solvers: map[Challenge]solver{
+		DNS01:  &dnsChallenge{provider: provider},
+		HTTP01: &httpChallenge{provider: &mockTimeoutProvider{}},
+	}}
+
+	err := client.Preflight([]string{"example.com", "*.example.com", "www.example.org", "192.0.2.1"}, "dns-01")
+	obtainErr, ok := err.(ObtainError)
+	if !ok {
+		t.Fatalf("Expected an ObtainError, got %v", err)
+	}
+	if len(obtainErr) != 2 {
+		t.Errorf("Expected 2 failures, got %v", obtainErr)
+	}
+	if e := obtainErr["www.example.org"]; e == nil || e.Error() != "zone of www.example.org not found" {
+		t.Errorf("Expected the missing zone of www.example.org to be reported, got %v", e)
+	}
+	if e := obtainErr["192.0.2.1"]; e == nil || !strings.Contains(e.Error(), "IP addresses can only be validated with") {
+		t.Errorf("Expected the IP address to be rejected, got %v", e)
+	}
+	if !reflect.DeepEqual(provider.domains, []string{"example.com", "example.com", "www.example.org"}) {
+		t.Errorf("Expected the provider to check the domains without wildcard, got %v", provider.domains)
+	}
+
+	// mockTimeoutProvider has no preflight check, only the wildcard is rejected.
+	err = client.Preflight([]string{"example.com", "*.example.com"}, "http-01")
+	obtainErr, ok = err.(ObtainError)
+	if !ok || len(obtainErr) != 1 || obtainErr["*.example.com"] == nil {
+		t.Errorf("Expected the wildcard domain to be rejected with http-01, got %v", err)
+	}
+
+	err = client.Preflight([]string{"example.com"}, "tls-alpn-01")
+	if err == nil || err.Error() != "acme: the tls-alpn-01 challenge is not enabled" {
+		t.Errorf("Expected the disabled challenge to be reported, got %v", err)
+	}
+
+	if err = client.Preflight([]string{"example.com", "www.example.com"}, "dns-01"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestHTTPProviderServerPreflight(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	if err = NewHTTPProviderServer("127.0.0.1", port).Preflight("example.com"); err == nil {
+		t.Error("Expected the port in use to be reported")
+	}
+
+	if err = NewHTTPProviderServer("127.0.0.1", "0").Preflight("example.com"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestObtainCertificateWithContextCancelledDuringPropagation(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		return false, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{NewNonceURL: ts.URL + "/nonce", NewAccountURL: ts.URL + "/newAccount", NewOrderURL: ts.URL + "/newOrder"})
+		case "/nonce":
+		case "/newOrder":
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{
+				Status:         "pending",
+				Identifiers:    []identifier{{Type: "dns", Value: "example.com"}},
+				Authorizations: []string{ts.URL + "/authz/1"},
+				Finalize:       ts.URL + "/finalize/1",
+			})
+		case "/authz/1":
+			writeJSONResponse(w, authorization{
+				Status:     "pending",
+				Identifier: identifier{Type: "dns", Value: "example.com"},
+				Challenges: []challenge{{Type: string(DNS01), Token: "token", URL: ts.URL + "/chlg/1"}},
+			})
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{email: "test@test.com", regres: &RegistrationResource{URI: ts.URL + "/account/1"}, privatekey: privKey}
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	provider := &mockTimeoutProvider{timeout: time.Hour, interval: 10 * time.Millisecond}
+	if err := client.SetChallengeProvider(DNS01, provider); err != nil {
+		t.Fatalf("Could not set the DNS provider: %v", err)
+	}
+	client.ExcludeChallenges([]Challenge{HTTP01, TLSALPN01})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = client.ObtainCertificateWithContext(ctx, []string{"example.com"}, false, nil, false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline of the context to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the call to return promptly after the cancellation, took %v", elapsed)
+	}
+	if !provider.presented || !provider.cleanedUp {
+		t.Errorf("Expected the record to be presented and cleaned up, got presented=%t cleanedUp=%t", provider.presented, provider.cleanedUp)
+	}
+}
+
+func TestSolveChallengeForAuthzCleansUpAfterPresentFailure(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+
+	provider := &mockFailingProvider{failPresent: "c.example.com"}
+	var validated int
+	client := &Client{jws: j, solvers: map[Challenge]solver{
+		DNS01: &dnsChallenge{jws: j, provider: provider, validate: func(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+			validated++
+			return nil
+		}},
+	}}
+
+	var authorizations []authorization
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"} {
+		authorizations = append(authorizations, authorization{
+			Identifier: identifier{Type: "dns", Value: domain},
+			Challenges: []challenge{{Type: string(DNS01), Token: domain}},
+		})
+	}
+
+	err := client.solveChallengeForAuthz(context.Background(), authorizations)
+	obtainErr, ok := err.(ObtainError)
+	if !ok || len(obtainErr) != 1 || obtainErr["c.example.com"] == nil {
+		t.Fatalf("Expected an error for c.example.com only, got %v", err)
+	}
+
+	if strings.Join(provider.presented, ",") != "a.example.com,b.example.com" {
+		t.Errorf("Expected the records to be presented until the failure, got %v", provider.presented)
+	}
+	if strings.Join(provider.cleaned, ",") != "a.example.com,b.example.com" {
+		t.Errorf("Expected the presented records to be cleaned up, got %v", provider.cleaned)
+	}
+	if validated != 0 {
+		t.Errorf("Expected no challenge to be validated, got %d", validated)
+	}
+
+	// the errors of the cleanups are reported apart from the error of the challenge.
+	provider = &mockFailingProvider{failPresent: "c.example.com", failCleanUp: "b.example.com"}
+	client.solvers[DNS01].(*dnsChallenge).provider = provider
+
+	err = client.solveChallengeForAuthz(context.Background(), authorizations)
+	cleanUpErr, ok := err.(*CleanUpError)
+	if !ok {
+		t.Fatalf("Expected a CleanUpError, got %v", err)
+	}
+	if obtainErr, ok := cleanUpErr.Err.(ObtainError); !ok || len(obtainErr) != 1 || obtainErr["c.example.com"] == nil {
+		t.Errorf("Expected the error of c.example.com, got %v", cleanUpErr.Err)
+	}
+	if len(cleanUpErr.CleanUpErrors) != 1 || cleanUpErr.CleanUpErrors["b.example.com"] == nil {
+		t.Errorf("Expected the cleanup error of b.example.com, got %v", cleanUpErr.CleanUpErrors)
+	}
+	if strings.Join(provider.cleaned, ",") != "a.example.com,b.example.com" {
+		t.Errorf("Expected the presented records to be cleaned up, got %v", provider.cleaned)
+	}
+}
+
+func TestRequestCertificateForOrderPreCSRHook(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	customOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1}
+
+	var submitted *x509.CertificateRequest
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{NewNonceURL: ts.URL + "/nonce", NewAccountURL: ts.URL + "/newAccount", NewOrderURL: ts.URL + "/newOrder"})
+		case "/nonce":
+		case "/finalize/1":
+			payload, err := readJWS(t, r).Verify(&privKey.PublicKey)
+			if err != nil {
+				t.Fatalf("Could not verify the finalize request: %v", err)
+			}
+			var msg csrMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Fatalf("Could not read the finalize request: %v", err)
+			}
+			der, _ := base64.RawURLEncoding.DecodeString(msg.Csr)
+			if submitted, err = x509.ParseCertificateRequest(der); err != nil {
+				t.Fatalf("Could not parse the CSR: %v", err)
+			}
+
+			// stop the flow once the CSR is submitted.
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"type": "urn:ietf:params:acme:error:unauthorized", "detail": "stop"}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{email: "test@test.com", regres: &RegistrationResource{URI: ts.URL + "/account/1"}, privatekey: privKey}
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	order := orderResource{
+		Domains:      []string{"example.com", "www.example.com"},
+		orderMessage: orderMessage{Identifiers: []identifier{{Type: "dns", Value: "example.com"}, {Type: "dns", Value: "www.example.com"}}, Finalize: ts.URL + "/finalize/1"},
+	}
+
+	client.SetPreCSRHook(func(csr *x509.CertificateRequest) error {
+		csr.ExtraExtensions = append(csr.ExtraExtensions, pkix.Extension{Id: customOID, Value: []byte{0x05, 0x00}})
+		return nil
+	})
+	if _, err := client.requestCertificateForOrder(context.Background(), order, false, privKey, false); err == nil {
+		t.Fatal("Expected the finalization to fail")
+	}
+
+	if submitted == nil {
+		t.Fatal("Expected the CSR to be submitted")
+	}
+	if err := submitted.CheckSignature(); err != nil {
+		t.Errorf("Expected the CSR to be signed after the hook: %v", err)
+	}
+	var found bool
+	for _, ext := range submitted.Extensions {
+		found = found || ext.Id.Equal(customOID)
+	}
+	if !found {
+		t.Errorf("Expected the custom extension in the submitted CSR, got %v", submitted.Extensions)
+	}
+
+	// the hook cannot change the identifiers of the order.
+	submitted = nil
+	client.SetPreCSRHook(func(csr *x509.CertificateRequest) error {
+		csr.DNSNames = append(csr.DNSNames, "other.example.org")
+		return nil
+	})
+	_, err = client.requestCertificateForOrder(context.Background(), order, false, privKey, false)
+	if err == nil || err.Error() != "acme: the CSR contains other.example.org, which is not an identifier of the order" {
+		t.Errorf("Expected the added name to be rejected, got %v", err)
+	}
+	if submitted != nil {
+		t.Error("Expected no CSR to be submitted")
+	}
+}
+
+func TestObtainCertificateForCSR(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	csrKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	caKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "example.com"},
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+	}, csrKey)
+	if err != nil {
+		t.Fatalf("Could not create the CSR: %v", err)
+	}
+	csr, _ := x509.ParseCertificateRequest(csrDER)
+
+	var certificate []byte
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+
+		var payload []byte
+		if r.Method == http.MethodPost {
+			payload, _ = readJWS(t, r).Verify(&accountKey.PublicKey)
+		}
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{NewNonceURL: ts.URL + "/nonce", NewAccountURL: ts.URL + "/newAccount", NewOrderURL: ts.URL + "/newOrder"})
+		case "/nonce":
+		case "/newOrder":
+			var order orderMessage
+			json.Unmarshal(payload, &order)
+			expected := []identifier{{Type: "dns", Value: "example.com"}, {Type: "dns", Value: "www.example.com"}, {Type: "ip", Value: "192.0.2.1"}}
+			if !reflect.DeepEqual(order.Identifiers, expected) {
+				t.Errorf("Expected the identifiers %v, got %v", expected, order.Identifiers)
+			}
+
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{Status: "ready", Identifiers: order.Identifiers, Finalize: ts.URL + "/finalize/1"})
+		case "/finalize/1":
+			var msg csrMessage
+			json.Unmarshal(payload, &msg)
+			der, _ := base64.RawURLEncoding.DecodeString(msg.Csr)
+			if !bytes.Equal(der, csrDER) {
+				t.Error("Expected the order to be finalized with the CSR of the user")
+			}
+
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "example.com"},
+				DNSNames:     csr.DNSNames,
+				IPAddresses:  csr.IPAddresses,
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			certDER, _ := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+			certificate = pemEncode(derCertificateBytes(certDER))
+
+			writeJSONResponse(w, orderMessage{Status: "valid", Certificate: ts.URL + "/cert/1"})
+		case "/cert/1":
+			w.Write(certificate)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{email: "test@test.com", regres: &RegistrationResource{URI: ts.URL + "/account/1"}, privatekey: accountKey}
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	cert, err := client.ObtainCertificateForCSR(*csr, false)
+	if err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+
+	if !bytes.Equal(cert.Certificate, certificate) {
+		t.Error("Expected the issued certificate to be returned")
+	}
+	if len(cert.PrivateKey) != 0 {
+		t.Errorf("Expected no private key, got %s", cert.PrivateKey)
+	}
+	if !bytes.Equal(cert.CSR, pemEncode(csr)) {
+		t.Error("Expected the CSR to be kept for the renewals")
+	}
+
+	// a CSR without identifiers is rejected before creating an order.
+	emptyDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, csrKey)
+	empty, _ := x509.ParseCertificateRequest(emptyDER)
+	if _, err := client.ObtainCertificateForCSR(*empty, false); err == nil || err.Error() != "acme: the CSR contains no domain or IP address" {
+		t.Errorf("Expected a CSR without identifiers to be rejected, got %v", err)
+	}
+}
+
+// newIssuingTestServer returns an ACME server issuing the certificates of the CSRs of the finalized orders,
+// signed by a test CA, without authorizations to complete. The certificates are served with the CA certificate.
+func newIssuingTestServer(t *testing.T, accountKey *rsa.PrivateKey) *httptest.Server {
+	_, issuer, issuerKey := generateTestChain(t, nil)
+
+	var mu sync.Mutex
+	var serial int64
+	certificates := make(map[string][]byte)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Add("Replay-Nonce", "12345")
+
+		var payload []byte
+		if r.Method == http.MethodPost {
+			payload, _ = readJWS(t, r).Verify(&accountKey.PublicKey)
+		}
+
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{NewNonceURL: ts.URL + "/nonce", NewAccountURL: ts.URL + "/newAccount", NewOrderURL: ts.URL + "/newOrder"})
+		case "/nonce":
+		case "/newOrder":
+			var order orderMessage
+			json.Unmarshal(payload, &order)
+
+			w.Header().Add("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			writeJSONResponse(w, orderMessage{Status: "ready", Identifiers: order.Identifiers, Finalize: ts.URL + "/finalize/1"})
+		case "/finalize/1":
+			var msg csrMessage
+			json.Unmarshal(payload, &msg)
+			der, _ := base64.RawURLEncoding.DecodeString(msg.Csr)
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				t.Fatalf("Could not parse the CSR: %v", err)
+			}
+
+			serial++
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(serial + 1),
+				Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			certDER, _ := x509.CreateCertificate(rand.Reader, template, issuer, csr.PublicKey, issuerKey)
+			certURL := fmt.Sprintf("/cert/%d", serial)
+			certificates[certURL] = append(pemEncode(derCertificateBytes(certDER)), pemEncode(derCertificateBytes(issuer.Raw))...)
+
+			writeJSONResponse(w, orderMessage{Status: "valid", Certificate: ts.URL + certURL})
+		default:
+			if cert, ok := certificates[r.URL.Path]; ok {
+				w.Write(cert)
+				return
+			}
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+
+	return ts
+}
+
+func TestRenewCertificateWithOptionsReusePrivateKey(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	ts := newIssuingTestServer(t, accountKey)
+	defer ts.Close()
+
+	user := mockUser{email: "test@test.com", regres: &RegistrationResource{URI: ts.URL + "/account/1"}, privatekey: accountKey}
+	client, err := NewClient(ts.URL+"/directory", user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	cert, err := client.ObtainCertificate([]string{"example.com", "www.example.com"}, false, nil, false)
+	if err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+
+	publicKey := func(cert *CertificateResource) []byte {
+		x509Cert, err := parseLeafCertificate(cert.Certificate)
+		if err != nil {
+			t.Fatalf("Could not parse the certificate: %v", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(x509Cert.PublicKey)
+		if err != nil {
+			t.Fatalf("Could not marshal the public key: %v", err)
+		}
+		return der
+	}
+
+	renewed, err := client.RenewCertificateWithOptions(context.Background(), *cert, RenewOptions{ReusePrivateKey: true})
+	if err != nil {
+		t.Fatalf("Could not renew the certificate: %v", err)
+	}
+	if !bytes.Equal(publicKey(renewed), publicKey(cert)) {
+		t.Error("Expected the renewed certificate to have the public key of the old one")
+	}
+	if !bytes.Equal(renewed.PrivateKey, cert.PrivateKey) {
+		t.Error("Expected the private key to be kept")
+	}
+
+	// the key can also be passed directly, it must match the renewed certificate.
+	privKey, _ := parsePEMPrivateKey(cert.PrivateKey)
+	withoutKey := *cert
+	withoutKey.PrivateKey = nil
+	renewed, err = client.RenewCertificateWithOptions(context.Background(), withoutKey, RenewOptions{ReusePrivateKey: true, PrivateKey: privKey})
+	if err != nil {
+		t.Fatalf("Could not renew the certificate with the given key: %v", err)
+	}
+	if !bytes.Equal(publicKey(renewed), publicKey(cert)) {
+		t.Error("Expected the renewed certificate to have the public key of the given key")
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = client.RenewCertificateWithOptions(context.Background(), *cert, RenewOptions{ReusePrivateKey: true, PrivateKey: otherKey})
+	if err == nil || err.Error() != "[example.com] acme: Could not reuse the private key: the private key does not match the certificate" {
+		t.Errorf("Expected the other key to be rejected, got %v", err)
+	}
+
+	_, err = client.RenewCertificateWithOptions(context.Background(), withoutKey, RenewOptions{ReusePrivateKey: true})
+	if err == nil || err.Error() != "[example.com] acme: Could not reuse the private key: no private key to reuse" {
+		t.Errorf("Expected an error without key to reuse, got %v", err)
+	}
+
+	// without ReusePrivateKey, a new key is generated even if the old one is known.
+	renewed, err = client.RenewCertificateWithOptions(context.Background(), *cert, RenewOptions{})
+	if err != nil {
+		t.Fatalf("Could not renew the certificate: %v", err)
+	}
+	if bytes.Equal(publicKey(renewed), publicKey(cert)) {
+		t.Error("Expected a new key for the renewed certificate")
+	}
+}
+
+func TestSetOnCertificate(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	ts := newIssuingTestServer(t, accountKey)
+	defer ts.Close()
+
+	user := mockUser{email: "test@test.com", regres: &RegistrationResource{URI: ts.URL + "/account/1"}, privatekey: accountKey}
+	client, err := NewClient(ts.URL+"/directory", user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	var received []*CertificateResource
+	client.SetOnCertificate(func(cert *CertificateResource) error {
+		received = append(received, cert)
+		return nil
+	})
+
+	cert, err := client.ObtainCertificate([]string{"example.com", "www.example.com"}, true, nil, false)
+	if err != nil {
+		t.Fatalf("Could not obtain the certificate: %v", err)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("Expected the hook to be called once, got %d calls", len(received))
+	}
+	if received[0] != cert {
+		t.Error("Expected the hook to receive the obtained certificate")
+	}
+	if received[0].Domain != "example.com" {
+		t.Errorf("Expected the domain example.com, got %q", received[0].Domain)
+	}
+
+	certificates, err := parsePEMBundle(received[0].Certificate)
+	if err != nil {
+		t.Fatalf("Could not parse the bundle: %v", err)
+	}
+	if len(certificates) != 2 || certificates[0].Subject.CommonName != "example.com" || certificates[1].Subject.CommonName != "Test CA" {
+		t.Errorf("Expected the bundle of the certificate and its issuer, got %d certificates", len(certificates))
+	}
+
+	// the renewals call the hook once too.
+	if _, err = client.RenewCertificate(*cert, true, false); err != nil {
+		t.Fatalf("Could not renew the certificate: %v", err)
+	}
+	if len(received) != 2 {
+		t.Errorf("Expected the hook to be called once for the renewal, got %d calls", len(received)-1)
+	}
+
+	// a failing hook doesn't discard the issued certificate.
+	client.SetOnCertificate(func(cert *CertificateResource) error {
+		return errors.New("reload failed")
+	})
+
+	cert, err = client.ObtainCertificate([]string{"example.com"}, true, nil, false)
+	if cert == nil || len(cert.Certificate) == 0 {
+		t.Error("Expected the certificate to be returned when the hook fails")
+	}
+	hookErr, ok := err.(*CertificateHookError)
+	if !ok || hookErr.Domain != "example.com" || hookErr.Err.Error() != "reload failed" {
+		t.Errorf("Expected a CertificateHookError, got %v", err)
+	}
+}
+
+// recordingSolver records the challenges it solves as "domain type".
+type recordingSolver struct {
+	solved *[]string
+}
+
+func (s *recordingSolver) Solve(_ context.Context, chlng challenge, domain string) error {
+	*s.solved = append(*s.solved, domain+" "+chlng.Type)
+	return nil
+}
+
+func TestSolveChallengeForAuthzWithSelector(t *testing.T) {
+	var solved []string
+	client := &Client{solvers: map[Challenge]solver{
+		HTTP01:    &recordingSolver{solved: &solved},
+		DNS01:     &recordingSolver{solved: &solved},
+		TLSALPN01: &recordingSolver{solved: &solved},
+	}}
+
+	var selected []string
+	client.SetChallengeSelector(func(domain string, authz Authorization) (Challenge, ChallengeProvider) {
+		selected = append(selected, fmt.Sprintf("%s %s %t %v", domain, authz.Identifier, authz.Wildcard, authz.Challenges))
+		switch {
+		case authz.Wildcard:
+			return DNS01, nil
+		case domain == "www.example.com":
+			return HTTP01, nil
+		default:
+			return "", nil
+		}
+	})
+
+	allChallenges := []challenge{{Type: string(HTTP01)}, {Type: string(DNS01)}, {Type: string(TLSALPN01)}}
+	authorizations := []authorization{
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Wildcard: true, Challenges: []challenge{{Type: string(DNS01)}}},
+		{Identifier: identifier{Type: "dns", Value: "www.example.com"}, Challenges: allChallenges},
+		{Identifier: identifier{Type: "dns", Value: "api.example.com"}, Challenges: allChallenges},
+	}
+
+	if err := client.solveChallengeForAuthz(context.Background(), authorizations); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedSelected := []string{
+		"*.example.com example.com true [dns-01]",
+		"www.example.com www.example.com false [http-01 dns-01 tls-alpn-01]",
+		"api.example.com api.example.com false [http-01 dns-01 tls-alpn-01]",
+	}
+	if fmt.Sprint(selected) != fmt.Sprint(expectedSelected) {
+		t.Errorf("Expected the selector to be called with %v, got %v", expectedSelected, selected)
+	}
+
+	// api.example.com uses the default order.
+	expectedSolved := []string{"example.com dns-01", "www.example.com http-01", "api.example.com tls-alpn-01"}
+	if fmt.Sprint(solved) != fmt.Sprint(expectedSolved) {
+		t.Errorf("Expected the challenges %v to be solved, got %v", expectedSolved, solved)
+	}
+}
+
+func TestChooseSolverDefaultOrder(t *testing.T) {
+	var solved []string
+	httpSolver := &recordingSolver{solved: &solved}
+	client := &Client{solvers: map[Challenge]solver{
+		HTTP01: httpSolver,
+		DNS01:  &recordingSolver{solved: &solved},
+	}}
+
+	authz := authorization{
+		Identifier: identifier{Type: "dns", Value: "example.com"},
+		Challenges: []challenge{{Type: string(DNS01)}, {Type: string(TLSALPN01)}, {Type: string(HTTP01)}},
+	}
+
+	i, solver := client.chooseSolver(authz, "example.com")
+	if i != 2 || solver != httpSolver {
+		t.Errorf("Expected http-01 to be preferred to dns-01, got the challenge %d", i)
+	}
+}
+
+func TestChooseSolverSelectedProvider(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	client := &Client{jws: &jws{privKey: privKey}, solvers: map[Challenge]solver{}}
+
+	provider := &mockTimeoutProvider{}
+	client.SetChallengeSelector(func(domain string, authz Authorization) (Challenge, ChallengeProvider) {
+		if domain == "missing.example.com" {
+			return TLSALPN01, nil
+		}
+		return DNS01, provider
+	})
+
+	authz := authorization{
+		Identifier: identifier{Type: "dns", Value: "example.com"},
+		Challenges: []challenge{{Type: string(HTTP01)}, {Type: string(DNS01)}},
+	}
+
+	i, first := client.chooseSolver(authz, "example.com")
+	dns, ok := first.(*dnsChallenge)
+	if i != 1 || !ok || dns.provider != provider {
+		t.Fatalf("Expected a dns-01 solver with the selected provider, got the challenge %d and %#v", i, first)
+	}
+
+	// the dns-01 challenges of the same provider are solved together.
+	if _, second := client.chooseSolver(authz, "www.example.com"); second != first {
+		t.Error("Expected the solver of the provider to be reused")
+	}
+
+	// the selected challenge is not offered.
+	if _, solver := client.chooseSolver(authz, "missing.example.com"); solver != nil {
+		t.Errorf("Expected no solver, got %#v", solver)
+	}
+}
+
+func TestSetDNSPropagationCheckSelectedSolver(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+	dnsStablePolls = 1
+	var checks int
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		checks++
+		return true, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	client := &Client{jws: &jws{privKey: privKey}, solvers: map[Challenge]solver{}}
+
+	provider := &mockTimeoutProvider{timeout: time.Second, interval: time.Millisecond}
+	client.SetChallengeSelector(func(domain string, authz Authorization) (Challenge, ChallengeProvider) {
+		return DNS01, provider
+	})
+
+	authz := authorization{
+		Identifier: identifier{Type: "dns", Value: "example.com"},
+		Challenges: []challenge{{Type: string(DNS01), Token: "token"}},
+	}
+
+	_, selected := client.chooseSolver(authz, "example.com")
+	dns, ok := selected.(*dnsChallenge)
+	if !ok {
+		t.Fatalf("Expected a dns-01 solver, got %#v", selected)
+	}
+	dns.validate = stubValidate
+
+	if err := dns.Solve(context.Background(), authz.Challenges[0], "example.com"); err != nil {
+		t.Fatalf("Expected the challenge to be solved, got %v", err)
+	}
+	if checks != 1 {
+		t.Fatalf("Expected the propagation to be checked once, got %d checks", checks)
+	}
+
+	// the setting applies to the solver cached for the selected provider.
+	client.SetDNSPropagationCheck(false)
+	if err := dns.Solve(context.Background(), authz.Challenges[0], "example.com"); err != nil {
+		t.Fatalf("Expected the challenge to be solved, got %v", err)
+	}
+	if checks != 1 {
+		t.Errorf("Expected the propagation check to be skipped, got %d checks", checks)
+	}
+
+	client.SetDNSPropagationCheck(true)
+	if err := dns.Solve(context.Background(), authz.Challenges[0], "example.com"); err != nil {
+		t.Fatalf("Expected the challenge to be solved, got %v", err)
+	}
+	if checks != 2 {
+		t.Errorf("Expected the propagation check to be enabled again, got %d checks", checks)
+	}
+}
+
+func TestSolveChallengeForAuthzMultiValue(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	// each value is checked once.
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+	dnsStablePolls = 1
+	var mu sync.Mutex
+	var checked []string
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checked = append(checked, value)
+		return true, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+
+	var validated []string
+	provider := &mockMultiValueProvider{}
+	client := &Client{jws: j, solvers: map[Challenge]solver{
+		DNS01: &dnsChallenge{jws: j, provider: provider, validate: func(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+			validated = append(validated, chlng.Token)
+			return nil
+		}},
+	}}
+
+	authorizations := []authorization{
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Challenges: []challenge{{Type: string(DNS01), Token: "apex"}}},
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Challenges: []challenge{{Type: string(DNS01), Token: "wildcard"}}},
+		{Identifier: identifier{Type: "dns", Value: "www.example.com"}, Challenges: []challenge{{Type: string(DNS01), Token: "www"}}},
+	}
+
+	if err := client.solveChallengeForAuthz(context.Background(), authorizations); err != nil {
+		t.Fatalf("Unexpected error solving challenges: %v", err)
+	}
+
+	if len(provider.presented["example.com"]) != 2 {
+		t.Errorf("Expected both values of example.com to be presented together, got %v", provider.presented["example.com"])
+	}
+	if len(provider.cleaned["example.com"]) != 2 {
+		t.Errorf("Expected both values of example.com to be cleaned up together, got %v", provider.cleaned["example.com"])
+	}
+	if provider.single != 1 {
+		t.Errorf("Expected www.example.com to be presented with Present, got %d calls", provider.single)
+	}
+	if len(checked) != 3 {
+		t.Errorf("Expected propagation of 3 values to be checked, got %d", len(checked))
+	}
+	if strings.Join(validated, ",") != "apex,wildcard,www" {
+		t.Errorf("Unexpected validated challenges: %v", validated)
+	}
+}
+
+func TestSolveChallengeForAuthzWildcardOnly(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	// each value is checked once.
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+	dnsStablePolls = 1
+	var checked []string
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		checked = append(checked, fqdn)
+		return true, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+
+	provider := &mockFailingProvider{}
+	dnsSolver := &dnsChallenge{jws: j, provider: provider, validate: stubValidate}
+	client := &Client{jws: j, solvers: map[Challenge]solver{DNS01: dnsSolver}}
+
+	// the CA gives the authorization of *.example.com for the identifier example.com.
+	authorizations := []authorization{
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Wildcard: true, Challenges: []challenge{{Type: string(DNS01), Token: "wildcard"}}},
+	}
+
+	if err := client.solveChallengeForAuthz(context.Background(), authorizations); err != nil {
+		t.Fatalf("Unexpected error solving challenges: %v", err)
+	}
+
+	if !reflect.DeepEqual(provider.presented, []string{"example.com"}) || !reflect.DeepEqual(provider.cleaned, []string{"example.com"}) {
+		t.Errorf("Expected a single record of example.com to be presented and cleaned up, got %v and %v", provider.presented, provider.cleaned)
+	}
+	if !reflect.DeepEqual(checked, []string{"_acme-challenge.example.com."}) {
+		t.Errorf("Expected the propagation of _acme-challenge.example.com. to be checked, got %v", checked)
+	}
+
+	// the errors are reported for the requested wildcard.
+	dnsSolver.validate = failingValidate
+	err := client.solveChallengeForAuthz(context.Background(), authorizations)
+	if obtainErr, ok := err.(ObtainError); !ok || len(obtainErr) != 1 || obtainErr["*.example.com"] == nil {
+		t.Errorf("Expected an error for *.example.com, got %v", err)
+	}
+}
+
+func TestSolveChallengeForAuthzWildcardAndApex(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	// each value is checked once.
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+	dnsStablePolls = 1
+	var mu sync.Mutex
+	var checked []string
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checked = append(checked, fqdn)
+		return true, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+
+	provider := &mockMultiValueProvider{}
+	dnsSolver := &dnsChallenge{jws: j, provider: provider, validate: stubValidate}
+	client := &Client{jws: j, solvers: map[Challenge]solver{DNS01: dnsSolver}}
+
+	authorizations := []authorization{
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Wildcard: true, Challenges: []challenge{{Type: string(DNS01), Token: "wildcard"}}},
+		{Identifier: identifier{Type: "dns", Value: "example.com"}, Challenges: []challenge{{Type: string(DNS01), Token: "apex"}}},
+	}
+
+	if err := client.solveChallengeForAuthz(context.Background(), authorizations); err != nil {
+		t.Fatalf("Unexpected error solving challenges: %v", err)
+	}
+
+	// both values are presented in the single record of example.com.
+	wildcardKeyAuth, _ := KeyAuthorization("wildcard", &privKey.PublicKey)
+	apexKeyAuth, _ := KeyAuthorization("apex", &privKey.PublicKey)
+	if expected := []string{wildcardKeyAuth, apexKeyAuth}; !reflect.DeepEqual(provider.presented["example.com"], expected) {
+		t.Errorf("Expected the values %v to be presented together, got %v", expected, provider.presented)
+	}
+	if provider.single != 0 || len(provider.presented) != 1 {
+		t.Errorf("Expected a single record to be presented, got %d and %v", provider.single, provider.presented)
+	}
+	if !reflect.DeepEqual(checked, []string{"_acme-challenge.example.com.", "_acme-challenge.example.com."}) {
+		t.Errorf("Expected both values of _acme-challenge.example.com. to be checked, got %v", checked)
+	}
+
+	// the errors are reported for the domain, its record serving both.
+	dnsSolver.validate = failingValidate
+	err := client.solveChallengeForAuthz(context.Background(), authorizations)
+	if obtainErr, ok := err.(ObtainError); !ok || len(obtainErr) != 1 || obtainErr["example.com"] == nil {
+		t.Errorf("Expected an error for example.com, got %v", err)
+	}
+}
+
+func TestSolveChallengeForAuthzChecksPropagationConcurrently(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+
+	delays := map[string]time.Duration{
+		"_acme-challenge.a.example.com.": 100 * time.Millisecond,
+		"_acme-challenge.b.example.com.": 200 * time.Millisecond,
+		"_acme-challenge.c.example.com.": 300 * time.Millisecond,
+		"_acme-challenge.d.example.com.": 400 * time.Millisecond,
+	}
+
+	start := time.Now()
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		delay, ok := delays[fqdn]
+		if !ok {
+			return false, errors.New("SERVFAIL")
+		}
+		return time.Since(start) >= delay, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+
+	var validated []string
+	provider := &mockTimeoutProvider{timeout: 600 * time.Millisecond, interval: 10 * time.Millisecond}
+	client := &Client{jws: j, solvers: map[Challenge]solver{
+		DNS01: &dnsChallenge{jws: j, provider: provider, validate: func(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+			validated = append(validated, domain)
+			return nil
+		}},
+	}}
+
+	var authorizations []authorization
+	for _, domain := range []string{"d.example.com", "c.example.com", "fail.example.com", "b.example.com", "a.example.com"} {
+		authorizations = append(authorizations, authorization{
+			Identifier: identifier{Type: "dns", Value: domain},
+			Challenges: []challenge{{Type: string(DNS01), Token: domain}},
+		})
+	}
+
+	err := client.solveChallengeForAuthz(context.Background(), authorizations)
+	elapsed := time.Since(start)
+
+	obtainErr, ok := err.(ObtainError)
+	if !ok || len(obtainErr) != 1 || obtainErr["fail.example.com"] == nil {
+		t.Fatalf("Expected only fail.example.com to fail, got %v", err)
+	}
+
+	// the checks run concurrently: the slowest one (the timeout of fail.example.com) bounds the wall time,
+	// not the sum of them (1.6s).
+	if elapsed >= time.Second {
+		t.Errorf("Expected the propagation checks to run concurrently, took %v", elapsed)
+	}
+
+	if strings.Join(validated, ",") != "d.example.com,c.example.com,b.example.com,a.example.com" {
+		t.Errorf("Unexpected validated domains: %v", validated)
+	}
+	if !provider.presented || !provider.cleanedUp {
+		t.Error("Expected the records to be presented and cleaned up")
+	}
+}
+
+func TestCheckCertResponsePreferredChain(t *testing.T) {
+	defaultChain := generateNamedChain(t, "Root A", "Intermediate A")
+	alternateChain := generateNamedChain(t, "ISRG Root X1", "Intermediate B")
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cert":
+			w.Header().Add("Link", "<"+ts.URL+"/cert/missing>;rel=\"alternate\", <"+ts.URL+"/cert/1>;rel=\"alternate\"")
+			w.Write(defaultChain)
+		case "/cert/1":
+			w.Header().Add("Link", "<"+ts.URL+"/cert>;rel=\"alternate\"")
+			w.Write(alternateChain)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		preferred string
+		expected  []byte
+	}{
+		{preferred: "", expected: defaultChain},
+		{preferred: "Intermediate A", expected: defaultChain},
+		{preferred: "ISRG Root X1", expected: alternateChain},
+		{preferred: "Intermediate B", expected: alternateChain},
+		{preferred: "Unknown Root", expected: defaultChain},
+	}
+
+	for _, test := range testCases {
+		client := &Client{jws: &jws{}}
+		client.SetPreferredChain(test.preferred)
+
+		certRes := &CertificateResource{Domain: "example.com"}
+		ok, err := client.checkCertResponse(context.Background(), orderMessage{Status: "valid", Certificate: ts.URL + "/cert"}, certRes, true)
+		if err != nil || !ok {
+			t.Fatalf("Unexpected result for %q: %v, %v", test.preferred, ok, err)
+		}
+
+		if !bytes.Equal(certRes.Certificate, test.expected) {
+			t.Errorf("Unexpected chain selected for %q", test.preferred)
+		}
+	}
+}
+
+func TestParseLinkURLs(t *testing.T) {
+	links := []string{
+		`<https://example.com/acme/cert/1>;rel="alternate", <https://example.com/acme/issuer>;rel="up"`,
+		`<https://example.com/acme/cert/2>; rel="alternate"`,
+	}
+
+	alternates := parseLinkURLs(links, "alternate")
+	if strings.Join(alternates, ",") != "https://example.com/acme/cert/1,https://example.com/acme/cert/2" {
+		t.Errorf("Unexpected alternate links: %v", alternates)
+	}
+}
+
+// generateNamedChain returns a PEM encoded chain: a leaf, an intermediate and a root, with the given names.
+func generateNamedChain(t *testing.T, rootCN, intermediateCN string) []byte {
+	var chain []byte
+	var parent *x509.Certificate
+	var parentKey *rsa.PrivateKey
+
+	for i, cn := range []string{rootCN, intermediateCN, "example.com"} {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal("Error generating private key:", err)
+		}
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  i < 2,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal("Error generating certificate:", err)
+		}
+		parent, err = x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal("Error parsing certificate:", err)
+		}
+		parentKey = key
+
+		chain = append(pemEncode(derCertificateBytes(der)), chain...)
+	}
+
+	return chain
+}
+
+// writeJSONResponse marshals the body as JSON and writes it to the response.
+func writeJSONResponse(w http.ResponseWriter, body interface{}) {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(bs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// stubValidate is like validate, except it does nothing.
+func stubValidate(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+	return nil
+}
+
+type mockUser struct {
+	email      string
+	regres     *RegistrationResource
+	privatekey crypto.PrivateKey
+}
+
+func (u mockUser) GetEmail() string                       { return u.email }
+func (u mockUser) GetRegistration() *RegistrationResource { return u.regres }
+func (u mockUser) GetPrivateKey() crypto.PrivateKey       { return u.privatekey }
+
+// mockMultiValueProvider records the values passed to a ProviderMultiValue.
+type mockMultiValueProvider struct {
+	single    int
+	presented map[string][]string
+	cleaned   map[string][]string
+}
+
+func (p *mockMultiValueProvider) Present(domain, token, keyAuth string) error {
+	p.single++
+	return nil
+}
+
+func (p *mockMultiValueProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (p *mockMultiValueProvider) PresentMultiValue(domain string, keyAuths []string) error {
+	if p.presented == nil {
+		p.presented = make(map[string][]string)
+	}
+	p.presented[domain] = append(p.presented[domain], keyAuths...)
+	return nil
+}
+
+func (p *mockMultiValueProvider) CleanUpMultiValue(domain string, keyAuths []string) error {
+	if p.cleaned == nil {
+		p.cleaned = make(map[string][]string)
+	}
+	p.cleaned[domain] = append(p.cleaned[domain], keyAuths...)
+	return nil
+}
+
+// mockFailingProvider records the presented and cleaned up domains,
+// failing to present the records of failPresent and to clean up the records of failCleanUp.
+type mockFailingProvider struct {
+	failPresent, failCleanUp string
+	presented, cleaned       []string
+}
+
+func (p *mockFailingProvider) Present(domain, token, keyAuth string) error {
+	if domain == p.failPresent {
+		return errors.New("the zone is read-only")
+	}
+	p.presented = append(p.presented, domain)
+	return nil
+}
+
+func (p *mockFailingProvider) CleanUp(domain, token, keyAuth string) error {
+	p.cleaned = append(p.cleaned, domain)
+	if domain == p.failCleanUp {
+		return errors.New("the record is locked")
+	}
+	return nil
+}
+
+// newPollingTestServer starts a CA answering the finalization and the first polls of the order
+// with the processing status, before the order becomes valid. The Retry-After header, if any,
+// is sent with each processing order.
+func newPollingTestServer(t *testing.T, accountKey *rsa.PrivateKey, processingPolls int, retryAfter string) (*httptest.Server, *[]time.Time) {
+	chain, _, _ := generateTestChain(t, nil)
+
+	var mu sync.Mutex
+	var polls []time.Time
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Add("Replay-Nonce", "12345")
+
+		switch r.URL.Path {
+		case "/nonce":
+		case "/finalize/1":
+			if _, err := readJWS(t, r).Verify(&accountKey.PublicKey); err != nil {
+				t.Fatalf("Could not verify the finalization request: %v", err)
+			}
+			if retryAfter != "" {
+				w.Header().Add("Retry-After", retryAfter)
+			}
+			writeJSONResponse(w, orderMessage{Status: "processing"})
+		case "/order/1":
+			polls = append(polls, time.Now())
+			if len(polls) <= processingPolls {
+				if retryAfter != "" {
+					w.Header().Add("Retry-After", retryAfter)
+				}
+				writeJSONResponse(w, orderMessage{Status: "processing"})
+				return
+			}
+			writeJSONResponse(w, orderMessage{Status: "valid", Certificate: ts.URL + "/cert/1"})
+		case "/cert/1":
+			w.Write(chain)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+
+	return ts, &polls
+}
+
+func newPollingTestClient(ts *httptest.Server, accountKey *rsa.PrivateKey) (*Client, orderResource) {
+	client := &Client{jws: &jws{privKey: accountKey, getNonceURL: ts.URL + "/nonce", kid: ts.URL + "/account/1"}}
+	order := orderResource{
+		URL:          ts.URL + "/order/1",
+		Domains:      []string{"example.com"},
+		orderMessage: orderMessage{Finalize: ts.URL + "/finalize/1"},
+	}
+	return client, order
+}
+
+func TestRequestCertificateOrderPolling(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	ts, polls := newPollingTestServer(t, accountKey, 3, "")
+	defer ts.Close()
+
+	client, order := newPollingTestClient(ts, accountKey)
+	client.SetOrderPollInterval(10 * time.Millisecond)
+
+	cert, err := client.requestCertificateForCsr(context.Background(), order, false, []byte("csr"), nil)
+	if err != nil {
+		t.Fatalf("Could not get the certificate: %v", err)
+	}
+	if len(cert.Certificate) == 0 || cert.CertURL != ts.URL+"/cert/1" {
+		t.Errorf("Expected the certificate of %s, got %+v", ts.URL+"/cert/1", cert)
+	}
+	if len(*polls) != 4 {
+		t.Errorf("Expected the order to be polled 4 times, got %d", len(*polls))
+	}
+}
+
+func TestRequestCertificateOrderPollingRetryAfter(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	ts, polls := newPollingTestServer(t, accountKey, 1, "1")
+	defer ts.Close()
+
+	client, order := newPollingTestClient(ts, accountKey)
+	client.SetOrderPollInterval(10 * time.Millisecond)
+	client.SetOrderPollTimeout(10 * time.Second)
+
+	start := time.Now()
+	if _, err := client.requestCertificateForCsr(context.Background(), order, false, []byte("csr"), nil); err != nil {
+		t.Fatalf("Could not get the certificate: %v", err)
+	}
+
+	if len(*polls) != 2 {
+		t.Fatalf("Expected the order to be polled 2 times, got %d", len(*polls))
+	}
+	// the Retry-After of the finalization and of the processing order are honored.
+	if wait := (*polls)[0].Sub(start); wait < time.Second {
+		t.Errorf("Expected the first poll after the Retry-After of 1s, got %s", wait)
+	}
+	if wait := (*polls)[1].Sub((*polls)[0]); wait < time.Second {
+		t.Errorf("Expected the second poll after the Retry-After of 1s, got %s", wait)
+	}
+}
+
+func TestRequestCertificateOrderPollingTimeout(t *testing.T) {
+	accountKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	ts, _ := newPollingTestServer(t, accountKey, 1000, "")
+	defer ts.Close()
+
+	client, order := newPollingTestClient(ts, accountKey)
+	client.SetOrderPollInterval(10 * time.Millisecond)
+	client.SetOrderPollTimeout(100 * time.Millisecond)
+
+	_, err := client.requestCertificateForCsr(context.Background(), order, false, []byte("csr"), nil)
+
+	timeoutErr, ok := err.(OrderTimeoutError)
+	if !ok {
+		t.Fatalf("Expected an OrderTimeoutError, got %v", err)
+	}
+	if timeoutErr.OrderURL != ts.URL+"/order/1" || timeoutErr.Timeout != 100*time.Millisecond {
+		t.Errorf("Expected the timeout of the order %s after 100ms, got %+v", ts.URL+"/order/1", timeoutErr)
+	}
+	expected := "acme: the order " + ts.URL + "/order/1 was not valid after 100ms of polling"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
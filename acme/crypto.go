@@ -2,11 +2,13 @@ package acme
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -17,9 +19,12 @@ import (
 	"io"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ocsp"
 	jose "gopkg.in/square/go-jose.v2"
 )
@@ -35,6 +40,11 @@ const (
 	RSA2048 = KeyType("2048")
 	RSA4096 = KeyType("4096")
 	RSA8192 = KeyType("8192")
+	// EC521 is NIST P-521, for compliance requirements: some CAs, as Let's Encrypt, do not accept it.
+	EC521 = KeyType("P521")
+	// Ed25519 is only supported for the account keys, signing the requests with EdDSA:
+	// the CAs do not issue Ed25519 certificates.
+	Ed25519 = KeyType("Ed25519")
 )
 
 const (
@@ -48,6 +58,25 @@ const (
 	OCSPServerFailed = ocsp.ServerFailed
 )
 
+// Revocation reason codes as defined in RFC 5280 section 5.3.1.
+const (
+	RevocationReasonUnspecified          uint = 0
+	RevocationReasonKeyCompromise        uint = 1
+	RevocationReasonCACompromise         uint = 2
+	RevocationReasonAffiliationChanged   uint = 3
+	RevocationReasonSuperseded           uint = 4
+	RevocationReasonCessationOfOperation uint = 5
+	RevocationReasonCertificateHold      uint = 6
+	RevocationReasonRemoveFromCRL        uint = 8
+	RevocationReasonPrivilegeWithdrawn   uint = 9
+	RevocationReasonAACompromise         uint = 10
+)
+
+// isValidRevocationReason reports whether reason is one of the RFC 5280 reason codes (7 is unused).
+func isValidRevocationReason(reason uint) bool {
+	return reason <= RevocationReasonAACompromise && reason != 7
+}
+
 // Constants for OCSP must staple
 var (
 	tlsFeatureExtensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
@@ -73,7 +102,7 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 	// we have only one certificate so far, we need to get the issuer cert.
 	issuedCert := certificates[0]
 	if len(issuedCert.OCSPServer) == 0 {
-		return nil, nil, errors.New("no OCSP server specified in cert")
+		return nil, nil, NoOCSPServerError{Subject: issuedCert.Subject.CommonName}
 	}
 	if len(certificates) == 1 {
 		// TODO: build fallback. If this fails, check the remaining array entries.
@@ -81,7 +110,7 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 			return nil, nil, errors.New("no issuing certificate URL")
 		}
 
-		resp, err := httpGet(issuedCert.IssuingCertificateURL[0])
+		resp, err := httpGet(context.Background(), &HTTPClient, issuedCert.IssuingCertificateURL[0])
 		if err != nil {
 			return nil, nil, err
 		}
@@ -110,7 +139,7 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 	}
 
 	reader := bytes.NewReader(ocspReq)
-	req, err := httpPost(issuedCert.OCSPServer[0], "application/ocsp-request", reader)
+	req, err := httpPost(context.Background(), &HTTPClient, issuedCert.OCSPServer[0], "application/ocsp-request", reader)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -129,20 +158,25 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 	return ocspResBytes, ocspRes, nil
 }
 
-func getKeyAuthorization(token string, key interface{}) (string, error) {
-	var publicKey crypto.PublicKey
+// KeyAuthorization returns the key authorization of a challenge token for the account key (RFC 8555, section 8.1):
+// the token and the base64url SHA-256 JWK thumbprint of the key, as presented by lego.
+// The account private key is accepted as well. The TXT value of a dns-01 challenge is then given by DNS01Record.
+func KeyAuthorization(token string, key crypto.PublicKey) (string, error) {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+
 	switch k := key.(type) {
-	case *ecdsa.PrivateKey:
-		publicKey = k.Public()
-	case *rsa.PrivateKey:
-		publicKey = k.Public()
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	case ed25519.PublicKey:
+		// go-jose computes invalid thumbprints of the Ed25519 keys.
+		return token + "." + ed25519Thumbprint(k), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
 	}
 
 	// Generate the Key Authorization for the challenge
-	jwk := &jose.JSONWebKey{Key: publicKey}
-	if jwk == nil {
-		return "", errors.New("could not generate JWK from key")
-	}
+	jwk := &jose.JSONWebKey{Key: key}
 	thumbBytes, err := jwk.Thumbprint(crypto.SHA256)
 	if err != nil {
 		return "", err
@@ -154,6 +188,13 @@ func getKeyAuthorization(token string, key interface{}) (string, error) {
 	return token + "." + keyThumb, nil
 }
 
+// ed25519Thumbprint returns the unpadded base64url SHA-256 JWK thumbprint (RFC 7638, RFC 8037) of the Ed25519 public key.
+func ed25519Thumbprint(publicKey ed25519.PublicKey) string {
+	jwk := fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(publicKey))
+	thumbprint := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
 // parsePEMBundle parses a certificate bundle from top to bottom and returns
 // a slice of x509 certificates. This function will error if no certificates are found.
 func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
@@ -182,6 +223,91 @@ func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
 	return certificates, nil
 }
 
+// LeafPEM returns the PEM encoded leaf certificate, the first certificate of the bundle.
+func (c *CertificateResource) LeafPEM() ([]byte, error) {
+	leaf, _, err := splitPEMBundle(c.Certificate)
+	return leaf, err
+}
+
+// IssuerPEM returns the PEM encoded issuer chain: the certificates following the leaf in the bundle,
+// or IssuerCertificate if the certificate was not bundled. It is empty if the CA returned only the leaf.
+func (c *CertificateResource) IssuerPEM() ([]byte, error) {
+	_, issuers, err := splitPEMBundle(c.Certificate)
+	if err != nil || len(issuers) > 0 || len(c.IssuerCertificate) == 0 {
+		return issuers, err
+	}
+
+	if _, err := parsePEMBundle(c.IssuerCertificate); err != nil {
+		return nil, fmt.Errorf("invalid issuer certificate: %v", err)
+	}
+	return c.IssuerCertificate, nil
+}
+
+// DER returns the DER encoded certificates of the leaf and of its issuer chain,
+// as separate blocks in the order of the bundle: the leaf first.
+// The issuer chain is the one of IssuerPEM.
+func (c *CertificateResource) DER() ([][]byte, error) {
+	leaf, err := c.LeafPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	issuers, err := c.IssuerPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates [][]byte
+	for _, bundle := range [][]byte{leaf, issuers} {
+		for {
+			var block *pem.Block
+			block, bundle = pem.Decode(bundle)
+			if block == nil {
+				break
+			}
+
+			if block.Type == "CERTIFICATE" {
+				certificates = append(certificates, block.Bytes)
+			}
+		}
+	}
+
+	return certificates, nil
+}
+
+// splitPEMBundle splits a certificate bundle after its first certificate.
+// All the PEM blocks must be certificates.
+func splitPEMBundle(bundle []byte) ([]byte, []byte, error) {
+	var leaf, issuers []byte
+
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return nil, nil, fmt.Errorf("unexpected PEM block %q in the certificate bundle", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, nil, err
+		}
+
+		if leaf == nil {
+			leaf = pem.EncodeToMemory(block)
+		} else {
+			issuers = append(issuers, pem.EncodeToMemory(block)...)
+		}
+	}
+
+	if leaf == nil {
+		return nil, nil, errors.New("no certificates were found while parsing the bundle")
+	}
+
+	return leaf, issuers, nil
+}
+
 func parsePEMPrivateKey(key []byte) (crypto.PrivateKey, error) {
 	keyBlock, _ := pem.Decode(key)
 
@@ -190,11 +316,67 @@ func parsePEMPrivateKey(key []byte) (crypto.PrivateKey, error) {
 		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "PRIVATE KEY":
+		return ParseEd25519PrivateKey(keyBlock.Bytes)
 	default:
 		return nil, errors.New("unknown PEM header value")
 	}
 }
 
+// oidEd25519 is the algorithm identifier of the Ed25519 keys (RFC 8410).
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// ed25519SeedSize is the size of the seed of an Ed25519 private key, its first half.
+const ed25519SeedSize = 32
+
+// pkcs8 is the PKCS#8 structure of a private key (RFC 5208).
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// MarshalEd25519PrivateKey encodes the Ed25519 private key as PKCS#8 (RFC 8410), the content of a "PRIVATE KEY" PEM block.
+func MarshalEd25519PrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid Ed25519 private key size")
+	}
+
+	// The private key is the seed, in an octet string.
+	seed, err := asn1.Marshal(key[:ed25519SeedSize])
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs8{Algo: pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, PrivateKey: seed})
+}
+
+// ParseEd25519PrivateKey parses an Ed25519 private key encoded as PKCS#8 (RFC 8410).
+func ParseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var privKey pkcs8
+	if rest, err := asn1.Unmarshal(der, &privKey); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after the PKCS#8 private key")
+	}
+
+	if !privKey.Algo.Algorithm.Equal(oidEd25519) {
+		return nil, fmt.Errorf("unsupported PKCS#8 private key algorithm %v", privKey.Algo.Algorithm)
+	}
+
+	var seed []byte
+	if _, err := asn1.Unmarshal(privKey.PrivateKey, &seed); err != nil {
+		return nil, fmt.Errorf("invalid Ed25519 private key: %v", err)
+	}
+	if len(seed) != ed25519SeedSize {
+		return nil, fmt.Errorf("invalid Ed25519 private key size %d", len(seed))
+	}
+
+	// The key pair is derived from the seed read by GenerateKey.
+	_, key, err := ed25519.GenerateKey(bytes.NewReader(seed))
+	return key, err
+}
+
 func generatePrivateKey(keyType KeyType) (crypto.PrivateKey, error) {
 
 	switch keyType {
@@ -202,6 +384,8 @@ func generatePrivateKey(keyType KeyType) (crypto.PrivateKey, error) {
 		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	case EC384:
 		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case EC521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
 	case RSA2048:
 		return rsa.GenerateKey(rand.Reader, 2048)
 	case RSA4096:
@@ -213,13 +397,27 @@ func generatePrivateKey(keyType KeyType) (crypto.PrivateKey, error) {
 	return nil, fmt.Errorf("invalid KeyType: %s", keyType)
 }
 
+// generateCsr creates a CSR for the domain and the SANs. The IP addresses are set as IP SANs,
+// and are not used as common name.
 func generateCsr(privateKey crypto.PrivateKey, domain string, san []string, mustStaple bool) ([]byte, error) {
-	template := x509.CertificateRequest{
-		Subject: pkix.Name{CommonName: domain},
+	return x509.CreateCertificateRequest(rand.Reader, newCsrTemplate(domain, san, mustStaple), privateKey)
+}
+
+// newCsrTemplate returns the unsigned CSR of the domain (the common name, unless it is an IP address)
+// and its SANs, with the OCSP Must Staple extension if requested.
+func newCsrTemplate(domain string, san []string, mustStaple bool) *x509.CertificateRequest {
+	template := &x509.CertificateRequest{}
+
+	if net.ParseIP(domain) == nil {
+		template.Subject = pkix.Name{CommonName: domain}
 	}
 
-	if len(san) > 0 {
-		template.DNSNames = san
+	for _, name := range san {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
 	}
 
 	if mustStaple {
@@ -229,7 +427,72 @@ func generateCsr(privateKey crypto.PrivateKey, domain string, san []string, must
 		})
 	}
 
-	return x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
+	return template
+}
+
+// checkCsrIdentifiers checks that the names and IP addresses of the CSR,
+// including its common name, are exactly the given identifiers.
+func checkCsrIdentifiers(csr *x509.CertificateRequest, identifiers []string) error {
+	expected := make(map[string]bool)
+	for _, ident := range identifiers {
+		expected[normalizeIdentifier(ident)] = true
+	}
+
+	names := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		names[normalizeIdentifier(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		names[ip.String()] = true
+	}
+	if csr.Subject.CommonName != "" && !names[normalizeIdentifier(csr.Subject.CommonName)] {
+		return fmt.Errorf("acme: the common name %s of the CSR is not one of its SANs", csr.Subject.CommonName)
+	}
+
+	for name := range names {
+		if !expected[name] {
+			return fmt.Errorf("acme: the CSR contains %s, which is not an identifier of the order", name)
+		}
+	}
+	for ident := range expected {
+		if !names[ident] {
+			return fmt.Errorf("acme: the CSR does not contain the identifier %s of the order", ident)
+		}
+	}
+
+	return nil
+}
+
+// csrIdentifiers returns the identifiers of the CSR without duplicates:
+// its common name first, then its DNS names and IP addresses.
+func csrIdentifiers(csr *x509.CertificateRequest) []string {
+	var identifiers []string
+	seen := make(map[string]bool)
+
+	add := func(ident string) {
+		if ident != "" && !seen[normalizeIdentifier(ident)] {
+			seen[normalizeIdentifier(ident)] = true
+			identifiers = append(identifiers, ident)
+		}
+	}
+
+	add(csr.Subject.CommonName)
+	for _, name := range csr.DNSNames {
+		add(name)
+	}
+	for _, ip := range csr.IPAddresses {
+		add(ip.String())
+	}
+
+	return identifiers
+}
+
+// normalizeIdentifier returns the canonical form of an IP address, or the lower case name.
+func normalizeIdentifier(ident string) string {
+	if ip := net.ParseIP(ident); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(ident)
 }
 
 func pemEncode(data interface{}) []byte {
@@ -240,6 +503,9 @@ func pemEncode(data interface{}) []byte {
 		pemBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
 	case *rsa.PrivateKey:
 		pemBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case ed25519.PrivateKey:
+		keyBytes, _ := MarshalEd25519PrivateKey(key)
+		pemBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}
 	case *x509.CertificateRequest:
 		pemBlock = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: key.Raw}
 	case derCertificateBytes:
@@ -331,10 +597,15 @@ func generateDerCert(privKey *rsa.PrivateKey, expiration time.Time, domain strin
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment,
 		BasicConstraintsValid: true,
-		DNSNames:              []string{domain},
 		ExtraExtensions:       extensions,
 	}
 
+	if ip := net.ParseIP(domain); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{domain}
+	}
+
 	return x509.CreateCertificate(rand.Reader, &template, &template, &privKey.PublicKey, privKey)
 }
 
@@ -1,12 +1,16 @@
 package acme
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -20,10 +24,28 @@ var (
 	// the DNS challenge is ready.
 	PreCheckDNS preCheckDNSFunc = checkDNSPropagation
 	fqdnToZone                  = map[string]string{}
+	// fqdnToZoneMu guards fqdnToZone, the propagation of several domains is checked concurrently.
+	fqdnToZoneMu sync.RWMutex
 )
 
 const defaultResolvConf = "/etc/resolv.conf"
 
+const (
+	// cnameSupportEnvVar is the environment variable name that can be used to
+	// enable following the CNAME of the `_acme-challenge` record.
+	cnameSupportEnvVar = "LEGO_EXPERIMENTAL_CNAME_SUPPORT"
+
+	// maxCNAMEChainLength is the maximum number of CNAMEs followed, to avoid loops.
+	maxCNAMEChainLength = 10
+
+	// disableCPEnvVar is the environment variable name that can be used to
+	// skip the DNS propagation pre-check.
+	disableCPEnvVar = "LEGO_DISABLE_CP"
+)
+
+// propagationCheckWorkers is the maximum number of DNS propagation checks running concurrently.
+var propagationCheckWorkers = 10
+
 var defaultNameservers = []string{
 	"google-public-dns-a.google.com:53",
 	"google-public-dns-b.google.com:53",
@@ -35,6 +57,98 @@ var RecursiveNameservers = getNameservers(defaultResolvConf, defaultNameservers)
 // DNSTimeout is used to override the default DNS timeout of 10 seconds.
 var DNSTimeout = 10 * time.Second
 
+// DNSCheckStrategy selects the nameservers queried by the DNS propagation pre-check.
+type DNSCheckStrategy string
+
+const (
+	// DNSCheckAuthoritative queries the authoritative nameservers of the zone,
+	// found by a NS lookup of the zone apex. It is the default strategy.
+	DNSCheckAuthoritative DNSCheckStrategy = "authoritative"
+	// DNSCheckRecursive queries the recursive nameservers (see SetDNSResolvers),
+	// e.g. when the authoritative nameservers are not reachable from the client.
+	// The resolvers may cache the absence of the record until the negative TTL of the zone expires.
+	DNSCheckRecursive DNSCheckStrategy = "recursive"
+)
+
+// dnsCheckStrategy is the strategy of the DNS propagation pre-check.
+var dnsCheckStrategy = DNSCheckAuthoritative
+
+// defaultDNSStablePolls is the default number of consecutive polls which must see the record.
+const defaultDNSStablePolls = 2
+
+// dnsStablePolls is the number of consecutive polls of the DNS propagation pre-check which must see the record
+// before the CA is notified: the record of some providers briefly disappears during the reloads of the zone.
+var dnsStablePolls = defaultDNSStablePolls
+
+// SetDNSStablePolls sets the number of consecutive polls of the DNS propagation pre-check which must see
+// the record before the CA is notified, 2 by default. With 1, the CA is notified as soon as the record is seen.
+func SetDNSStablePolls(polls int) error {
+	if polls < 1 {
+		return fmt.Errorf("the number of stable polls must be at least 1, got %d", polls)
+	}
+	dnsStablePolls = polls
+	return nil
+}
+
+// authoritativeNameserverPort is the port of the authoritative nameservers, it is only replaced by the tests.
+var authoritativeNameserverPort = "53"
+
+// SetDNSCheckStrategy selects the nameservers queried by the DNS propagation pre-check.
+func SetDNSCheckStrategy(strategy DNSCheckStrategy) error {
+	switch strategy {
+	case DNSCheckAuthoritative, DNSCheckRecursive:
+		dnsCheckStrategy = strategy
+		return nil
+	default:
+		return fmt.Errorf("unsupported DNS check strategy %q, must be %s or %s", strategy, DNSCheckAuthoritative, DNSCheckRecursive)
+	}
+}
+
+// SetDNSResolvers replaces the recursive nameservers used to pre-check DNS propagations.
+// The resolvers are queried in the given order, each one must be a host or host:port,
+// the port defaults to 53.
+func SetDNSResolvers(resolvers []string) error {
+	if len(resolvers) == 0 {
+		return errors.New("no DNS resolvers given")
+	}
+
+	var nameservers []string
+	for _, resolver := range resolvers {
+		nameserver, err := parseNameserver(resolver)
+		if err != nil {
+			return err
+		}
+		nameservers = append(nameservers, nameserver)
+	}
+
+	RecursiveNameservers = nameservers
+	return nil
+}
+
+// parseNameserver validates the nameserver address and adds the default port if missing.
+func parseNameserver(nameserver string) (string, error) {
+	nameserver = strings.TrimSpace(nameserver)
+	if nameserver == "" {
+		return "", errors.New("empty DNS resolver")
+	}
+
+	host, port, err := net.SplitHostPort(nameserver)
+	if err != nil {
+		// no port: a hostname, an IPv4 or a (possibly bracketed) IPv6 address
+		host, port = strings.TrimSuffix(strings.TrimPrefix(nameserver, "["), "]"), "53"
+	}
+
+	if host == "" || strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+		return "", fmt.Errorf("invalid DNS resolver %q", nameserver)
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return "", fmt.Errorf("invalid port in DNS resolver %q", nameserver)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
 // getNameservers attempts to get systems nameservers before falling back to the defaults
 func getNameservers(path string, defaults []string) []string {
 	config, err := dns.ClientConfigFromFile(path)
@@ -54,24 +168,118 @@ func getNameservers(path string, defaults []string) []string {
 	return systemNameservers
 }
 
-// DNS01Record returns a DNS record which will fulfill the `dns-01` challenge
+// DNS01Record returns a DNS record which will fulfill the `dns-01` challenge.
+// keyAuth is the key authorization of the challenge, as returned by KeyAuthorization.
+// A wildcard domain uses the record of its base domain: the record of *.example.com
+// is _acme-challenge.example.com, the same as the record of example.com.
+// If the LEGO_EXPERIMENTAL_CNAME_SUPPORT environment variable is set to a true value,
+// the CNAME chain of the `_acme-challenge` record is followed and the fqdn of its
+// final target is returned, allowing the challenge to be delegated to another zone.
 func DNS01Record(domain, keyAuth string) (fqdn string, value string, ttl int) {
 	keyAuthShaBytes := sha256.Sum256([]byte(keyAuth))
 	// base64URL encoding without padding
 	value = base64.RawURLEncoding.EncodeToString(keyAuthShaBytes[:sha256.Size])
 	ttl = 120
-	fqdn = fmt.Sprintf("_acme-challenge.%s.", domain)
+	fqdn = fmt.Sprintf("_acme-challenge.%s.", strings.TrimPrefix(domain, "*."))
+
+	if ok, _ := strconv.ParseBool(os.Getenv(cnameSupportEnvVar)); ok {
+		fqdn = followCNAMEs(fqdn, RecursiveNameservers)
+	}
 	return
 }
 
+// followCNAMEs resolves the CNAME chain of fqdn and returns its final target.
+// The fqdn itself is returned if it is not an alias or cannot be resolved.
+func followCNAMEs(fqdn string, nameservers []string) string {
+	for i := 0; i < maxCNAMEChainLength; i++ {
+		r, err := dnsQuery(fqdn, dns.TypeCNAME, nameservers, true)
+		if err != nil || r.Rcode != dns.RcodeSuccess {
+			return fqdn
+		}
+
+		target := cnameTarget(r, fqdn)
+		if target == "" || target == fqdn {
+			return fqdn
+		}
+
+		log.Infof("[%s] acme: Following CNAME to %s", UnFqdn(fqdn), target)
+		fqdn = target
+	}
+
+	return fqdn
+}
+
+// cnameTarget returns the target of the CNAME record of fqdn found in msg, if any.
+func cnameTarget(msg *dns.Msg, fqdn string) string {
+	for _, rr := range msg.Answer {
+		if cn, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cn.Hdr.Name, fqdn) {
+			return cn.Target
+		}
+	}
+	return ""
+}
+
 // dnsChallenge implements the dns-01 challenge according to ACME 7.5
 type dnsChallenge struct {
-	jws      *jws
-	validate validateFunc
-	provider ChallengeProvider
+	jws       *jws
+	validate  validateFunc
+	provider  ChallengeProvider
+	disableCP bool
+	// keepOnFailure keeps the records presented if the challenge fails, for debugging.
+	keepOnFailure bool
+}
+
+// SetDisableCP disables (or re-enables) the DNS propagation pre-check of the solver.
+func (s *dnsChallenge) SetDisableCP(disable bool) {
+	s.disableCP = disable
+}
+
+// propagationCheckDisabled reports whether the DNS propagation pre-check must be skipped,
+// either by the solver setting or by the LEGO_DISABLE_CP environment variable.
+func (s *dnsChallenge) propagationCheckDisabled() bool {
+	if s.disableCP {
+		return true
+	}
+	disabled, _ := strconv.ParseBool(os.Getenv(disableCPEnvVar))
+	return disabled
+}
+
+// waitForPropagation waits until the TXT record is propagated, unless the pre-check is disabled.
+func (s *dnsChallenge) waitForPropagation(ctx context.Context, domain, fqdn, value string) error {
+	if s.propagationCheckDisabled() {
+		log.Infof("[%s] Skipping DNS record propagation check", domain)
+		return nil
+	}
+
+	if dnsCheckStrategy == DNSCheckRecursive {
+		log.Infof("[%s] Checking DNS record propagation using the recursive nameservers %+v", domain, RecursiveNameservers)
+	} else {
+		log.Infof("[%s] Checking DNS record propagation using the authoritative nameservers", domain)
+	}
+
+	timeout, interval := s.timeout()
+
+	// the record must be seen by consecutive polls, it is not stable if it disappears in between.
+	polls, seen := dnsStablePolls, 0
+	return waitFor(ctx, timeout, interval, func() (bool, error) {
+		ok, err := PreCheckDNS(fqdn, value)
+		if !ok {
+			if seen > 0 {
+				log.Infof("[%s] The DNS record %s disappeared after being seen, waiting for it to be stable", domain, fqdn)
+			}
+			seen = 0
+			return false, err
+		}
+
+		seen++
+		if seen < polls {
+			return false, fmt.Errorf("the record was only seen by %d of %d consecutive polls", seen, polls)
+		}
+		return true, nil
+	})
 }
 
-func (s *dnsChallenge) Solve(chlng challenge, domain string) error {
+func (s *dnsChallenge) Solve(ctx context.Context, chlng challenge, domain string) (err error) {
 	log.Infof("[%s] acme: Trying to solve DNS-01", domain)
 
 	if s.provider == nil {
@@ -79,7 +287,7 @@ func (s *dnsChallenge) Solve(chlng challenge, domain string) error {
 	}
 
 	// Generate the Key Authorization for the challenge
-	keyAuth, err := getKeyAuthorization(chlng.Token, s.jws.privKey)
+	keyAuth, err := KeyAuthorization(chlng.Token, s.jws.privKey)
 	if err != nil {
 		return err
 	}
@@ -88,37 +296,206 @@ func (s *dnsChallenge) Solve(chlng challenge, domain string) error {
 	if err != nil {
 		return fmt.Errorf("error presenting token: %s", err)
 	}
+
+	fqdn, value, _ := DNS01Record(domain, keyAuth)
+
 	defer func() {
-		err := s.provider.CleanUp(domain, chlng.Token, keyAuth)
-		if err != nil {
+		if skipCleanUp(s.keepOnFailure, err, domain, fmt.Sprintf("the TXT record %s with the value %q", fqdn, value)) {
+			return
+		}
+		if err := s.provider.CleanUp(domain, chlng.Token, keyAuth); err != nil {
 			log.Warnf("Error cleaning up %s: %v ", domain, err)
 		}
 	}()
 
-	fqdn, value, _ := DNS01Record(domain, keyAuth)
+	err = s.waitForPropagation(ctx, domain, fqdn, value)
+	if err != nil {
+		return err
+	}
+
+	return s.validate(ctx, s.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+}
 
-	log.Infof("[%s] Checking DNS record propagation using %+v", domain, RecursiveNameservers)
+// supportsMultiValue reports whether the provider can present several values for the same record.
+func (s *dnsChallenge) supportsMultiValue() bool {
+	_, ok := s.provider.(ProviderMultiValue)
+	return ok
+}
 
-	var timeout, interval time.Duration
-	switch provider := s.provider.(type) {
-	case ChallengeProviderTimeout:
-		timeout, interval = provider.Timeout()
-	default:
-		timeout, interval = 60*time.Second, 2*time.Second
+// dnsChallengeGroup holds the dns-01 challenges of a domain which are presented at the same time.
+type dnsChallengeGroup struct {
+	domain   string
+	chlngs   []challenge
+	keyAuths []string
+}
+
+// SolveGroups solves the groups of challenges at once: the records of all the groups are presented,
+// their propagation is checked concurrently, then the challenges are validated and the records cleaned up.
+// If a record cannot be presented, the records already presented are cleaned up without being validated.
+// A group of several challenges requires a ProviderMultiValue. The errors of the challenges
+// and the errors of the cleanups are returned separately, by domain.
+func (s *dnsChallenge) SolveGroups(ctx context.Context, groups []*dnsChallengeGroup) (failures, cleanUpFailures map[string]error) {
+	failures = make(map[string]error)
+	cleanUpFailures = make(map[string]error)
+
+	if s.provider == nil {
+		for _, group := range groups {
+			failures[group.domain] = errors.New("no DNS Provider configured")
+		}
+		return failures, cleanUpFailures
 	}
 
-	err = WaitFor(timeout, interval, func() (bool, error) {
-		return PreCheckDNS(fqdn, value)
-	})
+	var presented []*dnsChallengeGroup
+	defer func() {
+		for _, group := range presented {
+			if skipCleanUp(s.keepOnFailure, failures[group.domain], group.domain, describeTXTRecords(group.domain, group.keyAuths)) {
+				continue
+			}
+			if err := s.cleanUp(group); err != nil {
+				log.Warnf("Error cleaning up %s: %v ", group.domain, err)
+				cleanUpFailures[group.domain] = err
+			}
+		}
+	}()
+
+	for _, group := range groups {
+		log.Infof("[%s] acme: Trying to solve DNS-01", group.domain)
+
+		err := s.present(group)
+		if err != nil {
+			// the certificate cannot be issued anymore, don't validate the other challenges.
+			failures[group.domain] = err
+			return failures, cleanUpFailures
+		}
+		presented = append(presented, group)
+	}
+
+	var checked []*dnsChallengeGroup
+	for i, err := range s.waitForGroupsPropagation(ctx, presented) {
+		if err != nil {
+			failures[presented[i].domain] = err
+			continue
+		}
+		checked = append(checked, presented[i])
+	}
+
+	for _, group := range checked {
+		for i, chlng := range group.chlngs {
+			err := s.validate(ctx, s.jws, group.domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: group.keyAuths[i]})
+			if err != nil {
+				failures[group.domain] = err
+				break
+			}
+		}
+	}
+
+	return failures, cleanUpFailures
+}
+
+// present computes the key authorizations of the group and presents its records.
+func (s *dnsChallenge) present(group *dnsChallengeGroup) error {
+	group.keyAuths = nil
+	for _, chlng := range group.chlngs {
+		// Generate the Key Authorization for the challenge
+		keyAuth, err := KeyAuthorization(chlng.Token, s.jws.privKey)
+		if err != nil {
+			return err
+		}
+		group.keyAuths = append(group.keyAuths, keyAuth)
+	}
+
+	var err error
+	if len(group.chlngs) == 1 {
+		err = s.provider.Present(group.domain, group.chlngs[0].Token, group.keyAuths[0])
+	} else if provider, ok := s.provider.(ProviderMultiValue); ok {
+		err = provider.PresentMultiValue(group.domain, group.keyAuths)
+	} else {
+		return errors.New("the DNS Provider does not support multiple values")
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("error presenting token: %s", err)
+	}
+
+	return nil
+}
+
+// describeTXTRecords describes the TXT records of the key authorizations, to remove them manually.
+func describeTXTRecords(domain string, keyAuths []string) string {
+	var values []string
+	var fqdn string
+	for _, keyAuth := range keyAuths {
+		var value string
+		fqdn, value, _ = DNS01Record(domain, keyAuth)
+		values = append(values, strconv.Quote(value))
+	}
+	return fmt.Sprintf("the TXT records %s with the values %s", fqdn, strings.Join(values, ", "))
+}
+
+// cleanUp removes the records of the group.
+func (s *dnsChallenge) cleanUp(group *dnsChallengeGroup) error {
+	var err error
+	if len(group.chlngs) == 1 {
+		err = s.provider.CleanUp(group.domain, group.chlngs[0].Token, group.keyAuths[0])
+	} else {
+		err = s.provider.(ProviderMultiValue).CleanUpMultiValue(group.domain, group.keyAuths)
+	}
+	if err != nil {
+		return fmt.Errorf("error cleaning up token: %s", err)
 	}
 
-	return s.validate(s.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return nil
 }
 
-// checkDNSPropagation checks if the expected TXT record has been propagated to all authoritative nameservers.
+// waitForGroupsPropagation checks the propagation of the records of the groups concurrently,
+// with at most propagationCheckWorkers checks at a time. The errors are returned in the order of the groups.
+func (s *dnsChallenge) waitForGroupsPropagation(ctx context.Context, groups []*dnsChallengeGroup) []error {
+	errs := make([]error, len(groups))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < propagationCheckWorkers && w < len(groups); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				group := groups[i]
+				for _, keyAuth := range group.keyAuths {
+					fqdn, value, _ := DNS01Record(group.domain, keyAuth)
+					if err := s.waitForPropagation(ctx, group.domain, fqdn, value); err != nil {
+						errs[i] = fmt.Errorf("[%s] %v", group.domain, err)
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	for i := range groups {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
+
+// timeout returns the propagation timeout and interval of the provider, or the defaults.
+func (s *dnsChallenge) timeout() (timeout, interval time.Duration) {
+	switch provider := s.provider.(type) {
+	case ChallengeProviderTimeout:
+		return provider.Timeout()
+	default:
+		return 60 * time.Second, 2 * time.Second
+	}
+}
+
+// checkDNSPropagation checks if the expected TXT record has been propagated to all the nameservers
+// of the DNS check strategy: the authoritative nameservers or the recursive ones.
 func checkDNSPropagation(fqdn, value string) (bool, error) {
+	if dnsCheckStrategy == DNSCheckRecursive {
+		return checkRecursiveNss(fqdn, value, RecursiveNameservers)
+	}
+
 	// Initial attempt to resolve at the recursive NS
 	r, err := dnsQuery(fqdn, dns.TypeTXT, RecursiveNameservers, true)
 	if err != nil {
@@ -147,31 +524,47 @@ func checkDNSPropagation(fqdn, value string) (bool, error) {
 // checkAuthoritativeNss queries each of the given nameservers for the expected TXT record.
 func checkAuthoritativeNss(fqdn, value string, nameservers []string) (bool, error) {
 	for _, ns := range nameservers {
-		r, err := dnsQuery(fqdn, dns.TypeTXT, []string{net.JoinHostPort(ns, "53")}, false)
-		if err != nil {
+		addr := net.JoinHostPort(strings.TrimSuffix(ns, "."), authoritativeNameserverPort)
+		if err := checkNameserverTXT(fqdn, value, ns, addr, false); err != nil {
 			return false, err
 		}
+	}
 
-		if r.Rcode != dns.RcodeSuccess {
-			return false, fmt.Errorf("NS %s returned %s for %s", ns, dns.RcodeToString[r.Rcode], fqdn)
-		}
+	return true, nil
+}
 
-		var found bool
-		for _, rr := range r.Answer {
-			if txt, ok := rr.(*dns.TXT); ok {
-				if strings.Join(txt.Txt, "") == value {
-					found = true
-					break
-				}
-			}
+// checkRecursiveNss queries each of the given recursive nameservers, in the host:port form,
+// for the expected TXT record.
+func checkRecursiveNss(fqdn, value string, nameservers []string) (bool, error) {
+	for _, ns := range nameservers {
+		if err := checkNameserverTXT(fqdn, value, ns, ns, true); err != nil {
+			return false, err
 		}
+	}
 
-		if !found {
-			return false, fmt.Errorf("NS %s did not return the expected TXT record", ns)
+	return true, nil
+}
+
+// checkNameserverTXT queries the nameserver ns at the address addr for the expected TXT record.
+func checkNameserverTXT(fqdn, value, ns, addr string, recursive bool) error {
+	r, err := dnsQuery(fqdn, dns.TypeTXT, []string{addr}, recursive)
+	if err != nil {
+		return err
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("NS %s returned %s for %s", ns, dns.RcodeToString[r.Rcode], fqdn)
+	}
+
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			if strings.Join(txt.Txt, "") == value {
+				return nil
+			}
 		}
 	}
 
-	return true, nil
+	return fmt.Errorf("NS %s did not return the expected TXT record", ns)
 }
 
 // dnsQuery will query a nameserver, iterating through the supplied servers as it retries
@@ -185,8 +578,9 @@ func dnsQuery(fqdn string, rtype uint16, nameservers []string, recursive bool) (
 		m.RecursionDesired = false
 	}
 
-	// Will retry the request based on the number of servers (n+1)
-	for i := 1; i <= len(nameservers)+1; i++ {
+	// Will retry the request based on the number of servers (n+1),
+	// starting with the first one.
+	for i := 0; i <= len(nameservers); i++ {
 		ns := nameservers[i%len(nameservers)]
 		udp := &dns.Client{Net: "udp", Timeout: DNSTimeout}
 		in, _, err = udp.Exchange(m, ns)
@@ -234,7 +628,10 @@ func lookupNameservers(fqdn string) ([]string, error) {
 // domain labels until the nameserver returns a SOA record in the answer section.
 func FindZoneByFqdn(fqdn string, nameservers []string) (string, error) {
 	// Do we have it cached?
-	if zone, ok := fqdnToZone[fqdn]; ok {
+	fqdnToZoneMu.RLock()
+	zone, ok := fqdnToZone[fqdn]
+	fqdnToZoneMu.RUnlock()
+	if ok {
 		return zone, nil
 	}
 
@@ -265,7 +662,9 @@ func FindZoneByFqdn(fqdn string, nameservers []string) (string, error) {
 			for _, ans := range in.Answer {
 				if soa, ok := ans.(*dns.SOA); ok {
 					zone := soa.Hdr.Name
+					fqdnToZoneMu.Lock()
 					fqdnToZone[fqdn] = zone
+					fqdnToZoneMu.Unlock()
 					return zone, nil
 				}
 			}
@@ -287,7 +686,9 @@ func dnsMsgContainsCNAME(msg *dns.Msg) bool {
 
 // ClearFqdnCache clears the cache of fqdn to zone mappings. Primarily used in testing.
 func ClearFqdnCache() {
+	fqdnToZoneMu.Lock()
 	fqdnToZone = map[string]string{}
+	fqdnToZoneMu.Unlock()
 }
 
 // ToFqdn converts the name into a fqdn appending a trailing dot.
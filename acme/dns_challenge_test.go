@@ -2,16 +2,22 @@ package acme
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 var lookupNameserversTestsOK = []struct {
@@ -111,11 +117,114 @@ func TestDNSValidServerResponse(t *testing.T) {
 		f.WriteString("\n")
 	}()
 
-	if err := solver.Solve(clientChallenge, "example.com"); err != nil {
+	if err := solver.Solve(context.Background(), clientChallenge, "example.com"); err != nil {
 		t.Errorf("VALID: Expected Solve to return no error but the error was -> %v", err)
 	}
 }
 
+func TestDNSSolveWithoutPropagationCheck(t *testing.T) {
+	var queries int32
+	addr, shutdown := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	defer shutdown()
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{addr}
+
+	defer func(check preCheckDNSFunc) { PreCheckDNS = check }(PreCheckDNS)
+	PreCheckDNS = checkDNSPropagation
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &mockTimeoutProvider{timeout: time.Hour, interval: time.Hour}
+
+	var validated bool
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, provider: provider, validate: func(_ context.Context, j *jws, domain, uri string, chlng challenge) error {
+		validated = true
+		return nil
+	}}
+	solver.SetDisableCP(true)
+
+	err := solver.Solve(context.Background(), challenge{Type: string(DNS01), Token: "token"}, "example.com")
+	if err != nil {
+		t.Fatalf("Expected Solve to return no error but the error was -> %v", err)
+	}
+	if !validated {
+		t.Error("Expected the challenge to be validated")
+	}
+	if !provider.presented || !provider.cleanedUp {
+		t.Error("Expected the record to be presented and cleaned up")
+	}
+	if n := atomic.LoadInt32(&queries); n != 0 {
+		t.Errorf("Expected no DNS queries, got %d", n)
+	}
+
+	os.Setenv(disableCPEnvVar, "1")
+	defer os.Unsetenv(disableCPEnvVar)
+
+	solver.SetDisableCP(false)
+	if !solver.propagationCheckDisabled() {
+		t.Errorf("Expected %s to disable the propagation check", disableCPEnvVar)
+	}
+}
+
+type mockTimeoutProvider struct {
+	timeout, interval    time.Duration
+	presented, cleanedUp bool
+}
+
+func (p *mockTimeoutProvider) Present(domain, token, keyAuth string) error {
+	p.presented = true
+	return nil
+}
+
+func (p *mockTimeoutProvider) CleanUp(domain, token, keyAuth string) error {
+	p.cleanedUp = true
+	return nil
+}
+
+func (p *mockTimeoutProvider) Timeout() (time.Duration, time.Duration) {
+	return p.timeout, p.interval
+}
+
+type mockRecordingProvider struct {
+	mockTimeoutProvider
+	keyAuth string
+}
+
+func (p *mockRecordingProvider) Present(domain, token, keyAuth string) error {
+	p.keyAuth = keyAuth
+	return p.mockTimeoutProvider.Present(domain, token, keyAuth)
+}
+
+func TestKeyAuthorizationMatchesSolve(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &mockRecordingProvider{}
+
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, provider: provider, validate: stubValidate, disableCP: true}
+
+	err := solver.Solve(context.Background(), challenge{Type: string(DNS01), Token: "token"}, "example.com")
+	if err != nil {
+		t.Fatalf("Expected Solve to return no error but the error was -> %v", err)
+	}
+
+	keyAuth, err := KeyAuthorization("token", &privKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if keyAuth != provider.keyAuth {
+		t.Errorf("Expected the key authorization %s, got %s", provider.keyAuth, keyAuth)
+	}
+
+	_, presented, _ := DNS01Record("example.com", provider.keyAuth)
+	if _, value, _ := DNS01Record("example.com", keyAuth); value != presented {
+		t.Errorf("Expected the TXT value %s, got %s", presented, value)
+	}
+}
+
 func TestPreCheckDNS(t *testing.T) {
 	ok, err := PreCheckDNS("acme-staging.api.letsencrypt.org", "fe01=")
 	if err != nil || !ok {
@@ -198,3 +307,385 @@ func TestResolveConfServers(t *testing.T) {
 		}
 	}
 }
+
+func TestDNS01RecordWildcard(t *testing.T) {
+	fqdn, value, _ := DNS01Record("*.example.com", "keyAuth")
+	if fqdn != "_acme-challenge.example.com." {
+		t.Errorf("Expected the record of the wildcard to be _acme-challenge.example.com., got %s", fqdn)
+	}
+
+	if _, apexValue, _ := DNS01Record("example.com", "keyAuth"); value != apexValue {
+		t.Errorf("Expected the value %s of the base domain, got %s", apexValue, value)
+	}
+}
+
+func TestDNS01RecordFollowsCNAME(t *testing.T) {
+	addr, shutdown := runStubDNSServer(t, map[string]string{
+		"_acme-challenge.example.com.":   "_acme-challenge.delegated.org.",
+		"_acme-challenge.delegated.org.": "challenges.delegated.org.",
+	})
+	defer shutdown()
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{addr}
+
+	fqdn, _, _ := DNS01Record("example.com", "keyAuth")
+	if fqdn != "_acme-challenge.example.com." {
+		t.Errorf("Expected CNAME to be ignored by default, got %s", fqdn)
+	}
+
+	os.Setenv(cnameSupportEnvVar, "1")
+	defer os.Unsetenv(cnameSupportEnvVar)
+
+	fqdn, _, _ = DNS01Record("example.com", "keyAuth")
+	if fqdn != "challenges.delegated.org." {
+		t.Errorf("Expected CNAME chain to be followed to challenges.delegated.org., got %s", fqdn)
+	}
+
+	fqdn, _, _ = DNS01Record("www.example.com", "keyAuth")
+	if fqdn != "_acme-challenge.www.example.com." {
+		t.Errorf("Expected fqdn without CNAME to be kept, got %s", fqdn)
+	}
+}
+
+func TestSetDNSResolvers(t *testing.T) {
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+
+	testCases := []struct {
+		resolvers []string
+		expected  []string
+	}{
+		{resolvers: []string{"8.8.8.8"}, expected: []string{"8.8.8.8:53"}},
+		{resolvers: []string{"8.8.8.8:5353", "ns.example.com"}, expected: []string{"8.8.8.8:5353", "ns.example.com:53"}},
+		{resolvers: []string{"2001:4860:4860::8888"}, expected: []string{"[2001:4860:4860::8888]:53"}},
+		{resolvers: []string{"[2001:4860:4860::8888]"}, expected: []string{"[2001:4860:4860::8888]:53"}},
+		{resolvers: []string{"[2001:4860:4860::8888]:5353"}, expected: []string{"[2001:4860:4860::8888]:5353"}},
+	}
+
+	for _, test := range testCases {
+		err := SetDNSResolvers(test.resolvers)
+		if err != nil {
+			t.Errorf("Expected %v to be valid, got %v", test.resolvers, err)
+			continue
+		}
+		if strings.Join(RecursiveNameservers, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected nameservers %v for %v, got %v", test.expected, test.resolvers, RecursiveNameservers)
+		}
+	}
+
+	invalid := [][]string{nil, {""}, {":53"}, {"8.8.8.8:0"}, {"8.8.8.8:dns"}, {"8.8.8.8:70000"}, {"2001:db8::zz"}}
+	for _, resolvers := range invalid {
+		RecursiveNameservers = []string{"keep:53"}
+		if err := SetDNSResolvers(resolvers); err == nil {
+			t.Errorf("Expected %v to be rejected", resolvers)
+		}
+		if RecursiveNameservers[0] != "keep:53" {
+			t.Errorf("Expected nameservers to be unchanged after rejecting %v, got %v", resolvers, RecursiveNameservers)
+		}
+	}
+}
+
+func TestDNSQueryUsesFirstResolverFirst(t *testing.T) {
+	first, shutdownFirst := runStubDNSServer(t, map[string]string{"example.com.": "first.example.org."})
+	defer shutdownFirst()
+	second, shutdownSecond := runStubDNSServer(t, map[string]string{"example.com.": "second.example.org."})
+	defer shutdownSecond()
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	if err := SetDNSResolvers([]string{first, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := dnsQuery("example.com.", dns.TypeCNAME, RecursiveNameservers, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := cnameTarget(in, "example.com."); target != "first.example.org." {
+		t.Errorf("Expected the first resolver to answer, got %q", target)
+	}
+}
+
+func TestCheckDNSPropagationStrategy(t *testing.T) {
+	fqdn, value, _ := DNS01Record("example.com", "keyAuth")
+
+	authoritative, shutdownAuthoritative := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		if q.Name == fqdn && q.Qtype == dns.TypeTXT {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120},
+				Txt: []string{value},
+			})
+		}
+
+		w.WriteMsg(m)
+	})
+	defer shutdownAuthoritative()
+
+	// The recursive nameserver still caches the absence of the record.
+	recursive, shutdownRecursive := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		switch {
+		case q.Name == "example.com." && q.Qtype == dns.TypeSOA:
+			m.Answer = append(m.Answer, &dns.SOA{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 120},
+				Ns:  "127.0.0.1.", Mbox: "admin.example.com.", Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 300,
+			})
+		case q.Name == "example.com." && q.Qtype == dns.TypeNS:
+			m.Answer = append(m.Answer, &dns.NS{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 120},
+				Ns:  "127.0.0.1.",
+			})
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+
+		w.WriteMsg(m)
+	})
+	defer shutdownRecursive()
+
+	_, port, _ := net.SplitHostPort(authoritative)
+	defer func(port string) { authoritativeNameserverPort = port }(authoritativeNameserverPort)
+	authoritativeNameserverPort = port
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{recursive}
+
+	defer func(strategy DNSCheckStrategy) { dnsCheckStrategy = strategy }(dnsCheckStrategy)
+	ClearFqdnCache()
+	defer ClearFqdnCache()
+
+	if dnsCheckStrategy != DNSCheckAuthoritative {
+		t.Errorf("Expected the authoritative strategy by default, got %s", dnsCheckStrategy)
+	}
+
+	ok, err := checkDNSPropagation(fqdn, value)
+	if !ok || err != nil {
+		t.Errorf("Expected the record to be found on the authoritative nameserver, got %v", err)
+	}
+
+	if err = SetDNSCheckStrategy(DNSCheckRecursive); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ok, err = checkDNSPropagation(fqdn, value)
+	if ok {
+		t.Error("Expected the record not to be found on the recursive nameserver")
+	}
+	if err == nil || !strings.Contains(err.Error(), "NXDOMAIN") {
+		t.Errorf("Expected a NXDOMAIN error, got %v", err)
+	}
+
+	// The recursive strategy does not need to reach the authoritative nameservers.
+	RecursiveNameservers = []string{authoritative}
+
+	ok, err = checkDNSPropagation(fqdn, value)
+	if !ok || err != nil {
+		t.Errorf("Expected the record to be found on the recursive nameserver, got %v", err)
+	}
+
+	if err = SetDNSCheckStrategy("cached"); err == nil {
+		t.Error("Expected an error for an unsupported strategy")
+	}
+	if dnsCheckStrategy != DNSCheckRecursive {
+		t.Errorf("Expected the strategy to be unchanged, got %s", dnsCheckStrategy)
+	}
+}
+
+// runStubDNSServer starts a DNS server answering CNAME queries with the given aliases.
+func runStubDNSServer(t *testing.T, cnames map[string]string) (string, func()) {
+	return startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		if target, ok := cnames[q.Name]; ok && q.Qtype == dns.TypeCNAME {
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			})
+		}
+
+		w.WriteMsg(m)
+	})
+}
+
+func TestWaitForPropagationWaitsForStableRecord(t *testing.T) {
+	fqdn, value, _ := DNS01Record("example.com", "keyAuth")
+
+	// the record is seen, disappears during a reload of the zone, then is seen again.
+	visible := []bool{true, false, true, true}
+	var queries int32
+	resolver, shutdown := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		if q.Name == fqdn && q.Qtype == dns.TypeTXT {
+			n := atomic.AddInt32(&queries, 1)
+			if int(n) <= len(visible) && visible[n-1] {
+				m.Answer = append(m.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120},
+					Txt: []string{value},
+				})
+			} else {
+				m.Rcode = dns.RcodeNameError
+			}
+		}
+
+		w.WriteMsg(m)
+	})
+	defer shutdown()
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{resolver}
+
+	defer func(strategy DNSCheckStrategy) { dnsCheckStrategy = strategy }(dnsCheckStrategy)
+	dnsCheckStrategy = DNSCheckRecursive
+
+	defer func(check preCheckDNSFunc) { PreCheckDNS = check }(PreCheckDNS)
+	PreCheckDNS = checkDNSPropagation
+
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+
+	solver := &dnsChallenge{provider: &mockTimeoutProvider{timeout: 5 * time.Second, interval: 10 * time.Millisecond}}
+
+	if err := solver.waitForPropagation(context.Background(), "example.com", fqdn, value); err != nil {
+		t.Fatalf("Expected the record to be propagated, got %v", err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 4 {
+		t.Errorf("Expected the record to be seen by 2 consecutive polls after it disappeared, got %d queries", n)
+	}
+
+	// with a single poll, the CA is notified as soon as the record is seen.
+	if err := SetDNSStablePolls(1); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&queries, 0)
+
+	if err := solver.waitForPropagation(context.Background(), "example.com", fqdn, value); err != nil {
+		t.Fatalf("Expected the record to be propagated, got %v", err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("Expected a single query, got %d", n)
+	}
+
+	if err := SetDNSStablePolls(0); err == nil {
+		t.Error("Expected an error for 0 stable polls")
+	}
+}
+
+func TestWaitForGroupsPropagationConcurrently(t *testing.T) {
+	keyAuth := "keyAuth"
+	_, value, _ := DNS01Record("example.com", keyAuth)
+
+	// every zone<n>.test. is a zone served by the stub, as recursive and authoritative nameserver.
+	addr, shutdown := startStubDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		q := req.Question[0]
+		labels := dns.SplitDomainName(q.Name)
+		isZone := len(labels) == 2 && strings.HasPrefix(labels[0], "zone") && labels[1] == "test"
+		switch {
+		case isZone && q.Qtype == dns.TypeSOA:
+			m.Answer = append(m.Answer, &dns.SOA{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 120},
+				Ns:  "127.0.0.1.", Mbox: "admin.test.", Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 300,
+			})
+		case isZone && q.Qtype == dns.TypeNS:
+			m.Answer = append(m.Answer, &dns.NS{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 120},
+				Ns:  "127.0.0.1.",
+			})
+		case labels[0] == "_acme-challenge" && q.Qtype == dns.TypeTXT:
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120},
+				Txt: []string{value},
+			})
+		}
+
+		w.WriteMsg(m)
+	})
+	defer shutdown()
+
+	_, port, _ := net.SplitHostPort(addr)
+	defer func(port string) { authoritativeNameserverPort = port }(authoritativeNameserverPort)
+	authoritativeNameserverPort = port
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{addr}
+
+	defer func(strategy DNSCheckStrategy) { dnsCheckStrategy = strategy }(dnsCheckStrategy)
+	dnsCheckStrategy = DNSCheckAuthoritative
+
+	defer func(check preCheckDNSFunc) { PreCheckDNS = check }(PreCheckDNS)
+	PreCheckDNS = checkDNSPropagation
+
+	defer func(polls int) { dnsStablePolls = polls }(dnsStablePolls)
+	dnsStablePolls = 1
+
+	ClearFqdnCache()
+	defer ClearFqdnCache()
+
+	// the zones of the domains are looked up and cached concurrently, run with -race.
+	var groups []*dnsChallengeGroup
+	for i := 0; i < 3*propagationCheckWorkers; i++ {
+		groups = append(groups, &dnsChallengeGroup{domain: fmt.Sprintf("www.zone%d.test", i), keyAuths: []string{keyAuth}})
+	}
+
+	// the zone of a domain is cached, it is read while the others are looked up.
+	if _, err := FindZoneByFqdn("_acme-challenge.www.zone0.test.", RecursiveNameservers); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				zone, err := FindZoneByFqdn("_acme-challenge.www.zone0.test.", RecursiveNameservers)
+				if err != nil || zone != "zone0.test." {
+					t.Errorf("Expected the cached zone, got %q, %v", zone, err)
+					return
+				}
+			}
+		}
+	}()
+
+	solver := &dnsChallenge{provider: &mockTimeoutProvider{timeout: 5 * time.Second, interval: 10 * time.Millisecond}}
+	errs := solver.waitForGroupsPropagation(context.Background(), groups)
+
+	close(done)
+	<-read
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Expected the record of %s to be propagated, got %v", groups[i].domain, err)
+		}
+	}
+}
+
+// startStubDNSServer starts a DNS server on a random local port using the given handler.
+func startStubDNSServer(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start stub DNS server: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
@@ -3,17 +3,40 @@ package acme
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 )
 
 const (
 	tosAgreementError = "Terms of service have changed"
 	invalidNonceError = "urn:ietf:params:acme:error:badNonce"
+	rateLimitedError  = "urn:ietf:params:acme:error:rateLimited"
+	// accountDoesNotExistError is returned by the new-account endpoint when onlyReturnExisting
+	// is set and no account matches the key.
+	accountDoesNotExistError   = "urn:ietf:params:acme:error:accountDoesNotExist"
+	badSignatureAlgorithmError = "urn:ietf:params:acme:error:badSignatureAlgorithm"
 )
 
+// ErrNoExistingAccount is returned by ResolveAccountByKey when the ACME server
+// has no account for the key of the user.
+var ErrNoExistingAccount = errors.New("acme: no existing account for the key")
+
+// ErrTermsOfServiceRequired is returned by the registration of an account if the CA
+// has terms of service and the user did not agree to them.
+type ErrTermsOfServiceRequired struct {
+	// URL is the URL of the terms of service to review.
+	URL string
+}
+
+func (e ErrTermsOfServiceRequired) Error() string {
+	return fmt.Sprintf("acme: the terms of service at %s must be agreed to register an account", e.URL)
+}
+
 // RemoteError is the base type for all errors specific to the ACME protocol.
 type RemoteError struct {
 	StatusCode int    `json:"status,omitempty"`
@@ -38,6 +61,123 @@ type NonceError struct {
 	RemoteError
 }
 
+// KeyInUseError is returned by a key rollover if the new key
+// is already used by another account.
+type KeyInUseError struct {
+	RemoteError
+	// AccountURL is the URL of the account using the key, if provided by the server.
+	AccountURL string
+}
+
+// BadSignatureAlgorithmError is returned when the CA does not accept the signature
+// algorithm of the account key, e.g. EdDSA for an Ed25519 key.
+type BadSignatureAlgorithmError struct {
+	RemoteError
+	// Algorithms are the signature algorithms accepted by the CA, if provided.
+	Algorithms []string
+}
+
+func (e BadSignatureAlgorithmError) Error() string {
+	msg := e.RemoteError.Error()
+	if len(e.Algorithms) > 0 {
+		msg += fmt.Sprintf(" (supported algorithms: %s)", strings.Join(e.Algorithms, ", "))
+	}
+	return msg
+}
+
+// RateLimitError is returned when the CA refused a request because a rate limit was exceeded.
+// See https://letsencrypt.org/docs/rate-limits/ for the limits of Let's Encrypt.
+type RateLimitError struct {
+	RemoteError
+	// RetryAfter is the time from which the request can be retried,
+	// from the Retry-After header or the detail of the error. It is zero if unknown.
+	RetryAfter time.Time
+	// Limit is the exceeded limit (e.g. "duplicate certificate"), detected from the detail
+	// of the error. It is empty if unknown.
+	Limit string
+}
+
+func (e RateLimitError) Error() string {
+	msg := e.RemoteError.Error()
+	if e.Limit != "" {
+		msg += fmt.Sprintf(" (limit: %s)", e.Limit)
+	}
+	if !e.RetryAfter.IsZero() {
+		msg += fmt.Sprintf(" (retry after %s)", e.RetryAfter.UTC().Format(time.RFC3339))
+	}
+	return msg
+}
+
+// OrderTimeoutError is returned when the order was not valid, with its certificate,
+// before the timeout set with SetOrderPollTimeout.
+type OrderTimeoutError struct {
+	OrderURL string
+	Timeout  time.Duration
+}
+
+func (e OrderTimeoutError) Error() string {
+	return fmt.Sprintf("acme: the order %s was not valid after %s of polling", e.OrderURL, e.Timeout)
+}
+
+// rateLimits are the limits of Let's Encrypt, detected from a distinctive part of the detail of the errors.
+// The order matters: the duplicate certificate limit also mentions too many certificates.
+var rateLimits = []struct {
+	detail string
+	limit  string
+}{
+	{detail: "exact set of domains", limit: "duplicate certificate"},
+	{detail: "too many certificates", limit: "certificates per registered domain"},
+	{detail: "failed authorizations", limit: "failed validations"},
+	{detail: "new orders", limit: "new orders"},
+	{detail: "pending authorizations", limit: "pending authorizations"},
+	{detail: "registrations", limit: "accounts per IP address"},
+	{detail: "rate limit for", limit: "overall requests"},
+}
+
+// retryAfterDetailRegexp matches the reset time in the detail of a rate limit error,
+// e.g. "retry after 2018-06-02T14:03:55Z" or "retry after 2024-11-21 18:56:36 UTC".
+var retryAfterDetailRegexp = regexp.MustCompile(`(?i)retry after (\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z| UTC|[+-]\d{2}:\d{2})?)`)
+
+// newRateLimitError builds a RateLimitError from the problem document and the Retry-After header,
+// which takes precedence over the reset time found in the detail.
+func newRateLimitError(problem RemoteError, retryAfterHeader string, now time.Time) RateLimitError {
+	rateLimitErr := RateLimitError{RemoteError: problem}
+
+	detail := strings.ToLower(problem.Detail)
+	for _, rateLimit := range rateLimits {
+		if strings.Contains(detail, rateLimit.detail) {
+			rateLimitErr.Limit = rateLimit.limit
+			break
+		}
+	}
+
+	if retryAfter := parseRetryAfter(retryAfterHeader, now); retryAfter > 0 {
+		rateLimitErr.RetryAfter = now.Add(retryAfter)
+		return rateLimitErr
+	}
+
+	if match := retryAfterDetailRegexp.FindStringSubmatch(problem.Detail); match != nil {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05 MST", "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+			if date, err := time.Parse(layout, match[1]); err == nil {
+				rateLimitErr.RetryAfter = date
+				break
+			}
+		}
+	}
+
+	return rateLimitErr
+}
+
+// NoOCSPServerError is returned by GetOCSPForCert when the certificate
+// does not specify any OCSP responder in its Authority Information Access.
+type NoOCSPServerError struct {
+	Subject string
+}
+
+func (e NoOCSPServerError) Error() string {
+	return fmt.Sprintf("no OCSP server specified in cert %q", e.Subject)
+}
+
 type domainError struct {
 	Domain string
 	Error  error
@@ -55,15 +195,59 @@ func (e ObtainError) Error() string {
 	return buffer.String()
 }
 
+// CertificateHookError is returned with the certificate when the hook set with SetOnCertificate fails.
+// The certificate was issued nonetheless.
+type CertificateHookError struct {
+	Domain string
+	Err    error
+}
+
+func (e *CertificateHookError) Error() string {
+	return fmt.Sprintf("[%s] acme: the certificate was issued but the certificate hook failed: %v", e.Domain, e.Err)
+}
+
+// CleanUpError is returned when challenges failed and, in addition, the records
+// presented for some challenges could not be cleaned up and may be left behind.
+type CleanUpError struct {
+	// Err is the error of the failed challenges.
+	Err error
+	// CleanUpErrors are the errors of the cleanups, by domain.
+	CleanUpErrors map[string]error
+}
+
+func (e *CleanUpError) Error() string {
+	buffer := bytes.NewBufferString(e.Err.Error())
+	buffer.WriteString("acme: Error -> The records of one or more domains could not be cleaned up:\n")
+	for dom, err := range e.CleanUpErrors {
+		buffer.WriteString(fmt.Sprintf("[%s] %s\n", dom, err))
+	}
+	return buffer.String()
+}
+
 func handleHTTPError(resp *http.Response) error {
 	var errorDetail RemoteError
 
+	var algorithms []string
+
 	contentType := resp.Header.Get("Content-Type")
 	if contentType == "application/json" || strings.HasPrefix(contentType, "application/problem+json") {
-		err := json.NewDecoder(resp.Body).Decode(&errorDetail)
+		detailBytes, err := ioutil.ReadAll(limitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(detailBytes, &errorDetail)
 		if err != nil {
 			return err
 		}
+
+		if errorDetail.Type == badSignatureAlgorithmError {
+			var problem struct {
+				Algorithms []string `json:"algorithms"`
+			}
+			json.Unmarshal(detailBytes, &problem)
+			algorithms = problem.Algorithms
+		}
 	} else {
 		detailBytes, err := ioutil.ReadAll(limitReader(resp.Body, maxBodySize))
 		if err != nil {
@@ -83,6 +267,14 @@ func handleHTTPError(resp *http.Response) error {
 		return NonceError{errorDetail}
 	}
 
+	if errorDetail.Type == badSignatureAlgorithmError {
+		return BadSignatureAlgorithmError{RemoteError: errorDetail, Algorithms: algorithms}
+	}
+
+	if errorDetail.Type == rateLimitedError {
+		return newRateLimitError(errorDetail, resp.Header.Get("Retry-After"), time.Now())
+	}
+
 	return errorDetail
 }
 
@@ -0,0 +1,137 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleHTTPErrorRateLimited(t *testing.T) {
+	testCases := []struct {
+		name       string
+		problem    string
+		retryAfter string
+		limit      string
+		reset      time.Time
+	}{
+		{
+			name:    "duplicate certificate",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "Error creating new order :: too many certificates already issued for exact set of domains: example.com,www.example.com: see https://letsencrypt.org/docs/rate-limits/", "status": 429}`,
+			limit:   "duplicate certificate",
+		},
+		{
+			name:    "duplicate certificate with reset",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "too many certificates (5) already issued for this exact set of domains in the last 168h0m0s, retry after 2024-11-21 18:56:36 UTC: see https://letsencrypt.org/docs/rate-limits/#new-certificates-per-exact-set-of-hostnames", "status": 429}`,
+			limit:   "duplicate certificate",
+			reset:   time.Date(2024, 11, 21, 18, 56, 36, 0, time.UTC),
+		},
+		{
+			name:    "certificates per registered domain",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "Error creating new order :: too many certificates already issued for \"example.com\". Retry after 2018-06-02T14:03:55Z: see https://letsencrypt.org/docs/rate-limits/", "status": 429}`,
+			limit:   "certificates per registered domain",
+			reset:   time.Date(2018, 6, 2, 14, 3, 55, 0, time.UTC),
+		},
+		{
+			name:       "failed validations with Retry-After",
+			problem:    `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "Error creating new order :: too many failed authorizations recently: see https://letsencrypt.org/docs/failed-validation-limit/", "status": 429}`,
+			retryAfter: "3600",
+			limit:      "failed validations",
+			reset:      time.Now().Add(time.Hour),
+		},
+		{
+			name:    "new orders",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "too many new orders (300) from this account in the last 3h0m0s, retry after 2024-11-21T18:56:36+01:00: see https://letsencrypt.org/docs/rate-limits/", "status": 429}`,
+			limit:   "new orders",
+			reset:   time.Date(2024, 11, 21, 17, 56, 36, 0, time.UTC),
+		},
+		{
+			name:    "accounts per IP address",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "Error creating new account :: too many registrations for this IP: see https://letsencrypt.org/docs/too-many-registrations-for-this-ip/", "status": 429}`,
+			limit:   "accounts per IP address",
+		},
+		{
+			name:    "unknown limit",
+			problem: `{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "slow down", "status": 429}`,
+		},
+	}
+
+	for _, test := range testCases {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/problem+json")
+		if test.retryAfter != "" {
+			rec.Header().Set("Retry-After", test.retryAfter)
+		}
+		rec.WriteHeader(http.StatusTooManyRequests)
+		rec.WriteString(test.problem)
+
+		err := handleHTTPError(rec.Result())
+
+		rateLimitErr, ok := err.(RateLimitError)
+		if !ok {
+			t.Errorf("[%s] Expected a RateLimitError, got %T: %v", test.name, err, err)
+			continue
+		}
+
+		if rateLimitErr.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("[%s] Expected the status 429, got %d", test.name, rateLimitErr.StatusCode)
+		}
+		if rateLimitErr.Limit != test.limit {
+			t.Errorf("[%s] Expected the limit %q, got %q", test.name, test.limit, rateLimitErr.Limit)
+		}
+		if d := rateLimitErr.RetryAfter.Sub(test.reset); d < -time.Minute || d > time.Minute || rateLimitErr.RetryAfter.IsZero() != test.reset.IsZero() {
+			t.Errorf("[%s] Expected to retry after %v, got %v", test.name, test.reset, rateLimitErr.RetryAfter)
+		}
+	}
+}
+
+func TestRateLimitErrorMessage(t *testing.T) {
+	err := RateLimitError{
+		RemoteError: RemoteError{StatusCode: 429, Type: rateLimitedError, Detail: "too many certificates already issued"},
+		RetryAfter:  time.Date(2018, 6, 2, 14, 3, 55, 0, time.UTC),
+		Limit:       "certificates per registered domain",
+	}
+
+	expected := "acme: Error 429 - urn:ietf:params:acme:error:rateLimited - too many certificates already issued (limit: certificates per registered domain) (retry after 2018-06-02T14:03:55Z)"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestPostJSONRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "12345")
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type": "urn:ietf:params:acme:error:rateLimited", "detail": "Error creating new order :: too many new orders recently", "status": 429}`))
+	}))
+	defer ts.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: key, getNonceURL: ts.URL}
+
+	_, err := postJSON(context.Background(), j, ts.URL+"/new-order", struct{}{}, nil)
+
+	rateLimitErr, ok := err.(RateLimitError)
+	if !ok {
+		t.Fatalf("Expected a RateLimitError, got %T: %v", err, err)
+	}
+	if rateLimitErr.Limit != "new orders" {
+		t.Errorf("Expected the new orders limit, got %q", rateLimitErr.Limit)
+	}
+	if d := time.Until(rateLimitErr.RetryAfter); d < time.Minute || d > 2*time.Minute {
+		t.Errorf("Expected to retry in about 2 minutes, got %v", d)
+	}
+	if !strings.Contains(err.Error(), "limit: new orders") {
+		t.Errorf("Expected the limit in the message, got %q", err.Error())
+	}
+}
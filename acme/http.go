@@ -1,6 +1,8 @@
 package acme
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -10,10 +12,14 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/xenolf/lego/log"
 )
 
 var (
@@ -25,7 +31,7 @@ var (
 	// environment variable (see the `initCertPool` function)
 	HTTPClient = http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy: ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -60,11 +66,25 @@ const (
 	// authenticate an ACME server with a HTTPS certificate not issued by a CA in
 	// the system-wide trusted root list.
 	caServerNameEnvVar = "LEGO_CA_SERVER_NAME"
+
+	// caSystemCertPoolEnvVar is the environment variable name that can be used to
+	// add the certificates of caCertificatesEnvVar to the system-wide trusted root
+	// list instead of replacing it.
+	caSystemCertPoolEnvVar = "LEGO_CA_SYSTEM_CERT_POOL"
+
+	// debugHTTPEnvVar is the environment variable name that can be used to
+	// log the requests and responses of the clients created by NewHTTPClient,
+	// with their credentials redacted.
+	debugHTTPEnvVar = "LEGO_DEBUG_ACME_HTTP"
+
+	// maxTracedBodySize is the maximum size of a traced request or response body.
+	maxTracedBodySize = 4096
 )
 
 // initCertPool creates a *x509.CertPool populated with the PEM certificates
 // found in the filepath specified in the caCertificatesEnvVar OS environment
-// variable. If the caCertificatesEnvVar is not set then initCertPool will
+// variable. If caSystemCertPoolEnvVar is true, the certificates are added to
+// the system pool. If the caCertificatesEnvVar is not set then initCertPool will
 // return nil. If there is an error creating a *x509.CertPool from the provided
 // caCertificatesEnvVar value then initCertPool will panic.
 func initCertPool() *x509.CertPool {
@@ -74,8 +94,9 @@ func initCertPool() *x509.CertPool {
 			panic(fmt.Sprintf("error reading %s=%q: %v",
 				caCertificatesEnvVar, customCACertsPath, err))
 		}
-		certPool := x509.NewCertPool()
-		if ok := certPool.AppendCertsFromPEM(customCAs); !ok {
+		useSystem, _ := strconv.ParseBool(os.Getenv(caSystemCertPoolEnvVar))
+		certPool, err := NewCertPool(customCAs, useSystem)
+		if err != nil {
 			panic(fmt.Sprintf("error creating x509 cert pool from %s=%q: %v",
 				caCertificatesEnvVar, customCACertsPath, err))
 		}
@@ -84,17 +105,68 @@ func initCertPool() *x509.CertPool {
 	return nil
 }
 
-// httpHead performs a HEAD request with a proper User-Agent string.
+// NewCertPool creates a *x509.CertPool with the given PEM encoded CA certificates,
+// e.g. the root of a private ACME CA, to use with NewClientWithCertPool.
+// If useSystem is true, the certificates are added to a copy of the system pool.
+func NewCertPool(pemCerts []byte, useSystem bool) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if useSystem {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("could not load the system cert pool: %v", err)
+		}
+		certPool = systemPool
+	}
+
+	if ok := certPool.AppendCertsFromPEM(pemCerts); !ok {
+		return nil, errors.New("no valid PEM certificate found")
+	}
+	return certPool, nil
+}
+
+// newHTTPClientWithCertPool returns a copy of HTTPClient trusting the certificates of certPool.
+func newHTTPClientWithCertPool(certPool *x509.CertPool) *http.Client {
+	client := HTTPClient
+
+	transport := &http.Transport{Proxy: ProxyFromEnvironment}
+	if base, ok := HTTPClient.Transport.(*http.Transport); ok && base != nil {
+		// http.Transport must not be copied, the fields used by HTTPClient are.
+		transport = &http.Transport{
+			Proxy:                 base.Proxy,
+			DialContext:           base.DialContext,
+			TLSHandshakeTimeout:   base.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: base.ResponseHeaderTimeout,
+			ExpectContinueTimeout: base.ExpectContinueTimeout,
+			IdleConnTimeout:       base.IdleConnTimeout,
+			MaxIdleConns:          base.MaxIdleConns,
+		}
+		if base.TLSClientConfig != nil {
+			transport.TLSClientConfig = base.TLSClientConfig.Clone()
+		}
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = certPool
+
+	client.Transport = transport
+	return &client
+}
+
+// httpHead performs a HEAD request with a proper User-Agent string,
+// retried on transient errors according to HTTPRetryPolicy.
 // The response body (resp.Body) is already closed when this function returns.
-func httpHead(url string) (resp *http.Response, err error) {
+func httpHead(ctx context.Context, client *http.Client, url string) (resp *http.Response, err error) {
 	req, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to head %q: %v", url, err)
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Set("User-Agent", userAgent())
 
-	resp, err = HTTPClient.Do(req)
+	resp, err = doWithRetry(client, req)
 	if err != nil {
 		return resp, fmt.Errorf("failed to do head %q: %v", url, err)
 	}
@@ -104,33 +176,36 @@ func httpHead(url string) (resp *http.Response, err error) {
 
 // httpPost performs a POST request with a proper User-Agent string.
 // Callers should close resp.Body when done reading from it.
-func httpPost(url string, bodyType string, body io.Reader) (resp *http.Response, err error) {
+func httpPost(ctx context.Context, client *http.Client, url string, bodyType string, body io.Reader) (resp *http.Response, err error) {
 	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to post %q: %v", url, err)
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", bodyType)
 	req.Header.Set("User-Agent", userAgent())
 
-	return HTTPClient.Do(req)
+	return client.Do(req)
 }
 
-// httpGet performs a GET request with a proper User-Agent string.
+// httpGet performs a GET request with a proper User-Agent string,
+// retried on transient errors according to HTTPRetryPolicy.
 // Callers should close resp.Body when done reading from it.
-func httpGet(url string) (resp *http.Response, err error) {
+func httpGet(ctx context.Context, client *http.Client, url string) (resp *http.Response, err error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get %q: %v", url, err)
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("User-Agent", userAgent())
 
-	return HTTPClient.Do(req)
+	return doWithRetry(client, req)
 }
 
 // getJSON performs an HTTP GET request and parses the response body
 // as JSON, into the provided respBody object.
-func getJSON(uri string, respBody interface{}) (http.Header, error) {
-	resp, err := httpGet(uri)
+func getJSON(ctx context.Context, client *http.Client, uri string, respBody interface{}) (http.Header, error) {
+	resp, err := httpGet(ctx, client, uri)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get json %q: %v", uri, err)
 	}
@@ -145,13 +220,13 @@ func getJSON(uri string, respBody interface{}) (http.Header, error) {
 
 // postJSON performs an HTTP POST request and parses the response body
 // as JSON, into the provided respBody object.
-func postJSON(j *jws, uri string, reqBody, respBody interface{}) (http.Header, error) {
+func postJSON(ctx context.Context, j *jws, uri string, reqBody, respBody interface{}) (http.Header, error) {
 	jsonBytes, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, errors.New("Failed to marshal network message")
 	}
 
-	resp, err := j.post(uri, jsonBytes)
+	resp, err := j.post(ctx, uri, jsonBytes)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to post JWS message. -> %v", err)
 	}
@@ -168,7 +243,7 @@ func postJSON(j *jws, uri string, reqBody, respBody interface{}) (http.Header, e
 
 			// Retry once if the nonce was invalidated
 
-			retryResp, err := j.post(uri, jsonBytes)
+			retryResp, err := j.post(ctx, uri, jsonBytes)
 			if err != nil {
 				return nil, fmt.Errorf("Failed to post JWS message. -> %v", err)
 			}
@@ -199,6 +274,191 @@ func postJSON(j *jws, uri string, reqBody, respBody interface{}) (http.Header, e
 	return resp.Header, json.NewDecoder(resp.Body).Decode(respBody)
 }
 
+// SetUserAgent sets the string prepended to the default User-Agent of lego,
+// in the requests of the ACME client and of the DNS providers.
+func SetUserAgent(ua string) {
+	UserAgent = ua
+}
+
+// NewHTTPClient returns an HTTP client with the given timeout, sending the lego User-Agent.
+// It is meant to be used by the providers to talk to their APIs.
+// If the LEGO_DEBUG_ACME_HTTP environment variable is true, the requests and responses
+// are logged, with the values of the parameters looking like credentials redacted.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	transport := NewUserAgentTransport(nil)
+	if trace, _ := strconv.ParseBool(os.Getenv(debugHTTPEnvVar)); trace {
+		transport = &traceTransport{base: transport}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// traceTransport logs the method, URL, status and body of the requests and responses.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+
+		// A RoundTripper must not modify the request.
+		req = cloneRequest(req)
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	log.Infof("acme: HTTP request %s %s: %s", req.Method, redactURL(req.URL), redactBody(reqBody))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Infof("acme: HTTP request %s %s failed: %v", req.Method, redactURL(req.URL), err)
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("acme: HTTP response %s %s %s: %s", req.Method, redactURL(req.URL), resp.Status, redactBody(respBody))
+
+	return resp, nil
+}
+
+// redacted replaces the values of the credentials in the traces.
+const redacted = "REDACTED"
+
+// isSensitive reports whether a parameter or field name looks like a credential.
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"auth", "token", "secret", "password", "passwd", "key", "signature", "credential"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL returns the URL with the values of its sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	if len(query) == 0 {
+		return u.String()
+	}
+
+	for name := range query {
+		if isSensitive(name) {
+			query[name] = []string{redacted}
+		}
+	}
+
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.String()
+}
+
+// redactBody returns the body with the values of its sensitive JSON fields
+// or form parameters redacted, truncated to maxTracedBodySize.
+func redactBody(body []byte) string {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err == nil {
+		if redactedBody, err := json.Marshal(redactJSON(data)); err == nil {
+			body = redactedBody
+		}
+	} else if form, err := url.ParseQuery(string(body)); err == nil && strings.Contains(string(body), "=") {
+		for name := range form {
+			if isSensitive(name) {
+				form[name] = []string{redacted}
+			}
+		}
+		body = []byte(form.Encode())
+	}
+
+	if len(body) > maxTracedBodySize {
+		return string(body[:maxTracedBodySize]) + "..."
+	}
+	return string(body)
+}
+
+// redactJSON redacts the values of the sensitive fields of the decoded JSON data, recursively.
+func redactJSON(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for name, field := range value {
+			if isSensitive(name) {
+				value[name] = redacted
+			} else {
+				value[name] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactJSON(item)
+		}
+	}
+	return data
+}
+
+// NewUserAgentTransport wraps the base transport (http.DefaultTransport using
+// ProxyFromEnvironment if nil) to add the lego User-Agent to every request.
+// A User-Agent already set on a request, e.g. by an SDK, is kept after the lego one.
+func NewUserAgentTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = defaultTransport
+	}
+	return &userAgentTransport{base: base}
+}
+
+// defaultTransport has the settings of http.DefaultTransport, using ProxyFromEnvironment.
+var defaultTransport = newDefaultTransport()
+
+func newDefaultTransport() http.RoundTripper {
+	return &http.Transport{
+		Proxy: ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ua := userAgent()
+	if existing := req.Header.Get("User-Agent"); existing != "" {
+		ua += " " + existing
+	}
+
+	// A RoundTripper must not modify the request.
+	req = cloneRequest(req)
+	req.Header.Set("User-Agent", ua)
+
+	return t.base.RoundTrip(req)
+}
+
+// cloneRequest returns a shallow copy of the request with a copy of its header.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := req.WithContext(req.Context())
+	clone.Header = make(http.Header, len(req.Header))
+	for name, values := range req.Header {
+		clone.Header[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
 // userAgent builds and returns the User-Agent string to use in requests.
 func userAgent() string {
 	ua := fmt.Sprintf("%s %s (%s; %s) %s", UserAgent, ourUserAgent, runtime.GOOS, runtime.GOARCH, defaultGoUserAgent)
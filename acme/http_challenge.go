@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/xenolf/lego/log"
@@ -10,6 +11,8 @@ type httpChallenge struct {
 	jws      *jws
 	validate validateFunc
 	provider ChallengeProvider
+	// keepOnFailure keeps the token presented if the challenge fails, for debugging.
+	keepOnFailure bool
 }
 
 // HTTP01ChallengePath returns the URL path for the `http-01` challenge
@@ -17,12 +20,12 @@ func HTTP01ChallengePath(token string) string {
 	return "/.well-known/acme-challenge/" + token
 }
 
-func (s *httpChallenge) Solve(chlng challenge, domain string) error {
+func (s *httpChallenge) Solve(ctx context.Context, chlng challenge, domain string) (err error) {
 
 	log.Infof("[%s] acme: Trying to solve HTTP-01", domain)
 
 	// Generate the Key Authorization for the challenge
-	keyAuth, err := getKeyAuthorization(chlng.Token, s.jws.privKey)
+	keyAuth, err := KeyAuthorization(chlng.Token, s.jws.privKey)
 	if err != nil {
 		return err
 	}
@@ -32,11 +35,13 @@ func (s *httpChallenge) Solve(chlng challenge, domain string) error {
 		return fmt.Errorf("[%s] error presenting token: %v", domain, err)
 	}
 	defer func() {
-		err := s.provider.CleanUp(domain, chlng.Token, keyAuth)
-		if err != nil {
+		if skipCleanUp(s.keepOnFailure, err, domain, fmt.Sprintf("the token served at %s", HTTP01ChallengePath(chlng.Token))) {
+			return
+		}
+		if err := s.provider.CleanUp(domain, chlng.Token, keyAuth); err != nil {
 			log.Warnf("[%s] error cleaning up: %v", domain, err)
 		}
 	}()
 
-	return s.validate(s.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return s.validate(ctx, s.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
 }
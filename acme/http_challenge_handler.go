@@ -0,0 +1,72 @@
+package acme
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/xenolf/lego/log"
+)
+
+// HTTPProviderHandler implements ChallengeProvider for `http-01` challenge
+// by serving the tokens from an existing web server instead of opening a listener.
+// It is an http.Handler to register for the `/.well-known/acme-challenge/` path of the router of the server,
+// or to wrap the whole router.
+// Present and CleanUp only make the key authorization available or not at `HTTP01ChallengePath(token)`.
+type HTTPProviderHandler struct {
+	next     http.Handler
+	mu       sync.RWMutex
+	keyAuths map[string]string
+}
+
+// NewHTTPProviderHandler creates a new HTTPProviderHandler.
+// The requests which are not for a presented token are passed to next,
+// or answered with a 404 status if next is nil.
+func NewHTTPProviderHandler(next http.Handler) *HTTPProviderHandler {
+	return &HTTPProviderHandler{next: next, keyAuths: make(map[string]string)}
+}
+
+// Present makes the key authorization available at `HTTP01ChallengePath(token)`.
+func (h *HTTPProviderHandler) Present(domain, token, keyAuth string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.keyAuths == nil {
+		h.keyAuths = make(map[string]string)
+	}
+	h.keyAuths[token] = keyAuth
+	return nil
+}
+
+// CleanUp removes the token from `HTTP01ChallengePath(token)`.
+func (h *HTTPProviderHandler) CleanUp(domain, token, keyAuth string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.keyAuths, token)
+	return nil
+}
+
+// ServeHTTP serves the key authorization of the presented tokens.
+func (h *HTTPProviderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if token := strings.TrimPrefix(r.URL.Path, HTTP01ChallengePath("")); token != r.URL.Path {
+			h.mu.RLock()
+			keyAuth, ok := h.keyAuths[token]
+			h.mu.RUnlock()
+
+			if ok {
+				w.Header().Add("Content-Type", "text/plain")
+				w.Write([]byte(keyAuth))
+				log.Infof("[%s] Served key authentication", r.Host)
+				return
+			}
+		}
+	}
+
+	if h.next == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -13,10 +13,11 @@ import (
 // It may be instantiated without using the NewHTTPProviderServer function if
 // you want only to use the default values.
 type HTTPProviderServer struct {
-	iface    string
-	port     string
-	done     chan bool
-	listener net.Listener
+	iface       string
+	port        string
+	matchHeader string
+	done        chan bool
+	listener    net.Listener
 }
 
 // NewHTTPProviderServer creates a new HTTPProviderServer on the selected interface and port.
@@ -26,6 +27,14 @@ func NewHTTPProviderServer(iface, port string) *HTTPProviderServer {
 	return &HTTPProviderServer{iface: iface, port: port}
 }
 
+// SetProxyHeader sets the name of the header holding the original host of the request,
+// to use when the server runs behind a reverse proxy (e.g. "X-Forwarded-Host").
+// The "Forwarded" header (RFC 7239) is supported too, its "host" parameter is used.
+// By default the Host header of the request is matched against the domain.
+func (s *HTTPProviderServer) SetProxyHeader(headerName string) {
+	s.matchHeader = http.CanonicalHeaderKey(headerName)
+}
+
 // Present starts a web server and makes the token available at `HTTP01ChallengePath(token)` for web requests.
 func (s *HTTPProviderServer) Present(domain, token, keyAuth string) error {
 	if s.port == "" {
@@ -43,6 +52,20 @@ func (s *HTTPProviderServer) Present(domain, token, keyAuth string) error {
 	return nil
 }
 
+// Preflight checks that the server can listen on its interface and port.
+func (s *HTTPProviderServer) Preflight(domain string) error {
+	port := s.port
+	if port == "" {
+		port = "80"
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.iface, port))
+	if err != nil {
+		return fmt.Errorf("Could not start HTTP server for challenge -> %v", err)
+	}
+	return listener.Close()
+}
+
 // CleanUp closes the HTTP server and removes the token from `HTTP01ChallengePath(token)`
 func (s *HTTPProviderServer) CleanUp(domain, token, keyAuth string) error {
 	if s.listener == nil {
@@ -60,12 +83,13 @@ func (s *HTTPProviderServer) serve(domain, token, keyAuth string) {
 	// For validation it then writes the token the server returned with the challenge
 	mux := http.NewServeMux()
 	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.Host, domain) && r.Method == http.MethodGet {
+		// IPv6 hosts are enclosed in brackets (e.g. [2001:db8::1]:80)
+		if strings.HasPrefix(strings.TrimPrefix(s.requestHost(r), "["), domain) && r.Method == http.MethodGet {
 			w.Header().Add("Content-Type", "text/plain")
 			w.Write([]byte(keyAuth))
 			log.Infof("[%s] Served key authentication", domain)
 		} else {
-			log.Warnf("Received request for domain %s with method %s but the domain did not match any challenge. Please ensure your are passing the HOST header properly.", r.Host, r.Method)
+			log.Warnf("Received request for domain %s with method %s but the domain did not match any challenge. Please ensure your are passing the %s header properly.", s.requestHost(r), r.Method, s.hostHeader())
 			w.Write([]byte("TEST"))
 		}
 	})
@@ -79,3 +103,30 @@ func (s *HTTPProviderServer) serve(domain, token, keyAuth string) {
 	httpServer.Serve(s.listener)
 	s.done <- true
 }
+
+// hostHeader returns the name of the header holding the host of the requests.
+func (s *HTTPProviderServer) hostHeader() string {
+	if s.matchHeader == "" {
+		return "Host"
+	}
+	return s.matchHeader
+}
+
+// requestHost returns the host the request was sent to, taking the proxy header into account.
+func (s *HTTPProviderServer) requestHost(r *http.Request) string {
+	switch s.matchHeader {
+	case "", "Host":
+		return r.Host
+	case "Forwarded":
+		// Forwarded: for=192.0.2.60;proto=http;host=example.com, for=...
+		for _, part := range strings.FieldsFunc(r.Header.Get("Forwarded"), func(r rune) bool { return r == ';' || r == ',' }) {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "host") {
+				return strings.Trim(kv[1], `"`)
+			}
+		}
+		return ""
+	default:
+		return r.Header.Get(s.matchHeader)
+	}
+}
@@ -1,9 +1,12 @@
 package acme
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -12,9 +15,9 @@ func TestHTTPChallenge(t *testing.T) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
 	j := &jws{privKey: privKey}
 	clientChallenge := challenge{Type: string(HTTP01), Token: "http1"}
-	mockValidate := func(_ *jws, _, _ string, chlng challenge) error {
+	mockValidate := func(_ context.Context, _ *jws, _, _ string, chlng challenge) error {
 		uri := "http://localhost:23457/.well-known/acme-challenge/" + chlng.Token
-		resp, err := httpGet(uri)
+		resp, err := httpGet(context.Background(), &HTTPClient, uri)
 		if err != nil {
 			return err
 		}
@@ -38,7 +41,7 @@ func TestHTTPChallenge(t *testing.T) {
 	}
 	solver := &httpChallenge{jws: j, validate: mockValidate, provider: &HTTPProviderServer{port: "23457"}}
 
-	if err := solver.Solve(clientChallenge, "localhost:23457"); err != nil {
+	if err := solver.Solve(context.Background(), clientChallenge, "localhost:23457"); err != nil {
 		t.Errorf("Solve error: got %v, want nil", err)
 	}
 }
@@ -49,9 +52,113 @@ func TestHTTPChallengeInvalidPort(t *testing.T) {
 	clientChallenge := challenge{Type: string(HTTP01), Token: "http2"}
 	solver := &httpChallenge{jws: j, validate: stubValidate, provider: &HTTPProviderServer{port: "123456"}}
 
-	if err := solver.Solve(clientChallenge, "localhost:123456"); err == nil {
+	if err := solver.Solve(context.Background(), clientChallenge, "localhost:123456"); err == nil {
 		t.Errorf("Solve error: got %v, want error", err)
 	} else if want, want18 := "invalid port 123456", "123456: invalid port"; !strings.HasSuffix(err.Error(), want) && !strings.HasSuffix(err.Error(), want18) {
 		t.Errorf("Solve error: got %q, want suffix %q", err.Error(), want)
 	}
 }
+
+func TestHTTPChallengeServerEphemeralPort(t *testing.T) {
+	server := NewHTTPProviderServer("127.0.0.1", "0")
+	server.SetProxyHeader("X-Forwarded-Host")
+
+	err := server.Present("example.com", "token3", "keyAuth3")
+	if err != nil {
+		t.Fatalf("Present error: got %v, want nil", err)
+	}
+	defer server.CleanUp("example.com", "token3", "keyAuth3")
+
+	uri := "http://" + server.listener.Addr().String() + HTTP01ChallengePath("token3")
+
+	testCases := []struct {
+		header   string
+		value    string
+		expected string
+	}{
+		{header: "X-Forwarded-Host", value: "example.com", expected: "keyAuth3"},
+		{header: "X-Forwarded-Host", value: "other.com", expected: "TEST"},
+		{expected: "TEST"},
+	}
+
+	for _, test := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, uri, nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Get(%q) error: %v", uri, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != test.expected {
+			t.Errorf("Get(%q) with %s %q: got %q, want %q", uri, test.header, test.value, body, test.expected)
+		}
+	}
+}
+
+func TestHTTPChallengeServerForwardedHeader(t *testing.T) {
+	server := &HTTPProviderServer{}
+	server.SetProxyHeader("forwarded")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:5002/", nil)
+	req.Header.Set("Forwarded", `for=192.0.2.60;proto=http;host="example.com", for=198.51.100.17`)
+
+	if host := server.requestHost(req); host != "example.com" {
+		t.Errorf("Expected host example.com, got %q", host)
+	}
+}
+
+func TestHTTPChallengeHandler(t *testing.T) {
+	app := http.NewServeMux()
+	app.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("app"))
+	})
+
+	handler := NewHTTPProviderHandler(app)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func(path string) string {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Get(%q) error: %v", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+	clientChallenge := challenge{Type: string(HTTP01), Token: "http4"}
+	mockValidate := func(_ context.Context, _ *jws, _, _ string, chlng challenge) error {
+		if body := get(HTTP01ChallengePath(chlng.Token)); body != chlng.KeyAuthorization {
+			t.Errorf("Get(%q) Body: got %q, want %q", HTTP01ChallengePath(chlng.Token), body, chlng.KeyAuthorization)
+		}
+		if body := get("/index.html"); body != "app" {
+			t.Errorf("Expected the other requests to be served by the app, got %q", body)
+		}
+		return nil
+	}
+	solver := &httpChallenge{jws: j, validate: mockValidate, provider: handler}
+
+	if err := solver.Solve(context.Background(), clientChallenge, "example.com"); err != nil {
+		t.Errorf("Solve error: got %v, want nil", err)
+	}
+
+	// The token is not served anymore once cleaned up.
+	if body := get(HTTP01ChallengePath("http4")); body != "app" {
+		t.Errorf("Expected the cleaned up token to be served by the app, got %q", body)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHTTPProviderHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HTTP01ChallengePath("http4"), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 status without a next handler, got %d", rec.Code)
+	}
+}
@@ -1,12 +1,21 @@
 package acme
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
 	"io/ioutil"
+	stdlog "log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/xenolf/lego/log"
 )
 
 func TestHTTPHeadUserAgent(t *testing.T) {
@@ -17,7 +26,7 @@ func TestHTTPHeadUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	_, err := httpHead(ts.URL)
+	_, err := httpHead(context.Background(), &HTTPClient, ts.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -38,7 +47,7 @@ func TestHTTPGetUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	res, err := httpGet(ts.URL)
+	res, err := httpGet(context.Background(), &HTTPClient, ts.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,7 +69,7 @@ func TestHTTPPostUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	res, err := httpPost(ts.URL, "text/plain", strings.NewReader("falalalala"))
+	res, err := httpPost(context.Background(), &HTTPClient, ts.URL, "text/plain", strings.NewReader("falalalala"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,6 +110,102 @@ func TestUserAgent(t *testing.T) {
 	}
 }
 
+func TestNewHTTPClientUserAgent(t *testing.T) {
+	defer func(ua string) { UserAgent = ua }(UserAgent)
+	SetUserAgent("MyApp/1.2.3")
+
+	var uas []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uas = append(uas, r.Header.Get("User-Agent"))
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(10 * time.Second)
+
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("User-Agent", "some-sdk/1.0")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if !strings.HasPrefix(uas[0], "MyApp/1.2.3 "+ourUserAgent) {
+		t.Errorf("Expected User-Agent to start with the custom UA and '%s', got: '%s'", ourUserAgent, uas[0])
+	}
+	if !strings.HasPrefix(uas[1], "MyApp/1.2.3 "+ourUserAgent) || !strings.HasSuffix(uas[1], " some-sdk/1.0") {
+		t.Errorf("Expected User-Agent to keep the request UA, got: '%s'", uas[1])
+	}
+	if req.Header.Get("User-Agent") != "some-sdk/1.0" {
+		t.Errorf("Expected the request to be left untouched, got: '%s'", req.Header.Get("User-Agent"))
+	}
+}
+
+func TestNewHTTPClientTrace(t *testing.T) {
+	defer os.Unsetenv(debugHTTPEnvVar)
+	os.Setenv(debugHTTPEnvVar, "1")
+
+	defer log.SetLogger(nil)
+	buf := &bytes.Buffer{}
+	log.SetLogger(stdlog.New(buf, "", 0))
+
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "42", "session": {"token": "s3cret-session"}}`))
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(10 * time.Second)
+
+	body := `{"authToken": "s3cret-token", "records": [{"name": "_acme-challenge", "apiKey": "s3cret-key"}]}`
+	res, err := client.Post(ts.URL+"/zoneUpdate?user=lego&api_key=s3cret-query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if received != body {
+		t.Errorf("Expected the request body to be sent untouched, got %s", received)
+	}
+	if !strings.Contains(string(respBody), "s3cret-session") {
+		t.Errorf("Expected the response body to be readable after the trace, got %s", respBody)
+	}
+
+	trace := buf.String()
+	expected := "POST " + ts.URL + "/zoneUpdate?api_key=REDACTED&user=lego: " + `{"authToken":"REDACTED","records":[{"apiKey":"REDACTED","name":"_acme-challenge"}]}`
+	if !strings.Contains(trace, expected) {
+		t.Errorf("Expected the request line %q to be logged, got:\n%s", expected, trace)
+	}
+	if !strings.Contains(trace, "201 Created") {
+		t.Errorf("Expected the response status to be logged, got:\n%s", trace)
+	}
+	if strings.Contains(trace, "s3cret") {
+		t.Errorf("Expected the credentials to be redacted, got:\n%s", trace)
+	}
+
+	// the trace is opt-in.
+	os.Unsetenv(debugHTTPEnvVar)
+	buf.Reset()
+	res, err = NewHTTPClient(10 * time.Second).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no trace, got:\n%s", buf.String())
+	}
+}
+
 // TestInitCertPool tests the http.go initCertPool function for customizing the
 // HTTP Client *x509.CertPool with an environment variable.
 func TestInitCertPool(t *testing.T) {
@@ -208,3 +313,80 @@ p9BI7gVKtWSZYegicA==
 		})
 	}
 }
+
+func TestNewClientWithCertPool(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, directory{
+			NewNonceURL:   ts.URL + "/nonce",
+			NewAccountURL: ts.URL + "/newAccount",
+			NewOrderURL:   ts.URL + "/newOrder",
+		})
+	}))
+	defer ts.Close()
+
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+	user := mockUser{email: "test@test.com", privatekey: key}
+
+	if _, err := NewClient(ts.URL, user, RSA2048); err == nil {
+		t.Error("Expected the self-signed certificate of the CA not to be trusted")
+	}
+
+	certPool, err := NewCertPool(rootPEM, false)
+	if err != nil {
+		t.Fatalf("Could not create the cert pool: %v", err)
+	}
+
+	client, err := NewClientWithCertPool(ts.URL, user, RSA2048, certPool)
+	if err != nil {
+		t.Fatalf("Expected the CA to be trusted: %v", err)
+	}
+	if client.directory.NewOrderURL != ts.URL+"/newOrder" {
+		t.Errorf("Expected the directory to be fetched, got %+v", client.directory)
+	}
+
+	if err := client.RefreshDirectory(); err != nil {
+		t.Errorf("Expected the subsequent requests to trust the CA: %v", err)
+	}
+	if HTTPClient.Transport.(*http.Transport).TLSClientConfig.RootCAs == certPool {
+		t.Error("Expected the transport of HTTPClient to be left untouched")
+	}
+
+	if _, err := NewCertPool([]byte("not a certificate"), false); err == nil {
+		t.Error("Expected an invalid certificate to be rejected")
+	}
+}
+
+func TestInitCertPoolSystemCertPool(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tmpFile, err := ioutil.TempFile("", "root.pem")
+	if err != nil {
+		t.Fatalf("Unable to create tempfile: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := pem.Encode(tmpFile, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}); err != nil {
+		t.Fatalf("Unable to write tempfile contents: %v", err)
+	}
+	tmpFile.Close()
+
+	os.Setenv(caCertificatesEnvVar, tmpFile.Name())
+	defer os.Setenv(caCertificatesEnvVar, "")
+
+	for _, useSystem := range []string{"", "1"} {
+		os.Setenv(caSystemCertPoolEnvVar, useSystem)
+
+		client := newHTTPClientWithCertPool(initCertPool())
+		resp, err := httpGet(context.Background(), client, ts.URL)
+		if err != nil {
+			t.Errorf("Expected the CA to be trusted with %s=%q: %v", caSystemCertPoolEnvVar, useSystem, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	os.Setenv(caSystemCertPoolEnvVar, "")
+}
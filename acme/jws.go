@@ -2,14 +2,17 @@ package acme
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
 
+	"golang.org/x/crypto/ed25519"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -18,19 +21,29 @@ type jws struct {
 	privKey     crypto.PrivateKey
 	kid         string
 	nonces      nonceManager
+	client      *http.Client
+}
+
+// httpClient returns the HTTP client used for the ACME requests,
+// the package HTTPClient unless a custom one was set.
+func (j *jws) httpClient() *http.Client {
+	if j.client != nil {
+		return j.client
+	}
+	return &HTTPClient
 }
 
 // Posts a JWS signed message to the specified URL.
 // It does NOT close the response body, so the caller must
 // do that if no error was returned.
-func (j *jws) post(url string, content []byte) (*http.Response, error) {
-	signedContent, err := j.signContent(url, content)
+func (j *jws) post(ctx context.Context, url string, content []byte) (*http.Response, error) {
+	signedContent, err := j.signContent(ctx, url, content)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign content -> %s", err.Error())
 	}
 
 	data := bytes.NewBuffer([]byte(signedContent.FullSerialize()))
-	resp, err := httpPost(url, "application/jose+json", data)
+	resp, err := httpPost(ctx, j.httpClient(), url, "application/jose+json", data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to HTTP POST to %s -> %s", url, err.Error())
 	}
@@ -43,19 +56,8 @@ func (j *jws) post(url string, content []byte) (*http.Response, error) {
 	return resp, nil
 }
 
-func (j *jws) signContent(url string, content []byte) (*jose.JSONWebSignature, error) {
-
-	var alg jose.SignatureAlgorithm
-	switch k := j.privKey.(type) {
-	case *rsa.PrivateKey:
-		alg = jose.RS256
-	case *ecdsa.PrivateKey:
-		if k.Curve == elliptic.P256() {
-			alg = jose.ES256
-		} else if k.Curve == elliptic.P384() {
-			alg = jose.ES384
-		}
-	}
+func (j *jws) signContent(ctx context.Context, url string, content []byte) (*jose.JSONWebSignature, error) {
+	alg := signatureAlgorithm(j.privKey)
 
 	jsonKey := jose.JSONWebKey{
 		Key:   j.privKey,
@@ -67,7 +69,7 @@ func (j *jws) signContent(url string, content []byte) (*jose.JSONWebSignature, e
 		Key:       jsonKey,
 	}
 	options := jose.SignerOptions{
-		NonceSource:  j,
+		NonceSource:  &contextNonceSource{ctx: ctx, jws: j},
 		ExtraHeaders: make(map[jose.HeaderKey]interface{}),
 	}
 	options.ExtraHeaders["url"] = url
@@ -116,12 +118,75 @@ func (j *jws) signEABContent(url, kid string, hmac []byte) (*jose.JSONWebSignatu
 	return signed, nil
 }
 
+// signKeyChangeContent creates the inner JWS of a key-change request:
+// it is signed with the new key, embeds its JWK and has no nonce.
+func (j *jws) signKeyChangeContent(url string, newKey crypto.PrivateKey) (*jose.JSONWebSignature, error) {
+	oldKey := jose.JSONWebKey{Key: j.privKey}
+	content, err := json.Marshal(keyChangeMessage{Account: j.kid, OldKey: oldKey.Public()})
+	if err != nil {
+		return nil, fmt.Errorf("acme: error encoding key change content: %s", err.Error())
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: signatureAlgorithm(newKey), Key: jose.JSONWebKey{Key: newKey}},
+		&jose.SignerOptions{
+			EmbedJWK: true,
+			ExtraHeaders: map[jose.HeaderKey]interface{}{
+				"url": url,
+			},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key change jose signer -> %s", err.Error())
+	}
+
+	signed, err := signer.Sign(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to key change sign content -> %s", err.Error())
+	}
+
+	return signed, nil
+}
+
+// signatureAlgorithm returns the JWS algorithm matching the private key.
+func signatureAlgorithm(privKey crypto.PrivateKey) jose.SignatureAlgorithm {
+	var alg jose.SignatureAlgorithm
+	switch k := privKey.(type) {
+	case *rsa.PrivateKey:
+		alg = jose.RS256
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P256() {
+			alg = jose.ES256
+		} else if k.Curve == elliptic.P384() {
+			alg = jose.ES384
+		}
+	case ed25519.PrivateKey:
+		alg = jose.EdDSA
+	}
+	return alg
+}
+
 func (j *jws) Nonce() (string, error) {
+	return j.nonce(context.Background())
+}
+
+// nonce returns a nonce returned by a previous request, or fetches a new one.
+func (j *jws) nonce(ctx context.Context) (string, error) {
 	if nonce, ok := j.nonces.Pop(); ok {
 		return nonce, nil
 	}
 
-	return getNonce(j.getNonceURL)
+	return getNonce(ctx, j.httpClient(), j.getNonceURL)
+}
+
+// contextNonceSource is the jose.NonceSource of a request, fetching the nonces with its context.
+type contextNonceSource struct {
+	ctx context.Context
+	jws *jws
+}
+
+func (s *contextNonceSource) Nonce() (string, error) {
+	return s.jws.nonce(s.ctx)
 }
 
 type nonceManager struct {
@@ -148,8 +213,8 @@ func (n *nonceManager) Push(nonce string) {
 	n.nonces = append(n.nonces, nonce)
 }
 
-func getNonce(url string) (string, error) {
-	resp, err := httpHead(url)
+func getNonce(ctx context.Context, client *http.Client, url string) (string, error) {
+	resp, err := httpHead(ctx, client, url)
 	if err != nil {
 		return "", fmt.Errorf("failed to get nonce from HTTP HEAD -> %s", err.Error())
 	}
@@ -3,6 +3,8 @@ package acme
 import (
 	"encoding/json"
 	"time"
+
+	"gopkg.in/square/go-jose.v2"
 )
 
 // RegistrationResource represents all important informations about a registration
@@ -18,6 +20,7 @@ type directory struct {
 	NewOrderURL   string `json:"newOrder"`
 	RevokeCertURL string `json:"revokeCert"`
 	KeyChangeURL  string `json:"keyChange"`
+	RenewalInfo   string `json:"renewalInfo"`
 	Meta          struct {
 		TermsOfService          string   `json:"termsOfService"`
 		Website                 string   `json:"website"`
@@ -35,6 +38,11 @@ type accountMessage struct {
 	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
 }
 
+type keyChangeMessage struct {
+	Account string          `json:"account"`
+	OldKey  jose.JSONWebKey `json:"oldKey"`
+}
+
 type orderResource struct {
 	URL          string   `json:"url,omitempty"`
 	Domains      []string `json:"domains,omitempty"`
@@ -57,6 +65,7 @@ type authorization struct {
 	Expires    time.Time   `json:"expires"`
 	Identifier identifier  `json:"identifier"`
 	Challenges []challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
 }
 
 type identifier struct {
@@ -80,6 +89,7 @@ type csrMessage struct {
 
 type revokeCertMessage struct {
 	Certificate string `json:"certificate"`
+	Reason      *uint  `json:"reason,omitempty"`
 }
 
 type deactivateAuthMessage struct {
@@ -0,0 +1,135 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xenolf/lego/log"
+)
+
+// maxOrdersPages bounds the number of pages of the orders list followed by ListOrders.
+const maxOrdersPages = 100
+
+// ErrOrdersListNotSupported is returned by ListOrders when the ACME server
+// does not expose the orders list of the account.
+var ErrOrdersListNotSupported = errors.New("acme: the server does not expose the orders list of the account")
+
+// Order is an order of the account, as reported by the ACME server.
+type Order struct {
+	// URL is the URL of the order.
+	URL string `json:"-"`
+	// Status is one of "pending", "ready", "processing", "valid" and "invalid".
+	Status      string       `json:"status"`
+	Expires     time.Time    `json:"expires"`
+	Identifiers []Identifier `json:"identifiers"`
+	NotBefore   time.Time    `json:"notBefore"`
+	NotAfter    time.Time    `json:"notAfter"`
+	// Error is the error that occurred while processing the order, if any.
+	Error *RemoteError `json:"error,omitempty"`
+	// Authorizations are the URLs of the authorizations to complete before the order is ready.
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	// Certificate is the URL of the issued certificate, once the order is valid.
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// Identifier is a domain or an IP address of an order or an authorization.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// AuthorizationDetails is the state of an authorization, as reported by the ACME server.
+type AuthorizationDetails struct {
+	// URL is the URL of the authorization.
+	URL string `json:"-"`
+	// Status is one of "pending", "valid", "invalid", "deactivated", "expired" and "revoked".
+	Status     string             `json:"status"`
+	Expires    time.Time          `json:"expires"`
+	Identifier Identifier         `json:"identifier"`
+	Challenges []ChallengeDetails `json:"challenges"`
+	Wildcard   bool               `json:"wildcard,omitempty"`
+}
+
+// ChallengeDetails is the state of a challenge of an authorization.
+type ChallengeDetails struct {
+	URL  string    `json:"url"`
+	Type Challenge `json:"type"`
+	// Status is one of "pending", "processing", "valid" and "invalid".
+	Status    string    `json:"status"`
+	Token     string    `json:"token"`
+	Validated time.Time `json:"validated"`
+	// Error is the reason of the failure of the validation, if any.
+	Error *RemoteError `json:"error,omitempty"`
+}
+
+type ordersListMessage struct {
+	Orders []string `json:"orders"`
+}
+
+// ListOrders returns the orders of the account, as listed by the ACME server.
+// The server may only list the orders in the "pending", "ready" and "processing" states.
+// ErrOrdersListNotSupported is returned if the account has no orders list, like on Let's Encrypt.
+func (c *Client) ListOrders() ([]Order, error) {
+	reg, err := c.QueryRegistration()
+	if err != nil {
+		return nil, err
+	}
+
+	if reg.Body.Orders == "" {
+		return nil, ErrOrdersListNotSupported
+	}
+
+	var urls []string
+	next := reg.Body.Orders
+	for page := 0; next != "" && page < maxOrdersPages; page++ {
+		var list ordersListMessage
+		hdr, err := getJSON(context.Background(), c.jws.httpClient(), next, &list)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, list.Orders...)
+
+		next = ""
+		if links := parseLinkURLs(hdr["Link"], "next"); len(links) > 0 {
+			next = links[0]
+		}
+	}
+	if next != "" {
+		log.Warnf("acme: Only the first %d pages of the orders list were fetched", maxOrdersPages)
+	}
+
+	orders := make([]Order, 0, len(urls))
+	for _, url := range urls {
+		order, err := c.GetOrder(url)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// GetOrder fetches the order with the given URL.
+func (c *Client) GetOrder(url string) (Order, error) {
+	var order Order
+	if _, err := getJSON(context.Background(), c.jws.httpClient(), url, &order); err != nil {
+		return Order{}, err
+	}
+
+	order.URL = url
+	return order, nil
+}
+
+// GetAuthorization fetches the authorization with the given URL, with the status of its challenges.
+func (c *Client) GetAuthorization(url string) (AuthorizationDetails, error) {
+	var authz AuthorizationDetails
+	if _, err := getJSON(context.Background(), c.jws.httpClient(), url, &authz); err != nil {
+		return AuthorizationDetails{}, err
+	}
+
+	authz.URL = url
+	return authz, nil
+}
@@ -0,0 +1,255 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newOrdersTestClient returns a client registered to the account of the server,
+// whose orders list URL is ordersURL.
+func newOrdersTestClient(t *testing.T, handler func(ts *httptest.Server, w http.ResponseWriter, r *http.Request), ordersURL func(ts *httptest.Server) string) (*Client, *httptest.Server) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+			})
+		case "/nonce":
+			w.Header().Add("Replay-Nonce", "12345")
+			w.Header().Add("Retry-After", "0")
+		case "/account/1":
+			w.Header().Add("Replay-Nonce", "12345")
+			writeJSONResponse(w, accountMessage{Status: "valid", Orders: ordersURL(ts)})
+		default:
+			handler(ts, w, r)
+		}
+	}))
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{URI: ts.URL + "/account/1"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.jws.kid = user.regres.URI
+
+	return client, ts
+}
+
+func writeOrder(w http.ResponseWriter, ts *httptest.Server, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{
+		"status": "` + status + `",
+		"expires": "2021-01-08T12:00:00Z",
+		"identifiers": [
+			{"type": "dns", "value": "example.com"},
+			{"type": "dns", "value": "*.example.com"}
+		],
+		"authorizations": [
+			"` + ts.URL + `/authz/1",
+			"` + ts.URL + `/authz/2"
+		],
+		"finalize": "` + ts.URL + `/order/finalize"
+	}`))
+}
+
+func TestGetOrder(t *testing.T) {
+	client, ts := newOrdersTestClient(t, func(ts *httptest.Server, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/order/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{
+				"status": "invalid",
+				"expires": "2021-01-08T12:00:00Z",
+				"identifiers": [{"type": "dns", "value": "example.com"}],
+				"notBefore": "2021-01-01T00:00:00Z",
+				"notAfter": "2021-01-08T00:00:00Z",
+				"error": {
+					"type": "urn:ietf:params:acme:error:unauthorized",
+					"detail": "Authorization failed",
+					"status": 403
+				},
+				"authorizations": ["` + ts.URL + `/authz/1"],
+				"finalize": "` + ts.URL + `/order/1/finalize"
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}, func(ts *httptest.Server) string { return "" })
+	defer ts.Close()
+
+	order, err := client.GetOrder(ts.URL + "/order/1")
+	if err != nil {
+		t.Fatalf("Unexpected error getting the order: %v", err)
+	}
+
+	if order.URL != ts.URL+"/order/1" {
+		t.Errorf("Expected the URL of the order, got %q", order.URL)
+	}
+	if order.Status != "invalid" {
+		t.Errorf("Expected the status invalid, got %q", order.Status)
+	}
+	if expected := time.Date(2021, 1, 8, 12, 0, 0, 0, time.UTC); !order.Expires.Equal(expected) {
+		t.Errorf("Expected the order to expire at %v, got %v", expected, order.Expires)
+	}
+	if expected := time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC); !order.NotAfter.Equal(expected) {
+		t.Errorf("Expected notAfter %v, got %v", expected, order.NotAfter)
+	}
+	if len(order.Identifiers) != 1 || order.Identifiers[0] != (Identifier{Type: "dns", Value: "example.com"}) {
+		t.Errorf("Unexpected identifiers: %v", order.Identifiers)
+	}
+	if len(order.Authorizations) != 1 || order.Authorizations[0] != ts.URL+"/authz/1" {
+		t.Errorf("Unexpected authorizations: %v", order.Authorizations)
+	}
+	if order.Error == nil || order.Error.Type != "urn:ietf:params:acme:error:unauthorized" || order.Error.StatusCode != http.StatusForbidden {
+		t.Errorf("Unexpected error of the order: %v", order.Error)
+	}
+	if order.Certificate != "" {
+		t.Errorf("Expected no certificate, got %q", order.Certificate)
+	}
+
+	if _, err = client.GetOrder(ts.URL + "/order/2"); err == nil {
+		t.Error("Expected an error for a missing order")
+	}
+}
+
+func TestGetAuthorization(t *testing.T) {
+	client, ts := newOrdersTestClient(t, func(ts *httptest.Server, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/authz/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{
+				"status": "invalid",
+				"expires": "2021-01-08T12:00:00Z",
+				"identifier": {"type": "dns", "value": "example.com"},
+				"challenges": [
+					{
+						"url": "` + ts.URL + `/chall/1",
+						"type": "http-01",
+						"status": "invalid",
+						"token": "DGyRejmCefe7v4NfDGDKfA",
+						"error": {
+							"type": "urn:ietf:params:acme:error:connection",
+							"detail": "Connection refused",
+							"status": 400
+						}
+					},
+					{
+						"url": "` + ts.URL + `/chall/2",
+						"type": "dns-01",
+						"status": "pending",
+						"token": "IlirfxKKXAsHtmzK29Pj8A"
+					}
+				],
+				"wildcard": true
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}, func(ts *httptest.Server) string { return "" })
+	defer ts.Close()
+
+	authz, err := client.GetAuthorization(ts.URL + "/authz/1")
+	if err != nil {
+		t.Fatalf("Unexpected error getting the authorization: %v", err)
+	}
+
+	if authz.URL != ts.URL+"/authz/1" {
+		t.Errorf("Expected the URL of the authorization, got %q", authz.URL)
+	}
+	if authz.Status != "invalid" || !authz.Wildcard {
+		t.Errorf("Expected an invalid wildcard authorization, got %q (wildcard: %v)", authz.Status, authz.Wildcard)
+	}
+	if authz.Identifier != (Identifier{Type: "dns", Value: "example.com"}) {
+		t.Errorf("Unexpected identifier: %v", authz.Identifier)
+	}
+	if len(authz.Challenges) != 2 {
+		t.Fatalf("Expected 2 challenges, got %d", len(authz.Challenges))
+	}
+
+	httpChallenge := authz.Challenges[0]
+	if httpChallenge.Type != HTTP01 || httpChallenge.Status != "invalid" || httpChallenge.URL != ts.URL+"/chall/1" {
+		t.Errorf("Unexpected challenge: %+v", httpChallenge)
+	}
+	if httpChallenge.Error == nil || httpChallenge.Error.Detail != "Connection refused" {
+		t.Errorf("Unexpected error of the challenge: %v", httpChallenge.Error)
+	}
+
+	dnsChallenge := authz.Challenges[1]
+	if dnsChallenge.Type != DNS01 || dnsChallenge.Status != "pending" || dnsChallenge.Token != "IlirfxKKXAsHtmzK29Pj8A" {
+		t.Errorf("Unexpected challenge: %+v", dnsChallenge)
+	}
+	if dnsChallenge.Error != nil {
+		t.Errorf("Expected no error for the pending challenge, got %v", dnsChallenge.Error)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	client, ts := newOrdersTestClient(t, func(ts *httptest.Server, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orders":
+			if r.URL.Query().Get("cursor") == "" {
+				w.Header().Add("Link", `<`+ts.URL+`/orders?cursor=2>;rel="next"`)
+				writeJSONResponse(w, ordersListMessage{Orders: []string{ts.URL + "/order/1"}})
+				return
+			}
+			writeJSONResponse(w, ordersListMessage{Orders: []string{ts.URL + "/order/2"}})
+		case "/order/1":
+			writeOrder(w, ts, "pending")
+		case "/order/2":
+			writeOrder(w, ts, "ready")
+		default:
+			http.NotFound(w, r)
+		}
+	}, func(ts *httptest.Server) string { return ts.URL + "/orders" })
+	defer ts.Close()
+
+	orders, err := client.ListOrders()
+	if err != nil {
+		t.Fatalf("Unexpected error listing the orders: %v", err)
+	}
+
+	if len(orders) != 2 {
+		t.Fatalf("Expected 2 orders, got %d", len(orders))
+	}
+	for i, expected := range []string{"pending", "ready"} {
+		if orders[i].Status != expected {
+			t.Errorf("Expected the order %d to be %s, got %q", i, expected, orders[i].Status)
+		}
+		if len(orders[i].Authorizations) != 2 {
+			t.Errorf("Expected 2 authorizations for the order %d, got %v", i, orders[i].Authorizations)
+		}
+	}
+	if orders[1].URL != ts.URL+"/order/2" {
+		t.Errorf("Expected the URL of the second order, got %q", orders[1].URL)
+	}
+}
+
+func TestListOrdersNotSupported(t *testing.T) {
+	client, ts := newOrdersTestClient(t, func(ts *httptest.Server, w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}, func(ts *httptest.Server) string { return "" })
+	defer ts.Close()
+
+	if _, err := client.ListOrders(); err != ErrOrdersListNotSupported {
+		t.Errorf("Expected ErrOrdersListNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations is the number of iterations of the key derivations, the default of OpenSSL.
+const pkcs12Iterations = 2048
+
+var (
+	oidDataContentType         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTDES   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                    = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidPublicKeyRSA            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyECDSA          = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveP224          = asn1.ObjectIdentifier{1, 3, 132, 0, 33}
+	oidNamedCurveP256          = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384          = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521          = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// IDs of the PKCS#12 key derivation (RFC 7292, appendix B.3).
+const (
+	pkcs12KeyID = 1
+	pkcs12IVID  = 2
+	pkcs12MACID = 3
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// PKCS12 returns the certificate and its private key as a PKCS#12 (.p12, .pfx) archive protected by the password,
+// which may be empty. The issuer chain (see IssuerPEM) is included if withChain is true.
+func (c *CertificateResource) PKCS12(password string, withChain bool) ([]byte, error) {
+	if len(c.PrivateKey) == 0 {
+		return nil, errors.New("the certificate has no private key")
+	}
+	if block, _ := pem.Decode(c.PrivateKey); block == nil {
+		return nil, errors.New("invalid private key: no PEM block")
+	}
+
+	privateKey, err := parsePEMPrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	leafPEM, err := c.LeafPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := pemDecodeTox509(leafPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	var caCerts []*x509.Certificate
+	if withChain {
+		issuers, err := c.IssuerPEM()
+		if err != nil {
+			return nil, err
+		}
+		if len(issuers) > 0 {
+			caCerts, err = parsePEMBundle(issuers)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return EncodePKCS12(privateKey, leaf, caCerts, password)
+}
+
+// EncodePKCS12 packs the private key, its certificate and the CA certificates into a PKCS#12 archive protected by the password.
+// The private key (RSA or ECDSA) is encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC, the certificates are not encrypted,
+// and the archive is authenticated with a HMAC-SHA1, as supported by Windows, Java keystores and OpenSSL.
+func EncodePKCS12(privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) ([]byte, error) {
+	encodedPassword := bmpString(password)
+
+	// The local key ID ties the private key to its certificate.
+	localKeyID := sha1.Sum(certificate.Raw)
+	attributes, err := localKeyIDAttributes(localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, cert := range append([]*x509.Certificate{certificate}, caCerts...) {
+		bag, err := newCertBag(cert)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+
+	keyBag, err := newShroudedKeyBag(privateKey, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = attributes
+
+	var authSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, {keyBag}} {
+		info, err := newDataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, info)
+	}
+
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	pfx := pfxPdu{Version: 3}
+
+	pfx.AuthSafe, err = newContentInfo(authSafeContent)
+	if err != nil {
+		return nil, err
+	}
+
+	pfx.MacData, err = newMacData(authSafeContent, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pfx)
+}
+
+func localKeyIDAttributes(localKeyID []byte) ([]pkcs12Attribute, error) {
+	value, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value}}}, nil
+}
+
+func newCertBag(cert *x509.Certificate) (safeBag, error) {
+	value, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	return safeBag{ID: oidCertBag, Value: explicitValue(value)}, nil
+}
+
+func newShroudedKeyBag(privateKey crypto.PrivateKey, password []byte) (safeBag, error) {
+	der, err := marshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	salt := make([]byte, 8)
+	if _, err = rand.Read(salt); err != nil {
+		return safeBag{}, err
+	}
+
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	encrypted, err := pbeEncrypt(der, password, salt, pkcs12Iterations)
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	value, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	return safeBag{ID: oidPKCS8ShroudedKeyBag, Value: explicitValue(value)}, nil
+}
+
+// marshalPKCS8PrivateKey converts an RSA or ECDSA private key to PKCS#8 form (RFC 5208).
+// x509.MarshalPKCS8PrivateKey is not used as it requires Go 1.10.
+func marshalPKCS8PrivateKey(privateKey crypto.PrivateKey) ([]byte, error) {
+	var info pkcs8
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		info.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyRSA, Parameters: asn1.NullRawValue}
+		info.PrivateKey = x509.MarshalPKCS1PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		var oid asn1.ObjectIdentifier
+		switch key.Curve {
+		case elliptic.P224():
+			oid = oidNamedCurveP224
+		case elliptic.P256():
+			oid = oidNamedCurveP256
+		case elliptic.P384():
+			oid = oidNamedCurveP384
+		case elliptic.P521():
+			oid = oidNamedCurveP521
+		default:
+			return nil, errors.New("unsupported private key: unknown elliptic curve")
+		}
+
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		info.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}}
+
+		info.PrivateKey, err = x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key: unknown type %T", privateKey)
+	}
+
+	return asn1.Marshal(info)
+}
+
+func newDataContentInfo(bags []safeBag) (contentInfo, error) {
+	content, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return newContentInfo(content)
+}
+
+// newContentInfo returns a data content info holding the content in an octet string.
+func newContentInfo(content []byte) (contentInfo, error) {
+	data, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: explicitValue(data)}, nil
+}
+
+func newMacData(content, password []byte) (macData, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return macData{}, err
+	}
+
+	return macData{
+		Mac: digestInfo{
+			Algorithm: algorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+			Digest:    pkcs12MAC(content, password, salt, pkcs12Iterations),
+		},
+		MacSalt:    salt,
+		Iterations: pkcs12Iterations,
+	}, nil
+}
+
+func explicitValue(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// pkcs12MAC returns the HMAC-SHA1 of the content keyed with the password.
+func pkcs12MAC(content, password, salt []byte, iterations int) []byte {
+	key := pkcs12KDF(password, salt, iterations, pkcs12MACID, sha1.Size)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(content)
+	return mac.Sum(nil)
+}
+
+// pbeEncrypt encrypts the data with pbeWithSHAAnd3-KeyTripleDES-CBC.
+func pbeEncrypt(data, password, salt []byte, iterations int) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(pkcs12KDF(password, salt, iterations, pkcs12KeyID, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pkcs12KDF(password, salt, iterations, pkcs12IVID, block.BlockSize())
+
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	padded := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	return encrypted, nil
+}
+
+// pkcs12KDF derives size bytes from the password and the salt with SHA-1 (RFC 7292, appendix B.2).
+func pkcs12KDF(password, salt []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fillBlocks(salt, v), fillBlocks(password, v)...)
+
+	var result []byte
+	for len(result) < size {
+		a := sha1.Sum(append(append([]byte(nil), d...), i...))
+		for r := 1; r < iterations; r++ {
+			a = sha1.Sum(a[:])
+		}
+		result = append(result, a[:]...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for each v bytes block of I, B being A repeated to v bytes.
+		b := fillBlocks(a[:], v)[:v]
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+
+	return result[:size]
+}
+
+// fillBlocks repeats the data to the smallest multiple of v bytes, empty data stays empty.
+func fillBlocks(data []byte, v int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	size := v * ((len(data) + v - 1) / v)
+	filled := make([]byte, size)
+	for i := range filled {
+		filled[i] = data[i%len(data)]
+	}
+	return filled
+}
+
+// bmpString encodes the password as a null terminated UTF-16 big endian string.
+func bmpString(s string) []byte {
+	var encoded []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		encoded = append(encoded, byte(r>>8), byte(r))
+	}
+	return append(encoded, 0, 0)
+}
@@ -0,0 +1,283 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCertificateResourcePKCS12(t *testing.T) {
+	bundle, issuer, leafKey := generateTestChain(t, nil)
+	cert := &CertificateResource{Certificate: bundle, PrivateKey: pemEncode(leafKey)}
+
+	leafPEM, _ := cert.LeafPEM()
+	leaf, _ := pemDecodeTox509(leafPEM)
+
+	for _, password := range []string{"secret", ""} {
+		for _, withChain := range []bool{true, false} {
+			p12, err := cert.PKCS12(password, withChain)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			key, certs, err := decodePKCS12(p12, password)
+			if err != nil {
+				t.Fatalf("Could not decode the PKCS#12 archive with the password %q: %v", password, err)
+			}
+
+			if !reflect.DeepEqual(key, leafKey) {
+				t.Errorf("Expected the original private key")
+			}
+
+			expected := []*x509.Certificate{leaf}
+			if withChain {
+				expected = append(expected, issuer)
+			}
+			if len(certs) != len(expected) {
+				t.Fatalf("Expected %d certificates, got %d", len(expected), len(certs))
+			}
+			for i := range certs {
+				if !certs[i].Equal(expected[i]) {
+					t.Errorf("Expected the certificate %s, got %s", expected[i].Subject, certs[i].Subject)
+				}
+			}
+
+			if _, _, err = decodePKCS12(p12, password+"x"); err == nil {
+				t.Error("Expected the decoding to fail with another password")
+			}
+		}
+	}
+}
+
+func TestEncodePKCS12ECDSA(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	p12, err := EncodePKCS12(privateKey, leaf, nil, "pässwörd")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	key, certs, err := decodePKCS12(p12, "pässwörd")
+	if err != nil {
+		t.Fatalf("Could not decode the PKCS#12 archive: %v", err)
+	}
+	if !reflect.DeepEqual(key, privateKey) {
+		t.Errorf("Expected the original private key")
+	}
+	if len(certs) != 1 || !certs[0].Equal(leaf) {
+		t.Errorf("Expected the original certificate, got %v", certs)
+	}
+}
+
+func TestCertificateResourcePKCS12NoKey(t *testing.T) {
+	bundle, _, _ := generateTestChain(t, nil)
+
+	for _, key := range [][]byte{nil, []byte("not a key")} {
+		cert := &CertificateResource{Certificate: bundle, PrivateKey: key}
+		if _, err := cert.PKCS12("", true); err == nil {
+			t.Errorf("Expected an error for the private key %q", key)
+		}
+	}
+}
+
+func TestMarshalPKCS8PrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []crypto.Signer{rsaKey}
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, ecKey)
+	}
+
+	for _, key := range keys {
+		der, err := marshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("Could not marshal the key %T: %v", key, err)
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			t.Fatalf("Could not parse the key %T: %v", key, err)
+		}
+		if !reflect.DeepEqual(parsed, key) {
+			t.Errorf("Expected the parsed key to be the marshaled %T", key)
+		}
+	}
+
+	if _, err = marshalPKCS8PrivateKey("not a key"); err == nil {
+		t.Error("Expected an error for an unsupported private key")
+	}
+}
+
+func TestPKCS12KDF(t *testing.T) {
+	// known answers computed with the PKCS12KDF of OpenSSL.
+	testCases := []struct {
+		password string
+		salt     []byte
+		id       byte
+		size     int
+		expected string
+	}{
+		{password: "sesame", salt: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, id: pkcs12KeyID, size: 24, expected: "7cd9fd3e2b3be7691a44e3bef0f9ea0fb9b897d4e325d9d1"},
+		{password: "sesame", salt: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, id: pkcs12IVID, size: 8, expected: "3f5a277f9c21ff82"},
+		{password: "sesame", salt: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, id: pkcs12MACID, size: 20, expected: "91715bd27aa978513e3a40f55b8f7b567b5a9f3c"},
+		// the addition of the blocks of I has a leading zero byte.
+		{password: "", salt: []byte{0xf3, 0x7e, 0x05, 0xb5, 0x18, 0x32, 0x4b, 0x4b}, id: pkcs12KeyID, size: 24, expected: "00f759ff47d14dd03665d5943cb3c4a39a2555c02aed66e1"},
+	}
+
+	for _, test := range testCases {
+		key := hex.EncodeToString(pkcs12KDF(bmpString(test.password), test.salt, 2048, test.id, test.size))
+		if key != test.expected {
+			t.Errorf("Expected the key %s for the password %q and the ID %d, got %s", test.expected, test.password, test.id, key)
+		}
+	}
+
+	mac := hex.EncodeToString(pkcs12MAC([]byte("lego"), bmpString("sesame"), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 2048))
+	if mac != "89038eeb9cd13f662607eab54d4adb15dc7157ff" {
+		t.Errorf("Unexpected MAC %s", mac)
+	}
+}
+
+func TestDecodePKCS12OpenSSL(t *testing.T) {
+	// created with: openssl pkcs12 -export -passout pass:lego -keypbe PBE-SHA1-3DES -certpbe NONE -macalg sha1 -iter 2048
+	data, err := ioutil.ReadFile("testdata/openssl.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decodePKCS12 checks the archives of EncodePKCS12, it must read the ones of OpenSSL.
+	key, certs, err := decodePKCS12(data, "lego")
+	if err != nil {
+		t.Fatalf("Could not decode the archive of OpenSSL: %v", err)
+	}
+
+	if len(certs) != 1 || certs[0].Subject.CommonName != "example.com" {
+		t.Fatalf("Expected the certificate of example.com, got %v", certs)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || !reflect.DeepEqual(ecKey.Public(), certs[0].PublicKey) {
+		t.Errorf("Expected the private key of the certificate, got %T", key)
+	}
+
+	if _, _, err = decodePKCS12(data, "wrong"); err == nil {
+		t.Error("Expected the MAC check to fail with a wrong password")
+	}
+}
+
+// decodePKCS12 decodes the archives produced by EncodePKCS12, checking their MAC with the password.
+func decodePKCS12(data []byte, password string) (crypto.PrivateKey, []*x509.Certificate, error) {
+	encodedPassword := bmpString(password)
+
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, nil, err
+	}
+
+	var authSafeContent []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeContent); err != nil {
+		return nil, nil, err
+	}
+
+	mac := pkcs12MAC(authSafeContent, encodedPassword, pfx.MacData.MacSalt, pfx.MacData.Iterations)
+	if !hmac.Equal(mac, pfx.MacData.Mac.Digest) {
+		return nil, nil, errors.New("invalid MAC")
+	}
+
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeContent, &authSafe); err != nil {
+		return nil, nil, err
+	}
+
+	var key crypto.PrivateKey
+	var certs []*x509.Certificate
+
+	for _, info := range authSafe {
+		var content []byte
+		if _, err := asn1.Unmarshal(info.Content.Bytes, &content); err != nil {
+			return nil, nil, err
+		}
+
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(content, &bags); err != nil {
+			return nil, nil, err
+		}
+
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, err
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, err
+				}
+				certs = append(certs, cert)
+
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				var info encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+					return nil, nil, err
+				}
+				var params pbeParams
+				if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+					return nil, nil, err
+				}
+
+				block, _ := des.NewTripleDESCipher(pkcs12KDF(encodedPassword, params.Salt, params.Iterations, pkcs12KeyID, 24))
+				iv := pkcs12KDF(encodedPassword, params.Salt, params.Iterations, pkcs12IVID, block.BlockSize())
+
+				decrypted := make([]byte, len(info.EncryptedData))
+				cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+				padding := int(decrypted[len(decrypted)-1])
+
+				var err error
+				key, err = x509.ParsePKCS8PrivateKey(decrypted[:len(decrypted)-padding])
+				if err != nil {
+					return nil, nil, err
+				}
+
+			default:
+				return nil, nil, errors.New("unexpected bag " + bag.ID.String())
+			}
+		}
+	}
+
+	return key, certs, nil
+}
@@ -5,7 +5,8 @@ import "time"
 // ChallengeProvider enables implementing a custom challenge
 // provider. Present presents the solution to a challenge available to
 // be solved. CleanUp will be called by the challenge if Present ends
-// in a non-error state.
+// in a non-error state. The domain of a wildcard is given without its
+// "*.": a wildcard is validated with the record of its base domain.
 type ChallengeProvider interface {
 	Present(domain, token, keyAuth string) error
 	CleanUp(domain, token, keyAuth string) error
@@ -26,3 +27,27 @@ type ChallengeProviderTimeout interface {
 	ChallengeProvider
 	Timeout() (timeout, interval time.Duration)
 }
+
+// ProviderMultiValue allows for implementing a ChallengeProvider
+// which is able to present several dns-01 values for the same record
+// at once. This is needed when a certificate covers a domain and its
+// wildcard: both challenges use the same _acme-challenge record, and
+// providers replacing the record would otherwise clobber the first
+// value. If an implementor of a ChallengeProvider provides these
+// methods, all key authorizations of a domain are passed together.
+type ProviderMultiValue interface {
+	ChallengeProvider
+	PresentMultiValue(domain string, keyAuths []string) error
+	CleanUpMultiValue(domain string, keyAuths []string) error
+}
+
+// ProviderPreflight allows for implementing a ChallengeProvider which
+// is able to check that the challenge of a domain can be solved
+// without presenting it, e.g. that its credentials are valid and that
+// the zone of the domain exists. If an implementor of a
+// ChallengeProvider provides a Preflight method, it is called by
+// Client.Preflight. It must not change anything.
+type ProviderPreflight interface {
+	ChallengeProvider
+	Preflight(domain string) error
+}
@@ -0,0 +1,146 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUseProxy(t *testing.T) {
+	testCases := []struct {
+		url      string
+		noProxy  string
+		expected bool
+	}{
+		{url: "https://acme.example/directory", expected: true},
+		{url: "http://localhost:8080/", expected: false},
+		{url: "http://127.0.0.1/", expected: false},
+		{url: "http://[::1]/", expected: false},
+		{url: "https://acme.example/", noProxy: "*", expected: false},
+		{url: "https://acme.example/", noProxy: "other.example, acme.example", expected: false},
+		{url: "https://sub.acme.example/", noProxy: ".acme.example", expected: false},
+		{url: "https://sub.acme.example/", noProxy: "acme.example", expected: false},
+		{url: "https://notacme.example/", noProxy: "acme.example", expected: true},
+		{url: "https://acme.example/", noProxy: "acme.example:443", expected: false},
+		{url: "https://acme.example:14000/", noProxy: "acme.example:443", expected: true},
+		{url: "https://10.1.2.3/", noProxy: "10.0.0.0/8", expected: false},
+		{url: "https://192.168.1.1/", noProxy: "10.0.0.0/8", expected: true},
+	}
+
+	for _, test := range testCases {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := useProxy(u, test.noProxy); actual != test.expected {
+			t.Errorf("useProxy(%s, %q): expected %v, got %v", test.url, test.noProxy, test.expected, actual)
+		}
+	}
+}
+
+// stubProxy is a forward proxy requiring credentials, serving itself
+// a minimal ACME server for acme.example and a provider API for provider.example.
+type stubProxy struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (p *stubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.requests = append(p.requests, r.Method+" "+r.URL.String())
+	p.mu.Unlock()
+
+	if expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:p@ss")); r.Header.Get("Proxy-Authorization") != expected {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	w.Header().Set("Replay-Nonce", "12345")
+
+	switch r.URL.Host + r.URL.Path {
+	case "acme.example/directory":
+		writeJSONResponse(w, directory{
+			NewNonceURL:   "http://acme.example/nonce",
+			NewAccountURL: "http://acme.example/account",
+			NewOrderURL:   "http://acme.example/order",
+			RevokeCertURL: "http://acme.example/revoke",
+			KeyChangeURL:  "http://acme.example/key-change",
+		})
+	case "acme.example/nonce":
+	case "acme.example/challenge":
+		writeJSONResponse(w, challenge{Type: "http-01", Status: "valid", URL: "http://acme.example/challenge", Token: "token"})
+	case "provider.example/api":
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestProxyFromEnvironmentLegoProxy(t *testing.T) {
+	proxy := &stubProxy{}
+	ts := httptest.NewServer(proxy)
+	defer ts.Close()
+
+	proxyURL, _ := url.Parse(ts.URL)
+	proxyURL.User = url.UserPassword("user", "p@ss")
+
+	defer os.Unsetenv(proxyEnvVar)
+	os.Setenv(proxyEnvVar, proxyURL.String())
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{email: "test@test.com", regres: new(RegistrationResource), privatekey: key}
+
+	client, err := NewClient("http://acme.example/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client through the proxy: %v", err)
+	}
+
+	if _, err = client.jws.Nonce(); err != nil {
+		t.Fatalf("Could not get a nonce through the proxy: %v", err)
+	}
+
+	err = validate(context.Background(), client.jws, "example.com", "http://acme.example/challenge", challenge{Type: "http-01", Token: "token"})
+	if err != nil {
+		t.Fatalf("Could not notify the challenge through the proxy: %v", err)
+	}
+
+	resp, err := NewHTTPClient(10 * time.Second).Get("http://provider.example/api")
+	if err != nil {
+		t.Fatalf("Could not call the provider API through the proxy: %v", err)
+	}
+	resp.Body.Close()
+
+	expected := []string{
+		"GET http://acme.example/directory",
+		"HEAD http://acme.example/nonce",
+		"HEAD http://acme.example/nonce",
+		"POST http://acme.example/challenge",
+		"GET http://provider.example/api",
+	}
+	if !reflect.DeepEqual(expected, proxy.requests) {
+		t.Errorf("Expected the requests %v to transit the proxy, got %v", expected, proxy.requests)
+	}
+
+	// the hosts of NO_PROXY are requested directly.
+	defer os.Unsetenv("NO_PROXY")
+	os.Setenv("NO_PROXY", "provider.example")
+
+	u, err := ProxyFromEnvironment(httptest.NewRequest(http.MethodGet, "http://provider.example/api", nil))
+	if err != nil || u != nil {
+		t.Errorf("Expected no proxy for a host of NO_PROXY, got %v, %v", u, err)
+	}
+}
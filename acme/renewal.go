@@ -0,0 +1,95 @@
+package acme
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/xenolf/lego/log"
+)
+
+// RenewalCandidates returns the PEM encoded certificates (or bundles) which expire within the given duration.
+// An error is returned if one of the certificates cannot be parsed.
+func RenewalCandidates(certs [][]byte, within time.Duration) ([][]byte, error) {
+	return renewalCandidates(certs, within, time.Now())
+}
+
+// renewalCandidates returns the certificates which expire within the given duration from now.
+func renewalCandidates(certs [][]byte, within time.Duration, now time.Time) ([][]byte, error) {
+	deadline := now.Add(within)
+
+	var candidates [][]byte
+	for i, cert := range certs {
+		x509Cert, err := parseLeafCertificate(cert)
+		if err != nil {
+			return nil, fmt.Errorf("acme: certificate %d: %v", i, err)
+		}
+
+		if !x509Cert.NotAfter.After(deadline) {
+			candidates = append(candidates, cert)
+		}
+	}
+
+	return candidates, nil
+}
+
+// RenewIfDue renews the certificates which expire within the given duration.
+// If the CA supports the ACME Renewal Information (ARI) extension, a certificate is also renewed
+// when its suggested renewal window has started.
+// The renewed certificates and the errors are returned keyed by the index of their input certificate,
+// so a single failure doesn't abort the batch. The certificates which are not due are in none of them.
+func (c *Client) RenewIfDue(certs []CertificateResource, within time.Duration, bundle, mustStaple bool) (map[int]*CertificateResource, map[int]error) {
+	renewed := make(map[int]*CertificateResource)
+	failures := make(map[int]error)
+
+	for i, cert := range certs {
+		x509Cert, err := parseLeafCertificate(cert.Certificate)
+		if err != nil {
+			failures[i] = fmt.Errorf("[%s] acme: %v", cert.Domain, err)
+			continue
+		}
+
+		if !c.isRenewalDue(cert.Domain, x509Cert, within) {
+			log.Infof("[%s] acme: The certificate expires on %s, no need to renew", cert.Domain, x509Cert.NotAfter.Format(time.RFC3339))
+			continue
+		}
+
+		newCert, err := c.RenewCertificate(cert, bundle, mustStaple)
+		if err != nil {
+			failures[i] = err
+			continue
+		}
+		renewed[i] = newCert
+	}
+
+	return renewed, failures
+}
+
+// isRenewalDue checks the expiry of the certificate, then its ARI suggested window if available.
+func (c *Client) isRenewalDue(domain string, cert *x509.Certificate, within time.Duration) bool {
+	now := c.now()
+	if !cert.NotAfter.After(now.Add(within)) {
+		return true
+	}
+
+	info, err := c.GetRenewalInfo(cert)
+	if err == ErrRenewalInfoNotSupported {
+		return false
+	}
+	if err != nil {
+		log.Warnf("[%s] acme: Could not get the renewal information, relying on the expiry date: %v", domain, err)
+		return false
+	}
+
+	return !info.SuggestedWindow.Start.After(now)
+}
+
+// parseLeafCertificate returns the first certificate of a PEM encoded certificate or bundle.
+func parseLeafCertificate(cert []byte) (*x509.Certificate, error) {
+	certificates, err := parsePEMBundle(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return certificates[0], nil
+}
@@ -0,0 +1,101 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRenewalInfoNotSupported is returned by GetRenewalInfo when the directory
+// of the ACME server does not advertise the renewalInfo endpoint.
+var ErrRenewalInfoNotSupported = errors.New("acme: the server does not support the ACME Renewal Information (ARI) extension")
+
+// RenewalInfo is the renewal information of a certificate, as suggested by the CA.
+type RenewalInfo struct {
+	// SuggestedWindow is the time window in which the certificate should be renewed.
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	// ExplanationURL is an optional URL explaining the suggested window, e.g. an incident report.
+	ExplanationURL string `json:"explanationURL,omitempty"`
+	// RetryAfter is the duration to wait before polling the renewal information again.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// RenewalWindow is the time window suggested by the CA to renew a certificate.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// GetRenewalInfo fetches the renewal information of the certificate from the CA,
+// using the ACME Renewal Information (ARI) extension.
+// ErrRenewalInfoNotSupported is returned if the CA does not support it.
+func (c *Client) GetRenewalInfo(cert *x509.Certificate) (*RenewalInfo, error) {
+	if c.directory.RenewalInfo == "" {
+		return nil, ErrRenewalInfoNotSupported
+	}
+
+	certID, err := renewalInfoCertID(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	var info RenewalInfo
+	hdr, err := getJSON(context.Background(), c.jws.httpClient(), strings.TrimSuffix(c.directory.RenewalInfo, "/")+"/"+certID, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	info.RetryAfter = parseRetryAfter(hdr.Get("Retry-After"), c.now())
+
+	return &info, nil
+}
+
+// renewalInfoCertID computes the ARI certificate identifier: the base64url encoded
+// key identifier of the Authority Key Identifier extension and the base64url encoded
+// DER content of the serial number, separated by a dot.
+func renewalInfoCertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", errors.New("acme: the certificate has no authority key identifier")
+	}
+	if cert.SerialNumber == nil || cert.SerialNumber.Sign() < 0 {
+		return "", errors.New("acme: the certificate has an invalid serial number")
+	}
+
+	// The DER encoding of a positive integer is prefixed with a 0 when its most significant bit is set.
+	serial := cert.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+
+	return fmt.Sprintf("%s.%s",
+		base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId),
+		base64.RawURLEncoding.EncodeToString(serial)), nil
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date,
+// the date being relative to now.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := time.Parse(time.RFC1123, value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
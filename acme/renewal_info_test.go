@@ -0,0 +1,129 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenewalInfoCertID(t *testing.T) {
+	aki, _ := hex.DecodeString("69885b6b87465e2bd5d4e9ec0000c3e2b2a1c3ac")
+
+	testCases := []struct {
+		serial   string
+		expected string
+	}{
+		{serial: "87654321", expected: "aYhba4dGXivV1OnsAADD4rKhw6w.AIdlQyE"},
+		{serial: "0123", expected: "aYhba4dGXivV1OnsAADD4rKhw6w.ASM"},
+	}
+
+	for _, test := range testCases {
+		serial, ok := new(big.Int).SetString(test.serial, 16)
+		if !ok {
+			t.Fatalf("Invalid serial %s", test.serial)
+		}
+
+		certID, err := renewalInfoCertID(&x509.Certificate{AuthorityKeyId: aki, SerialNumber: serial})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if certID != test.expected {
+			t.Errorf("Expected CertID %s for serial %s, got %s", test.expected, test.serial, certID)
+		}
+	}
+
+	if _, err := renewalInfoCertID(&x509.Certificate{SerialNumber: big.NewInt(1)}); err == nil {
+		t.Error("Expected an error for a certificate without authority key identifier")
+	}
+}
+
+func TestGetRenewalInfo(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	cert := &x509.Certificate{AuthorityKeyId: []byte{1, 2, 3}, SerialNumber: big.NewInt(0xff)}
+
+	retryAfter := "21600"
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, directory{
+				NewNonceURL:   ts.URL + "/nonce",
+				NewAccountURL: ts.URL + "/account",
+				NewOrderURL:   ts.URL + "/newOrder",
+				RevokeCertURL: ts.URL + "/revokeCert",
+				KeyChangeURL:  ts.URL + "/keyChange",
+				RenewalInfo:   ts.URL + "/renewalInfo",
+			})
+		case "/renewalInfo/AQID.AP8":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", retryAfter)
+			w.Write([]byte(`{
+				"suggestedWindow": {
+					"start": "2021-01-03T00:00:00Z",
+					"end": "2021-01-07T00:00:00Z"
+				},
+				"explanationURL": "https://acme.example.com/docs/ari"
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     new(RegistrationResource),
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	info, err := client.GetRenewalInfo(cert)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the renewal info: %v", err)
+	}
+
+	if expected := time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC); !info.SuggestedWindow.Start.Equal(expected) {
+		t.Errorf("Expected the window to start at %v, got %v", expected, info.SuggestedWindow.Start)
+	}
+	if expected := time.Date(2021, 1, 7, 0, 0, 0, 0, time.UTC); !info.SuggestedWindow.End.Equal(expected) {
+		t.Errorf("Expected the window to end at %v, got %v", expected, info.SuggestedWindow.End)
+	}
+	if info.ExplanationURL != "https://acme.example.com/docs/ari" {
+		t.Errorf("Unexpected explanation URL: %s", info.ExplanationURL)
+	}
+	if info.RetryAfter != 6*time.Hour {
+		t.Errorf("Expected to retry after 6h, got %v", info.RetryAfter)
+	}
+
+	// the Retry-After date is relative to the clock of the client.
+	client.setClock(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC))
+	retryAfter = "Sat, 02 Jan 2021 12:00:00 GMT"
+
+	info, err = client.GetRenewalInfo(cert)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the renewal info: %v", err)
+	}
+	if info.RetryAfter != 12*time.Hour {
+		t.Errorf("Expected to retry after 12h, got %v", info.RetryAfter)
+	}
+
+	client.directory.RenewalInfo = ""
+	if _, err := client.GetRenewalInfo(cert); err != ErrRenewalInfoNotSupported {
+		t.Errorf("Expected ErrRenewalInfoNotSupported, got %v", err)
+	}
+}
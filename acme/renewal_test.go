@@ -0,0 +1,185 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// generateCertExpiringIn returns a PEM encoded self-signed certificate for the domain expiring in the given duration.
+func generateCertExpiringIn(t *testing.T, domain string, expiresIn time.Duration) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Error generating private key:", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: domain},
+		DNSNames:       []string{domain},
+		AuthorityKeyId: []byte{1, 2, 3, 4},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(expiresIn),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error generating certificate:", err)
+	}
+
+	return pemEncode(derCertificateBytes(der))
+}
+
+// setClock replaces the clock of the client by a fake one starting at now,
+// and returns a function advancing it.
+func (c *Client) setClock(now time.Time) func(d time.Duration) {
+	c.clock = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRenewalCandidates(t *testing.T) {
+	soon := generateCertExpiringIn(t, "soon.example.com", time.Hour)
+	month := generateCertExpiringIn(t, "month.example.com", 20*24*time.Hour)
+	later := generateCertExpiringIn(t, "later.example.com", 60*24*time.Hour)
+	expired := generateCertExpiringIn(t, "expired.example.com", -time.Minute)
+
+	candidates, err := RenewalCandidates([][]byte{soon, later, month, expired}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(candidates) != 3 || string(candidates[0]) != string(soon) || string(candidates[1]) != string(month) || string(candidates[2]) != string(expired) {
+		t.Errorf("Expected the certificates expiring within 30 days, got %d candidates", len(candidates))
+	}
+
+	_, err = RenewalCandidates([][]byte{soon, []byte("not a certificate")}, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "certificate 1") {
+		t.Errorf("Expected an error for the invalid certificate, got %v", err)
+	}
+}
+
+func TestRenewalCandidatesCrossingThreshold(t *testing.T) {
+	cert := generateCertExpiringIn(t, "month.example.com", 40*24*time.Hour)
+	now := time.Now()
+
+	candidates, err := renewalCandidates([][]byte{cert}, 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(candidates) != 0 {
+		t.Errorf("Expected the certificate not to be a candidate yet, got %d candidates", len(candidates))
+	}
+
+	candidates, err = renewalCandidates([][]byte{cert}, 30*24*time.Hour, now.Add(11*24*time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(candidates) != 1 {
+		t.Errorf("Expected the certificate to be a candidate 11 days later, got %d candidates", len(candidates))
+	}
+}
+
+func TestIsRenewalDueClock(t *testing.T) {
+	cert, err := parseLeafCertificate(generateCertExpiringIn(t, "month.example.com", 40*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{}
+	advance := client.setClock(time.Now())
+
+	if client.isRenewalDue("month.example.com", cert, 30*24*time.Hour) {
+		t.Error("Expected the renewal not to be due 40 days before the expiry")
+	}
+
+	advance(9 * 24 * time.Hour)
+	if client.isRenewalDue("month.example.com", cert, 30*24*time.Hour) {
+		t.Error("Expected the renewal not to be due 31 days before the expiry")
+	}
+
+	advance(2 * 24 * time.Hour)
+	if !client.isRenewalDue("month.example.com", cert, 30*24*time.Hour) {
+		t.Error("Expected the renewal to be due 29 days before the expiry")
+	}
+}
+
+func TestIsRenewalDueRenewalInfoClock(t *testing.T) {
+	start := time.Now().Add(5 * 24 * time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, RenewalInfo{SuggestedWindow: RenewalWindow{Start: start, End: start.Add(2 * 24 * time.Hour)}})
+	}))
+	defer ts.Close()
+
+	cert, err := parseLeafCertificate(generateCertExpiringIn(t, "later.example.com", 60*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{directory: directory{RenewalInfo: ts.URL}, jws: &jws{}}
+	advance := client.setClock(time.Now())
+
+	if client.isRenewalDue("later.example.com", cert, 30*24*time.Hour) {
+		t.Error("Expected the renewal not to be due before the suggested window")
+	}
+
+	advance(6 * 24 * time.Hour)
+	if !client.isRenewalDue("later.example.com", cert, 30*24*time.Hour) {
+		t.Error("Expected the renewal to be due once the suggested window has started")
+	}
+}
+
+func TestRenewIfDue(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	certs := []CertificateResource{
+		{Domain: "soon.example.com", Certificate: generateCertExpiringIn(t, "soon.example.com", time.Hour)},
+		{Domain: "later.example.com", Certificate: generateCertExpiringIn(t, "later.example.com", 60*24*time.Hour)},
+		{Domain: "invalid.example.com", Certificate: []byte("not a certificate")},
+	}
+
+	// the client has no ACME server, so the renewals of the due certificates fail.
+	client := &Client{jws: &jws{privKey: key}, keyType: RSA2048}
+
+	renewed, failures := client.RenewIfDue(certs, 30*24*time.Hour, true, false)
+
+	if len(renewed) != 0 {
+		t.Errorf("Expected no renewed certificates, got %v", renewed)
+	}
+	if len(failures) != 2 || failures[0] == nil || failures[2] == nil {
+		t.Errorf("Expected the renewals of the certificates 0 and 2 to fail, got %v", failures)
+	}
+	if _, ok := failures[1]; ok {
+		t.Error("Expected the certificate 1 not to be renewed")
+	}
+}
+
+func TestRenewIfDueRenewalInfo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, RenewalInfo{SuggestedWindow: RenewalWindow{
+			Start: time.Now().Add(-time.Hour),
+			End:   time.Now().Add(time.Hour),
+		}})
+	}))
+	defer ts.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	certs := []CertificateResource{
+		{Domain: "later.example.com", Certificate: generateCertExpiringIn(t, "later.example.com", 60*24*time.Hour)},
+	}
+
+	client := &Client{directory: directory{RenewalInfo: ts.URL}, jws: &jws{privKey: key}, keyType: RSA2048}
+
+	// the suggested window has started: the renewal is attempted even if the certificate does not expire soon.
+	_, failures := client.RenewIfDue(certs, 30*24*time.Hour, true, false)
+	if failures[0] == nil {
+		t.Error("Expected the renewal of the certificate to be attempted")
+	}
+}
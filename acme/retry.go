@@ -0,0 +1,130 @@
+package acme
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/log"
+)
+
+// RetryPolicy configures the retries of the idempotent requests to the ACME server
+// (directory, nonces, authorizations, orders and certificates) after a network error
+// or a 500, 502, 503 or 504 response.
+// The POST requests are never retried: they could have been processed by the server.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries of a request, 0 disables the retries.
+	MaxRetries int
+	// InitialInterval is the delay before the first retry, doubled for each following retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between two retries.
+	MaxInterval time.Duration
+	// MaxElapsedTime caps the time spent on a request and its retries.
+	MaxElapsedTime time.Duration
+}
+
+// HTTPRetryPolicy is the retry policy of the requests to the ACME server.
+// A Retry-After header sent by the server is used instead of the computed delay.
+var HTTPRetryPolicy = RetryPolicy{
+	MaxRetries:      4,
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	MaxElapsedTime:  time.Minute,
+}
+
+// doWithRetry sends an idempotent request, retrying it according to HTTPRetryPolicy.
+// The request must have no body.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	policy := HTTPRetryPolicy
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= policy.MaxRetries || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		delay := policy.delay(attempt, resp)
+		if time.Since(start)+delay > policy.MaxElapsedTime {
+			return resp, err
+		}
+
+		if resp != nil {
+			log.Infof("acme: %s %s returned %s, retrying in %v", req.Method, req.URL, resp.Status, delay)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Infof("acme: %s %s failed, retrying in %v: %v", req.Method, req.URL, delay, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// delay returns the Retry-After delay of the response if any,
+// otherwise an exponential backoff with jitter for the given attempt (starting at 0).
+func (p RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); retryAfter > 0 {
+			return retryAfter
+		}
+	}
+
+	delay := p.InitialInterval
+	for i := 0; i < attempt && delay < p.MaxInterval; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxInterval {
+		delay = p.MaxInterval
+	}
+
+	// jitter in [delay/2, delay[ to spread the retries of concurrent clients.
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half))
+	}
+
+	return delay
+}
+
+// isRetryable reports whether a request failed because of a transient server or network error.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+
+		if isDNSNotFound(err) {
+			return false
+		}
+
+		_, ok := err.(net.Error)
+		return ok || err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDNSNotFound reports whether the error is a DNS error for a host which does not exist,
+// retrying would not help.
+func isDNSNotFound(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && strings.Contains(dnsErr.Err, "no such host")
+}
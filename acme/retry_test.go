@@ -0,0 +1,140 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// withRetryPolicy sets HTTPRetryPolicy for the duration of a test.
+func withRetryPolicy(policy RetryPolicy) func() {
+	saved := HTTPRetryPolicy
+	HTTPRetryPolicy = policy
+	return func() { HTTPRetryPolicy = saved }
+}
+
+func TestHTTPGetRetriesTransientErrors(t *testing.T) {
+	defer withRetryPolicy(RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxElapsedTime: time.Second})()
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSONResponse(w, directory{NewAccountURL: "/newAccount", NewOrderURL: "/newOrder"})
+	}))
+	defer ts.Close()
+
+	var dir directory
+	if _, err := getJSON(context.Background(), &HTTPClient, ts.URL, &dir); err != nil {
+		t.Fatalf("Expected the request to succeed after 2 retries: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if dir.NewOrderURL != "/newOrder" {
+		t.Errorf("Expected the response of the last request, got %+v", dir)
+	}
+
+	requests = 0
+	HTTPRetryPolicy.MaxRetries = 1
+
+	if _, err := getJSON(context.Background(), &HTTPClient, ts.URL, &dir); err == nil {
+		t.Error("Expected the request to fail after 1 retry")
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestHTTPGetDoesNotRetryClientErrors(t *testing.T) {
+	defer withRetryPolicy(RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxElapsedTime: time.Second})()
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	if _, err := getJSON(context.Background(), &HTTPClient, ts.URL, &directory{}); err == nil {
+		t.Error("Expected the request to fail")
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
+
+func TestPostJSONDoesNotRetryServerErrors(t *testing.T) {
+	defer withRetryPolicy(RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxElapsedTime: time.Second})()
+
+	var posts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Replay-Nonce", "12345")
+		if r.Method == http.MethodPost {
+			posts++
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: key, getNonceURL: ts.URL}
+
+	if _, err := postJSON(context.Background(), j, ts.URL, struct{}{}, nil); err == nil {
+		t.Error("Expected the request to fail")
+	}
+	if posts != 1 {
+		t.Errorf("Expected 1 POST, got %d", posts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second}
+
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		delay := policy.delay(attempt, nil)
+		if delay < max/2 || delay >= max {
+			t.Errorf("Expected the delay of the attempt %d to be in [%v, %v[, got %v", attempt, max/2, max, delay)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if delay := policy.delay(0, resp); delay != 3*time.Second {
+		t.Errorf("Expected the Retry-After delay, got %v", delay)
+	}
+}
+
+func TestIsRetryableErrors(t *testing.T) {
+	notFound := &net.DNSError{Err: "no such host", Name: "acme.invalid"}
+	timeout := &net.DNSError{Err: "i/o timeout", Name: "acme.test", IsTimeout: true}
+
+	testCases := []struct {
+		desc      string
+		err       error
+		retryable bool
+	}{
+		{desc: "unknown host", err: &url.Error{Op: "Get", URL: "https://acme.invalid", Err: &net.OpError{Op: "dial", Net: "tcp", Err: notFound}}, retryable: false},
+		{desc: "unknown host without dial error", err: &url.Error{Op: "Get", URL: "https://acme.invalid", Err: notFound}, retryable: false},
+		{desc: "DNS timeout", err: &url.Error{Op: "Get", URL: "https://acme.test", Err: &net.OpError{Op: "dial", Net: "tcp", Err: timeout}}, retryable: true},
+		{desc: "connection refused", err: &url.Error{Op: "Get", URL: "https://acme.test", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, retryable: true},
+		{desc: "unexpected EOF", err: &url.Error{Op: "Get", URL: "https://acme.test", Err: io.ErrUnexpectedEOF}, retryable: true},
+		{desc: "invalid URL", err: &url.Error{Op: "Get", URL: "acme.test", Err: errors.New("unsupported protocol scheme")}, retryable: false},
+	}
+
+	for _, test := range testCases {
+		if retryable := isRetryable(nil, test.err); retryable != test.retryable {
+			t.Errorf("%s: expected retryable to be %v, got %v", test.desc, test.retryable, retryable)
+		}
+	}
+}
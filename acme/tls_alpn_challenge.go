@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/tls"
@@ -19,14 +20,16 @@ type tlsALPNChallenge struct {
 	jws      *jws
 	validate validateFunc
 	provider ChallengeProvider
+	// keepOnFailure keeps the certificate presented if the challenge fails, for debugging.
+	keepOnFailure bool
 }
 
 // Solve manages the provider to validate and solve the challenge.
-func (t *tlsALPNChallenge) Solve(chlng challenge, domain string) error {
+func (t *tlsALPNChallenge) Solve(ctx context.Context, chlng challenge, domain string) (err error) {
 	log.Infof("[%s] acme: Trying to solve TLS-ALPN-01", domain)
 
 	// Generate the Key Authorization for the challenge
-	keyAuth, err := getKeyAuthorization(chlng.Token, t.jws.privKey)
+	keyAuth, err := KeyAuthorization(chlng.Token, t.jws.privKey)
 	if err != nil {
 		return err
 	}
@@ -36,13 +39,15 @@ func (t *tlsALPNChallenge) Solve(chlng challenge, domain string) error {
 		return fmt.Errorf("[%s] error presenting token: %v", domain, err)
 	}
 	defer func() {
-		err := t.provider.CleanUp(domain, chlng.Token, keyAuth)
-		if err != nil {
+		if skipCleanUp(t.keepOnFailure, err, domain, "the tls-alpn-01 certificate") {
+			return
+		}
+		if err := t.provider.CleanUp(domain, chlng.Token, keyAuth); err != nil {
 			log.Warnf("[%s] error cleaning up: %v", domain, err)
 		}
 	}()
 
-	return t.validate(t.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return t.validate(ctx, t.jws, domain, chlng.URL, challenge{Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
 }
 
 // TLSALPNChallengeBlocks returns PEM blocks (certPEMBlock, keyPEMBlock) with the acmeValidation-v1 extension
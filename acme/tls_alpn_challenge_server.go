@@ -2,9 +2,11 @@ package acme
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 )
 
 const (
@@ -20,9 +22,16 @@ const (
 // challenge. It may be instantiated without using the NewTLSALPNProviderServer
 // if you want only to use the default values.
 type TLSALPNProviderServer struct {
-	iface    string
-	port     string
-	listener net.Listener
+	iface        string
+	port         string
+	listener     net.Listener
+	userListener net.Listener
+
+	// certMu protects the challenge certificate served on the user listener,
+	// and whether that listener is being served.
+	certMu  sync.Mutex
+	cert    *tls.Certificate
+	serving bool
 }
 
 // NewTLSALPNProviderServer creates a new TLSALPNProviderServer on the selected
@@ -32,6 +41,15 @@ func NewTLSALPNProviderServer(iface, port string) *TLSALPNProviderServer {
 	return &TLSALPNProviderServer{iface: iface, port: port}
 }
 
+// NewTLSALPNProviderServerWithListener creates a new TLSALPNProviderServer serving
+// the challenge certificate on the given listener instead of opening its own one.
+// The listener must accept raw TCP connections, TLS is negotiated by the provider.
+// The listener is served from the first call to Present and stays open across
+// challenges: closing it, once no challenge is pending, is left to the caller.
+func NewTLSALPNProviderServerWithListener(listener net.Listener) *TLSALPNProviderServer {
+	return &TLSALPNProviderServer{userListener: listener}
+}
+
 // Present generates a certificate with a SHA-256 digest of the keyAuth provided
 // as the acmeValidation-v1 extension value to conform to the ACME-TLS-ALPN
 // spec.
@@ -47,6 +65,11 @@ func (t *TLSALPNProviderServer) Present(domain, token, keyAuth string) error {
 		return err
 	}
 
+	if t.userListener != nil {
+		t.serveUserListener(cert)
+		return nil
+	}
+
 	// Place the generated certificate with the extension into the TLS config
 	// so that it can serve the correct details.
 	tlsConf := new(tls.Config)
@@ -71,8 +94,74 @@ func (t *TLSALPNProviderServer) Present(domain, token, keyAuth string) error {
 	return nil
 }
 
+// serveUserListener sets the challenge certificate and starts serving the
+// listener supplied by the user if it is not served yet. A single server
+// is kept for all the challenges, as closing a server closes its listener.
+func (t *TLSALPNProviderServer) serveUserListener(cert *tls.Certificate) {
+	t.certMu.Lock()
+	defer t.certMu.Unlock()
+
+	t.cert = cert
+	if t.serving {
+		return
+	}
+	t.serving = true
+
+	tlsConf := &tls.Config{
+		GetCertificate: t.getCertificate,
+		NextProtos:     []string{ACMETLS1Protocol},
+	}
+
+	go func() {
+		http.Serve(tls.NewListener(t.userListener, tlsConf), nil)
+
+		// The listener was closed by the user.
+		t.certMu.Lock()
+		t.serving = false
+		t.certMu.Unlock()
+	}()
+}
+
+// getCertificate returns the certificate of the pending challenge on the user listener.
+func (t *TLSALPNProviderServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	t.certMu.Lock()
+	defer t.certMu.Unlock()
+
+	if t.cert == nil {
+		return nil, errors.New("no pending TLS-ALPN-01 challenge")
+	}
+	return t.cert, nil
+}
+
+// Preflight checks that the server can listen on its interface and port.
+// The listeners supplied by the user are not checked.
+func (t *TLSALPNProviderServer) Preflight(domain string) error {
+	if t.userListener != nil {
+		return nil
+	}
+
+	port := t.port
+	if port == "" {
+		port = defaultTLSPort
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(t.iface, port))
+	if err != nil {
+		return fmt.Errorf("could not start HTTPS server for challenge -> %v", err)
+	}
+	return listener.Close()
+}
+
 // CleanUp closes the HTTPS server.
+// On a listener supplied by the user, only the challenge certificate is removed.
 func (t *TLSALPNProviderServer) CleanUp(domain, token, keyAuth string) error {
+	if t.userListener != nil {
+		t.certMu.Lock()
+		t.cert = nil
+		t.certMu.Unlock()
+		return nil
+	}
+
 	if t.listener == nil {
 		return nil
 	}
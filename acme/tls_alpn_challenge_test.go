@@ -1,12 +1,14 @@
 package acme
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/tls"
 	"encoding/asn1"
+	"net"
 	"strings"
 	"testing"
 )
@@ -16,7 +18,7 @@ func TestTLSALPNChallenge(t *testing.T) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
 	j := &jws{privKey: privKey}
 	clientChallenge := challenge{Type: string(TLSALPN01), Token: "tlsalpn1"}
-	mockValidate := func(_ *jws, _, _ string, chlng challenge) error {
+	mockValidate := func(_ context.Context, _ *jws, _, _ string, chlng challenge) error {
 		conn, err := tls.Dial("tcp", domain, &tls.Config{
 			InsecureSkipVerify: true,
 		})
@@ -73,7 +75,7 @@ func TestTLSALPNChallenge(t *testing.T) {
 		return nil
 	}
 	solver := &tlsALPNChallenge{jws: j, validate: mockValidate, provider: &TLSALPNProviderServer{port: "23457"}}
-	if err := solver.Solve(clientChallenge, domain); err != nil {
+	if err := solver.Solve(context.Background(), clientChallenge, domain); err != nil {
 		t.Errorf("Solve error: got %v, want nil", err)
 	}
 }
@@ -84,9 +86,75 @@ func TestTLSALPNChallengeInvalidPort(t *testing.T) {
 	clientChallenge := challenge{Type: string(TLSALPN01), Token: "tlsalpn1"}
 	solver := &tlsALPNChallenge{jws: j, validate: stubValidate, provider: &TLSALPNProviderServer{port: "123456"}}
 
-	if err := solver.Solve(clientChallenge, "localhost:123456"); err == nil {
+	if err := solver.Solve(context.Background(), clientChallenge, "localhost:123456"); err == nil {
 		t.Errorf("Solve error: got %v, want error", err)
 	} else if want, want18 := "invalid port 123456", "123456: invalid port"; !strings.HasSuffix(err.Error(), want) && !strings.HasSuffix(err.Error(), want18) {
 		t.Errorf("Solve error: got %q, want suffix %q", err.Error(), want)
 	}
 }
+
+func TestTLSALPNChallengeWithListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start listener: %v", err)
+	}
+	defer listener.Close()
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+	clientChallenge := challenge{Type: string(TLSALPN01), Token: "tlsalpn1"}
+	mockValidate := func(_ context.Context, _ *jws, domain, _ string, chlng challenge) error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{ACMETLS1Protocol},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("Expected to connect to challenge server without an error. %v", err)
+		}
+		defer conn.Close()
+
+		connState := conn.ConnectionState()
+		if connState.NegotiatedProtocol != ACMETLS1Protocol {
+			t.Errorf("Expected the %s protocol to be negotiated, got %q", ACMETLS1Protocol, connState.NegotiatedProtocol)
+		}
+
+		if names := connState.PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != domain {
+			t.Errorf("Expected the challenge certificate of %s, got %v", domain, names)
+		}
+
+		zBytes := sha256.Sum256([]byte(chlng.KeyAuthorization))
+		value, _ := asn1.Marshal(zBytes[:sha256.Size])
+
+		for _, ext := range connState.PeerCertificates[0].Extensions {
+			if idPeAcmeIdentifierV1.Equal(ext.Id) {
+				if !ext.Critical || subtle.ConstantTimeCompare(value, ext.Value) != 1 {
+					t.Errorf("Expected a critical id-pe-acmeIdentifier extension with the keyAuth digest, got %v", ext)
+				}
+				return nil
+			}
+		}
+
+		t.Error("Expected the challenge certificate to contain an extension with the id-pe-acmeIdentifier id, it did not")
+		return nil
+	}
+
+	// the same provider solves the challenges of several authorizations.
+	solver := &tlsALPNChallenge{jws: j, validate: mockValidate, provider: NewTLSALPNProviderServerWithListener(listener)}
+	for _, domain := range []string{"example.com", "www.example.com"} {
+		if err := solver.Solve(context.Background(), clientChallenge, domain); err != nil {
+			t.Errorf("Solve error for %s: got %v, want nil", domain, err)
+		}
+	}
+
+	// the listener stays open, without a challenge certificate to serve.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the listener to stay open after CleanUp, got %v", err)
+	}
+	defer conn.Close()
+
+	if err = tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake(); err == nil {
+		t.Error("Expected the TLS handshake to fail once the challenges are cleaned up")
+	}
+}
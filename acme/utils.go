@@ -1,12 +1,18 @@
 package acme
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
 // WaitFor polls the given function 'f', once every 'interval', up to 'timeout'.
 func WaitFor(timeout, interval time.Duration, f func() (bool, error)) error {
+	return waitFor(context.Background(), timeout, interval, f)
+}
+
+// waitFor is like WaitFor, returning the error of the context as soon as it is done.
+func waitFor(ctx context.Context, timeout, interval time.Duration, f func() (bool, error)) error {
 	var lastErr string
 	timeup := time.After(timeout)
 	for {
@@ -24,6 +30,10 @@ func WaitFor(timeout, interval time.Duration, f func() (bool, error)) error {
 			lastErr = err.Error()
 		}
 
-		time.Sleep(interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
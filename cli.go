@@ -24,7 +24,7 @@ func main() {
 
 	app.Version = version
 
-	acme.UserAgent = "lego/" + app.Version
+	acme.SetUserAgent("lego/" + app.Version)
 
 	defaultPath := ""
 	cwd, err := os.Getwd()
@@ -59,6 +59,12 @@ func main() {
 			Name:   "revoke",
 			Usage:  "Revoke a certificate",
 			Action: revoke,
+			Flags: []cli.Flag{
+				cli.UintFlag{
+					Name:  "reason",
+					Usage: "Identifies the reason for the certificate revocation. See https://tools.ietf.org/html/rfc5280#section-5.3.1. 0(unspecified),1(keyCompromise),2(cACompromise),3(affiliationChanged),4(superseded),5(cessationOfOperation),6(certificateHold),8(removeFromCRL),9(privilegeWithdrawn),10(aACompromise)",
+				},
+			},
 		},
 		{
 			Name:   "renew",
@@ -102,9 +108,14 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:  "server, s",
-			Value: "https://acme-v02.api.letsencrypt.org/directory",
+			Value: acme.LEDirectoryProduction,
 			Usage: "CA hostname (and optionally :port). The server certificate must be trusted in order to avoid further modifications to the client.",
 		},
+		cli.BoolFlag{
+			Name:   "staging",
+			EnvVar: "LEGO_STAGING",
+			Usage:  "Use the Let's Encrypt staging directory, to test without hitting the production rate limits. The certificates are not publicly trusted and are stored apart from the production ones.",
+		},
 		cli.StringFlag{
 			Name:  "email, m",
 			Usage: "Email used for registration and recovery contact.",
@@ -128,7 +139,12 @@ func main() {
 		cli.StringFlag{
 			Name:  "key-type, k",
 			Value: "rsa2048",
-			Usage: "Key type to use for private keys. Supported: rsa2048, rsa4096, rsa8192, ec256, ec384",
+			Usage: "Key type to use for private keys. Supported: rsa2048, rsa4096, rsa8192, ec256, ec384, ec521 (if supported by the CA)",
+		},
+		cli.StringFlag{
+			Name:  "account-key-type",
+			Value: "ec384",
+			Usage: "Key type of a new account key. Supported: rsa2048, rsa4096, ec256, ec384, ed25519 (if supported by the CA)",
 		},
 		cli.StringFlag{
 			Name:  "path",
@@ -147,10 +163,27 @@ func main() {
 			Name:  "memcached-host",
 			Usage: "Set the memcached host(s) to use for HTTP based challenges. Challenges will be written to all specified hosts.",
 		},
+		cli.StringFlag{
+			Name:  "redis-addr",
+			Usage: "Set the address (host:port) of the Redis server to use for HTTP based challenges. Challenges will be written to Redis, to be served by the web servers with the handler of the redis package.",
+		},
+		cli.IntFlag{
+			Name:  "redis-db",
+			Usage: "Set the Redis database to use with --redis-addr.",
+		},
+		cli.StringFlag{
+			Name:   "redis-password",
+			Usage:  "Set the password of the Redis server to use with --redis-addr.",
+			EnvVar: "LEGO_REDIS_PASSWORD",
+		},
 		cli.StringFlag{
 			Name:  "http",
 			Usage: "Set the port and interface to use for HTTP based challenges to listen on. Supported: interface:port or :port",
 		},
+		cli.StringFlag{
+			Name:  "http-proxy-header",
+			Usage: "Validate the host of HTTP based challenge requests against this header instead of Host, e.g. X-Forwarded-Host or Forwarded when running behind a reverse proxy.",
+		},
 		cli.StringFlag{
 			Name:  "tls",
 			Usage: "Set the port and interface to use for TLS based challenges to listen on. Supported: interface:port or :port",
@@ -168,13 +201,39 @@ func main() {
 			Usage: "Set the DNS timeout value to a specific value in seconds. The default is 10 seconds.",
 		},
 		cli.StringSliceFlag{
-			Name:  "dns-resolvers",
-			Usage: "Set the resolvers to use for performing recursive DNS queries. Supported: host:port. The default is to use the system resolvers, or Google's DNS resolvers if the system's cannot be determined.",
+			Name:   "dns-resolvers",
+			Usage:  "Set the resolvers to use for performing recursive DNS queries. Supported: host:port. The default is to use the system resolvers, or Google's DNS resolvers if the system's cannot be determined.",
+			EnvVar: "LEGO_DNS_RESOLVERS",
+		},
+		cli.StringFlag{
+			Name:   "dns-check",
+			Usage:  "Set the nameservers queried by the DNS propagation pre-check: 'authoritative' to query the authoritative nameservers of the zone, or 'recursive' to query the resolvers.",
+			Value:  string(acme.DNSCheckAuthoritative),
+			EnvVar: "LEGO_DNS_CHECK",
+		},
+		cli.IntFlag{
+			Name:  "dns-stable-polls",
+			Usage: "Set the number of consecutive polls of the DNS propagation pre-check which must see the record before notifying the CA.",
+			Value: 2,
+		},
+		cli.BoolFlag{
+			Name:  "dns-disable-cp",
+			Usage: "Skip the DNS propagation pre-check and notify the CA as soon as the record is presented. Only use it if the record is guaranteed to be propagated.",
 		},
 		cli.BoolFlag{
 			Name:  "pem",
 			Usage: "Generate a .pem file by concatanating the .key and .crt files together.",
 		},
+		cli.StringFlag{
+			Name:   "caa-check",
+			Usage:  "Check the CAA records of the domains before ordering: 'advisory' to warn if they don't allow the CA to issue the certificate, 'strict' to fail, or 'off'.",
+			Value:  string(acme.CAACheckAdvisory),
+			EnvVar: "LEGO_CAA_CHECK",
+		},
+		cli.StringFlag{
+			Name:  "preferred-chain",
+			Usage: "If the CA offers alternate certificate chains, use the one having an issuer with this common name (e.g. \"ISRG Root X1\"). The default chain is used if none matches.",
+		},
 	}
 
 	err = app.Run(os.Args)
@@ -193,38 +252,58 @@ Here is an example bash command using the CloudFlare DNS provider:
     CLOUDFLARE_API_KEY=b9841238feb177a84330febba8a83208921177bffe733 \
     lego --dns cloudflare --domains www.example.com --email me@bar.com run
 
+For the providers reading their credentials with env.Get, a credential can also be
+read from a file by suffixing its variable with _FILE, e.g. HOSTINGDE_API_KEY_FILE.
+
 `)
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
 	fmt.Fprintln(w, "Valid providers and their associated credential environment variables:")
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "\tacme-dns:\tACME_DNS_API_BASE, ACME_DNS_STORAGE_PATH")
+	fmt.Fprintln(w, "\talidns:\tALICLOUD_ACCESS_KEY, ALICLOUD_SECRET_KEY")
 	fmt.Fprintln(w, "\tazure:\tAZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID, AZURE_TENANT_ID, AZURE_RESOURCE_GROUP")
+	fmt.Fprintln(w, "\tazuredns:\tAZURE_SUBSCRIPTION_ID, AZURE_RESOURCE_GROUP, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_TENANT_ID")
 	fmt.Fprintln(w, "\tauroradns:\tAURORA_USER_ID, AURORA_KEY, AURORA_ENDPOINT")
 	fmt.Fprintln(w, "\tbluecat:\tBLUECAT_SERVER_URL, BLUECAT_USER_NAME, BLUECAT_PASSWORD, BLUECAT_CONFIG_NAME, BLUECAT_DNS_VIEW")
+	fmt.Fprintln(w, "\tcloudns:\tCLOUDNS_AUTH_ID or CLOUDNS_SUB_AUTH_ID or CLOUDNS_SUB_AUTH_USER, CLOUDNS_AUTH_PASSWORD")
 	fmt.Fprintln(w, "\tcloudxns:\tCLOUDXNS_API_KEY, CLOUDXNS_SECRET_KEY")
-	fmt.Fprintln(w, "\tcloudflare:\tCLOUDFLARE_EMAIL, CLOUDFLARE_API_KEY")
+	fmt.Fprintln(w, "\tconstellix:\tCONSTELLIX_API_KEY, CONSTELLIX_SECRET_KEY")
+	fmt.Fprintln(w, "\tcloudflare:\tCLOUDFLARE_EMAIL, CLOUDFLARE_API_KEY or CLOUDFLARE_DNS_API_TOKEN, CLOUDFLARE_ZONE_API_TOKEN")
+	fmt.Fprintln(w, "\tdesec:\tDESEC_TOKEN")
 	fmt.Fprintln(w, "\tdigitalocean:\tDO_AUTH_TOKEN")
 	fmt.Fprintln(w, "\tdnsimple:\tDNSIMPLE_EMAIL, DNSIMPLE_OAUTH_TOKEN")
 	fmt.Fprintln(w, "\tdnsmadeeasy:\tDNSMADEEASY_API_KEY, DNSMADEEASY_API_SECRET")
+	fmt.Fprintln(w, "\tdreamhost:\tDREAMHOST_API_KEY")
 	fmt.Fprintln(w, "\tduckdns:\tDUCKDNS_TOKEN")
+	fmt.Fprintln(w, "\teasyname:\tEASYNAME_EMAIL, EASYNAME_PASSWORD")
 	fmt.Fprintln(w, "\texoscale:\tEXOSCALE_API_KEY, EXOSCALE_API_SECRET, EXOSCALE_ENDPOINT")
 	fmt.Fprintln(w, "\tgandi:\tGANDI_API_KEY")
-	fmt.Fprintln(w, "\tgandiv5:\tGANDIV5_API_KEY")
-	fmt.Fprintln(w, "\tgcloud:\tGCE_PROJECT, GCE_SERVICE_ACCOUNT_FILE")
+	fmt.Fprintln(w, "\tgandiv5:\tGANDIV5_API_KEY, GANDIV5_TTL")
+	fmt.Fprintln(w, "\tgcloud:\tGCE_PROJECT, GCE_SERVICE_ACCOUNT_FILE or GOOGLE_APPLICATION_CREDENTIALS")
 	fmt.Fprintln(w, "\tglesys:\tGLESYS_API_USER, GLESYS_API_KEY")
+	fmt.Fprintln(w, "\tgoogledomains:\tGOOGLE_DOMAINS_ACCESS_TOKEN")
+	fmt.Fprintln(w, "\thetzner:\tHETZNER_API_KEY")
+	fmt.Fprintln(w, "\thostingde:\tHOSTINGDE_API_KEY, HOSTINGDE_ZONE_NAME (optional)")
+	fmt.Fprintln(w, "\tinwx:\tINWX_USERNAME, INWX_PASSWORD, INWX_SHARED_SECRET")
+	fmt.Fprintln(w, "\tjoker:\tJOKER_API_KEY or JOKER_USERNAME, JOKER_PASSWORD")
 	fmt.Fprintln(w, "\tlinode:\tLINODE_API_KEY")
+	fmt.Fprintln(w, "\tlinodev4:\tLINODE_TOKEN")
 	fmt.Fprintln(w, "\tlightsail:\tAWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, DNS_ZONE")
 	fmt.Fprintln(w, "\tmanual:\tnone")
 	fmt.Fprintln(w, "\tnamecheap:\tNAMECHEAP_API_USER, NAMECHEAP_API_KEY")
 	fmt.Fprintln(w, "\tnamedotcom:\tNAMECOM_USERNAME, NAMECOM_API_TOKEN")
+	fmt.Fprintln(w, "\tnetlify:\tNETLIFY_TOKEN")
 	fmt.Fprintln(w, "\tnifcloud:\tNIFCLOUD_ACCESS_KEY_ID, NIFCLOUD_SECRET_ACCESS_KEY")
+	fmt.Fprintln(w, "\tnjalla:\tNJALLA_TOKEN")
 	fmt.Fprintln(w, "\trackspace:\tRACKSPACE_USER, RACKSPACE_API_KEY")
 	fmt.Fprintln(w, "\trfc2136:\tRFC2136_TSIG_KEY, RFC2136_TSIG_SECRET,\n\t\tRFC2136_TSIG_ALGORITHM, RFC2136_NAMESERVER")
-	fmt.Fprintln(w, "\troute53:\tAWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION, AWS_HOSTED_ZONE_ID")
+	fmt.Fprintln(w, "\troute53:\tAWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION, AWS_HOSTED_ZONE_ID, AWS_ROLE_ARN, AWS_WEB_IDENTITY_TOKEN_FILE")
 	fmt.Fprintln(w, "\tdyn:\tDYN_CUSTOMER_NAME, DYN_USER_NAME, DYN_PASSWORD")
+	fmt.Fprintln(w, "\ttransip:\tTRANSIP_ACCOUNT_NAME, TRANSIP_PRIVATE_KEY_PATH")
 	fmt.Fprintln(w, "\tvegadns:\tSECRET_VEGADNS_KEY, SECRET_VEGADNS_SECRET, VEGADNS_URL")
 	fmt.Fprintln(w, "\tvultr:\tVULTR_API_KEY")
+	fmt.Fprintln(w, "\tyandex:\tYANDEX_PDD_TOKEN")
 	fmt.Fprintln(w, "\tovh:\tOVH_ENDPOINT, OVH_APPLICATION_KEY, OVH_APPLICATION_SECRET, OVH_CONSUMER_KEY")
 	fmt.Fprintln(w, "\tpdns:\tPDNS_API_KEY, PDNS_API_URL")
 	fmt.Fprintln(w, "\tdnspod:\tDNSPOD_API_KEY")
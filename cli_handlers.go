@@ -3,12 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -19,6 +19,7 @@ import (
 	"github.com/xenolf/lego/log"
 	"github.com/xenolf/lego/providers/dns"
 	"github.com/xenolf/lego/providers/http/memcached"
+	"github.com/xenolf/lego/providers/http/redis"
 	"github.com/xenolf/lego/providers/http/webroot"
 )
 
@@ -32,7 +33,8 @@ func checkFolder(path string) error {
 func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 
 	if c.GlobalIsSet("http-timeout") {
-		acme.HTTPClient = http.Client{Timeout: time.Duration(c.GlobalInt("http-timeout")) * time.Second}
+		// keep the transport of the client, with its proxy and CA certificates settings.
+		acme.HTTPClient.Timeout = time.Duration(c.GlobalInt("http-timeout")) * time.Second
 	}
 
 	if c.GlobalIsSet("dns-timeout") {
@@ -40,14 +42,18 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 	}
 
 	if len(c.GlobalStringSlice("dns-resolvers")) > 0 {
-		var resolvers []string
-		for _, resolver := range c.GlobalStringSlice("dns-resolvers") {
-			if !strings.Contains(resolver, ":") {
-				resolver += ":53"
-			}
-			resolvers = append(resolvers, resolver)
+		err := acme.SetDNSResolvers(c.GlobalStringSlice("dns-resolvers"))
+		if err != nil {
+			log.Fatalf("Could not set the DNS resolvers: %v", err)
 		}
-		acme.RecursiveNameservers = resolvers
+	}
+
+	if err := acme.SetDNSCheckStrategy(acme.DNSCheckStrategy(c.GlobalString("dns-check"))); err != nil {
+		log.Fatalf("Could not set the DNS check strategy: %v", err)
+	}
+
+	if err := acme.SetDNSStablePolls(c.GlobalInt("dns-stable-polls")); err != nil {
+		log.Fatalf("Could not set the DNS stable polls: %v", err)
 	}
 
 	err := checkFolder(c.GlobalString("path"))
@@ -55,6 +61,10 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		log.Fatalf("Could not check/create path: %v", err)
 	}
 
+	if c.GlobalBool("staging") && c.GlobalIsSet("server") && c.GlobalString("server") != acme.LEDirectoryStaging {
+		log.Fatal("The --staging and --server options are mutually exclusive")
+	}
+
 	conf := NewConfiguration(c)
 	if len(c.GlobalString("email")) == 0 {
 		log.Fatal("You have to pass an account (email address) to the program using --email or -m")
@@ -68,9 +78,9 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		log.Fatal(err)
 	}
 
-	acme.UserAgent = fmt.Sprintf("lego-cli/%s", c.App.Version)
+	acme.SetUserAgent(fmt.Sprintf("lego-cli/%s", c.App.Version))
 
-	client, err := acme.NewClient(c.GlobalString("server"), acc, keyType)
+	client, err := acme.NewClient(conf.ServerURL(), acc, keyType)
 	if err != nil {
 		log.Fatalf("Could not create client: %v", err)
 	}
@@ -79,6 +89,14 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		client.ExcludeChallenges(conf.ExcludedSolvers())
 	}
 
+	if c.GlobalIsSet("preferred-chain") {
+		client.SetPreferredChain(c.GlobalString("preferred-chain"))
+	}
+
+	if err := client.SetCAACheck(acme.CAACheckMode(c.GlobalString("caa-check"))); err != nil {
+		log.Fatalf("Could not set the CAA check: %v", err)
+	}
+
 	if c.GlobalIsSet("webroot") {
 		provider, err := webroot.NewHTTPProvider(c.GlobalString("webroot"))
 		if err != nil {
@@ -109,6 +127,26 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		// infer that the user also wants to exclude all other challenges
 		client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSALPN01})
 	}
+	if c.GlobalIsSet("redis-addr") {
+		config := redis.NewDefaultConfig()
+		config.Addr = c.GlobalString("redis-addr")
+		config.DB = c.GlobalInt("redis-db")
+		config.Password = c.GlobalString("redis-password")
+
+		provider, err := redis.NewHTTPProvider(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = client.SetChallengeProvider(acme.HTTP01, provider)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// --redis-addr=foo:6379 indicates that the user specifically want to do a HTTP challenge
+		// infer that the user also wants to exclude all other challenges
+		client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSALPN01})
+	}
 	if c.GlobalIsSet("http") {
 		if !strings.Contains(c.GlobalString("http"), ":") {
 			log.Fatalf("The --http switch only accepts interface:port or :port for its argument.")
@@ -120,6 +158,13 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		}
 	}
 
+	if c.GlobalIsSet("http-proxy-header") {
+		err = client.SetHTTPProxyHeader(c.GlobalString("http-proxy-header"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if c.GlobalIsSet("tls") {
 		if !strings.Contains(c.GlobalString("tls"), ":") {
 			log.Fatalf("The --tls switch only accepts interface:port or :port for its argument.")
@@ -138,6 +183,10 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 			log.Fatal(err)
 		}
 
+		if c.GlobalBool("dns-disable-cp") {
+			client.SetDNSPropagationCheck(false)
+		}
+
 		// --dns=foo indicates that the user specifically want to do a DNS challenge
 		// infer that the user also wants to exclude all other challenges
 		client.ExcludeChallenges([]acme.Challenge{acme.HTTP01, acme.TLSALPN01})
@@ -204,14 +253,10 @@ func saveCertRes(certRes *acme.CertificateResource, conf *Configuration) {
 	}
 }
 
-func handleTOS(c *cli.Context, client *acme.Client) bool {
-	// Check for a global accept override
-	if c.GlobalBool("accept-tos") {
-		return true
-	}
-
+// handleTOS asks the user to accept the TOS at the URL.
+func handleTOS(url string) {
 	reader := bufio.NewReader(os.Stdin)
-	log.Printf("Please review the TOS at %s", client.GetToSURL())
+	log.Printf("Please review the TOS at %s", url)
 
 	for {
 		log.Println("Do you accept the TOS? Y/n")
@@ -227,7 +272,7 @@ func handleTOS(c *cli.Context, client *acme.Client) bool {
 		}
 
 		if text == "Y" || text == "y" || text == "" {
-			return true
+			return
 		}
 
 		log.Println("Your input was invalid. Please answer with one of Y/y, n or by pressing enter.")
@@ -270,28 +315,43 @@ func run(c *cli.Context) error {
 
 	conf, acc, client := setup(c)
 	if acc.Registration == nil {
-		accepted := handleTOS(c, client)
-		if !accepted {
-			log.Fatal("You did not accept the TOS. Unable to proceed.")
+		// An account may already exist for the key, e.g. if the account file was lost.
+		reg, err := client.ResolveAccountByKey()
+		switch {
+		case err == nil:
+			log.Printf("Found the existing account %s for the key", reg.URI)
+			acc.Registration = reg
+			if err = acc.Save(); err != nil {
+				log.Fatalf("Could not save the account\n\t%v", err)
+			}
+		case err != acme.ErrNoExistingAccount:
+			log.Printf("Could not look up an existing account, registering a new one\n\t%v", err)
 		}
+	}
 
-		var reg *acme.RegistrationResource
-
+	if acc.Registration == nil {
+		var kid, hmacEncoded string
 		if c.GlobalBool("eab") {
-			kid := c.GlobalString("kid")
-			hmacEncoded := c.GlobalString("hmac")
+			kid = c.GlobalString("kid")
+			hmacEncoded = c.GlobalString("hmac")
 
 			if kid == "" || hmacEncoded == "" {
 				log.Fatalf("Requires arguments --kid and --hmac.")
 			}
+		}
 
-			reg, err = client.RegisterWithExternalAccountBinding(
-				accepted,
-				kid,
-				hmacEncoded,
-			)
-		} else {
-			reg, err = client.Register(accepted)
+		register := func(accepted bool) (*acme.RegistrationResource, error) {
+			if c.GlobalBool("eab") {
+				return client.RegisterWithExternalAccountBinding(accepted, kid, hmacEncoded)
+			}
+			return client.RegisterWithOptions(acme.RegisterOptions{TermsOfServiceAgreed: accepted})
+		}
+
+		// The TOS are only reviewed if the CA has some and they were not accepted with --accept-tos.
+		reg, err := register(c.GlobalBool("accept-tos"))
+		if tosErr, ok := err.(acme.ErrTermsOfServiceRequired); ok {
+			handleTOS(tosErr.URL)
+			reg, err = register(true)
 		}
 
 		if err != nil {
@@ -372,7 +432,11 @@ func revoke(c *cli.Context) error {
 			log.Println(err)
 		}
 
-		err = client.RevokeCertificate(certBytes)
+		if c.IsSet("reason") {
+			err = client.RevokeCertificateWithReason(certBytes, c.Uint("reason"))
+		} else {
+			err = client.RevokeCertificate(certBytes)
+		}
 		if err != nil {
 			log.Fatalf("Error while revoking the certificate for domain %s\n\t%v", domain, err)
 		} else {
@@ -439,7 +503,12 @@ func renew(c *cli.Context) error {
 
 	certRes.Certificate = certBytes
 
-	newCert, err := client.RenewCertificate(certRes, !c.Bool("no-bundle"), c.Bool("must-staple"))
+	options := acme.RenewOptions{
+		Bundle:          !c.Bool("no-bundle"),
+		MustStaple:      c.Bool("must-staple"),
+		ReusePrivateKey: c.Bool("reuse-key"),
+	}
+	newCert, err := client.RenewCertificateWithOptions(context.Background(), certRes, options)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -34,11 +34,31 @@ func (c *Configuration) KeyType() (acme.KeyType, error) {
 		return acme.EC256, nil
 	case "EC384":
 		return acme.EC384, nil
+	case "EC521":
+		return acme.EC521, nil
 	}
 
 	return "", fmt.Errorf("Unsupported KeyType: %s", c.context.GlobalString("key-type"))
 }
 
+// AccountKeyType the type of the key generated for a new account.
+func (c *Configuration) AccountKeyType() (acme.KeyType, error) {
+	switch strings.ToUpper(c.context.GlobalString("account-key-type")) {
+	case "RSA2048":
+		return acme.RSA2048, nil
+	case "RSA4096":
+		return acme.RSA4096, nil
+	case "EC256":
+		return acme.EC256, nil
+	case "EC384":
+		return acme.EC384, nil
+	case "ED25519":
+		return acme.Ed25519, nil
+	}
+
+	return "", fmt.Errorf("Unsupported account KeyType: %s", c.context.GlobalString("account-key-type"))
+}
+
 // ExcludedSolvers is a list of solvers that are to be excluded.
 func (c *Configuration) ExcludedSolvers() (cc []acme.Challenge) {
 	for _, s := range c.context.GlobalStringSlice("exclude") {
@@ -47,15 +67,27 @@ func (c *Configuration) ExcludedSolvers() (cc []acme.Challenge) {
 	return
 }
 
+// ServerURL returns the URL of the CA directory, the Let's Encrypt staging one with --staging.
+func (c *Configuration) ServerURL() string {
+	if c.context.GlobalBool("staging") {
+		return acme.LEDirectoryStaging
+	}
+	return c.context.GlobalString("server")
+}
+
 // ServerPath returns the OS dependent path to the data for a specific CA
 func (c *Configuration) ServerPath() string {
-	srv, _ := url.Parse(c.context.GlobalString("server"))
+	srv, _ := url.Parse(c.ServerURL())
 	srvStr := strings.Replace(srv.Host, ":", "_", -1)
 	return strings.Replace(srvStr, "/", string(os.PathSeparator), -1)
 }
 
 // CertPath gets the path for certificates.
+// The staging certificates are stored apart, so they can't be mistaken for production ones.
 func (c *Configuration) CertPath() string {
+	if c.context.GlobalBool("staging") {
+		return path.Join(c.context.GlobalString("path"), "certificates-staging")
+	}
 	return path.Join(c.context.GlobalString("path"), "certificates")
 }
 
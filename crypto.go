@@ -5,26 +5,67 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ed25519"
 )
 
-func generatePrivateKey(file string) (crypto.PrivateKey, error) {
+func generatePrivateKey(file string, keyType acme.KeyType) (crypto.PrivateKey, error) {
+	var privateKey crypto.PrivateKey
+	var pemKey pem.Block
 
-	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-	if err != nil {
-		return nil, err
-	}
+	switch keyType {
+	case acme.EC256, acme.EC384:
+		curve := elliptic.P256()
+		if keyType == acme.EC384 {
+			curve = elliptic.P384()
+		}
 
-	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
-	if err != nil {
-		return nil, err
-	}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
 
-	pemKey := pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, pemKey = key, pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+	case acme.RSA2048, acme.RSA4096:
+		bits := 2048
+		if keyType == acme.RSA4096 {
+			bits = 4096
+		}
+
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, pemKey = key, pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case acme.Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		keyBytes, err := acme.MarshalEd25519PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, pemKey = key, pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
 
 	certOut, err := os.Create(file)
 	if err != nil {
@@ -50,6 +91,8 @@ func loadPrivateKey(file string) (crypto.PrivateKey, error) {
 		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "PRIVATE KEY":
+		return acme.ParseEd25519PrivateKey(keyBlock.Bytes)
 	}
 
 	return nil, errors.New("unknown private key type")
@@ -5,45 +5,119 @@ import (
 	"os"
 )
 
+// StdLogger is the interface of the standard library logger,
+// it can be implemented to route the lego output into another logging system.
+type StdLogger interface {
+	Fatal(args ...interface{})
+	Fatalln(args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Print(args ...interface{})
+	Println(args ...interface{})
+	Printf(format string, args ...interface{})
+}
+
+// LeveledLogger can be implemented by a StdLogger to receive the leveled entries
+// (e.g. from logrus or zap) instead of prefixed ones.
+type LeveledLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
 // Logger is an optional custom logger.
 var Logger = log.New(os.Stdout, "", log.LstdFlags)
 
+// customLogger is the logger set by SetLogger, Logger is used if nil.
+var customLogger StdLogger
+
+// debug enables the debug entries when the logger is not a LeveledLogger.
+var debug bool
+
+// SetLogger sets the logger used by lego instead of Logger.
+// A nil logger restores Logger.
+func SetLogger(logger StdLogger) {
+	customLogger = logger
+}
+
+// current returns the logger used by lego.
+func current() StdLogger {
+	if customLogger != nil {
+		return customLogger
+	}
+	return Logger
+}
+
+// SetDebug enables or disables the debug entries.
+// A LeveledLogger always receives them and is responsible for filtering them.
+func SetDebug(enabled bool) {
+	debug = enabled
+}
+
 // Fatal writes a log entry.
-// It uses Logger if not nil, otherwise it uses the default log.Logger.
+// It uses the logger set by SetLogger if any, otherwise Logger.
 func Fatal(args ...interface{}) {
-	Logger.Fatal(args...)
+	current().Fatal(args...)
 }
 
 // Fatalf writes a log entry.
-// It uses Logger if not nil, otherwise it uses the default log.Logger.
+// It uses the logger set by SetLogger if any, otherwise Logger.
 func Fatalf(format string, args ...interface{}) {
-	Logger.Fatalf(format, args...)
+	current().Fatalf(format, args...)
 }
 
 // Print writes a log entry.
-// It uses Logger if not nil, otherwise it uses the default log.Logger.
+// It uses the logger set by SetLogger if any, otherwise Logger.
 func Print(args ...interface{}) {
-	Logger.Print(args...)
+	current().Print(args...)
 }
 
 // Println writes a log entry.
-// It uses Logger if not nil, otherwise it uses the default log.Logger.
+// It uses the logger set by SetLogger if any, otherwise Logger.
 func Println(args ...interface{}) {
-	Logger.Println(args...)
+	current().Println(args...)
 }
 
 // Printf writes a log entry.
-// It uses Logger if not nil, otherwise it uses the default log.Logger.
+// It uses the logger set by SetLogger if any, otherwise Logger.
 func Printf(format string, args ...interface{}) {
-	Logger.Printf(format, args...)
+	current().Printf(format, args...)
 }
 
-// Warnf writes a log entry.
-func Warnf(format string, args ...interface{}) {
-	Printf("[WARN] "+format, args...)
+// Debugf writes a log entry, only if the debug entries are enabled.
+func Debugf(format string, args ...interface{}) {
+	if leveled, ok := current().(LeveledLogger); ok {
+		leveled.Debugf(format, args...)
+		return
+	}
+	if debug {
+		Printf("[DEBUG] "+format, args...)
+	}
 }
 
 // Infof writes a log entry.
 func Infof(format string, args ...interface{}) {
+	if leveled, ok := current().(LeveledLogger); ok {
+		leveled.Infof(format, args...)
+		return
+	}
 	Printf("[INFO] "+format, args...)
 }
+
+// Warnf writes a log entry.
+func Warnf(format string, args ...interface{}) {
+	if leveled, ok := current().(LeveledLogger); ok {
+		leveled.Warnf(format, args...)
+		return
+	}
+	Printf("[WARN] "+format, args...)
+}
+
+// Errorf writes a log entry.
+func Errorf(format string, args ...interface{}) {
+	if leveled, ok := current().(LeveledLogger); ok {
+		leveled.Errorf(format, args...)
+		return
+	}
+	Printf("[ERROR] "+format, args...)
+}
@@ -0,0 +1,83 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type capturingLogger struct {
+	*log.Logger
+	entries []string
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	l.entries = append(l.entries, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Infof(format string, args ...interface{}) {
+	l.entries = append(l.entries, "info: "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Warnf(format string, args ...interface{}) {
+	l.entries = append(l.entries, "warn: "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {
+	l.entries = append(l.entries, "error: "+fmt.Sprintf(format, args...))
+}
+
+func TestSetLoggerLeveled(t *testing.T) {
+	defer SetLogger(nil)
+
+	logger := &capturingLogger{}
+	SetLogger(logger)
+
+	Debugf("debug %d", 1)
+	Infof("info %d", 2)
+	Warnf("warn %d", 3)
+	Errorf("error %d", 4)
+
+	expected := []string{"debug: debug 1", "info: info 2", "warn: warn 3", "error: error 4"}
+	assert.Equal(t, expected, logger.entries)
+}
+
+func TestSetLoggerStd(t *testing.T) {
+	defer SetLogger(nil)
+	defer SetDebug(false)
+
+	buf := &bytes.Buffer{}
+	SetLogger(log.New(buf, "", 0))
+
+	Debugf("hidden")
+	Infof("info %d", 2)
+	Errorf("error %d", 4)
+
+	SetDebug(true)
+	Debugf("visible")
+
+	assert.Equal(t, "[INFO] info 2\n[ERROR] error 4\n[DEBUG] visible\n", buf.String())
+}
+
+func TestLoggerSetOutput(t *testing.T) {
+	defer Logger.SetOutput(os.Stdout)
+	defer Logger.SetFlags(log.LstdFlags)
+
+	buf := &bytes.Buffer{}
+	Logger.SetOutput(buf)
+	Logger.SetFlags(0)
+
+	Infof("info %d", 2)
+
+	SetLogger(&capturingLogger{})
+	Infof("captured")
+	SetLogger(nil)
+
+	Warnf("warn %d", 3)
+
+	assert.Equal(t, "[INFO] info 2\n[WARN] warn 3\n", buf.String())
+}
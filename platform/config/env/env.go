@@ -1,9 +1,13 @@
 package env
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Get environment variables
@@ -12,7 +16,10 @@ func Get(names ...string) (map[string]string, error) {
 
 	var missingEnvVars []string
 	for _, envVar := range names {
-		value := os.Getenv(envVar)
+		value, err := getOrFile(envVar)
+		if err != nil {
+			return nil, err
+		}
 		if value == "" {
 			missingEnvVars = append(missingEnvVars, envVar)
 		}
@@ -25,3 +32,101 @@ func Get(names ...string) (map[string]string, error) {
 
 	return values, nil
 }
+
+// GetWithFallback gets environment variables, each one being looked up by a group of names:
+// the first name set in a group wins (e.g. a variable and its legacy alias).
+// The values are returned keyed by the first name of their group.
+func GetWithFallback(groups ...[]string) (map[string]string, error) {
+	values := map[string]string{}
+
+	var missingEnvVars []string
+	for _, names := range groups {
+		if len(names) == 0 {
+			return nil, errors.New("no env var names")
+		}
+
+		var value string
+		for _, envVar := range names {
+			var err error
+			value, err = getOrFile(envVar)
+			if err != nil {
+				return nil, err
+			}
+			if value != "" {
+				break
+			}
+		}
+
+		if value == "" {
+			missingEnvVars = append(missingEnvVars, names[0])
+		}
+		values[names[0]] = value
+	}
+
+	if len(missingEnvVars) > 0 {
+		return nil, fmt.Errorf("some credentials information are missing: %s", strings.Join(missingEnvVars, ","))
+	}
+
+	return values, nil
+}
+
+// GetOrFile returns the value of the given environment variable, or if it is not set,
+// the content of the file pointed by the same variable suffixed by _FILE (e.g. FOO_FILE for FOO).
+// It allows to pass secrets as files (e.g. Docker secrets). Trailing newlines of the file are removed.
+// Returns an empty string if neither is set or if the file cannot be read.
+func GetOrFile(envVar string) string {
+	value, _ := getOrFile(envVar)
+	return value
+}
+
+func getOrFile(envVar string) (string, error) {
+	if value := os.Getenv(envVar); value != "" {
+		return value, nil
+	}
+
+	fileVar := envVar + "_FILE"
+	fileName := os.Getenv(fileVar)
+	if fileName == "" {
+		return "", nil
+	}
+
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the file %s (defined by env var %s): %v", fileName, fileVar, err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// GetOrDefaultInt returns the given environment variable value as an integer.
+// Returns the default if the envvar cannot be converted to an int.
+func GetOrDefaultInt(envVar string, defaultValue int) int {
+	v, err := strconv.Atoi(GetOrFile(envVar))
+	if err != nil {
+		return defaultValue
+	}
+
+	return v
+}
+
+// GetOrDefaultFloat returns the given environment variable value as a float64.
+// Returns the default if the envvar cannot be converted to a float64.
+func GetOrDefaultFloat(envVar string, defaultValue float64) float64 {
+	v, err := strconv.ParseFloat(GetOrFile(envVar), 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return v
+}
+
+// GetOrDefaultSecond returns the given environment variable value as a time.Duration (second).
+// Returns the default if the envvar cannot be converted to an int.
+func GetOrDefaultSecond(envVar string, defaultValue time.Duration) time.Duration {
+	v := GetOrDefaultInt(envVar, -1)
+	if v < 0 {
+		return defaultValue
+	}
+
+	return time.Duration(v) * time.Second
+}
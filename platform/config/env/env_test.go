@@ -0,0 +1,152 @@
+package env
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrDefaultInt(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		envValue string
+		expected int
+	}{
+		{desc: "valid value", envValue: "100", expected: 100},
+		{desc: "unset", envValue: "", expected: 42},
+		{desc: "not a number", envValue: "abc", expected: 42},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer os.Unsetenv("LEGO_ENV_TEST_INT")
+			os.Setenv("LEGO_ENV_TEST_INT", test.envValue)
+
+			assert.Equal(t, test.expected, GetOrDefaultInt("LEGO_ENV_TEST_INT", 42))
+		})
+	}
+}
+
+func TestGetOrDefaultFloat(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		envValue string
+		expected float64
+	}{
+		{desc: "valid value", envValue: "2.5", expected: 2.5},
+		{desc: "unset", envValue: "", expected: 42},
+		{desc: "not a number", envValue: "abc", expected: 42},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer os.Unsetenv("LEGO_ENV_TEST_FLOAT")
+			os.Setenv("LEGO_ENV_TEST_FLOAT", test.envValue)
+
+			assert.Equal(t, test.expected, GetOrDefaultFloat("LEGO_ENV_TEST_FLOAT", 42))
+		})
+	}
+}
+
+func TestGetOrDefaultSecond(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		envValue string
+		expected time.Duration
+	}{
+		{desc: "valid value", envValue: "100", expected: 100 * time.Second},
+		{desc: "zero", envValue: "0", expected: 0},
+		{desc: "negative", envValue: "-1", expected: 42 * time.Second},
+		{desc: "unset", envValue: "", expected: 42 * time.Second},
+		{desc: "not a number", envValue: "abc", expected: 42 * time.Second},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer os.Unsetenv("LEGO_ENV_TEST_SECOND")
+			os.Setenv("LEGO_ENV_TEST_SECOND", test.envValue)
+
+			assert.Equal(t, test.expected, GetOrDefaultSecond("LEGO_ENV_TEST_SECOND", 42*time.Second))
+		})
+	}
+}
+
+func TestGetOrFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "lego")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString("secret-from-file\n\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	testCases := []struct {
+		desc      string
+		envValue  string
+		fileValue string
+		expected  string
+	}{
+		{desc: "direct var", envValue: "secret", expected: "secret"},
+		{desc: "file var", fileValue: file.Name(), expected: "secret-from-file"},
+		{desc: "both set", envValue: "secret", fileValue: file.Name(), expected: "secret"},
+		{desc: "unset", expected: ""},
+		{desc: "missing file", fileValue: file.Name() + ".missing", expected: ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer os.Unsetenv("LEGO_ENV_TEST_SECRET")
+			defer os.Unsetenv("LEGO_ENV_TEST_SECRET_FILE")
+			os.Setenv("LEGO_ENV_TEST_SECRET", test.envValue)
+			os.Setenv("LEGO_ENV_TEST_SECRET_FILE", test.fileValue)
+
+			assert.Equal(t, test.expected, GetOrFile("LEGO_ENV_TEST_SECRET"))
+		})
+	}
+}
+
+func TestGetWithFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "lego")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString("secret-from-file\r\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	defer os.Unsetenv("LEGO_ENV_TEST_SECRET_FILE")
+	os.Setenv("LEGO_ENV_TEST_SECRET_FILE", file.Name())
+
+	values, err := Get("LEGO_ENV_TEST_SECRET")
+	require.NoError(t, err)
+	assert.Equal(t, "secret-from-file", values["LEGO_ENV_TEST_SECRET"])
+
+	os.Setenv("LEGO_ENV_TEST_SECRET_FILE", file.Name()+".missing")
+
+	_, err = Get("LEGO_ENV_TEST_SECRET")
+	assert.Error(t, err)
+}
+
+func TestGetWithFallback(t *testing.T) {
+	defer os.Unsetenv("LEGO_ENV_TEST_NEW")
+	defer os.Unsetenv("LEGO_ENV_TEST_OLD")
+
+	_, err := GetWithFallback([]string{"LEGO_ENV_TEST_NEW", "LEGO_ENV_TEST_OLD"})
+	assert.EqualError(t, err, "some credentials information are missing: LEGO_ENV_TEST_NEW")
+
+	os.Setenv("LEGO_ENV_TEST_OLD", "old")
+
+	values, err := GetWithFallback([]string{"LEGO_ENV_TEST_NEW", "LEGO_ENV_TEST_OLD"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"LEGO_ENV_TEST_NEW": "old"}, values)
+
+	os.Setenv("LEGO_ENV_TEST_NEW", "new")
+
+	values, err = GetWithFallback([]string{"LEGO_ENV_TEST_NEW", "LEGO_ENV_TEST_OLD"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"LEGO_ENV_TEST_NEW": "new"}, values)
+}
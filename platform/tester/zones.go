@@ -0,0 +1,65 @@
+// Package tester contains the helpers shared by the tests of the DNS providers.
+package tester
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+)
+
+// MockZones makes acme.FindZoneByFqdn find the given zones, e.g. "example.com.",
+// without querying the real nameservers: a DNS server on a local port answers
+// their SOA queries and replaces acme.RecursiveNameservers.
+// The other names have no zone. The returned function restores the nameservers.
+func MockZones(t *testing.T, zones ...string) func() {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start the DNS server of the zones: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           soaHandler(zones),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	<-started
+
+	savedNameservers := acme.RecursiveNameservers
+	acme.RecursiveNameservers = []string{pc.LocalAddr().String()}
+	acme.ClearFqdnCache()
+
+	return func() {
+		acme.RecursiveNameservers = savedNameservers
+		acme.ClearFqdnCache()
+		server.Shutdown()
+	}
+}
+
+// soaHandler answers the SOA queries of the zones, and NXDOMAIN for the other names.
+func soaHandler(zones []string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeNameError)
+
+		name := req.Question[0].Name
+		for _, zone := range zones {
+			if req.Question[0].Qtype == dns.TypeSOA && strings.EqualFold(name, acme.ToFqdn(zone)) {
+				m.SetRcode(req, dns.RcodeSuccess)
+				m.Answer = []dns.RR{&dns.SOA{
+					Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+					Ns:     "ns1." + name,
+					Mbox:   "hostmaster." + name,
+					Serial: 1,
+				}}
+				break
+			}
+		}
+
+		w.WriteMsg(m)
+	}
+}
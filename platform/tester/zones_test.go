@@ -0,0 +1,30 @@
+package tester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+)
+
+func TestMockZones(t *testing.T) {
+	savedNameservers := acme.RecursiveNameservers
+
+	restore := MockZones(t, "example.com.", "sub.example.com")
+
+	zone, err := acme.FindZoneByFqdn("_acme-challenge.www.example.com.", acme.RecursiveNameservers)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", zone)
+
+	// the longest zone of the fqdn is found.
+	zone, err = acme.FindZoneByFqdn("_acme-challenge.sub.example.com.", acme.RecursiveNameservers)
+	require.NoError(t, err)
+	assert.Equal(t, "sub.example.com.", zone)
+
+	_, err = acme.FindZoneByFqdn("_acme-challenge.example.org.", acme.RecursiveNameservers)
+	assert.Error(t, err)
+
+	restore()
+	assert.Equal(t, savedNameservers, acme.RecursiveNameservers)
+}
@@ -0,0 +1,98 @@
+// Package wait provides helpers to pace the calls to the DNS providers APIs.
+package wait
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// Limiter is a token bucket limiting the rate of the calls to an API.
+// It can be shared by the goroutines of a provider. A nil Limiter does not limit anything.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate calls per second, with bursts of up to burst calls.
+// It returns nil (no limit) if rate is not positive.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// NewLimiterFromEnv returns a Limiter allowing the number of calls per second set in the given
+// environment variable (e.g. HOSTINGDE_HTTP_RPS), without bursts, or nil if it is not set.
+func NewLimiterFromEnv(envVar string) *Limiter {
+	return NewLimiter(env.GetOrDefaultFloat(envVar, 0), 1)
+}
+
+// Wait blocks until a call is allowed.
+func (l *Limiter) Wait() {
+	l.WaitContext(context.Background())
+}
+
+// WaitContext blocks until a call is allowed, or until the context is done.
+// It returns the error of the context in the latter case, the call is then not counted.
+func (l *Limiter) WaitContext(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token and returns how long to wait for it to be available.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// release gives back a token taken by reserve for a call which was not made.
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+}
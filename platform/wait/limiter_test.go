@@ -0,0 +1,98 @@
+package wait
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	limiter := NewLimiter(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Wait()
+	}
+	elapsed := time.Since(start)
+
+	// the first call is immediate, the 4 others are spaced by 50ms.
+	assert.True(t, elapsed >= 190*time.Millisecond, "the calls were not spaced: %v", elapsed)
+	assert.True(t, elapsed < 400*time.Millisecond, "the calls were spaced too much: %v", elapsed)
+}
+
+func TestLimiter_WaitConcurrent(t *testing.T) {
+	limiter := NewLimiter(20, 1)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Wait()
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, time.Since(start) >= 190*time.Millisecond, "the concurrent calls were not spaced: %v", time.Since(start))
+}
+
+func TestLimiter_WaitContext(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+
+	assert.NoError(t, limiter.WaitContext(context.Background()))
+
+	// the next call is allowed in a second, after the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := limiter.WaitContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the wait was not interrupted: %v", time.Since(start))
+
+	// the interrupted call is not counted.
+	assert.InDelta(t, 0, limiter.tokens, 0.1)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, limiter.WaitContext(canceled))
+
+	var unlimited *Limiter
+	assert.NoError(t, unlimited.WaitContext(canceled))
+}
+
+func TestLimiter_reserve(t *testing.T) {
+	limiter := NewLimiter(10, 2)
+	now := limiter.last
+
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+	assert.Equal(t, time.Duration(0), limiter.reserve(now))
+	assert.Equal(t, 100*time.Millisecond, limiter.reserve(now))
+	assert.Equal(t, 200*time.Millisecond, limiter.reserve(now))
+
+	// the bucket refills at 10 tokens per second, up to the burst.
+	assert.Equal(t, time.Duration(0), limiter.reserve(now.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), limiter.reserve(now.Add(time.Hour)))
+	assert.Equal(t, 100*time.Millisecond, limiter.reserve(now.Add(time.Hour)))
+}
+
+func TestNewLimiterFromEnv(t *testing.T) {
+	defer os.Unsetenv("LEGO_WAIT_TEST_HTTP_RPS")
+
+	assert.Nil(t, NewLimiterFromEnv("LEGO_WAIT_TEST_HTTP_RPS"))
+
+	os.Setenv("LEGO_WAIT_TEST_HTTP_RPS", "0.5")
+	limiter := NewLimiterFromEnv("LEGO_WAIT_TEST_HTTP_RPS")
+	if assert.NotNil(t, limiter) {
+		assert.Equal(t, 0.5, limiter.rate)
+	}
+
+	// a nil limiter does not block.
+	var unlimited *Limiter
+	unlimited.Wait()
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/cpu/goacmedns"
 	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
@@ -159,6 +160,10 @@ func (d *DNSProvider) register(domain, fqdn string) error {
 		return err
 	}
 
+	// The error may only be reported once the other domains of the
+	// certificate are processed, so the CNAME to create is logged right away.
+	log.Warnf("[%s] acme-dns: new account registered, create the CNAME %s pointing to %s, then run again", domain, fqdn, newAcct.FullDomain)
+
 	// Stop issuance by returning an error. The user needs to perform a manual
 	// one-time CNAME setup in their DNS zone to complete the setup of the new
 	// account we created.
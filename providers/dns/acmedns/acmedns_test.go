@@ -1,13 +1,21 @@
 package acmedns
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/cpu/goacmedns"
+	"github.com/xenolf/lego/acme"
 )
 
 var (
@@ -274,3 +282,115 @@ func TestRegister(t *testing.T) {
 		})
 	}
 }
+
+// mockServer is an acme-dns server registering a single account.
+type mockServer struct {
+	t         *testing.T
+	mu        sync.Mutex
+	registers int
+	updates   []string
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	require.Equal(s.t, http.MethodPost, r.Method)
+
+	switch r.URL.Path {
+	case "/register":
+		s.registers++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(egAccount)
+
+	case "/update":
+		if r.Header.Get("X-Api-User") != egAccount.Username || r.Header.Get("X-Api-Key") != egAccount.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "forbidden"}`))
+			return
+		}
+
+		var update struct {
+			SubDomain string
+			Txt       string
+		}
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&update))
+		assert.Equal(s.t, egAccount.SubDomain, update.SubDomain)
+
+		s.updates = append(s.updates, update.Txt)
+		json.NewEncoder(w).Encode(map[string]string{"txt": update.Txt})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestPresentServer tests the registration of a new account, its persistence
+// in the storage file, then the update of its TXT record by a new provider,
+// against an acme-dns server.
+func TestPresentServer(t *testing.T) {
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "acmedns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	storagePath := filepath.Join(dir, "acme-dns.json")
+
+	defer os.Setenv(apiBaseEnvVar, os.Getenv(apiBaseEnvVar))
+	defer os.Setenv(storagePathEnvVar, os.Getenv(storagePathEnvVar))
+	os.Setenv(apiBaseEnvVar, ts.URL)
+	os.Setenv(storagePathEnvVar, storagePath)
+
+	dp, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	// first run: the domain has no account yet.
+	err = dp.Present(egDomain, "", egKeyAuth)
+	assert.Equal(t, ErrCNAMERequired{Domain: egDomain, FQDN: egFQDN, Target: egAccount.FullDomain}, err)
+	assert.Equal(t, 1, server.registers)
+	assert.Empty(t, server.updates)
+
+	raw, err := ioutil.ReadFile(storagePath)
+	require.NoError(t, err)
+
+	var stored map[string]goacmedns.Account
+	require.NoError(t, json.Unmarshal(raw, &stored))
+	assert.Equal(t, map[string]goacmedns.Account{egDomain: egAccount}, stored)
+
+	info, err := os.Stat(storagePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// next runs: the stored account is used to update the TXT record.
+	dp, err = NewDNSProvider()
+	require.NoError(t, err)
+
+	err = dp.Present(egDomain, "", egKeyAuth)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record(egDomain, egKeyAuth)
+	assert.Equal(t, 1, server.registers)
+	assert.Equal(t, []string{value}, server.updates)
+}
+
+// TestPresentServerUpdateError tests that the errors of the acme-dns server are returned.
+func TestPresentServerUpdateError(t *testing.T) {
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	account := egAccount
+	account.Password = "wrong"
+	storage := mockStorage{map[string]goacmedns.Account{egDomain: account}}
+
+	dp, err := NewDNSProviderClient(goacmedns.NewClient(ts.URL), storage)
+	require.NoError(t, err)
+
+	err = dp.Present(egDomain, "", egKeyAuth)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to update txt record")
+	assert.Empty(t, server.updates)
+}
@@ -0,0 +1,184 @@
+// Package alidns implements a DNS provider for solving the DNS-01 challenge using Alibaba Cloud DNS.
+// See https://www.alibabacloud.com/help/doc-detail/29739.htm
+package alidns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://alidns.aliyuncs.com"
+
+// minTTL is the lowest TTL accepted by Alibaba Cloud DNS for a record of the free edition.
+const minTTL = 600
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	AccessKey          string
+	SecretKey          string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("ALICLOUD_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("ALICLOUD_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("ALICLOUD_TTL", minTTL),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("ALICLOUD_HTTP_TIMEOUT", 10*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Alibaba Cloud DNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// recordIDs are the IDs of the created records, by fqdn and value.
+	recordIDs   map[string]string
+	recordIDsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Alibaba Cloud DNS.
+// Credentials must be passed in the environment variables ALICLOUD_ACCESS_KEY and ALICLOUD_SECRET_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("ALICLOUD_ACCESS_KEY", "ALICLOUD_SECRET_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("alidns: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.AccessKey = values["ALICLOUD_ACCESS_KEY"]
+	config.SecretKey = values["ALICLOUD_SECRET_KEY"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Alibaba Cloud DNS.
+func NewDNSProviderCredentials(accessKey, secretKey string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.AccessKey = accessKey
+	config.SecretKey = secretKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Alibaba Cloud DNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("alidns: the configuration of the DNS provider is nil")
+	}
+
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("alidns: credentials missing")
+	}
+
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("alidns: invalid TTL, TTL (%d) must be greater than or equal to %d", config.TTL, minTTL)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(10 * time.Second)
+	}
+
+	return &DNSProvider{config: config, recordIDs: make(map[string]string)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, err := d.findDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("alidns: %v", err)
+	}
+
+	recordID, err := d.addTXTRecord(zone, extractRecordName(fqdn, zone), value, d.config.TTL)
+	if err != nil {
+		return fmt.Errorf("alidns: failed to create TXT record: %v", err)
+	}
+
+	d.recordIDsMu.Lock()
+	d.recordIDs[fqdn+" "+value] = recordID
+	d.recordIDsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present, by its ID.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordIDsMu.Lock()
+	recordID, ok := d.recordIDs[fqdn+" "+value]
+	d.recordIDsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("alidns: unknown record ID for %q", fqdn)
+	}
+
+	if err := d.deleteRecord(recordID); err != nil {
+		return fmt.Errorf("alidns: failed to delete TXT record: %v", err)
+	}
+
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn+" "+value)
+	d.recordIDsMu.Unlock()
+
+	return nil
+}
+
+// findDomain returns the longest domain of the account which is a parent of the fqdn.
+func (d *DNSProvider) findDomain(fqdn string) (string, error) {
+	domains, err := d.describeDomains()
+	if err != nil {
+		return "", fmt.Errorf("could not list the domains: %v", err)
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+
+	var zone string
+	for _, domain := range domains {
+		domainName := strings.ToLower(acme.UnFqdn(domain.DomainName))
+		if strings.HasSuffix(name, "."+domainName) && len(domainName) > len(zone) {
+			zone = domainName
+		}
+	}
+
+	if zone == "" {
+		return "", fmt.Errorf("could not find the domain of %q in the account", fqdn)
+	}
+	return zone, nil
+}
+
+func extractRecordName(fqdn, zone string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(name, "."+zone); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,275 @@
+package alidns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest         bool
+	envTestAccessKey string
+	envTestSecretKey string
+	envTestDomain    string
+)
+
+func init() {
+	envTestAccessKey = os.Getenv("ALICLOUD_ACCESS_KEY")
+	envTestSecretKey = os.Getenv("ALICLOUD_SECRET_KEY")
+	envTestDomain = os.Getenv("ALICLOUD_DOMAIN")
+	liveTest = len(envTestAccessKey) > 0 && len(envTestSecretKey) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("ALICLOUD_ACCESS_KEY", envTestAccessKey)
+	os.Setenv("ALICLOUD_SECRET_KEY", envTestSecretKey)
+	os.Unsetenv("ALICLOUD_TTL")
+}
+
+// mockServer is an Alibaba Cloud DNS API checking the signature of the requests.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	actions  []string
+	nonces   map[string]bool
+	domains  []Domain
+	records  map[string]url.Values
+	recordID int
+}
+
+func newMockServer(t *testing.T, domains ...string) *mockServer {
+	server := &mockServer{t: t, nonces: make(map[string]bool), records: make(map[string]url.Values)}
+	for i, domain := range domains {
+		server.domains = append(server.domains, Domain{DomainID: strconv.Itoa(i + 1), DomainName: domain})
+	}
+	return server
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	s.actions = append(s.actions, query.Get("Action"))
+
+	assert.Equal(s.t, http.MethodGet, r.Method)
+	assert.Equal(s.t, "access", query.Get("AccessKeyId"))
+	assert.Equal(s.t, "JSON", query.Get("Format"))
+	assert.Equal(s.t, "2015-01-09", query.Get("Version"))
+	assert.Equal(s.t, "HMAC-SHA1", query.Get("SignatureMethod"))
+	assert.Equal(s.t, "1.0", query.Get("SignatureVersion"))
+
+	timestamp, err := time.Parse("2006-01-02T15:04:05Z", query.Get("Timestamp"))
+	assert.NoError(s.t, err)
+	assert.WithinDuration(s.t, time.Now(), timestamp, time.Minute)
+
+	nonce := query.Get("SignatureNonce")
+	assert.NotEmpty(s.t, nonce)
+	assert.False(s.t, s.nonces[nonce], "the nonce %s is reused", nonce)
+	s.nonces[nonce] = true
+
+	if query.Get("Signature") != signature(http.MethodGet, query, "secret") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIError{RequestID: "1", Code: "SignatureDoesNotMatch", Message: "Specified signature is not matched with our calculation."})
+		return
+	}
+
+	switch query.Get("Action") {
+	case "DescribeDomains":
+		page, _ := strconv.Atoi(query.Get("PageNumber"))
+		var resp describeDomainsResponse
+		resp.TotalCount = len(s.domains)
+		resp.PageNumber = page
+		resp.PageSize = 1
+		if page <= len(s.domains) {
+			resp.Domains.Domain = s.domains[page-1 : page]
+		}
+		json.NewEncoder(w).Encode(resp)
+
+	case "AddDomainRecord":
+		s.recordID++
+		id := strconv.Itoa(s.recordID)
+		s.records[id] = query
+		json.NewEncoder(w).Encode(addDomainRecordResponse{RecordID: id})
+
+	case "DeleteDomainRecord":
+		id := query.Get("RecordId")
+		if _, ok := s.records[id]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIError{RequestID: "2", Code: "DomainRecordNotBelongToUser", Message: "The DNS record does not exist."})
+			return
+		}
+		delete(s.records, id)
+		json.NewEncoder(w).Encode(map[string]string{"RequestId": "3", "RecordId": id})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("ALICLOUD_ACCESS_KEY", "access")
+	os.Setenv("ALICLOUD_SECRET_KEY", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("ALICLOUD_ACCESS_KEY", "")
+	os.Setenv("ALICLOUD_SECRET_KEY", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "alidns: some credentials information are missing: ALICLOUD_ACCESS_KEY,ALICLOUD_SECRET_KEY")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "alidns: the configuration of the DNS provider is nil")
+
+	config := NewDefaultConfig()
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "alidns: credentials missing")
+
+	config.AccessKey = "access"
+	config.SecretKey = "secret"
+	config.TTL = 60
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "alidns: invalid TTL, TTL (60) must be greater than or equal to 600")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := newMockServer(t, "example.org", "example.com", "sub.example.com")
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessKey = "access"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.Present("www.sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records, 2)
+
+	record := server.records["1"]
+	assert.Equal(t, "example.com", record.Get("DomainName"))
+	assert.Equal(t, "_acme-challenge.www", record.Get("RR"))
+	assert.Equal(t, "TXT", record.Get("Type"))
+	assert.Equal(t, "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", record.Get("Value"))
+	assert.Equal(t, "600", record.Get("TTL"))
+
+	// The longest domain of the account is used.
+	record = server.records["2"]
+	assert.Equal(t, "sub.example.com", record.Get("DomainName"))
+	assert.Equal(t, "_acme-challenge.www", record.Get("RR"))
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records)
+	assert.Empty(t, provider.recordIDs)
+
+	expected := []string{
+		"DescribeDomains", "DescribeDomains", "DescribeDomains", "AddDomainRecord",
+		"DescribeDomains", "DescribeDomains", "DescribeDomains", "AddDomainRecord",
+		"DeleteDomainRecord", "DeleteDomainRecord",
+	}
+	assert.Equal(t, expected, server.actions)
+}
+
+func TestDNSProvider_PresentUnknownDomain(t *testing.T) {
+	server := newMockServer(t, "example.org")
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessKey = "access"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `alidns: could not find the domain of "_acme-challenge.example.com." in the account`)
+	assert.Empty(t, server.records)
+}
+
+func TestDNSProvider_InvalidSignature(t *testing.T) {
+	server := newMockServer(t, "example.com")
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessKey = "access"
+	config.SecretKey = "other"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "alidns: could not list the domains: API error (HTTP 400): SignatureDoesNotMatch: Specified signature is not matched with our calculation. (request 1)")
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	server := newMockServer(t, "example.com")
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessKey = "access"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `alidns: unknown record ID for "_acme-challenge.example.com."`)
+	assert.Empty(t, server.actions)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
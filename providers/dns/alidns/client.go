@@ -0,0 +1,214 @@
+package alidns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the version of the Alibaba Cloud DNS API.
+const apiVersion = "2015-01-09"
+
+// timestampFormat is the format of the Timestamp parameter: ISO 8601 in UTC.
+const timestampFormat = "2006-01-02T15:04:05Z"
+
+// domainsPageSize is the number of domains per page of DescribeDomains, the maximum is 100.
+const domainsPageSize = 100
+
+// Domain is a domain of the Alibaba Cloud DNS account.
+type Domain struct {
+	DomainID   string `json:"DomainId"`
+	DomainName string `json:"DomainName"`
+}
+
+type describeDomainsResponse struct {
+	TotalCount int `json:"TotalCount"`
+	PageNumber int `json:"PageNumber"`
+	PageSize   int `json:"PageSize"`
+	Domains    struct {
+		Domain []Domain `json:"Domain"`
+	} `json:"Domains"`
+}
+
+type addDomainRecordResponse struct {
+	RecordID string `json:"RecordId"`
+}
+
+// APIError is an error returned by the Alibaba Cloud DNS API.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	RequestID  string `json:"RequestId"`
+	Code       string `json:"Code"`
+	Message    string `json:"Message"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// describeDomains returns all the domains of the account.
+func (d *DNSProvider) describeDomains() ([]Domain, error) {
+	var domains []Domain
+
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Set("PageNumber", strconv.Itoa(page))
+		params.Set("PageSize", strconv.Itoa(domainsPageSize))
+
+		var resp describeDomainsResponse
+		if err := d.doRequest("DescribeDomains", params, &resp); err != nil {
+			return nil, err
+		}
+
+		domains = append(domains, resp.Domains.Domain...)
+
+		if len(resp.Domains.Domain) == 0 || len(domains) >= resp.TotalCount {
+			return domains, nil
+		}
+	}
+}
+
+// addTXTRecord creates the TXT record of the domain and returns its ID.
+func (d *DNSProvider) addTXTRecord(domain, rr, value string, ttl int) (string, error) {
+	params := url.Values{}
+	params.Set("DomainName", domain)
+	params.Set("RR", rr)
+	params.Set("Type", "TXT")
+	params.Set("Value", value)
+	params.Set("TTL", strconv.Itoa(ttl))
+
+	var resp addDomainRecordResponse
+	if err := d.doRequest("AddDomainRecord", params, &resp); err != nil {
+		return "", err
+	}
+	if resp.RecordID == "" {
+		return "", fmt.Errorf("no record ID in the response of AddDomainRecord")
+	}
+
+	return resp.RecordID, nil
+}
+
+// deleteRecord deletes the record with the given ID.
+func (d *DNSProvider) deleteRecord(recordID string) error {
+	params := url.Values{}
+	params.Set("RecordId", recordID)
+
+	return d.doRequest("DeleteDomainRecord", params, nil)
+}
+
+// doRequest calls the action of the RPC API with the parameters, signed with the access key,
+// and decodes the JSON response into result if not nil.
+func (d *DNSProvider) doRequest(action string, params url.Values, result interface{}) error {
+	nonce, err := newSignatureNonce()
+	if err != nil {
+		return err
+	}
+
+	params.Set("Action", action)
+	params.Set("Format", "JSON")
+	params.Set("Version", apiVersion)
+	params.Set("AccessKeyId", d.config.AccessKey)
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("SignatureNonce", nonce)
+	params.Set("Timestamp", time.Now().UTC().Format(timestampFormat))
+	params.Set("Signature", signature(http.MethodGet, params, d.config.SecretKey))
+
+	req, err := http.NewRequest(http.MethodGet, d.config.BaseURL+"/?"+canonicalizedQuery(params), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := APIError{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(raw, &apiErr); err != nil || apiErr.Code == "" {
+			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	return nil
+}
+
+// signature computes the signature of the RPC request: the base64 encoded HMAC-SHA1,
+// keyed with the secret followed by "&", of the method, the encoded "/" and the encoded
+// canonicalized query, separated by "&".
+func signature(method string, params url.Values, secret string) string {
+	query := make(url.Values, len(params))
+	for name, values := range params {
+		if name != "Signature" {
+			query[name] = values
+		}
+	}
+
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(canonicalizedQuery(query))
+
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalizedQuery returns the percent encoded parameters sorted by name.
+func canonicalizedQuery(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range params[name] {
+			pairs = append(pairs, percentEncode(name)+"="+percentEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// percentEncode encodes the value as required by the signature,
+// following RFC 3986: the spaces are encoded as %20 and "~" is not encoded.
+func percentEncode(value string) string {
+	encoded := url.QueryEscape(value)
+	encoded = strings.Replace(encoded, "+", "%20", -1)
+	encoded = strings.Replace(encoded, "*", "%2A", -1)
+	encoded = strings.Replace(encoded, "%7E", "~", -1)
+	return encoded
+}
+
+// newSignatureNonce returns a random nonce, which must be unique for each request.
+func newSignatureNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate the signature nonce: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,54 @@
+package alidns
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	// The example of the documentation of the signature of the Alibaba Cloud DNS API.
+	params := url.Values{}
+	params.Set("Format", "XML")
+	params.Set("AccessKeyId", "testid")
+	params.Set("Action", "DescribeDomainRecords")
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("DomainName", "example.com")
+	params.Set("SignatureNonce", "f59ed6a9-83fc-473b-9cc6-99c95df3856e")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("Version", "2015-01-09")
+	params.Set("Timestamp", "2016-03-24T16:41:54Z")
+
+	assert.Equal(t, "uRpHwaSEt3J+6KQD//svCh/x+pI=", signature("GET", params, "testsecret"))
+
+	// The signature itself is not signed.
+	params.Set("Signature", "uRpHwaSEt3J+6KQD//svCh/x+pI=")
+	assert.Equal(t, "uRpHwaSEt3J+6KQD//svCh/x+pI=", signature("GET", params, "testsecret"))
+}
+
+func TestPercentEncode(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{value: "2016-03-24T16:41:54Z", expected: "2016-03-24T16%3A41%3A54Z"},
+		{value: "a b", expected: "a%20b"},
+		{value: "a*b", expected: "a%2Ab"},
+		{value: "a~b", expected: "a~b"},
+		{value: "a+b/c=", expected: "a%2Bb%2Fc%3D"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, percentEncode(test.value), test.value)
+	}
+}
+
+func TestCanonicalizedQuery(t *testing.T) {
+	params := url.Values{}
+	params.Set("Value", "a b")
+	params.Set("Action", "AddDomainRecord")
+	params.Set("RR", "_acme-challenge")
+
+	assert.Equal(t, "Action=AddDomainRecord&RR=_acme-challenge&Value=a%20b", canonicalizedQuery(params))
+}
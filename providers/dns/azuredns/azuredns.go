@@ -0,0 +1,262 @@
+// Package azuredns implements a DNS provider for solving the DNS-01 challenge
+// using the Azure DNS REST API.
+// The provider authenticates with the client secret of a service principal if
+// AZURE_CLIENT_SECRET is set, with the managed identity of the Azure host otherwise.
+// See https://docs.microsoft.com/en-us/rest/api/dns/
+package azuredns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+const (
+	defaultResourceManagerEndpoint = "https://management.azure.com"
+	defaultActiveDirectoryEndpoint = "https://login.microsoftonline.com"
+	// defaultMetadataEndpoint is the Azure Instance Metadata Service providing the managed identity tokens.
+	defaultMetadataEndpoint = "http://169.254.169.254"
+)
+
+// maxRetries is the number of retries of a record set update made concurrently with another one.
+const maxRetries = 5
+
+// Config is used to configure the creation of the DNSProvider.
+// The managed identity of the host is used if ClientSecret is empty,
+// ClientID then optionally selects a user-assigned identity.
+type Config struct {
+	ClientID                string
+	ClientSecret            string
+	TenantID                string
+	SubscriptionID          string
+	ResourceGroup           string
+	ResourceManagerEndpoint string
+	ActiveDirectoryEndpoint string
+	MetadataEndpoint        string
+	PropagationTimeout      time.Duration
+	PollingInterval         time.Duration
+	TTL                     int
+	HTTPClient              *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		ResourceManagerEndpoint: defaultResourceManagerEndpoint,
+		ActiveDirectoryEndpoint: defaultActiveDirectoryEndpoint,
+		MetadataEndpoint:        defaultMetadataEndpoint,
+		PropagationTimeout:      env.GetOrDefaultSecond("AZURE_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:         env.GetOrDefaultSecond("AZURE_POLLING_INTERVAL", 2*time.Second),
+		TTL:                     env.GetOrDefaultInt("AZURE_TTL", 60),
+		HTTPClient:              acme.NewHTTPClient(env.GetOrDefaultSecond("AZURE_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Azure DNS REST API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+
+	accessToken string
+	tokenExpiry time.Time
+	tokenMu     sync.Mutex
+
+	// recordsMu serializes the updates of the record sets.
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Azure DNS.
+// The zones are looked up in AZURE_RESOURCE_GROUP of AZURE_SUBSCRIPTION_ID.
+// A service principal is authenticated with AZURE_CLIENT_ID, AZURE_CLIENT_SECRET and AZURE_TENANT_ID,
+// without AZURE_CLIENT_SECRET the managed identity of the host is used.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("AZURE_SUBSCRIPTION_ID", "AZURE_RESOURCE_GROUP")
+	if err != nil {
+		return nil, fmt.Errorf("azuredns: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = values["AZURE_SUBSCRIPTION_ID"]
+	config.ResourceGroup = values["AZURE_RESOURCE_GROUP"]
+	config.ClientID = os.Getenv("AZURE_CLIENT_ID")
+	config.ClientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	config.TenantID = os.Getenv("AZURE_TENANT_ID")
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Azure DNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("azuredns: the configuration of the DNS provider is nil")
+	}
+
+	if config.SubscriptionID == "" || config.ResourceGroup == "" {
+		return nil, errors.New("azuredns: the subscription ID and the resource group are required")
+	}
+
+	if config.ClientSecret != "" && (config.ClientID == "" || config.TenantID == "") {
+		return nil, errors.New("azuredns: the client ID and the tenant ID are required with a client secret")
+	}
+
+	config.ResourceManagerEndpoint = endpoint(config.ResourceManagerEndpoint, defaultResourceManagerEndpoint)
+	config.ActiveDirectoryEndpoint = endpoint(config.ActiveDirectoryEndpoint, defaultActiveDirectoryEndpoint)
+	config.MetadataEndpoint = endpoint(config.MetadataEndpoint, defaultMetadataEndpoint)
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present adds the value to the TXT record set, keeping the values already present,
+// e.g. the one of the wildcard of the domain.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	err := d.updateTXTRecordSet(fqdn, func(values []string) []string {
+		for _, v := range values {
+			if v == value {
+				return values
+			}
+		}
+		return append(values, value)
+	})
+	if err != nil {
+		return fmt.Errorf("azuredns: failed to create TXT record: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the value from the TXT record set, the record set is deleted with its last value.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	err := d.updateTXTRecordSet(fqdn, func(values []string) []string {
+		var kept []string
+		for _, v := range values {
+			if v != value {
+				kept = append(kept, v)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return fmt.Errorf("azuredns: failed to delete TXT record: %v", err)
+	}
+	return nil
+}
+
+// updateTXTRecordSet replaces the values of the TXT record set of the fqdn by the result of update.
+// The record set is read and written with its etag, the update is retried if the record set
+// was changed concurrently, e.g. by another instance solving the challenge of the wildcard.
+func (d *DNSProvider) updateTXTRecordSet(fqdn string, update func(values []string) []string) error {
+	zone, err := d.findZone(fqdn)
+	if err != nil {
+		return err
+	}
+	name := extractRecordName(fqdn, zone)
+
+	d.recordsMu.Lock()
+	defer d.recordsMu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		recordSet, err := d.getTXTRecordSet(zone, name)
+		if err != nil {
+			return err
+		}
+
+		var values []string
+		var etag string
+		if recordSet != nil {
+			etag = recordSet.Etag
+			for _, record := range recordSet.Properties.TXTRecords {
+				values = append(values, strings.Join(record.Value, ""))
+			}
+		}
+
+		updated := update(values)
+		if equalValues(values, updated) {
+			return nil
+		}
+
+		if len(updated) == 0 {
+			err = d.deleteTXTRecordSet(zone, name, etag)
+		} else {
+			newRecordSet := RecordSet{Properties: RecordSetProperties{TTL: d.config.TTL}}
+			for _, value := range updated {
+				newRecordSet.Properties.TXTRecords = append(newRecordSet.Properties.TXTRecords, TxtRecord{Value: []string{value}})
+			}
+			err = d.putTXTRecordSet(zone, name, etag, newRecordSet)
+		}
+
+		apiErr, ok := err.(*APIError)
+		if !ok || apiErr.StatusCode != http.StatusPreconditionFailed || attempt >= maxRetries {
+			return err
+		}
+	}
+}
+
+// findZone walks up the labels of the fqdn and returns the longest zone of the resource group.
+func (d *DNSProvider) findZone(fqdn string) (string, error) {
+	zones, err := d.getZones()
+	if err != nil {
+		return "", err
+	}
+
+	name := acme.UnFqdn(fqdn)
+	for _, index := range dns.Split(name) {
+		candidate := name[index:]
+
+		for _, zone := range zones {
+			if strings.EqualFold(zone, candidate) {
+				return zone, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find zone for domain %q in the resource group %q", fqdn, d.config.ResourceGroup)
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// endpoint returns the URL without trailing slash, or the default URL if it is empty.
+func endpoint(url, defaultURL string) string {
+	if url == "" {
+		return defaultURL
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+func extractRecordName(fqdn, zone string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(strings.ToLower(name), "."+strings.ToLower(zone)); idx != -1 {
+		return name[:idx]
+	}
+	return "@"
+}
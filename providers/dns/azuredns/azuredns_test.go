@@ -0,0 +1,467 @@
+package azuredns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest              bool
+	envTestClientID       string
+	envTestClientSecret   string
+	envTestTenantID       string
+	envTestSubscriptionID string
+	envTestResourceGroup  string
+	envTestDomain         string
+)
+
+func init() {
+	envTestClientID = os.Getenv("AZURE_CLIENT_ID")
+	envTestClientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	envTestTenantID = os.Getenv("AZURE_TENANT_ID")
+	envTestSubscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
+	envTestResourceGroup = os.Getenv("AZURE_RESOURCE_GROUP")
+	envTestDomain = os.Getenv("AZURE_DOMAIN")
+	liveTest = len(envTestSubscriptionID) > 0 && len(envTestResourceGroup) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("AZURE_CLIENT_ID", envTestClientID)
+	os.Setenv("AZURE_CLIENT_SECRET", envTestClientSecret)
+	os.Setenv("AZURE_TENANT_ID", envTestTenantID)
+	os.Setenv("AZURE_SUBSCRIPTION_ID", envTestSubscriptionID)
+	os.Setenv("AZURE_RESOURCE_GROUP", envTestResourceGroup)
+}
+
+const recordSetsPath = "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Network/dnsZones/"
+
+// mockServer is a minimal in-memory implementation of the token endpoints and of the Azure DNS API.
+type mockServer struct {
+	t  *testing.T
+	mu sync.Mutex
+	// zones are the zones of the resource group, returned one per page.
+	zones []string
+	// recordSets are the TXT record sets, by zone and relative name.
+	recordSets map[string]*RecordSet
+	nextEtag   int
+	// conflicts is the number of record set updates to reject as concurrently modified.
+	conflicts int
+	// resource is the expected audience of the tokens.
+	resource string
+	// expiresIn is the lifetime of the issued tokens in seconds.
+	expiresIn  int
+	tokens     []string
+	tokenQuery []string
+}
+
+func newMockServer(t *testing.T, zones ...string) *mockServer {
+	return &mockServer{t: t, zones: zones, recordSets: make(map[string]*RecordSet), expiresIn: 3600}
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/metadata/identity/oauth2/token":
+		assert.Equal(m.t, http.MethodGet, r.Method)
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request","error_description":"Required metadata header not specified"}`)
+			return
+		}
+		m.issueToken(w, "msi", r.URL.Query())
+
+	case r.URL.Path == "/tenant-1/oauth2/token":
+		assert.Equal(m.t, http.MethodPost, r.Method)
+		require.NoError(m.t, r.ParseForm())
+		if r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		m.issueToken(w, "sp", r.PostForm)
+
+	case strings.HasPrefix(r.URL.Path, "/subscriptions/"):
+		assert.Equal(m.t, apiVersion, r.URL.Query().Get("api-version"))
+		if !m.validToken(r.Header.Get("Authorization")) {
+			m.writeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "The access token is invalid.")
+			return
+		}
+		m.serveDNS(w, r)
+
+	default:
+		m.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockServer) issueToken(w http.ResponseWriter, kind string, params map[string][]string) {
+	assert.Equal(m.t, m.resource, params["resource"][0])
+
+	token := fmt.Sprintf("%s-token-%d", kind, len(m.tokens)+1)
+	m.tokens = append(m.tokens, token)
+	if clientID, ok := params["client_id"]; ok {
+		m.tokenQuery = append(m.tokenQuery, clientID[0])
+	} else {
+		m.tokenQuery = append(m.tokenQuery, "")
+	}
+
+	// the managed identity endpoint returns expires_on, Azure AD expires_in.
+	resp := map[string]string{"access_token": token, "token_type": "Bearer"}
+	if kind == "msi" {
+		resp["expires_on"] = strconv.FormatInt(time.Now().Unix()+int64(m.expiresIn), 10)
+	} else {
+		resp["expires_in"] = strconv.Itoa(m.expiresIn)
+	}
+	require.NoError(m.t, json.NewEncoder(w).Encode(resp))
+}
+
+func (m *mockServer) validToken(authorization string) bool {
+	return len(m.tokens) > 0 && authorization == "Bearer "+m.tokens[len(m.tokens)-1]
+}
+
+func (m *mockServer) serveDNS(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Network/dnsZones" {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		result := ZoneListResult{Value: []Zone{}}
+		if page < len(m.zones) {
+			result.Value = append(result.Value, Zone{Name: m.zones[page]})
+		}
+		if page+1 < len(m.zones) {
+			result.NextLink = fmt.Sprintf("http://%s%s?api-version=%s&page=%d", r.Host, r.URL.Path, apiVersion, page+1)
+		}
+		require.NoError(m.t, json.NewEncoder(w).Encode(result))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, recordSetsPath), "/")
+	if !strings.HasPrefix(r.URL.Path, recordSetsPath) || len(parts) != 3 || parts[1] != "TXT" {
+		m.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := parts[0] + "/" + parts[2]
+	existing := m.recordSets[key]
+
+	if r.Method == http.MethodGet {
+		if existing == nil {
+			m.writeError(w, http.StatusNotFound, "NotFound", "The resource record '"+parts[2]+"' does not exist.")
+			return
+		}
+		require.NoError(m.t, json.NewEncoder(w).Encode(existing))
+		return
+	}
+
+	if m.conflicts > 0 {
+		m.conflicts--
+		m.writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "The etag does not match.")
+		return
+	}
+	if existing != nil && r.Header.Get("If-Match") != existing.Etag || existing == nil && r.Header.Get("If-None-Match") != "*" {
+		m.writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "The etag does not match.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		recordSet := &RecordSet{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(recordSet))
+		m.nextEtag++
+		recordSet.Name = parts[2]
+		recordSet.Etag = "etag-" + strconv.Itoa(m.nextEtag)
+		m.recordSets[key] = recordSet
+		require.NoError(m.t, json.NewEncoder(w).Encode(recordSet))
+	case http.MethodDelete:
+		delete(m.recordSets, key)
+	default:
+		m.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (m *mockServer) writeError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"code":%q,"message":%q}}`, code, message)
+}
+
+// values returns the values of the TXT record set.
+func (m *mockServer) values(key string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recordSet, ok := m.recordSets[key]
+	if !ok {
+		return nil
+	}
+
+	var values []string
+	for _, record := range recordSet.Properties.TXTRecords {
+		values = append(values, strings.Join(record.Value, ""))
+	}
+	return values
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	defer restoreEnv()
+
+	testCases := []struct {
+		desc     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			desc: "managed identity",
+			env:  map[string]string{"AZURE_SUBSCRIPTION_ID": "sub-1", "AZURE_RESOURCE_GROUP": "rg-1"},
+		},
+		{
+			desc: "service principal",
+			env: map[string]string{"AZURE_SUBSCRIPTION_ID": "sub-1", "AZURE_RESOURCE_GROUP": "rg-1",
+				"AZURE_CLIENT_ID": "client-1", "AZURE_CLIENT_SECRET": "secret", "AZURE_TENANT_ID": "tenant-1"},
+		},
+		{
+			desc: "missing tenant",
+			env: map[string]string{"AZURE_SUBSCRIPTION_ID": "sub-1", "AZURE_RESOURCE_GROUP": "rg-1",
+				"AZURE_CLIENT_ID": "client-1", "AZURE_CLIENT_SECRET": "secret"},
+			expected: "azuredns: the client ID and the tenant ID are required with a client secret",
+		},
+		{
+			desc:     "missing resource group",
+			env:      map[string]string{"AZURE_SUBSCRIPTION_ID": "sub-1"},
+			expected: "azuredns: some credentials information are missing: AZURE_RESOURCE_GROUP",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			for _, name := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID", "AZURE_SUBSCRIPTION_ID", "AZURE_RESOURCE_GROUP"} {
+				os.Setenv(name, test.env[name])
+			}
+
+			provider, err := NewDNSProvider()
+			if test.expected != "" {
+				assert.EqualError(t, err, test.expected)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, defaultResourceManagerEndpoint, provider.config.ResourceManagerEndpoint)
+		})
+	}
+}
+
+func TestNewDNSProviderConfigNil(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "azuredns: the configuration of the DNS provider is nil")
+}
+
+func TestDNSProvider_ManagedIdentityToken(t *testing.T) {
+	server := newMockServer(t, "example.com")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	// the token is requested once and reused.
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	require.NoError(t, provider.CleanUp("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"msi-token-1"}, server.tokens)
+	assert.Equal(t, []string{""}, server.tokenQuery)
+
+	// an expiring token is renewed.
+	provider.tokenExpiry = time.Now().Add(30 * time.Second)
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"msi-token-1", "msi-token-2"}, server.tokens)
+}
+
+func TestDNSProvider_UserAssignedManagedIdentityToken(t *testing.T) {
+	server := newMockServer(t, "example.com")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+	config.ClientID = "identity-1"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"msi-token-1"}, server.tokens)
+	assert.Equal(t, []string{"identity-1"}, server.tokenQuery)
+}
+
+func TestDNSProvider_ManagedIdentityUnavailable(t *testing.T) {
+	provider, err := NewDNSProviderConfig(&Config{
+		SubscriptionID:   "sub-1",
+		ResourceGroup:    "rg-1",
+		MetadataEndpoint: "http://127.0.0.1:1",
+		HTTPClient:       &http.Client{Timeout: time.Second},
+	})
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "azuredns: failed to create TXT record: could not get a managed identity token: ")
+}
+
+func TestDNSProvider_ServicePrincipalToken(t *testing.T) {
+	server := newMockServer(t, "example.com")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+	config.ClientID = "client-1"
+	config.ClientSecret = "secret"
+	config.TenantID = "tenant-1"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"sp-token-1"}, server.tokens)
+	assert.Equal(t, []string{"client-1"}, server.tokenQuery)
+
+	provider.config.ClientSecret = "invalid"
+	provider.accessToken = ""
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `azuredns: failed to create TXT record: could not get a service principal token (HTTP 401): {"error":"invalid_client"}`)
+}
+
+func TestDNSProvider_PresentMultiValue(t *testing.T) {
+	server := newMockServer(t, "example.org", "example.com", "sub.example.com")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	// a value of another client is kept.
+	server.recordSets["example.com/_acme-challenge"] = &RecordSet{
+		Etag:       "etag-0",
+		Properties: RecordSetProperties{TTL: 300, TXTRecords: []TxtRecord{{Value: []string{"other"}}}},
+	}
+
+	// the domain and its wildcard, presented with the domain, share the record set.
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	require.NoError(t, provider.Present("example.com", "", "wildcardKeyAuth"))
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	require.NoError(t, provider.Present("www.sub.example.com", "", "keyAuth"))
+
+	assert.Equal(t, []string{"other", "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", "URmNLr6LuNdBWwZKnxnrB_yy4sy8R48PV-dj4ichCLQ"},
+		server.values("example.com/_acme-challenge"))
+	assert.Equal(t, []string{"pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"}, server.values("sub.example.com/_acme-challenge.www"))
+	assert.Equal(t, 60, server.recordSets["example.com/_acme-challenge"].Properties.TTL)
+
+	require.NoError(t, provider.CleanUp("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"other", "URmNLr6LuNdBWwZKnxnrB_yy4sy8R48PV-dj4ichCLQ"}, server.values("example.com/_acme-challenge"))
+
+	require.NoError(t, provider.CleanUp("example.com", "", "wildcardKeyAuth"))
+	assert.Equal(t, []string{"other"}, server.values("example.com/_acme-challenge"))
+
+	// the record set is deleted with its last value.
+	require.NoError(t, provider.CleanUp("www.sub.example.com", "", "keyAuth"))
+	assert.NotContains(t, server.recordSets, "sub.example.com/_acme-challenge.www")
+
+	require.NoError(t, provider.CleanUp("www.sub.example.com", "", "keyAuth"))
+}
+
+func TestDNSProvider_PresentConcurrentModification(t *testing.T) {
+	server := newMockServer(t, "example.com")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	server.conflicts = 2
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"}, server.values("example.com/_acme-challenge"))
+
+	server.conflicts = maxRetries + 1
+	err = provider.Present("example.com", "", "wildcardKeyAuth")
+	assert.EqualError(t, err, "azuredns: failed to create TXT record: API error (HTTP 412): PreconditionFailed: The etag does not match.")
+}
+
+func TestDNSProvider_PresentZoneNotFound(t *testing.T) {
+	server := newMockServer(t, "example.org")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.resource = ts.URL + "/"
+
+	config := NewDefaultConfig()
+	config.SubscriptionID = "sub-1"
+	config.ResourceGroup = "rg-1"
+	config.ActiveDirectoryEndpoint = ts.URL
+	config.MetadataEndpoint = ts.URL + "/"
+	config.ResourceManagerEndpoint = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `azuredns: failed to create TXT record: could not find zone for domain "_acme-challenge.example.com." in the resource group "rg-1"`)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
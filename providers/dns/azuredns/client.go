@@ -0,0 +1,179 @@
+package azuredns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiVersion is the version of the Azure DNS REST API.
+const apiVersion = "2018-05-01"
+
+// Zone represents an Azure DNS zone
+type Zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ZoneListResult represents a page of the zones of a resource group
+type ZoneListResult struct {
+	Value    []Zone `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// RecordSet represents an Azure DNS record set
+type RecordSet struct {
+	Name       string              `json:"name,omitempty"`
+	Etag       string              `json:"etag,omitempty"`
+	Properties RecordSetProperties `json:"properties"`
+}
+
+// RecordSetProperties represents the properties of an Azure DNS record set
+type RecordSetProperties struct {
+	TTL        int         `json:"TTL"`
+	TXTRecords []TxtRecord `json:"TXTRecords"`
+}
+
+// TxtRecord represents a TXT record of a record set, its value is split in strings of at most 255 characters
+type TxtRecord struct {
+	Value []string `json:"value"`
+}
+
+// APIError represents an error of the Azure Resource Manager API
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (a *APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s: %s", a.StatusCode, a.Code, a.Message)
+}
+
+// getZones returns the names of the DNS zones of the resource group.
+func (d *DNSProvider) getZones() ([]string, error) {
+	var names []string
+
+	uri := d.resourceGroupURL() + "/providers/Microsoft.Network/dnsZones?api-version=" + apiVersion
+	for uri != "" {
+		result := &ZoneListResult{}
+		if err := d.doRequest(http.MethodGet, uri, nil, nil, result); err != nil {
+			return nil, err
+		}
+
+		for _, zone := range result.Value {
+			names = append(names, zone.Name)
+		}
+		uri = result.NextLink
+	}
+
+	return names, nil
+}
+
+// getTXTRecordSet returns the TXT record set with the relative name in the zone, or nil if there is none.
+func (d *DNSProvider) getTXTRecordSet(zone, name string) (*RecordSet, error) {
+	recordSet := &RecordSet{}
+	err := d.doRequest(http.MethodGet, d.txtRecordSetURL(zone, name), nil, nil, recordSet)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return recordSet, nil
+}
+
+// putTXTRecordSet creates or replaces the TXT record set. If etag is empty, the record set must not exist,
+// otherwise it must not have been changed since it was read, a *APIError with the status 412 is returned if it was.
+func (d *DNSProvider) putTXTRecordSet(zone, name, etag string, recordSet RecordSet) error {
+	headers := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		headers = map[string]string{"If-Match": etag}
+	}
+
+	return d.doRequest(http.MethodPut, d.txtRecordSetURL(zone, name), headers, recordSet, nil)
+}
+
+// deleteTXTRecordSet deletes the TXT record set if it was not changed since it was read.
+func (d *DNSProvider) deleteTXTRecordSet(zone, name, etag string) error {
+	return d.doRequest(http.MethodDelete, d.txtRecordSetURL(zone, name), map[string]string{"If-Match": etag}, nil, nil)
+}
+
+func (d *DNSProvider) resourceGroupURL() string {
+	return fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s", d.config.ResourceManagerEndpoint,
+		url.PathEscape(d.config.SubscriptionID), url.PathEscape(d.config.ResourceGroup))
+}
+
+func (d *DNSProvider) txtRecordSetURL(zone, name string) string {
+	return fmt.Sprintf("%s/providers/Microsoft.Network/dnsZones/%s/TXT/%s?api-version=%s",
+		d.resourceGroupURL(), url.PathEscape(zone), url.PathEscape(name), apiVersion)
+}
+
+// doRequest sends the request to the Azure Resource Manager API with the given additional headers
+// and decodes the response into result.
+func (d *DNSProvider) doRequest(method, uri string, headers map[string]string, body, result interface{}) error {
+	token, err := d.token()
+	if err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, uri, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := struct {
+			Error *APIError `json:"error"`
+		}{}
+		if json.Unmarshal(raw, &apiErr) != nil || apiErr.Error == nil {
+			apiErr.Error = &APIError{Message: strings.TrimSpace(string(raw))}
+		}
+		apiErr.Error.StatusCode = resp.StatusCode
+		return apiErr.Error
+	}
+
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(raw, result)
+	if err != nil {
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+	return nil
+}
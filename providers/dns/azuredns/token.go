@@ -0,0 +1,125 @@
+package azuredns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenExpiryMargin is the time before its expiration a token is renewed.
+const tokenExpiryMargin = time.Minute
+
+// tokenResponse is the OAuth 2.0 token returned by Azure Active Directory and
+// by the managed identity endpoint. Both return the times as strings.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+	ExpiresOn   string `json:"expires_on"`
+	TokenType   string `json:"token_type"`
+}
+
+// token returns an access token to the Azure Resource Manager API, renewed when it expires.
+func (d *DNSProvider) token() (string, error) {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.accessToken != "" && time.Now().Add(tokenExpiryMargin).Before(d.tokenExpiry) {
+		return d.accessToken, nil
+	}
+
+	var resp *tokenResponse
+	var err error
+	if d.config.ClientSecret != "" {
+		resp, err = d.servicePrincipalToken()
+	} else {
+		resp, err = d.managedIdentityToken()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("no access token in the response")
+	}
+
+	d.accessToken = resp.AccessToken
+	d.tokenExpiry = resp.expiry()
+	return d.accessToken, nil
+}
+
+// servicePrincipalToken requests a token from Azure Active Directory with the client credentials.
+func (d *DNSProvider) servicePrincipalToken() (*tokenResponse, error) {
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", d.config.ClientID)
+	params.Set("client_secret", d.config.ClientSecret)
+	params.Set("resource", d.config.ResourceManagerEndpoint+"/")
+
+	uri := fmt.Sprintf("%s/%s/oauth2/token", d.config.ActiveDirectoryEndpoint, url.PathEscape(d.config.TenantID))
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return d.requestToken(req, "service principal")
+}
+
+// managedIdentityToken requests a token of the managed identity of the host
+// from the Azure Instance Metadata Service (IMDS). The client ID selects a user-assigned identity.
+func (d *DNSProvider) managedIdentityToken() (*tokenResponse, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", d.config.ResourceManagerEndpoint+"/")
+	if d.config.ClientID != "" {
+		query.Set("client_id", d.config.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, d.config.MetadataEndpoint+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return d.requestToken(req, "managed identity")
+}
+
+func (d *DNSProvider) requestToken(req *http.Request, kind string) (*tokenResponse, error) {
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a %s token: %v", kind, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the %s token (HTTP %d): %v", kind, resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get a %s token (HTTP %d): %s", kind, resp.StatusCode, string(raw))
+	}
+
+	token := &tokenResponse{}
+	if err = json.Unmarshal(raw, token); err != nil {
+		return nil, fmt.Errorf("could not decode the %s token: %v: %s", kind, err, string(raw))
+	}
+	return token, nil
+}
+
+// expiry returns the expiration time of the token, expires_on (in seconds since the epoch)
+// takes precedence over expires_in. A token without expiration is only used once.
+func (t *tokenResponse) expiry() time.Time {
+	if expiresOn, err := strconv.ParseInt(t.ExpiresOn, 10, 64); err == nil {
+		return time.Unix(expiresOn, 0)
+	}
+	if expiresIn, err := strconv.ParseInt(t.ExpiresIn, 10, 64); err == nil {
+		return time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return time.Now()
+}
@@ -55,7 +55,7 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("BlueCat: %v", err)
 	}
 
-	httpClient := &http.Client{Timeout: 30 * time.Second}
+	httpClient := acme.NewHTTPClient(30 * time.Second)
 
 	return NewDNSProviderCredentials(
 		values["BLUECAT_SERVER_URL"],
@@ -74,7 +74,7 @@ func NewDNSProviderCredentials(server, userName, password, configName, dnsView s
 		return nil, fmt.Errorf("Bluecat credentials missing")
 	}
 
-	client := http.DefaultClient
+	client := acme.NewHTTPClient(30 * time.Second)
 	if httpClient != nil {
 		client = httpClient
 	}
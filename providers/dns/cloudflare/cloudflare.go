@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/xenolf/lego/acme"
@@ -20,36 +21,88 @@ import (
 // TODO: Unexport?
 const CloudFlareAPIURL = "https://api.cloudflare.com/client/v4"
 
+// Config is used to configure the creation of the DNSProvider.
+// Either AuthToken (a scoped API token) or AuthEmail and AuthKey (the legacy global API key) must be set.
+type Config struct {
+	AuthEmail string
+	AuthKey   string
+
+	// AuthToken is used to manage the DNS records.
+	AuthToken string
+	// ZoneToken is used to list the zones, AuthToken is used if empty.
+	ZoneToken string
+
+	BaseURL string
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL: CloudFlareAPIURL,
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 type DNSProvider struct {
-	authEmail string
-	authKey   string
-	client    *http.Client
+	config *Config
+	client *http.Client
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for cloudflare.
-// Credentials must be passed in the environment variables: CLOUDFLARE_EMAIL
-// and CLOUDFLARE_API_KEY.
+// Credentials must be passed in the environment variables:
+// CLOUDFLARE_DNS_API_TOKEN (and optionally CLOUDFLARE_ZONE_API_TOKEN) to use scoped API tokens,
+// or CLOUDFLARE_EMAIL and CLOUDFLARE_API_KEY (alias CF_API_EMAIL and CF_API_KEY) to use the global API key.
 func NewDNSProvider() (*DNSProvider, error) {
-	values, err := env.Get("CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY")
+	config := NewDefaultConfig()
+
+	if token := env.GetOrFile("CLOUDFLARE_DNS_API_TOKEN"); token != "" {
+		config.AuthToken = token
+		config.ZoneToken = env.GetOrFile("CLOUDFLARE_ZONE_API_TOKEN")
+
+		return NewDNSProviderConfig(config)
+	}
+
+	values, err := env.GetWithFallback(
+		[]string{"CLOUDFLARE_EMAIL", "CF_API_EMAIL"},
+		[]string{"CLOUDFLARE_API_KEY", "CF_API_KEY"},
+	)
 	if err != nil {
 		return nil, fmt.Errorf("CloudFlare: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["CLOUDFLARE_EMAIL"], values["CLOUDFLARE_API_KEY"])
+	config.AuthEmail = values["CLOUDFLARE_EMAIL"]
+	config.AuthKey = values["CLOUDFLARE_API_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for cloudflare.
 func NewDNSProviderCredentials(email, key string) (*DNSProvider, error) {
-	if email == "" || key == "" {
+	config := NewDefaultConfig()
+	config.AuthEmail = email
+	config.AuthKey = key
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for cloudflare.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("CloudFlare: the configuration of the DNS provider is nil")
+	}
+
+	if config.AuthToken == "" && (config.AuthEmail == "" || config.AuthKey == "") {
 		return nil, errors.New("CloudFlare: some credentials information are missing")
 	}
 
+	if config.BaseURL == "" {
+		config.BaseURL = CloudFlareAPIURL
+	}
+
 	return &DNSProvider{
-		authEmail: email,
-		authKey:   key,
-		client:    &http.Client{Timeout: 30 * time.Second},
+		config: config,
+		client: acme.NewHTTPClient(30 * time.Second),
 	}, nil
 }
 
@@ -96,34 +149,72 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	return err
 }
 
-func (d *DNSProvider) getHostedZoneID(fqdn string) (string, error) {
-	// HostedZone represents a CloudFlare DNS zone
-	type HostedZone struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
-	}
+// hostedZone represents a CloudFlare DNS zone
+type hostedZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
 
+// getHostedZoneID looks up the zone of the fqdn by name, preferring an exact match.
+// If the zone is not found by name, the zone with the longest name matching the fqdn is picked
+// among all the zones of the account.
+func (d *DNSProvider) getHostedZoneID(fqdn string) (string, error) {
 	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
 		return "", err
 	}
 
-	result, err := d.doRequest(http.MethodGet, "/zones?name="+acme.UnFqdn(authZone), nil)
+	zones, err := d.listZones("?name=" + acme.UnFqdn(authZone))
 	if err != nil {
 		return "", err
 	}
 
-	var hostedZone []HostedZone
-	err = json.Unmarshal(result, &hostedZone)
+	for _, zone := range zones {
+		if zone.Name == acme.UnFqdn(authZone) {
+			return zone.ID, nil
+		}
+	}
+
+	zones, err = d.listZones("?per_page=50")
 	if err != nil {
 		return "", err
 	}
 
-	if len(hostedZone) != 1 {
+	var best hostedZone
+	for _, zone := range zones {
+		if acme.UnFqdn(fqdn) != zone.Name && !strings.HasSuffix(acme.UnFqdn(fqdn), "."+zone.Name) {
+			continue
+		}
+		if len(zone.Name) > len(best.Name) {
+			best = zone
+		}
+	}
+
+	if best.ID == "" {
 		return "", fmt.Errorf("zone %s not found in CloudFlare for domain %s", authZone, fqdn)
 	}
 
-	return hostedZone[0].ID, nil
+	return best.ID, nil
+}
+
+func (d *DNSProvider) listZones(query string) ([]hostedZone, error) {
+	token := d.config.ZoneToken
+	if token == "" {
+		token = d.config.AuthToken
+	}
+
+	result, err := d.doRequestWithToken(http.MethodGet, "/zones"+query, nil, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []hostedZone
+	err = json.Unmarshal(result, &zones)
+	if err != nil {
+		return nil, err
+	}
+
+	return zones, nil
 }
 
 func (d *DNSProvider) findTxtRecord(fqdn string) (*cloudFlareRecord, error) {
@@ -157,13 +248,22 @@ func (d *DNSProvider) findTxtRecord(fqdn string) (*cloudFlareRecord, error) {
 }
 
 func (d *DNSProvider) doRequest(method, uri string, body io.Reader) (json.RawMessage, error) {
-	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", CloudFlareAPIURL, uri), body)
+	return d.doRequestWithToken(method, uri, body, d.config.AuthToken)
+}
+
+// doRequestWithToken authenticates with the given API token, or with the global API key if the token is empty.
+func (d *DNSProvider) doRequestWithToken(method, uri string, body io.Reader, token string) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", d.config.BaseURL, uri), body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("X-Auth-Email", d.authEmail)
-	req.Header.Set("X-Auth-Key", d.authKey)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set("X-Auth-Email", d.config.AuthEmail)
+		req.Header.Set("X-Auth-Key", d.config.AuthKey)
+	}
 
 	resp, err := d.client.Do(req)
 	if err != nil {
@@ -1,11 +1,16 @@
 package cloudflare
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
 )
 
 var (
@@ -27,6 +32,15 @@ func init() {
 func restoreEnv() {
 	os.Setenv("CLOUDFLARE_EMAIL", cflareEmail)
 	os.Setenv("CLOUDFLARE_API_KEY", cflareAPIKey)
+	os.Unsetenv("CF_API_EMAIL")
+	os.Unsetenv("CF_API_KEY")
+	os.Unsetenv("CLOUDFLARE_DNS_API_TOKEN")
+	os.Unsetenv("CLOUDFLARE_ZONE_API_TOKEN")
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Result: raw})
 }
 
 func TestNewDNSProviderValid(t *testing.T) {
@@ -57,6 +71,32 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	assert.EqualError(t, err, "CloudFlare: some credentials information are missing: CLOUDFLARE_EMAIL,CLOUDFLARE_API_KEY")
 }
 
+func TestNewDNSProviderLegacyAliasEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("CLOUDFLARE_EMAIL", "")
+	os.Setenv("CLOUDFLARE_API_KEY", "")
+	os.Setenv("CF_API_EMAIL", "test@example.com")
+	os.Setenv("CF_API_KEY", "123")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "test@example.com", provider.config.AuthEmail)
+	assert.Equal(t, "123", provider.config.AuthKey)
+}
+
+func TestNewDNSProviderTokenEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("CLOUDFLARE_EMAIL", "")
+	os.Setenv("CLOUDFLARE_API_KEY", "")
+	os.Setenv("CLOUDFLARE_DNS_API_TOKEN", "dns-token")
+	os.Setenv("CLOUDFLARE_ZONE_API_TOKEN", "zone-token")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "dns-token", provider.config.AuthToken)
+	assert.Equal(t, "zone-token", provider.config.ZoneToken)
+}
+
 func TestNewDNSProviderMissingCredErrSingle(t *testing.T) {
 	defer restoreEnv()
 	os.Setenv("CLOUDFLARE_EMAIL", "awesome@possum.com")
@@ -65,6 +105,134 @@ func TestNewDNSProviderMissingCredErrSingle(t *testing.T) {
 	assert.EqualError(t, err, "CloudFlare: some credentials information are missing: CLOUDFLARE_API_KEY")
 }
 
+func TestDNSProvider_PresentTokenAuth(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	var created cloudFlareRecord
+	config := &Config{AuthToken: "dns-token", ZoneToken: "zone-token"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("X-Auth-Email"))
+		assert.Empty(t, r.Header.Get("X-Auth-Key"))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			assert.Equal(t, "Bearer zone-token", r.Header.Get("Authorization"))
+			assert.Equal(t, "example.com", r.URL.Query().Get("name"))
+			writeResult(w, []hostedZone{{ID: "zone-id", Name: "example.com"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/zone-id/dns_records":
+			assert.Equal(t, "Bearer dns-token", r.Header.Get("Authorization"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			writeResult(w, created)
+		default:
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.BaseURL = server.URL
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "123d==")
+	require.NoError(t, err)
+
+	assert.Equal(t, "TXT", created.Type)
+	assert.Equal(t, "_acme-challenge.www.example.com", created.Name)
+}
+
+func TestDNSProvider_CleanUpLegacyAuth(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	var deleted bool
+	config := &Config{AuthEmail: "test@example.com", AuthKey: "key"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Equal(t, "test@example.com", r.Header.Get("X-Auth-Email"))
+		assert.Equal(t, "key", r.Header.Get("X-Auth-Key"))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			writeResult(w, []hostedZone{{ID: "zone-id", Name: "example.com"}})
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone-id/dns_records":
+			writeResult(w, []cloudFlareRecord{{ID: "record-id", ZoneID: "zone-id", Name: "_acme-challenge.www.example.com", Type: "TXT"}})
+		case r.Method == http.MethodDelete && r.URL.Path == "/zones/zone-id/dns_records/record-id":
+			deleted = true
+			writeResult(w, map[string]string{"id": "record-id"})
+		default:
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.BaseURL = server.URL
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "123d==")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestDNSProvider_getHostedZoneID(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		fqdn       string
+		byName     []hostedZone
+		all        []hostedZone
+		expectedID string
+		expectErr  bool
+	}{
+		{
+			desc:       "exact match preferred",
+			fqdn:       "_acme-challenge.www.example.com.",
+			byName:     []hostedZone{{ID: "other", Name: "example.com.cn"}, {ID: "zone-id", Name: "example.com"}},
+			expectedID: "zone-id",
+		},
+		{
+			desc:       "fallback to the longest matching zone of the account",
+			fqdn:       "_acme-challenge.www.sub.example.com.",
+			all:        []hostedZone{{ID: "other", Name: "notexample.com"}, {ID: "apex", Name: "example.com"}, {ID: "sub", Name: "sub.example.com"}},
+			expectedID: "sub",
+		},
+		{
+			desc:      "no matching zone",
+			fqdn:      "_acme-challenge.www.example.com.",
+			all:       []hostedZone{{ID: "other", Name: "example.org"}},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer tester.MockZones(t, "example.com.")()
+
+			config := &Config{AuthToken: "dns-token"}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "Bearer dns-token", r.Header.Get("Authorization"))
+
+				if r.URL.Query().Get("name") != "" {
+					writeResult(w, test.byName)
+					return
+				}
+				writeResult(w, test.all)
+			}))
+			defer server.Close()
+
+			config.BaseURL = server.URL
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			zoneID, err := provider.getHostedZoneID(test.fqdn)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedID, zoneID)
+		})
+	}
+}
+
 func TestCloudFlarePresent(t *testing.T) {
 	if !cflareLiveTest {
 		t.Skip("skipping live test")
@@ -0,0 +1,173 @@
+package cloudns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetries is the number of retries of a rate limited request.
+const maxRetries = 3
+
+// rateLimitDelay is the delay before the first retry of a rate limited request,
+// doubled at each retry if the server does not send a Retry-After header.
+var rateLimitDelay = 2 * time.Second
+
+// Zone represents a ClouDNS zone
+type Zone struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Zone   string `json:"zone"`
+	Status string `json:"status"`
+}
+
+// apiResponse is the status returned by the ClouDNS API for the changes and the errors.
+type apiResponse struct {
+	Status            string `json:"status"`
+	StatusDescription string `json:"statusDescription"`
+	Data              struct {
+		ID int `json:"id"`
+	} `json:"data"`
+}
+
+// apiError is a failure reported by the ClouDNS API.
+type apiError struct {
+	Description string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("API error: %s", e.Description)
+}
+
+// getZone returns the zone with the given name, or nil if the account has no such zone.
+func (d *DNSProvider) getZone(name string) (*Zone, error) {
+	params := url.Values{}
+	params.Set("domain-name", name)
+
+	raw, err := d.doRequest("dns/get-zone-info.json", params)
+	if err != nil {
+		// A missing zone is reported as a failure, unlike the authentication errors.
+		if apiErr, ok := err.(apiError); ok && !strings.Contains(strings.ToLower(apiErr.Description), "auth") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var zone Zone
+	if err = json.Unmarshal(raw, &zone); err != nil {
+		return nil, fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	if zone.Name == "" {
+		return nil, nil
+	}
+	return &zone, nil
+}
+
+// addTXTRecord creates the TXT record in the zone and returns its ID.
+func (d *DNSProvider) addTXTRecord(zone, host, value string, ttl int) (int, error) {
+	params := url.Values{}
+	params.Set("domain-name", zone)
+	params.Set("record-type", "TXT")
+	params.Set("host", host)
+	params.Set("record", value)
+	params.Set("ttl", strconv.Itoa(ttl))
+
+	raw, err := d.doRequest("dns/add-record.json", params)
+	if err != nil {
+		return 0, err
+	}
+
+	var resp apiResponse
+	if err = json.Unmarshal(raw, &resp); err != nil {
+		return 0, fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	if resp.Data.ID == 0 {
+		return 0, fmt.Errorf("no record ID in the API response: %s", string(raw))
+	}
+	return resp.Data.ID, nil
+}
+
+// deleteRecord deletes the record with the given ID from the zone.
+func (d *DNSProvider) deleteRecord(zone string, recordID int) error {
+	params := url.Values{}
+	params.Set("domain-name", zone)
+	params.Set("record-id", strconv.Itoa(recordID))
+
+	_, err := d.doRequest("dns/delete-record.json", params)
+	return err
+}
+
+// doRequest posts the parameters and the credentials to the endpoint of the ClouDNS API
+// and returns the raw response, retrying the rate limited requests.
+// The failures of the changes are returned as errors.
+func (d *DNSProvider) doRequest(endpoint string, params url.Values) ([]byte, error) {
+	for name, value := range d.config.authParams() {
+		params.Set(name, value)
+	}
+
+	delay := rateLimitDelay
+	for retry := 0; ; retry++ {
+		raw, retryAfter, err := d.post(endpoint, params)
+		if err == nil || retryAfter < 0 || retry == maxRetries {
+			return raw, err
+		}
+
+		if retryAfter == 0 {
+			retryAfter = delay
+			delay *= 2
+		}
+		time.Sleep(retryAfter)
+	}
+}
+
+// post sends a single request. The returned duration is non negative if the request was rate limited
+// and can be retried, it is the value of the Retry-After header if any.
+func (d *DNSProvider) post(endpoint string, params url.Values) ([]byte, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, d.config.BaseURL+endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, -1, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, -1, fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, -1, fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, time.Duration(retryAfter) * time.Second, fmt.Errorf("rate limit exceeded (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, -1, fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+
+	var status apiResponse
+	if json.Unmarshal(raw, &status) == nil && status.Status == "Failed" {
+		if isRateLimited(status.StatusDescription) {
+			return nil, 0, fmt.Errorf("rate limit exceeded: %s", status.StatusDescription)
+		}
+		return nil, -1, apiError{Description: status.StatusDescription}
+	}
+
+	return raw, -1, nil
+}
+
+// isRateLimited reports whether the description of a failure is a rate limit.
+func isRateLimited(description string) bool {
+	description = strings.ToLower(description)
+	return strings.Contains(description, "too many requests") || strings.Contains(description, "rate limit")
+}
@@ -0,0 +1,230 @@
+// Package cloudns implements a DNS provider for solving the DNS-01 challenge using ClouDNS.
+// See https://www.cloudns.net/wiki/article/41/
+package cloudns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.cloudns.net/"
+
+// allowedTTLs are the TTLs accepted by ClouDNS for a record, in ascending order.
+var allowedTTLs = []int{60, 300, 900, 1800, 3600, 21600, 43200, 86400, 172800, 259200, 604800, 1209600, 2592000}
+
+// Config is used to configure the creation of the DNSProvider.
+// Exactly one of AuthID, SubAuthID and SubAuthUser identifies the user,
+// in this order of precedence if several are set.
+type Config struct {
+	AuthID string
+	// SubAuthID and SubAuthUser identify a sub-user of the API, by ID or by name.
+	SubAuthID          string
+	SubAuthUser        string
+	AuthPassword       string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("CLOUDNS_PROPAGATION_TIMEOUT", 180*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("CLOUDNS_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("CLOUDNS_TTL", 60),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("CLOUDNS_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// authParams returns the authentication parameters of the API requests.
+func (c *Config) authParams() map[string]string {
+	params := map[string]string{"auth-password": c.AuthPassword}
+
+	switch {
+	case c.AuthID != "":
+		params["auth-id"] = c.AuthID
+	case c.SubAuthID != "":
+		params["sub-auth-id"] = c.SubAuthID
+	default:
+		params["sub-auth-user"] = c.SubAuthUser
+	}
+
+	return params
+}
+
+type record struct {
+	zone string
+	id   int
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the ClouDNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// records are the zones and IDs of the created records, by fqdn and value.
+	records   map[string]record
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for ClouDNS.
+// Credentials must be passed in the environment variables CLOUDNS_AUTH_ID and CLOUDNS_AUTH_PASSWORD,
+// a sub-user is identified by CLOUDNS_SUB_AUTH_ID or CLOUDNS_SUB_AUTH_USER instead of CLOUDNS_AUTH_ID.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("CLOUDNS_AUTH_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("cloudns: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.AuthID = os.Getenv("CLOUDNS_AUTH_ID")
+	config.SubAuthID = os.Getenv("CLOUDNS_SUB_AUTH_ID")
+	config.SubAuthUser = os.Getenv("CLOUDNS_SUB_AUTH_USER")
+	config.AuthPassword = values["CLOUDNS_AUTH_PASSWORD"]
+
+	if config.AuthID == "" && config.SubAuthID == "" && config.SubAuthUser == "" {
+		return nil, errors.New("cloudns: some credentials information are missing: CLOUDNS_AUTH_ID, CLOUDNS_SUB_AUTH_ID or CLOUDNS_SUB_AUTH_USER")
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials of a main user
+// to return a DNSProvider instance configured for ClouDNS.
+func NewDNSProviderCredentials(authID, authPassword string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.AuthID = authID
+	config.AuthPassword = authPassword
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for ClouDNS.
+// The TTL is rounded up to the next TTL accepted by ClouDNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("cloudns: the configuration of the DNS provider is nil")
+	}
+
+	if (config.AuthID == "" && config.SubAuthID == "" && config.SubAuthUser == "") || config.AuthPassword == "" {
+		return nil, errors.New("cloudns: credentials missing")
+	}
+
+	if config.TTL > allowedTTLs[len(allowedTTLs)-1] {
+		return nil, fmt.Errorf("cloudns: invalid TTL, TTL (%d) must be lower than or equal to %d", config.TTL, allowedTTLs[len(allowedTTLs)-1])
+	}
+	config.TTL = roundTTL(config.TTL)
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	} else if !strings.HasSuffix(config.BaseURL, "/") {
+		config.BaseURL += "/"
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config, records: make(map[string]record)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, err := d.findZone(fqdn)
+	if err != nil {
+		return fmt.Errorf("cloudns: %v", err)
+	}
+
+	id, err := d.addTXTRecord(zone, extractRecordName(fqdn, zone), value, d.config.TTL)
+	if err != nil {
+		return fmt.Errorf("cloudns: failed to create TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = record{zone: zone, id: id}
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present, by its ID.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordsMu.Lock()
+	rec, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("cloudns: unknown record ID for %q", fqdn)
+	}
+
+	err := d.deleteRecord(rec.zone, rec.id)
+	if err != nil {
+		return fmt.Errorf("cloudns: failed to delete TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// findZone walks up the labels of the fqdn and returns the longest zone of the account.
+func (d *DNSProvider) findZone(fqdn string) (string, error) {
+	name := acme.UnFqdn(fqdn)
+
+	for _, index := range dns.Split(name) {
+		candidate := name[index:]
+
+		zone, err := d.getZone(candidate)
+		if err != nil {
+			return "", err
+		}
+
+		if zone != nil {
+			return zone.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find zone for domain %q", fqdn)
+}
+
+// roundTTL returns the lowest TTL accepted by ClouDNS greater than or equal to ttl.
+func roundTTL(ttl int) int {
+	for _, allowed := range allowedTTLs {
+		if ttl <= allowed {
+			return allowed
+		}
+	}
+	return allowedTTLs[len(allowedTTLs)-1]
+}
+
+func extractRecordName(fqdn, zone string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(name, "."+zone); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,376 @@
+package cloudns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+)
+
+var (
+	liveTest            bool
+	envTestAuthID       string
+	envTestSubAuthID    string
+	envTestSubAuthUser  string
+	envTestAuthPassword string
+	envTestDomain       string
+)
+
+func init() {
+	envTestAuthID = os.Getenv("CLOUDNS_AUTH_ID")
+	envTestSubAuthID = os.Getenv("CLOUDNS_SUB_AUTH_ID")
+	envTestSubAuthUser = os.Getenv("CLOUDNS_SUB_AUTH_USER")
+	envTestAuthPassword = os.Getenv("CLOUDNS_AUTH_PASSWORD")
+	envTestDomain = os.Getenv("CLOUDNS_DOMAIN")
+	liveTest = (len(envTestAuthID) > 0 || len(envTestSubAuthID) > 0 || len(envTestSubAuthUser) > 0) &&
+		len(envTestAuthPassword) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("CLOUDNS_AUTH_ID", envTestAuthID)
+	os.Setenv("CLOUDNS_SUB_AUTH_ID", envTestSubAuthID)
+	os.Setenv("CLOUDNS_SUB_AUTH_USER", envTestSubAuthUser)
+	os.Setenv("CLOUDNS_AUTH_PASSWORD", envTestAuthPassword)
+	os.Unsetenv("CLOUDNS_TTL")
+}
+
+// mockServer is a minimal in-memory implementation of the ClouDNS API.
+type mockServer struct {
+	t  *testing.T
+	mu sync.Mutex
+	// auth are the expected authentication parameters.
+	auth    url.Values
+	zones   []string
+	records map[int]url.Values
+	nextID  int
+	// rateLimited is the number of requests to reject with a rate limit error.
+	rateLimited int
+	requests    []string
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Equal(m.t, http.MethodPost, r.Method)
+	require.NoError(m.t, r.ParseForm())
+	m.requests = append(m.requests, r.URL.Path)
+
+	if m.rateLimited > 0 {
+		m.rateLimited--
+		writeResponse(w, "Failed", "Too many requests. Please try again later.", nil)
+		return
+	}
+
+	for _, name := range []string{"auth-id", "sub-auth-id", "sub-auth-user", "auth-password"} {
+		if r.PostForm.Get(name) != m.auth.Get(name) {
+			writeResponse(w, "Failed", "Invalid authentication, incorrect auth-id or auth-password.", nil)
+			return
+		}
+	}
+
+	zone := r.PostForm.Get("domain-name")
+
+	switch r.URL.Path {
+	case "/dns/get-zone-info.json":
+		for _, z := range m.zones {
+			if z == zone {
+				json.NewEncoder(w).Encode(Zone{Name: zone, Type: "master", Zone: "domain", Status: "1"})
+				return
+			}
+		}
+		writeResponse(w, "Failed", "Missing domain-name", nil)
+
+	case "/dns/add-record.json":
+		assert.Equal(m.t, "TXT", r.PostForm.Get("record-type"))
+		m.nextID++
+		m.records[m.nextID] = r.PostForm
+		writeResponse(w, "Success", "The record was added successfully.", map[string]int{"id": m.nextID})
+
+	case "/dns/delete-record.json":
+		id, _ := strconv.Atoi(r.PostForm.Get("record-id"))
+		if rec, ok := m.records[id]; !ok || rec.Get("domain-name") != zone {
+			writeResponse(w, "Failed", "Invalid record-id param.", nil)
+			return
+		}
+		delete(m.records, id)
+		writeResponse(w, "Success", "The record was deleted successfully.", nil)
+
+	default:
+		m.t.Errorf("unexpected request %s", r.URL.Path)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, status, description string, data interface{}) {
+	response := map[string]interface{}{"status": status, "statusDescription": description}
+	if data != nil {
+		response["data"] = data
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("CLOUDNS_AUTH_ID", "")
+	os.Setenv("CLOUDNS_SUB_AUTH_ID", "")
+	os.Setenv("CLOUDNS_SUB_AUTH_USER", "")
+	os.Setenv("CLOUDNS_AUTH_PASSWORD", "secret")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "cloudns: some credentials information are missing: CLOUDNS_AUTH_ID, CLOUDNS_SUB_AUTH_ID or CLOUDNS_SUB_AUTH_USER")
+
+	os.Setenv("CLOUDNS_AUTH_ID", "123")
+	os.Setenv("CLOUDNS_AUTH_PASSWORD", "")
+
+	_, err = NewDNSProvider()
+	assert.EqualError(t, err, "cloudns: some credentials information are missing: CLOUDNS_AUTH_PASSWORD")
+}
+
+func TestNewDNSProviderAuthVariants(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		authID      string
+		subAuthID   string
+		subAuthUser string
+		expected    map[string]string
+	}{
+		{
+			desc:     "main user",
+			authID:   "123",
+			expected: map[string]string{"auth-id": "123", "auth-password": "secret"},
+		},
+		{
+			desc:      "sub-user by ID",
+			subAuthID: "456",
+			expected:  map[string]string{"sub-auth-id": "456", "auth-password": "secret"},
+		},
+		{
+			desc:        "sub-user by name",
+			subAuthUser: "lego",
+			expected:    map[string]string{"sub-auth-user": "lego", "auth-password": "secret"},
+		},
+		{
+			desc:        "main user first",
+			authID:      "123",
+			subAuthID:   "456",
+			subAuthUser: "lego",
+			expected:    map[string]string{"auth-id": "123", "auth-password": "secret"},
+		},
+		{
+			desc:        "sub-user ID before name",
+			subAuthID:   "456",
+			subAuthUser: "lego",
+			expected:    map[string]string{"sub-auth-id": "456", "auth-password": "secret"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer restoreEnv()
+			os.Setenv("CLOUDNS_AUTH_ID", test.authID)
+			os.Setenv("CLOUDNS_SUB_AUTH_ID", test.subAuthID)
+			os.Setenv("CLOUDNS_SUB_AUTH_USER", test.subAuthUser)
+			os.Setenv("CLOUDNS_AUTH_PASSWORD", "secret")
+
+			provider, err := NewDNSProvider()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expected, provider.config.authParams())
+		})
+	}
+}
+
+func TestNewDNSProviderConfigTTL(t *testing.T) {
+	testCases := []struct {
+		ttl      int
+		expected int
+	}{
+		{ttl: 0, expected: 60},
+		{ttl: 60, expected: 60},
+		{ttl: 120, expected: 300},
+		{ttl: 3600, expected: 3600},
+		{ttl: 2592000, expected: 2592000},
+	}
+
+	for _, test := range testCases {
+		config := NewDefaultConfig()
+		config.AuthID = "123"
+		config.AuthPassword = "secret"
+		config.TTL = test.ttl
+
+		provider, err := NewDNSProviderConfig(config)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, provider.config.TTL, "TTL %d", test.ttl)
+	}
+
+	config := NewDefaultConfig()
+	config.AuthID = "123"
+	config.AuthPassword = "secret"
+	config.TTL = 2592001
+
+	_, err := NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "cloudns: invalid TTL, TTL (2592001) must be lower than or equal to 2592000")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		auth:    url.Values{"sub-auth-user": {"lego"}, "auth-password": {"secret"}},
+		zones:   []string{"example.com"},
+		records: map[int]url.Values{},
+		nextID:  40,
+	}
+
+	config := NewDefaultConfig()
+	config.SubAuthUser = "lego"
+	config.AuthPassword = "secret"
+	config.TTL = 120
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("www.example.com", "keyAuth")
+	_, otherValue, _ := acme.DNS01Record("example.com", "otherKeyAuth")
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	err = provider.Present("example.com", "", "otherKeyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records, 2)
+	assert.Equal(t, "example.com", server.records[41].Get("domain-name"))
+	assert.Equal(t, "_acme-challenge.www", server.records[41].Get("host"))
+	assert.Equal(t, value, server.records[41].Get("record"))
+	assert.Equal(t, "300", server.records[41].Get("ttl"))
+	assert.Equal(t, "_acme-challenge", server.records[42].Get("host"))
+	assert.Equal(t, otherValue, server.records[42].Get("record"))
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// Only the record of the challenge is deleted, by its ID.
+	assert.Len(t, server.records, 1)
+	assert.Contains(t, server.records, 42)
+
+	assert.Equal(t, []string{
+		"/dns/get-zone-info.json", "/dns/get-zone-info.json", "/dns/get-zone-info.json", "/dns/add-record.json",
+		"/dns/get-zone-info.json", "/dns/get-zone-info.json", "/dns/add-record.json",
+		"/dns/delete-record.json",
+	}, server.requests)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, `cloudns: unknown record ID for "_acme-challenge.www.example.com."`)
+}
+
+func TestDNSProvider_PresentRateLimited(t *testing.T) {
+	defer func(delay time.Duration) { rateLimitDelay = delay }(rateLimitDelay)
+	rateLimitDelay = time.Millisecond
+
+	server := &mockServer{
+		t:           t,
+		auth:        url.Values{"auth-id": {"123"}, "auth-password": {"secret"}},
+		zones:       []string{"example.com"},
+		records:     map[int]url.Values{},
+		rateLimited: 2,
+	}
+
+	config := NewDefaultConfig()
+	config.AuthID = "123"
+	config.AuthPassword = "secret"
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+	assert.Len(t, server.records, 1)
+
+	server.rateLimited = maxRetries + 1
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "cloudns: failed to delete TXT record: rate limit exceeded: Too many requests. Please try again later.")
+}
+
+func TestDNSProvider_PresentAuthError(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		auth:    url.Values{"auth-id": {"123"}, "auth-password": {"secret"}},
+		zones:   []string{"example.com"},
+		records: map[int]url.Values{},
+	}
+
+	config := NewDefaultConfig()
+	config.AuthID = "123"
+	config.AuthPassword = "wrong"
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "cloudns: API error: Invalid authentication, incorrect auth-id or auth-password.")
+}
+
+func TestDNSProvider_PresentUnknownZone(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		auth:    url.Values{"auth-id": {"123"}, "auth-password": {"secret"}},
+		zones:   []string{"example.com"},
+		records: map[int]url.Values{},
+	}
+
+	config := NewDefaultConfig()
+	config.AuthID = "123"
+	config.AuthPassword = "secret"
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.org", "", "keyAuth")
+	assert.EqualError(t, err, `cloudns: could not find zone for domain "_acme-challenge.example.org."`)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
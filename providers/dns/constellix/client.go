@@ -0,0 +1,155 @@
+package constellix
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// securityTokenHeader is the header authenticating the requests.
+const securityTokenHeader = "x-cns-security-token"
+
+// Domain is a domain of the account.
+type Domain struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Pending is true while the last changes of the records are being committed.
+	Pending bool `json:"pending"`
+}
+
+// RecordValue is a value of a record.
+type RecordValue struct {
+	Value       string `json:"value"`
+	DisableFlag bool   `json:"disableFlag"`
+}
+
+// Record is a TXT record.
+type Record struct {
+	ID         int64         `json:"id,omitempty"`
+	Name       string        `json:"name"`
+	TTL        int           `json:"ttl"`
+	RoundRobin []RecordValue `json:"roundRobin"`
+}
+
+// APIError is an error returned by the Constellix API.
+type APIError struct {
+	StatusCode int      `json:"-"`
+	Errors     []string `json:"errors"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// securityToken returns the value of the security token header at the time:
+// the API key, the base64 HMAC-SHA1 of the time in milliseconds keyed with the secret key, and that time.
+func securityToken(apiKey, secretKey string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	mac.Write([]byte(timestamp))
+	hmacValue := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return apiKey + ":" + hmacValue + ":" + timestamp
+}
+
+// getDomain returns the domain of the name.
+func (d *DNSProvider) getDomain(name string) (*Domain, error) {
+	var domains []Domain
+	err := d.doRequest(http.MethodGet, "/domains/search?exact="+url.QueryEscape(name), nil, &domains)
+	if apiErr, ok := err.(APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("domain %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, domain := range domains {
+		if domain.Name == name {
+			return &domain, nil
+		}
+	}
+	return nil, fmt.Errorf("domain %s not found", name)
+}
+
+// getDomainByID returns the domain with its pending state.
+func (d *DNSProvider) getDomainByID(domainID int64) (*Domain, error) {
+	domain := &Domain{}
+	if err := d.doRequest(http.MethodGet, fmt.Sprintf("/domains/%d", domainID), nil, domain); err != nil {
+		return nil, err
+	}
+	return domain, nil
+}
+
+// createRecord creates the TXT record in the domain and returns its ID.
+func (d *DNSProvider) createRecord(domainID int64, record Record) (int64, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+
+	var created []Record
+	err = d.doRequest(http.MethodPost, fmt.Sprintf("/domains/%d/records/txt", domainID), bytes.NewReader(body), &created)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(created) == 0 || created[0].ID == 0 {
+		return 0, errors.New("no record ID in the response")
+	}
+	return created[0].ID, nil
+}
+
+// deleteRecord deletes the TXT record of the domain.
+func (d *DNSProvider) deleteRecord(domainID, recordID int64) error {
+	return d.doRequest(http.MethodDelete, fmt.Sprintf("/domains/%d/records/txt/%d", domainID, recordID), nil, nil)
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(securityTokenHeader, securityToken(d.config.APIKey, d.config.SecretKey, time.Now()))
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := APIError{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(raw, &apiErr); err != nil || len(apiErr.Errors) == 0 {
+			apiErr.Errors = []string{strings.TrimSpace(string(raw))}
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package constellix implements a DNS provider for solving the DNS-01 challenge using Constellix DNS.
+// See https://api-docs.constellix.com/
+package constellix
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.dns.constellix.com/v1"
+
+// commitTimeout and commitInterval bound the wait for the changes of a domain to be committed.
+// They are overridden during tests.
+var (
+	commitTimeout  = 2 * time.Minute
+	commitInterval = 2 * time.Second
+)
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey             string
+	SecretKey          string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("CONSTELLIX_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("CONSTELLIX_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("CONSTELLIX_TTL", 60),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("CONSTELLIX_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// createdRecord is a record created by Present, with its domain.
+type createdRecord struct {
+	domainID int64
+	recordID int64
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Constellix API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// records are the created records, by fqdn and value.
+	records   map[string]createdRecord
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Constellix.
+// Credentials must be passed in the environment variables:
+// CONSTELLIX_API_KEY and CONSTELLIX_SECRET_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("CONSTELLIX_API_KEY", "CONSTELLIX_SECRET_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("constellix: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.APIKey = values["CONSTELLIX_API_KEY"]
+	config.SecretKey = values["CONSTELLIX_SECRET_KEY"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Constellix.
+func NewDNSProviderCredentials(apiKey, secretKey string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.APIKey = apiKey
+	config.SecretKey = secretKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Constellix.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("constellix: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" || config.SecretKey == "" {
+		return nil, errors.New("constellix: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{
+		config:  config,
+		records: make(map[string]createdRecord),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return fmt.Errorf("constellix: %v", err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+
+	dom, err := d.getDomain(zone)
+	if err != nil {
+		return fmt.Errorf("constellix: %v", err)
+	}
+
+	record := Record{
+		Name:       extractRecordName(fqdn, zone),
+		TTL:        d.config.TTL,
+		RoundRobin: []RecordValue{{Value: fmt.Sprintf("%q", value)}},
+	}
+
+	recordID, err := d.createRecord(dom.ID, record)
+	if err != nil {
+		return fmt.Errorf("constellix: failed to create the TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = createdRecord{domainID: dom.ID, recordID: recordID}
+	d.recordsMu.Unlock()
+
+	if err = d.waitForCommit(dom.ID); err != nil {
+		return fmt.Errorf("constellix: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordsMu.Lock()
+	created, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("constellix: unknown record ID for %q", fqdn)
+	}
+
+	if err := d.deleteRecord(created.domainID, created.recordID); err != nil {
+		return fmt.Errorf("constellix: failed to delete the TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// waitForCommit waits for the changes of the domain to be committed:
+// Constellix applies them asynchronously, the domain is pending meanwhile.
+func (d *DNSProvider) waitForCommit(domainID int64) error {
+	return acme.WaitFor(commitTimeout, commitInterval, func() (bool, error) {
+		dom, err := d.getDomainByID(domainID)
+		if err != nil {
+			return false, fmt.Errorf("could not get the state of the domain: %v", err)
+		}
+		return !dom.Pending, nil
+	})
+}
+
+// extractRecordName returns the name of the record of the fqdn relative to the domain, empty for the domain itself.
+func extractRecordName(fqdn, domain string) string {
+	name := acme.UnFqdn(fqdn)
+	if name == domain {
+		return ""
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
@@ -0,0 +1,248 @@
+package constellix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest         bool
+	envTestAPIKey    string
+	envTestSecretKey string
+	envTestDomain    string
+)
+
+func init() {
+	envTestAPIKey = os.Getenv("CONSTELLIX_API_KEY")
+	envTestSecretKey = os.Getenv("CONSTELLIX_SECRET_KEY")
+	envTestDomain = os.Getenv("CONSTELLIX_DOMAIN")
+	liveTest = len(envTestAPIKey) > 0 && len(envTestSecretKey) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("CONSTELLIX_API_KEY", envTestAPIKey)
+	os.Setenv("CONSTELLIX_SECRET_KEY", envTestSecretKey)
+}
+
+// mockServer is a Constellix API with the domain example.com, committing the changes after a poll.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	records  map[int64]Record
+	nextID   int64
+	pending  bool
+	polls    int
+	requests []string
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+
+	token := strings.Split(r.Header.Get(securityTokenHeader), ":")
+	if len(token) != 3 || token[0] != "key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(APIError{Errors: []string{"Invalid security token"}})
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /domains/search":
+		if r.URL.Query().Get("exact") != "example.com" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIError{Errors: []string{"Domain not found"}})
+			return
+		}
+		json.NewEncoder(w).Encode([]Domain{{ID: 42, Name: "example.com"}})
+	case "GET /domains/42":
+		s.polls++
+		domain := Domain{ID: 42, Name: "example.com", Pending: s.pending}
+		s.pending = false
+		json.NewEncoder(w).Encode(domain)
+	case "POST /domains/42/records/txt":
+		var record Record
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&record))
+		s.nextID++
+		record.ID = s.nextID
+		s.records[record.ID] = record
+		s.pending = true
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode([]Record{record})
+	case "DELETE /domains/42/records/txt/1":
+		delete(s.records, 1)
+		json.NewEncoder(w).Encode(map[string]string{"success": "Record deleted"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIError{Errors: []string{"Not found"}})
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("CONSTELLIX_API_KEY", "key")
+	os.Setenv("CONSTELLIX_SECRET_KEY", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("CONSTELLIX_API_KEY", "")
+	os.Setenv("CONSTELLIX_SECRET_KEY", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "constellix: some credentials information are missing: CONSTELLIX_API_KEY,CONSTELLIX_SECRET_KEY")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "constellix: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderCredentials("key", "")
+	assert.EqualError(t, err, "constellix: credentials missing")
+}
+
+func TestSecurityToken(t *testing.T) {
+	now := time.Unix(1546300800, 123*int64(time.Millisecond))
+
+	token := securityToken("key", "secret", now)
+	assert.Equal(t, "key:Ci6w2/yrLgerceJx1tyO2RZ/m0o=:1546300800123", token)
+}
+
+func TestExtractRecordName(t *testing.T) {
+	assert.Equal(t, "_acme-challenge", extractRecordName("_acme-challenge.example.com.", "example.com"))
+	assert.Equal(t, "_acme-challenge.www", extractRecordName("_acme-challenge.www.example.com.", "example.com"))
+	assert.Equal(t, "", extractRecordName("example.com.", "example.com"))
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	defer func(interval time.Duration) { commitInterval = interval }(commitInterval)
+	commitInterval = 10 * time.Millisecond
+
+	server := &mockServer{t: t, records: make(map[int64]Record)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[int64]Record{1: {
+		ID:         1,
+		Name:       "_acme-challenge.www",
+		TTL:        60,
+		RoundRobin: []RecordValue{{Value: `"pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"`}},
+	}}, server.records)
+	// the commit of the record is awaited.
+	assert.Equal(t, 2, server.polls)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records)
+	assert.Equal(t, []string{
+		"GET /domains/search?exact=example.com",
+		"POST /domains/42/records/txt",
+		"GET /domains/42",
+		"GET /domains/42",
+		"DELETE /domains/42/records/txt/1",
+	}, server.requests)
+}
+
+func TestDNSProvider_PresentUnknownDomain(t *testing.T) {
+	defer tester.MockZones(t, "example.org.")()
+
+	server := &mockServer{t: t, records: make(map[int64]Record)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.org", "", "keyAuth")
+	assert.EqualError(t, err, "constellix: domain example.org not found")
+	assert.Empty(t, server.records)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t, records: make(map[int64]Record)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, `constellix: unknown record ID for "_acme-challenge.www.example.com."`)
+	assert.Empty(t, server.requests)
+}
+
+func TestDNSProvider_InvalidCredentials(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	ts := httptest.NewServer(&mockServer{t: t, records: make(map[int64]Record)})
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "invalid"
+	config.SecretKey = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, "constellix: API error (HTTP 401): Invalid security token")
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
@@ -0,0 +1,158 @@
+package desec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/xenolf/lego/log"
+)
+
+// maxRetries is the number of times a rate limited request is retried.
+const maxRetries = 5
+
+// defaultRetryAfter is used when a rate limited response has no usable Retry-After header.
+var defaultRetryAfter = 1 * time.Second
+
+// Domain represents a deSEC domain
+type Domain struct {
+	Name string `json:"name"`
+}
+
+// RRSet represents a deSEC RRset (all records of a given name and type)
+type RRSet struct {
+	SubName string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl,omitempty"`
+	Records []string `json:"records"`
+}
+
+// getDomainName returns the name of the deSEC domain responsible for the given fqdn.
+func (d *DNSProvider) getDomainName(fqdn string) (string, error) {
+	query := url.Values{}
+	query.Set("owns_qname", fqdn)
+
+	var domains []Domain
+	found, err := d.doRequest(http.MethodGet, "/domains/?"+query.Encode(), nil, &domains)
+	if err != nil {
+		return "", err
+	}
+
+	if !found || len(domains) == 0 {
+		return "", fmt.Errorf("no domain found for %s", fqdn)
+	}
+
+	return domains[0].Name, nil
+}
+
+// getTxtRRSet returns the TXT RRset for the given subname, or nil if it does not exist.
+func (d *DNSProvider) getTxtRRSet(domainName, subName string) (*RRSet, error) {
+	rrSet := &RRSet{}
+	found, err := d.doRequest(http.MethodGet, rrSetPath(domainName, subName), nil, rrSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return rrSet, nil
+}
+
+// createRRSet creates a new RRset.
+func (d *DNSProvider) createRRSet(domainName string, rrSet RRSet) error {
+	_, err := d.doRequest(http.MethodPost, fmt.Sprintf("/domains/%s/rrsets/", domainName), rrSet, nil)
+	return err
+}
+
+// updateRRSetRecords replaces the records of an existing RRset,
+// an empty list of records deletes the RRset.
+func (d *DNSProvider) updateRRSetRecords(domainName string, rrSet RRSet) error {
+	body := map[string][]string{"records": rrSet.Records}
+	_, err := d.doRequest(http.MethodPatch, rrSetPath(domainName, rrSet.SubName), body, nil)
+	return err
+}
+
+func rrSetPath(domainName, subName string) string {
+	return fmt.Sprintf("/domains/%s/rrsets/%s/TXT/", domainName, subName)
+}
+
+// doRequest sends the request to the deSEC API and decodes the response into result.
+// It reports false if the requested resource does not exist.
+// Rate limited requests are retried after the delay sent by the API.
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) (bool, error) {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if content != nil {
+			reqBody = bytes.NewReader(content)
+		}
+
+		req, err := http.NewRequest(method, d.config.BaseURL+uri, reqBody)
+		if err != nil {
+			return false, err
+		}
+
+		req.Header.Set("Authorization", "Token "+d.config.Token)
+		req.Header.Set("Accept", "application/json")
+		if content != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("error querying API: %v", err)
+		}
+
+		raw, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests && attempt < maxRetries:
+			delay := retryAfter(resp.Header.Get("Retry-After"))
+			log.Infof("deSEC: rate limited, retrying in %v", delay)
+			time.Sleep(delay)
+			continue
+		case resp.StatusCode == http.StatusNotFound:
+			return false, nil
+		case resp.StatusCode >= http.StatusBadRequest:
+			return false, fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+
+		if result == nil || len(raw) == 0 {
+			return true, nil
+		}
+
+		err = json.Unmarshal(raw, result)
+		if err != nil {
+			return false, fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+		}
+		return true, nil
+	}
+}
+
+// retryAfter parses the Retry-After header value in seconds.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
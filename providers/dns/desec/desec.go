@@ -0,0 +1,192 @@
+// Package desec implements a DNS provider for solving the DNS-01 challenge using deSEC DNS.
+// See https://desec.readthedocs.io/en/latest/
+package desec
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://desec.io/api/v1"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Token              string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("DESEC_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("DESEC_POLLING_INTERVAL", 4*time.Second),
+		TTL:                env.GetOrDefaultInt("DESEC_TTL", 3600),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses deSEC's REST API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	client *http.Client
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for deSEC.
+// Credentials must be passed in the environment variable DESEC_TOKEN.
+// The propagation timeout, polling interval and record TTL (in seconds) can be set
+// with DESEC_PROPAGATION_TIMEOUT, DESEC_POLLING_INTERVAL and DESEC_TTL.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("DESEC_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("deSEC: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Token = values["DESEC_TOKEN"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for deSEC.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.Token = token
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for deSEC.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("deSEC: the configuration of the DNS provider is nil")
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("deSEC: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	return &DNSProvider{
+		config: config,
+		client: acme.NewHTTPClient(30 * time.Second),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+// deSEC replaces a whole RRset at once, so the value is merged
+// into the already existing TXT records of the name.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, err := d.getDomainName(acme.UnFqdn(fqdn))
+	if err != nil {
+		return fmt.Errorf("deSEC: %v", err)
+	}
+
+	subName := extractSubName(fqdn, domainName)
+	record := `"` + value + `"`
+
+	rrSet, err := d.getTxtRRSet(domainName, subName)
+	if err != nil {
+		return fmt.Errorf("deSEC: %v", err)
+	}
+
+	if rrSet == nil {
+		err = d.createRRSet(domainName, RRSet{
+			SubName: subName,
+			Type:    "TXT",
+			TTL:     d.config.TTL,
+			Records: []string{record},
+		})
+		if err != nil {
+			return fmt.Errorf("deSEC: failed to create TXT records: %v", err)
+		}
+		return nil
+	}
+
+	for _, existing := range rrSet.Records {
+		if existing == record {
+			return nil
+		}
+	}
+
+	rrSet.Records = append(rrSet.Records, record)
+	err = d.updateRRSetRecords(domainName, *rrSet)
+	if err != nil {
+		return fmt.Errorf("deSEC: failed to update TXT records: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters,
+// other values of the RRset are kept.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, err := d.getDomainName(acme.UnFqdn(fqdn))
+	if err != nil {
+		return fmt.Errorf("deSEC: %v", err)
+	}
+
+	subName := extractSubName(fqdn, domainName)
+	record := `"` + value + `"`
+
+	rrSet, err := d.getTxtRRSet(domainName, subName)
+	if err != nil {
+		return fmt.Errorf("deSEC: %v", err)
+	}
+	if rrSet == nil {
+		return nil
+	}
+
+	var records []string
+	for _, existing := range rrSet.Records {
+		if existing != record {
+			records = append(records, existing)
+		}
+	}
+
+	if len(records) == len(rrSet.Records) {
+		return nil
+	}
+
+	rrSet.Records = records
+	if rrSet.Records == nil {
+		rrSet.Records = []string{}
+	}
+
+	err = d.updateRRSetRecords(domainName, *rrSet)
+	if err != nil {
+		return fmt.Errorf("deSEC: failed to update TXT records: %v", err)
+	}
+	return nil
+}
+
+func extractSubName(fqdn, domainName string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(name, "."+domainName); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,202 @@
+package desec
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest      bool
+	envTestToken  string
+	envTestDomain string
+)
+
+func init() {
+	envTestToken = os.Getenv("DESEC_TOKEN")
+	envTestDomain = os.Getenv("DESEC_DOMAIN")
+	liveTest = len(envTestToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("DESEC_TOKEN", envTestToken)
+	os.Unsetenv("DESEC_TTL")
+}
+
+// mockServer is a minimal in-memory implementation of the deSEC RRset API.
+type mockServer struct {
+	t      *testing.T
+	rrSets map[string]*RRSet
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(m.t, "Token secret", r.Header.Get("Authorization"))
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/domains/":
+		assert.Equal(m.t, "_acme-challenge.www.example.com", r.URL.Query().Get("owns_qname"))
+		json.NewEncoder(w).Encode([]Domain{{Name: "example.com"}})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/domains/example.com/rrsets/":
+		rrSet := &RRSet{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(rrSet))
+		m.rrSets[rrSet.SubName] = rrSet
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rrSet)
+
+	case r.URL.Path == "/domains/example.com/rrsets/_acme-challenge.www/TXT/":
+		rrSet, ok := m.rrSets["_acme-challenge.www"]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			require.NoError(m.t, json.NewDecoder(r.Body).Decode(rrSet))
+			if len(rrSet.Records) == 0 {
+				delete(m.rrSets, "_acme-challenge.www")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(rrSet)
+
+	default:
+		m.t.Fatalf("unexpected request %s %s", r.Method, r.URL)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DESEC_TOKEN", "123")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DESEC_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "deSEC: some credentials information are missing: DESEC_TOKEN")
+}
+
+func TestNewDNSProviderTTL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DESEC_TOKEN", "123")
+	os.Setenv("DESEC_TTL", "300")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, 300, provider.config.TTL)
+}
+
+func TestDNSProvider_PresentMergesRRSet(t *testing.T) {
+	server := &mockServer{t: t, rrSets: map[string]*RRSet{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth1")
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth2")
+	require.NoError(t, err)
+
+	require.Contains(t, server.rrSets, "_acme-challenge.www")
+	rrSet := server.rrSets["_acme-challenge.www"]
+	assert.Len(t, rrSet.Records, 2)
+	assert.Equal(t, 3600, rrSet.TTL)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth1")
+	require.NoError(t, err)
+	assert.Len(t, server.rrSets["_acme-challenge.www"].Records, 1)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth2")
+	require.NoError(t, err)
+	assert.NotContains(t, server.rrSets, "_acme-challenge.www")
+}
+
+func TestDNSProvider_RateLimited(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode([]Domain{{Name: "example.com"}})
+	}))
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	domainName, err := provider.getDomainName("_acme-challenge.www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domainName)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDNSProvider_RateLimitedExhausted(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"detail":"Request was throttled."}`))
+	}))
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, err = provider.getDomainName("_acme-challenge.www.example.com")
+	assert.EqualError(t, err, `API error (HTTP 429): {"detail":"Request was throttled."}`)
+	assert.Equal(t, maxRetries+1, calls)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, retryAfter("3"))
+	assert.Equal(t, defaultRetryAfter, retryAfter(""))
+	assert.Equal(t, defaultRetryAfter, retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
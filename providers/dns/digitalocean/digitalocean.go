@@ -5,22 +5,49 @@ package digitalocean
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// DigitalOcean API reference: https://developers.digitalocean.com/documentation/v2/#domains
+
+// defaultBaseURL is the DigitalOcean API endpoint.
+const defaultBaseURL = "https://api.digitalocean.com"
+
+// perPage is the number of domains or records requested per page of a list.
+const perPage = 200
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	AuthToken          string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("DO_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("DO_POLLING_INTERVAL", 5*time.Second),
+		TTL:                env.GetOrDefaultInt("DO_TTL", 30),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("DO_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 // that uses DigitalOcean's REST API to manage TXT records for a domain.
 type DNSProvider struct {
-	apiAuthToken string
-	recordIDs    map[string]int
-	recordIDsMu  sync.Mutex
-	client       *http.Client
+	config *Config
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Digital
@@ -29,150 +56,206 @@ type DNSProvider struct {
 func NewDNSProvider() (*DNSProvider, error) {
 	values, err := env.Get("DO_AUTH_TOKEN")
 	if err != nil {
-		return nil, fmt.Errorf("DigitalOcean: %v", err)
+		return nil, fmt.Errorf("digitalocean: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["DO_AUTH_TOKEN"])
+	config := NewDefaultConfig()
+	config.AuthToken = values["DO_AUTH_TOKEN"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for Digital Ocean.
 func NewDNSProviderCredentials(apiAuthToken string) (*DNSProvider, error) {
-	if apiAuthToken == "" {
-		return nil, fmt.Errorf("DigitalOcean credentials missing")
+	config := NewDefaultConfig()
+	config.AuthToken = apiAuthToken
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Digital Ocean.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("digitalocean: the configuration of the DNS provider is nil")
+	}
+
+	if config.AuthToken == "" {
+		return nil, errors.New("digitalocean: credentials missing")
 	}
-	return &DNSProvider{
-		apiAuthToken: apiAuthToken,
-		recordIDs:    make(map[string]int),
-		client:       &http.Client{Timeout: 30 * time.Second},
-	}, nil
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
 }
 
 // Timeout returns the timeout and interval to use when checking for DNS
 // propagation. Adjusting here to cope with spikes in propagation times.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return 60 * time.Second, 5 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record using the specified parameters
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+	zone, name, err := d.findDomain(fqdn)
 	if err != nil {
-		return fmt.Errorf("could not determine zone for domain: '%s'. %s", domain, err)
+		return err
 	}
 
-	authZone = acme.UnFqdn(authZone)
+	record := domainRecord{Type: "TXT", Name: name, Data: value, TTL: d.config.TTL}
+	url := fmt.Sprintf("%s/v2/domains/%s/records", strings.TrimSuffix(d.config.BaseURL, "/"), zone)
 
-	reqURL := fmt.Sprintf("%s/v2/domains/%s/records", digitalOceanBaseURL, authZone)
-	reqData := txtRecordRequest{RecordType: "TXT", Name: fqdn, Data: value, TTL: 30}
-	body, err := json.Marshal(reqData)
-	if err != nil {
-		return err
-	}
+	return d.do(http.MethodPost, url, record, nil)
+}
+
+// CleanUp removes the TXT record matching the specified parameters
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
+	zone, name, err := d.findDomain(fqdn)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
 
-	resp, err := d.client.Do(req)
+	recordID, err := d.findTXTRecord(zone, name, value)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		var errInfo digitalOceanAPIError
-		json.NewDecoder(resp.Body).Decode(&errInfo)
-		return fmt.Errorf("HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
+	url := fmt.Sprintf("%s/v2/domains/%s/records/%d", strings.TrimSuffix(d.config.BaseURL, "/"), zone, recordID)
+
+	return d.do(http.MethodDelete, url, nil, nil)
+}
+
+// findDomain returns the longest domain of the account matching the fqdn,
+// and the name of the record relative to this domain.
+func (d *DNSProvider) findDomain(fqdn string) (string, string, error) {
+	name := acme.UnFqdn(fqdn)
+
+	var zone string
+	url := fmt.Sprintf("%s/v2/domains?per_page=%d", strings.TrimSuffix(d.config.BaseURL, "/"), perPage)
+	for url != "" {
+		var page domainsPage
+		if err := d.do(http.MethodGet, url, nil, &page); err != nil {
+			return "", "", err
+		}
+
+		for _, domain := range page.Domains {
+			if (name == domain.Name || strings.HasSuffix(name, "."+domain.Name)) && len(domain.Name) > len(zone) {
+				zone = domain.Name
+			}
+		}
+
+		url = page.Links.Pages.Next
 	}
 
-	// Everything looks good; but we'll need the ID later to delete the record
-	var respData txtRecordResponse
-	err = json.NewDecoder(resp.Body).Decode(&respData)
-	if err != nil {
-		return err
+	if zone == "" {
+		return "", "", fmt.Errorf("digitalocean: no domain of the account matches %s", fqdn)
 	}
-	d.recordIDsMu.Lock()
-	d.recordIDs[fqdn] = respData.DomainRecord.ID
-	d.recordIDsMu.Unlock()
 
-	return nil
+	if name == zone {
+		return zone, "@", nil
+	}
+	return zone, strings.TrimSuffix(name, "."+zone), nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters
-func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+// findTXTRecord pages through the TXT records of the domain
+// and returns the ID of the record with the given name and value.
+func (d *DNSProvider) findTXTRecord(zone, name, value string) (int, error) {
+	url := fmt.Sprintf("%s/v2/domains/%s/records?type=TXT&per_page=%d", strings.TrimSuffix(d.config.BaseURL, "/"), zone, perPage)
+	for url != "" {
+		var page recordsPage
+		if err := d.do(http.MethodGet, url, nil, &page); err != nil {
+			return 0, err
+		}
 
-	// get the record's unique ID from when we created it
-	d.recordIDsMu.Lock()
-	recordID, ok := d.recordIDs[fqdn]
-	d.recordIDsMu.Unlock()
-	if !ok {
-		return fmt.Errorf("unknown record ID for '%s'", fqdn)
-	}
+		for _, record := range page.DomainRecords {
+			if record.Type == "TXT" && record.Name == name && record.Data == value {
+				return record.ID, nil
+			}
+		}
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
-	if err != nil {
-		return fmt.Errorf("could not determine zone for domain: '%s'. %s", domain, err)
+		url = page.Links.Pages.Next
 	}
 
-	authZone = acme.UnFqdn(authZone)
+	return 0, fmt.Errorf("digitalocean: no TXT record %s found in the domain %s", name, zone)
+}
+
+func (d *DNSProvider) do(method, url string, payload, result interface{}) error {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
+	}
 
-	reqURL := fmt.Sprintf("%s/v2/domains/%s/records/%d", digitalOceanBaseURL, authZone, recordID)
-	req, err := http.NewRequest(http.MethodDelete, reqURL, nil)
+	req, err := http.NewRequest(method, url, &body)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
+	req.Header.Set("Authorization", "Bearer "+d.config.AuthToken)
 
-	resp, err := d.client.Do(req)
+	resp, err := d.config.HTTPClient.Do(req)
 	if err != nil {
-		return err
+		return fmt.Errorf("digitalocean: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		var errInfo digitalOceanAPIError
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errInfo apiError
 		json.NewDecoder(resp.Body).Decode(&errInfo)
-		return fmt.Errorf("HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
+		return fmt.Errorf("digitalocean: HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
 	}
 
-	// Delete record ID from map
-	d.recordIDsMu.Lock()
-	delete(d.recordIDs, fqdn)
-	d.recordIDsMu.Unlock()
+	if result == nil {
+		return nil
+	}
 
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("digitalocean: could not decode the response of %s %s: %v", method, url, err)
+	}
 	return nil
 }
 
-type digitalOceanAPIError struct {
+type apiError struct {
 	ID      string `json:"id"`
 	Message string `json:"message"`
 }
 
-var digitalOceanBaseURL = "https://api.digitalocean.com"
+// domainRecord is a record of a domain, as sent to and returned by DO's API.
+type domainRecord struct {
+	ID   int    `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
 
-// txtRecordRequest represents the request body to DO's API to make a TXT record
-type txtRecordRequest struct {
-	RecordType string `json:"type"`
-	Name       string `json:"name"`
-	Data       string `json:"data"`
-	TTL        int    `json:"ttl"`
+// links holds the pagination links of a list, the next page is empty on the last page.
+type links struct {
+	Pages struct {
+		Next string `json:"next"`
+	} `json:"pages"`
 }
 
-// txtRecordResponse represents a response from DO's API after making a TXT record
-type txtRecordResponse struct {
-	DomainRecord struct {
-		ID   int    `json:"id"`
-		Type string `json:"type"`
+type domainsPage struct {
+	Domains []struct {
 		Name string `json:"name"`
-		Data string `json:"data"`
-	} `json:"domain_record"`
+	} `json:"domains"`
+	Links links `json:"links"`
+}
+
+type recordsPage struct {
+	DomainRecords []domainRecord `json:"domain_records"`
+	Links         links          `json:"links"`
 }
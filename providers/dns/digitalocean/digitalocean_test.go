@@ -1,86 +1,195 @@
 package digitalocean
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-var fakeDigitalOceanAuth = "asdf1234"
+var (
+	fakeDigitalOceanAuth = "asdf1234"
+	envTestAuthToken     = os.Getenv("DO_AUTH_TOKEN")
+)
+
+func restoreEnv() {
+	os.Setenv("DO_AUTH_TOKEN", envTestAuthToken)
+}
+
+// writePage writes the page of a list, with the link to the next page if there is one.
+func writePage(w http.ResponseWriter, r *http.Request, key string, items []interface{}, pageSize int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	var next string
+	if end < len(items) {
+		next = fmt.Sprintf("http://%s%s?page=%d", r.Host, r.URL.Path, page+1)
+	}
+
+	body := map[string]interface{}{key: items[start:end], "links": map[string]interface{}{"pages": map[string]string{"next": next}}}
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DO_AUTH_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "digitalocean: some credentials information are missing: DO_AUTH_TOKEN")
+}
+
+func TestDigitalOceanFindDomain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/domains", r.URL.Path)
+		writePage(w, r, "domains", []interface{}{
+			map[string]string{"name": "example.org"},
+			map[string]string{"name": "example.com"},
+			map[string]string{"name": "sub.example.com"},
+		}, 2)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.AuthToken = fakeDigitalOceanAuth
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	zone, name, err := provider.findDomain("_acme-challenge.www.sub.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "sub.example.com", zone)
+	assert.Equal(t, "_acme-challenge.www", name)
+
+	zone, name, err = provider.findDomain("_acme-challenge.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", zone)
+	assert.Equal(t, "_acme-challenge", name)
+
+	_, _, err = provider.findDomain("_acme-challenge.example.net.")
+	assert.EqualError(t, err, "digitalocean: no domain of the account matches _acme-challenge.example.net.")
+}
 
 func TestDigitalOceanPresent(t *testing.T) {
 	var requestReceived bool
 
-	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestReceived = true
-
-		assert.Equal(t, http.MethodPost, r.Method, "method")
-		assert.Equal(t, "/v2/domains/example.com/records", r.URL.Path, "Path")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "Content-Type")
 		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"), "Authorization")
 
-		reqBody, err := ioutil.ReadAll(r.Body)
-		require.NoError(t, err, "reading request body")
-		assert.Equal(t, `{"type":"TXT","name":"_acme-challenge.example.com.","data":"w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI","ttl":30}`, string(reqBody))
-
-		w.WriteHeader(http.StatusCreated)
-		fmt.Fprintf(w, `{
-			"domain_record": {
-				"id": 1234567,
-				"type": "TXT",
-				"name": "_acme-challenge",
-				"data": "w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI",
-				"priority": null,
-				"port": null,
-				"weight": null
-			}
-		}`)
+		switch r.URL.Path {
+		case "/v2/domains":
+			writePage(w, r, "domains", []interface{}{map[string]string{"name": "example.com"}}, perPage)
+		case "/v2/domains/example.com/records":
+			requestReceived = true
+			assert.Equal(t, http.MethodPost, r.Method, "method")
+
+			var record domainRecord
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+			assert.Equal(t, domainRecord{Type: "TXT", Name: "_acme-challenge", Data: "w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI", TTL: 30}, record)
+
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{
+				"domain_record": {
+					"id": 1234567,
+					"type": "TXT",
+					"name": "_acme-challenge",
+					"data": "w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI",
+					"priority": null,
+					"port": null,
+					"weight": null
+				}
+			}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
 	}))
-	defer mock.Close()
-	digitalOceanBaseURL = mock.URL
+	defer server.Close()
 
-	doprov, err := NewDNSProviderCredentials(fakeDigitalOceanAuth)
+	config := NewDefaultConfig()
+	config.AuthToken = fakeDigitalOceanAuth
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
 	require.NoError(t, err)
-	require.NotNil(t, doprov)
 
-	err = doprov.Present("example.com", "", "foobar")
+	err = provider.Present("example.com", "", "foobar")
 	require.NoError(t, err, "fail to create TXT record")
 
 	assert.True(t, requestReceived, "Expected request to be received by mock backend, but it wasn't")
 }
 
 func TestDigitalOceanCleanUp(t *testing.T) {
-	var requestReceived bool
+	var deleted []string
 
-	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestReceived = true
+	// the record to delete is on the third page of the records.
+	var records []interface{}
+	for i := 1; i <= 5; i++ {
+		records = append(records, domainRecord{ID: i, Type: "TXT", Name: "_acme-challenge", Data: fmt.Sprintf("other%d", i)})
+	}
+	records = append(records, domainRecord{ID: 1234567, Type: "TXT", Name: "_acme-challenge", Data: "w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI"})
 
-		assert.Equal(t, http.MethodDelete, r.Method, "method")
-		assert.Equal(t, "/v2/domains/example.com/records/1234567", r.URL.Path, "Path")
-		// NOTE: Even though the body is empty, DigitalOcean API docs still show setting this Content-Type...
-		assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "Content-Type")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"), "Authorization")
 
-		w.WriteHeader(http.StatusNoContent)
+		switch {
+		case r.URL.Path == "/v2/domains":
+			writePage(w, r, "domains", []interface{}{map[string]string{"name": "example.com"}}, perPage)
+		case r.URL.Path == "/v2/domains/example.com/records" && r.Method == http.MethodGet:
+			writePage(w, r, "domain_records", records, 2)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
 	}))
-	defer mock.Close()
-	digitalOceanBaseURL = mock.URL
+	defer server.Close()
 
-	doprov, err := NewDNSProviderCredentials(fakeDigitalOceanAuth)
-	require.NoError(t, err)
-	require.NotNil(t, doprov)
+	config := NewDefaultConfig()
+	config.AuthToken = fakeDigitalOceanAuth
+	config.BaseURL = server.URL
 
-	doprov.recordIDsMu.Lock()
-	doprov.recordIDs["_acme-challenge.example.com."] = 1234567
-	doprov.recordIDsMu.Unlock()
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
 
-	err = doprov.CleanUp("example.com", "", "")
+	err = provider.CleanUp("example.com", "", "foobar")
 	require.NoError(t, err, "fail to remove TXT record")
 
-	assert.True(t, requestReceived, "Expected request to be received by mock backend, but it wasn't")
+	assert.Equal(t, []string{"/v2/domains/example.com/records/1234567"}, deleted)
+
+	err = provider.CleanUp("example.com", "", "missing")
+	assert.EqualError(t, err, "digitalocean: no TXT record _acme-challenge found in the domain example.com")
+}
+
+func TestDigitalOceanAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"id": "unauthorized", "message": "Unable to authenticate you."}`)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.AuthToken = fakeDigitalOceanAuth
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "foobar")
+	assert.EqualError(t, err, "digitalocean: HTTP 401: unauthorized: Unable to authenticate you.")
 }
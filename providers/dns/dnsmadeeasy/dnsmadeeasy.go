@@ -70,10 +70,11 @@ func NewDNSProviderCredentials(baseURL, apiKey, apiSecret string) (*DNSProvider,
 	}
 
 	transport := &http.Transport{
+		Proxy:           acme.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{
-		Transport: transport,
+		Transport: acme.NewUserAgentTransport(transport),
 		Timeout:   10 * time.Second,
 	}
 
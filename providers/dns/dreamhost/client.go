@@ -0,0 +1,170 @@
+package dreamhost
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+// errRecordAlreadyExists is the prefix of the errors of dns-add_record when the record exists.
+const errRecordAlreadyExists = "record_already_exists"
+
+// apiResponse is the flat response of all the commands.
+type apiResponse struct {
+	Result string          `json:"result"`
+	Data   json.RawMessage `json:"data"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// dnsRecord is a record of dns-list_records.
+type dnsRecord struct {
+	Zone     string `json:"zone"`
+	Record   string `json:"record"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Comment  string `json:"comment"`
+	Editable string `json:"editable"`
+}
+
+// APIError is an error returned by a command of the DreamHost API.
+type APIError struct {
+	Command string
+	// Data is the code of the error, e.g. no_such_zone.
+	Data   string
+	Reason string
+}
+
+func (e APIError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("API error on %s: %s: %s", e.Command, e.Data, e.Reason)
+	}
+	return fmt.Sprintf("API error on %s: %s", e.Command, e.Data)
+}
+
+// addTXTRecord creates the TXT record, the record existing already with the same value is not an error.
+func (d *DNSProvider) addTXTRecord(record, value string) error {
+	params := url.Values{}
+	params.Set("record", record)
+	params.Set("type", "TXT")
+	params.Set("value", value)
+	params.Set("comment", recordComment)
+
+	err := d.doCommand("dns-add_record", params, nil)
+	if apiErr, ok := err.(APIError); ok && strings.HasPrefix(apiErr.Data, errRecordAlreadyExists) {
+		exists, listErr := d.hasTXTRecord(record, value)
+		if listErr != nil {
+			return fmt.Errorf("%v, could not check the existing records: %v", err, listErr)
+		}
+		if exists {
+			return nil
+		}
+	}
+	return err
+}
+
+// removeTXTRecord removes the TXT record with the exact value it was created with.
+func (d *DNSProvider) removeTXTRecord(record, value string) error {
+	params := url.Values{}
+	params.Set("record", record)
+	params.Set("type", "TXT")
+	params.Set("value", value)
+
+	return d.doCommand("dns-remove_record", params, nil)
+}
+
+// hasTXTRecord checks if the TXT record exists with the value.
+func (d *DNSProvider) hasTXTRecord(record, value string) (bool, error) {
+	var records []dnsRecord
+	if err := d.doCommand("dns-list_records", url.Values{}, &records); err != nil {
+		return false, err
+	}
+
+	for _, r := range records {
+		if strings.EqualFold(r.Record, record) && r.Type == "TXT" && r.Value == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// doCommand runs the command with the parameters and decodes the data of its response into result if not nil.
+// Each call is sent with a new unique_id, DreamHost refusing a request whose ID was already used.
+func (d *DNSProvider) doCommand(cmd string, params url.Values, result interface{}) error {
+	uniqueID, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	params.Set("key", d.config.APIKey)
+	params.Set("cmd", cmd)
+	params.Set("unique_id", uniqueID)
+	params.Set("format", "json")
+
+	req, err := http.NewRequest(http.MethodGet, d.config.BaseURL+"/?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+
+	var response apiResponse
+	if err = json.Unmarshal(raw, &response); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+
+	switch response.Result {
+	case resultSuccess:
+	case resultError:
+		var data string
+		if err = json.Unmarshal(response.Data, &data); err != nil {
+			data = string(response.Data)
+		}
+		return APIError{Command: cmd, Data: data, Reason: response.Reason}
+	default:
+		return fmt.Errorf("unexpected result of %s: %s", cmd, string(raw))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(response.Data, result); err != nil {
+		return fmt.Errorf("could not decode the data of %s: %v: %s", cmd, err, string(response.Data))
+	}
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate the unique ID of the request: %v", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
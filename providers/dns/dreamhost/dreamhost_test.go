@@ -0,0 +1,256 @@
+package dreamhost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest      bool
+	envTestAPIKey string
+	envTestDomain string
+)
+
+func init() {
+	envTestAPIKey = os.Getenv("DREAMHOST_API_KEY")
+	envTestDomain = os.Getenv("DREAMHOST_DOMAIN")
+	liveTest = len(envTestAPIKey) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("DREAMHOST_API_KEY", envTestAPIKey)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// mockServer is a DreamHost API refusing the reused unique IDs.
+type mockServer struct {
+	t         *testing.T
+	mu        sync.Mutex
+	commands  []string
+	uniqueIDs map[string]bool
+	records   []dnsRecord
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	return &mockServer{t: t, uniqueIDs: make(map[string]bool)}
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	s.commands = append(s.commands, query.Get("cmd"))
+
+	assert.Equal(s.t, http.MethodGet, r.Method)
+	assert.Equal(s.t, "json", query.Get("format"))
+
+	if query.Get("key") != "secret" {
+		json.NewEncoder(w).Encode(apiResponse{Result: resultError, Data: json.RawMessage(`"invalid_api_key"`)})
+		return
+	}
+
+	uniqueID := query.Get("unique_id")
+	assert.Regexp(s.t, uuidPattern, uniqueID)
+	if s.uniqueIDs[uniqueID] {
+		json.NewEncoder(w).Encode(apiResponse{Result: resultError, Data: json.RawMessage(`"unique_id_already_used"`)})
+		return
+	}
+	s.uniqueIDs[uniqueID] = true
+
+	record := dnsRecord{Zone: "example.com", Record: query.Get("record"), Type: query.Get("type"), Value: query.Get("value"), Editable: "1"}
+
+	switch query.Get("cmd") {
+	case "dns-list_records":
+		data, _ := json.Marshal(s.records)
+		json.NewEncoder(w).Encode(apiResponse{Result: resultSuccess, Data: data})
+
+	case "dns-add_record":
+		assert.Equal(s.t, "Managed By lego", query.Get("comment"))
+		if s.find(record) != -1 {
+			json.NewEncoder(w).Encode(apiResponse{Result: resultError, Data: json.RawMessage(`"record_already_exists_remove_first"`)})
+			return
+		}
+		s.records = append(s.records, record)
+		json.NewEncoder(w).Encode(apiResponse{Result: resultSuccess, Data: json.RawMessage(`"record_added"`)})
+
+	case "dns-remove_record":
+		i := s.find(record)
+		if i == -1 {
+			json.NewEncoder(w).Encode(apiResponse{Result: resultError, Data: json.RawMessage(`"no_such_value"`)})
+			return
+		}
+		s.records = append(s.records[:i], s.records[i+1:]...)
+		json.NewEncoder(w).Encode(apiResponse{Result: resultSuccess, Data: json.RawMessage(`"record_removed"`)})
+
+	default:
+		json.NewEncoder(w).Encode(apiResponse{Result: resultError, Data: json.RawMessage(`"no_cmd"`)})
+	}
+}
+
+func (s *mockServer) find(record dnsRecord) int {
+	for i, r := range s.records {
+		if r.Record == record.Record && r.Type == record.Type && r.Value == record.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DREAMHOST_API_KEY", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DREAMHOST_API_KEY", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "dreamhost: some credentials information are missing: DREAMHOST_API_KEY")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "dreamhost: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "dreamhost: credentials missing")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []dnsRecord{{
+		Zone:     "example.com",
+		Record:   "_acme-challenge.example.com",
+		Type:     "TXT",
+		Value:    "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		Editable: "1",
+	}}
+	assert.Equal(t, expected, server.records)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records)
+	assert.Equal(t, []string{"dns-add_record", "dns-remove_record"}, server.commands)
+	// each request has its own unique ID.
+	assert.Len(t, server.uniqueIDs, 2)
+}
+
+func TestDNSProvider_PresentRecordAlreadyExists(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// the record is left over from a previous attempt.
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Len(t, server.records, 1)
+	assert.Equal(t, []string{"dns-add_record", "dns-add_record", "dns-list_records"}, server.commands)
+}
+
+func TestDNSProvider_CleanUpUnknownValue(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "otherKeyAuth")
+	assert.EqualError(t, err, "dreamhost: API error on dns-remove_record: no_such_value")
+	assert.Len(t, server.records, 1)
+}
+
+func TestDNSProvider_InvalidAPIKey(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "other"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "dreamhost: API error on dns-add_record: invalid_api_key")
+}
+
+func TestNewUUID(t *testing.T) {
+	first, err := newUUID()
+	require.NoError(t, err)
+	assert.Regexp(t, uuidPattern, first)
+
+	second, err := newUUID()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
@@ -6,15 +6,40 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// defaultBaseURL is the DuckDNS update endpoint.
+const defaultBaseURL = "https://www.duckdns.org/update"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Token              string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("DUCKDNS_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("DUCKDNS_POLLING_INTERVAL", 2*time.Second),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("DUCKDNS_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
 // DNSProvider adds and removes the record for the DNS challenge
 type DNSProvider struct {
-	// The api token
-	token string
+	config *Config
 }
 
 // NewDNSProvider returns a new DNS provider using
@@ -25,50 +50,97 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("DuckDNS: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["DUCKDNS_TOKEN"])
+	config := NewDefaultConfig()
+	config.Token = values["DUCKDNS_TOKEN"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for http://duckdns.org .
 func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
-	if token == "" {
+	config := NewDefaultConfig()
+	config.Token = token
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for DuckDNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("DuckDNS: the configuration of the DNS provider is nil")
+	}
+
+	if config.Token == "" {
 		return nil, errors.New("DuckDNS: credentials missing")
 	}
 
-	return &DNSProvider{token: token}, nil
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	_, txtRecord, _ := acme.DNS01Record(domain, keyAuth)
-	return updateTxtRecord(domain, d.token, txtRecord, false)
+	fqdn, txtRecord, _ := acme.DNS01Record(domain, keyAuth)
+	return d.updateTxtRecord(fqdn, url.Values{"txt": {txtRecord}})
 }
 
 // CleanUp clears DuckDNS TXT record
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	return updateTxtRecord(domain, d.token, "", true)
+	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	return d.updateTxtRecord(fqdn, url.Values{"txt": {""}, "clear": {"true"}})
 }
 
 // updateTxtRecord Update the domains TXT record
 // To update the TXT record we just need to make one simple get request.
 // In DuckDNS you only have one TXT record shared with the domain and all sub domains.
-func updateTxtRecord(domain, token, txt string, clear bool) error {
-	u := fmt.Sprintf("https://www.duckdns.org/update?domains=%s&token=%s&clear=%t&txt=%s", domain, token, clear, txt)
+func (d *DNSProvider) updateTxtRecord(fqdn string, params url.Values) error {
+	params.Set("domains", getMainDomain(fqdn))
+	params.Set("token", d.config.Token)
 
-	response, err := acme.HTTPClient.Get(u)
+	u := d.config.BaseURL + "?" + params.Encode()
+
+	response, err := d.config.HTTPClient.Get(u)
 	if err != nil {
-		return err
+		return fmt.Errorf("DuckDNS: %v", err)
 	}
 	defer response.Body.Close()
 
 	bodyBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return err
+		return fmt.Errorf("DuckDNS: %v", err)
 	}
 
-	body := string(bodyBytes)
-	if body != "OK" {
-		return fmt.Errorf("request to change TXT record for DuckDNS returned the following result (%s) this does not match expectation (OK) used url [%s]", body, u)
+	// the body is a plain text OK or KO, optionally followed by lines of details.
+	body := strings.TrimSpace(string(bodyBytes))
+	if response.StatusCode != http.StatusOK || strings.SplitN(body, "\n", 2)[0] != "OK" {
+		return fmt.Errorf("DuckDNS: request to update the TXT record of %s returned HTTP %d (%s), expected OK", params.Get("domains"), response.StatusCode, body)
 	}
 	return nil
 }
+
+// getMainDomain returns the DuckDNS domain owning the fqdn:
+// the TXT record is applied to the base domain, so the _acme-challenge
+// prefix and the sub domains below the DuckDNS domain are dropped.
+func getMainDomain(fqdn string) string {
+	name := strings.TrimPrefix(acme.UnFqdn(fqdn), "_acme-challenge.")
+
+	if !strings.HasSuffix(name, ".duckdns.org") {
+		return name
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, ".duckdns.org"), ".")
+	return labels[len(labels)-1] + ".duckdns.org"
+}
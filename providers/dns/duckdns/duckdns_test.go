@@ -1,11 +1,17 @@
 package duckdns
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
 )
 
 var (
@@ -42,6 +48,85 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	assert.EqualError(t, err, "DuckDNS: some credentials information are missing: DUCKDNS_TOKEN")
 }
 
+func TestGetMainDomain(t *testing.T) {
+	testCases := []struct {
+		fqdn     string
+		expected string
+	}{
+		{fqdn: "_acme-challenge.sub.duckdns.org.", expected: "sub.duckdns.org"},
+		{fqdn: "_acme-challenge.my.sub.duckdns.org.", expected: "sub.duckdns.org"},
+		{fqdn: "_acme-challenge.example.com.", expected: "example.com"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, getMainDomain(test.fqdn), test.fqdn)
+	}
+}
+
+func TestDNSProvider_Present(t *testing.T) {
+	_, value, _ := acme.DNS01Record("www.sub.duckdns.org", "keyAuth")
+
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/update", r.URL.Path)
+		query = r.URL.Query()
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = server.URL + "/update"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.sub.duckdns.org", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := url.Values{"domains": {"sub.duckdns.org"}, "token": {"secret"}, "txt": {value}}
+	assert.Equal(t, expected, query)
+}
+
+func TestDNSProvider_CleanUp(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = server.URL + "/update"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("sub.duckdns.org", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := url.Values{"domains": {"sub.duckdns.org"}, "token": {"secret"}, "txt": {""}, "clear": {"true"}}
+	assert.Equal(t, expected, query)
+}
+
+func TestDNSProvider_PresentKO(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "KO")
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = server.URL + "/update"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("sub.duckdns.org", "", "keyAuth")
+	assert.EqualError(t, err, "DuckDNS: request to update the TXT record of sub.duckdns.org returned HTTP 200 (KO), expected OK")
+}
+
 func TestLiveDuckdnsPresent(t *testing.T) {
 	if !duckdnsLiveTest {
 		t.Skip("skipping live test")
@@ -0,0 +1,221 @@
+package dyn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxJobPolls is the number of polls of a long running job before giving up.
+const maxJobPolls = 30
+
+// jobPollInterval is the delay between two polls of a long running job.
+var jobPollInterval = 2 * time.Second
+
+type dynResponse struct {
+	// One of 'success', 'failure', or 'incomplete'
+	Status string `json:"status"`
+
+	// The structure containing the actual results of the request
+	Data json.RawMessage `json:"data"`
+
+	// The ID of the job that was created in response to a request.
+	JobID int `json:"job_id"`
+
+	// A list of zero or more messages
+	Messages []message `json:"msgs"`
+}
+
+type message struct {
+	Info   string `json:"INFO"`
+	Source string `json:"SOURCE"`
+	Code   string `json:"ERR_CD"`
+	Level  string `json:"LVL"`
+}
+
+func (m message) String() string {
+	if m.Code != "" {
+		return fmt.Sprintf("%s: %s (%s)", m.Source, m.Info, m.Code)
+	}
+	return fmt.Sprintf("%s: %s", m.Source, m.Info)
+}
+
+// apiError is a failure reported by the Dyn API.
+type apiError struct {
+	StatusCode int
+	Messages   []message
+}
+
+func (e apiError) Error() string {
+	msgs := make([]string, len(e.Messages))
+	for i, msg := range e.Messages {
+		msgs[i] = msg.String()
+	}
+	return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, strings.Join(msgs, ", "))
+}
+
+type txtRecord struct {
+	RecordID int `json:"record_id"`
+}
+
+// login starts a new session of the Dyn API and returns its token.
+func (d *DNSProvider) login() (string, error) {
+	payload := map[string]string{
+		"customer_name": d.config.CustomerName,
+		"user_name":     d.config.UserName,
+		"password":      d.config.Password,
+	}
+
+	dynRes, err := d.doRequest("", http.MethodPost, "Session", payload)
+	if err != nil {
+		return "", err
+	}
+
+	var session struct {
+		Token   string `json:"token"`
+		Version string `json:"version"`
+	}
+	if err = json.Unmarshal(dynRes.Data, &session); err != nil {
+		return "", fmt.Errorf("could not decode the session: %v", err)
+	}
+	if session.Token == "" {
+		return "", fmt.Errorf("no token in the session: %s", string(dynRes.Data))
+	}
+
+	return session.Token, nil
+}
+
+// logout destroys the session of the token.
+func (d *DNSProvider) logout(token string) error {
+	_, err := d.doRequest(token, http.MethodDelete, "Session", nil)
+	return err
+}
+
+// addTXTRecord creates the TXT record of the zone and returns its ID.
+func (d *DNSProvider) addTXTRecord(token, zone, fqdn, value string, ttl int) (int, error) {
+	payload := map[string]interface{}{
+		"rdata": map[string]string{
+			"txtdata": value,
+		},
+		"ttl": strconv.Itoa(ttl),
+	}
+
+	dynRes, err := d.doRequest(token, http.MethodPost, fmt.Sprintf("TXTRecord/%s/%s/", zone, fqdn), payload)
+	if err != nil {
+		return 0, err
+	}
+
+	var record txtRecord
+	if err = json.Unmarshal(dynRes.Data, &record); err != nil {
+		return 0, fmt.Errorf("could not decode the record: %v", err)
+	}
+
+	return record.RecordID, nil
+}
+
+// deleteTXTRecord deletes the TXT record with the given ID,
+// or all the TXT records of the fqdn if the ID is unknown.
+func (d *DNSProvider) deleteTXTRecord(token, zone, fqdn string, recordID int) error {
+	resource := fmt.Sprintf("TXTRecord/%s/%s/", zone, fqdn)
+	if recordID != 0 {
+		resource += strconv.Itoa(recordID) + "/"
+	}
+
+	_, err := d.doRequest(token, http.MethodDelete, resource, nil)
+	return err
+}
+
+// publish publishes the pending changes of the zone.
+func (d *DNSProvider) publish(token, zone, notes string) error {
+	payload := map[string]interface{}{
+		"publish": true,
+		"notes":   notes,
+	}
+
+	_, err := d.doRequest(token, http.MethodPut, fmt.Sprintf("Zone/%s/", zone), payload)
+	return err
+}
+
+// doRequest sends the request to the resource of the Dyn API and returns its response.
+// The long running requests are followed by polling their job until it is complete.
+func (d *DNSProvider) doRequest(token, method, resource string, payload interface{}) (*dynResponse, error) {
+	endpoint := fmt.Sprintf("%s/%s", d.config.BaseURL, resource)
+
+	for poll := 0; ; poll++ {
+		dynRes, jobURL, err := d.send(token, method, endpoint, payload)
+		if err != nil || jobURL == "" {
+			return dynRes, err
+		}
+
+		if poll == maxJobPolls {
+			return nil, fmt.Errorf("the job %s is still incomplete after %d polls", jobURL, maxJobPolls)
+		}
+
+		time.Sleep(jobPollInterval)
+		method, endpoint, payload = http.MethodGet, jobURL, nil
+	}
+}
+
+// send sends a single request. The returned URL is not empty if the request
+// is still running, it is the job to poll for the result.
+func (d *DNSProvider) send(token, method, endpoint string, payload interface{}) (*dynResponse, string, error) {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, "", err
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Auth-Token", token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	// The requests taking more than a few seconds are redirected to their job.
+	if resp.StatusCode == http.StatusTemporaryRedirect {
+		location, errLoc := resp.Location()
+		if errLoc != nil {
+			return nil, "", fmt.Errorf("could not get the job of the request (HTTP %d): %v", resp.StatusCode, errLoc)
+		}
+		return nil, location.String(), nil
+	}
+
+	var dynRes dynResponse
+	if err = json.Unmarshal(raw, &dynRes); err != nil {
+		return nil, "", fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest || dynRes.Status == "failure" {
+		return nil, "", apiError{StatusCode: resp.StatusCode, Messages: dynRes.Messages}
+	}
+
+	if dynRes.Status == "incomplete" {
+		return nil, fmt.Sprintf("%s/Job/%d/", d.config.BaseURL, dynRes.JobID), nil
+	}
+
+	return &dynRes, "", nil
+}
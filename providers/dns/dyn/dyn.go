@@ -3,41 +3,54 @@
 package dyn
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
-var dynBaseURL = "https://api.dynect.net/REST"
-
-type dynResponse struct {
-	// One of 'success', 'failure', or 'incomplete'
-	Status string `json:"status"`
-
-	// The structure containing the actual results of the request
-	Data json.RawMessage `json:"data"`
-
-	// The ID of the job that was created in response to a request.
-	JobID int `json:"job_id"`
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.dynect.net/REST"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	CustomerName       string
+	UserName           string
+	Password           string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
 
-	// A list of zero or more messages
-	Messages json.RawMessage `json:"msgs"`
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("DYN_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("DYN_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("DYN_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("DYN_HTTP_TIMEOUT", 10*time.Second)),
+	}
 }
 
 // DNSProvider is an implementation of the acme.ChallengeProvider interface that uses
 // Dyn's Managed DNS API to manage TXT records for a domain.
+// Each call opens its own session of the API, which is closed before returning.
 type DNSProvider struct {
-	customerName string
-	userName     string
-	password     string
-	token        string
-	client       *http.Client
+	config *Config
+	// client does not follow the redirections of the long running requests to their job.
+	client *http.Client
+	// recordIDs are the IDs of the created records, by fqdn and value.
+	recordIDs   map[string]int
+	recordIDsMu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Dyn DNS.
@@ -46,223 +59,135 @@ type DNSProvider struct {
 func NewDNSProvider() (*DNSProvider, error) {
 	values, err := env.Get("DYN_CUSTOMER_NAME", "DYN_USER_NAME", "DYN_PASSWORD")
 	if err != nil {
-		return nil, fmt.Errorf("DynDNS: %v", err)
+		return nil, fmt.Errorf("dyn: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["DYN_CUSTOMER_NAME"], values["DYN_USER_NAME"], values["DYN_PASSWORD"])
+	config := NewDefaultConfig()
+	config.CustomerName = values["DYN_CUSTOMER_NAME"]
+	config.UserName = values["DYN_USER_NAME"]
+	config.Password = values["DYN_PASSWORD"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for Dyn DNS.
 func NewDNSProviderCredentials(customerName, userName, password string) (*DNSProvider, error) {
-	if customerName == "" || userName == "" || password == "" {
-		return nil, fmt.Errorf("DynDNS credentials missing")
-	}
+	config := NewDefaultConfig()
+	config.CustomerName = customerName
+	config.UserName = userName
+	config.Password = password
 
-	return &DNSProvider{
-		customerName: customerName,
-		userName:     userName,
-		password:     password,
-		client:       &http.Client{Timeout: 10 * time.Second},
-	}, nil
+	return NewDNSProviderConfig(config)
 }
 
-func (d *DNSProvider) sendRequest(method, resource string, payload interface{}) (*dynResponse, error) {
-	url := fmt.Sprintf("%s/%s", dynBaseURL, resource)
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest(method, url, bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if len(d.token) > 0 {
-		req.Header.Set("Auth-Token", d.token)
-	}
-
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return nil, err
+// NewDNSProviderConfig return a DNSProvider instance configured for Dyn DNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("dyn: the configuration of the DNS provider is nil")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 500 {
-		return nil, fmt.Errorf("Dyn API request failed with HTTP status code %d", resp.StatusCode)
+	if config.CustomerName == "" || config.UserName == "" || config.Password == "" {
+		return nil, errors.New("dyn: credentials missing")
 	}
 
-	var dynRes dynResponse
-	err = json.NewDecoder(resp.Body).Decode(&dynRes)
-	if err != nil {
-		return nil, err
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
 	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("Dyn API request failed with HTTP status code %d: %s", resp.StatusCode, dynRes.Messages)
-	} else if resp.StatusCode == 307 {
-		// TODO add support for HTTP 307 response and long running jobs
-		return nil, fmt.Errorf("Dyn API request returned HTTP 307. This is currently unsupported")
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(10 * time.Second)
 	}
 
-	if dynRes.Status == "failure" {
-		// TODO add better error handling
-		return nil, fmt.Errorf("Dyn API request failed: %s", dynRes.Messages)
+	client := *config.HTTPClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
-	return &dynRes, nil
+	return &DNSProvider{config: config, client: &client, recordIDs: make(map[string]int)}, nil
 }
 
-// Starts a new Dyn API Session. Authenticates using customerName, userName,
-// password and receives a token to be used in for subsequent requests.
-func (d *DNSProvider) login() error {
-	type creds struct {
-		Customer string `json:"customer_name"`
-		User     string `json:"user_name"`
-		Pass     string `json:"password"`
-	}
-
-	type session struct {
-		Token   string `json:"token"`
-		Version string `json:"version"`
-	}
-
-	payload := &creds{Customer: d.customerName, User: d.userName, Pass: d.password}
-	dynRes, err := d.sendRequest(http.MethodPost, "Session", payload)
-	if err != nil {
-		return err
-	}
-
-	var s session
-	err = json.Unmarshal(dynRes.Data, &s)
-	if err != nil {
-		return err
-	}
-
-	d.token = s.Token
-
-	return nil
-}
-
-// Destroys Dyn Session
-func (d *DNSProvider) logout() error {
-	if len(d.token) == 0 {
-		// nothing to do
-		return nil
-	}
-
-	url := fmt.Sprintf("%s/Session", dynBaseURL)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Auth-Token", d.token)
-
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Dyn API request failed to delete session with HTTP status code %d", resp.StatusCode)
-	}
-
-	d.token = ""
-
-	return nil
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record using the specified parameters
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
 	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: %v", err)
 	}
+	authZone = acme.UnFqdn(authZone)
 
-	err = d.login()
+	sessionToken, err := d.login()
 	if err != nil {
-		return err
-	}
-
-	data := map[string]interface{}{
-		"rdata": map[string]string{
-			"txtdata": value,
-		},
-		"ttl": strconv.Itoa(ttl),
+		return fmt.Errorf("dyn: login failed: %v", err)
 	}
+	defer d.closeSession(sessionToken)
 
-	resource := fmt.Sprintf("TXTRecord/%s/%s/", authZone, fqdn)
-	_, err = d.sendRequest(http.MethodPost, resource, data)
+	recordID, err := d.addTXTRecord(sessionToken, authZone, acme.UnFqdn(fqdn), value, d.config.TTL)
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: failed to create TXT record: %v", err)
 	}
 
-	err = d.publish(authZone, "Added TXT record for ACME dns-01 challenge using lego client")
-	if err != nil {
-		return err
-	}
-
-	return d.logout()
-}
+	d.recordIDsMu.Lock()
+	d.recordIDs[fqdn+" "+value] = recordID
+	d.recordIDsMu.Unlock()
 
-func (d *DNSProvider) publish(zone, notes string) error {
-	type publish struct {
-		Publish bool   `json:"publish"`
-		Notes   string `json:"notes"`
+	err = d.publish(sessionToken, authZone, "Added TXT record for ACME dns-01 challenge using lego client")
+	if err != nil {
+		return fmt.Errorf("dyn: failed to publish zone %s: %v", authZone, err)
 	}
 
-	pub := &publish{Publish: true, Notes: notes}
-	resource := fmt.Sprintf("Zone/%s/", zone)
-
-	_, err := d.sendRequest(http.MethodPut, resource, pub)
-	return err
+	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters
+// CleanUp removes the TXT record matching the specified parameters.
+// The records which were not created by this provider are removed with all the TXT records of the fqdn.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
 	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: %v", err)
 	}
+	authZone = acme.UnFqdn(authZone)
 
-	err = d.login()
+	d.recordIDsMu.Lock()
+	recordID := d.recordIDs[fqdn+" "+value]
+	d.recordIDsMu.Unlock()
+
+	sessionToken, err := d.login()
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: login failed: %v", err)
 	}
+	defer d.closeSession(sessionToken)
 
-	resource := fmt.Sprintf("TXTRecord/%s/%s/", authZone, fqdn)
-	url := fmt.Sprintf("%s/%s", dynBaseURL, resource)
-
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	err = d.deleteTXTRecord(sessionToken, authZone, acme.UnFqdn(fqdn), recordID)
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: failed to delete TXT record: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Auth-Token", d.token)
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn+" "+value)
+	d.recordIDsMu.Unlock()
 
-	resp, err := d.client.Do(req)
+	err = d.publish(sessionToken, authZone, "Removed TXT record for ACME dns-01 challenge using lego client")
 	if err != nil {
-		return err
+		return fmt.Errorf("dyn: failed to publish zone %s: %v", authZone, err)
 	}
-	resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Dyn API request failed to delete TXT record HTTP status code %d", resp.StatusCode)
-	}
+	return nil
+}
 
-	err = d.publish(authZone, "Removed TXT record for ACME dns-01 challenge using lego client")
-	if err != nil {
-		return err
+// closeSession logs out of the session, the sessions left open expire by themselves.
+func (d *DNSProvider) closeSession(sessionToken string) {
+	if err := d.logout(sessionToken); err != nil {
+		log.Warnf("dyn: failed to log out: %v", err)
 	}
-
-	return d.logout()
 }
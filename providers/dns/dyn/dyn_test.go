@@ -1,11 +1,18 @@
 package dyn
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
 )
 
 var (
@@ -26,6 +33,378 @@ func init() {
 	}
 }
 
+func restoreEnv() {
+	os.Setenv("DYN_CUSTOMER_NAME", dynCustomerName)
+	os.Setenv("DYN_USER_NAME", dynUserName)
+	os.Setenv("DYN_PASSWORD", dynPassword)
+}
+
+// mockServer is a Dyn API recording the requests it serves.
+type mockServer struct {
+	mu       sync.Mutex
+	requests []string
+	// handlers override the default responses, by method and path.
+	handlers map[string]http.HandlerFunc
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.Path
+
+	s.mu.Lock()
+	s.requests = append(s.requests, key)
+	handler, ok := s.handlers[key]
+	s.mu.Unlock()
+
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	if r.URL.Path == "/REST/Session" {
+		if r.Method == http.MethodPost {
+			writeResponse(w, http.StatusOK, "success", map[string]string{"token": "session-token", "version": "3.7.0"})
+			return
+		}
+	} else if r.Header.Get("Auth-Token") != "session-token" {
+		writeResponse(w, http.StatusBadRequest, "failure", nil)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, "success", map[string]int{"record_id": 123})
+}
+
+func (s *mockServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func writeResponse(w http.ResponseWriter, statusCode int, status string, data interface{}) {
+	raw, _ := json.Marshal(data)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(dynResponse{Status: status, Data: raw, JobID: 42})
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DYN_CUSTOMER_NAME", "customer")
+	os.Setenv("DYN_USER_NAME", "user")
+	os.Setenv("DYN_PASSWORD", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("DYN_CUSTOMER_NAME", "")
+	os.Setenv("DYN_USER_NAME", "")
+	os.Setenv("DYN_PASSWORD", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "dyn: some credentials information are missing: DYN_CUSTOMER_NAME,DYN_USER_NAME,DYN_PASSWORD")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "dyn: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "dyn: credentials missing")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{handlers: map[string]http.HandlerFunc{
+		"POST /REST/Session": func(w http.ResponseWriter, r *http.Request) {
+			var creds map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&creds))
+			assert.Equal(t, map[string]string{"customer_name": "customer", "user_name": "user", "password": "secret"}, creds)
+
+			writeResponse(w, http.StatusOK, "success", map[string]string{"token": "session-token"})
+		},
+		"POST /REST/TXTRecord/example.com/_acme-challenge.example.com/": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "session-token", r.Header.Get("Auth-Token"))
+
+			var record struct {
+				RData map[string]string `json:"rdata"`
+				TTL   string            `json:"ttl"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+			assert.Equal(t, "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", record.RData["txtdata"])
+			assert.Equal(t, "120", record.TTL)
+
+			writeResponse(w, http.StatusOK, "success", map[string]int{"record_id": 123})
+		},
+		"PUT /REST/Zone/example.com/": func(w http.ResponseWriter, r *http.Request) {
+			var publish map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&publish))
+			assert.Equal(t, true, publish["publish"])
+
+			writeResponse(w, http.StatusOK, "success", nil)
+		},
+	}}
+
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 0
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.CustomerName = "customer"
+	config.UserName = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/REST"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []string{
+		"POST /REST/Session",
+		"POST /REST/TXTRecord/example.com/_acme-challenge.example.com/",
+		"PUT /REST/Zone/example.com/",
+		"DELETE /REST/Session",
+	}
+	assert.Equal(t, expected, server.Requests())
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected = append(expected,
+		"POST /REST/Session",
+		"DELETE /REST/TXTRecord/example.com/_acme-challenge.example.com/123/",
+		"PUT /REST/Zone/example.com/",
+		"DELETE /REST/Session",
+	)
+	assert.Equal(t, expected, server.Requests())
+	assert.Empty(t, provider.recordIDs)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{}
+
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 0
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.CustomerName = "customer"
+	config.UserName = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/REST"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []string{
+		"POST /REST/Session",
+		"DELETE /REST/TXTRecord/example.com/_acme-challenge.example.com/",
+		"PUT /REST/Zone/example.com/",
+		"DELETE /REST/Session",
+	}
+	assert.Equal(t, expected, server.Requests())
+}
+
+func TestDNSProvider_PresentLongRunningJobs(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	var polls int
+	server := &mockServer{handlers: map[string]http.HandlerFunc{
+		"POST /REST/TXTRecord/example.com/_acme-challenge.example.com/": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/REST/Job/42/", http.StatusTemporaryRedirect)
+		},
+		"PUT /REST/Zone/example.com/": func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, http.StatusOK, "incomplete", nil)
+		},
+		"GET /REST/Job/42/": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "session-token", r.Header.Get("Auth-Token"))
+
+			polls++
+			if polls%2 == 1 {
+				writeResponse(w, http.StatusOK, "incomplete", nil)
+				return
+			}
+			writeResponse(w, http.StatusOK, "success", map[string]int{"record_id": 456})
+		},
+	}}
+
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 0
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.CustomerName = "customer"
+	config.UserName = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/REST"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []string{
+		"POST /REST/Session",
+		"POST /REST/TXTRecord/example.com/_acme-challenge.example.com/",
+		"GET /REST/Job/42/",
+		"GET /REST/Job/42/",
+		"PUT /REST/Zone/example.com/",
+		"GET /REST/Job/42/",
+		"GET /REST/Job/42/",
+		"DELETE /REST/Session",
+	}
+	assert.Equal(t, expected, server.Requests())
+	assert.Equal(t, 456, provider.recordIDs["_acme-challenge.example.com. pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"])
+}
+
+func TestDNSProvider_PresentIncompleteJob(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{handlers: map[string]http.HandlerFunc{
+		"POST /REST/TXTRecord/example.com/_acme-challenge.example.com/": func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, http.StatusOK, "incomplete", nil)
+		},
+		"GET /REST/Job/42/": func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, http.StatusOK, "incomplete", nil)
+		},
+	}}
+
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 0
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.CustomerName = "customer"
+	config.UserName = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/REST"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("still incomplete after %d polls", maxJobPolls))
+
+	requests := server.Requests()
+	assert.Len(t, requests, maxJobPolls+3)
+	assert.Equal(t, "DELETE /REST/Session", requests[len(requests)-1])
+}
+
+func TestDNSProvider_LogoutOnError(t *testing.T) {
+	failure := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"failure","data":{},"job_id":42,"msgs":[{"INFO":"name: Name already exists","SOURCE":"BLL","ERR_CD":"TARGET_EXISTS","LVL":"ERROR"}]}`))
+	}
+
+	testCases := []struct {
+		desc     string
+		resource string
+		present  bool
+		expected string
+	}{
+		{
+			desc:     "create",
+			resource: "POST /REST/TXTRecord/example.com/_acme-challenge.example.com/",
+			present:  true,
+			expected: "dyn: failed to create TXT record: API error (HTTP 400): BLL: name: Name already exists (TARGET_EXISTS)",
+		},
+		{
+			desc:     "publish",
+			resource: "PUT /REST/Zone/example.com/",
+			present:  true,
+			expected: "dyn: failed to publish zone example.com: API error (HTTP 400): BLL: name: Name already exists (TARGET_EXISTS)",
+		},
+		{
+			desc:     "delete",
+			resource: "DELETE /REST/TXTRecord/example.com/_acme-challenge.example.com/",
+			expected: "dyn: failed to delete TXT record: API error (HTTP 400): BLL: name: Name already exists (TARGET_EXISTS)",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer tester.MockZones(t, "example.com.")()
+
+			server := &mockServer{handlers: map[string]http.HandlerFunc{test.resource: failure}}
+
+			defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+			jobPollInterval = 0
+
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			config := NewDefaultConfig()
+			config.CustomerName = "customer"
+			config.UserName = "user"
+			config.Password = "secret"
+			config.BaseURL = ts.URL + "/REST"
+
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			if test.present {
+				err = provider.Present("example.com", "", "keyAuth")
+			} else {
+				err = provider.CleanUp("example.com", "", "keyAuth")
+			}
+			assert.EqualError(t, err, test.expected)
+
+			requests := server.Requests()
+			assert.Equal(t, "POST /REST/Session", requests[0])
+			assert.Equal(t, "DELETE /REST/Session", requests[len(requests)-1])
+		})
+	}
+}
+
+func TestDNSProvider_LoginFailure(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{handlers: map[string]http.HandlerFunc{
+		"POST /REST/Session": func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, http.StatusBadRequest, "failure", nil)
+		},
+	}}
+
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 0
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.CustomerName = "customer"
+	config.UserName = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/REST"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "dyn: login failed: API error (HTTP 400): ")
+	assert.Equal(t, []string{"POST /REST/Session"}, server.Requests())
+}
+
 func TestLiveDynPresent(t *testing.T) {
 	if !dynLiveTest {
 		t.Skip("skipping live test")
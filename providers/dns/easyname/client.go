@@ -0,0 +1,265 @@
+package easyname
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	loginPath     = "/en/login"
+	domainsPath   = "/en/domain"
+	dnsIndexPath  = "/en/domain/dns/index/domain/%s"
+	dnsCreatePath = "/en/domain/dns/create/domain/%s"
+)
+
+var (
+	dnsIndexLinkRegexp   = regexp.MustCompile(`/domain/dns/index/domain/(\d+)`)
+	dnsDeleteLinkRegexp  = regexp.MustCompile(`/domain/dns/delete/domain/(\d+)/id/(\d+)`)
+	errPageLayoutChanged = errors.New("the page layout may have changed")
+)
+
+// record is a DNS record as entered in the forms.
+type record struct {
+	Name    string
+	Type    string
+	Content string
+	TTL     int
+}
+
+// session is a logged in session of the web interface, keeping its cookies.
+type session struct {
+	client  *http.Client
+	baseURL string
+}
+
+// login opens a session, submitting the login form with its CSRF token.
+func (d *DNSProvider) login() (*session, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := *d.config.HTTPClient
+	client.Jar = jar
+
+	s := &session{client: &client, baseURL: d.config.BaseURL}
+
+	page, pageURL, err := s.get(loginPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the login page: %v", err)
+	}
+
+	loginForm, ok := findForm(page, func(f form) bool { return f.hasInputType("password") })
+	if !ok {
+		return nil, fmt.Errorf("could not find the login form: %v", errPageLayoutChanged)
+	}
+
+	if _, _, err = loginForm.csrfToken(); err != nil {
+		return nil, fmt.Errorf("could not find the CSRF token of the login form: %v", errPageLayoutChanged)
+	}
+
+	values := url.Values{}
+	for name, inputType := range loginForm.inputs {
+		switch inputType {
+		case "email", "text":
+			values.Set(name, d.config.Email)
+		case "password":
+			values.Set(name, d.config.Password)
+		}
+	}
+
+	page, _, err = s.submit(pageURL, loginForm, values)
+	if err != nil {
+		return nil, fmt.Errorf("could not log in: %v", err)
+	}
+
+	if _, ok = findForm(page, func(f form) bool { return f.hasInputType("password") }); ok {
+		return nil, errors.New("could not log in: the email address or the password was rejected")
+	}
+
+	return s, nil
+}
+
+// findDomainID returns the ID of the domain, from the link to its DNS records in the list of the domains.
+func (s *session) findDomainID(domain string) (string, error) {
+	page, _, err := s.get(domainsPath)
+	if err != nil {
+		return "", fmt.Errorf("could not get the list of the domains: %v", err)
+	}
+
+	for _, row := range parseRows(page) {
+		if !containsField(textFields(row), domain) {
+			continue
+		}
+
+		for _, link := range parseLinks(row) {
+			if m := dnsIndexLinkRegexp.FindStringSubmatch(link); m != nil {
+				return m[1], nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find the domain %s in the list of the domains", domain)
+}
+
+// addRecord submits the form adding a DNS record to the domain.
+func (s *session) addRecord(domainID string, r record) error {
+	page, pageURL, err := s.get(fmt.Sprintf(dnsCreatePath, domainID))
+	if err != nil {
+		return fmt.Errorf("could not get the form adding a record: %v", err)
+	}
+
+	createForm, ok := findForm(page, func(f form) bool { return f.inputs["content"] != "" })
+	if !ok {
+		return fmt.Errorf("could not find the form adding a record: %v", errPageLayoutChanged)
+	}
+
+	if _, _, err = createForm.csrfToken(); err != nil {
+		return fmt.Errorf("could not find the CSRF token of the form adding a record: %v", errPageLayoutChanged)
+	}
+
+	values := url.Values{
+		"name":    {r.Name},
+		"type":    {r.Type},
+		"content": {r.Content},
+		"ttl":     {fmt.Sprintf("%d", r.TTL)},
+	}
+	if createForm.inputs["priority"] != "" {
+		values.Set("priority", "0")
+	}
+
+	_, _, err = s.submit(pageURL, createForm, values)
+	return err
+}
+
+// findRecordID returns the ID of the record, from the form deleting it in the list of the records of the domain.
+func (s *session) findRecordID(domainID string, r record, fqdn string) (string, error) {
+	page, _, err := s.get(fmt.Sprintf(dnsIndexPath, domainID))
+	if err != nil {
+		return "", fmt.Errorf("could not get the list of the records: %v", err)
+	}
+
+	for _, row := range parseRows(page) {
+		fields := textFields(row)
+		if !containsField(fields, r.Name) && !containsField(fields, fqdn) ||
+			!containsField(fields, r.Content) && !containsField(fields, `"`+r.Content+`"`) {
+			continue
+		}
+
+		for _, f := range parseForms(row) {
+			if m := dnsDeleteLinkRegexp.FindStringSubmatch(f.action); m != nil && m[1] == domainID {
+				return m[2], nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find the record %s in the list of the records", r.Name)
+}
+
+// deleteRecord submits the form deleting the record, found in the list of the records of the domain.
+func (s *session) deleteRecord(domainID, recordID string) error {
+	page, pageURL, err := s.get(fmt.Sprintf(dnsIndexPath, domainID))
+	if err != nil {
+		return fmt.Errorf("could not get the list of the records: %v", err)
+	}
+
+	deleteForm, ok := findForm(page, func(f form) bool {
+		m := dnsDeleteLinkRegexp.FindStringSubmatch(f.action)
+		return m != nil && m[1] == domainID && m[2] == recordID
+	})
+	if !ok {
+		return fmt.Errorf("could not find the form deleting the record %s", recordID)
+	}
+
+	if _, _, err = deleteForm.csrfToken(); err != nil {
+		return fmt.Errorf("could not find the CSRF token of the form deleting a record: %v", errPageLayoutChanged)
+	}
+
+	_, _, err = s.submit(pageURL, deleteForm, nil)
+	return err
+}
+
+// get returns the page of the path, and its URL after the redirections.
+func (s *session) get(path string) (string, *url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+path, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.do(req)
+}
+
+// submit submits the form of the page with its hidden inputs and the values.
+func (s *session) submit(pageURL *url.URL, f form, values url.Values) (string, *url.URL, error) {
+	action, err := pageURL.Parse(f.action)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid form action %q: %v", f.action, err)
+	}
+
+	data := url.Values{}
+	for name, v := range f.hidden {
+		data[name] = v
+	}
+	for name, v := range values {
+		data[name] = v
+	}
+
+	var req *http.Request
+	if f.method == http.MethodGet {
+		action.RawQuery = data.Encode()
+		req, err = http.NewRequest(http.MethodGet, action.String(), nil)
+	} else {
+		req, err = http.NewRequest(f.method, action.String(), strings.NewReader(data.Encode()))
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if req.Body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return s.do(req)
+}
+
+func (s *session) do(req *http.Request) (string, *url.URL, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read the page (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", nil, fmt.Errorf("unexpected HTTP status %d for %s", resp.StatusCode, resp.Request.URL.Path)
+	}
+
+	return string(raw), resp.Request.URL, nil
+}
+
+// findForm returns the first form of the page matching.
+func findForm(page string, match func(form) bool) (form, bool) {
+	for _, f := range parseForms(page) {
+		if match(f) {
+			return f, true
+		}
+	}
+	return form{}, false
+}
+
+func containsField(fields []string, value string) bool {
+	for _, field := range fields {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
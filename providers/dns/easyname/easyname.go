@@ -0,0 +1,193 @@
+// Package easyname implements a DNS provider for solving the DNS-01 challenge using easyname.
+// easyname has no API: its web interface is used, logging in and submitting its forms.
+package easyname
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the web interface to use.
+const defaultBaseURL = "https://my.easyname.com"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Email              string
+	Password           string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("EASYNAME_PROPAGATION_TIMEOUT", 10*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("EASYNAME_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("EASYNAME_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("EASYNAME_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// addedRecord is a record added by Present, with its domain.
+type addedRecord struct {
+	domainID string
+	recordID string
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the web interface of easyname to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// records are the added records, by fqdn and value.
+	records   map[string]addedRecord
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for easyname.
+// Credentials must be passed in the environment variables:
+// EASYNAME_EMAIL and EASYNAME_PASSWORD.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("EASYNAME_EMAIL", "EASYNAME_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("easyname: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Email = values["EASYNAME_EMAIL"]
+	config.Password = values["EASYNAME_PASSWORD"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for easyname.
+func NewDNSProviderCredentials(email, password string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.Email = email
+	config.Password = password
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for easyname.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("easyname: the configuration of the DNS provider is nil")
+	}
+
+	if config.Email == "" || config.Password == "" {
+		return nil, errors.New("easyname: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{
+		config:  config,
+		records: make(map[string]addedRecord),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return fmt.Errorf("easyname: %v", err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	rec := record{
+		Name:    extractRecordName(fqdn, zone),
+		Type:    "TXT",
+		Content: value,
+		TTL:     d.config.TTL,
+	}
+
+	s, err := d.login()
+	if err != nil {
+		return fmt.Errorf("easyname: %v", err)
+	}
+
+	domainID, err := s.findDomainID(zone)
+	if err != nil {
+		return fmt.Errorf("easyname: %v", err)
+	}
+
+	if err = s.addRecord(domainID, rec); err != nil {
+		return fmt.Errorf("easyname: failed to add the record: %v", err)
+	}
+
+	// the form gives no ID back: the record is looked for in the list of the records of the domain.
+	recordID, err := s.findRecordID(domainID, rec, acme.UnFqdn(fqdn))
+	if err != nil {
+		return fmt.Errorf("easyname: the record was not added: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = addedRecord{domainID: domainID, recordID: recordID}
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordsMu.Lock()
+	added, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("easyname: unknown record for %q", fqdn)
+	}
+
+	s, err := d.login()
+	if err != nil {
+		return fmt.Errorf("easyname: %v", err)
+	}
+
+	if err = s.deleteRecord(added.domainID, added.recordID); err != nil {
+		return fmt.Errorf("easyname: failed to delete the record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// extractRecordName returns the name of the record of the fqdn relative to the domain, "@" for the domain itself.
+func extractRecordName(fqdn, domain string) string {
+	name := acme.UnFqdn(fqdn)
+	if name == domain {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
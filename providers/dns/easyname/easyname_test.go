@@ -0,0 +1,305 @@
+package easyname
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest        bool
+	envTestEmail    string
+	envTestPassword string
+	envTestDomain   string
+)
+
+func init() {
+	envTestEmail = os.Getenv("EASYNAME_EMAIL")
+	envTestPassword = os.Getenv("EASYNAME_PASSWORD")
+	envTestDomain = os.Getenv("EASYNAME_DOMAIN")
+	liveTest = len(envTestEmail) > 0 && len(envTestPassword) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("EASYNAME_EMAIL", envTestEmail)
+	os.Setenv("EASYNAME_PASSWORD", envTestPassword)
+}
+
+// mockServer is the web interface of easyname, serving the recorded pages of testdata for the domain example.com.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	added    bool
+	form     map[string]string
+	requests []string
+}
+
+func (s *mockServer) serveFixture(w http.ResponseWriter, name string) {
+	page, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(s.t, err)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	require.NoError(s.t, r.ParseForm())
+
+	if r.URL.Path == "/en/login" {
+		if r.Method == http.MethodGet {
+			s.serveFixture(w, "login.html")
+			return
+		}
+
+		if r.PostForm.Get("loginxtoken") != "7f3c1a9e2b" ||
+			r.PostForm.Get("emailAddress") != "user@example.com" || r.PostForm.Get("password") != "secret" {
+			s.serveFixture(w, "login_failed.html")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3ss10n", Path: "/"})
+		http.Redirect(w, r, "/en/domain", http.StatusFound)
+		return
+	}
+
+	if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "s3ss10n" {
+		http.Redirect(w, r, "/en/login", http.StatusFound)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /en/domain":
+		s.serveFixture(w, "domains.html")
+	case "GET /en/domain/dns/index/domain/1234":
+		if s.added {
+			s.serveFixture(w, "dns_index.html")
+		} else {
+			s.serveFixture(w, "dns_index_empty.html")
+		}
+	case "GET /en/domain/dns/create/domain/1234":
+		s.serveFixture(w, "dns_create.html")
+	case "POST /en/domain/dns/create/domain/1234":
+		if r.PostForm.Get("token") != "c0ffee4711" {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		s.form = map[string]string{}
+		for name := range r.PostForm {
+			s.form[name] = r.PostForm.Get(name)
+		}
+		s.added = true
+		http.Redirect(w, r, "/en/domain/dns/index/domain/1234", http.StatusFound)
+	case "POST /en/domain/dns/delete/domain/1234/id/3003":
+		if r.PostForm.Get("token") != "d3adb33f" {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		s.added = false
+		http.Redirect(w, r, "/en/domain/dns/index/domain/1234", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("EASYNAME_EMAIL", "user@example.com")
+	os.Setenv("EASYNAME_PASSWORD", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("EASYNAME_EMAIL", "")
+	os.Setenv("EASYNAME_PASSWORD", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "easyname: some credentials information are missing: EASYNAME_EMAIL,EASYNAME_PASSWORD")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "easyname: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderCredentials("user@example.com", "")
+	assert.EqualError(t, err, "easyname: credentials missing")
+}
+
+func TestParseForms(t *testing.T) {
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "dns_create.html"))
+	require.NoError(t, err)
+
+	forms := parseForms(string(page))
+	require.Len(t, forms, 1)
+
+	assert.Equal(t, "/en/domain/dns/create/domain/1234", forms[0].action)
+	assert.Equal(t, "POST", forms[0].method)
+	assert.Equal(t, map[string]string{
+		"token":    "hidden",
+		"name":     "text",
+		"type":     "select",
+		"content":  "textarea",
+		"priority": "number",
+		"ttl":      "number",
+	}, forms[0].inputs)
+
+	name, value, err := forms[0].csrfToken()
+	require.NoError(t, err)
+	assert.Equal(t, "token", name)
+	assert.Equal(t, "c0ffee4711", value)
+
+	_, _, err = form{}.csrfToken()
+	assert.Error(t, err)
+}
+
+func TestTextFields(t *testing.T) {
+	fields := textFields(`<td>_acme-challenge</td><td>TXT</td><td>&quot;value&quot;</td>`)
+	assert.Equal(t, []string{"_acme-challenge", "TXT", `"value"`}, fields)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Email = "user@example.com"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.True(t, server.added)
+	assert.Equal(t, map[string]string{
+		"token":    "c0ffee4711",
+		"name":     "_acme-challenge.www",
+		"type":     "TXT",
+		"content":  "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		"priority": "0",
+		"ttl":      "300",
+	}, server.form)
+	assert.Equal(t, addedRecord{domainID: "1234", recordID: "3003"},
+		provider.records["_acme-challenge.www.example.com. pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.False(t, server.added)
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSProvider_PresentUnknownDomain(t *testing.T) {
+	defer tester.MockZones(t, "example.net.")()
+
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Email = "user@example.com"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.net", "", "keyAuth")
+	assert.EqualError(t, err, "easyname: could not find the domain example.net in the list of the domains")
+	assert.False(t, server.added)
+}
+
+func TestDNSProvider_LoginFailed(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Email = "user@example.com"
+	config.Password = "invalid"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, "easyname: could not log in: the email address or the password was rejected")
+}
+
+func TestDNSProvider_LayoutChanged(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form action="/en/login" method="post"><input type="password" name="password"></form></body></html>`))
+	}))
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Email = "user@example.com"
+	config.Password = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, err = provider.login()
+	assert.EqualError(t, err, "could not find the CSRF token of the login form: the page layout may have changed")
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Email = "user@example.com"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, `easyname: unknown record for "_acme-challenge.www.example.com."`)
+	assert.Empty(t, server.requests)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
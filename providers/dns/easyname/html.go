@@ -0,0 +1,131 @@
+package easyname
+
+import (
+	"errors"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The pages are scraped with regular expressions selecting the elements on their stable attributes
+// (form inputs, types, actions and links), not on their layout.
+var (
+	formRegexp  = regexp.MustCompile(`(?is)<form\b([^>]*)>(.*?)</form>`)
+	inputRegexp = regexp.MustCompile(`(?is)<(?:input|select|textarea)\b([^>]*)>`)
+	linkRegexp  = regexp.MustCompile(`(?is)<a\b([^>]*)>(.*?)</a>`)
+	rowRegexp   = regexp.MustCompile(`(?is)<tr\b[^>]*>(.*?)</tr>`)
+	attrRegexp  = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	tagRegexp   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// form is a form of a page.
+type form struct {
+	action string
+	method string
+	// hidden are the values of the hidden inputs, among them the CSRF token.
+	hidden url.Values
+	// inputs are the types of the inputs by name, "select" and "textarea" for these elements.
+	inputs map[string]string
+}
+
+// hasInputType reports whether the form has an input of the type.
+func (f form) hasInputType(inputType string) bool {
+	for _, t := range f.inputs {
+		if t == inputType {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfToken returns the name and the value of the hidden input holding the CSRF token of the form.
+func (f form) csrfToken() (string, string, error) {
+	for name := range f.hidden {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "token") || strings.Contains(lower, "csrf") {
+			if value := f.hidden.Get(name); value != "" {
+				return name, value, nil
+			}
+		}
+	}
+	return "", "", errors.New("no CSRF token in the form")
+}
+
+// parseAttributes returns the attributes of a tag, by lower case name.
+func parseAttributes(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRegexp.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+// parseForms returns the forms of the page.
+func parseForms(page string) []form {
+	var forms []form
+	for _, m := range formRegexp.FindAllStringSubmatch(page, -1) {
+		attrs := parseAttributes(m[1])
+
+		f := form{
+			action: attrs["action"],
+			method: strings.ToUpper(attrs["method"]),
+			hidden: url.Values{},
+			inputs: make(map[string]string),
+		}
+		if f.method == "" {
+			f.method = "GET"
+		}
+
+		for _, input := range inputRegexp.FindAllStringSubmatch(m[2], -1) {
+			inputAttrs := parseAttributes(input[1])
+			name := inputAttrs["name"]
+			if name == "" {
+				continue
+			}
+
+			inputType := strings.ToLower(inputAttrs["type"])
+			switch tag := strings.ToLower(input[0][1:]); {
+			case strings.HasPrefix(tag, "select"):
+				inputType = "select"
+			case strings.HasPrefix(tag, "textarea"):
+				inputType = "textarea"
+			case inputType == "":
+				inputType = "text"
+			}
+
+			f.inputs[name] = inputType
+			if inputType == "hidden" {
+				f.hidden.Add(name, inputAttrs["value"])
+			}
+		}
+
+		forms = append(forms, f)
+	}
+	return forms
+}
+
+// parseRows returns the table rows of the page.
+func parseRows(page string) []string {
+	var rows []string
+	for _, m := range rowRegexp.FindAllStringSubmatch(page, -1) {
+		rows = append(rows, m[1])
+	}
+	return rows
+}
+
+// parseLinks returns the targets of the links of the page.
+func parseLinks(page string) []string {
+	var links []string
+	for _, m := range linkRegexp.FindAllStringSubmatch(page, -1) {
+		if href := parseAttributes(m[1])["href"]; href != "" {
+			links = append(links, href)
+		}
+	}
+	return links
+}
+
+// textFields returns the words of the text of the HTML fragment, without the tags.
+func textFields(fragment string) []string {
+	return strings.Fields(html.UnescapeString(tagRegexp.ReplaceAllString(fragment, " ")))
+}
@@ -17,7 +17,15 @@ It will then call the program './update-dns.sh' with like this:
 	./update-dns.sh "present" "_acme-challenge.foo.example.com." "MsijOYZxqyjGnFGwhjrhfg-Xgbl5r68WPda0J9EgqqI" "120"
 
 The program then needs to make sure the record is inserted.
-When it returns an error via a non-zero exit code, lego aborts.
+When it returns an error via a non-zero exit code, lego aborts
+and reports the output (stdout and stderr) of the program in the error.
+
+The parameters are also passed in the environment of the program:
+`LEGO_DOMAIN`, `LEGO_TOKEN` and `LEGO_KEY_AUTH` for the challenge,
+`LEGO_FQDN` and `LEGO_VALUE` for the record.
+
+The propagation of the record is checked by lego for `EXEC_PROPAGATION_TIMEOUT` seconds (default 60),
+every `EXEC_POLLING_INTERVAL` seconds (default 2).
 
 When the record is to be removed again,
 the program is called with the first command-line parameter set to "cleanup" instead of "present".
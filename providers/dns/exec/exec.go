@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/log"
@@ -15,7 +17,19 @@ import (
 // Config Provider configuration.
 type Config struct {
 	Program string
-	Mode    string
+	// Mode is "RAW" to pass the domain, token and key authorization to the program
+	// instead of the fqdn, value and TTL of the record.
+	Mode               string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		PropagationTimeout: env.GetOrDefaultSecond("EXEC_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("EXEC_POLLING_INTERVAL", 2*time.Second),
+	}
 }
 
 // DNSProvider adds and removes the record for the DNS challenge by calling a
@@ -32,17 +46,22 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("exec: %v", err)
 	}
 
-	return NewDNSProviderConfig(&Config{
-		Program: values["EXEC_PATH"],
-		Mode:    os.Getenv("EXEC_MODE"),
-	})
+	config := NewDefaultConfig()
+	config.Program = values["EXEC_PATH"]
+	config.Mode = os.Getenv("EXEC_MODE")
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderConfig returns a new DNS provider which runs the given configuration
 // for adding and removing the DNS record.
 func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 	if config == nil {
-		return nil, errors.New("the configuration is nil")
+		return nil, errors.New("exec: the configuration of the DNS provider is nil")
+	}
+
+	if config.Program == "" {
+		return nil, errors.New("exec: the program is undefined")
 	}
 
 	return &DNSProvider{config: config}, nil
@@ -56,45 +75,60 @@ func NewDNSProviderProgram(program string) (*DNSProvider, error) {
 		return nil, errors.New("the program is undefined")
 	}
 
-	return NewDNSProviderConfig(&Config{Program: program})
+	config := NewDefaultConfig()
+	config.Program = program
+
+	return NewDNSProviderConfig(config)
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	var args []string
-	if d.config.Mode == "RAW" {
-		args = []string{"present", "--", domain, token, keyAuth}
-	} else {
-		fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-		args = []string{"present", fqdn, value, strconv.Itoa(ttl)}
-	}
-
-	cmd := exec.Command(d.config.Program, args...)
-
-	output, err := cmd.CombinedOutput()
-	if len(output) > 0 {
-		log.Println(string(output))
-	}
-
-	return err
+	return d.run("present", domain, token, keyAuth)
 }
 
 // CleanUp removes the TXT record matching the specified parameters
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return d.run("cleanup", domain, token, keyAuth)
+}
+
+// run calls the program for the action. The parameters of the challenge and of the record
+// are also passed in the environment: LEGO_DOMAIN, LEGO_TOKEN, LEGO_KEY_AUTH, LEGO_FQDN and LEGO_VALUE.
+func (d *DNSProvider) run(action, domain, token, keyAuth string) error {
+	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+
 	var args []string
 	if d.config.Mode == "RAW" {
-		args = []string{"cleanup", "--", domain, token, keyAuth}
+		args = []string{action, "--", domain, token, keyAuth}
 	} else {
-		fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-		args = []string{"cleanup", fqdn, value, strconv.Itoa(ttl)}
+		args = []string{action, fqdn, value, strconv.Itoa(ttl)}
 	}
 
 	cmd := exec.Command(d.config.Program, args...)
+	cmd.Env = append(os.Environ(),
+		"LEGO_DOMAIN="+domain,
+		"LEGO_TOKEN="+token,
+		"LEGO_KEY_AUTH="+keyAuth,
+		"LEGO_FQDN="+fqdn,
+		"LEGO_VALUE="+value,
+	)
 
 	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(output) == 0 {
+			return fmt.Errorf("exec: %s %s failed: %v", d.config.Program, action, err)
+		}
+		return fmt.Errorf("exec: %s %s failed: %v: %s", d.config.Program, action, err, strings.TrimSpace(string(output)))
+	}
+
 	if len(output) > 0 {
-		log.Println(string(output))
+		log.Debugf("exec: %s", output)
 	}
 
-	return err
+	return nil
 }
@@ -0,0 +1,157 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testScript records its arguments and the challenge environment, one per line, in the file $OUTPUT,
+// and fails with its arguments on stderr if the first one is FAIL_ACTION.
+const testScript = `#!/bin/sh
+for arg in "$@"; do echo "$arg" >> "$OUTPUT"; done
+echo "$LEGO_DOMAIN $LEGO_TOKEN $LEGO_KEY_AUTH $LEGO_FQDN $LEGO_VALUE" >> "$OUTPUT"
+if [ "$1" = "$FAIL_ACTION" ]; then
+	echo "could not $1 the record $2" >&2
+	exit 3
+fi
+echo "done"
+`
+
+func setupScript(t *testing.T) (string, string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test script requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "lego-exec")
+	require.NoError(t, err)
+
+	script := filepath.Join(dir, "update-dns.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte(testScript), 0700))
+
+	output := filepath.Join(dir, "output")
+	os.Setenv("OUTPUT", output)
+
+	return script, output, func() {
+		os.Unsetenv("OUTPUT")
+		os.Unsetenv("FAIL_ACTION")
+		os.RemoveAll(dir)
+	}
+}
+
+func readLines(t *testing.T, file string) []string {
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(file))
+
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	defer func() {
+		os.Unsetenv("EXEC_PATH")
+		os.Unsetenv("EXEC_MODE")
+		os.Unsetenv("EXEC_PROPAGATION_TIMEOUT")
+		os.Unsetenv("EXEC_POLLING_INTERVAL")
+	}()
+
+	os.Setenv("EXEC_PATH", "")
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "exec: some credentials information are missing: EXEC_PATH")
+
+	os.Setenv("EXEC_PATH", "./update-dns.sh")
+	os.Setenv("EXEC_MODE", "RAW")
+	os.Setenv("EXEC_PROPAGATION_TIMEOUT", "300")
+	os.Setenv("EXEC_POLLING_INTERVAL", "10")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, &Config{Program: "./update-dns.sh", Mode: "RAW", PropagationTimeout: 300 * time.Second, PollingInterval: 10 * time.Second}, provider.config)
+
+	timeout, interval := provider.Timeout()
+	assert.Equal(t, 300*time.Second, timeout)
+	assert.Equal(t, 10*time.Second, interval)
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "exec: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(&Config{})
+	assert.EqualError(t, err, "exec: the program is undefined")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	script, output, tearDown := setupScript(t)
+	defer tearDown()
+
+	config := NewDefaultConfig()
+	config.Program = script
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	env := "example.com token keyAuth _acme-challenge.example.com. pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"
+
+	err = provider.Present("example.com", "token", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"present", "_acme-challenge.example.com.", "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", "120", env}, readLines(t, output))
+
+	err = provider.CleanUp("example.com", "token", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cleanup", "_acme-challenge.example.com.", "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", "120", env}, readLines(t, output))
+}
+
+func TestDNSProvider_PresentRawMode(t *testing.T) {
+	script, output, tearDown := setupScript(t)
+	defer tearDown()
+
+	config := NewDefaultConfig()
+	config.Program = script
+	config.Mode = "RAW"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "-token", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"present", "--", "example.com", "-token", "keyAuth",
+		"example.com -token keyAuth _acme-challenge.example.com. pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM"}, readLines(t, output))
+}
+
+func TestDNSProvider_PresentFailure(t *testing.T) {
+	script, _, tearDown := setupScript(t)
+	defer tearDown()
+	os.Setenv("FAIL_ACTION", "present")
+
+	config := NewDefaultConfig()
+	config.Program = script
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "token", "keyAuth")
+	assert.EqualError(t, err, "exec: "+script+" present failed: exit status 3: could not present the record _acme-challenge.example.com.")
+
+	err = provider.CleanUp("example.com", "token", "keyAuth")
+	assert.NoError(t, err)
+}
+
+func TestDNSProvider_PresentMissingProgram(t *testing.T) {
+	config := NewDefaultConfig()
+	config.Program = "/nonexistent/update-dns.sh"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "token", "keyAuth")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exec: /nonexistent/update-dns.sh present failed: ")
+}
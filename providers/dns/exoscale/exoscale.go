@@ -5,16 +5,49 @@ package exoscale
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/exoscale/egoscale"
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// defaultEndpoint is the endpoint of the DNS API of Exoscale.
+const defaultEndpoint = "https://api.exoscale.ch/dns"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey    string
+	APISecret string
+	// Endpoint is the DNS API endpoint, e.g. for another region than the default one.
+	Endpoint           string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		Endpoint:           defaultEndpoint,
+		PropagationTimeout: env.GetOrDefaultSecond("EXOSCALE_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("EXOSCALE_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("EXOSCALE_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("EXOSCALE_HTTP_TIMEOUT", 60*time.Second)),
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface.
 type DNSProvider struct {
+	config *Config
 	client *egoscale.Client
+	// recordIDs are the IDs of the created records, by fqdn and value.
+	recordIDs   map[string]int64
+	recordIDsMu sync.Mutex
 }
 
 // NewDNSProvider Credentials must be passed in the environment variables:
@@ -25,82 +58,113 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("Exoscale: %v", err)
 	}
 
-	endpoint := os.Getenv("EXOSCALE_ENDPOINT")
-	return NewDNSProviderClient(values["EXOSCALE_API_KEY"], values["EXOSCALE_API_SECRET"], endpoint)
+	config := NewDefaultConfig()
+	config.APIKey = values["EXOSCALE_API_KEY"]
+	config.APISecret = values["EXOSCALE_API_SECRET"]
+	if endpoint := os.Getenv("EXOSCALE_ENDPOINT"); endpoint != "" {
+		config.Endpoint = endpoint
+	}
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderClient Uses the supplied parameters to return a DNSProvider instance
 // configured for Exoscale.
 func NewDNSProviderClient(key, secret, endpoint string) (*DNSProvider, error) {
-	if key == "" || secret == "" {
+	config := NewDefaultConfig()
+	config.APIKey = key
+	config.APISecret = secret
+	if endpoint != "" {
+		config.Endpoint = endpoint
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Exoscale.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("Exoscale: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" || config.APISecret == "" {
 		return nil, fmt.Errorf("Exoscale credentials missing")
 	}
 
-	if endpoint == "" {
-		endpoint = "https://api.exoscale.ch/dns"
+	if config.Endpoint == "" {
+		config.Endpoint = defaultEndpoint
+	}
+
+	client := egoscale.NewClient(config.Endpoint, config.APIKey, config.APISecret)
+	if config.HTTPClient != nil {
+		client.HTTPClient = config.HTTPClient
 	}
 
 	return &DNSProvider{
-		client: egoscale.NewClient(endpoint, key, secret),
+		config:    config,
+		client:    client,
+		recordIDs: make(map[string]int64),
 	}, nil
 }
 
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
 // Present creates a TXT record to fulfil the dns-01 challenge.
+// The record is created next to the other TXT records of the same name, e.g. for a wildcard and its domain.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 	zone, recordName, err := d.FindZoneAndRecordName(fqdn, domain)
 	if err != nil {
 		return err
 	}
 
-	recordID, err := d.FindExistingRecordID(zone, recordName)
-	if err != nil {
-		return err
-	}
-
 	record := egoscale.DNSRecord{
 		Name:       recordName,
-		TTL:        ttl,
+		TTL:        d.config.TTL,
 		Content:    value,
 		RecordType: "TXT",
 	}
 
-	if recordID == 0 {
-		_, err := d.client.CreateRecord(zone, record)
-		if err != nil {
-			return errors.New("Error while creating DNS record: " + err.Error())
-		}
-	} else {
-		record.ID = recordID
-		_, err := d.client.UpdateRecord(zone, record)
-		if err != nil {
-			return errors.New("Error while updating DNS record: " + err.Error())
-		}
+	created, err := d.client.CreateRecord(zone, record)
+	if err != nil {
+		return errors.New("Error while creating DNS record: " + err.Error())
 	}
 
+	d.recordIDsMu.Lock()
+	d.recordIDs[fqdn+" "+value] = created.ID
+	d.recordIDsMu.Unlock()
+
 	return nil
 }
 
-// CleanUp removes the record matching the specified parameters.
+// CleanUp removes the record created by Present, by its ID.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
-	zone, recordName, err := d.FindZoneAndRecordName(fqdn, domain)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, _, err := d.FindZoneAndRecordName(fqdn, domain)
 	if err != nil {
 		return err
 	}
 
-	recordID, err := d.FindExistingRecordID(zone, recordName)
-	if err != nil {
-		return err
+	d.recordIDsMu.Lock()
+	recordID, ok := d.recordIDs[fqdn+" "+value]
+	d.recordIDsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Exoscale: unknown record ID for %q", fqdn)
 	}
 
-	if recordID != 0 {
-		err = d.client.DeleteRecord(zone, recordID)
-		if err != nil {
-			return errors.New("Error while deleting DNS record: " + err.Error())
-		}
+	if err = d.client.DeleteRecord(zone, recordID); err != nil {
+		return errors.New("Error while deleting DNS record: " + err.Error())
 	}
 
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn+" "+value)
+	d.recordIDsMu.Unlock()
+
 	return nil
 }
 
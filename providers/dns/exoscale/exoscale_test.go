@@ -1,11 +1,21 @@
 package exoscale
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/exoscale/egoscale"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
 )
 
 var (
@@ -27,6 +37,66 @@ func init() {
 func restoreEnv() {
 	os.Setenv("EXOSCALE_API_KEY", exoscaleAPIKey)
 	os.Setenv("EXOSCALE_API_SECRET", exoscaleAPISecret)
+	os.Unsetenv("EXOSCALE_ENDPOINT")
+}
+
+// mockServer is an Exoscale DNS API holding the records of the zone bar.com.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests []string
+	records  map[int64]egoscale.DNSRecord
+	lastID   int64
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	return &mockServer{t: t, records: make(map[int64]egoscale.DNSRecord)}
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	// the requests of the DNS API are authenticated by the key and the secret.
+	if r.Header.Get("X-DNS-TOKEN") != "key:secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"message": "Invalid API key or secret"}`)
+		return
+	}
+
+	const recordsPath = "/dns/v1/domains/bar.com/records"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == recordsPath:
+		assert.Equal(s.t, "application/json", r.Header.Get("Content-Type"))
+
+		var req egoscale.DNSRecordResponse
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&req))
+
+		s.lastID++
+		req.Record.ID = s.lastID
+		req.Record.DomainID = 1
+		s.records[req.Record.ID] = req.Record
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(req)
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, recordsPath+"/"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, recordsPath+"/"), 10, 64)
+		if _, ok := s.records[id]; err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Record not found"}`)
+			return
+		}
+		delete(s.records, id)
+		fmt.Fprint(w, `{}`)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not found"}`)
+	}
 }
 
 func TestNewDNSProviderValid(t *testing.T) {
@@ -56,7 +126,33 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	assert.EqualError(t, err, "Exoscale: some credentials information are missing: EXOSCALE_API_KEY,EXOSCALE_API_SECRET")
 }
 
+func TestNewDNSProviderEndpoint(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("EXOSCALE_API_KEY", "key")
+	os.Setenv("EXOSCALE_API_SECRET", "secret")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.exoscale.ch/dns", provider.client.Endpoint)
+
+	os.Setenv("EXOSCALE_ENDPOINT", "https://api.example.com/dns")
+
+	provider, err = NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/dns", provider.client.Endpoint)
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "Exoscale: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "Exoscale credentials missing")
+}
+
 func TestExtractRootRecordName(t *testing.T) {
+	defer tester.MockZones(t, "bar.com.")()
+
 	provider, err := NewDNSProviderClient("example@example.com", "123", "")
 	assert.NoError(t, err)
 
@@ -67,6 +163,8 @@ func TestExtractRootRecordName(t *testing.T) {
 }
 
 func TestExtractSubRecordName(t *testing.T) {
+	defer tester.MockZones(t, "bar.com.")()
+
 	provider, err := NewDNSProviderClient("example@example.com", "123", "")
 	assert.NoError(t, err)
 
@@ -76,32 +174,118 @@ func TestExtractSubRecordName(t *testing.T) {
 	assert.Equal(t, "_acme-challenge.foo", recordName)
 }
 
-func TestLiveExoscalePresent(t *testing.T) {
-	if !exoscaleLiveTest {
-		t.Skip("skipping live test")
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "bar.com.")()
+
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.APISecret = "secret"
+	config.Endpoint = ts.URL + "/dns"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	// the challenges of the domain and of its wildcard have records of the same name.
+	err = provider.Present("foo.bar.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.Present("foo.bar.com", "", "otherKeyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records, 2)
+	assert.Equal(t, egoscale.DNSRecord{
+		ID:         1,
+		DomainID:   1,
+		Name:       "_acme-challenge.foo",
+		TTL:        120,
+		Content:    "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		RecordType: "TXT",
+	}, server.records[1])
+	assert.Equal(t, "_acme-challenge.foo", server.records[2].Name)
+
+	err = provider.CleanUp("foo.bar.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// only the record of the cleaned up challenge is deleted.
+	require.Len(t, server.records, 1)
+	assert.Contains(t, server.records, int64(2))
+
+	err = provider.CleanUp("foo.bar.com", "", "otherKeyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records)
+	assert.Empty(t, provider.recordIDs)
+
+	expected := []string{
+		"POST /dns/v1/domains/bar.com/records",
+		"POST /dns/v1/domains/bar.com/records",
+		"DELETE /dns/v1/domains/bar.com/records/1",
+		"DELETE /dns/v1/domains/bar.com/records/2",
 	}
+	assert.Equal(t, expected, server.requests)
+}
 
-	provider, err := NewDNSProviderClient(exoscaleAPIKey, exoscaleAPISecret, "")
-	assert.NoError(t, err)
+func TestDNSProvider_InvalidCredentials(t *testing.T) {
+	defer tester.MockZones(t, "bar.com.")()
 
-	err = provider.Present(exoscaleDomain, "", "123d==")
-	assert.NoError(t, err)
+	server := newMockServer(t)
 
-	// Present Twice to handle create / update
-	err = provider.Present(exoscaleDomain, "", "123d==")
-	assert.NoError(t, err)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.APISecret = "other"
+	config.Endpoint = ts.URL + "/dns"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("foo.bar.com", "", "keyAuth")
+	assert.EqualError(t, err, "Error while creating DNS record: DNS error: Invalid API key or secret")
+	assert.Empty(t, server.records)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	defer tester.MockZones(t, "bar.com.")()
+
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.APISecret = "secret"
+	config.Endpoint = ts.URL + "/dns"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("foo.bar.com", "", "keyAuth")
+	assert.EqualError(t, err, `Exoscale: unknown record ID for "_acme-challenge.foo.bar.com."`)
+	assert.Empty(t, server.requests)
 }
 
-func TestLiveExoscaleCleanUp(t *testing.T) {
+func TestLivePresentAndCleanUp(t *testing.T) {
 	if !exoscaleLiveTest {
 		t.Skip("skipping live test")
 	}
 
-	time.Sleep(time.Second * 1)
-
 	provider, err := NewDNSProviderClient(exoscaleAPIKey, exoscaleAPISecret, "")
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
+	err = provider.Present(exoscaleDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	// the record is deleted by the ID captured on its creation.
 	err = provider.CleanUp(exoscaleDomain, "", "123d==")
-	assert.NoError(t, err)
+	require.NoError(t, err)
 }
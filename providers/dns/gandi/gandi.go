@@ -68,7 +68,7 @@ func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
 		apiKey:              apiKey,
 		inProgressFQDNs:     make(map[string]inProgressInfo),
 		inProgressAuthZones: make(map[string]struct{}),
-		client:              &http.Client{Timeout: 60 * time.Second},
+		client:              acme.NewHTTPClient(60 * time.Second),
 	}, nil
 }
 
@@ -5,6 +5,7 @@ package gandiv5
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -12,205 +13,273 @@ import (
 	"time"
 
 	"github.com/xenolf/lego/acme"
-	"github.com/xenolf/lego/log"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
-// Gandi API reference:       http://doc.livedns.gandi.net/
+// Gandi API reference: https://api.gandi.net/docs/livedns/
 
-var (
-	// endpoint is the Gandi API endpoint used by Present and
-	// CleanUp. It is overridden during tests.
-	endpoint = "https://dns.api.gandi.net/api/v5"
+// defaultBaseURL is the Gandi LiveDNS API endpoint.
+const defaultBaseURL = "https://api.gandi.net/v5/livedns"
 
-	// findZoneByFqdn determines the DNS zone of an fqdn. It is overridden
-	// during tests.
-	findZoneByFqdn = acme.FindZoneByFqdn
-)
+// minTTL is the lowest TTL accepted by Gandi for a record.
+const minTTL = 300
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	// APIKey is a Gandi personal access token.
+	APIKey             string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
 
-// inProgressInfo contains information about an in-progress challenge
-type inProgressInfo struct {
-	fieldName string
-	authZone  string
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("GANDIV5_PROPAGATION_TIMEOUT", 20*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("GANDIV5_POLLING_INTERVAL", 20*time.Second),
+		TTL:                env.GetOrDefaultInt("GANDIV5_TTL", minTTL),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("GANDIV5_HTTP_TIMEOUT", 10*time.Second)),
+	}
 }
 
 // DNSProvider is an implementation of the
 // acme.ChallengeProviderTimeout interface that uses Gandi's LiveDNS
 // API to manage TXT records for a domain.
 type DNSProvider struct {
-	apiKey          string
-	inProgressFQDNs map[string]inProgressInfo
-	inProgressMu    sync.Mutex
-	client          *http.Client
+	config *Config
+
+	// mu serializes the updates of the TXT records, as Gandi replaces a whole RRset at once.
+	mu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Gandi.
 // Credentials must be passed in the environment variable: GANDIV5_API_KEY.
+// The record TTL (in seconds), propagation timeout and polling interval can be set
+// with GANDIV5_TTL, GANDIV5_PROPAGATION_TIMEOUT and GANDIV5_POLLING_INTERVAL.
 func NewDNSProvider() (*DNSProvider, error) {
 	values, err := env.Get("GANDIV5_API_KEY")
 	if err != nil {
-		return nil, fmt.Errorf("GandiDNS: %v", err)
+		return nil, fmt.Errorf("gandiv5: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["GANDIV5_API_KEY"])
+	config := NewDefaultConfig()
+	config.APIKey = values["GANDIV5_API_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for Gandi.
 func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("Gandi DNS: No Gandi API Key given")
+	config := NewDefaultConfig()
+	config.APIKey = apiKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Gandi.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("gandiv5: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" {
+		return nil, errors.New("gandiv5: no API key given")
+	}
+
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("gandiv5: invalid TTL, TTL (%d) must be greater than %d", config.TTL, minTTL-1)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(10 * time.Second)
 	}
-	return &DNSProvider{
-		apiKey:          apiKey,
-		inProgressFQDNs: make(map[string]inProgressInfo),
-		client:          &http.Client{Timeout: 10 * time.Second},
-	}, nil
+
+	return &DNSProvider{config: config}, nil
 }
 
 // Present creates a TXT record using the specified parameters.
+// The value is added to the values of the record, if any.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-	if ttl < 300 {
-		ttl = 300 // 300 is gandi minimum value for ttl
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	zone, name, err := d.findDomain(fqdn)
+	if err != nil {
+		return err
 	}
 
-	// find authZone
-	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	rrset, err := d.getTXTRecord(zone, name)
 	if err != nil {
-		return fmt.Errorf("Gandi DNS: findZoneByFqdn failure: %v", err)
+		return err
 	}
 
-	// determine name of TXT record
-	if !strings.HasSuffix(
-		strings.ToLower(fqdn), strings.ToLower("."+authZone)) {
-		return fmt.Errorf(
-			"Gandi DNS: unexpected authZone %s for fqdn %s", authZone, fqdn)
+	for _, existing := range rrset.Values {
+		if existing == value {
+			return nil
+		}
 	}
-	name := fqdn[:len(fqdn)-len("."+authZone)]
 
-	// acquire lock and check there is not a challenge already in
-	// progress for this value of authZone
-	d.inProgressMu.Lock()
-	defer d.inProgressMu.Unlock()
+	return d.putTXTRecord(zone, name, append(rrset.Values, value), d.config.TTL)
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+// The other values of the record are kept.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	// add TXT record into authZone
-	err = d.addTXTRecord(acme.UnFqdn(authZone), name, value, ttl)
+	zone, name, err := d.findDomain(fqdn)
 	if err != nil {
 		return err
 	}
 
-	// save data necessary for CleanUp
-	d.inProgressFQDNs[fqdn] = inProgressInfo{
-		authZone:  authZone,
-		fieldName: name,
+	rrset, err := d.getTXTRecord(zone, name)
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-// CleanUp removes the TXT record matching the specified parameters.
-func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	var remaining []string
+	for _, existing := range rrset.Values {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
 
-	// acquire lock and retrieve authZone
-	d.inProgressMu.Lock()
-	defer d.inProgressMu.Unlock()
-	if _, ok := d.inProgressFQDNs[fqdn]; !ok {
-		// if there is no cleanup information then just return
+	if len(remaining) == len(rrset.Values) {
 		return nil
 	}
 
-	fieldName := d.inProgressFQDNs[fqdn].fieldName
-	authZone := d.inProgressFQDNs[fqdn].authZone
-	delete(d.inProgressFQDNs, fqdn)
+	if len(remaining) == 0 {
+		return d.deleteTXTRecord(zone, name)
+	}
 
-	// delete TXT record from authZone
-	return d.deleteTXTRecord(acme.UnFqdn(authZone), fieldName)
+	return d.putTXTRecord(zone, name, remaining, rrset.TTL)
 }
 
-// Timeout returns the values (20*time.Minute, 20*time.Second) which
-// are used by the acme package as timeout and check interval values
-// when checking for DNS record propagation with Gandi.
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return 20 * time.Minute, 20 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
-// types for JSON method calls and parameters
+// types for JSON method calls and responses
 
-type addFieldRequest struct {
-	RRSetTTL    int      `json:"rrset_ttl"`
-	RRSetValues []string `json:"rrset_values"`
+type rrset struct {
+	TTL    int      `json:"rrset_ttl,omitempty"`
+	Values []string `json:"rrset_values"`
 }
 
-type deleteFieldRequest struct {
-	Delete bool `json:"delete"`
+type apiError struct {
+	StatusCode int    `json:"code"`
+	Message    string `json:"message"`
+	Cause      string `json:"cause"`
 }
 
-// types for JSON responses
+func (e *apiError) Error() string {
+	return fmt.Sprintf("gandiv5: request failed with HTTP status code %d: %s %s", e.StatusCode, e.Cause, e.Message)
+}
 
-type responseStruct struct {
-	Message string `json:"message"`
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
 }
 
-// POSTing/Marshalling/Unmarshalling
+// findDomain returns the domain of the account managing the fqdn, stripping its labels
+// until a domain is found, and the name of the record in this domain.
+func (d *DNSProvider) findDomain(fqdn string) (string, string, error) {
+	labels := strings.Split(acme.UnFqdn(fqdn), ".")
 
-func (d *DNSProvider) sendRequest(method string, resource string, payload interface{}) (*responseStruct, error) {
-	url := fmt.Sprintf("%s/%s", endpoint, resource)
+	for i := 1; i < len(labels)-1; i++ {
+		domain := strings.Join(labels[i:], ".")
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+		err := d.do(http.MethodGet, "domains/"+domain, nil, nil)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		return domain, strings.Join(labels[:i], "."), nil
 	}
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	return "", "", fmt.Errorf("gandiv5: no domain of the account matches %s", fqdn)
+}
+
+// getTXTRecord returns the TXT RRset of the name, empty if it doesn't exist.
+func (d *DNSProvider) getTXTRecord(domain, name string) (*rrset, error) {
+	var record rrset
+	err := d.do(http.MethodGet, fmt.Sprintf("domains/%s/records/%s/TXT", domain, name), nil, &record)
+	if isNotFound(err) {
+		return &rrset{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	if len(d.apiKey) > 0 {
-		req.Header.Set("X-Api-Key", d.apiKey)
+	return &record, nil
+}
+
+func (d *DNSProvider) putTXTRecord(domain, name string, values []string, ttl int) error {
+	return d.do(http.MethodPut, fmt.Sprintf("domains/%s/records/%s/TXT", domain, name), rrset{TTL: ttl, Values: values}, nil)
+}
+
+func (d *DNSProvider) deleteTXTRecord(domain, name string) error {
+	return d.do(http.MethodDelete, fmt.Sprintf("domains/%s/records/%s/TXT", domain, name), nil, nil)
+}
+
+func (d *DNSProvider) do(method, resource string, payload, result interface{}) error {
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(d.config.BaseURL, "/"), resource)
+
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
 	}
 
-	resp, err := d.client.Do(req)
+	req, err := http.NewRequest(method, url, &body)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("Gandi DNS: request failed with HTTP status code %d", resp.StatusCode)
-	}
-	var response responseStruct
-	err = json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil && method != http.MethodDelete {
-		return nil, err
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("Authorization", "Bearer "+d.config.APIKey)
 
-	return &response, nil
-}
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("gandiv5: %v", err)
+	}
+	defer resp.Body.Close()
 
-// functions to perform API actions
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &apiError{}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
 
-func (d *DNSProvider) addTXTRecord(domain string, name string, value string, ttl int) error {
-	target := fmt.Sprintf("domains/%s/records/%s/TXT", domain, name)
-	response, err := d.sendRequest(http.MethodPut, target, addFieldRequest{
-		RRSetTTL:    ttl,
-		RRSetValues: []string{value},
-	})
-	if response != nil {
-		log.Infof("Gandi DNS: %s", response.Message)
+	if result == nil {
+		return nil
 	}
-	return err
-}
 
-func (d *DNSProvider) deleteTXTRecord(domain string, name string) error {
-	target := fmt.Sprintf("domains/%s/records/%s/TXT", domain, name)
-	response, err := d.sendRequest(http.MethodDelete, target, deleteFieldRequest{
-		Delete: true,
-	})
-	if response != nil && response.Message == "" {
-		log.Infof("Gandi DNS: Zone record deleted")
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("gandiv5: could not decode the response of %s %s: %v", method, resource, err)
 	}
-	return err
+	return nil
 }
@@ -1,73 +1,181 @@
 package gandiv5
 
 import (
-	"io"
-	"io/ioutil"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"regexp"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
 )
 
-// TestDNSProvider runs Present and CleanUp against a fake Gandi RPC
-// Server, whose responses are predetermined for particular requests.
-func TestDNSProvider(t *testing.T) {
-	fakeAPIKey := "123412341234123412341234"
-	fakeKeyAuth := "XXXX"
+var envTestAPIKey = os.Getenv("GANDIV5_API_KEY")
 
-	provider, err := NewDNSProviderCredentials(fakeAPIKey)
-	require.NoError(t, err)
+func restoreEnv() {
+	os.Setenv("GANDIV5_API_KEY", envTestAPIKey)
+	os.Unsetenv("GANDIV5_TTL")
+}
 
-	regexpToken, err := regexp.Compile(`"rrset_values":\[".+"\]`)
-	require.NoError(t, err)
+// fakeLiveDNS is a fake Gandi LiveDNS API serving the TXT RRsets of the domains of an account.
+type fakeLiveDNS struct {
+	t       *testing.T
+	mu      sync.Mutex
+	domains map[string]bool
+	// records are the values of the TXT RRsets by "domain/name".
+	records map[string][]string
+	// requests are the "METHOD path" of the received requests.
+	requests []string
+}
 
-	// start fake RPC server
-	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		require.Equal(t, "application/json", r.Header.Get("Content-Type"), "invalid content type")
+func (f *fakeLiveDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		req, err := ioutil.ReadAll(r.Body)
-		require.NoError(t, err)
+	assert.Equal(f.t, "Bearer secret", r.Header.Get("Authorization"))
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
 
-		req = regexpToken.ReplaceAllLiteral(req, []byte(`"rrset_values":["TOKEN"]`))
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/domains/"), "/")
+	if !f.domains[parts[0]] {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code": 404, "message": "Can't find the domain", "object": "domain", "cause": "Not Found"}`)
+		return
+	}
 
-		resp, ok := serverResponses[string(req)]
-		require.True(t, ok, "Server response for request not found")
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		fmt.Fprintf(w, `{"fqdn": %q}`, parts[0])
+	case len(parts) == 4 && parts[1] == "records" && parts[3] == "TXT":
+		key := parts[0] + "/" + parts[2]
+
+		switch r.Method {
+		case http.MethodGet:
+			values, ok := f.records[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"code": 404, "message": "Can't find the record", "cause": "Not Found"}`)
+				return
+			}
+			json.NewEncoder(w).Encode(rrset{TTL: 300, Values: values})
+		case http.MethodPut:
+			var record rrset
+			require.NoError(f.t, json.NewDecoder(r.Body).Decode(&record))
+			assert.Equal(f.t, 300, record.TTL)
+			f.records[key] = record.Values
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"message": "DNS Record Created"}`)
+		case http.MethodDelete:
+			delete(f.records, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
 
-		_, err = io.Copy(w, strings.NewReader(resp))
-		require.NoError(t, err)
-	}))
-	defer fakeServer.Close()
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GANDIV5_API_KEY", "")
 
-	// define function to override findZoneByFqdn with
-	fakeFindZoneByFqdn := func(fqdn string, nameserver []string) (string, error) {
-		return "example.com.", nil
-	}
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "gandiv5: some credentials information are missing: GANDIV5_API_KEY")
+}
+
+func TestNewDNSProviderInvalidTTL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GANDIV5_API_KEY", "secret")
+	os.Setenv("GANDIV5_TTL", "60")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "gandiv5: invalid TTL, TTL (60) must be greater than 299")
+}
 
-	// override gandi endpoint and findZoneByFqdn function
-	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
-	defer func() {
-		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
-	}()
+func TestDNSProvider_FindDomain(t *testing.T) {
+	fake := &fakeLiveDNS{t: t, domains: map[string]bool{"example.com": true}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
 
-	endpoint, findZoneByFqdn = fakeServer.URL, fakeFindZoneByFqdn
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
 
-	// run Present
-	err = provider.Present("abc.def.example.com", "", fakeKeyAuth)
+	provider, err := NewDNSProviderConfig(config)
 	require.NoError(t, err)
 
-	// run CleanUp
-	err = provider.CleanUp("abc.def.example.com", "", fakeKeyAuth)
+	domain, name, err := provider.findDomain("_acme-challenge.abc.def.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+	assert.Equal(t, "_acme-challenge.abc.def", name)
+
+	expected := []string{
+		"GET /domains/abc.def.example.com",
+		"GET /domains/def.example.com",
+		"GET /domains/example.com",
+	}
+	assert.Equal(t, expected, fake.requests)
+
+	_, _, err = provider.findDomain("_acme-challenge.example.org.")
+	assert.EqualError(t, err, "gandiv5: no domain of the account matches _acme-challenge.example.org.")
+}
+
+func TestDNSProvider_PresentMergesRRSet(t *testing.T) {
+	fake := &fakeLiveDNS{
+		t:       t,
+		domains: map[string]bool{"example.com": true},
+		records: map[string][]string{"example.com/_acme-challenge.abc": {"existing"}},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
 	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("abc.example.com", "keyAuth")
+	_, wildcardValue, _ := acme.DNS01Record("abc.example.com", "wildcardKeyAuth")
+
+	require.NoError(t, provider.Present("abc.example.com", "", "keyAuth"))
+	require.NoError(t, provider.Present("abc.example.com", "", "wildcardKeyAuth"))
+	assert.Equal(t, []string{"existing", value, wildcardValue}, fake.records["example.com/_acme-challenge.abc"])
+
+	require.NoError(t, provider.CleanUp("abc.example.com", "", "keyAuth"))
+	assert.Equal(t, []string{"existing", wildcardValue}, fake.records["example.com/_acme-challenge.abc"])
+
+	require.NoError(t, provider.CleanUp("abc.example.com", "", "wildcardKeyAuth"))
+	assert.Equal(t, []string{"existing"}, fake.records["example.com/_acme-challenge.abc"])
 }
 
-// serverResponses is the JSON Request->Response map used by the
-// fake JSON server.
-var serverResponses = map[string]string{
-	// Present Request->Response (addTXTRecord)
-	`{"rrset_ttl":300,"rrset_values":["TOKEN"]}`: `{"message": "Zone Record Created"}`,
-	// CleanUp Request->Response (deleteTXTRecord)
-	`{"delete":true}`: ``,
+func TestDNSProvider_CleanUpDeletesRRSet(t *testing.T) {
+	fake := &fakeLiveDNS{
+		t:       t,
+		domains: map[string]bool{"example.com": true},
+		records: map[string][]string{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("example.com", "keyAuth")
+
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	assert.Equal(t, []string{value}, fake.records["example.com/_acme-challenge"])
+
+	require.NoError(t, provider.CleanUp("example.com", "", "keyAuth"))
+	_, ok := fake.records["example.com/_acme-challenge"]
+	assert.False(t, ok, "Expected the RRset to be deleted")
+	assert.Equal(t, "DELETE /domains/example.com/records/_acme-challenge/TXT", fake.requests[len(fake.requests)-1])
 }
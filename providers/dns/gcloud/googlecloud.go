@@ -4,12 +4,16 @@ package gcloud
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 
+	"cloud.google.com/go/compute/metadata"
 	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
@@ -17,22 +21,58 @@ import (
 	"google.golang.org/api/dns/v1"
 )
 
+// metadataProjectID returns the project of the GCE instance lego runs on. It is overridden during tests.
+var metadataProjectID = func() (string, error) {
+	if !metadata.OnGCE() {
+		return "", errors.New("not running on GCE")
+	}
+	return metadata.ProjectID()
+}
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Project            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		PropagationTimeout: env.GetOrDefaultSecond("GCE_PROPAGATION_TIMEOUT", 180*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("GCE_POLLING_INTERVAL", 5*time.Second),
+		TTL:                env.GetOrDefaultInt("GCE_TTL", 120),
+	}
+}
+
 // DNSProvider is an implementation of the DNSProvider interface.
 type DNSProvider struct {
-	project string
-	client  *dns.Service
+	config *Config
+	client *dns.Service
 }
 
-// NewDNSProvider returns a DNSProvider instance configured for Google Cloud
-// DNS. Project name must be passed in the environment variable: GCE_PROJECT.
-// A Service Account file can be passed in the environment variable:
-// GCE_SERVICE_ACCOUNT_FILE
+// NewDNSProvider returns a DNSProvider instance configured for Google Cloud DNS.
+// A Service Account file can be passed in the environment variable GCE_SERVICE_ACCOUNT_FILE,
+// otherwise the application default credentials are used and the project name must be passed
+// in the environment variable GCE_PROJECT. If GCE_PROJECT is not set, the project is read from
+// the Service Account file pointed by GOOGLE_APPLICATION_CREDENTIALS, or from the metadata server
+// when running on GCE.
 func NewDNSProvider() (*DNSProvider, error) {
 	if saFile, ok := os.LookupEnv("GCE_SERVICE_ACCOUNT_FILE"); ok {
 		return NewDNSProviderServiceAccount(saFile)
 	}
 
 	project := os.Getenv("GCE_PROJECT")
+	if project == "" {
+		if saFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); saFile != "" {
+			return NewDNSProviderServiceAccount(saFile)
+		}
+
+		project, _ = metadataProjectID()
+	}
+
 	return NewDNSProviderCredentials(project)
 }
 
@@ -47,14 +87,12 @@ func NewDNSProviderCredentials(project string) (*DNSProvider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to get Google Cloud client: %v", err)
 	}
-	svc, err := dns.New(client)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create Google Cloud DNS service: %v", err)
-	}
-	return &DNSProvider{
-		project: project,
-		client:  svc,
-	}, nil
+
+	config := NewDefaultConfig()
+	config.Project = project
+	config.HTTPClient = client
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderServiceAccount uses the supplied service account JSON file to
@@ -77,29 +115,50 @@ func NewDNSProviderServiceAccount(saFile string) (*DNSProvider, error) {
 	if err != nil || datJSON.ProjectID == "" {
 		return nil, fmt.Errorf("project ID not found in Google Cloud Service Account file")
 	}
-	project := datJSON.ProjectID
 
 	conf, err := google.JWTConfigFromJSON(dat, dns.NdevClouddnsReadwriteScope)
 	if err != nil {
 		return nil, fmt.Errorf("unable to acquire config: %v", err)
 	}
-	client := conf.Client(context.Background())
 
-	svc, err := dns.New(client)
+	config := NewDefaultConfig()
+	config.Project = datJSON.ProjectID
+	config.HTTPClient = conf.Client(context.Background())
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Google Cloud DNS.
+// config.HTTPClient must be authenticated.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("googlecloud: the configuration of the DNS provider is nil")
+	}
+
+	if config.Project == "" {
+		return nil, fmt.Errorf("Google Cloud project name missing")
+	}
+
+	if config.HTTPClient == nil {
+		return nil, errors.New("googlecloud: unable to create Google Cloud DNS service: client is nil")
+	}
+
+	svc, err := dns.New(config.HTTPClient)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create Google Cloud DNS service: %v", err)
 	}
+
 	return &DNSProvider{
-		project: project,
-		client:  svc,
+		config: config,
+		client: svc,
 	}, nil
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	zone, err := d.getHostedZone(domain)
+	zone, err := d.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
@@ -107,7 +166,7 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	rec := &dns.ResourceRecordSet{
 		Name:    fqdn,
 		Rrdatas: []string{value},
-		Ttl:     int64(ttl),
+		Ttl:     int64(d.config.TTL),
 		Type:    "TXT",
 	}
 	change := &dns.Change{
@@ -124,16 +183,27 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 		change.Deletions = existing
 	}
 
-	chg, err := d.client.Changes.Create(d.project, zone, change).Do()
+	chg, err := d.client.Changes.Create(d.config.Project, zone, change).Do()
 	if err != nil {
 		return err
 	}
 
-	// wait for change to be acknowledged
-	for chg.Status == "pending" {
-		time.Sleep(time.Second)
+	return d.waitForChange(zone, chg)
+}
 
-		chg, err = d.client.Changes.Get(d.project, zone, chg.Id).Do()
+// waitForChange polls the status of the change set until it is done.
+func (d *DNSProvider) waitForChange(zone string, chg *dns.Change) error {
+	timeout := time.After(d.config.PropagationTimeout)
+
+	for chg.Status != "done" {
+		select {
+		case <-timeout:
+			return fmt.Errorf("googlecloud: the change %s is still %s after %v", chg.Id, chg.Status, d.config.PropagationTimeout)
+		case <-time.After(d.config.PollingInterval):
+		}
+
+		var err error
+		chg, err = d.client.Changes.Get(d.config.Project, zone, chg.Id).Do()
 		if err != nil {
 			return err
 		}
@@ -146,7 +216,7 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
 
-	zone, err := d.getHostedZone(domain)
+	zone, err := d.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
@@ -160,41 +230,43 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 		return nil
 	}
 
-	_, err = d.client.Changes.Create(d.project, zone, &dns.Change{Deletions: records}).Do()
+	_, err = d.client.Changes.Create(d.config.Project, zone, &dns.Change{Deletions: records}).Do()
 	return err
 }
 
 // Timeout customizes the timeout values used by the ACME package for checking
 // DNS record validity.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return 180 * time.Second, 5 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
-// getHostedZone returns the managed-zone
-func (d *DNSProvider) getHostedZone(domain string) (string, error) {
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+// getHostedZone returns the name of the managed zone holding the fqdn.
+func (d *DNSProvider) getHostedZone(fqdn string) (string, error) {
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
 		return "", err
 	}
 
 	zones, err := d.client.ManagedZones.
-		List(d.project).
+		List(d.config.Project).
 		DnsName(authZone).
 		Do()
 	if err != nil {
 		return "", fmt.Errorf("GoogleCloud API call failed: %v", err)
 	}
 
-	if len(zones.ManagedZones) == 0 {
-		return "", fmt.Errorf("no matching GoogleCloud domain found for domain %s", authZone)
+	for _, zone := range zones.ManagedZones {
+		if zone.DnsName == authZone {
+			return zone.Name, nil
+		}
 	}
 
-	return zones.ManagedZones[0].Name, nil
+	return "", fmt.Errorf("no matching GoogleCloud domain found for domain %s", authZone)
 }
 
 func (d *DNSProvider) findTxtRecords(zone, fqdn string) ([]*dns.ResourceRecordSet, error) {
 
-	recs, err := d.client.ResourceRecordSets.List(d.project, zone).Name(fqdn).Type("TXT").Do()
+	recs, err := d.client.ResourceRecordSets.List(d.config.Project, zone).Name(fqdn).Type("TXT").Do()
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,12 @@
 package gcloud
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -10,6 +16,8 @@ import (
 	"google.golang.org/api/dns/v1"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
 )
 
 var (
@@ -29,6 +37,128 @@ func init() {
 
 func restoreEnv() {
 	os.Setenv("GCE_PROJECT", gcloudProject)
+	os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+// withMetadataProject makes the metadata server report the given project, or no project if empty.
+func withMetadataProject(project string) func() {
+	saved := metadataProjectID
+	metadataProjectID = func() (string, error) {
+		if project == "" {
+			return "", errors.New("not running on GCE")
+		}
+		return project, nil
+	}
+	return func() { metadataProjectID = saved }
+}
+
+// fakeCloudDNS serves the managed zones and the TXT records of my-project,
+// the changes stay pending for the given number of polls.
+func fakeCloudDNS(t *testing.T, pendingPolls int, polls *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/my-project/managedZones":
+			assert.Equal(t, "example.com.", r.URL.Query().Get("dnsName"))
+			fmt.Fprint(w, `{"managedZones": [{"name": "other", "dnsName": "other.example.com."}, {"name": "example-com", "dnsName": "example.com."}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/my-project/managedZones/example-com/rrsets":
+			fmt.Fprint(w, `{"rrsets": []}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/my-project/managedZones/example-com/changes":
+			var change dns.Change
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&change))
+			require.Len(t, change.Additions, 1)
+			assert.Equal(t, "_acme-challenge.example.com.", change.Additions[0].Name)
+			fmt.Fprint(w, `{"id": "1", "status": "pending"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/my-project/managedZones/example-com/changes/1":
+			*polls++
+			if *polls <= pendingPolls {
+				fmt.Fprint(w, `{"id": "1", "status": "pending"}`)
+				return
+			}
+			fmt.Fprint(w, `{"id": "1", "status": "done"}`)
+		default:
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusNotFound)
+		}
+	}
+}
+
+func TestDNSProvider_PresentWaitsForChange(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	var polls int
+	server := httptest.NewServer(fakeCloudDNS(t, 2, &polls))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Project = "my-project"
+	config.HTTPClient = server.Client()
+	config.PropagationTimeout = time.Second
+	config.PollingInterval = 10 * time.Millisecond
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	provider.client.BasePath = server.URL + "/"
+
+	err = provider.Present("example.com", "", "123d==")
+	require.NoError(t, err)
+	assert.Equal(t, 3, polls)
+}
+
+func TestDNSProvider_PresentChangeTimeout(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	var polls int
+	server := httptest.NewServer(fakeCloudDNS(t, 1000, &polls))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Project = "my-project"
+	config.HTTPClient = server.Client()
+	config.PropagationTimeout = time.Second
+	config.PollingInterval = 10 * time.Millisecond
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	provider.client.BasePath = server.URL + "/"
+	provider.config.PropagationTimeout = 100 * time.Millisecond
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, "googlecloud: the change 1 is still pending after 100ms")
+}
+
+func TestNewDNSProviderProjectFromMetadata(t *testing.T) {
+	if _, err := google.DefaultClient(context.Background(), dns.NdevClouddnsReadwriteScope); err != nil {
+		t.Skip("skipping test (requires application default credentials)")
+	}
+
+	defer restoreEnv()
+	os.Setenv("GCE_PROJECT", "")
+	defer withMetadataProject("metadata-project")()
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "metadata-project", provider.config.Project)
+}
+
+func TestNewDNSProviderApplicationCredentials(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GCE_PROJECT", "")
+	defer withMetadataProject("")()
+
+	file, err := ioutil.TempFile("", "lego-gcloud")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{"type": "service_account", "project_id": "sa-project", "client_email": "lego@sa-project.iam.gserviceaccount.com", "private_key": "key"}`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file.Name())
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "sa-project", provider.config.Project)
 }
 
 func TestNewDNSProviderValid(t *testing.T) {
@@ -58,6 +188,7 @@ func TestNewDNSProviderValidEnv(t *testing.T) {
 func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	defer restoreEnv()
 	os.Setenv("GCE_PROJECT", "")
+	defer withMetadataProject("")()
 
 	_, err := NewDNSProvider()
 	assert.EqualError(t, err, "Google Cloud project name missing")
@@ -55,7 +55,7 @@ func NewDNSProviderCredentials(apiUser string, apiKey string) (*DNSProvider, err
 		apiUser:       apiUser,
 		apiKey:        apiKey,
 		activeRecords: make(map[string]int),
-		client:        &http.Client{Timeout: 10 * time.Second},
+		client:        acme.NewHTTPClient(10 * time.Second),
 	}, nil
 }
 
@@ -190,7 +190,7 @@ func (d *DNSProvider) addTXTRecord(fqdn string, domain string, name string, valu
 	})
 
 	if response != nil && response.Response.Status.Code == http.StatusOK {
-		log.Infof("[%s] GleSYS DNS: Successfully created record id %d", fqdn, response.Response.Record.RecordID)
+		log.Debugf("[%s] GleSYS DNS: Successfully created record id %d", fqdn, response.Response.Record.RecordID)
 		return response.Response.Record.RecordID, nil
 	}
 	return 0, err
@@ -201,7 +201,7 @@ func (d *DNSProvider) deleteTXTRecord(fqdn string, recordid int) error {
 		RecordID: recordid,
 	})
 	if response != nil && response.Response.Status.Code == 200 {
-		log.Infof("[%s] GleSYS DNS: Successfully deleted record id %d", fqdn, recordid)
+		log.Debugf("[%s] GleSYS DNS: Successfully deleted record id %d", fqdn, recordid)
 	}
 	return err
 }
@@ -47,7 +47,7 @@ func NewDNSProviderCredentials(apiKey, apiSecret string) (*DNSProvider, error) {
 	return &DNSProvider{
 		apiKey:    apiKey,
 		apiSecret: apiSecret,
-		client:    &http.Client{Timeout: 30 * time.Second},
+		client:    acme.NewHTTPClient(30 * time.Second),
 	}, nil
 }
 
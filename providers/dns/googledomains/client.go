@@ -0,0 +1,85 @@
+package googledomains
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// acmeTxtRecord is a TXT record of the challenge set of a root domain.
+type acmeTxtRecord struct {
+	Fqdn       string `json:"fqdn"`
+	Digest     string `json:"digest"`
+	UpdateTime string `json:"updateTime,omitempty"`
+}
+
+// rotateChallengesRequest atomically adds and removes TXT records of the challenge set.
+type rotateChallengesRequest struct {
+	AccessToken     string          `json:"accessToken"`
+	RecordsToAdd    []acmeTxtRecord `json:"recordsToAdd,omitempty"`
+	RecordsToRemove []acmeTxtRecord `json:"recordsToRemove,omitempty"`
+	// KeepExpiredRecords keeps the records older than 30 days, which are removed otherwise.
+	KeepExpiredRecords bool `json:"keepExpiredRecords"`
+}
+
+// acmeChallengeSet is the set of the TXT records of a root domain.
+type acmeChallengeSet struct {
+	Record []acmeTxtRecord `json:"record"`
+}
+
+// APIError is an error returned by the ACME DNS API.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s: %s", e.Code, e.Status, e.Message)
+}
+
+// rotateChallenges applies the change to the challenge set of the root domain.
+func (d *DNSProvider) rotateChallenges(rootDomain string, change rotateChallengesRequest) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/acmeChallengeSets/%s:rotateChallenges", d.config.BaseURL, url.PathEscape(rootDomain))
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error APIError `json:"error"`
+		}
+		if err = json.Unmarshal(raw, &errResp); err != nil || errResp.Error.Message == "" {
+			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		return errResp.Error
+	}
+
+	var set acmeChallengeSet
+	if err = json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Package googledomains implements a DNS provider for solving the DNS-01 challenge using the Google Domains ACME DNS API.
+// See https://developers.google.com/domains/acme-dns/reference/rest
+package googledomains
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://acmedns.googleapis.com"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	// AccessToken is the token of the ACME DNS API, used for the root domains without a token in AccessTokens.
+	AccessToken string
+	// AccessTokens are the tokens by root domain, as Google Domains issues one token per root domain.
+	AccessTokens       map[string]string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("GOOGLE_DOMAINS_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("GOOGLE_DOMAINS_POLLING_INTERVAL", 2*time.Second),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("GOOGLE_DOMAINS_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the ACME DNS API of Google Domains to manage the challenge set of a root domain.
+type DNSProvider struct {
+	config *Config
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Google Domains.
+// The access token must be passed in the environment variable GOOGLE_DOMAINS_ACCESS_TOKEN,
+// either as a single token or, for several root domains, as a comma separated list of
+// root domain and token pairs, e.g. "example.com:token1,example.org:token2".
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("GOOGLE_DOMAINS_ACCESS_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("googledomains: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.AccessToken, config.AccessTokens, err = parseAccessTokens(values["GOOGLE_DOMAINS_ACCESS_TOKEN"])
+	if err != nil {
+		return nil, fmt.Errorf("googledomains: %v", err)
+	}
+
+	if baseURL := os.Getenv("GOOGLE_DOMAINS_BASE_URL"); baseURL != "" {
+		config.BaseURL = baseURL
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied access token to return a
+// DNSProvider instance configured for Google Domains.
+func NewDNSProviderCredentials(accessToken string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.AccessToken = accessToken
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Google Domains.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("googledomains: the configuration of the DNS provider is nil")
+	}
+
+	if config.AccessToken == "" && len(config.AccessTokens) == 0 {
+		return nil, errors.New("googledomains: access token missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present adds the TXT record to the challenge set of the root domain,
+// keeping the records of the other pending challenges.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	rootDomain, accessToken, err := d.findRootDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("googledomains: %v", err)
+	}
+
+	change := rotateChallengesRequest{
+		AccessToken:  accessToken,
+		RecordsToAdd: []acmeTxtRecord{{Fqdn: acme.UnFqdn(fqdn), Digest: value}},
+	}
+
+	if err = d.rotateChallenges(rootDomain, change); err != nil {
+		return fmt.Errorf("googledomains: failed to add the TXT record: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record from the challenge set of the root domain.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	rootDomain, accessToken, err := d.findRootDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("googledomains: %v", err)
+	}
+
+	change := rotateChallengesRequest{
+		AccessToken:     accessToken,
+		RecordsToRemove: []acmeTxtRecord{{Fqdn: acme.UnFqdn(fqdn), Digest: value}},
+	}
+
+	if err = d.rotateChallenges(rootDomain, change); err != nil {
+		return fmt.Errorf("googledomains: failed to remove the TXT record: %v", err)
+	}
+	return nil
+}
+
+// findRootDomain returns the root domain of the fqdn, which scopes its challenge set, and its access token.
+func (d *DNSProvider) findRootDomain(fqdn string) (string, string, error) {
+	zone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("could not find the root domain of %q: %v", fqdn, err)
+	}
+
+	rootDomain := strings.ToLower(acme.UnFqdn(zone))
+
+	if accessToken, ok := d.config.AccessTokens[rootDomain]; ok {
+		return rootDomain, accessToken, nil
+	}
+	if d.config.AccessToken == "" {
+		return "", "", fmt.Errorf("no access token for the root domain %s", rootDomain)
+	}
+	return rootDomain, d.config.AccessToken, nil
+}
+
+// parseAccessTokens parses either a single token or a comma separated list
+// of "rootdomain:token" pairs.
+func parseAccessTokens(value string) (string, map[string]string, error) {
+	if !strings.Contains(value, ":") {
+		return strings.TrimSpace(value), nil, nil
+	}
+
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", nil, fmt.Errorf("invalid access token %q, must be rootdomain:token", pair)
+		}
+		tokens[strings.ToLower(acme.UnFqdn(parts[0]))] = parts[1]
+	}
+	return "", tokens, nil
+}
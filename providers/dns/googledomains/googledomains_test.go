@@ -0,0 +1,266 @@
+package googledomains
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest           bool
+	envTestAccessToken string
+	envTestDomain      string
+)
+
+func init() {
+	envTestAccessToken = os.Getenv("GOOGLE_DOMAINS_ACCESS_TOKEN")
+	envTestDomain = os.Getenv("GOOGLE_DOMAINS_DOMAIN")
+	liveTest = len(envTestAccessToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("GOOGLE_DOMAINS_ACCESS_TOKEN", envTestAccessToken)
+	os.Unsetenv("GOOGLE_DOMAINS_BASE_URL")
+}
+
+// mockServer is an ACME DNS API keeping the challenge set of each root domain.
+type mockServer struct {
+	t      *testing.T
+	mu     sync.Mutex
+	tokens map[string]string
+	sets   map[string][]acmeTxtRecord
+	calls  int
+}
+
+func newMockServer(t *testing.T, tokens map[string]string) *mockServer {
+	return &mockServer{t: t, tokens: tokens, sets: make(map[string][]acmeTxtRecord)}
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+
+	assert.Equal(s.t, http.MethodPost, r.Method)
+	assert.Equal(s.t, "application/json", r.Header.Get("Content-Type"))
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/acmeChallengeSets/")
+	if path == r.URL.Path || !strings.HasSuffix(path, ":rotateChallenges") {
+		http.NotFound(w, r)
+		return
+	}
+	rootDomain := strings.TrimSuffix(path, ":rotateChallenges")
+
+	var change rotateChallengesRequest
+	require.NoError(s.t, json.NewDecoder(r.Body).Decode(&change))
+
+	if token, ok := s.tokens[rootDomain]; !ok || token != change.AccessToken {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": 403, "message": "The caller does not have permission", "status": "PERMISSION_DENIED"}}`))
+		return
+	}
+
+	var set []acmeTxtRecord
+	for _, record := range s.sets[rootDomain] {
+		if !containsRecord(change.RecordsToRemove, record) {
+			set = append(set, record)
+		}
+	}
+	for _, record := range change.RecordsToAdd {
+		record.UpdateTime = "2021-01-01T00:00:00Z"
+		set = append(set, record)
+	}
+	s.sets[rootDomain] = set
+
+	json.NewEncoder(w).Encode(acmeChallengeSet{Record: set})
+}
+
+func containsRecord(records []acmeTxtRecord, record acmeTxtRecord) bool {
+	for _, r := range records {
+		if r.Fqdn == record.Fqdn && r.Digest == record.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GOOGLE_DOMAINS_ACCESS_TOKEN", "token")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "token", provider.config.AccessToken)
+	assert.Empty(t, provider.config.AccessTokens)
+}
+
+func TestNewDNSProviderTokensByRootDomain(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GOOGLE_DOMAINS_ACCESS_TOKEN", "example.com:token1, Example.org.:token2")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Empty(t, provider.config.AccessToken)
+	assert.Equal(t, map[string]string{"example.com": "token1", "example.org": "token2"}, provider.config.AccessTokens)
+
+	os.Setenv("GOOGLE_DOMAINS_ACCESS_TOKEN", "example.com:token1,token2")
+
+	_, err = NewDNSProvider()
+	assert.EqualError(t, err, `googledomains: invalid access token "token2", must be rootdomain:token`)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("GOOGLE_DOMAINS_ACCESS_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "googledomains: some credentials information are missing: GOOGLE_DOMAINS_ACCESS_TOKEN")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "googledomains: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "googledomains: access token missing")
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := newMockServer(t, map[string]string{"example.com": "token"})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessToken = "token"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// The records of a certificate spanning subdomains are appended to the challenge set of the root domain.
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []acmeTxtRecord{
+		{Fqdn: "_acme-challenge.example.com", Digest: "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", UpdateTime: "2021-01-01T00:00:00Z"},
+		{Fqdn: "_acme-challenge.www.example.com", Digest: "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM", UpdateTime: "2021-01-01T00:00:00Z"},
+	}
+	assert.Equal(t, expected, server.sets["example.com"])
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Equal(t, expected[1:], server.sets["example.com"])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.sets["example.com"])
+	assert.Equal(t, 4, server.calls)
+}
+
+func TestDNSProvider_TokensByRootDomain(t *testing.T) {
+	defer tester.MockZones(t, "example.com.", "example.org.")()
+
+	tokens := map[string]string{"example.com": "token1", "example.org": "token2"}
+	server := newMockServer(t, tokens)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessTokens = tokens
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.org", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Len(t, server.sets["example.com"], 1)
+	assert.Len(t, server.sets["example.org"], 1)
+
+	err = provider.CleanUp("www.example.org", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Len(t, server.sets["example.com"], 1)
+	assert.Empty(t, server.sets["example.org"])
+}
+
+func TestDNSProvider_MissingTokenForRootDomain(t *testing.T) {
+	defer tester.MockZones(t, "example.com.", "example.org.")()
+
+	server := newMockServer(t, map[string]string{"example.com": "token1"})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessTokens = map[string]string{"example.com": "token1"}
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.org", "", "keyAuth")
+	assert.EqualError(t, err, "googledomains: no access token for the root domain example.org")
+	assert.Zero(t, server.calls)
+}
+
+func TestDNSProvider_PermissionDenied(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := newMockServer(t, map[string]string{"example.com": "token"})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccessToken = "other"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "googledomains: failed to add the TXT record: API error (HTTP 403): PERMISSION_DENIED: The caller does not have permission")
+	assert.Empty(t, server.sets)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
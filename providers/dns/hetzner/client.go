@@ -0,0 +1,128 @@
+package hetzner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Zone represents a Hetzner DNS zone
+type Zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ZonesResponse represents a response of the zones endpoint
+type ZonesResponse struct {
+	Zones []Zone `json:"zones"`
+}
+
+// DNSRecord represents a Hetzner DNS record
+type DNSRecord struct {
+	ID     string `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl,omitempty"`
+	ZoneID string `json:"zone_id"`
+}
+
+// RecordResponse represents a response of the records endpoint
+type RecordResponse struct {
+	Record DNSRecord `json:"record"`
+}
+
+// getZoneID returns the ID of the zone with the given name, or an empty string if there is none.
+func (d *DNSProvider) getZoneID(name string) (string, error) {
+	query := url.Values{}
+	query.Set("name", name)
+
+	zones := &ZonesResponse{}
+	found, err := d.doRequest(http.MethodGet, "/zones?"+query.Encode(), nil, zones)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", nil
+	}
+
+	for _, zone := range zones.Zones {
+		if zone.Name == name {
+			return zone.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// createRecord creates the record and returns its ID.
+func (d *DNSProvider) createRecord(record DNSRecord) (string, error) {
+	resp := &RecordResponse{}
+	_, err := d.doRequest(http.MethodPost, "/records", record, resp)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Record.ID, nil
+}
+
+// deleteRecord deletes the record with the given ID.
+func (d *DNSProvider) deleteRecord(recordID string) error {
+	_, err := d.doRequest(http.MethodDelete, "/records/"+recordID, nil, nil)
+	return err
+}
+
+// doRequest sends the request to the Hetzner DNS API and decodes the response into result.
+// It reports false if the requested resource does not exist.
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) (bool, error) {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, reqBody)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Auth-API-Token", d.config.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return false, fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+
+	if result == nil || len(raw) == 0 {
+		return true, nil
+	}
+
+	err = json.Unmarshal(raw, result)
+	if err != nil {
+		return false, fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+	return true, nil
+}
@@ -0,0 +1,176 @@
+// Package hetzner implements a DNS provider for solving the DNS-01 challenge using Hetzner DNS.
+// See https://dns.hetzner.com/api-docs
+package hetzner
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://dns.hetzner.com/api/v1"
+
+// minTTL is the lowest TTL accepted by Hetzner DNS for a record.
+const minTTL = 60
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey             string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("HETZNER_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("HETZNER_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("HETZNER_TTL", minTTL),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Hetzner DNS Console API to manage TXT records for a domain.
+type DNSProvider struct {
+	config    *Config
+	recordIDs map[string]string
+	client    *http.Client
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Hetzner DNS.
+// Credentials must be passed in the environment variable HETZNER_API_KEY.
+// The propagation timeout, polling interval and record TTL (in seconds) can be set
+// with HETZNER_PROPAGATION_TIMEOUT, HETZNER_POLLING_INTERVAL and HETZNER_TTL.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("HETZNER_API_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("hetzner: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.APIKey = values["HETZNER_API_KEY"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Hetzner DNS.
+func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.APIKey = apiKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Hetzner DNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("hetzner: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" {
+		return nil, errors.New("hetzner: credentials missing")
+	}
+
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("hetzner: invalid TTL, TTL (%d) must be greater than %d", config.TTL, minTTL-1)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	return &DNSProvider{
+		config:    config,
+		recordIDs: make(map[string]string),
+		client:    acme.NewHTTPClient(30 * time.Second),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zoneName, zoneID, err := d.findZone(domain)
+	if err != nil {
+		return fmt.Errorf("hetzner: %v", err)
+	}
+
+	recordID, err := d.createRecord(DNSRecord{
+		Type:   "TXT",
+		Name:   extractRecordName(fqdn, zoneName),
+		Value:  value,
+		TTL:    d.config.TTL,
+		ZoneID: zoneID,
+	})
+	if err != nil {
+		return fmt.Errorf("hetzner: failed to create TXT record: %v", err)
+	}
+
+	d.recordIDs[fqdn] = recordID
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+
+	// get the record's unique ID from when we created it
+	recordID, ok := d.recordIDs[fqdn]
+	if !ok {
+		return fmt.Errorf("hetzner: unknown record ID for %q", fqdn)
+	}
+
+	err := d.deleteRecord(recordID)
+	if err != nil {
+		return fmt.Errorf("hetzner: failed to delete TXT record: %v", err)
+	}
+
+	delete(d.recordIDs, fqdn)
+	return nil
+}
+
+// findZone walks up the labels of the domain, starting with the domain itself,
+// and returns the name and ID of the longest zone managed by Hetzner DNS.
+func (d *DNSProvider) findZone(domain string) (string, string, error) {
+	name := acme.UnFqdn(domain)
+
+	for _, index := range dns.Split(name) {
+		candidate := name[index:]
+
+		zoneID, err := d.getZoneID(candidate)
+		if err != nil {
+			return "", "", err
+		}
+
+		if zoneID != "" {
+			return candidate, zoneID, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("could not find zone for domain %q", domain)
+}
+
+func extractRecordName(fqdn, zoneName string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(name, "."+zoneName); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
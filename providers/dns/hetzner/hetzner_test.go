@@ -0,0 +1,177 @@
+package hetzner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest      bool
+	envTestAPIKey string
+	envTestDomain string
+)
+
+func init() {
+	envTestAPIKey = os.Getenv("HETZNER_API_KEY")
+	envTestDomain = os.Getenv("HETZNER_DOMAIN")
+	liveTest = len(envTestAPIKey) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("HETZNER_API_KEY", envTestAPIKey)
+	os.Unsetenv("HETZNER_TTL")
+	os.Unsetenv("HETZNER_PROPAGATION_TIMEOUT")
+	os.Unsetenv("HETZNER_POLLING_INTERVAL")
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HETZNER_API_KEY", "123")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HETZNER_API_KEY", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "hetzner: some credentials information are missing: HETZNER_API_KEY")
+}
+
+func TestNewDNSProviderInvalidTTL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HETZNER_API_KEY", "123")
+	os.Setenv("HETZNER_TTL", "10")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "hetzner: invalid TTL, TTL (10) must be greater than 59")
+}
+
+func TestDNSProvider_Timeout(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HETZNER_API_KEY", "123")
+	os.Setenv("HETZNER_PROPAGATION_TIMEOUT", "300")
+	os.Setenv("HETZNER_POLLING_INTERVAL", "5")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	timeout, interval := provider.Timeout()
+	assert.Equal(t, 300*time.Second, timeout)
+	assert.Equal(t, 5*time.Second, interval)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	var created DNSRecord
+	var deleted []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("Auth-API-Token"))
+		assert.Contains(t, r.Header.Get("User-Agent"), "xenolf-acme")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") != "example.com" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"zones":[]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(ZonesResponse{Zones: []Zone{{ID: "zone-1", Name: "example.com"}}})
+
+		case r.Method == http.MethodPost && r.URL.Path == "/records":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			record := created
+			record.ID = "record-1"
+			json.NewEncoder(w).Encode(RecordResponse{Record: record})
+
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "123d==")
+	require.NoError(t, err)
+
+	assert.Equal(t, "zone-1", created.ZoneID)
+	assert.Equal(t, "_acme-challenge.www", created.Name)
+	assert.Equal(t, "TXT", created.Type)
+	assert.Equal(t, 60, created.TTL)
+	assert.Equal(t, "record-1", provider.recordIDs["_acme-challenge.www.example.com."])
+
+	err = provider.CleanUp("www.example.com", "", "123d==")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/records/record-1"}, deleted)
+	assert.Empty(t, provider.recordIDs)
+}
+
+func TestDNSProvider_PresentZoneNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "123d==")
+	assert.EqualError(t, err, `hetzner: could not find zone for domain "www.example.com"`)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "123d==")
+	assert.EqualError(t, err, `hetzner: unknown record ID for "_acme-challenge.www.example.com."`)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
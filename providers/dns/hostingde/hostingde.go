@@ -0,0 +1,573 @@
+// Package hostingde implements a DNS provider for solving the DNS-01
+// challenge using hosting.de.
+package hostingde
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
+	"github.com/xenolf/lego/platform/config/env"
+	"github.com/xenolf/lego/platform/wait"
+)
+
+// HostingdeAPIURL represents the API endpoint to call.
+const HostingdeAPIURL = "https://secure.hosting.de/api/dns/v1/json"
+
+// minTTL is the lowest TTL accepted by hosting.de for a record.
+const minTTL = 60
+
+// errorCodeConcurrentModification is the hosting.de error code returned when
+// a zone was modified by another request while an update was processed.
+const errorCodeConcurrentModification = 10206
+
+// retryDelay is the initial delay before retrying a zone update,
+// it is doubled after every attempt.
+var retryDelay = 500 * time.Millisecond
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey             string
+	ZoneName           string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	MaxRetries         int
+	// RequestsPerSecond limits the rate of the calls to the API, 0 means no limit.
+	RequestsPerSecond float64
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            HostingdeAPIURL,
+		PropagationTimeout: env.GetOrDefaultSecond("HOSTINGDE_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("HOSTINGDE_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("HOSTINGDE_TTL", 120),
+		MaxRetries:         env.GetOrDefaultInt("HOSTINGDE_MAX_RETRIES", 5),
+		RequestsPerSecond:  env.GetOrDefaultFloat("HOSTINGDE_HTTP_RPS", 0),
+	}
+}
+
+// presentedRecord is a TXT record added by the provider,
+// its ID is empty until it is returned by the zone update.
+type presentedRecord struct {
+	value string
+	id    string
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+type DNSProvider struct {
+	config *Config
+	// records are the TXT records presented and not cleaned up yet, by fqdn.
+	records   map[string][]presentedRecord
+	zoneNames map[string]string
+	// recordsMu guards records and zoneNames.
+	recordsMu sync.Mutex
+	client    *http.Client
+	limiter   *wait.Limiter
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for hosting.de.
+// Credentials must be passed in the environment variable HOSTINGDE_API_KEY,
+// or in the file pointed by HOSTINGDE_API_KEY_FILE.
+// HOSTINGDE_ZONE_NAME is optional, if it is not set the zone is looked up
+// for every domain. The API endpoint can be overridden with HOSTINGDE_API_URL,
+// the propagation timeout, polling interval and record TTL (in seconds) with
+// HOSTINGDE_PROPAGATION_TIMEOUT, HOSTINGDE_POLLING_INTERVAL and HOSTINGDE_TTL.
+// HOSTINGDE_MAX_RETRIES sets how often a concurrently modified zone update is retried,
+// HOSTINGDE_HTTP_RPS the maximum number of API calls per second.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("HOSTINGDE_API_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("hostingde: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.APIKey = values["HOSTINGDE_API_KEY"]
+	config.ZoneName = env.GetOrFile("HOSTINGDE_ZONE_NAME")
+	if baseURL := env.GetOrFile("HOSTINGDE_API_URL"); baseURL != "" {
+		config.BaseURL = baseURL
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for hosting.de.
+// If zoneName is empty, the zone is looked up for every domain.
+func NewDNSProviderCredentials(key, zoneName string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.APIKey = key
+	config.ZoneName = zoneName
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for hosting.de.
+// If config.BaseURL is empty, HostingdeAPIURL is used.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("hostingde: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" {
+		return nil, errors.New("hostingde: API key missing")
+	}
+
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("hostingde: invalid TTL, TTL (%d) must be greater than %d", config.TTL, minTTL-1)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = HostingdeAPIURL
+	}
+
+	baseURL, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("hostingde: invalid API URL %q: %v", config.BaseURL, err)
+	}
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("hostingde: invalid API URL %q: scheme and host are required", config.BaseURL)
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+
+	return &DNSProvider{
+		config:    config,
+		records:   make(map[string][]presentedRecord),
+		zoneNames: make(map[string]string),
+		client:    acme.NewHTTPClient(30 * time.Second),
+		limiter:   wait.NewLimiter(config.RequestsPerSecond, 1),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS
+// propagation. Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	return d.PresentMultiValue(domain, []string{keyAuth})
+}
+
+// PresentMultiValue creates the TXT records of all the given key authorizations
+// with a single zone update, so values sharing the same record don't clobber each other.
+// The values already presented for the domain are not added again.
+func (d *DNSProvider) PresentMultiValue(domain string, keyAuths []string) error {
+	zoneName, err := d.getZoneName(domain)
+	if err != nil {
+		return fmt.Errorf("hostingde: %v", err)
+	}
+
+	fqdn, values := dns01Values(domain, keyAuths)
+
+	added := d.trackRecords(fqdn, values)
+	if len(added) == 0 {
+		return nil
+	}
+
+	var rec []RecordsAddRequest
+	for _, value := range added {
+		rec = append(rec, RecordsAddRequest{
+			Type:    "TXT",
+			Name:    acme.UnFqdn(fqdn),
+			Content: value,
+			TTL:     d.config.TTL,
+		})
+	}
+
+	req := ZoneUpdateRequest{
+		AuthToken: d.config.APIKey,
+		ZoneConfigSelector: ZoneConfigSelector{
+			Name: zoneName,
+		},
+		RecordsToAdd: rec,
+	}
+
+	resp, err := d.updateZone(req)
+	if err != nil {
+		d.untrackRecords(fqdn, added)
+		return fmt.Errorf("hostingde: %v", err)
+	}
+
+	// hosting.de answers successfully with an empty zone config when the zone does not exist.
+	if resp.Response.ZoneConfig.ID == "" {
+		d.untrackRecords(fqdn, added)
+		return fmt.Errorf("hostingde: zone %q not found on the account, check HOSTINGDE_ZONE_NAME", zoneName)
+	}
+
+	if !d.setRecordIDs(fqdn, added, resp.Response.Records) {
+		// the zone was updated: remove the added records, they would not be cleaned up otherwise.
+		records := d.untrackRecords(fqdn, added)
+		if err := d.deleteRecords(zoneName, fqdn, records); err != nil {
+			log.Warnf("hostingde: could not remove the records added to the zone %q: %v", zoneName, err)
+		}
+		return fmt.Errorf("hostingde: the record %s was not found in the updated zone %q", acme.UnFqdn(fqdn), zoneName)
+	}
+
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return d.CleanUpMultiValue(domain, []string{keyAuth})
+}
+
+// CleanUpMultiValue removes the TXT records of all the given key authorizations
+// with a single zone update. Only the records presented by the provider are removed,
+// the values which were not presented or were already cleaned up are ignored.
+func (d *DNSProvider) CleanUpMultiValue(domain string, keyAuths []string) error {
+	zoneName, err := d.getZoneName(domain)
+	if err != nil {
+		return fmt.Errorf("hostingde: %v", err)
+	}
+
+	fqdn, values := dns01Values(domain, keyAuths)
+
+	records := d.untrackRecords(fqdn, values)
+	if len(records) == 0 {
+		return nil
+	}
+
+	err = d.deleteRecords(zoneName, fqdn, records)
+	if err != nil {
+		// keep the records, the clean up can be retried.
+		d.recordsMu.Lock()
+		d.records[fqdn] = append(d.records[fqdn], records...)
+		d.recordsMu.Unlock()
+		return fmt.Errorf("hostingde: %v", err)
+	}
+	return nil
+}
+
+// deleteRecords removes the records from the zone with a single zone update.
+// The records are deleted by ID, or matched by their name and content if their ID is unknown.
+func (d *DNSProvider) deleteRecords(zoneName, fqdn string, records []presentedRecord) error {
+	var rec []RecordsDeleteRequest
+	for _, record := range records {
+		rec = append(rec, RecordsDeleteRequest{
+			Type:    "TXT",
+			Name:    acme.UnFqdn(fqdn),
+			Content: record.value,
+			ID:      record.id,
+		})
+	}
+
+	req := ZoneUpdateRequest{
+		AuthToken: d.config.APIKey,
+		ZoneConfigSelector: ZoneConfigSelector{
+			Name: zoneName,
+		},
+		RecordsToDelete: rec,
+	}
+
+	_, err := d.updateZone(req)
+	return err
+}
+
+// trackRecords records the values presented for the fqdn and returns the ones
+// which were not presented yet, in order and without duplicates.
+func (d *DNSProvider) trackRecords(fqdn string, values []string) []string {
+	d.recordsMu.Lock()
+	defer d.recordsMu.Unlock()
+
+	var added []string
+	for _, value := range values {
+		if indexRecord(d.records[fqdn], value) != -1 {
+			continue
+		}
+		d.records[fqdn] = append(d.records[fqdn], presentedRecord{value: value})
+		added = append(added, value)
+	}
+	return added
+}
+
+// untrackRecords removes the values presented for the fqdn and returns their records.
+func (d *DNSProvider) untrackRecords(fqdn string, values []string) []presentedRecord {
+	d.recordsMu.Lock()
+	defer d.recordsMu.Unlock()
+
+	var removed []presentedRecord
+	for _, value := range values {
+		records := d.records[fqdn]
+		if i := indexRecord(records, value); i != -1 {
+			removed = append(removed, records[i])
+			d.records[fqdn] = append(records[:i], records[i+1:]...)
+		}
+	}
+	if len(d.records[fqdn]) == 0 {
+		delete(d.records, fqdn)
+	}
+	return removed
+}
+
+// setRecordIDs sets the IDs of the records presented for the fqdn from the records of the updated zone.
+// It reports whether all the values were found.
+func (d *DNSProvider) setRecordIDs(fqdn string, values []string, zoneRecords []DNSRecord) bool {
+	d.recordsMu.Lock()
+	defer d.recordsMu.Unlock()
+
+	found := true
+	for _, value := range values {
+		i := indexRecord(d.records[fqdn], value)
+		if i == -1 {
+			// cleaned up concurrently.
+			continue
+		}
+
+		for _, record := range zoneRecords {
+			if record.Name == acme.UnFqdn(fqdn) && record.Content == fmt.Sprintf(`"%s"`, value) {
+				d.records[fqdn][i].id = record.ID
+			}
+		}
+		found = found && d.records[fqdn][i].id != ""
+	}
+	return found
+}
+
+func indexRecord(records []presentedRecord, value string) int {
+	for i, record := range records {
+		if record.value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// dns01Values returns the fqdn of the TXT record of the domain and the values of the key authorizations.
+func dns01Values(domain string, keyAuths []string) (string, []string) {
+	var fqdn string
+	var values []string
+	for _, keyAuth := range keyAuths {
+		var value string
+		fqdn, value, _ = acme.DNS01Record(domain, keyAuth)
+		values = append(values, value)
+	}
+	return fqdn, values
+}
+
+// Preflight checks that the API key is valid and that the zone of the domain exists
+// on the account, with a zonesFind call. The zone is not modified.
+func (d *DNSProvider) Preflight(domain string) error {
+	if d.config.ZoneName == "" {
+		_, err := d.getZoneName(domain)
+		if err != nil {
+			return fmt.Errorf("hostingde: %v", err)
+		}
+		return nil
+	}
+
+	name := acme.UnFqdn(domain)
+	if !dns.IsSubDomain(d.config.ZoneName, name) {
+		return fmt.Errorf("hostingde: domain %q is not in the zone %q, check HOSTINGDE_ZONE_NAME", name, d.config.ZoneName)
+	}
+
+	zones, err := d.zonesFind(d.config.ZoneName)
+	if err != nil {
+		return fmt.Errorf("hostingde: %v", err)
+	}
+
+	for _, zone := range zones {
+		if strings.EqualFold(zone.ZoneConfig.Name, d.config.ZoneName) {
+			return nil
+		}
+	}
+	return fmt.Errorf("hostingde: zone %q not found on the account, check HOSTINGDE_ZONE_NAME", d.config.ZoneName)
+}
+
+// getZoneName returns the name of the zone the given domain belongs to.
+// The configured zone name is used if set, otherwise the zone is looked up
+// and cached for subsequent calls.
+func (d *DNSProvider) getZoneName(domain string) (string, error) {
+	if d.config.ZoneName != "" {
+		return d.config.ZoneName, nil
+	}
+
+	d.recordsMu.Lock()
+	zoneName, ok := d.zoneNames[domain]
+	d.recordsMu.Unlock()
+	if ok {
+		return zoneName, nil
+	}
+
+	zoneName, err := d.findZone(domain)
+	if err != nil {
+		return "", err
+	}
+
+	d.recordsMu.Lock()
+	d.zoneNames[domain] = zoneName
+	d.recordsMu.Unlock()
+	return zoneName, nil
+}
+
+// findZone walks up the labels of the domain, starting with the domain itself,
+// and returns the longest zone name registered with hosting.de.
+func (d *DNSProvider) findZone(domain string) (string, error) {
+	name := acme.UnFqdn(domain)
+
+	for _, index := range dns.Split(name) {
+		candidate := name[index:]
+
+		zones, err := d.zonesFind(candidate)
+		if err != nil {
+			return "", err
+		}
+
+		for _, zone := range zones {
+			if strings.EqualFold(zone.ZoneConfig.Name, candidate) {
+				return zone.ZoneConfig.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find zone for domain %q", domain)
+}
+
+// zonesFind retrieves the zones matching exactly the given name.
+func (d *DNSProvider) zonesFind(zoneName string) ([]Zone, error) {
+	req := ZonesFindRequest{
+		AuthToken: d.config.APIKey,
+		Filter: Filter{
+			Field: "zoneName",
+			Value: zoneName,
+		},
+		Limit: 1,
+		Page:  1,
+	}
+
+	resp := &ZonesFindResponse{}
+	err := d.doRequest("zonesFind", req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Response.Data, nil
+}
+
+// updateZone sends the zone update, retrying with an exponential backoff
+// while the zone is being modified concurrently.
+func (d *DNSProvider) updateZone(updateRequest ZoneUpdateRequest) (*ZoneUpdateResponse, error) {
+	delay := retryDelay
+
+	for attempt := 0; ; attempt++ {
+		log.Debugf("hostingde: zoneUpdate of the zone %s: %d records to add, %d records to delete",
+			updateRequest.ZoneConfigSelector.Name, len(updateRequest.RecordsToAdd), len(updateRequest.RecordsToDelete))
+
+		resp := &ZoneUpdateResponse{}
+		err := d.doRequest("zoneUpdate", updateRequest, resp)
+		if err == nil {
+			return resp, nil
+		}
+
+		apiErr, ok := err.(*APIError)
+		if !ok || !apiErr.hasCode(errorCodeConcurrentModification) || attempt >= d.config.MaxRetries {
+			return nil, err
+		}
+
+		log.Infof("hostingde: zone %s was modified concurrently, retrying in %v", updateRequest.ZoneConfigSelector.Name, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (d *DNSProvider) doRequest(method string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.config.BaseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	d.limiter.Wait()
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	r := &BaseResponse{}
+	err = json.Unmarshal(content, r)
+	if err != nil {
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(content))
+	}
+
+	// the request is not logged, it holds the API key.
+	log.Debugf("hostingde: %s: status %q (HTTP %d)", method, r.Status, resp.StatusCode)
+
+	if r.Status != "success" && r.Status != "pending" {
+		return &APIError{
+			Status:     r.Status,
+			StatusCode: resp.StatusCode,
+			Errors:     r.Errors,
+			Body:       string(content),
+		}
+	}
+
+	return json.Unmarshal(content, response)
+}
+
+// APIError represents an unsuccessful response of the hosting.de API.
+type APIError struct {
+	Status     string
+	StatusCode int
+	Errors     []ZoneUpdateError
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+		return "authentication failed, check HOSTINGDE_API_KEY: " + e.apiError()
+	}
+	return e.apiError()
+}
+
+func (e *APIError) apiError() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("API error: status %q (HTTP %d): %s", e.Status, e.StatusCode, e.Body)
+	}
+
+	var messages []string
+	for _, apiErr := range e.Errors {
+		msg := fmt.Sprintf("%d: %s", apiErr.Code, apiErr.Text)
+		if apiErr.Value != "" {
+			msg += fmt.Sprintf(" (%s)", apiErr.Value)
+		}
+		if len(apiErr.Details) > 0 {
+			msg += fmt.Sprintf(" [%s]", strings.Join(apiErr.Details, ", "))
+		}
+		messages = append(messages, msg)
+	}
+	return fmt.Sprintf("API error: status %q (HTTP %d): %s", e.Status, e.StatusCode, strings.Join(messages, "; "))
+}
+
+func (e *APIError) hasCode(code int) bool {
+	for _, apiErr := range e.Errors {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
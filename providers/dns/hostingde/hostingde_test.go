@@ -0,0 +1,893 @@
+package hostingde
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
+)
+
+var (
+	liveTest      bool
+	envTestAPIKey string
+	envTestZone   string
+	envTestDomain string
+)
+
+func init() {
+	envTestAPIKey = os.Getenv("HOSTINGDE_API_KEY")
+	envTestZone = os.Getenv("HOSTINGDE_ZONE_NAME")
+	envTestDomain = os.Getenv("HOSTINGDE_DOMAIN")
+	if len(envTestAPIKey) > 0 && len(envTestDomain) > 0 {
+		liveTest = true
+	}
+}
+
+func restoreEnv() {
+	os.Setenv("HOSTINGDE_ZONE_NAME", envTestZone)
+	os.Setenv("HOSTINGDE_API_KEY", envTestAPIKey)
+	os.Unsetenv("HOSTINGDE_API_URL")
+	os.Unsetenv("HOSTINGDE_PROPAGATION_TIMEOUT")
+	os.Unsetenv("HOSTINGDE_POLLING_INTERVAL")
+	os.Unsetenv("HOSTINGDE_TTL")
+	os.Unsetenv("HOSTINGDE_MAX_RETRIES")
+	os.Unsetenv("HOSTINGDE_API_KEY_FILE")
+	os.Unsetenv("HOSTINGDE_HTTP_RPS")
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "")
+	os.Setenv("HOSTINGDE_API_KEY", "")
+
+	_, err := NewDNSProviderCredentials("123", "example.com")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "")
+	os.Setenv("HOSTINGDE_API_KEY", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "hostingde: some credentials information are missing: HOSTINGDE_API_KEY")
+}
+
+func TestNewDNSProviderAPIKeyFile(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "")
+
+	file, err := ioutil.TempFile("", "hostingde")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString("key-from-file\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	os.Setenv("HOSTINGDE_API_KEY_FILE", file.Name())
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "key-from-file", provider.config.APIKey)
+}
+
+func TestNewDNSProviderDefaultAPIURL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, HostingdeAPIURL, provider.config.BaseURL)
+}
+
+func TestNewDNSProviderCustomAPIURL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+	os.Setenv("HOSTINGDE_API_URL", "https://sandbox.example.com/api/dns/v1/json/")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "https://sandbox.example.com/api/dns/v1/json", provider.config.BaseURL)
+}
+
+func TestNewDNSProviderInvalidAPIURL(t *testing.T) {
+	config := NewDefaultConfig()
+	config.APIKey = "123"
+	config.ZoneName = "example.com"
+	config.BaseURL = "secure.hosting.de"
+
+	_, err := NewDNSProviderConfig(config)
+	assert.EqualError(t, err, `hostingde: invalid API URL "secure.hosting.de": scheme and host are required`)
+}
+
+func TestDNSProvider_TimeoutDefault(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	timeout, interval := provider.Timeout()
+	assert.Equal(t, 120*time.Second, timeout)
+	assert.Equal(t, 2*time.Second, interval)
+}
+
+func TestDNSProvider_TimeoutFromEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+	os.Setenv("HOSTINGDE_PROPAGATION_TIMEOUT", "600")
+	os.Setenv("HOSTINGDE_POLLING_INTERVAL", "10")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	timeout, interval := provider.Timeout()
+	assert.Equal(t, 600*time.Second, timeout)
+	assert.Equal(t, 10*time.Second, interval)
+}
+
+func TestDNSProvider_RateLimit(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_HTTP_RPS", "20")
+
+	var calls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, time.Now())
+		fmt.Fprint(w, `{"status": "success"}`)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, provider.doRequest("zonesFind", ZonesFindRequest{}, &ZonesFindResponse{}))
+	}
+
+	require.Len(t, calls, 4)
+	for i := 1; i < len(calls); i++ {
+		assert.True(t, calls[i].Sub(calls[i-1]) >= 45*time.Millisecond, "calls %d and %d are not spaced: %v", i-1, i, calls[i].Sub(calls[i-1]))
+	}
+}
+
+func TestNewDNSProviderInvalidTTL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("HOSTINGDE_ZONE_NAME", "example.com")
+	os.Setenv("HOSTINGDE_API_KEY", "123")
+	os.Setenv("HOSTINGDE_TTL", "30")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "hostingde: invalid TTL, TTL (30) must be greater than 59")
+}
+
+func TestDNSProvider_PresentTTL(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		envTTL   string
+		expected int
+	}{
+		{desc: "default", envTTL: "", expected: 120},
+		{desc: "override", envTTL: "60", expected: 60},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			defer restoreEnv()
+			os.Setenv("HOSTINGDE_TTL", test.envTTL)
+
+			var ttl int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req ZoneUpdateRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				require.Len(t, req.RecordsToAdd, 1)
+				ttl = req.RecordsToAdd[0].TTL
+
+				resp := ZoneUpdateResponse{Status: "success"}
+				resp.Response.ZoneConfig = ZoneConfigObject{ID: "zone-1", Name: "example.com"}
+				resp.Response.Records = []DNSRecord{{
+					ID:      "rec-1",
+					Name:    req.RecordsToAdd[0].Name,
+					Type:    "TXT",
+					Content: `"` + req.RecordsToAdd[0].Content + `"`,
+				}}
+				json.NewEncoder(w).Encode(resp)
+			}))
+			defer server.Close()
+
+			config := NewDefaultConfig()
+			config.APIKey = "secret-token"
+			config.ZoneName = "example.com"
+			config.BaseURL = server.URL
+
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			err = provider.Present("example.com", "", "123d==")
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ttl)
+		})
+	}
+}
+
+func TestDNSProvider_CustomAPIURLIsUsed(t *testing.T) {
+	var requestedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, err = provider.updateZone(ZoneUpdateRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "/zoneUpdate", requestedPath)
+}
+
+func TestDNSProvider_PresentWritesNothingToStdout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ZoneUpdateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Len(t, req.RecordsToAdd, 1)
+
+		resp := ZoneUpdateResponse{Status: "success"}
+		resp.Response.ZoneConfig = ZoneConfigObject{ID: "zone-1", Name: "example.com"}
+		resp.Response.Records = []DNSRecord{{
+			ID:      "rec-1",
+			Name:    req.RecordsToAdd[0].Name,
+			Type:    "TXT",
+			Content: `"` + req.RecordsToAdd[0].Content + `"`,
+		}}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = writer
+
+	err = provider.Present("example.com", "", "123d==")
+
+	os.Stdout = stdout
+	writer.Close()
+	output, _ := ioutil.ReadAll(reader)
+
+	assert.NoError(t, err)
+	assert.Empty(t, string(output))
+	assert.NotContains(t, string(output), "secret-token")
+}
+
+func TestDNSProvider_PresentDebugLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"error","errors":[{"code":10205,"text":"Zone not found"}]}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	defer log.SetLogger(nil)
+	log.SetLogger(stdlog.New(&output, "", 0))
+	defer log.SetDebug(false)
+	log.SetDebug(true)
+
+	err = provider.Present("example.com", "", "123d==")
+	require.Error(t, err)
+
+	assert.Equal(t, "[DEBUG] hostingde: zoneUpdate of the zone example.com: 1 records to add, 0 records to delete\n"+
+		"[DEBUG] hostingde: zoneUpdate: status \"error\" (HTTP 200)\n", output.String())
+	assert.NotContains(t, output.String(), "secret-token")
+}
+
+func TestDNSProvider_PresentAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"errors": [{
+				"code": 10205,
+				"contextObject": "",
+				"contextPath": "/recordsToAdd/0",
+				"details": ["name"],
+				"text": "Record name is not part of the zone",
+				"value": "_acme-challenge.example.org"
+			}],
+			"metadata": {"clientTransactionId": "", "serverTransactionId": "abc"},
+			"warnings": [],
+			"status": "error"
+		}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: API error: status "error" (HTTP 200): 10205: Record name is not part of the zone (_acme-challenge.example.org) [name]`)
+}
+
+func TestDNSProvider_PresentAPIErrorWithoutDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error"}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: API error: status "error" (HTTP 500): {"status":"error"}`)
+}
+
+func TestDNSProvider_PresentZoneNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","response":{"records":[],"zoneConfig":{}}}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: zone "example.com" not found on the account, check HOSTINGDE_ZONE_NAME`)
+}
+
+func TestDNSProvider_PresentRecordMissing(t *testing.T) {
+	var requests []ZoneUpdateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ZoneUpdateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+
+		w.Write([]byte(`{"status":"success","response":{"records":[],"zoneConfig":{"id":"zone-1","name":"example.com"}}}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: the record _acme-challenge.example.com was not found in the updated zone "example.com"`)
+
+	// the added record is removed, matched by its name and content.
+	_, value, _ := acme.DNS01Record("example.com", "123d==")
+	require.Len(t, requests, 2)
+	assert.Equal(t, []RecordsDeleteRequest{{Type: "TXT", Name: "_acme-challenge.example.com", Content: value}}, requests[1].RecordsToDelete)
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSProvider_PresentWrongAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"status":"error","errors":[{"code":10100,"text":"Invalid authentication token"}]}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: authentication failed, check HOSTINGDE_API_KEY: API error: status "error" (HTTP 401): 10100: Invalid authentication token`)
+}
+
+func TestDNSProvider_FindZone(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		domain   string
+		expected string
+	}{
+		{desc: "apex", domain: "example.com", expected: "example.com"},
+		{desc: "subdomain", domain: "www.example.com", expected: "example.com"},
+		{desc: "multi-label", domain: "a.b.sub.example.com", expected: "sub.example.com"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			var lookups []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/zonesFind", r.URL.Path)
+
+				var req ZonesFindRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				require.Equal(t, "zoneName", req.Filter.Field)
+				lookups = append(lookups, req.Filter.Value)
+
+				resp := ZonesFindResponse{}
+				resp.Status = "success"
+				if req.Filter.Value == "example.com" || req.Filter.Value == "sub.example.com" {
+					resp.Response.Data = []Zone{{ZoneConfig: ZoneConfigObject{Name: req.Filter.Value}}}
+				}
+				json.NewEncoder(w).Encode(resp)
+			}))
+			defer server.Close()
+
+			config := NewDefaultConfig()
+			config.APIKey = "secret-token"
+			config.BaseURL = server.URL
+
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			zoneName, err := provider.getZoneName(test.domain)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, zoneName)
+
+			// the zone is cached per domain
+			zoneName, err = provider.getZoneName(test.domain)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, zoneName)
+			assert.Equal(t, lookups[len(lookups)-1], test.expected)
+			assert.Len(t, lookups, len(dns.Split(test.domain))-len(dns.Split(test.expected))+1)
+		})
+	}
+}
+
+func TestDNSProvider_FindZoneNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","response":{"data":[]}}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: could not find zone for domain "www.example.com"`)
+}
+
+func TestDNSProvider_Preflight(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		zoneName string
+		domain   string
+		expected string
+	}{
+		{desc: "configured zone", zoneName: "example.com", domain: "www.example.com"},
+		{desc: "looked up zone", domain: "www.example.com"},
+		{desc: "configured zone missing", zoneName: "example.org", domain: "www.example.org",
+			expected: `hostingde: zone "example.org" not found on the account, check HOSTINGDE_ZONE_NAME`},
+		{desc: "looked up zone missing", domain: "www.example.org",
+			expected: `hostingde: could not find zone for domain "www.example.org"`},
+		{desc: "domain outside the configured zone", zoneName: "example.com", domain: "www.example.org",
+			expected: `hostingde: domain "www.example.org" is not in the zone "example.com", check HOSTINGDE_ZONE_NAME`},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// only the zones are looked up, nothing is updated.
+				require.Equal(t, "/zonesFind", r.URL.Path)
+
+				var req ZonesFindRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+				resp := ZonesFindResponse{}
+				resp.Status = "success"
+				if req.Filter.Value == "example.com" {
+					resp.Response.Data = []Zone{{ZoneConfig: ZoneConfigObject{Name: req.Filter.Value}}}
+				}
+				json.NewEncoder(w).Encode(resp)
+			}))
+			defer server.Close()
+
+			config := NewDefaultConfig()
+			config.APIKey = "secret-token"
+			config.ZoneName = test.zoneName
+			config.BaseURL = server.URL
+
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			err = provider.Preflight(test.domain)
+			if test.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestDNSProvider_PresentRetriesConcurrentModification(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	var updates int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zoneUpdate":
+			updates++
+			var req ZoneUpdateRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			if updates <= 2 {
+				w.Write([]byte(`{"status":"error","errors":[{"code":10206,"text":"Object was modified concurrently"}]}`))
+				return
+			}
+
+			assert.Equal(t, "example.com", req.ZoneConfigSelector.Name)
+
+			resp := ZoneUpdateResponse{}
+			resp.Status = "success"
+			resp.Response.ZoneConfig = ZoneConfigObject{ID: "zone-1", Name: "example.com"}
+			resp.Response.Records = []DNSRecord{{
+				ID:      "rec-1",
+				Name:    req.RecordsToAdd[0].Name,
+				Type:    "TXT",
+				Content: `"` + req.RecordsToAdd[0].Content + `"`,
+			}}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	require.NoError(t, err)
+	assert.Equal(t, 3, updates)
+	fqdn, value, _ := acme.DNS01Record("example.com", "123d==")
+	assert.Equal(t, []presentedRecord{{value: value, id: "rec-1"}}, provider.records[fqdn])
+}
+
+func TestDNSProvider_PresentRetriesExhausted(t *testing.T) {
+	defer restoreEnv()
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+	os.Setenv("HOSTINGDE_MAX_RETRIES", "1")
+
+	var updates int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zoneUpdate" {
+			updates++
+			w.Write([]byte(`{"status":"error","errors":[{"code":10206,"text":"Object was modified concurrently"}]}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","response":{"data":[]}}`))
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "123d==")
+	assert.EqualError(t, err, `hostingde: API error: status "error" (HTTP 200): 10206: Object was modified concurrently`)
+	assert.Equal(t, 2, updates)
+}
+
+func TestDNSProvider_PresentMultiValue(t *testing.T) {
+	var updates int
+	var lastRequest ZoneUpdateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		updates++
+		lastRequest = ZoneUpdateRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&lastRequest))
+
+		resp := ZoneUpdateResponse{}
+		resp.Status = "success"
+		resp.Response.ZoneConfig = ZoneConfigObject{ID: "zone-1", Name: "example.com"}
+		for i, rec := range lastRequest.RecordsToAdd {
+			resp.Response.Records = append(resp.Response.Records, DNSRecord{
+				ID:      fmt.Sprintf("rec-%d", i),
+				Name:    rec.Name,
+				Type:    rec.Type,
+				Content: `"` + rec.Content + `"`,
+			})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	keyAuths := []string{"apex", "wildcard"}
+
+	err = provider.PresentMultiValue("example.com", keyAuths)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updates)
+	require.Len(t, lastRequest.RecordsToAdd, 2)
+	assert.Equal(t, lastRequest.RecordsToAdd[0].Name, lastRequest.RecordsToAdd[1].Name)
+	assert.NotEqual(t, lastRequest.RecordsToAdd[0].Content, lastRequest.RecordsToAdd[1].Content)
+	assert.Len(t, provider.records["_acme-challenge.example.com."], 2)
+
+	err = provider.CleanUpMultiValue("example.com", keyAuths)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updates)
+	require.Len(t, lastRequest.RecordsToDelete, 2)
+	assert.Equal(t, "rec-0", lastRequest.RecordsToDelete[0].ID)
+	assert.Equal(t, "rec-1", lastRequest.RecordsToDelete[1].ID)
+	assert.Empty(t, provider.records)
+}
+
+// fakeZone is a minimal in-memory implementation of the zone updates of the hosting.de API.
+type fakeZone struct {
+	t       *testing.T
+	mu      sync.Mutex
+	records []DNSRecord
+	nextID  int
+	updates []ZoneUpdateRequest
+}
+
+func (z *fakeZone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	require.Equal(z.t, "/zoneUpdate", r.URL.Path)
+
+	var req ZoneUpdateRequest
+	require.NoError(z.t, json.NewDecoder(r.Body).Decode(&req))
+	z.updates = append(z.updates, req)
+
+	for _, rec := range req.RecordsToAdd {
+		z.nextID++
+		z.records = append(z.records, DNSRecord{
+			ID:      fmt.Sprintf("rec-%d", z.nextID),
+			Name:    rec.Name,
+			Type:    rec.Type,
+			Content: `"` + rec.Content + `"`,
+		})
+	}
+
+	for _, rec := range req.RecordsToDelete {
+		var kept []DNSRecord
+		for _, existing := range z.records {
+			// without ID, all the records with the name and content are deleted.
+			if rec.ID == existing.ID || rec.ID == "" && existing.Name == rec.Name && existing.Content == `"`+rec.Content+`"` {
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		z.records = kept
+	}
+
+	resp := ZoneUpdateResponse{}
+	resp.Status = "success"
+	resp.Response.ZoneConfig = ZoneConfigObject{ID: "zone-1", Name: "example.com"}
+	resp.Response.Records = z.records
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestDNSProvider_PresentAndCleanUpTracking(t *testing.T) {
+	fqdn, value, _ := acme.DNS01Record("example.com", "123d==")
+
+	// the same value was added to the zone by another client.
+	zone := &fakeZone{t: t, nextID: 1, records: []DNSRecord{{ID: "rec-1", Name: acme.UnFqdn(fqdn), Type: "TXT", Content: `"` + value + `"`}}}
+	server := httptest.NewServer(zone)
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Present("example.com", "", "123d=="))
+	require.NoError(t, provider.Present("example.com", "", "123d=="))
+	require.Len(t, zone.updates, 1)
+	assert.Equal(t, []presentedRecord{{value: value, id: "rec-2"}}, provider.records[fqdn])
+
+	require.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	require.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	require.Len(t, zone.updates, 2)
+	assert.Equal(t, []RecordsDeleteRequest{{Type: "TXT", Name: acme.UnFqdn(fqdn), Content: value, ID: "rec-2"}}, zone.updates[1].RecordsToDelete)
+
+	// only the record added by the provider was deleted.
+	assert.Equal(t, []DNSRecord{{ID: "rec-1", Name: acme.UnFqdn(fqdn), Type: "TXT", Content: `"` + value + `"`}}, zone.records)
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSProvider_CleanUpFailureKeepsRecords(t *testing.T) {
+	zone := &fakeZone{t: t}
+	var fail bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"status":"error"}`))
+			return
+		}
+		zone.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Present("example.com", "", "123d=="))
+
+	fail = true
+	require.Error(t, provider.CleanUp("example.com", "", "123d=="))
+
+	fail = false
+	require.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	assert.Empty(t, zone.records)
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSProvider_PresentAndCleanUpConcurrently(t *testing.T) {
+	zone := &fakeZone{t: t}
+	server := httptest.NewServer(zone)
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret-token"
+	config.ZoneName = "example.com"
+	config.BaseURL = server.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	keyAuths := []string{"apex", "wildcard"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(keyAuths))
+	for _, keyAuth := range keyAuths {
+		wg.Add(1)
+		go func(keyAuth string) {
+			defer wg.Done()
+			errs <- provider.Present("example.com", "", keyAuth)
+		}(keyAuth)
+	}
+	wg.Wait()
+
+	assert.Len(t, zone.records, 2)
+	assert.Len(t, provider.records["_acme-challenge.example.com."], 2)
+
+	for _, keyAuth := range keyAuths {
+		wg.Add(1)
+		go func(keyAuth string) {
+			defer wg.Done()
+			errs <- provider.CleanUp("example.com", "", keyAuth)
+		}(keyAuth)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, zone.records)
+	assert.Empty(t, provider.records)
+
+	// every record was deleted by its ID.
+	for _, update := range zone.updates {
+		for _, rec := range update.RecordsToDelete {
+			assert.NotEmpty(t, rec.ID)
+		}
+	}
+}
+
+func TestDNSProvider_Present(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestDNSProvider_CleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second * 2)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	assert.NoError(t, err)
+}
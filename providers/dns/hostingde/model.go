@@ -0,0 +1,116 @@
+package hostingde
+
+// RecordsAddRequest represents a DNS record to add
+type RecordsAddRequest struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// RecordsDeleteRequest represents a DNS record to remove
+type RecordsDeleteRequest struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	ID      string `json:"id,omitempty"`
+}
+
+// ZoneConfigObject represents the ZoneConfig-section of a hosting.de API response.
+type ZoneConfigObject struct {
+	AccountID      string `json:"accountId"`
+	EmailAddress   string `json:"emailAddress"`
+	ID             string `json:"id"`
+	LastChangeDate string `json:"lastChangeDate"`
+	MasterIP       string `json:"masterIp"`
+	Name           string `json:"name"`
+	NameUnicode    string `json:"nameUnicode"`
+	Status         string `json:"status"`
+	Type           string `json:"type"`
+}
+
+// ZoneUpdateError represents an error in a ZoneUpdateResponse
+type ZoneUpdateError struct {
+	Code          int      `json:"code"`
+	ContextObject string   `json:"contextObject"`
+	ContextPath   string   `json:"contextPath"`
+	Details       []string `json:"details"`
+	Text          string   `json:"text"`
+	Value         string   `json:"value"`
+}
+
+// ZoneUpdateMetadata represents the metadata in a ZoneUpdateResponse
+type ZoneUpdateMetadata struct {
+	ClientTransactionID string `json:"clientTransactionId"`
+	ServerTransactionID string `json:"serverTransactionId"`
+}
+
+// DNSRecord represents a DNS record returned by the hosting.de API
+type DNSRecord struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// BaseResponse represents the fields common to all hosting.de API responses
+type BaseResponse struct {
+	Errors   []ZoneUpdateError  `json:"errors"`
+	Metadata ZoneUpdateMetadata `json:"metadata"`
+	Warnings []string           `json:"warnings"`
+	Status   string             `json:"status"`
+}
+
+// ZoneUpdateResponse represents a response from hosting.de API
+type ZoneUpdateResponse struct {
+	BaseResponse
+	Response struct {
+		Records    []DNSRecord      `json:"records"`
+		ZoneConfig ZoneConfigObject `json:"zoneConfig"`
+	} `json:"response"`
+}
+
+// ZoneConfigSelector represents a "minimal" ZoneConfig object used in hosting.de API requests
+type ZoneConfigSelector struct {
+	Name string `json:"name"`
+}
+
+// ZoneUpdateRequest represents a hosting.de API ZoneUpdate request
+type ZoneUpdateRequest struct {
+	AuthToken          string `json:"authToken"`
+	ZoneConfigSelector `json:"zoneConfig"`
+	RecordsToAdd       []RecordsAddRequest    `json:"recordsToAdd"`
+	RecordsToDelete    []RecordsDeleteRequest `json:"recordsToDelete"`
+}
+
+// Filter represents a hosting.de API filter used in find requests
+type Filter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// ZonesFindRequest represents a hosting.de API ZonesFind request
+type ZonesFindRequest struct {
+	AuthToken string `json:"authToken"`
+	Filter    Filter `json:"filter"`
+	Limit     int    `json:"limit"`
+	Page      int    `json:"page"`
+}
+
+// Zone represents a zone returned by the hosting.de API
+type Zone struct {
+	Records    []DNSRecord      `json:"records"`
+	ZoneConfig ZoneConfigObject `json:"zoneConfig"`
+}
+
+// ZonesFindResponse represents a response from hosting.de API ZonesFind
+type ZonesFindResponse struct {
+	BaseResponse
+	Response struct {
+		Limit        int    `json:"limit"`
+		Page         int    `json:"page"`
+		TotalEntries int    `json:"totalEntries"`
+		Data         []Zone `json:"data"`
+	} `json:"response"`
+}
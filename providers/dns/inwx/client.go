@@ -0,0 +1,204 @@
+package inwx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// codeSuccess, codeSuccessPending and codeSuccessLogout are the codes of the successful calls.
+	codeSuccess        = 1000
+	codeSuccessPending = 1001
+	codeSuccessLogout  = 1500
+	// codeObjectNotFound is returned when the requested domain or record does not exist.
+	codeObjectNotFound = 2303
+)
+
+// Record represents an INWX nameserver record
+type Record struct {
+	ID      int    `json:"id,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+type apiRequest struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type apiResponse struct {
+	Code    int             `json:"code"`
+	Message string          `json:"msg"`
+	Reason  string          `json:"reason,omitempty"`
+	ResData json.RawMessage `json:"resData"`
+}
+
+type apiError struct {
+	Code    int
+	Message string
+	Reason  string
+}
+
+func (e apiError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("API error %d: %s (%s)", e.Code, e.Message, e.Reason)
+	}
+	return fmt.Sprintf("API error %d: %s", e.Code, e.Message)
+}
+
+// login opens a session, unlocked with a TOTP when the account has the two-factor authentication enabled.
+func (d *DNSProvider) login() error {
+	var result struct {
+		TFA string `json:"tfa"`
+	}
+
+	err := d.call("account.login", map[string]string{"user": d.config.Username, "pass": d.config.Password}, &result)
+	if err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+
+	if result.TFA == "" || result.TFA == "0" {
+		return nil
+	}
+
+	if d.config.SharedSecret == "" {
+		return fmt.Errorf("the account has the two-factor authentication (%s) enabled but no shared secret is configured", result.TFA)
+	}
+
+	tan, err := generateTOTP(d.config.SharedSecret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = d.call("account.unlock", map[string]string{"tan": tan}, nil)
+	if err != nil {
+		return fmt.Errorf("unlock failed: %v", err)
+	}
+	return nil
+}
+
+// logout closes the session.
+func (d *DNSProvider) logout() error {
+	return d.call("account.logout", map[string]string{}, nil)
+}
+
+// hasDomain reports whether the domain is a zone of the account.
+func (d *DNSProvider) hasDomain(domain string) (bool, error) {
+	err := d.call("nameserver.info", map[string]string{"domain": domain}, nil)
+	if apiErr, ok := err.(apiError); ok && apiErr.Code == codeObjectNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// createRecord creates the record and returns its ID.
+func (d *DNSProvider) createRecord(record Record) (int, error) {
+	var result struct {
+		ID int `json:"id"`
+	}
+
+	err := d.call("nameserver.createRecord", record, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.ID, nil
+}
+
+// findRecords returns the records of the domain matching the name, type and content of the record.
+func (d *DNSProvider) findRecords(record Record) ([]Record, error) {
+	var result struct {
+		Records []Record `json:"record"`
+	}
+
+	err := d.call("nameserver.info", record, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+// deleteRecord deletes the record with the given ID.
+func (d *DNSProvider) deleteRecord(id int) error {
+	return d.call("nameserver.deleteRecord", map[string]int{"id": id}, nil)
+}
+
+// call sends the method and its parameters to the INWX JSON-RPC API and decodes the result,
+// the session cookie is kept by the cookie jar of the HTTP client.
+func (d *DNSProvider) call(method string, params, result interface{}) error {
+	content, err := json.Marshal(apiRequest{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.config.BaseURL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	var apiResp apiResponse
+	if err = json.Unmarshal(raw, &apiResp); err != nil {
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+
+	if apiResp.Code != codeSuccess && apiResp.Code != codeSuccessPending && apiResp.Code != codeSuccessLogout {
+		return apiError{Code: apiResp.Code, Message: apiResp.Message, Reason: apiResp.Reason}
+	}
+
+	if result == nil || len(apiResp.ResData) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(apiResp.ResData, result)
+	if err != nil {
+		return fmt.Errorf("could not decode the result of %s: %v", method, err)
+	}
+	return nil
+}
+
+// generateTOTP computes the time-based one-time password (RFC 6238) of the base32 encoded secret:
+// HMAC-SHA1, 30 seconds steps and 6 digits.
+func generateTOTP(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", fmt.Errorf("invalid shared secret: %v", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
@@ -0,0 +1,252 @@
+// Package inwx implements a DNS provider for solving the DNS-01 challenge using INWX DNS.
+// See https://www.inwx.com/en/help/apidoc
+package inwx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+const (
+	// defaultBaseURL represents the API endpoint to call.
+	defaultBaseURL = "https://api.domrobot.com/jsonrpc/"
+	// sandboxBaseURL is the API endpoint of the INWX test environment.
+	sandboxBaseURL = "https://api.ote.domrobot.com/jsonrpc/"
+)
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Username string
+	Password string
+	// SharedSecret is the secret of the two-factor authentication of the account, if enabled.
+	SharedSecret       string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	baseURL := defaultBaseURL
+	if os.Getenv("INWX_SANDBOX") == "true" {
+		baseURL = sandboxBaseURL
+	}
+
+	return &Config{
+		SharedSecret:       os.Getenv("INWX_SHARED_SECRET"),
+		BaseURL:            baseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("INWX_PROPAGATION_TIMEOUT", 360*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("INWX_POLLING_INTERVAL", 15*time.Second),
+		TTL:                env.GetOrDefaultInt("INWX_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("INWX_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses INWX's API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// client is the HTTP client of the configuration with a cookie jar holding the session.
+	client *http.Client
+	// sessionMu serializes the sessions, a login being bound to the cookie jar.
+	sessionMu sync.Mutex
+	// recordIDs are the IDs of the created records, by fqdn and value.
+	recordIDs   map[string]int
+	recordIDsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for INWX.
+// Credentials must be passed in the environment variables INWX_USERNAME and INWX_PASSWORD,
+// INWX_SHARED_SECRET is required for the accounts with the two-factor authentication enabled.
+// INWX_SANDBOX=true uses the test environment of INWX.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("INWX_USERNAME", "INWX_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("inwx: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Username = values["INWX_USERNAME"]
+	config.Password = values["INWX_PASSWORD"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for INWX.
+func NewDNSProviderCredentials(username, password, sharedSecret string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.Username = username
+	config.Password = password
+	config.SharedSecret = sharedSecret
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for INWX.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("inwx: the configuration of the DNS provider is nil")
+	}
+
+	if config.Username == "" || config.Password == "" {
+		return nil, errors.New("inwx: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("inwx: %v", err)
+	}
+
+	client := *config.HTTPClient
+	client.Jar = jar
+
+	return &DNSProvider{config: config, client: &client, recordIDs: make(map[string]int)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.sessionMu.Lock()
+	defer d.sessionMu.Unlock()
+
+	err := d.login()
+	if err != nil {
+		return fmt.Errorf("inwx: %v", err)
+	}
+	defer d.closeSession()
+
+	zone, err := d.findZone(fqdn)
+	if err != nil {
+		return fmt.Errorf("inwx: %v", err)
+	}
+
+	record := Record{
+		Domain:  zone,
+		Name:    acme.UnFqdn(fqdn),
+		Type:    "TXT",
+		Content: value,
+		TTL:     d.config.TTL,
+	}
+
+	id, err := d.createRecord(record)
+	if err != nil {
+		return fmt.Errorf("inwx: failed to create the TXT record: %v", err)
+	}
+
+	d.recordIDsMu.Lock()
+	d.recordIDs[fqdn+" "+value] = id
+	d.recordIDsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+// The record is deleted by the ID stored at its creation, or looked up by its name and value otherwise.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.sessionMu.Lock()
+	defer d.sessionMu.Unlock()
+
+	err := d.login()
+	if err != nil {
+		return fmt.Errorf("inwx: %v", err)
+	}
+	defer d.closeSession()
+
+	d.recordIDsMu.Lock()
+	id, ok := d.recordIDs[fqdn+" "+value]
+	d.recordIDsMu.Unlock()
+
+	if !ok {
+		id, err = d.findRecordID(fqdn, value)
+		if err != nil {
+			return fmt.Errorf("inwx: %v", err)
+		}
+	}
+
+	err = d.deleteRecord(id)
+	if err != nil {
+		return fmt.Errorf("inwx: failed to delete the TXT record: %v", err)
+	}
+
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn+" "+value)
+	d.recordIDsMu.Unlock()
+
+	return nil
+}
+
+func (d *DNSProvider) closeSession() {
+	if err := d.logout(); err != nil {
+		log.Warnf("inwx: logout failed: %v", err)
+	}
+}
+
+// findZone returns the longest zone of the account matching the fqdn.
+func (d *DNSProvider) findZone(fqdn string) (string, error) {
+	labels := strings.Split(acme.UnFqdn(fqdn), ".")
+
+	for i := 0; i < len(labels)-1; i++ {
+		zone := strings.Join(labels[i:], ".")
+
+		found, err := d.hasDomain(zone)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the zone %s: %v", zone, err)
+		}
+		if found {
+			return zone, nil
+		}
+	}
+
+	return "", fmt.Errorf("no zone of the account matches %s", fqdn)
+}
+
+// findRecordID returns the ID of the TXT record with the given fqdn and value.
+func (d *DNSProvider) findRecordID(fqdn, value string) (int, error) {
+	zone, err := d.findZone(fqdn)
+	if err != nil {
+		return 0, err
+	}
+
+	records, err := d.findRecords(Record{Domain: zone, Name: acme.UnFqdn(fqdn), Type: "TXT", Content: value})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the records of %s: %v", zone, err)
+	}
+
+	for _, record := range records {
+		if record.Content == value {
+			return record.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no TXT record %s found in the zone %s", acme.UnFqdn(fqdn), zone)
+}
@@ -0,0 +1,361 @@
+package inwx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+)
+
+var (
+	liveTest            bool
+	envTestUsername     string
+	envTestPassword     string
+	envTestSharedSecret string
+	envTestDomain       string
+)
+
+func init() {
+	envTestUsername = os.Getenv("INWX_USERNAME")
+	envTestPassword = os.Getenv("INWX_PASSWORD")
+	envTestSharedSecret = os.Getenv("INWX_SHARED_SECRET")
+	envTestDomain = os.Getenv("INWX_DOMAIN")
+	liveTest = len(envTestUsername) > 0 && len(envTestPassword) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("INWX_USERNAME", envTestUsername)
+	os.Setenv("INWX_PASSWORD", envTestPassword)
+	os.Setenv("INWX_SHARED_SECRET", envTestSharedSecret)
+	os.Unsetenv("INWX_SANDBOX")
+}
+
+// mockServer is a minimal in-memory implementation of the INWX JSON-RPC API.
+type mockServer struct {
+	t            *testing.T
+	mu           sync.Mutex
+	sharedSecret string
+	domains      []string
+	records      map[int]Record
+	nextID       int
+	session      string
+	unlocked     bool
+	// methods are the methods of the received requests.
+	methods []string
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Equal(m.t, http.MethodPost, r.Method)
+
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&req))
+	m.methods = append(m.methods, req.Method)
+
+	var params struct {
+		Record
+		User string `json:"user"`
+		Pass string `json:"pass"`
+		TAN  string `json:"tan"`
+	}
+	require.NoError(m.t, json.Unmarshal(req.Params, &params))
+
+	if req.Method != "account.login" {
+		cookie, err := r.Cookie("domrobot")
+		if err != nil || cookie.Value != m.session {
+			writeResponse(w, 2200, "Authentication error", nil)
+			return
+		}
+		if m.sharedSecret != "" && !m.unlocked && req.Method != "account.unlock" {
+			writeResponse(w, 2200, "Authentication error", nil)
+			return
+		}
+	}
+
+	switch req.Method {
+	case "account.login":
+		if params.User != "user" || params.Pass != "secret" {
+			writeResponse(w, 2200, "Authentication error", nil)
+			return
+		}
+
+		m.session = fmt.Sprintf("session%d", len(m.methods))
+		m.unlocked = false
+		http.SetCookie(w, &http.Cookie{Name: "domrobot", Value: m.session, Path: "/"})
+
+		tfa := "0"
+		if m.sharedSecret != "" {
+			tfa = "GOOGLE-AUTH"
+		}
+		writeResponse(w, 1000, "Command completed successfully", map[string]interface{}{"customerId": 1, "tfa": tfa})
+
+	case "account.unlock":
+		now := time.Now()
+		previous, _ := generateTOTP(m.sharedSecret, now.Add(-30*time.Second))
+		current, _ := generateTOTP(m.sharedSecret, now)
+		if params.TAN != current && params.TAN != previous {
+			writeResponse(w, 2200, "Authentication error", nil)
+			return
+		}
+		m.unlocked = true
+		writeResponse(w, 1000, "Command completed successfully", nil)
+
+	case "account.logout":
+		m.session = ""
+		writeResponse(w, 1500, "Command completed successfully; ending session", nil)
+
+	case "nameserver.info":
+		if !m.hasDomain(params.Domain) {
+			writeResponse(w, 2303, "Object does not exist", nil)
+			return
+		}
+
+		var records []Record
+		for id, record := range m.records {
+			if record.Domain == params.Domain && (params.Name == "" || record.Name == params.Name) && (params.Content == "" || record.Content == params.Content) {
+				record.ID = id
+				record.Domain = ""
+				records = append(records, record)
+			}
+		}
+		writeResponse(w, 1000, "Command completed successfully", map[string]interface{}{"domain": params.Domain, "record": records})
+
+	case "nameserver.createRecord":
+		if !m.hasDomain(params.Domain) {
+			writeResponse(w, 2303, "Object does not exist", nil)
+			return
+		}
+
+		m.nextID++
+		m.records[m.nextID] = params.Record
+		writeResponse(w, 1000, "Command completed successfully", map[string]interface{}{"id": m.nextID})
+
+	case "nameserver.deleteRecord":
+		if _, ok := m.records[params.ID]; !ok {
+			writeResponse(w, 2303, "Object does not exist", nil)
+			return
+		}
+		delete(m.records, params.ID)
+		writeResponse(w, 1000, "Command completed successfully", nil)
+
+	default:
+		writeResponse(w, 2000, "Command unrecognized", nil)
+	}
+}
+
+func (m *mockServer) hasDomain(domain string) bool {
+	for _, d := range m.domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func writeResponse(w http.ResponseWriter, code int, msg string, resData interface{}) {
+	response := map[string]interface{}{"code": code, "msg": msg}
+	if resData != nil {
+		response["resData"] = resData
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("INWX_USERNAME", "")
+	os.Setenv("INWX_PASSWORD", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "inwx: some credentials information are missing: INWX_USERNAME,INWX_PASSWORD")
+}
+
+func TestNewDNSProviderSandbox(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("INWX_USERNAME", "user")
+	os.Setenv("INWX_PASSWORD", "secret")
+	os.Setenv("INWX_SHARED_SECRET", "GEZDGNBVGY3TQOJQ")
+	os.Setenv("INWX_SANDBOX", "true")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, sandboxBaseURL, provider.config.BaseURL)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQ", provider.config.SharedSecret)
+	assert.NotNil(t, provider.client.Jar)
+}
+
+func TestGenerateTOTP(t *testing.T) {
+	// Test vectors of RFC 6238 (SHA1), truncated to 6 digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, test := range testCases {
+		code, err := generateTOTP(secret, time.Unix(test.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, code, "time %d", test.unix)
+	}
+
+	code, err := generateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	_, err = generateTOTP("not base32!", time.Now())
+	assert.Error(t, err)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := &mockServer{t: t, domains: []string{"example.com", "sub.example.com"}, records: map[int]Record{}, nextID: 100}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/jsonrpc/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("www.example.com", "keyAuth")
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := Record{Domain: "example.com", Name: "_acme-challenge.www.example.com", Type: "TXT", Content: value, TTL: 300}
+	assert.Equal(t, map[int]Record{101: expected}, server.records)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records)
+	assert.Equal(t, []string{
+		"account.login", "nameserver.info", "nameserver.info", "nameserver.info", "nameserver.createRecord", "account.logout",
+		"account.login", "nameserver.deleteRecord", "account.logout",
+	}, server.methods)
+}
+
+func TestDNSProvider_CleanUpLookup(t *testing.T) {
+	_, value, _ := acme.DNS01Record("sub.example.com", "keyAuth")
+
+	server := &mockServer{
+		t:       t,
+		domains: []string{"example.com", "sub.example.com"},
+		records: map[int]Record{
+			7: {Domain: "sub.example.com", Name: "_acme-challenge.sub.example.com", Type: "TXT", Content: "other"},
+			8: {Domain: "sub.example.com", Name: "_acme-challenge.sub.example.com", Type: "TXT", Content: value},
+		},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/jsonrpc/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Len(t, server.records, 1)
+	assert.Contains(t, server.records, 7)
+}
+
+func TestDNSProvider_PresentTwoFactor(t *testing.T) {
+	server := &mockServer{t: t, sharedSecret: "GEZDGNBVGY3TQOJQ", domains: []string{"example.com"}, records: map[int]Record{}}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.Password = "secret"
+	config.SharedSecret = "GEZDGNBVGY3TQOJQ"
+	config.BaseURL = ts.URL + "/jsonrpc/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Len(t, server.records, 1)
+	assert.Equal(t, []string{"account.login", "account.unlock", "nameserver.info", "nameserver.info", "nameserver.createRecord", "account.logout"}, server.methods)
+}
+
+func TestDNSProvider_PresentTwoFactorWithoutSecret(t *testing.T) {
+	server := &mockServer{t: t, sharedSecret: "GEZDGNBVGY3TQOJQ", domains: []string{"example.com"}, records: map[int]Record{}}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/jsonrpc/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "inwx: the account has the two-factor authentication (GOOGLE-AUTH) enabled but no shared secret is configured")
+}
+
+func TestDNSProvider_PresentUnknownZone(t *testing.T) {
+	server := &mockServer{t: t, domains: []string{"example.com"}, records: map[int]Record{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.Password = "secret"
+	config.BaseURL = ts.URL + "/jsonrpc/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.org", "", "keyAuth")
+	assert.EqualError(t, err, "inwx: no zone of the account matches _acme-challenge.example.org.")
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
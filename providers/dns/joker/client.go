@@ -0,0 +1,128 @@
+package joker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// response is a response of the DMAPI: a block of "Name: value" headers, an empty line and the body.
+type response struct {
+	Headers    url.Values
+	Body       string
+	StatusCode string
+	StatusText string
+	AuthSid    string
+}
+
+// parseResponse parses a response of the DMAPI.
+func parseResponse(message string) *response {
+	message = strings.Replace(message, "\r\n", "\n", -1)
+
+	resp := &response{Headers: url.Values{}}
+
+	parts := strings.SplitN(message, "\n\n", 2)
+	for _, line := range strings.Split(parts[0], "\n") {
+		if line == "" {
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		val := ""
+		if len(kv) == 2 {
+			val = strings.TrimSpace(kv[1])
+		}
+		resp.Headers.Add(strings.TrimSpace(kv[0]), val)
+	}
+
+	if len(parts) == 2 {
+		resp.Body = parts[1]
+	}
+
+	resp.StatusCode = resp.Headers.Get("Status-Code")
+	resp.StatusText = resp.Headers.Get("Status-Text")
+	resp.AuthSid = resp.Headers.Get("Auth-Sid")
+
+	return resp
+}
+
+// login opens a session, authenticating with the API key or the username and password.
+// It returns the session ID.
+func (d *DNSProvider) login() (string, error) {
+	data := url.Values{}
+	if d.config.APIKey != "" {
+		data.Set("api-key", d.config.APIKey)
+	} else {
+		data.Set("username", d.config.Username)
+		data.Set("password", d.config.Password)
+	}
+
+	resp, err := d.postRequest("login", data)
+	if err != nil {
+		return "", fmt.Errorf("could not log in: %v", err)
+	}
+
+	if resp.AuthSid == "" {
+		return "", fmt.Errorf("could not log in: no session ID in the response: %s", resp.StatusText)
+	}
+	return resp.AuthSid, nil
+}
+
+// logout closes the session.
+func (d *DNSProvider) logout(authSid string) error {
+	_, err := d.postRequest("logout", url.Values{"auth-sid": {authSid}})
+	return err
+}
+
+// getZone returns the zone of the domain, in the text format of Joker.
+func (d *DNSProvider) getZone(authSid, domain string) (string, error) {
+	resp, err := d.postRequest("dns-zone-get", url.Values{
+		"auth-sid": {authSid},
+		"domain":   {domain},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Body, nil
+}
+
+// putZone replaces the zone of the domain.
+func (d *DNSProvider) putZone(authSid, domain, zone string) error {
+	_, err := d.postRequest("dns-zone-put", url.Values{
+		"auth-sid": {authSid},
+		"domain":   {domain},
+		"zone":     {zone},
+	})
+	return err
+}
+
+// postRequest sends the command to the DMAPI and checks its status.
+func (d *DNSProvider) postRequest(cmd string, data url.Values) (*response, error) {
+	resp, err := d.config.HTTPClient.PostForm(d.config.BaseURL+"/"+cmd, data)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DMAPI: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read DMAPI response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DMAPI error on %s (HTTP %d): %s", cmd, resp.StatusCode, string(raw))
+	}
+
+	result := parseResponse(string(raw))
+	if result.StatusCode != "0" {
+		msg := result.StatusText
+		if details := result.Headers["Error"]; len(details) > 0 {
+			msg += ": " + strings.Join(details, ", ")
+		}
+		return nil, fmt.Errorf("DMAPI error on %s (status %s): %s", cmd, result.StatusCode, msg)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,225 @@
+// Package joker implements a DNS provider for solving the DNS-01 challenge using the DMAPI of Joker.com.
+// See https://joker.com/faq/category/39/22-dmapi.html
+package joker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://dmapi.joker.com/request"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	// APIKey is used to log in, else Username and Password.
+	APIKey             string
+	Username           string
+	Password           string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("JOKER_PROPAGATION_TIMEOUT", 10*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("JOKER_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("JOKER_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("JOKER_HTTP_TIMEOUT", 60*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the DMAPI of Joker.com to manage TXT records for a domain.
+// The DMAPI replaces the whole zone, so the zone is read, edited and sent back.
+type DNSProvider struct {
+	config *Config
+	// zoneMu serializes the edits of the zones, which would otherwise overwrite each other.
+	zoneMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Joker.com.
+// Credentials must be passed in the environment variables:
+// JOKER_API_KEY, or JOKER_USERNAME and JOKER_PASSWORD.
+func NewDNSProvider() (*DNSProvider, error) {
+	config := NewDefaultConfig()
+
+	if apiKey := env.GetOrFile("JOKER_API_KEY"); apiKey != "" {
+		config.APIKey = apiKey
+		return NewDNSProviderConfig(config)
+	}
+
+	values, err := env.Get("JOKER_USERNAME", "JOKER_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("joker: %v", err)
+	}
+
+	config.Username = values["JOKER_USERNAME"]
+	config.Password = values["JOKER_PASSWORD"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Joker.com.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("joker: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" && (config.Username == "" || config.Password == "") {
+		return nil, errors.New("joker: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(60 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	err := d.editZone(fqdn, func(zone, name string) string {
+		return addTxtEntryToZone(zone, name, value, d.config.TTL)
+	})
+	if err != nil {
+		return fmt.Errorf("joker: failed to add the TXT record: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	err := d.editZone(fqdn, func(zone, name string) string {
+		return removeTxtEntryFromZone(zone, name, value)
+	})
+	if err != nil {
+		return fmt.Errorf("joker: failed to remove the TXT record: %v", err)
+	}
+	return nil
+}
+
+// editZone reads the zone of the fqdn, edits it with the name of the record relative to the zone,
+// and sends it back if it changed. A session is opened for the edit.
+func (d *DNSProvider) editZone(fqdn string, edit func(zone, name string) string) error {
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return err
+	}
+
+	domain := acme.UnFqdn(authZone)
+	name := extractRecordName(fqdn, domain)
+
+	d.zoneMu.Lock()
+	defer d.zoneMu.Unlock()
+
+	authSid, err := d.login()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errLogout := d.logout(authSid); errLogout != nil {
+			log.Warnf("joker: could not log out: %v", errLogout)
+		}
+	}()
+
+	zone, err := d.getZone(authSid, domain)
+	if err != nil {
+		return err
+	}
+
+	edited := edit(zone, name)
+	if edited == zone {
+		return nil
+	}
+
+	return d.putZone(authSid, domain, edited)
+}
+
+// extractRecordName returns the name of the record of the fqdn relative to the domain, "@" for the domain itself.
+func extractRecordName(fqdn, domain string) string {
+	name := acme.UnFqdn(fqdn)
+	if name == domain {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
+
+// addTxtEntryToZone returns the zone with a TXT record, unless the zone already has it.
+// The other lines of the zone are kept as they are.
+func addTxtEntryToZone(zone, name, value string, ttl int) string {
+	lines := zoneLines(zone)
+	for _, line := range lines {
+		if isTxtEntry(line, name, value) {
+			return zone
+		}
+	}
+
+	lines = append(lines, fmt.Sprintf(`%s TXT 0 "%s" %d`, name, value, ttl))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// removeTxtEntryFromZone returns the zone without the TXT records of the name with the value, whatever their TTL.
+// The other lines of the zone are kept as they are.
+func removeTxtEntryFromZone(zone, name, value string) string {
+	lines := zoneLines(zone)
+
+	var kept []string
+	for _, line := range lines {
+		if !isTxtEntry(line, name, value) {
+			kept = append(kept, line)
+		}
+	}
+
+	if len(kept) == len(lines) {
+		return zone
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+// zoneLines splits the zone into lines, without the trailing empty lines.
+func zoneLines(zone string) []string {
+	zone = strings.TrimRight(strings.Replace(zone, "\r\n", "\n", -1), "\n")
+	if zone == "" {
+		return nil
+	}
+	return strings.Split(zone, "\n")
+}
+
+// isTxtEntry reports whether the line of the zone is a TXT record of the name with the value.
+// The lines are in the form: <label> <type> <priority> <target> <ttl>.
+func isTxtEntry(line, name, value string) bool {
+	fields := strings.Fields(line)
+	return len(fields) >= 4 &&
+		fields[0] == name &&
+		strings.EqualFold(fields[1], "TXT") &&
+		strings.Trim(fields[3], `"`) == value
+}
@@ -0,0 +1,273 @@
+package joker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest        bool
+	envTestAPIKey   string
+	envTestUsername string
+	envTestPassword string
+	envTestDomain   string
+)
+
+func init() {
+	envTestAPIKey = os.Getenv("JOKER_API_KEY")
+	envTestUsername = os.Getenv("JOKER_USERNAME")
+	envTestPassword = os.Getenv("JOKER_PASSWORD")
+	envTestDomain = os.Getenv("JOKER_DOMAIN")
+	liveTest = (len(envTestAPIKey) > 0 || len(envTestUsername) > 0 && len(envTestPassword) > 0) && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("JOKER_API_KEY", envTestAPIKey)
+	os.Setenv("JOKER_USERNAME", envTestUsername)
+	os.Setenv("JOKER_PASSWORD", envTestPassword)
+}
+
+const testZone = `$dyndns=no:yes:
+@ A 0 192.0.2.1 86400
+www CNAME 0 example.com. 86400
+@ MX 10 mail.example.com. 86400
+@ TXT 0 "v=spf1 mx -all" 86400
+`
+
+// mockServer is a DMAPI serving the zone of example.com.
+type mockServer struct {
+	mu       sync.Mutex
+	zone     string
+	puts     int
+	commands []string
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cmd := r.URL.Path[1:]
+	s.commands = append(s.commands, cmd)
+
+	if cmd == "login" {
+		if r.PostForm.Get("api-key") != "key" {
+			fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authentication error\n\n")
+			return
+		}
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: Command completed successfully\nAuth-Sid: sid\n\n")
+		return
+	}
+
+	if r.PostForm.Get("auth-sid") != "sid" {
+		fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authentication error\n\n")
+		return
+	}
+
+	if cmd != "logout" && r.PostForm.Get("domain") != "example.com" {
+		fmt.Fprint(w, "Status-Code: 2303\nStatus-Text: Object does not exist\nError: Domain not found\n\n")
+		return
+	}
+
+	switch cmd {
+	case "logout":
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: Command completed successfully\n\n")
+	case "dns-zone-get":
+		fmt.Fprint(w, "Status-Code: 0\r\nStatus-Text: Command completed successfully\r\n\r\n"+s.zone)
+	case "dns-zone-put":
+		s.zone = r.PostForm.Get("zone")
+		s.puts++
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: Command completed successfully\n\n")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("JOKER_API_KEY", "")
+	os.Setenv("JOKER_USERNAME", "user")
+	os.Setenv("JOKER_PASSWORD", "secret")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "user", provider.config.Username)
+
+	os.Setenv("JOKER_API_KEY", "key")
+
+	provider, err = NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "key", provider.config.APIKey)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("JOKER_API_KEY", "")
+	os.Setenv("JOKER_USERNAME", "")
+	os.Setenv("JOKER_PASSWORD", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "joker: some credentials information are missing: JOKER_USERNAME,JOKER_PASSWORD")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "joker: the configuration of the DNS provider is nil")
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "joker: credentials missing")
+}
+
+func TestParseResponse(t *testing.T) {
+	resp := parseResponse("Status-Code: 0\r\nStatus-Text: Command completed successfully\r\nAuth-Sid: sid\r\n\r\n@ A 0 192.0.2.1 86400\r\n")
+
+	assert.Equal(t, "0", resp.StatusCode)
+	assert.Equal(t, "Command completed successfully", resp.StatusText)
+	assert.Equal(t, "sid", resp.AuthSid)
+	assert.Equal(t, "@ A 0 192.0.2.1 86400\n", resp.Body)
+
+	resp = parseResponse("Status-Code: 2200\nStatus-Text: Authentication error\n")
+	assert.Equal(t, "2200", resp.StatusCode)
+	assert.Empty(t, resp.Body)
+}
+
+func TestExtractRecordName(t *testing.T) {
+	assert.Equal(t, "_acme-challenge", extractRecordName("_acme-challenge.example.com.", "example.com"))
+	assert.Equal(t, "_acme-challenge.www", extractRecordName("_acme-challenge.www.example.com.", "example.com"))
+	assert.Equal(t, "@", extractRecordName("example.com.", "example.com"))
+}
+
+func TestZoneRoundTrip(t *testing.T) {
+	zone := addTxtEntryToZone(testZone, "_acme-challenge", "value", 120)
+	assert.Equal(t, testZone+"_acme-challenge TXT 0 \"value\" 120\n", zone)
+
+	// the record is not added twice.
+	assert.Equal(t, zone, addTxtEntryToZone(zone, "_acme-challenge", "value", 300))
+
+	zone = addTxtEntryToZone(zone, "_acme-challenge", "other", 120)
+
+	zone = removeTxtEntryFromZone(zone, "_acme-challenge", "value")
+	assert.Equal(t, testZone+"_acme-challenge TXT 0 \"other\" 120\n", zone)
+
+	zone = removeTxtEntryFromZone(zone, "_acme-challenge", "other")
+	assert.Equal(t, testZone, zone)
+
+	// a zone without the record is left as it is.
+	assert.Equal(t, testZone, removeTxtEntryFromZone(testZone, "_acme-challenge", "value"))
+	assert.Equal(t, "@ TXT 0 \"value\" 120\n", addTxtEntryToZone("", "@", "value", 120))
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{zone: testZone}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Equal(t, testZone+"_acme-challenge.www TXT 0 \"pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM\" 120\n", server.zone)
+	assert.Equal(t, []string{"login", "dns-zone-get", "dns-zone-put", "logout"}, server.commands)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// the records of the user are kept.
+	assert.Equal(t, testZone, server.zone)
+	assert.Equal(t, 2, server.puts)
+
+	// the zone is not sent back when the record is already removed.
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.puts)
+}
+
+func TestDNSProvider_PresentConcurrently(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{zone: testZone}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "key"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			assert.NoError(t, provider.Present(domain, "", "keyAuth"))
+		}(domain)
+	}
+	wg.Wait()
+
+	assert.Contains(t, server.zone, "_acme-challenge.a TXT")
+	assert.Contains(t, server.zone, "_acme-challenge.b TXT")
+	assert.Contains(t, server.zone, "_acme-challenge.c TXT")
+}
+
+func TestDNSProvider_InvalidAPIKey(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{zone: testZone}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "invalid"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "joker: failed to add the TXT record: could not log in: DMAPI error on login (status 2200): Authentication error")
+	assert.Equal(t, testZone, server.zone)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
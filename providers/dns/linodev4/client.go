@@ -0,0 +1,151 @@
+package linodev4
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Domain represents a Linode domain
+type Domain struct {
+	ID     int    `json:"id"`
+	Domain string `json:"domain"`
+}
+
+// DomainsResponse represents a page of the domains endpoint
+type DomainsResponse struct {
+	Data    []Domain `json:"data"`
+	Page    int      `json:"page"`
+	Pages   int      `json:"pages"`
+	Results int      `json:"results"`
+}
+
+// DomainRecord represents a Linode domain record
+type DomainRecord struct {
+	ID     int    `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	TTLSec int    `json:"ttl_sec,omitempty"`
+}
+
+// APIError represents an error of the Linode API
+type APIError struct {
+	Errors []struct {
+		Reason string `json:"reason"`
+		Field  string `json:"field,omitempty"`
+	} `json:"errors"`
+}
+
+func (a APIError) Error() string {
+	var reasons []string
+	for _, e := range a.Errors {
+		if e.Field != "" {
+			reasons = append(reasons, e.Field+": "+e.Reason)
+		} else {
+			reasons = append(reasons, e.Reason)
+		}
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// getDomainID returns the ID of the domain with the given name, or 0 if the account has no such domain.
+func (d *DNSProvider) getDomainID(name string) (int, error) {
+	filter, err := json.Marshal(map[string]string{"domain": name})
+	if err != nil {
+		return 0, err
+	}
+
+	domains := &DomainsResponse{}
+	err = d.doRequest(http.MethodGet, "/domains", map[string]string{"X-Filter": string(filter)}, nil, domains)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, domain := range domains.Data {
+		if strings.EqualFold(domain.Domain, name) {
+			return domain.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// createRecord creates the record in the domain and returns its ID.
+func (d *DNSProvider) createRecord(domainID int, record DomainRecord) (int, error) {
+	resp := &DomainRecord{}
+	err := d.doRequest(http.MethodPost, "/domains/"+strconv.Itoa(domainID)+"/records", nil, record, resp)
+	if err != nil {
+		return 0, err
+	}
+	if resp.ID == 0 {
+		return 0, fmt.Errorf("no record ID in the API response")
+	}
+
+	return resp.ID, nil
+}
+
+// deleteRecord deletes the record with the given ID from the domain.
+func (d *DNSProvider) deleteRecord(domainID, recordID int) error {
+	return d.doRequest(http.MethodDelete, fmt.Sprintf("/domains/%d/records/%d", domainID, recordID), nil, nil, nil)
+}
+
+// doRequest sends the request to the Linode API with the given additional headers
+// and decodes the response into result.
+func (d *DNSProvider) doRequest(method, uri string, headers map[string]string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+d.config.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := APIError{}
+		if json.Unmarshal(raw, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			return fmt.Errorf("API error (HTTP %d): %v", resp.StatusCode, apiErr)
+		}
+		return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(raw, result)
+	if err != nil {
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+	return nil
+}
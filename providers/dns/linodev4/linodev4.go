@@ -0,0 +1,217 @@
+// Package linodev4 implements a DNS provider for solving the DNS-01 challenge
+// using the version 4 of the Linode API.
+// See https://developers.linode.com/api/v4#tag/Domains
+package linodev4
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.linode.com/v4"
+
+const (
+	dnsUpdateFreqMins  = 15
+	dnsUpdateFudgeSecs = 120
+)
+
+// allowedTTLs are the TTLs accepted by Linode for a record, in ascending order.
+var allowedTTLs = []int{300, 3600, 7200, 14400, 28800, 57600, 86400, 172800, 345600, 604800, 1209600, 2419200}
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Token   string
+	BaseURL string
+	// PropagationTimeout is computed from the next update of the Linode zone files if zero.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("LINODE_PROPAGATION_TIMEOUT", 0),
+		PollingInterval:    env.GetOrDefaultSecond("LINODE_POLLING_INTERVAL", 15*time.Second),
+		TTL:                env.GetOrDefaultInt("LINODE_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("LINODE_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+type record struct {
+	domainID int
+	id       int
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Linode API v4 to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// records are the domain and record IDs of the created records, by fqdn and value.
+	records   map[string]record
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Linode.
+// The personal access token must be passed in the environment variable LINODE_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("LINODE_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("linodev4: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Token = values["LINODE_TOKEN"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Linode.
+// The TTL is snapped to the nearest TTL accepted by Linode.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("linodev4: the configuration of the DNS provider is nil")
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("linodev4: Linode access token missing")
+	}
+
+	config.TTL = snapTTL(config.TTL)
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config, records: make(map[string]record)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	if d.config.PropagationTimeout > 0 {
+		return d.config.PropagationTimeout, d.config.PollingInterval
+	}
+
+	// Linode only updates their zone files every X minutes, so wait until
+	// the next update and then for the TTL of the record, plus a couple of minutes.
+	minsRemaining := dnsUpdateFreqMins - (time.Now().Minute() % dnsUpdateFreqMins)
+
+	timeout = (time.Duration(minsRemaining) * time.Minute) +
+		(time.Duration(d.config.TTL) * time.Second) +
+		(dnsUpdateFudgeSecs * time.Second)
+	return timeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, domainID, err := d.findDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("linodev4: %v", err)
+	}
+
+	id, err := d.createRecord(domainID, DomainRecord{
+		Type:   "TXT",
+		Name:   extractRecordName(fqdn, domainName),
+		Target: value,
+		TTLSec: d.config.TTL,
+	})
+	if err != nil {
+		return fmt.Errorf("linodev4: failed to create TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = record{domainID: domainID, id: id}
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present, by its ID.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordsMu.Lock()
+	rec, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("linodev4: unknown record ID for %q", fqdn)
+	}
+
+	err := d.deleteRecord(rec.domainID, rec.id)
+	if err != nil {
+		return fmt.Errorf("linodev4: failed to delete TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// findDomain walks up the labels of the fqdn and returns the name and ID
+// of the longest domain of the Linode account.
+func (d *DNSProvider) findDomain(fqdn string) (string, int, error) {
+	name := acme.UnFqdn(fqdn)
+
+	for _, index := range dns.Split(name) {
+		candidate := name[index:]
+
+		domainID, err := d.getDomainID(candidate)
+		if err != nil {
+			return "", 0, err
+		}
+
+		if domainID != 0 {
+			return candidate, domainID, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("could not find domain for %q", fqdn)
+}
+
+// snapTTL returns the TTL accepted by Linode nearest to ttl, the highest one in case of a tie.
+func snapTTL(ttl int) int {
+	nearest := allowedTTLs[0]
+	for _, allowed := range allowedTTLs[1:] {
+		if abs(allowed-ttl) <= abs(nearest-ttl) {
+			nearest = allowed
+		}
+	}
+	return nearest
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func extractRecordName(fqdn, domain string) string {
+	name := acme.UnFqdn(fqdn)
+	if idx := strings.LastIndex(name, "."+domain); idx != -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,311 @@
+package linodev4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest      bool
+	envTestToken  string
+	envTestTTL    string
+	envTestDomain string
+)
+
+func init() {
+	envTestToken = os.Getenv("LINODE_TOKEN")
+	envTestTTL = os.Getenv("LINODE_TTL")
+	envTestDomain = os.Getenv("LINODE_DOMAIN")
+	liveTest = len(envTestToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("LINODE_TOKEN", envTestToken)
+	os.Setenv("LINODE_TTL", envTestTTL)
+}
+
+// mockServer is a minimal in-memory implementation of the domains of the Linode API v4.
+type mockServer struct {
+	t  *testing.T
+	mu sync.Mutex
+	// domains are the IDs of the domains of the account, by name.
+	domains map[string]int
+	// records are the records of the domains, by domain ID and record ID.
+	records map[int]map[int]DomainRecord
+	nextID  int
+	// filters are the domains requested with the X-Filter header.
+	filters []string
+}
+
+func newMockServer(t *testing.T, domains map[string]int) *mockServer {
+	records := make(map[int]map[int]DomainRecord)
+	for _, id := range domains {
+		records[id] = make(map[int]DomainRecord)
+	}
+	return &mockServer{t: t, domains: domains, records: records, nextID: 1}
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors":[{"reason":"Invalid Token"}]}`)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/domains":
+		var filter map[string]string
+		require.NoError(m.t, json.Unmarshal([]byte(r.Header.Get("X-Filter")), &filter))
+		m.filters = append(m.filters, filter["domain"])
+
+		resp := DomainsResponse{Data: []Domain{}, Page: 1, Pages: 1}
+		if id, ok := m.domains[filter["domain"]]; ok {
+			resp.Data = append(resp.Data, Domain{ID: id, Domain: filter["domain"]})
+		}
+		resp.Results = len(resp.Data)
+		require.NoError(m.t, json.NewEncoder(w).Encode(resp))
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "domains" && parts[2] == "records":
+		domainID, _ := strconv.Atoi(parts[1])
+		records, ok := m.records[domainID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"reason":"Not found"}]}`)
+			return
+		}
+
+		var rec DomainRecord
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(&rec))
+		if rec.TTLSec != 0 && rec.TTLSec != snapTTL(rec.TTLSec) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"reason":"Invalid TTL","field":"ttl_sec"}]}`)
+			return
+		}
+		rec.ID = m.nextID
+		m.nextID++
+		records[rec.ID] = rec
+		require.NoError(m.t, json.NewEncoder(w).Encode(rec))
+
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[0] == "domains" && parts[2] == "records":
+		domainID, _ := strconv.Atoi(parts[1])
+		recordID, _ := strconv.Atoi(parts[3])
+		if _, ok := m.records[domainID][recordID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"reason":"Not found"}]}`)
+			return
+		}
+		delete(m.records[domainID], recordID)
+		fmt.Fprint(w, `{}`)
+
+	default:
+		m.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("LINODE_TOKEN", "secret")
+	os.Setenv("LINODE_TTL", "1000")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, 300, provider.config.TTL)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("LINODE_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "linodev4: some credentials information are missing: LINODE_TOKEN")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "linodev4: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(&Config{})
+	assert.EqualError(t, err, "linodev4: Linode access token missing")
+}
+
+func TestSnapTTL(t *testing.T) {
+	testCases := []struct {
+		ttl      int
+		expected int
+	}{
+		{ttl: 0, expected: 300},
+		{ttl: 120, expected: 300},
+		{ttl: 300, expected: 300},
+		{ttl: 1000, expected: 300},
+		{ttl: 1950, expected: 3600},
+		{ttl: 3000, expected: 3600},
+		{ttl: 5000, expected: 3600},
+		{ttl: 6000, expected: 7200},
+		{ttl: 86400, expected: 86400},
+		{ttl: 100000, expected: 86400},
+		{ttl: 2419200, expected: 2419200},
+		{ttl: 5000000, expected: 2419200},
+	}
+
+	for _, test := range testCases {
+		t.Run(strconv.Itoa(test.ttl), func(t *testing.T) {
+			assert.Equal(t, test.expected, snapTTL(test.ttl))
+		})
+	}
+}
+
+func TestDNSProvider_Timeout(t *testing.T) {
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.PollingInterval = 10 * time.Second
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	timeout, interval := provider.Timeout()
+	assert.InDelta(t, 15*time.Minute+300*time.Second+120*time.Second, timeout, float64(15*time.Minute))
+	assert.Equal(t, 10*time.Second, interval)
+
+	config.PropagationTimeout = 5 * time.Minute
+	timeout, _ = provider.Timeout()
+	assert.Equal(t, 5*time.Minute, timeout)
+}
+
+func TestDNSProvider_FindDomain(t *testing.T) {
+	server := newMockServer(t, map[string]int{"example.com": 1234, "sub.example.com": 5678})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		fqdn         string
+		expectedName string
+		expectedID   int
+	}{
+		{fqdn: "_acme-challenge.example.com.", expectedName: "example.com", expectedID: 1234},
+		{fqdn: "_acme-challenge.www.example.com.", expectedName: "example.com", expectedID: 1234},
+		{fqdn: "_acme-challenge.sub.example.com.", expectedName: "sub.example.com", expectedID: 5678},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.fqdn, func(t *testing.T) {
+			name, id, err := provider.findDomain(test.fqdn)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedName, name)
+			assert.Equal(t, test.expectedID, id)
+		})
+	}
+
+	assert.Equal(t, []string{
+		"_acme-challenge.example.com", "example.com",
+		"_acme-challenge.www.example.com", "www.example.com", "example.com",
+		"_acme-challenge.sub.example.com", "sub.example.com",
+	}, server.filters)
+
+	_, _, err = provider.findDomain("_acme-challenge.example.org.")
+	assert.EqualError(t, err, `could not find domain for "_acme-challenge.example.org."`)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := newMockServer(t, map[string]int{"example.com": 1234})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records[1234], 1)
+	assert.Equal(t, DomainRecord{
+		ID:     1,
+		Type:   "TXT",
+		Name:   "_acme-challenge.www",
+		Target: "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		TTLSec: 300,
+	}, server.records[1234][1])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	assert.Empty(t, server.records[1234])
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSProvider_PresentInvalidToken(t *testing.T) {
+	server := newMockServer(t, map[string]int{"example.com": 1234})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	provider.config.Token = "invalid"
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "linodev4: API error (HTTP 401): Invalid Token")
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	server := newMockServer(t, map[string]int{"example.com": 1234})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `linodev4: unknown record ID for "_acme-challenge.example.com."`)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
@@ -4,11 +4,15 @@ package namecheap
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xenolf/lego/acme"
@@ -29,62 +33,112 @@ import (
 //    address as a form or query string value. This code uses a namecheap
 //    service to query the client's IP address.
 
-var (
-	debug          = false
+const (
 	defaultBaseURL = "https://api.namecheap.com/xml.response"
-	getIPURL       = "https://dynamicdns.park-your-domain.com/getip"
+	sandboxBaseURL = "https://api.sandbox.namecheap.com/xml.response"
 )
 
+var getIPURL = "https://dynamicdns.park-your-domain.com/getip"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	BaseURL  string
+	APIUser  string
+	APIKey   string
+	ClientIP string
+	// PropagationTimeout is long since Namecheap can take up to an hour to complete an update.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	baseURL := defaultBaseURL
+	if sandbox := os.Getenv("NAMECHEAP_SANDBOX"); sandbox == "1" || sandbox == "true" {
+		baseURL = sandboxBaseURL
+	}
+
+	return &Config{
+		BaseURL:            baseURL,
+		ClientIP:           os.Getenv("NAMECHEAP_CLIENT_IP"),
+		PropagationTimeout: env.GetOrDefaultSecond("NAMECHEAP_PROPAGATION_TIMEOUT", 60*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("NAMECHEAP_POLLING_INTERVAL", 15*time.Second),
+		TTL:                env.GetOrDefaultInt("NAMECHEAP_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("NAMECHEAP_HTTP_TIMEOUT", 60*time.Second)),
+	}
+}
+
 // DNSProvider is an implementation of the ChallengeProviderTimeout interface
 // that uses Namecheap's tool API to manage TXT records for a domain.
 type DNSProvider struct {
-	baseURL  string
-	apiUser  string
-	apiKey   string
-	clientIP string
-	client   *http.Client
+	config *Config
+	// mu serializes the read-modify-write cycles of the host records.
+	mu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for namecheap.
 // Credentials must be passed in the environment variables: NAMECHEAP_API_USER
-// and NAMECHEAP_API_KEY.
+// and NAMECHEAP_API_KEY. NAMECHEAP_SANDBOX=1 uses the sandbox API, and the
+// whitelisted client IP is looked up unless set in NAMECHEAP_CLIENT_IP.
 func NewDNSProvider() (*DNSProvider, error) {
 	values, err := env.Get("NAMECHEAP_API_USER", "NAMECHEAP_API_KEY")
 	if err != nil {
 		return nil, fmt.Errorf("NameCheap: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["NAMECHEAP_API_USER"], values["NAMECHEAP_API_KEY"])
+	config := NewDefaultConfig()
+	config.APIUser = values["NAMECHEAP_API_USER"]
+	config.APIKey = values["NAMECHEAP_API_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for namecheap.
 func NewDNSProviderCredentials(apiUser, apiKey string) (*DNSProvider, error) {
-	if apiUser == "" || apiKey == "" {
+	config := NewDefaultConfig()
+	config.APIUser = apiUser
+	config.APIKey = apiKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for namecheap.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("NameCheap: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIUser == "" || config.APIKey == "" {
 		return nil, fmt.Errorf("Namecheap credentials missing")
 	}
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
 
-	clientIP, err := getClientIP(client)
-	if err != nil {
-		return nil, err
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(60 * time.Second)
 	}
 
-	return &DNSProvider{
-		baseURL:  defaultBaseURL,
-		apiUser:  apiUser,
-		apiKey:   apiKey,
-		clientIP: clientIP,
-		client:   client,
-	}, nil
+	if config.ClientIP == "" {
+		clientIP, err := getClientIP(config.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientIP = clientIP
+	}
+
+	return &DNSProvider{config: config}, nil
 }
 
 // Timeout returns the timeout and interval to use when checking for DNS
 // propagation. Namecheap can sometimes take a long time to complete an
-// update, so wait up to 60 minutes for the update to propagate.
+// update, so wait up to 60 minutes for the update to propagate by default.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return 60 * time.Minute, 15 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // host describes a DNS record returned by the Namecheap DNS gethosts API.
@@ -118,9 +172,7 @@ func getClientIP(client *http.Client) (addr string, err error) {
 		return "", err
 	}
 
-	if debug {
-		log.Println("Client IP:", string(clientIP))
-	}
+	log.Debugf("namecheap: client IP: %s", clientIP)
 	return string(clientIP), nil
 }
 
@@ -178,10 +230,10 @@ func newChallenge(domain, keyAuth string, tlds map[string]string) (*challenge, e
 // setGlobalParams adds the namecheap global parameters to the provided url
 // Values record.
 func (d *DNSProvider) setGlobalParams(v *url.Values, cmd string) {
-	v.Set("ApiUser", d.apiUser)
-	v.Set("ApiKey", d.apiKey)
-	v.Set("UserName", d.apiUser)
-	v.Set("ClientIp", d.clientIP)
+	v.Set("ApiUser", d.config.APIUser)
+	v.Set("ApiKey", d.config.APIKey)
+	v.Set("UserName", d.config.APIUser)
+	v.Set("ClientIp", d.config.ClientIP)
 	v.Set("Command", cmd)
 }
 
@@ -190,17 +242,17 @@ func (d *DNSProvider) getTLDs() (tlds map[string]string, err error) {
 	values := make(url.Values)
 	d.setGlobalParams(&values, "namecheap.domains.getTldList")
 
-	reqURL, _ := url.Parse(d.baseURL)
+	reqURL, _ := url.Parse(d.config.BaseURL)
 	reqURL.RawQuery = values.Encode()
 
-	resp, err := d.client.Get(reqURL.String())
+	resp, err := d.config.HTTPClient.Get(reqURL.String())
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("getHosts HTTP error %d", resp.StatusCode)
+		return nil, fmt.Errorf("getTldList HTTP error %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -232,56 +284,63 @@ func (d *DNSProvider) getTLDs() (tlds map[string]string, err error) {
 	return tlds, nil
 }
 
-// getHosts reads the full list of DNS host records using the Namecheap API.
-func (d *DNSProvider) getHosts(ch *challenge) (hosts []host, err error) {
+// getHosts reads the full list of DNS host records using the Namecheap API,
+// with the email type of the domain which setHosts would otherwise reset.
+func (d *DNSProvider) getHosts(ch *challenge) (hosts []host, emailType string, err error) {
 	values := make(url.Values)
 	d.setGlobalParams(&values, "namecheap.domains.dns.getHosts")
 	values.Set("SLD", ch.sld)
 	values.Set("TLD", ch.tld)
 
-	reqURL, _ := url.Parse(d.baseURL)
+	reqURL, _ := url.Parse(d.config.BaseURL)
 	reqURL.RawQuery = values.Encode()
 
-	resp, err := d.client.Get(reqURL.String())
+	resp, err := d.config.HTTPClient.Get(reqURL.String())
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("getHosts HTTP error %d", resp.StatusCode)
+		return nil, "", fmt.Errorf("getHosts HTTP error %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	type GetHostsResponse struct {
 		XMLName xml.Name   `xml:"ApiResponse"`
 		Status  string     `xml:"Status,attr"`
 		Errors  []apierror `xml:"Errors>Error"`
-		Hosts   []host     `xml:"CommandResponse>DomainDNSGetHostsResult>host"`
+		Result  struct {
+			EmailType string `xml:",attr"`
+			Hosts     []host `xml:"host"`
+		} `xml:"CommandResponse>DomainDNSGetHostsResult"`
 	}
 
 	var ghr GetHostsResponse
 	if err = xml.Unmarshal(body, &ghr); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if len(ghr.Errors) > 0 {
-		return nil, fmt.Errorf("Namecheap error: %s [%d]",
+		return nil, "", fmt.Errorf("Namecheap error: %s [%d]",
 			ghr.Errors[0].Description, ghr.Errors[0].Number)
 	}
 
-	return ghr.Hosts, nil
+	return ghr.Result.Hosts, ghr.Result.EmailType, nil
 }
 
 // setHosts writes the full list of DNS host records using the Namecheap API.
-func (d *DNSProvider) setHosts(ch *challenge, hosts []host) error {
+func (d *DNSProvider) setHosts(ch *challenge, hosts []host, emailType string) error {
 	values := make(url.Values)
 	d.setGlobalParams(&values, "namecheap.domains.dns.setHosts")
 	values.Set("SLD", ch.sld)
 	values.Set("TLD", ch.tld)
+	if emailType != "" {
+		values.Set("EmailType", emailType)
+	}
 
 	for i, h := range hosts {
 		ind := fmt.Sprintf("%d", i+1)
@@ -292,7 +351,7 @@ func (d *DNSProvider) setHosts(ch *challenge, hosts []host) error {
 		values.Add("TTL"+ind, h.TTL)
 	}
 
-	resp, err := d.client.PostForm(d.baseURL, values)
+	resp, err := d.config.HTTPClient.PostForm(d.config.BaseURL, values)
 	if err != nil {
 		return err
 	}
@@ -332,26 +391,26 @@ func (d *DNSProvider) setHosts(ch *challenge, hosts []host) error {
 }
 
 // addChallengeRecord adds a DNS challenge TXT record to a list of namecheap
-// host records.
-func (d *DNSProvider) addChallengeRecord(ch *challenge, hosts *[]host) {
+// host records. The other TXT records of the same name are kept, since the
+// challenges of a domain and its wildcard share the record name.
+// Return true if a record was added.
+func (d *DNSProvider) addChallengeRecord(ch *challenge, hosts *[]host) bool {
 	host := host{
 		Name:    ch.key,
 		Type:    "TXT",
 		Address: ch.keyValue,
 		MXPref:  "10",
-		TTL:     "120",
+		TTL:     strconv.Itoa(d.config.TTL),
 	}
 
-	// If there's already a TXT record with the same name, replace it.
-	for i, h := range *hosts {
-		if h.Name == ch.key && h.Type == "TXT" {
-			(*hosts)[i] = host
-			return
+	for _, h := range *hosts {
+		if h.Name == ch.key && h.Type == "TXT" && h.Address == ch.keyValue {
+			return false
 		}
 	}
 
-	// No record was replaced, so add a new one.
 	*hosts = append(*hosts, host)
+	return true
 }
 
 // removeChallengeRecord removes a DNS challenge TXT record from a list of
@@ -359,7 +418,7 @@ func (d *DNSProvider) addChallengeRecord(ch *challenge, hosts *[]host) {
 func (d *DNSProvider) removeChallengeRecord(ch *challenge, hosts *[]host) bool {
 	// Find the challenge TXT record and remove it if found.
 	for i, h := range *hosts {
-		if h.Name == ch.key && h.Type == "TXT" {
+		if h.Name == ch.key && h.Type == "TXT" && h.Address == ch.keyValue {
 			*hosts = append((*hosts)[:i], (*hosts)[i+1:]...)
 			return true
 		}
@@ -370,6 +429,21 @@ func (d *DNSProvider) removeChallengeRecord(ch *challenge, hosts *[]host) bool {
 
 // Present installs a TXT record for the DNS challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	return d.updateHosts(domain, keyAuth, d.addChallengeRecord)
+}
+
+// CleanUp removes a TXT record used for a previous DNS challenge.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return d.updateHosts(domain, keyAuth, d.removeChallengeRecord)
+}
+
+// updateHosts applies the change to the full list of host records of the domain and submits it
+// if changed. Since setHosts replaces all the records, the submitted list is checked to differ
+// from the current one by the challenge record only, and read back to check that no record was lost.
+func (d *DNSProvider) updateHosts(domain, keyAuth string, change func(ch *challenge, hosts *[]host) bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	tlds, err := d.getTLDs()
 	if err != nil {
 		return err
@@ -380,44 +454,78 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 		return err
 	}
 
-	hosts, err := d.getHosts(ch)
+	current, emailType, err := d.getHosts(ch)
 	if err != nil {
 		return err
 	}
 
-	d.addChallengeRecord(ch, &hosts)
+	hosts := append([]host(nil), current...)
+	if !change(ch, &hosts) {
+		return nil
+	}
 
-	if debug {
-		for _, h := range hosts {
-			log.Printf(
-				"%-5.5s %-30.30s %-6s %-70.70s\n",
-				h.Type, h.Name, h.TTL, h.Address)
+	added, removed := diffHosts(current, hosts)
+	for _, h := range append(added, removed...) {
+		if h.Type != "TXT" || h.Name != ch.key || h.Address != ch.keyValue {
+			return fmt.Errorf("Namecheap: refusing to change the record %s %s %q of %s", h.Type, h.Name, h.Address, ch.domain)
 		}
 	}
 
-	return d.setHosts(ch, hosts)
-}
+	for _, h := range hosts {
+		log.Debugf("namecheap: %-5.5s %-30.30s %-6s %-70.70s", h.Type, h.Name, h.TTL, h.Address)
+	}
 
-// CleanUp removes a TXT record used for a previous DNS challenge.
-func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	tlds, err := d.getTLDs()
+	err = d.setHosts(ch, hosts, emailType)
 	if err != nil {
 		return err
 	}
 
-	ch, err := newChallenge(domain, keyAuth, tlds)
+	written, _, err := d.getHosts(ch)
 	if err != nil {
-		return err
+		return fmt.Errorf("Namecheap: could not read back the records of %s: %v", ch.domain, err)
 	}
 
-	hosts, err := d.getHosts(ch)
-	if err != nil {
-		return err
+	if _, lost := diffHosts(hosts, written); len(lost) > 0 {
+		return fmt.Errorf("Namecheap: %d records of %s were not kept by setHosts: %s", len(lost), ch.domain, formatHosts(lost))
 	}
 
-	if removed := d.removeChallengeRecord(ch, &hosts); !removed {
-		return nil
+	return nil
+}
+
+// diffHosts returns the records added to and removed from the before list in the after list.
+// The records are compared by type, name and address, the names being case insensitive.
+func diffHosts(before, after []host) (added, removed []host) {
+	counts := make(map[string]int)
+	for _, h := range before {
+		counts[hostKey(h)]++
 	}
 
-	return d.setHosts(ch, hosts)
+	for _, h := range after {
+		if counts[hostKey(h)] > 0 {
+			counts[hostKey(h)]--
+		} else {
+			added = append(added, h)
+		}
+	}
+
+	for _, h := range before {
+		if counts[hostKey(h)] > 0 {
+			counts[hostKey(h)]--
+			removed = append(removed, h)
+		}
+	}
+
+	return added, removed
+}
+
+func hostKey(h host) string {
+	return h.Type + " " + strings.ToLower(h.Name) + " " + h.Address
+}
+
+func formatHosts(hosts []host) string {
+	var records []string
+	for _, h := range hosts {
+		records = append(records, fmt.Sprintf("%s %s %q", h.Type, h.Name, h.Address))
+	}
+	return strings.Join(records, ", ")
 }
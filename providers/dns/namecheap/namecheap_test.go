@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -52,10 +55,10 @@ func mockServer(tc *testcase, t *testing.T, w http.ResponseWriter, r *http.Reque
 		case "namecheap.domains.dns.getHosts":
 			assertHdr(tc, t, &values)
 			w.WriteHeader(http.StatusOK)
-			fmt.Fprintf(w, tc.getHostsResponse)
+			fmt.Fprint(w, tc.getHostsResponse)
 		case "namecheap.domains.getTldList":
 			w.WriteHeader(http.StatusOK)
-			fmt.Fprintf(w, responseGetTlds)
+			fmt.Fprint(w, responseGetTlds)
 		default:
 			t.Errorf("Unexpected GET command: %s", cmd)
 		}
@@ -86,16 +89,10 @@ func testGetHosts(tc *testcase, t *testing.T) {
 		}))
 	defer mock.Close()
 
-	prov := &DNSProvider{
-		baseURL:  mock.URL,
-		apiUser:  fakeUser,
-		apiKey:   fakeKey,
-		clientIP: fakeClientIP,
-		client:   &http.Client{Timeout: 60 * time.Second},
-	}
+	prov := mockDNSProvider(mock.URL)
 
 	ch, _ := newChallenge(tc.domain, "", tlds)
-	hosts, err := prov.getHosts(ch)
+	hosts, _, err := prov.getHosts(ch)
 	if tc.errString != "" {
 		if err == nil || err.Error() != tc.errString {
 			t.Errorf("Namecheap getHosts case %s expected error", tc.name)
@@ -131,11 +128,14 @@ next2:
 
 func mockDNSProvider(url string) *DNSProvider {
 	return &DNSProvider{
-		baseURL:  url,
-		apiUser:  fakeUser,
-		apiKey:   fakeKey,
-		clientIP: fakeClientIP,
-		client:   &http.Client{Timeout: 60 * time.Second},
+		config: &Config{
+			BaseURL:    url,
+			APIUser:    fakeUser,
+			APIKey:     fakeKey,
+			ClientIP:   fakeClientIP,
+			TTL:        120,
+			HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		},
 	}
 }
 
@@ -148,7 +148,7 @@ func testSetHosts(tc *testcase, t *testing.T) {
 
 	prov := mockDNSProvider(mock.URL)
 	ch, _ := newChallenge(tc.domain, "", tlds)
-	hosts, err := prov.getHosts(ch)
+	hosts, emailType, err := prov.getHosts(ch)
 	if tc.errString != "" {
 		if err == nil || err.Error() != tc.errString {
 			t.Errorf("Namecheap getHosts case %s expected error", tc.name)
@@ -162,49 +162,79 @@ func testSetHosts(tc *testcase, t *testing.T) {
 		return
 	}
 
-	err = prov.setHosts(ch, hosts)
+	err = prov.setHosts(ch, hosts, emailType)
 	if err != nil {
 		t.Errorf("Namecheap setHosts case %s failed", tc.name)
 	}
 }
 
 func testPresent(tc *testcase, t *testing.T) {
-	mock := httptest.NewServer(http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			mockServer(tc, t, w, r)
-		}))
+	fake := &fakeNamecheap{t: t, hosts: append([]host(nil), tc.hosts...), emailType: "MXE"}
+	if tc.errString != "" {
+		fake.getHostsResponse = tc.getHostsResponse
+	}
+
+	mock := httptest.NewServer(fake)
 	defer mock.Close()
 
 	prov := mockDNSProvider(mock.URL)
+
 	err := prov.Present(tc.domain, "", "dummyKey")
 	if tc.errString != "" {
 		if err == nil || err.Error() != tc.errString {
 			t.Errorf("Namecheap Present case %s expected error", tc.name)
 		}
-	} else {
-		if err != nil {
-			t.Errorf("Namecheap Present case %s failed\n%v", tc.name, err)
-		}
+		return
+	}
+	if err != nil {
+		t.Errorf("Namecheap Present case %s failed\n%v", tc.name, err)
+		return
+	}
+
+	ch, _ := newChallenge(tc.domain, "dummyKey", tlds)
+	expected := append(append([]host(nil), tc.hosts...), host{Type: "TXT", Name: ch.key, Address: ch.keyValue, MXPref: "10", TTL: "120"})
+	if !reflect.DeepEqual(fake.hosts, expected) {
+		t.Errorf("Namecheap Present case %s: expected the records %v, got %v", tc.name, expected, fake.hosts)
+	}
+	if fake.emailType != "MXE" {
+		t.Errorf("Namecheap Present case %s: expected the email type to be kept, got %q", tc.name, fake.emailType)
 	}
 }
 
 func testCleanUp(tc *testcase, t *testing.T) {
-	mock := httptest.NewServer(http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			mockServer(tc, t, w, r)
-		}))
+	fake := &fakeNamecheap{t: t, hosts: append([]host(nil), tc.hosts...), emailType: "MXE"}
+	if tc.errString != "" {
+		fake.getHostsResponse = tc.getHostsResponse
+	}
+
+	mock := httptest.NewServer(fake)
 	defer mock.Close()
 
 	prov := mockDNSProvider(mock.URL)
+
+	ch, _ := newChallenge(tc.domain, "dummyKey", tlds)
+	other, _ := newChallenge("*."+tc.domain, "otherKey", tlds)
+	fake.hosts = append(fake.hosts,
+		host{Type: "TXT", Name: ch.key, Address: other.keyValue, MXPref: "10", TTL: "120"},
+		host{Type: "TXT", Name: ch.key, Address: ch.keyValue, MXPref: "10", TTL: "120"},
+	)
+
 	err := prov.CleanUp(tc.domain, "", "dummyKey")
 	if tc.errString != "" {
 		if err == nil || err.Error() != tc.errString {
 			t.Errorf("Namecheap CleanUp case %s expected error", tc.name)
 		}
-	} else {
-		if err != nil {
-			t.Errorf("Namecheap CleanUp case %s failed\n%v", tc.name, err)
-		}
+		return
+	}
+	if err != nil {
+		t.Errorf("Namecheap CleanUp case %s failed\n%v", tc.name, err)
+		return
+	}
+
+	// The challenge record of the wildcard domain is kept.
+	expected := append(append([]host(nil), tc.hosts...), host{Type: "TXT", Name: ch.key, Address: other.keyValue, MXPref: "10", TTL: "120"})
+	if !reflect.DeepEqual(fake.hosts, expected) {
+		t.Errorf("Namecheap CleanUp case %s: expected the records %v, got %v", tc.name, expected, fake.hosts)
 	}
 }
 
@@ -217,6 +247,62 @@ func TestNamecheap(t *testing.T) {
 	}
 }
 
+func TestNamecheapPresentIdempotent(t *testing.T) {
+	tc := &testcases[1]
+	fake := &fakeNamecheap{t: t, hosts: append([]host(nil), tc.hosts...), emailType: "MXE"}
+
+	mock := httptest.NewServer(fake)
+	defer mock.Close()
+
+	prov := mockDNSProvider(mock.URL)
+
+	for i := 0; i < 2; i++ {
+		if err := prov.Present(tc.domain, "", "dummyKey"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(fake.hosts) != len(tc.hosts)+1 {
+		t.Errorf("Expected the challenge record to be added once, got %v", fake.hosts)
+	}
+	if fake.setHostsCalls != 1 {
+		t.Errorf("Expected a single setHosts call, got %d", fake.setHostsCalls)
+	}
+}
+
+func TestNamecheapPresentLostRecords(t *testing.T) {
+	tc := &testcases[0]
+	// The update keeps the first two records only.
+	fake := &fakeNamecheap{t: t, hosts: append([]host(nil), tc.hosts...), emailType: "MXE", keep: 2}
+
+	mock := httptest.NewServer(fake)
+	defer mock.Close()
+
+	prov := mockDNSProvider(mock.URL)
+
+	err := prov.Present(tc.domain, "", "dummyKey")
+	if err == nil || !strings.HasPrefix(err.Error(), "Namecheap: 5 records of test.example.com were not kept by setHosts: AAAA a") {
+		t.Errorf("Expected the lost records to be reported, got %v", err)
+	}
+}
+
+func TestNewDNSProviderSandbox(t *testing.T) {
+	defer func() {
+		os.Unsetenv("NAMECHEAP_SANDBOX")
+		os.Unsetenv("NAMECHEAP_CLIENT_IP")
+	}()
+	os.Setenv("NAMECHEAP_SANDBOX", "1")
+	os.Setenv("NAMECHEAP_CLIENT_IP", fakeClientIP)
+
+	prov, err := NewDNSProviderCredentials(fakeUser, fakeKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	assertEq(t, "BaseURL", prov.config.BaseURL, sandboxBaseURL)
+	assertEq(t, "ClientIP", prov.config.ClientIP, fakeClientIP)
+}
+
 func TestNamecheapDomainSplit(t *testing.T) {
 	tests := []struct {
 		domain string
@@ -268,6 +354,66 @@ func TestNamecheapDomainSplit(t *testing.T) {
 	}
 }
 
+// fakeNamecheap is a stateful mock of the Namecheap API, setHosts replacing all the host records.
+type fakeNamecheap struct {
+	t         *testing.T
+	hosts     []host
+	emailType string
+	// getHostsResponse, if set, is returned by getHosts instead of the records.
+	getHostsResponse string
+	// keep, if set, is the number of records kept by setHosts, to simulate a lossy update.
+	keep          int
+	setHostsCalls int
+}
+
+func (f *fakeNamecheap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	switch cmd := r.Form.Get("Command"); cmd {
+	case "namecheap.domains.getTldList":
+		fmt.Fprint(w, responseGetTlds)
+
+	case "namecheap.domains.dns.getHosts":
+		if f.getHostsResponse != "" {
+			fmt.Fprint(w, f.getHostsResponse)
+			return
+		}
+
+		fmt.Fprintf(w, `<ApiResponse Status="OK"><Errors /><CommandResponse Type="namecheap.domains.dns.getHosts"><DomainDNSGetHostsResult Domain="example.com" EmailType=%q>`, f.emailType)
+		for _, h := range f.hosts {
+			fmt.Fprintf(w, `<host Name=%q Type=%q Address=%q MXPref=%q TTL=%q />`, h.Name, h.Type, h.Address, h.MXPref, h.TTL)
+		}
+		fmt.Fprint(w, `</DomainDNSGetHostsResult></CommandResponse></ApiResponse>`)
+
+	case "namecheap.domains.dns.setHosts":
+		if r.Method != http.MethodPost {
+			f.t.Errorf("Unexpected method %s for setHosts", r.Method)
+		}
+		f.setHostsCalls++
+
+		var hosts []host
+		for i := 1; r.PostForm.Get(fmt.Sprintf("HostName%d", i)) != ""; i++ {
+			hosts = append(hosts, host{
+				Name:    r.PostForm.Get(fmt.Sprintf("HostName%d", i)),
+				Type:    r.PostForm.Get(fmt.Sprintf("RecordType%d", i)),
+				Address: r.PostForm.Get(fmt.Sprintf("Address%d", i)),
+				MXPref:  r.PostForm.Get(fmt.Sprintf("MXPref%d", i)),
+				TTL:     r.PostForm.Get(fmt.Sprintf("TTL%d", i)),
+			})
+		}
+		if f.keep > 0 && len(hosts) > f.keep {
+			hosts = hosts[:f.keep]
+		}
+
+		f.hosts = hosts
+		f.emailType = r.PostForm.Get("EmailType")
+		fmt.Fprint(w, responseSetHostsSuccess1)
+
+	default:
+		f.t.Errorf("Unexpected command: %s", cmd)
+	}
+}
+
 type testcase struct {
 	name             string
 	domain           string
@@ -3,18 +3,57 @@
 package namedotcom
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/namedotcom/go/namecom"
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// minTTL is the minimal TTL allowed by Name.com.
+const minTTL = 300
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Username string
+	APIToken string
+	// Server is the host of the API, api.name.com by default.
+	Server             string
+	TTL                int
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		TTL:                env.GetOrDefaultInt("NAMECOM_TTL", minTTL),
+		PropagationTimeout: env.GetOrDefaultSecond("NAMECOM_PROPAGATION_TIMEOUT", 15*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("NAMECOM_POLLING_INTERVAL", 20*time.Second),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("NAMECOM_HTTP_TIMEOUT", 10*time.Second)),
+	}
+}
+
+// createdRecord is a record created by Present, with its domain.
+type createdRecord struct {
+	domainName string
+	id         int32
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface.
 type DNSProvider struct {
+	config *Config
 	client *namecom.NameCom
+	// records are the created records, by fqdn and value.
+	records   map[string]createdRecord
+	recordsMu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for namedotcom.
@@ -25,103 +64,160 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("Name.com: %v", err)
 	}
 
-	server := os.Getenv("NAMECOM_SERVER")
-	return NewDNSProviderCredentials(values["NAMECOM_USERNAME"], values["NAMECOM_API_TOKEN"], server)
+	config := NewDefaultConfig()
+	config.Username = values["NAMECOM_USERNAME"]
+	config.APIToken = values["NAMECOM_API_TOKEN"]
+	config.Server = os.Getenv("NAMECOM_SERVER")
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for namedotcom.
 func NewDNSProviderCredentials(username, apiToken, server string) (*DNSProvider, error) {
-	if username == "" {
+	config := NewDefaultConfig()
+	config.Username = username
+	config.APIToken = apiToken
+	config.Server = server
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for namedotcom.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("Name.com: the configuration of the DNS provider is nil")
+	}
+
+	if config.Username == "" {
 		return nil, fmt.Errorf("Name.com Username is required")
 	}
-	if apiToken == "" {
+	if config.APIToken == "" {
 		return nil, fmt.Errorf("Name.com API token is required")
 	}
 
-	client := namecom.New(username, apiToken)
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("Name.com: invalid TTL, TTL (%d) must be greater than %d", config.TTL, minTTL-1)
+	}
+
+	client := namecom.New(config.Username, config.APIToken)
 
-	if server != "" {
-		client.Server = server
+	if config.Server != "" {
+		client.Server = config.Server
 	}
+	if config.HTTPClient != nil {
+		client.Client = config.HTTPClient
+	}
+
+	return &DNSProvider{
+		config:  config,
+		client:  client,
+		records: make(map[string]createdRecord),
+	}, nil
+}
 
-	return &DNSProvider{client: client}, nil
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, err := d.findDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("Name.com: %v", err)
+	}
 
 	request := &namecom.Record{
-		DomainName: domain,
-		Host:       d.extractRecordName(fqdn, domain),
+		DomainName: domainName,
+		Host:       extractRecordName(fqdn, domainName),
 		Type:       "TXT",
-		TTL:        uint32(ttl),
+		TTL:        uint32(d.config.TTL),
 		Answer:     value,
 	}
 
-	_, err := d.client.CreateRecord(request)
+	record, err := d.client.CreateRecord(request)
 	if err != nil {
 		return fmt.Errorf("Name.com API call failed: %v", err)
 	}
 
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = createdRecord{domainName: domainName, id: record.ID}
+	d.recordsMu.Unlock()
+
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters.
+// CleanUp removes the TXT record created by Present, by its ID.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	records, err := d.getRecords(domain)
-	if err != nil {
-		return err
-	}
-
-	for _, rec := range records {
-		if rec.Fqdn == fqdn && rec.Type == "TXT" {
-			request := &namecom.DeleteRecordRequest{
-				DomainName: domain,
-				ID:         rec.ID,
-			}
-			_, err := d.client.DeleteRecord(request)
-			if err != nil {
-				return err
-			}
-		}
+	d.recordsMu.Lock()
+	record, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Name.com: unknown record ID for %q", fqdn)
+	}
+
+	request := &namecom.DeleteRecordRequest{
+		DomainName: record.domainName,
+		ID:         record.id,
+	}
+	if _, err := d.client.DeleteRecord(request); err != nil {
+		return fmt.Errorf("Name.com API call failed: %v", err)
 	}
 
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
 	return nil
 }
 
-func (d *DNSProvider) getRecords(domain string) ([]*namecom.Record, error) {
-	var (
-		err      error
-		records  []*namecom.Record
-		response *namecom.ListRecordsResponse
-	)
-
-	request := &namecom.ListRecordsRequest{
-		DomainName: domain,
-		Page:       1,
+// findDomain returns the domain of the account the fqdn belongs to,
+// walking up its labels from the longest candidate.
+func (d *DNSProvider) findDomain(fqdn string) (string, error) {
+	domains, err := d.getDomains()
+	if err != nil {
+		return "", fmt.Errorf("could not list the domains: %v", err)
 	}
 
-	for request.Page > 0 {
-		response, err = d.client.ListRecords(request)
-		if err != nil {
-			return nil, err
+	labels := strings.Split(strings.ToLower(acme.UnFqdn(fqdn)), ".")
+	for i := range labels {
+		if candidate := strings.Join(labels[i:], "."); domains[candidate] {
+			return candidate, nil
 		}
+	}
+
+	return "", fmt.Errorf("could not find the domain of %q in the account", fqdn)
+}
+
+// getDomains returns the names of the domains of the account.
+// Only the first page is read: the client does not send the paging parameters,
+// and a page holds up to 1000 domains by default.
+func (d *DNSProvider) getDomains() (map[string]bool, error) {
+	response, err := d.client.ListDomains(&namecom.ListDomainsRequest{})
+	if err != nil {
+		return nil, err
+	}
 
-		records = append(records, response.Records...)
-		request.Page = response.NextPage
+	domains := make(map[string]bool)
+	for _, domain := range response.Domains {
+		domains[strings.ToLower(domain.DomainName)] = true
 	}
 
-	return records, nil
+	return domains, nil
 }
 
-func (d *DNSProvider) extractRecordName(fqdn, domain string) string {
+// extractRecordName returns the host of the fqdn relative to the domain, as Name.com expects it.
+func extractRecordName(fqdn, domain string) string {
 	name := acme.UnFqdn(fqdn)
-	if idx := strings.Index(name, "."+domain); idx != -1 {
-		return name[:idx]
+	if strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(domain)) {
+		return name[:len(name)-len(domain)-1]
 	}
 	return name
 }
@@ -1,11 +1,19 @@
 package namedotcom
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/namedotcom/go/namecom"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -27,6 +35,194 @@ func init() {
 	}
 }
 
+// mockServer is a Name.com API with the domains example.com and example.org.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	records  []namecom.Record
+	requests []string
+	nextID   int32
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	username, token, ok := r.BasicAuth()
+	if !ok || username != "user" || token != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(namecom.ErrorResponse{Message: "Unauthenticated"})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v4/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v4/domains":
+		json.NewEncoder(w).Encode(namecom.ListDomainsResponse{Domains: []*namecom.Domain{{DomainName: "example.com"}, {DomainName: "Example.ORG"}}})
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "records":
+		var record namecom.Record
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&record))
+
+		s.nextID++
+		record.ID = s.nextID
+		record.DomainName = parts[1]
+		record.Fqdn = record.Host + "." + record.DomainName + "."
+		s.records = append(s.records, record)
+
+		json.NewEncoder(w).Encode(record)
+
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[2] == "records":
+		for i, record := range s.records {
+			if record.DomainName == parts[1] && fmt.Sprint(record.ID) == parts[3] {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				w.Write([]byte("{}"))
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(namecom.ErrorResponse{Message: "Not Found"})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(namecom.ErrorResponse{Message: "Not Found"})
+	}
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "Name.com: the configuration of the DNS provider is nil")
+
+	config := NewDefaultConfig()
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "Name.com Username is required")
+
+	config.Username = "user"
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "Name.com API token is required")
+
+	config.APIToken = "secret"
+	config.TTL = 120
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, "Name.com: invalid TTL, TTL (120) must be greater than 299")
+}
+
+func TestExtractRecordName(t *testing.T) {
+	testCases := []struct {
+		fqdn     string
+		domain   string
+		expected string
+	}{
+		{fqdn: "_acme-challenge.example.com.", domain: "example.com", expected: "_acme-challenge"},
+		{fqdn: "_acme-challenge.www.example.com.", domain: "example.com", expected: "_acme-challenge.www"},
+		{fqdn: "_acme-challenge.Example.COM.", domain: "example.com", expected: "_acme-challenge"},
+		// the domain only matches at the end of the fqdn.
+		{fqdn: "_acme-challenge.example.com.example.org.", domain: "example.org", expected: "_acme-challenge.example.com"},
+		{fqdn: "_acme-challenge.notexample.com.", domain: "example.com", expected: "_acme-challenge.notexample.com"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, extractRecordName(test.fqdn, test.domain), test.fqdn)
+	}
+}
+
+func TestDNSProvider_findDomain(t *testing.T) {
+	server := &mockServer{t: t}
+
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.APIToken = "secret"
+	config.Server = strings.TrimPrefix(ts.URL, "https://")
+	config.HTTPClient = ts.Client()
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	domain, err := provider.findDomain("_acme-challenge.www.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+
+	domain, err = provider.findDomain("_acme-challenge.example.org.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.org", domain)
+
+	_, err = provider.findDomain("_acme-challenge.example.net.")
+	assert.EqualError(t, err, `could not find the domain of "_acme-challenge.example.net." in the account`)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := &mockServer{t: t}
+
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.APIToken = "secret"
+	config.Server = strings.TrimPrefix(ts.URL, "https://")
+	config.HTTPClient = ts.Client()
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "otherKeyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records, 2)
+	assert.Equal(t, namecom.Record{
+		ID:         1,
+		DomainName: "example.com",
+		Host:       "_acme-challenge.www",
+		Fqdn:       "_acme-challenge.www.example.com.",
+		Type:       "TXT",
+		Answer:     "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		TTL:        300,
+	}, server.records[0])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// only the record of the value is deleted, by its ID.
+	require.Len(t, server.records, 1)
+	assert.Equal(t, int32(2), server.records[0].ID)
+	assert.Equal(t, "DELETE /v4/domains/example.com/records/1", server.requests[len(server.requests)-1])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, `Name.com: unknown record ID for "_acme-challenge.www.example.com."`)
+}
+
+func TestDNSProvider_InvalidCredentials(t *testing.T) {
+	server := &mockServer{t: t}
+
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Username = "user"
+	config.APIToken = "secret"
+	config.Server = strings.TrimPrefix(ts.URL, "https://")
+	config.HTTPClient = ts.Client()
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	provider.client.Token = "other"
+
+	err = provider.Present("example.com", "", "keyAuth")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Name.com: could not list the domains: Unauthenticated")
+}
+
 func TestLiveNamedotcomPresent(t *testing.T) {
 	if !namedotcomLiveTest {
 		t.Skip("skipping live test")
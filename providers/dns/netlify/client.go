@@ -0,0 +1,110 @@
+package netlify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// dnsZone is a DNS zone of the Netlify account.
+type dnsZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// dnsRecord is a record of a DNS zone.
+type dnsRecord struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Hostname string `json:"hostname"`
+	Value    string `json:"value"`
+	TTL      int    `json:"ttl,omitempty"`
+	DNSZone  string `json:"dns_zone_id,omitempty"`
+}
+
+// APIError is an error returned by the Netlify API.
+type APIError struct {
+	StatusCode int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+// listZones returns the DNS zones of the account.
+func (d *DNSProvider) listZones() ([]dnsZone, error) {
+	var zones []dnsZone
+	err := d.doRequest(http.MethodGet, "/dns_zones", nil, &zones)
+	return zones, err
+}
+
+// createRecord creates the record in the zone and returns it with its ID.
+func (d *DNSProvider) createRecord(zoneID string, record dnsRecord) (*dnsRecord, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var created dnsRecord
+	if err = d.doRequest(http.MethodPost, "/dns_zones/"+url.PathEscape(zoneID)+"/dns_records", bytes.NewReader(body), &created); err != nil {
+		return nil, err
+	}
+	if created.ID == "" {
+		return nil, fmt.Errorf("no record ID in the response")
+	}
+
+	return &created, nil
+}
+
+// deleteRecord deletes the record of the zone with the given ID.
+func (d *DNSProvider) deleteRecord(zoneID, recordID string) error {
+	return d.doRequest(http.MethodDelete, "/dns_zones/"+url.PathEscape(zoneID)+"/dns_records/"+url.PathEscape(recordID), nil, nil)
+}
+
+// doRequest sends the request authenticated with the token and decodes the JSON response into result if not nil.
+func (d *DNSProvider) doRequest(method, uri string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+d.config.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := APIError{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(raw, &apiErr); err != nil || apiErr.Message == "" {
+			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	return nil
+}
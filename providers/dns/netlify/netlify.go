@@ -0,0 +1,181 @@
+// Package netlify implements a DNS provider for solving the DNS-01 challenge using Netlify DNS.
+// See https://open-api.netlify.com/#tag/dnsZone
+package netlify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.netlify.com/api/v1"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Token              string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL: defaultBaseURL,
+		// The records of Netlify DNS may take several minutes to be served by all its nameservers.
+		PropagationTimeout: env.GetOrDefaultSecond("NETLIFY_PROPAGATION_TIMEOUT", 15*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("NETLIFY_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("NETLIFY_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("NETLIFY_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// createdRecord is a record created by Present, with its zone.
+type createdRecord struct {
+	zoneID   string
+	recordID string
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Netlify API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+	// records are the created records, by fqdn and value.
+	records   map[string]createdRecord
+	recordsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Netlify.
+// The personal access token must be passed in the environment variable NETLIFY_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("NETLIFY_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("netlify: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Token = values["NETLIFY_TOKEN"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied token to return a
+// DNSProvider instance configured for Netlify.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.Token = token
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Netlify.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("netlify: the configuration of the DNS provider is nil")
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("netlify: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config, records: make(map[string]createdRecord)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, err := d.findZone(fqdn)
+	if err != nil {
+		return fmt.Errorf("netlify: %v", err)
+	}
+
+	record := dnsRecord{
+		Type:     "TXT",
+		Hostname: acme.UnFqdn(fqdn),
+		Value:    value,
+		TTL:      d.config.TTL,
+	}
+
+	created, err := d.createRecord(zone.ID, record)
+	if err != nil {
+		return fmt.Errorf("netlify: failed to create TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = createdRecord{zoneID: zone.ID, recordID: created.ID}
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present, by its ID.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.recordsMu.Lock()
+	record, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("netlify: unknown record ID for %q", fqdn)
+	}
+
+	if err := d.deleteRecord(record.zoneID, record.recordID); err != nil {
+		return fmt.Errorf("netlify: failed to delete TXT record: %v", err)
+	}
+
+	d.recordsMu.Lock()
+	delete(d.records, fqdn+" "+value)
+	d.recordsMu.Unlock()
+
+	return nil
+}
+
+// findZone returns the longest DNS zone of the account which is a parent of the fqdn.
+func (d *DNSProvider) findZone(fqdn string) (dnsZone, error) {
+	zones, err := d.listZones()
+	if err != nil {
+		return dnsZone{}, fmt.Errorf("could not list the DNS zones: %v", err)
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+
+	var found dnsZone
+	for _, zone := range zones {
+		zoneName := strings.ToLower(acme.UnFqdn(zone.Name))
+		if strings.HasSuffix(name, "."+zoneName) && len(zoneName) > len(found.Name) {
+			found = zone
+			found.Name = zoneName
+		}
+	}
+
+	if found.ID == "" {
+		return dnsZone{}, fmt.Errorf("could not find the DNS zone of %q in the account", fqdn)
+	}
+	return found, nil
+}
@@ -0,0 +1,300 @@
+package netlify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	liveTest      bool
+	envTestToken  string
+	envTestDomain string
+)
+
+func init() {
+	envTestToken = os.Getenv("NETLIFY_TOKEN")
+	envTestDomain = os.Getenv("NETLIFY_DOMAIN")
+	liveTest = len(envTestToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("NETLIFY_TOKEN", envTestToken)
+}
+
+// mockServer is a Netlify API with the zones example.com, sub.example.com and example.org.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	zones    []dnsZone
+	records  map[string][]dnsRecord
+	requests []string
+	nextID   int
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	return &mockServer{
+		t: t,
+		zones: []dnsZone{
+			{ID: "zone-com", Name: "example.com"},
+			{ID: "zone-sub", Name: "sub.example.com"},
+			{ID: "zone-org", Name: "example.org"},
+		},
+		records: make(map[string][]dnsRecord),
+	}
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(APIError{StatusCode: http.StatusUnauthorized, Message: "Access Denied: Invalid token"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "dns_zones":
+		json.NewEncoder(w).Encode(s.zones)
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "dns_zones" && parts[2] == "dns_records":
+		assert.Equal(s.t, "application/json", r.Header.Get("Content-Type"))
+
+		var record dnsRecord
+		require.NoError(s.t, json.NewDecoder(r.Body).Decode(&record))
+
+		s.nextID++
+		record.ID = fmt.Sprintf("record-%d", s.nextID)
+		record.DNSZone = parts[1]
+		s.records[parts[1]] = append(s.records[parts[1]], record)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(record)
+
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[0] == "dns_zones" && parts[2] == "dns_records":
+		records := s.records[parts[1]]
+		for i, record := range records {
+			if record.ID == parts[3] {
+				s.records[parts[1]] = append(records[:i], records[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIError{StatusCode: http.StatusNotFound, Message: "Not Found"})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("NETLIFY_TOKEN", "secret")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("NETLIFY_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "netlify: some credentials information are missing: NETLIFY_TOKEN")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "netlify: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "netlify: credentials missing")
+}
+
+func TestDNSProvider_findZone(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		fqdn     string
+		expected string
+	}{
+		{fqdn: "_acme-challenge.example.com.", expected: "zone-com"},
+		{fqdn: "_acme-challenge.www.example.com.", expected: "zone-com"},
+		// the longest zone wins.
+		{fqdn: "_acme-challenge.sub.example.com.", expected: "zone-sub"},
+		{fqdn: "_acme-challenge.WWW.Example.ORG.", expected: "zone-org"},
+	}
+
+	for _, test := range testCases {
+		zone, err := provider.findZone(test.fqdn)
+		require.NoError(t, err, test.fqdn)
+		assert.Equal(t, test.expected, zone.ID, test.fqdn)
+	}
+
+	_, err = provider.findZone("_acme-challenge.notexample.com.")
+	assert.EqualError(t, err, `could not find the DNS zone of "_acme-challenge.notexample.com." in the account`)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []dnsRecord{{
+		ID:       "record-1",
+		Type:     "TXT",
+		Hostname: "_acme-challenge.www.sub.example.com",
+		Value:    "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		TTL:      300,
+		DNSZone:  "zone-sub",
+	}}
+	assert.Equal(t, expected, server.records["zone-sub"])
+
+	err = provider.CleanUp("www.sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.records["zone-sub"])
+	assert.Empty(t, provider.records)
+	assert.Equal(t, []string{
+		"GET /dns_zones",
+		"POST /dns_zones/zone-sub/dns_records",
+		"DELETE /dns_zones/zone-sub/dns_records/record-1",
+	}, server.requests)
+}
+
+func TestDNSProvider_PresentTwoValues(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	// e.g. a wildcard and its domain.
+	err = provider.Present("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "otherKeyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records["zone-com"], 2)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// only the record of the value is deleted.
+	require.Len(t, server.records["zone-com"], 1)
+	assert.Equal(t, "record-2", server.records["zone-com"][0].ID)
+}
+
+func TestDNSProvider_PresentUnknownZone(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.net", "", "keyAuth")
+	assert.EqualError(t, err, `netlify: could not find the DNS zone of "_acme-challenge.example.net." in the account`)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `netlify: unknown record ID for "_acme-challenge.example.com."`)
+	assert.Empty(t, server.requests)
+}
+
+func TestDNSProvider_InvalidToken(t *testing.T) {
+	server := newMockServer(t)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "other"
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "netlify: could not list the DNS zones: API error (HTTP 401): Access Denied: Invalid token")
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/xenolf/lego/acme"
 )
 
 const (
@@ -89,7 +91,7 @@ type ChangeInfo struct {
 }
 
 func newClient(httpClient *http.Client, accessKey string, secretKey string, endpoint string) *Client {
-	client := http.DefaultClient
+	client := acme.NewHTTPClient(30 * time.Second)
 	if httpClient != nil {
 		client = httpClient
 	}
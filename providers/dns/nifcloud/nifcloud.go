@@ -30,7 +30,7 @@ func NewDNSProvider() (*DNSProvider, error) {
 		endpoint = defaultEndpoint
 	}
 
-	httpClient := &http.Client{Timeout: 30 * time.Second}
+	httpClient := acme.NewHTTPClient(30 * time.Second)
 
 	return NewDNSProviderCredentials(httpClient, endpoint, values["NIFCLOUD_ACCESS_KEY_ID"], values["NIFCLOUD_SECRET_ACCESS_KEY"])
 }
@@ -0,0 +1,137 @@
+package njalla
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Domain represents a Njalla domain
+type Domain struct {
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+}
+
+// Record represents a Njalla DNS record
+type Record struct {
+	ID      string `json:"id,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+// apiRequest is the JSON-RPC style request of the Njalla API.
+type apiRequest struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+// apiResponse is the JSON-RPC style response of the Njalla API,
+// holding either a result or an error.
+type apiResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *apiError       `json:"error"`
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Code, e.Message)
+}
+
+// listDomains returns the domains of the account.
+func (d *DNSProvider) listDomains() ([]Domain, error) {
+	var result struct {
+		Domains []Domain `json:"domains"`
+	}
+
+	err := d.call("list-domains", map[string]string{}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Domains, nil
+}
+
+// listRecords returns the records of the domain.
+func (d *DNSProvider) listRecords(domainName string) ([]Record, error) {
+	var result struct {
+		Records []Record `json:"records"`
+	}
+
+	err := d.call("list-records", map[string]string{"domain": domainName}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+// addRecord creates the record in its domain.
+func (d *DNSProvider) addRecord(record Record) error {
+	return d.call("add-record", record, nil)
+}
+
+// removeRecord removes the record with the given ID from the domain.
+func (d *DNSProvider) removeRecord(domainName, id string) error {
+	return d.call("remove-record", map[string]string{"domain": domainName, "id": id}, nil)
+}
+
+// call sends the method and its parameters to the Njalla API and decodes the result.
+func (d *DNSProvider) call(method string, params, result interface{}) error {
+	content, err := json.Marshal(apiRequest{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.config.BaseURL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Njalla "+d.config.Token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	var apiResp apiResponse
+	if err = json.Unmarshal(raw, &apiResp); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+
+	if apiResp.Error != nil {
+		return apiResp.Error
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+	}
+
+	if result == nil || len(apiResp.Result) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(apiResp.Result, result)
+	if err != nil {
+		return fmt.Errorf("could not decode the result of %s: %v", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package njalla implements a DNS provider for solving the DNS-01 challenge using Njalla DNS.
+// See https://njal.la/api/
+package njalla
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://njal.la/api/1/"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	Token              string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("NJALLA_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("NJALLA_POLLING_INTERVAL", 5*time.Second),
+		TTL:                env.GetOrDefaultInt("NJALLA_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("NJALLA_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Njalla's API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Njalla.
+// Credentials must be passed in the environment variable NJALLA_TOKEN.
+// The propagation timeout, polling interval and record TTL (in seconds) can be set
+// with NJALLA_PROPAGATION_TIMEOUT, NJALLA_POLLING_INTERVAL and NJALLA_TTL.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("NJALLA_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("njalla: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.Token = values["NJALLA_TOKEN"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Njalla.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.Token = token
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Njalla.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("njalla: the configuration of the DNS provider is nil")
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("njalla: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, err := d.findDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("njalla: %v", err)
+	}
+
+	record := Record{
+		Domain:  domainName,
+		Name:    extractRecordName(fqdn, domainName),
+		Type:    "TXT",
+		Content: value,
+		TTL:     d.config.TTL,
+	}
+
+	err = d.addRecord(record)
+	if err != nil {
+		return fmt.Errorf("njalla: failed to add the TXT record: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+// The ID of the record is looked up since it is required to remove it.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	domainName, err := d.findDomain(fqdn)
+	if err != nil {
+		return fmt.Errorf("njalla: %v", err)
+	}
+
+	records, err := d.listRecords(domainName)
+	if err != nil {
+		return fmt.Errorf("njalla: failed to list the records: %v", err)
+	}
+
+	name := extractRecordName(fqdn, domainName)
+	for _, record := range records {
+		if record.Type == "TXT" && record.Name == name && record.Content == value {
+			err = d.removeRecord(domainName, record.ID)
+			if err != nil {
+				return fmt.Errorf("njalla: failed to remove the TXT record: %v", err)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("njalla: no TXT record %s found in the domain %s", name, domainName)
+}
+
+// findDomain returns the longest domain of the account matching the fqdn.
+func (d *DNSProvider) findDomain(fqdn string) (string, error) {
+	domains, err := d.listDomains()
+	if err != nil {
+		return "", fmt.Errorf("failed to list the domains: %v", err)
+	}
+
+	name := acme.UnFqdn(fqdn)
+
+	var domainName string
+	for _, domain := range domains {
+		if (name == domain.Name || strings.HasSuffix(name, "."+domain.Name)) && len(domain.Name) > len(domainName) {
+			domainName = domain.Name
+		}
+	}
+
+	if domainName == "" {
+		return "", fmt.Errorf("no domain of the account matches %s", fqdn)
+	}
+	return domainName, nil
+}
+
+// extractRecordName returns the name of the record relative to the domain, "@" for the domain itself.
+func extractRecordName(fqdn, domainName string) string {
+	name := acme.UnFqdn(fqdn)
+	if name == domainName {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+domainName)
+}
@@ -0,0 +1,216 @@
+package njalla
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+)
+
+var (
+	liveTest      bool
+	envTestToken  string
+	envTestDomain string
+)
+
+func init() {
+	envTestToken = os.Getenv("NJALLA_TOKEN")
+	envTestDomain = os.Getenv("NJALLA_DOMAIN")
+	liveTest = len(envTestToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("NJALLA_TOKEN", envTestToken)
+	os.Unsetenv("NJALLA_TTL")
+}
+
+// mockServer is a minimal in-memory implementation of the Njalla API.
+type mockServer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	domains []string
+	records map[string][]Record
+	nextID  int
+	// methods are the methods of the received requests.
+	methods []string
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Equal(m.t, http.MethodPost, r.Method)
+	assert.Equal(m.t, "Njalla secret", r.Header.Get("Authorization"))
+
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&req))
+	m.methods = append(m.methods, req.Method)
+
+	var params Record
+	require.NoError(m.t, json.Unmarshal(req.Params, &params))
+
+	switch req.Method {
+	case "list-domains":
+		var domains []Domain
+		for _, name := range m.domains {
+			domains = append(domains, Domain{Name: name, Status: "active"})
+		}
+		writeResult(w, map[string]interface{}{"domains": domains})
+
+	case "list-records":
+		writeResult(w, map[string]interface{}{"records": m.records[params.Domain]})
+
+	case "add-record":
+		m.nextID++
+		params.ID = strconv.Itoa(m.nextID)
+		domain := params.Domain
+		params.Domain = ""
+		m.records[domain] = append(m.records[domain], params)
+		writeResult(w, params)
+
+	case "remove-record":
+		for i, record := range m.records[params.Domain] {
+			if record.ID == params.ID {
+				m.records[params.Domain] = append(m.records[params.Domain][:i], m.records[params.Domain][i+1:]...)
+				writeResult(w, map[string]interface{}{})
+				return
+			}
+		}
+		fmt.Fprint(w, `{"error": {"code": 404, "message": "Record not found"}}`)
+
+	default:
+		fmt.Fprintf(w, `{"error": {"code": 400, "message": "unknown method %s"}}`, req.Method)
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("NJALLA_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "njalla: some credentials information are missing: NJALLA_TOKEN")
+}
+
+func TestNewDNSProviderConfigTTL(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("NJALLA_TOKEN", "secret")
+	os.Setenv("NJALLA_TTL", "60")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, 60, provider.config.TTL)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		domains: []string{"example.org", "example.com", "sub.example.com"},
+		records: map[string][]Record{
+			"example.com": {{ID: "100", Name: "_acme-challenge.www", Type: "TXT", Content: "other"}},
+		},
+		nextID: 100,
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/api/1/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("www.example.com", "keyAuth")
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []Record{
+		{ID: "100", Name: "_acme-challenge.www", Type: "TXT", Content: "other"},
+		{ID: "101", Name: "_acme-challenge.www", Type: "TXT", Content: value, TTL: 300},
+	}
+	assert.Equal(t, expected, server.records["example.com"])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Equal(t, expected[:1], server.records["example.com"])
+	assert.Equal(t, []string{"list-domains", "add-record", "list-domains", "list-records", "remove-record"}, server.methods)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, "njalla: no TXT record _acme-challenge.www found in the domain example.com")
+}
+
+func TestDNSProvider_PresentLongestDomain(t *testing.T) {
+	server := &mockServer{t: t, domains: []string{"example.com", "sub.example.com"}, records: map[string][]Record{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "secret"
+	config.BaseURL = ts.URL + "/api/1/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records["sub.example.com"], 1)
+	assert.Equal(t, "_acme-challenge", server.records["sub.example.com"][0].Name)
+
+	err = provider.Present("example.net", "", "keyAuth")
+	assert.EqualError(t, err, "njalla: no domain of the account matches _acme-challenge.example.net.")
+}
+
+func TestDNSProvider_APIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error": {"code": 403, "message": "Invalid token."}}`)
+	}))
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.Token = "invalid"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "njalla: failed to list the domains: API error 403: Invalid token.")
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
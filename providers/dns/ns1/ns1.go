@@ -4,7 +4,6 @@ package ns1
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
 	"time"
 
@@ -37,7 +36,7 @@ func NewDNSProviderCredentials(key string) (*DNSProvider, error) {
 		return nil, fmt.Errorf("NS1 credentials missing")
 	}
 
-	httpClient := &http.Client{Timeout: time.Second * 10}
+	httpClient := acme.NewHTTPClient(time.Second * 10)
 	client := rest.NewClient(httpClient, rest.SetAPIKey(key))
 
 	return &DNSProvider{client}, nil
@@ -85,12 +85,14 @@ func (d *DNSProvider) SendRequest(method, resource string, payload interface{})
 	}
 
 	// Workaround for keep alive bug in otc api
-	tr := http.DefaultTransport.(*http.Transport)
-	tr.DisableKeepAlives = true
+	tr := &http.Transport{
+		Proxy:             acme.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+	}
 
 	client := &http.Client{
 		Timeout:   10 * time.Second,
-		Transport: tr,
+		Transport: acme.NewUserAgentTransport(tr),
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -176,7 +178,7 @@ func (d *DNSProvider) loginRequest() error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := acme.NewHTTPClient(10 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
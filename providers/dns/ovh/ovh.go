@@ -3,9 +3,12 @@
 package ovh
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ovh/go-ovh/ovh"
 	"github.com/xenolf/lego/acme"
@@ -15,10 +18,35 @@ import (
 // OVH API reference:       https://eu.api.ovh.com/
 // Create a Token:					https://eu.api.ovh.com/createToken/
 
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	// APIEndpoint is the name of an OVH endpoint (e.g. ovh-eu or ovh-ca) or the URL of the API.
+	APIEndpoint        string
+	ApplicationKey     string
+	ApplicationSecret  string
+	ConsumerKey        string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		PropagationTimeout: env.GetOrDefaultSecond("OVH_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("OVH_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("OVH_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("OVH_HTTP_TIMEOUT", ovh.DefaultTimeout)),
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 // that uses OVH's REST API to manage TXT records for a domain.
 type DNSProvider struct {
-	client      *ovh.Client
+	config *Config
+	client *ovh.Client
+	// recordIDs are the IDs of the created records by value.
 	recordIDs   map[string]int
 	recordIDsMu sync.Mutex
 }
@@ -35,128 +63,179 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("OVH: %v", err)
 	}
 
-	return NewDNSProviderCredentials(
-		values["OVH_ENDPOINT"],
-		values["OVH_APPLICATION_KEY"],
-		values["OVH_APPLICATION_SECRET"],
-		values["OVH_CONSUMER_KEY"],
-	)
+	config := NewDefaultConfig()
+	config.APIEndpoint = values["OVH_ENDPOINT"]
+	config.ApplicationKey = values["OVH_APPLICATION_KEY"]
+	config.ApplicationSecret = values["OVH_APPLICATION_SECRET"]
+	config.ConsumerKey = values["OVH_CONSUMER_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for OVH.
 func NewDNSProviderCredentials(apiEndpoint, applicationKey, applicationSecret, consumerKey string) (*DNSProvider, error) {
-	if apiEndpoint == "" || applicationKey == "" || applicationSecret == "" || consumerKey == "" {
-		return nil, fmt.Errorf("OVH credentials missing")
+	config := NewDefaultConfig()
+	config.APIEndpoint = apiEndpoint
+	config.ApplicationKey = applicationKey
+	config.ApplicationSecret = applicationSecret
+	config.ConsumerKey = consumerKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for OVH.
+// The requests are signed with the application secret and consumer key,
+// using the time of the API to cope with a local clock drift.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("OVH: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIEndpoint == "" || config.ApplicationKey == "" || config.ApplicationSecret == "" || config.ConsumerKey == "" {
+		return nil, errors.New("OVH: credentials missing")
 	}
 
-	ovhClient, _ := ovh.NewClient(
-		apiEndpoint,
-		applicationKey,
-		applicationSecret,
-		consumerKey,
+	client, err := ovh.NewClient(
+		config.APIEndpoint,
+		config.ApplicationKey,
+		config.ApplicationSecret,
+		config.ConsumerKey,
 	)
+	if err != nil {
+		return nil, fmt.Errorf("OVH: %v", err)
+	}
+
+	if config.HTTPClient != nil {
+		client.Client = config.HTTPClient
+		client.Timeout = config.HTTPClient.Timeout
+	}
 
 	return &DNSProvider{
-		client:    ovhClient,
+		config:    config,
+		client:    client,
 		recordIDs: make(map[string]int),
 	}, nil
 }
 
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	// txtRecordRequest represents the request body to DO's API to make a TXT record
-	type txtRecordRequest struct {
-		FieldType string `json:"fieldType"`
-		SubDomain string `json:"subDomain"`
-		Target    string `json:"target"`
-		TTL       int    `json:"ttl"`
-	}
-
-	// txtRecordResponse represents a response from DO's API after making a TXT record
-	type txtRecordResponse struct {
-		ID        int    `json:"id"`
-		FieldType string `json:"fieldType"`
-		SubDomain string `json:"subDomain"`
-		Target    string `json:"target"`
-		TTL       int    `json:"ttl"`
-		Zone      string `json:"zone"`
-	}
-
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-
-	// Parse domain name
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+	authZone, err := d.findZone(fqdn)
 	if err != nil {
-		return fmt.Errorf("Could not determine zone for domain: '%s'. %s", domain, err)
+		return err
 	}
 
-	authZone = acme.UnFqdn(authZone)
-	subDomain := d.extractRecordName(fqdn, authZone)
-
 	reqURL := fmt.Sprintf("/domain/zone/%s/record", authZone)
-	reqData := txtRecordRequest{FieldType: "TXT", SubDomain: subDomain, Target: value, TTL: ttl}
+	reqData := txtRecordRequest{FieldType: "TXT", SubDomain: extractRecordName(fqdn, authZone), Target: value, TTL: d.config.TTL}
 	var respData txtRecordResponse
 
 	// Create TXT record
 	err = d.client.Post(reqURL, reqData, &respData)
 	if err != nil {
-		return fmt.Errorf("error when call OVH api to add record: %v", err)
-	}
-
-	// Apply the change
-	reqURL = fmt.Sprintf("/domain/zone/%s/refresh", authZone)
-	err = d.client.Post(reqURL, nil, nil)
-	if err != nil {
-		return fmt.Errorf("error when call OVH api to refresh zone: %v", err)
+		return fmt.Errorf("OVH: error when call api to add record: %v", err)
 	}
 
+	// the record exists from now on, even if the zone is not refreshed.
 	d.recordIDsMu.Lock()
-	d.recordIDs[fqdn] = respData.ID
+	d.recordIDs[value] = respData.ID
 	d.recordIDsMu.Unlock()
 
-	return nil
+	return d.refreshZone(authZone)
 }
 
 // CleanUp removes the TXT record matching the specified parameters
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
 	// get the record's unique ID from when we created it
 	d.recordIDsMu.Lock()
-	recordID, ok := d.recordIDs[fqdn]
+	recordID, ok := d.recordIDs[value]
 	d.recordIDsMu.Unlock()
 	if !ok {
-		return fmt.Errorf("unknown record ID for '%s'", fqdn)
+		return fmt.Errorf("OVH: unknown record ID for '%s'", fqdn)
 	}
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+	authZone, err := d.findZone(fqdn)
 	if err != nil {
-		return fmt.Errorf("Could not determine zone for domain: '%s'. %s", domain, err)
+		return err
 	}
 
-	authZone = acme.UnFqdn(authZone)
-
 	reqURL := fmt.Sprintf("/domain/zone/%s/record/%d", authZone, recordID)
 
 	err = d.client.Delete(reqURL, nil)
 	if err != nil {
-		return fmt.Errorf("error when call OVH api to delete challenge record: %v", err)
+		return fmt.Errorf("OVH: error when call api to delete challenge record: %v", err)
 	}
 
 	// Delete record ID from map
 	d.recordIDsMu.Lock()
-	delete(d.recordIDs, fqdn)
+	delete(d.recordIDs, value)
 	d.recordIDsMu.Unlock()
 
+	return d.refreshZone(authZone)
+}
+
+// findZone returns the longest zone of the account matching the fqdn.
+func (d *DNSProvider) findZone(fqdn string) (string, error) {
+	var zones []string
+	err := d.client.Get("/domain/zone", &zones)
+	if err != nil {
+		return "", fmt.Errorf("OVH: error when call api to list the zones: %v", err)
+	}
+
+	name := acme.UnFqdn(fqdn)
+
+	var authZone string
+	for _, zone := range zones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(authZone) {
+			authZone = zone
+		}
+	}
+
+	if authZone == "" {
+		return "", fmt.Errorf("OVH: no zone of the account matches %s", fqdn)
+	}
+	return authZone, nil
+}
+
+// refreshZone applies the changes of the records of the zone.
+func (d *DNSProvider) refreshZone(authZone string) error {
+	err := d.client.Post(fmt.Sprintf("/domain/zone/%s/refresh", authZone), nil, nil)
+	if err != nil {
+		return fmt.Errorf("OVH: error when call api to refresh zone: %v", err)
+	}
 	return nil
 }
 
-func (d *DNSProvider) extractRecordName(fqdn, domain string) string {
+func extractRecordName(fqdn, domain string) string {
 	name := acme.UnFqdn(fqdn)
 	if idx := strings.Index(name, "."+domain); idx != -1 {
 		return name[:idx]
 	}
 	return name
 }
+
+// txtRecordRequest represents the request body to OVH's API to make a TXT record
+type txtRecordRequest struct {
+	FieldType string `json:"fieldType"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl"`
+}
+
+// txtRecordResponse represents a response from OVH's API after making a TXT record
+type txtRecordResponse struct {
+	ID        int    `json:"id"`
+	FieldType string `json:"fieldType"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl"`
+	Zone      string `json:"zone"`
+}
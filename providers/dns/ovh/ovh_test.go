@@ -1,11 +1,20 @@
 package ovh
 
 import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -117,6 +126,141 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	}
 }
 
+// signature returns the OVH "$1$" signature of a request.
+func signature(secret, consumerKey, method, url, body string, timestamp int64) string {
+	return fmt.Sprintf("$1$%x", sha1.Sum([]byte(fmt.Sprintf("%s+%s+%s+%s+%s+%d", secret, consumerKey, method, url, body, timestamp))))
+}
+
+// fakeOVH is a fake OVH API checking the signature of the requests.
+// Its clock is ahead of the local one by delta.
+type fakeOVH struct {
+	t        *testing.T
+	server   *httptest.Server
+	delta    time.Duration
+	mu       sync.Mutex
+	timeReqs int
+	requests []string
+}
+
+func (f *fakeOVH) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assert.Equal(f.t, "1234", r.Header.Get("X-Ovh-Application"))
+
+	if r.URL.Path == "/auth/time" {
+		f.timeReqs++
+		fmt.Fprint(w, time.Now().Add(f.delta).Unix())
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(f.t, err)
+
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Ovh-Timestamp"), 10, 64)
+	require.NoError(f.t, err)
+	assert.InDelta(f.t, time.Now().Add(f.delta).Unix(), timestamp, 2, "the timestamp must be the time of the API")
+	assert.Equal(f.t, "abcde", r.Header.Get("X-Ovh-Consumer"))
+	assert.Equal(f.t, signature("5678", "abcde", r.Method, f.server.URL+r.URL.Path, string(body), timestamp), r.Header.Get("X-Ovh-Signature"))
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	switch {
+	case r.URL.Path == "/domain/zone":
+		fmt.Fprint(w, `["example.org", "example.com", "sub.example.com"]`)
+	case r.URL.Path == "/domain/zone/example.com/record" && r.Method == http.MethodPost:
+		var record txtRecordRequest
+		require.NoError(f.t, json.Unmarshal(body, &record))
+		assert.Equal(f.t, txtRecordRequest{FieldType: "TXT", SubDomain: "_acme-challenge.www", Target: "w6uP8Tcg6K2QR905Rms8iXTlksL6OD1KOWBxTK7wxPI", TTL: 120}, record)
+		fmt.Fprint(w, `{"id": 42, "fieldType": "TXT", "subDomain": "_acme-challenge.www", "zone": "example.com"}`)
+	case r.URL.Path == "/domain/zone/example.com/refresh", r.URL.Path == "/domain/zone/example.com/record/42":
+		fmt.Fprint(w, `null`)
+	default:
+		http.Error(w, `{"message": "unexpected request"}`, http.StatusBadRequest)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	sig := signature("EgWIz07P0HYwtQDs", "MtSwSrPpNjqfVSmJhLbPyr2i45lSwPU1", http.MethodGet, "https://eu.api.ovh.com/1.0/domain/zone", "", 1366560945)
+	assert.Equal(t, "$1$0e1b395e7db5b9580ec29934c8730154c1d9079f", sig)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	fake := &fakeOVH{t: t}
+	fake.server = httptest.NewServer(fake)
+	defer fake.server.Close()
+
+	config := NewDefaultConfig()
+	config.APIEndpoint = fake.server.URL
+	config.ApplicationKey = "1234"
+	config.ApplicationSecret = "5678"
+	config.ConsumerKey = "abcde"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	defer fake.server.Close()
+
+	err = provider.Present("www.example.com", "", "foobar")
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	require.NoError(t, err)
+
+	expected := []string{
+		"GET /domain/zone",
+		"POST /domain/zone/example.com/record",
+		"POST /domain/zone/example.com/refresh",
+		"GET /domain/zone",
+		"DELETE /domain/zone/example.com/record/42",
+		"POST /domain/zone/example.com/refresh",
+	}
+	assert.Equal(t, expected, fake.requests)
+	assert.Empty(t, provider.recordIDs)
+}
+
+func TestDNSProvider_SignsWithTheTimeOfTheAPI(t *testing.T) {
+	// the local clock is one hour late.
+	fake := &fakeOVH{t: t, delta: time.Hour}
+	fake.server = httptest.NewServer(fake)
+	defer fake.server.Close()
+
+	config := NewDefaultConfig()
+	config.APIEndpoint = fake.server.URL
+	config.ApplicationKey = "1234"
+	config.ApplicationSecret = "5678"
+	config.ConsumerKey = "abcde"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	defer fake.server.Close()
+
+	err = provider.Present("www.example.com", "", "foobar")
+	require.NoError(t, err)
+
+	// the time delta is fetched once.
+	assert.Equal(t, 1, fake.timeReqs)
+	assert.Len(t, fake.requests, 3)
+}
+
+func TestDNSProvider_NoMatchingZone(t *testing.T) {
+	fake := &fakeOVH{t: t}
+	fake.server = httptest.NewServer(fake)
+	defer fake.server.Close()
+
+	config := NewDefaultConfig()
+	config.APIEndpoint = fake.server.URL
+	config.ApplicationKey = "1234"
+	config.ApplicationSecret = "5678"
+	config.ConsumerKey = "abcde"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	defer fake.server.Close()
+
+	err = provider.Present("example.net", "", "foobar")
+	assert.EqualError(t, err, "OVH: no zone of the account matches _acme-challenge.example.net.")
+}
+
 func TestLivePresent(t *testing.T) {
 	if !liveTest {
 		t.Skip("skipping live test")
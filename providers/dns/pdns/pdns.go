@@ -5,10 +5,12 @@ package pdns
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -18,17 +20,48 @@ import (
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// PowerDNS API reference: https://doc.powerdns.com/authoritative/http-api/
+
+// defaultServerName is the ID of the server in the API of a PowerDNS Authoritative Server.
+const defaultServerName = "localhost"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey             string
+	Host               *url.URL
+	ServerName         string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	serverName := os.Getenv("PDNS_SERVER_ID")
+	if serverName == "" {
+		serverName = defaultServerName
+	}
+
+	return &Config{
+		ServerName:         serverName,
+		PropagationTimeout: env.GetOrDefaultSecond("PDNS_PROPAGATION_TIMEOUT", 120*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("PDNS_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("PDNS_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("PDNS_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 type DNSProvider struct {
-	apiKey     string
-	host       *url.URL
 	apiVersion int
-	client     *http.Client
+	config     *Config
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for pdns.
 // Credentials must be passed in the environment variable:
 // PDNS_API_URL and PDNS_API_KEY.
+// The ID of the server can be set with PDNS_SERVER_ID, it defaults to localhost.
 func NewDNSProvider() (*DNSProvider, error) {
 	values, err := env.Get("PDNS_API_KEY", "PDNS_API_URL")
 	if err != nil {
@@ -40,26 +73,49 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("PDNS: %v", err)
 	}
 
-	return NewDNSProviderCredentials(hostURL, values["PDNS_API_KEY"])
+	config := NewDefaultConfig()
+	config.Host = hostURL
+	config.APIKey = values["PDNS_API_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for pdns.
 func NewDNSProviderCredentials(host *url.URL, key string) (*DNSProvider, error) {
-	if key == "" {
+	config := NewDefaultConfig()
+	config.Host = host
+	config.APIKey = key
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for pdns.
+// The version of the API is detected to support both the rrsets of the API v1
+// (PowerDNS 4.x) and the records of the pre-v1 API (PowerDNS 3.x).
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("PDNS: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" {
 		return nil, fmt.Errorf("PDNS API key missing")
 	}
 
-	if host == nil || host.Host == "" {
+	if config.Host == nil || config.Host.Host == "" {
 		return nil, fmt.Errorf("PDNS API URL missing")
 	}
 
-	d := &DNSProvider{
-		host:   host,
-		apiKey: key,
-		client: &http.Client{Timeout: 30 * time.Second},
+	if config.ServerName == "" {
+		config.ServerName = defaultServerName
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
 	}
 
+	d := &DNSProvider{config: config}
+
 	apiVersion, err := d.getAPIVersion()
 	if err != nil {
 		log.Warnf("PDNS: failed to get API version %v", err)
@@ -72,97 +128,122 @@ func NewDNSProviderCredentials(host *url.URL, key string) (*DNSProvider, error)
 // Timeout returns the timeout and interval to use when checking for DNS
 // propagation. Adjusting here to cope with spikes in propagation times.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return 120 * time.Second, 2 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
-// Present creates a TXT record to fulfil the dns-01 challenge
+// Present creates a TXT record to fulfil the dns-01 challenge.
+// The value is added to the existing values of the RRset,
+// e.g. the value of the challenge of a wildcard domain.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
 	zone, err := d.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
 
-	name := fqdn
+	content := strconv.Quote(value)
 
-	// pre-v1 API wants non-fqdn
-	if d.apiVersion == 0 {
-		name = acme.UnFqdn(fqdn)
+	var contents []string
+	if set := findTxtRecord(zone, fqdn); set != nil {
+		for _, record := range set.Records {
+			if record.Content == content {
+				return nil
+			}
+			contents = append(contents, record.Content)
+		}
 	}
 
-	rec := pdnsRecord{
-		Content:  "\"" + value + "\"",
-		Disabled: false,
+	return d.updateTxtRecord(zone, fqdn, append(contents, content))
+}
 
-		// pre-v1 API
-		Type: "TXT",
-		Name: name,
-		TTL:  120,
+// CleanUp removes the TXT record matching the specified parameters.
+// The RRset is deleted when no other value remains.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, err := d.getHostedZone(fqdn)
+	if err != nil {
+		return err
 	}
 
-	rrsets := rrSets{
-		RRSets: []rrSet{
-			{
-				Name:       name,
-				ChangeType: "REPLACE",
-				Type:       "TXT",
-				Kind:       "Master",
-				TTL:        120,
-				Records:    []pdnsRecord{rec},
-			},
-		},
+	set := findTxtRecord(zone, fqdn)
+	if set == nil {
+		return fmt.Errorf("no existing record found for %s", fqdn)
 	}
 
-	body, err := json.Marshal(rrsets)
-	if err != nil {
-		return err
+	content := strconv.Quote(value)
+
+	var contents []string
+	for _, record := range set.Records {
+		if record.Content != content {
+			contents = append(contents, record.Content)
+		}
 	}
 
-	_, err = d.makeRequest(http.MethodPatch, zone.URL, bytes.NewReader(body))
-	return err
+	return d.updateTxtRecord(zone, fqdn, contents)
 }
 
-// CleanUp removes the TXT record matching the specified parameters
-func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+// updateTxtRecord replaces the TXT RRset of the fqdn with the given contents,
+// or deletes it if there is none, then notifies the slaves of the zone.
+func (d *DNSProvider) updateTxtRecord(zone *hostedZone, fqdn string, contents []string) error {
+	name := fqdn
 
-	zone, err := d.getHostedZone(fqdn)
-	if err != nil {
-		return err
+	// pre-v1 API wants non-fqdn
+	if d.apiVersion == 0 {
+		name = acme.UnFqdn(fqdn)
 	}
 
-	set, err := d.findTxtRecord(fqdn)
+	set := rrSet{Name: name, Type: "TXT", ChangeType: "DELETE"}
+	if len(contents) > 0 {
+		set.ChangeType = "REPLACE"
+		set.TTL = d.config.TTL
+
+		for _, content := range contents {
+			record := pdnsRecord{Content: content}
+
+			// pre-v1 API
+			if d.apiVersion == 0 {
+				record.Name = name
+				record.Type = "TXT"
+				record.TTL = d.config.TTL
+			}
+
+			set.Records = append(set.Records, record)
+		}
+	}
+
+	body, err := json.Marshal(rrSets{RRSets: []rrSet{set}})
 	if err != nil {
 		return err
 	}
 
-	rrsets := rrSets{
-		RRSets: []rrSet{
-			{
-				Name:       set.Name,
-				Type:       set.Type,
-				ChangeType: "DELETE",
-			},
-		},
-	}
-	body, err := json.Marshal(rrsets)
+	_, err = d.makeRequest(http.MethodPatch, zone.URL, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
-	_, err = d.makeRequest(http.MethodPatch, zone.URL, bytes.NewReader(body))
-	return err
+	d.notify(zone)
+	return nil
 }
 
-func (d *DNSProvider) getHostedZone(fqdn string) (*hostedZone, error) {
-	var zone hostedZone
-	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+// notify asks a master zone to send a DNS NOTIFY to its slaves,
+// a failure is not fatal since the slaves refresh the zone anyway.
+func (d *DNSProvider) notify(zone *hostedZone) {
+	if !strings.EqualFold(zone.Kind, "Master") {
+		return
+	}
+
+	_, err := d.makeRequest(http.MethodPut, zone.URL+"/notify", nil)
 	if err != nil {
-		return nil, err
+		log.Warnf("PDNS: failed to notify the slaves of the zone %s: %v", zone.Name, err)
 	}
+}
 
-	url := "/servers/localhost/zones"
-	result, err := d.makeRequest(http.MethodGet, url, nil)
+// getHostedZone returns the longest zone of the server matching the fqdn, with its RRsets.
+// The names of the zones are compared in their canonical form, with a trailing dot.
+func (d *DNSProvider) getHostedZone(fqdn string) (*hostedZone, error) {
+	result, err := d.makeRequest(http.MethodGet, d.zonesPath(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -173,57 +254,78 @@ func (d *DNSProvider) getHostedZone(fqdn string) (*hostedZone, error) {
 		return nil, err
 	}
 
-	url = ""
-	for _, zone := range zones {
-		if acme.UnFqdn(zone.Name) == acme.UnFqdn(authZone) {
-			url = zone.URL
+	name := strings.ToLower(fqdn)
+
+	var match *hostedZone
+	for i, zone := range zones {
+		zoneName := strings.ToLower(acme.ToFqdn(zone.Name))
+		if (name == zoneName || strings.HasSuffix(name, "."+zoneName)) && (match == nil || len(zoneName) > len(acme.ToFqdn(match.Name))) {
+			match = &zones[i]
 		}
 	}
 
-	result, err = d.makeRequest(http.MethodGet, url, nil)
+	if match == nil {
+		return nil, fmt.Errorf("PDNS: no zone of the server matches %s", fqdn)
+	}
+
+	zoneURL := match.URL
+	if zoneURL == "" {
+		zoneURL = d.zonesPath() + "/" + url.PathEscape(match.ID)
+	}
+
+	result, err = d.makeRequest(http.MethodGet, zoneURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	var zone hostedZone
 	err = json.Unmarshal(result, &zone)
 	if err != nil {
 		return nil, err
 	}
 
+	if zone.URL == "" {
+		zone.URL = zoneURL
+	}
+
 	// convert pre-v1 API result
 	if len(zone.Records) > 0 {
-		zone.RRSets = []rrSet{}
+		zone.RRSets = nil
 		for _, record := range zone.Records {
-			set := rrSet{
+			if set := findRRSet(zone.RRSets, record.Name, record.Type); set != nil {
+				set.Records = append(set.Records, record)
+				continue
+			}
+
+			zone.RRSets = append(zone.RRSets, rrSet{
 				Name:    record.Name,
 				Type:    record.Type,
 				Records: []pdnsRecord{record},
-			}
-			zone.RRSets = append(zone.RRSets, set)
+			})
 		}
 	}
 
 	return &zone, nil
 }
 
-func (d *DNSProvider) findTxtRecord(fqdn string) (*rrSet, error) {
-	zone, err := d.getHostedZone(fqdn)
-	if err != nil {
-		return nil, err
-	}
+func (d *DNSProvider) zonesPath() string {
+	return "/servers/" + url.PathEscape(d.config.ServerName) + "/zones"
+}
 
-	_, err = d.makeRequest(http.MethodGet, zone.URL, nil)
-	if err != nil {
-		return nil, err
-	}
+// findTxtRecord returns the TXT RRset of the zone for the fqdn, or nil if there is none.
+func findTxtRecord(zone *hostedZone, fqdn string) *rrSet {
+	return findRRSet(zone.RRSets, fqdn, "TXT")
+}
 
-	for _, set := range zone.RRSets {
-		if (set.Name == acme.UnFqdn(fqdn) || set.Name == fqdn) && set.Type == "TXT" {
-			return &set, nil
+// findRRSet returns the RRset with the given name and type, the names being
+// compared in their canonical form to support both API versions.
+func findRRSet(sets []rrSet, name, rrType string) *rrSet {
+	for i, set := range sets {
+		if strings.EqualFold(acme.ToFqdn(set.Name), acme.ToFqdn(name)) && set.Type == rrType {
+			return &sets[i]
 		}
 	}
-
-	return nil, fmt.Errorf("no existing record found for %s", fqdn)
+	return nil
 }
 
 func (d *DNSProvider) getAPIVersion() (int, error) {
@@ -259,8 +361,8 @@ func (d *DNSProvider) makeRequest(method, uri string, body io.Reader) (json.RawM
 	}
 
 	var path = ""
-	if d.host.Path != "/" {
-		path = d.host.Path
+	if d.config.Host.Path != "/" {
+		path = strings.TrimSuffix(d.config.Host.Path, "/")
 	}
 
 	if !strings.HasPrefix(uri, "/") {
@@ -271,15 +373,18 @@ func (d *DNSProvider) makeRequest(method, uri string, body io.Reader) (json.RawM
 		uri = "/api/v" + strconv.Itoa(d.apiVersion) + uri
 	}
 
-	url := d.host.Scheme + "://" + d.host.Host + path + uri
+	url := d.config.Host.Scheme + "://" + d.config.Host.Host + path + uri
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("X-API-Key", d.apiKey)
+	req.Header.Set("X-API-Key", d.config.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	resp, err := d.client.Do(req)
+	resp, err := d.config.HTTPClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error talking to PDNS API -> %v", err)
 	}
@@ -320,8 +425,8 @@ type pdnsRecord struct {
 	Disabled bool   `json:"disabled"`
 
 	// pre-v1 API
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
 	TTL  int    `json:"ttl,omitempty"`
 }
 
@@ -329,6 +434,7 @@ type hostedZone struct {
 	ID     string  `json:"id"`
 	Name   string  `json:"name"`
 	URL    string  `json:"url"`
+	Kind   string  `json:"kind"`
 	RRSets []rrSet `json:"rrsets"`
 
 	// pre-v1 API
@@ -338,9 +444,8 @@ type hostedZone struct {
 type rrSet struct {
 	Name       string       `json:"name"`
 	Type       string       `json:"type"`
-	Kind       string       `json:"kind"`
 	ChangeType string       `json:"changetype"`
-	Records    []pdnsRecord `json:"records"`
+	Records    []pdnsRecord `json:"records,omitempty"`
 	TTL        int          `json:"ttl,omitempty"`
 }
 
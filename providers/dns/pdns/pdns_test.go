@@ -1,11 +1,19 @@
 package pdns
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
 )
 
 var (
@@ -31,6 +39,101 @@ func restoreEnv() {
 	os.Setenv("PDNS_API_KEY", pdnsAPIKey)
 }
 
+// fakePDNS is a fake PowerDNS API serving the TXT RRsets of its zones,
+// with the canonical names of the API v1 or the names without a trailing dot of the pre-v1 API.
+type fakePDNS struct {
+	t          *testing.T
+	mu         sync.Mutex
+	apiVersion int
+	zones      []string
+	// records are the contents of the TXT RRsets by canonical name.
+	records map[string][]string
+	// requests are the "METHOD path" of the received requests.
+	requests []string
+}
+
+func (f *fakePDNS) name(name string) string {
+	if f.apiVersion == 0 {
+		return acme.UnFqdn(name)
+	}
+	return acme.ToFqdn(name)
+}
+
+func (f *fakePDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assert.Equal(f.t, "secret", r.Header.Get("X-API-Key"))
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if r.URL.Path == "/api" {
+		if f.apiVersion == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[{"url": "/api/v%d", "version": %d}]`, f.apiVersion, f.apiVersion)
+		return
+	}
+
+	prefix := "/servers/localhost/zones"
+	if f.apiVersion > 0 {
+		prefix = fmt.Sprintf("/api/v%d", f.apiVersion) + prefix
+	}
+
+	if r.URL.Path == prefix {
+		var zones []hostedZone
+		for _, zone := range f.zones {
+			zones = append(zones, hostedZone{ID: f.name(zone), Name: f.name(zone), URL: prefix + "/" + f.name(zone), Kind: "Master"})
+		}
+		json.NewEncoder(w).Encode(zones)
+		return
+	}
+
+	zoneName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix+"/"), "/notify")
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/notify"):
+		fmt.Fprint(w, `{"result": "Notification queued"}`)
+	case r.Method == http.MethodGet:
+		zone := hostedZone{ID: zoneName, Name: zoneName, URL: r.URL.Path, Kind: "Master"}
+		for name, contents := range f.records {
+			var records []pdnsRecord
+			for _, content := range contents {
+				records = append(records, pdnsRecord{Content: content, Name: f.name(name), Type: "TXT", TTL: 120})
+			}
+
+			if f.apiVersion == 0 {
+				zone.Records = append(zone.Records, records...)
+			} else {
+				zone.RRSets = append(zone.RRSets, rrSet{Name: f.name(name), Type: "TXT", TTL: 120, Records: records})
+			}
+		}
+		json.NewEncoder(w).Encode(zone)
+	case r.Method == http.MethodPatch:
+		var sets rrSets
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&sets))
+
+		for _, set := range sets.RRSets {
+			assert.Equal(f.t, f.name(set.Name), set.Name, "name of the RRset")
+
+			switch set.ChangeType {
+			case "REPLACE":
+				var contents []string
+				for _, record := range set.Records {
+					contents = append(contents, record.Content)
+				}
+				f.records[acme.ToFqdn(set.Name)] = contents
+			case "DELETE":
+				assert.Empty(f.t, set.Records)
+				delete(f.records, acme.ToFqdn(set.Name))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
 func TestNewDNSProviderValid(t *testing.T) {
 	defer restoreEnv()
 	os.Setenv("PDNS_API_URL", "")
@@ -68,6 +171,100 @@ func TestNewDNSProviderMissingKeyErr(t *testing.T) {
 	assert.EqualError(t, err, "PDNS: some credentials information are missing: PDNS_API_KEY,PDNS_API_URL")
 }
 
+func TestDNSProvider_GetHostedZone(t *testing.T) {
+	for _, apiVersion := range []int{0, 1} {
+		fake := &fakePDNS{t: t, apiVersion: apiVersion, zones: []string{"example.com", "sub.example.com.", "example.org."}}
+		server := httptest.NewServer(fake)
+
+		config := NewDefaultConfig()
+		config.APIKey = "secret"
+		config.Host, _ = url.Parse(server.URL)
+
+		provider, err := NewDNSProviderConfig(config)
+		require.NoError(t, err)
+
+		zone, err := provider.getHostedZone("_acme-challenge.www.Sub.Example.com.")
+		require.NoError(t, err)
+		assert.Equal(t, fake.name("sub.example.com"), zone.Name, "API v%d", apiVersion)
+
+		zone, err = provider.getHostedZone("_acme-challenge.example.com.")
+		require.NoError(t, err)
+		assert.Equal(t, fake.name("example.com"), zone.Name, "API v%d", apiVersion)
+
+		_, err = provider.getHostedZone("_acme-challenge.example.net.")
+		assert.EqualError(t, err, "PDNS: no zone of the server matches _acme-challenge.example.net.")
+
+		server.Close()
+	}
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	for _, apiVersion := range []int{0, 1} {
+		fake := &fakePDNS{
+			t:          t,
+			apiVersion: apiVersion,
+			zones:      []string{"example.com"},
+			records:    map[string][]string{"_acme-challenge.example.com.": {`"existing"`}},
+		}
+		server := httptest.NewServer(fake)
+
+		config := NewDefaultConfig()
+		config.APIKey = "secret"
+		config.Host, _ = url.Parse(server.URL)
+
+		provider, err := NewDNSProviderConfig(config)
+		require.NoError(t, err)
+
+		_, value, _ := acme.DNS01Record("example.com", "keyAuth")
+		_, wildcardValue, _ := acme.DNS01Record("example.com", "wildcardKeyAuth")
+
+		require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+		require.NoError(t, provider.Present("example.com", "", "wildcardKeyAuth"))
+		assert.Equal(t, []string{`"existing"`, `"` + value + `"`, `"` + wildcardValue + `"`}, fake.records["_acme-challenge.example.com."], "API v%d", apiVersion)
+
+		require.NoError(t, provider.CleanUp("example.com", "", "keyAuth"))
+		assert.Equal(t, []string{`"existing"`, `"` + wildcardValue + `"`}, fake.records["_acme-challenge.example.com."], "API v%d", apiVersion)
+
+		require.NoError(t, provider.CleanUp("example.com", "", "wildcardKeyAuth"))
+		assert.Equal(t, []string{`"existing"`}, fake.records["_acme-challenge.example.com."], "API v%d", apiVersion)
+
+		server.Close()
+	}
+}
+
+func TestDNSProvider_CleanUpDeletesRRSet(t *testing.T) {
+	fake := &fakePDNS{t: t, apiVersion: 1, zones: []string{"example.com."}, records: map[string][]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.Host, _ = url.Parse(server.URL)
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Present("example.com", "", "keyAuth"))
+	require.Len(t, fake.records["_acme-challenge.example.com."], 1)
+
+	require.NoError(t, provider.CleanUp("example.com", "", "keyAuth"))
+	_, ok := fake.records["_acme-challenge.example.com."]
+	assert.False(t, ok, "Expected the RRset to be deleted")
+
+	expected := []string{
+		"GET /api",
+		"GET /api/v1/servers/localhost/zones",
+		"GET /api/v1/servers/localhost/zones/example.com.",
+		"PATCH /api/v1/servers/localhost/zones/example.com.",
+		"PUT /api/v1/servers/localhost/zones/example.com./notify",
+		"GET /api/v1/servers/localhost/zones",
+		"GET /api/v1/servers/localhost/zones/example.com.",
+		"PATCH /api/v1/servers/localhost/zones/example.com.",
+		"PUT /api/v1/servers/localhost/zones/example.com./notify",
+	}
+	assert.Equal(t, expected, fake.requests)
+}
+
 func TestPdnsPresentAndCleanup(t *testing.T) {
 	if !pdnsLiveTest {
 		t.Skip("skipping live test")
@@ -3,6 +3,7 @@
 package rfc2136
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -11,21 +12,69 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
 )
 
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	// Nameserver is the network address of the nameserver, in the form "host" or "host:port".
+	Nameserver string
+	// Net is the transport of the updates: "udp", retrying over TCP when the reply is truncated, or "tcp".
+	Net string
+	// TSIGAlgorithm defaults to hmac-md5.sig-alg.reg.int. (HMAC-MD5).
+	// See https://github.com/miekg/dns/blob/master/tsig.go for supported values.
+	TSIGAlgorithm string
+	// TSIGKey is the name of the secret key as defined in the DNS server configuration,
+	// the updates are not signed if TSIGKey or TSIGSecret is empty.
+	TSIGKey            string
+	TSIGSecret         string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	DNSTimeout         time.Duration
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		Net:                "udp",
+		TSIGAlgorithm:      dns.HmacMD5,
+		PropagationTimeout: env.GetOrDefaultSecond("RFC2136_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("RFC2136_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("RFC2136_TTL", 120),
+		DNSTimeout:         env.GetOrDefaultSecond("RFC2136_DNS_TIMEOUT", 10*time.Second),
+	}
+}
+
+// UpdateError is returned when the nameserver rejects a dynamic update.
+type UpdateError struct {
+	Zone  string
+	Rcode int
+}
+
+func (e UpdateError) Error() string {
+	rcode := dns.RcodeToString[e.Rcode]
+
+	switch e.Rcode {
+	case dns.RcodeServerFailure:
+		return fmt.Sprintf("rfc2136: the server failed to apply the update of the zone %s (%s), it may be unable to reach the primary server or to store the zone", e.Zone, rcode)
+	case dns.RcodeNotAuth:
+		return fmt.Sprintf("rfc2136: the server is not authoritative for the zone %s or did not accept the TSIG key (%s)", e.Zone, rcode)
+	default:
+		return fmt.Sprintf("rfc2136: DNS update of the zone %s failed, server replied: %s", e.Zone, rcode)
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface that
 // uses dynamic DNS updates (RFC 2136) to create TXT records on a nameserver.
 type DNSProvider struct {
-	nameserver    string
-	tsigAlgorithm string
-	tsigKey       string
-	tsigSecret    string
-	timeout       time.Duration
+	config *Config
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for rfc2136
 // dynamic update. Configured with environment variables:
 // RFC2136_NAMESERVER: Network address in the form "host" or "host:port".
+// RFC2136_NET: "udp" (default) or "tcp".
 // RFC2136_TSIG_ALGORITHM: Defaults to hmac-md5.sig-alg.reg.int. (HMAC-MD5).
 // See https://github.com/miekg/dns/blob/master/tsig.go for supported values.
 // RFC2136_TSIG_KEY: Name of the secret key as defined in DNS server configuration.
@@ -33,13 +82,22 @@ type DNSProvider struct {
 // RFC2136_TIMEOUT: DNS propagation timeout in time.ParseDuration format. (60s)
 // To disable TSIG authentication, leave the RFC2136_TSIG* variables unset.
 func NewDNSProvider() (*DNSProvider, error) {
-	nameserver := os.Getenv("RFC2136_NAMESERVER")
-	tsigAlgorithm := os.Getenv("RFC2136_TSIG_ALGORITHM")
-	tsigKey := os.Getenv("RFC2136_TSIG_KEY")
-	tsigSecret := os.Getenv("RFC2136_TSIG_SECRET")
-	timeout := os.Getenv("RFC2136_TIMEOUT")
+	config := NewDefaultConfig()
+	config.Nameserver = os.Getenv("RFC2136_NAMESERVER")
+	config.Net = os.Getenv("RFC2136_NET")
+	config.TSIGAlgorithm = os.Getenv("RFC2136_TSIG_ALGORITHM")
+	config.TSIGKey = os.Getenv("RFC2136_TSIG_KEY")
+	config.TSIGSecret = os.Getenv("RFC2136_TSIG_SECRET")
 
-	return NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret, timeout)
+	if timeout := os.Getenv("RFC2136_TIMEOUT"); timeout != "" {
+		var err error
+		config.PropagationTimeout, err = parseTimeout(timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -47,67 +105,93 @@ func NewDNSProvider() (*DNSProvider, error) {
 // authentication, leave the TSIG parameters as empty strings.
 // nameserver must be a network address in the form "host" or "host:port".
 func NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret, timeout string) (*DNSProvider, error) {
-	if nameserver == "" {
-		return nil, fmt.Errorf("RFC2136 nameserver missing")
+	config := NewDefaultConfig()
+	config.Nameserver = nameserver
+	config.TSIGAlgorithm = tsigAlgorithm
+	config.TSIGKey = tsigKey
+	config.TSIGSecret = tsigSecret
+
+	if timeout != "" {
+		var err error
+		config.PropagationTimeout, err = parseTimeout(timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for rfc2136 dynamic update.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("rfc2136: the configuration of the DNS provider is nil")
+	}
+
+	if config.Nameserver == "" {
+		return nil, errors.New("rfc2136: nameserver missing")
 	}
 
 	// Append the default DNS port if none is specified.
-	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+	if _, _, err := net.SplitHostPort(config.Nameserver); err != nil {
 		if strings.Contains(err.Error(), "missing port") {
-			nameserver = net.JoinHostPort(nameserver, "53")
+			config.Nameserver = net.JoinHostPort(config.Nameserver, "53")
 		} else {
 			return nil, err
 		}
 	}
 
-	d := &DNSProvider{nameserver: nameserver}
-
-	if tsigAlgorithm == "" {
-		tsigAlgorithm = dns.HmacMD5
+	switch config.Net {
+	case "":
+		config.Net = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("rfc2136: unsupported transport %q, must be udp or tcp", config.Net)
 	}
-	d.tsigAlgorithm = tsigAlgorithm
 
-	if len(tsigKey) > 0 && len(tsigSecret) > 0 {
-		d.tsigKey = tsigKey
-		d.tsigSecret = tsigSecret
+	if config.TSIGAlgorithm == "" {
+		config.TSIGAlgorithm = dns.HmacMD5
 	}
 
-	if timeout == "" {
-		d.timeout = 60 * time.Second
-	} else {
-		t, err := time.ParseDuration(timeout)
-		if err != nil {
-			return nil, err
-		} else if t < 0 {
-			return nil, fmt.Errorf("invalid/negative RFC2136_TIMEOUT: %v", timeout)
-		} else {
-			d.timeout = t
-		}
+	if config.TSIGKey == "" || config.TSIGSecret == "" {
+		config.TSIGKey = ""
+		config.TSIGSecret = ""
 	}
 
-	return d, nil
+	return &DNSProvider{config: config}, nil
+}
+
+func parseTimeout(timeout string) (time.Duration, error) {
+	t, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if t < 0 {
+		return 0, fmt.Errorf("invalid/negative RFC2136_TIMEOUT: %v", timeout)
+	}
+	return t, nil
 }
 
 // Timeout Returns the timeout configured with RFC2136_TIMEOUT, or 60s.
 func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
-	return d.timeout, 2 * time.Second
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record using the specified parameters
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-	return d.changeRecord("INSERT", fqdn, value, ttl)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.changeRecord("INSERT", fqdn, value, d.config.TTL)
 }
 
 // CleanUp removes the TXT record matching the specified parameters
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-	return d.changeRecord("REMOVE", fqdn, value, ttl)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.changeRecord("REMOVE", fqdn, value, d.config.TTL)
 }
 
 func (d *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 	// Find the zone for the given fqdn
-	zone, err := acme.FindZoneByFqdn(fqdn, []string{d.nameserver})
+	zone, err := acme.FindZoneByFqdn(fqdn, []string{d.config.Nameserver})
 	if err != nil {
 		return err
 	}
@@ -132,23 +216,41 @@ func (d *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 		return fmt.Errorf("unexpected action: %s", action)
 	}
 
-	// Setup client
-	c := new(dns.Client)
-	c.SingleInflight = true
 	// TSIG authentication / msg signing
-	if len(d.tsigKey) > 0 && len(d.tsigSecret) > 0 {
-		m.SetTsig(dns.Fqdn(d.tsigKey), d.tsigAlgorithm, 300, time.Now().Unix())
-		c.TsigSecret = map[string]string{dns.Fqdn(d.tsigKey): d.tsigSecret}
+	if d.config.TSIGKey != "" {
+		m.SetTsig(dns.Fqdn(d.config.TSIGKey), d.config.TSIGAlgorithm, 300, time.Now().Unix())
 	}
 
 	// Send the query
-	reply, _, err := c.Exchange(m, d.nameserver)
+	reply, err := d.exchange(m)
 	if err != nil {
 		return fmt.Errorf("DNS update failed: %v", err)
 	}
 	if reply != nil && reply.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("DNS update failed. Server replied: %s", dns.RcodeToString[reply.Rcode])
+		return UpdateError{Zone: zone, Rcode: reply.Rcode}
 	}
 
 	return nil
 }
+
+// exchange sends the update with the configured transport,
+// retrying over TCP if the reply to an UDP update is truncated.
+func (d *DNSProvider) exchange(m *dns.Msg) (*dns.Msg, error) {
+	reply, err := d.exchangeNet(d.config.Net, m)
+
+	if d.config.Net == "udp" && (err == dns.ErrTruncated || (err == nil && reply != nil && reply.Truncated)) {
+		return d.exchangeNet("tcp", m)
+	}
+
+	return reply, err
+}
+
+func (d *DNSProvider) exchangeNet(network string, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: network, Timeout: d.config.DNSTimeout, SingleInflight: true}
+	if d.config.TSIGKey != "" {
+		c.TsigSecret = map[string]string{dns.Fqdn(d.config.TSIGKey): d.config.TSIGSecret}
+	}
+
+	reply, _, err := c.Exchange(m, d.config.Nameserver)
+	return reply, err
+}
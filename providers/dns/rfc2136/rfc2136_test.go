@@ -224,3 +224,254 @@ func serverHandlerPassBackRequest(w dns.ResponseWriter, req *dns.Msg) {
 		reqChan <- req
 	}
 }
+
+// updateRecorder is a DNS handler serving the SOA of the test zone
+// and recording the updates with the network they were received on.
+type updateRecorder struct {
+	updates chan receivedUpdate
+	// reply returns the reply to an update received on the network.
+	reply func(network string, req *dns.Msg, tsigErr error) *dns.Msg
+}
+
+type receivedUpdate struct {
+	network string
+	msg     *dns.Msg
+	tsigErr error
+}
+
+func (u *updateRecorder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if req.Opcode == dns.OpcodeQuery {
+		serverHandlerReturnSuccess(w, req)
+		return
+	}
+
+	var tsigErr error
+	if req.IsTsig() != nil {
+		tsigErr = w.TsigStatus()
+	}
+
+	network := w.RemoteAddr().Network()
+	u.updates <- receivedUpdate{network: network, msg: req, tsigErr: tsigErr}
+
+	m := u.reply(network, req, tsigErr)
+	if req.IsTsig() != nil && tsigErr == nil {
+		m.SetTsig(rfc2136TestZone, dns.HmacMD5, 300, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+func replySuccess(network string, req *dns.Msg, tsigErr error) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	return m
+}
+
+// runLocalDNSTestServers starts an UDP and a TCP server on the same port.
+func runLocalDNSTestServers(t *testing.T, handler dns.Handler, tsig bool) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+
+	var servers []*dns.Server
+	for _, server := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		if tsig {
+			server.TsigSecret = map[string]string{rfc2136TestTsigKey: rfc2136TestTsigSecret}
+		}
+
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+
+		go server.ActivateAndServe()
+		<-started
+
+		servers = append(servers, server)
+	}
+
+	return pc.LocalAddr().String(), func() {
+		for _, server := range servers {
+			server.Shutdown()
+		}
+	}
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "rfc2136: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "rfc2136: nameserver missing")
+
+	config := NewDefaultConfig()
+	config.Nameserver = "127.0.0.1"
+	config.Net = "tcp-tls"
+	_, err = NewDNSProviderConfig(config)
+	assert.EqualError(t, err, `rfc2136: unsupported transport "tcp-tls", must be udp or tcp`)
+
+	config.Net = ""
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:53", provider.config.Nameserver)
+	assert.Equal(t, "udp", provider.config.Net)
+}
+
+func TestRFC2136PresentAndCleanUp(t *testing.T) {
+	acme.ClearFqdnCache()
+
+	recorder := &updateRecorder{updates: make(chan receivedUpdate, 10), reply: replySuccess}
+	addr, shutdown := runLocalDNSTestServers(t, recorder, true)
+	defer shutdown()
+
+	config := NewDefaultConfig()
+	config.Nameserver = addr
+	config.TSIGKey = rfc2136TestTsigKey
+	config.TSIGSecret = rfc2136TestTsigSecret
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present(rfc2136TestDomain, "", "1234d==")
+	require.NoError(t, err)
+
+	update := <-recorder.updates
+	assert.Equal(t, "udp", update.network)
+	require.NotNil(t, update.msg.IsTsig(), "the update is not signed")
+	assert.NoError(t, update.tsigErr, "invalid TSIG signature")
+	assert.Equal(t, rfc2136TestZone, update.msg.Question[0].Name)
+	assert.Equal(t, dns.TypeSOA, update.msg.Question[0].Qtype)
+
+	// The RRset of the fqdn is deleted before adding the record.
+	require.Len(t, update.msg.Ns, 2)
+	assert.Equal(t, dns.RR_Header{Name: rfc2136TestFqdn, Rrtype: dns.TypeTXT, Class: dns.ClassANY, Ttl: 0}, *update.msg.Ns[0].Header())
+	added := update.msg.Ns[1].(*dns.TXT)
+	assert.Equal(t, dns.RR_Header{Name: rfc2136TestFqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(rfc2136TestTTL), Rdlength: added.Hdr.Rdlength}, added.Hdr)
+	assert.Equal(t, []string{rfc2136TestValue}, added.Txt)
+
+	err = provider.CleanUp(rfc2136TestDomain, "", "1234d==")
+	require.NoError(t, err)
+
+	update = <-recorder.updates
+	assert.NoError(t, update.tsigErr, "invalid TSIG signature")
+
+	// Only the record with the value is deleted.
+	require.Len(t, update.msg.Ns, 1)
+	removed := update.msg.Ns[0].(*dns.TXT)
+	assert.Equal(t, uint16(dns.ClassNONE), removed.Hdr.Class)
+	assert.Equal(t, uint32(0), removed.Hdr.Ttl)
+	assert.Equal(t, []string{rfc2136TestValue}, removed.Txt)
+}
+
+func TestRFC2136TruncatedFallbackToTCP(t *testing.T) {
+	acme.ClearFqdnCache()
+
+	recorder := &updateRecorder{
+		updates: make(chan receivedUpdate, 10),
+		reply: func(network string, req *dns.Msg, tsigErr error) *dns.Msg {
+			m := replySuccess(network, req, tsigErr)
+			m.Truncated = network == "udp"
+			return m
+		},
+	}
+	addr, shutdown := runLocalDNSTestServers(t, recorder, true)
+	defer shutdown()
+
+	config := NewDefaultConfig()
+	config.Nameserver = addr
+	config.TSIGKey = rfc2136TestTsigKey
+	config.TSIGSecret = rfc2136TestTsigSecret
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present(rfc2136TestDomain, "", rfc2136TestKeyAuth)
+	require.NoError(t, err)
+
+	for _, network := range []string{"udp", "tcp"} {
+		update := <-recorder.updates
+		assert.Equal(t, network, update.network)
+		assert.NoError(t, update.tsigErr, "invalid TSIG signature over %s", network)
+	}
+}
+
+func TestRFC2136TCP(t *testing.T) {
+	acme.ClearFqdnCache()
+
+	recorder := &updateRecorder{updates: make(chan receivedUpdate, 10), reply: replySuccess}
+	addr, shutdown := runLocalDNSTestServers(t, recorder, false)
+	defer shutdown()
+
+	config := NewDefaultConfig()
+	config.Nameserver = addr
+	config.Net = "tcp"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present(rfc2136TestDomain, "", rfc2136TestKeyAuth)
+	require.NoError(t, err)
+
+	update := <-recorder.updates
+	assert.Equal(t, "tcp", update.network)
+	assert.Nil(t, update.msg.IsTsig())
+}
+
+func TestRFC2136UpdateErrors(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		secret   string
+		rcode    int
+		expected string
+	}{
+		{
+			desc:     "server failure",
+			secret:   rfc2136TestTsigSecret,
+			rcode:    dns.RcodeServerFailure,
+			expected: "rfc2136: the server failed to apply the update of the zone example.com. (SERVFAIL), it may be unable to reach the primary server or to store the zone",
+		},
+		{
+			desc:     "invalid TSIG signature",
+			secret:   "d3JvbmcgIHNlY3JldA==",
+			rcode:    dns.RcodeNotAuth,
+			expected: "rfc2136: the server is not authoritative for the zone example.com. or did not accept the TSIG key (NOTAUTH)",
+		},
+		{
+			desc:     "refused",
+			secret:   rfc2136TestTsigSecret,
+			rcode:    dns.RcodeRefused,
+			expected: "rfc2136: DNS update of the zone example.com. failed, server replied: REFUSED",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			acme.ClearFqdnCache()
+
+			recorder := &updateRecorder{
+				updates: make(chan receivedUpdate, 10),
+				reply: func(network string, req *dns.Msg, tsigErr error) *dns.Msg {
+					m := new(dns.Msg)
+					if tsigErr != nil {
+						return m.SetRcode(req, dns.RcodeNotAuth)
+					}
+					return m.SetRcode(req, test.rcode)
+				},
+			}
+			addr, shutdown := runLocalDNSTestServers(t, recorder, true)
+			defer shutdown()
+
+			config := NewDefaultConfig()
+			config.Nameserver = addr
+			config.TSIGKey = rfc2136TestTsigKey
+			config.TSIGSecret = test.secret
+
+			provider, err := NewDNSProviderConfig(config)
+			require.NoError(t, err)
+
+			err = provider.Present(rfc2136TestDomain, "", rfc2136TestKeyAuth)
+			require.Error(t, err)
+			assert.EqualError(t, err, test.expected)
+			assert.Equal(t, UpdateError{Zone: rfc2136TestZone, Rcode: test.rcode}, err)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package route53
 
+import "fmt"
+
 var ChangeResourceRecordSetsResponse = `<?xml version="1.0" encoding="UTF-8"?>
 <ChangeResourceRecordSetsResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
 <ChangeInfo>
@@ -37,3 +39,42 @@ var GetChangeResponse = `<?xml version="1.0" encoding="UTF-8"?>
       <SubmittedAt>2016-02-10T01:36:41.958Z</SubmittedAt>
    </ChangeInfo>
 </GetChangeResponse>`
+
+var GetChangePendingResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<GetChangeResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+   <ChangeInfo>
+      <Id>123456</Id>
+      <Status>PENDING</Status>
+      <SubmittedAt>2016-02-10T01:36:41.958Z</SubmittedAt>
+   </ChangeInfo>
+</GetChangeResponse>`
+
+var ThrottlingResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ErrorResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+   <Error>
+      <Type>Sender</Type>
+      <Code>Throttling</Code>
+      <Message>Rate exceeded</Message>
+   </Error>
+   <RequestId>b25f48e8-84fd-11e6-80d9-574e0c4664cb</RequestId>
+</ErrorResponse>`
+
+var listHostedZonesByNameTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<ListHostedZonesByNameResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+   <HostedZones>%s</HostedZones>
+   <IsTruncated>false</IsTruncated>
+   <MaxItems>100</MaxItems>
+</ListHostedZonesByNameResponse>`
+
+func hostedZone(id, name string, private bool) string {
+	return fmt.Sprintf(`
+      <HostedZone>
+         <Id>/hostedzone/%s</Id>
+         <Name>%s</Name>
+         <CallerReference>D2224C5B-684A-DB4A-BB9A-E09E3BAFEA7A</CallerReference>
+         <Config>
+            <PrivateZone>%t</PrivateZone>
+         </Config>
+         <ResourceRecordSetCount>10</ResourceRecordSetCount>
+      </HostedZone>`, id, name, private)
+}
@@ -5,6 +5,7 @@ package route53
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"strings"
@@ -12,9 +13,12 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/xenolf/lego/acme"
 )
 
@@ -25,16 +29,26 @@ type Config struct {
 	PropagationTimeout time.Duration
 	PollingInterval    time.Duration
 	HostedZoneID       string
+
+	// AssumeRoleArn is the ARN of a role to assume with STS, using the credentials of the default chain
+	// or the web identity token of WebIdentityTokenFile if set.
+	AssumeRoleArn        string
+	WebIdentityTokenFile string
+	// RoleSessionName identifies the sessions of the assumed role, "lego" by default.
+	RoleSessionName string
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider
 func NewDefaultConfig() *Config {
 	return &Config{
-		MaxRetries:         5,
-		TTL:                10,
-		PropagationTimeout: time.Minute * 2,
-		PollingInterval:    time.Second * 4,
-		HostedZoneID:       os.Getenv("AWS_HOSTED_ZONE_ID"),
+		MaxRetries:           5,
+		TTL:                  10,
+		PropagationTimeout:   time.Minute * 2,
+		PollingInterval:      time.Second * 4,
+		HostedZoneID:         os.Getenv("AWS_HOSTED_ZONE_ID"),
+		AssumeRoleArn:        os.Getenv("AWS_ROLE_ARN"),
+		WebIdentityTokenFile: os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		RoleSessionName:      os.Getenv("AWS_ROLE_SESSION_NAME"),
 	}
 }
 
@@ -71,13 +85,16 @@ func (d customRetryer) RetryRules(r *request.Request) time.Duration {
 //
 // AWS Credentials are automatically detected in the following locations
 // and prioritized in the following order:
-// 1. Environment variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
-//    AWS_REGION, [AWS_SESSION_TOKEN]
-// 2. Shared credentials file (defaults to ~/.aws/credentials)
-// 3. Amazon EC2 IAM role
+//  1. Environment variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+//     AWS_REGION, [AWS_SESSION_TOKEN]
+//  2. Shared credentials file (defaults to ~/.aws/credentials)
+//  3. Amazon EC2 IAM role
+//
+// If AWS_ROLE_ARN is set, the role is assumed with these credentials, or with
+// the web identity token of AWS_WEB_IDENTITY_TOKEN_FILE if set (e.g. on EKS).
 //
-// If AWS_HOSTED_ZONE_ID is not set, Lego tries to determine the correct
-// public hosted zone via the FQDN.
+// If AWS_HOSTED_ZONE_ID is not set, Lego uses the public hosted zone
+// with the longest name matching the FQDN.
 //
 // See also: https://github.com/aws/aws-sdk-go/wiki/configuring-sdk
 func NewDNSProvider() (*DNSProvider, error) {
@@ -98,7 +115,30 @@ func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
 	if err != nil {
 		return nil, err
 	}
-	client := route53.New(session)
+
+	sessionName := config.RoleSessionName
+	if sessionName == "" {
+		sessionName = "lego"
+	}
+
+	var client *route53.Route53
+	switch {
+	case config.AssumeRoleArn != "" && config.WebIdentityTokenFile != "":
+		provider := &webIdentityProvider{
+			client:      sts.New(session, &aws.Config{Credentials: credentials.AnonymousCredentials}),
+			roleArn:     config.AssumeRoleArn,
+			sessionName: sessionName,
+			tokenFile:   config.WebIdentityTokenFile,
+		}
+		client = route53.New(session, &aws.Config{Credentials: credentials.NewCredentials(provider)})
+	case config.AssumeRoleArn != "":
+		creds := stscreds.NewCredentials(session, config.AssumeRoleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+		})
+		client = route53.New(session, &aws.Config{Credentials: creds})
+	default:
+		client = route53.New(session)
+	}
 
 	return &DNSProvider{
 		client: client,
@@ -173,38 +213,34 @@ func (r *DNSProvider) getHostedZoneID(fqdn string) (string, error) {
 		return r.config.HostedZoneID, nil
 	}
 
-	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
-	if err != nil {
-		return "", err
-	}
+	// the longest matching zone is found first, looking up the names from the fqdn to its TLD.
+	labels := strings.Split(acme.UnFqdn(fqdn), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		name := strings.Join(labels[i:], ".")
 
-	// .DNSName should not have a trailing dot
-	reqParams := &route53.ListHostedZonesByNameInput{
-		DNSName: aws.String(acme.UnFqdn(authZone)),
-	}
-	resp, err := r.client.ListHostedZonesByName(reqParams)
-	if err != nil {
-		return "", err
-	}
-
-	var hostedZoneID string
-	for _, hostedZone := range resp.HostedZones {
-		// .Name has a trailing dot
-		if !aws.BoolValue(hostedZone.Config.PrivateZone) && aws.StringValue(hostedZone.Name) == authZone {
-			hostedZoneID = aws.StringValue(hostedZone.Id)
-			break
+		// .DNSName should not have a trailing dot
+		reqParams := &route53.ListHostedZonesByNameInput{
+			DNSName: aws.String(name),
+		}
+		resp, err := r.client.ListHostedZonesByName(reqParams)
+		if err != nil {
+			return "", err
 		}
-	}
 
-	if len(hostedZoneID) == 0 {
-		return "", fmt.Errorf("zone %s not found in Route 53 for domain %s", authZone, fqdn)
-	}
+		// the zones are sorted by name, starting at the requested one.
+		for _, hostedZone := range resp.HostedZones {
+			// .Name has a trailing dot
+			if aws.StringValue(hostedZone.Name) != acme.ToFqdn(name) {
+				break
+			}
 
-	if strings.HasPrefix(hostedZoneID, "/hostedzone/") {
-		hostedZoneID = strings.TrimPrefix(hostedZoneID, "/hostedzone/")
+			if hostedZone.Config == nil || !aws.BoolValue(hostedZone.Config.PrivateZone) {
+				return strings.TrimPrefix(aws.StringValue(hostedZone.Id), "/hostedzone/"), nil
+			}
+		}
 	}
 
-	return hostedZoneID, nil
+	return "", fmt.Errorf("no public hosted zone found in Route 53 for domain %s", fqdn)
 }
 
 func newTXTRecordSet(fqdn, value string, ttl int) *route53.ResourceRecordSet {
@@ -217,3 +253,46 @@ func newTXTRecordSet(fqdn, value string, ttl int) *route53.ResourceRecordSet {
 		},
 	}
 }
+
+// stsWebIdentityAPI is the STS API used by the webIdentityProvider.
+type stsWebIdentityAPI interface {
+	AssumeRoleWithWebIdentity(*sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// webIdentityProvider retrieves credentials by assuming a role with the web identity token of a file,
+// which is read again at each renewal as it is rotated (e.g. a projected Kubernetes service account token).
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	client      stsWebIdentityAPI
+	roleArn     string
+	sessionName string
+	tokenFile   string
+}
+
+// Retrieve implements credentials.Provider.
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read the web identity token: %v", err)
+	}
+
+	resp, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleArn),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to assume the role %s with the web identity token: %v", p.roleArn, err)
+	}
+
+	// renew the credentials a minute before they expire.
+	p.SetExpiration(aws.TimeValue(resp.Credentials.Expiration), time.Minute)
+
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(resp.Credentials.SessionToken),
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
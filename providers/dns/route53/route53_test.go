@@ -1,15 +1,22 @@
 package route53
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -17,6 +24,7 @@ var (
 	route53Key    string
 	route53Region string
 	route53Zone   string
+	route53Role   string
 )
 
 func init() {
@@ -24,6 +32,7 @@ func init() {
 	route53Secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
 	route53Region = os.Getenv("AWS_REGION")
 	route53Zone = os.Getenv("AWS_HOSTED_ZONE_ID")
+	route53Role = os.Getenv("AWS_ROLE_ARN")
 }
 
 func restoreEnv() {
@@ -31,6 +40,7 @@ func restoreEnv() {
 	os.Setenv("AWS_SECRET_ACCESS_KEY", route53Secret)
 	os.Setenv("AWS_REGION", route53Region)
 	os.Setenv("AWS_HOSTED_ZONE_ID", route53Zone)
+	os.Setenv("AWS_ROLE_ARN", route53Role)
 }
 
 func makeRoute53Provider(ts *httptest.Server) *DNSProvider {
@@ -102,3 +112,171 @@ func TestRoute53Present(t *testing.T) {
 	err := provider.Present(domain, "", keyAuth)
 	assert.NoError(t, err, "Expected Present to return no error")
 }
+
+func TestRoute53GetHostedZoneIDLongestMatch(t *testing.T) {
+	zonesByName := map[string]string{
+		"sub.example.com": hostedZone("PRIVATE", "sub.example.com.", true) + hostedZone("SUBZONE", "sub.example.com.", false) + hostedZone("OTHER", "tub.example.com.", false),
+		"example.com":     hostedZone("ABCDEFG", "example.com.", false),
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/2013-04-01/hostedzonesbyname", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, listHostedZonesByNameTemplate, zonesByName[r.URL.Query().Get("dnsname")])
+	}))
+	defer ts.Close()
+
+	provider := makeRoute53Provider(ts)
+
+	testCases := []struct {
+		fqdn     string
+		expected string
+	}{
+		{fqdn: "_acme-challenge.www.sub.example.com.", expected: "SUBZONE"},
+		{fqdn: "_acme-challenge.sub.example.com.", expected: "SUBZONE"},
+		{fqdn: "_acme-challenge.www.example.com.", expected: "ABCDEFG"},
+		{fqdn: "_acme-challenge.example.com.", expected: "ABCDEFG"},
+	}
+
+	for _, test := range testCases {
+		zoneID, err := provider.getHostedZoneID(test.fqdn)
+		require.NoError(t, err, test.fqdn)
+		assert.Equal(t, test.expected, zoneID, test.fqdn)
+	}
+
+	_, err := provider.getHostedZoneID("_acme-challenge.example.org.")
+	assert.EqualError(t, err, "no public hosted zone found in Route 53 for domain _acme-challenge.example.org.")
+}
+
+func TestRoute53PresentWaitsForInsync(t *testing.T) {
+	var polls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+
+		switch r.URL.Path {
+		case "/2013-04-01/hostedzonesbyname":
+			w.Write([]byte(ListHostedZonesByNameResponse))
+		case "/2013-04-01/hostedzone/ABCDEFG/rrset/":
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Contains(t, string(body), "<Action>UPSERT</Action>")
+			w.Write([]byte(ChangeResourceRecordSetsResponse))
+		case "/2013-04-01/change/123456":
+			polls++
+			if polls < 3 {
+				w.Write([]byte(GetChangePendingResponse))
+				return
+			}
+			w.Write([]byte(GetChangeResponse))
+		default:
+			require.FailNow(t, "Requested path not found: "+r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	provider := makeRoute53Provider(ts)
+	provider.config.PollingInterval = 10 * time.Millisecond
+
+	err := provider.Present("example.com", "", "123456d==")
+	require.NoError(t, err)
+	assert.Equal(t, 3, polls)
+}
+
+func TestRoute53ThrottlingIsRetried(t *testing.T) {
+	var changes int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+
+		switch r.URL.Path {
+		case "/2013-04-01/hostedzonesbyname":
+			w.Write([]byte(ListHostedZonesByNameResponse))
+		case "/2013-04-01/hostedzone/ABCDEFG/rrset/":
+			changes++
+			if changes == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(ThrottlingResponse))
+				return
+			}
+			w.Write([]byte(ChangeResourceRecordSetsResponse))
+		case "/2013-04-01/change/123456":
+			w.Write([]byte(GetChangeResponse))
+		default:
+			require.FailNow(t, "Requested path not found: "+r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	retryer := customRetryer{}
+	retryer.NumMaxRetries = 3
+	config := request.WithRetryer(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("abc", "123", " "),
+		Endpoint:    aws.String(ts.URL),
+		Region:      aws.String("mock-region"),
+	}, retryer)
+
+	provider := &DNSProvider{client: route53.New(session.New(config)), config: NewDefaultConfig()}
+
+	err := provider.CleanUp("example.com", "", "123456d==")
+	require.NoError(t, err)
+	assert.Equal(t, 2, changes)
+}
+
+type mockSTS struct {
+	input *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (m *mockSTS) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	m.input = input
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String("ASIAEXAMPLE"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("session"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "lego-route53-token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+
+	_, err = tokenFile.WriteString("web-identity-token\n")
+	require.NoError(t, err)
+	require.NoError(t, tokenFile.Close())
+
+	client := &mockSTS{}
+	provider := &webIdentityProvider{
+		client:      client,
+		roleArn:     "arn:aws:iam::123456789012:role/lego",
+		sessionName: "lego",
+		tokenFile:   tokenFile.Name(),
+	}
+
+	value, err := credentials.NewCredentials(provider).Get()
+	require.NoError(t, err)
+
+	assert.Equal(t, "ASIAEXAMPLE", value.AccessKeyID)
+	assert.Equal(t, "secret", value.SecretAccessKey)
+	assert.Equal(t, "session", value.SessionToken)
+	assert.False(t, provider.IsExpired())
+
+	assert.Equal(t, "arn:aws:iam::123456789012:role/lego", aws.StringValue(client.input.RoleArn))
+	assert.Equal(t, "lego", aws.StringValue(client.input.RoleSessionName))
+	assert.Equal(t, "web-identity-token", aws.StringValue(client.input.WebIdentityToken))
+
+	provider.tokenFile = tokenFile.Name() + ".missing"
+	_, err = provider.Retrieve()
+	assert.Error(t, err)
+}
+
+func TestNewDNSProviderAssumeRole(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/lego")
+
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/lego", provider.config.AssumeRoleArn)
+	assert.NotNil(t, provider.client.Config.Credentials)
+}
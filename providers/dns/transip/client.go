@@ -0,0 +1,221 @@
+package transip
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// tokenLifetime is the lifetime requested for the access tokens, which are renewed a bit before they expire.
+const tokenLifetime = 30 * time.Minute
+
+// authRequest is the request of an access token, signed with the private key of the account.
+type authRequest struct {
+	Login          string `json:"login"`
+	Nonce          string `json:"nonce"`
+	ReadOnly       bool   `json:"read_only"`
+	ExpirationTime string `json:"expiration_time"`
+	Label          string `json:"label"`
+	GlobalKey      bool   `json:"global_key"`
+}
+
+type authResponse struct {
+	Token string `json:"token"`
+}
+
+// dnsEntry is an entry of the DNS of a domain. TransIP matches the entries by all their fields.
+type dnsEntry struct {
+	Name    string `json:"name"`
+	Expire  int    `json:"expire"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+type dnsEntryRequest struct {
+	DNSEntry dnsEntry `json:"dnsEntry"`
+}
+
+// APIError is an error returned by the TransIP API.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+// loadPrivateKey reads the PEM encoded RSA private key of the account, in PKCS #8 or PKCS #1 form.
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the private key of %s: %v", path, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key of %s is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+// signBody returns the signature of the body of an authentication request, as expected in the Signature header.
+func signBody(key *rsa.PrivateKey, body []byte) (string, error) {
+	digest := sha256.Sum256(body)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// getToken returns an access token, requesting a new one if the current one is about to expire.
+func (d *DNSProvider) getToken() (string, error) {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.token != "" && time.Now().Before(d.tokenExpiration) {
+		return d.token, nil
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(authRequest{
+		Login:          d.config.AccountName,
+		Nonce:          nonce,
+		ExpirationTime: fmt.Sprintf("%d minutes", tokenLifetime/time.Minute),
+		Label:          fmt.Sprintf("lego %s", nonce),
+		GlobalKey:      true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signBody(d.privateKey, body)
+	if err != nil {
+		return "", fmt.Errorf("could not sign the authentication request: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.config.BaseURL+"/auth", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Signature", signature)
+
+	var auth authResponse
+	if err = d.do(req, &auth); err != nil {
+		return "", fmt.Errorf("could not get an access token: %v", err)
+	}
+	if auth.Token == "" {
+		return "", errors.New("could not get an access token: no token in the response")
+	}
+
+	d.token = auth.Token
+	d.tokenExpiration = time.Now().Add(tokenLifetime - time.Minute)
+
+	return d.token, nil
+}
+
+// addEntry adds the entry to the DNS of the domain.
+func (d *DNSProvider) addEntry(domain string, entry dnsEntry) error {
+	return d.doEntryRequest(http.MethodPatch, domain, entry)
+}
+
+// removeEntry removes the entry matching exactly from the DNS of the domain.
+func (d *DNSProvider) removeEntry(domain string, entry dnsEntry) error {
+	return d.doEntryRequest(http.MethodDelete, domain, entry)
+}
+
+func (d *DNSProvider) doEntryRequest(method, domain string, entry dnsEntry) error {
+	token, err := d.getToken()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(dnsEntryRequest{DNSEntry: entry})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, d.config.BaseURL+"/domains/"+url.PathEscape(domain)+"/dns", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return d.do(req, nil)
+}
+
+// do sends the request and decodes the JSON response into result if not nil.
+func (d *DNSProvider) do(req *http.Request, result interface{}) error {
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := APIError{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(raw, &apiErr); err != nil || apiErr.Message == "" {
+			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode API response: %v: %s", err, string(raw))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Package transip implements a DNS provider for solving the DNS-01 challenge using TransIP.
+// See https://api.transip.nl/rest/docs.html
+package transip
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://api.transip.nl/v6"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	AccountName string
+	// PrivateKeyPath is the path of the PEM encoded private key of a key pair of the account.
+	PrivateKeyPath     string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("TRANSIP_PROPAGATION_TIMEOUT", 10*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("TRANSIP_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("TRANSIP_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("TRANSIP_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// presentedEntry is an entry added by Present, with its domain.
+type presentedEntry struct {
+	domain string
+	entry  dnsEntry
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the TransIP API to manage TXT records for a domain.
+type DNSProvider struct {
+	config     *Config
+	privateKey *rsa.PrivateKey
+
+	token           string
+	tokenExpiration time.Time
+	tokenMu         sync.Mutex
+
+	// entries are the added entries, by fqdn and value.
+	entries   map[string]presentedEntry
+	entriesMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for TransIP.
+// Credentials must be passed in the environment variables:
+// TRANSIP_ACCOUNT_NAME and TRANSIP_PRIVATE_KEY_PATH.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("TRANSIP_ACCOUNT_NAME", "TRANSIP_PRIVATE_KEY_PATH")
+	if err != nil {
+		return nil, fmt.Errorf("transip: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.AccountName = values["TRANSIP_ACCOUNT_NAME"]
+	config.PrivateKeyPath = values["TRANSIP_PRIVATE_KEY_PATH"]
+	if baseURL := os.Getenv("TRANSIP_BASE_URL"); baseURL != "" {
+		config.BaseURL = baseURL
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for TransIP.
+func NewDNSProviderCredentials(accountName, privateKeyPath string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.AccountName = accountName
+	config.PrivateKeyPath = privateKeyPath
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for TransIP.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("transip: the configuration of the DNS provider is nil")
+	}
+
+	if config.AccountName == "" || config.PrivateKeyPath == "" {
+		return nil, errors.New("transip: credentials missing")
+	}
+
+	privateKey, err := loadPrivateKey(config.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("transip: %v", err)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{
+		config:     config,
+		privateKey: privateKey,
+		entries:    make(map[string]presentedEntry),
+	}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return fmt.Errorf("transip: %v", err)
+	}
+
+	zone = acme.UnFqdn(zone)
+	entry := dnsEntry{
+		Name:    extractRecordName(fqdn, zone),
+		Expire:  d.config.TTL,
+		Type:    "TXT",
+		Content: value,
+	}
+
+	if err = d.addEntry(zone, entry); err != nil {
+		return fmt.Errorf("transip: failed to add the DNS entry: %v", err)
+	}
+
+	d.entriesMu.Lock()
+	d.entries[fqdn+" "+value] = presentedEntry{domain: zone, entry: entry}
+	d.entriesMu.Unlock()
+
+	return nil
+}
+
+// CleanUp removes the TXT record added by Present.
+// TransIP removes the entries matching exactly, so the entry is sent as it was added.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.entriesMu.Lock()
+	presented, ok := d.entries[fqdn+" "+value]
+	d.entriesMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("transip: unknown DNS entry for %q", fqdn)
+	}
+
+	if err := d.removeEntry(presented.domain, presented.entry); err != nil {
+		return fmt.Errorf("transip: failed to remove the DNS entry: %v", err)
+	}
+
+	d.entriesMu.Lock()
+	delete(d.entries, fqdn+" "+value)
+	d.entriesMu.Unlock()
+
+	return nil
+}
+
+// extractRecordName returns the name of the entry of the fqdn relative to the domain, "@" for the domain itself.
+func extractRecordName(fqdn, domain string) string {
+	name := acme.UnFqdn(fqdn)
+	if name == domain {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
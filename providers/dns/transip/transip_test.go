@@ -0,0 +1,328 @@
+package transip
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest              bool
+	envTestAccountName    string
+	envTestPrivateKeyPath string
+	envTestDomain         string
+)
+
+func init() {
+	envTestAccountName = os.Getenv("TRANSIP_ACCOUNT_NAME")
+	envTestPrivateKeyPath = os.Getenv("TRANSIP_PRIVATE_KEY_PATH")
+	envTestDomain = os.Getenv("TRANSIP_DOMAIN")
+	liveTest = len(envTestAccountName) > 0 && len(envTestPrivateKeyPath) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("TRANSIP_ACCOUNT_NAME", envTestAccountName)
+	os.Setenv("TRANSIP_PRIVATE_KEY_PATH", envTestPrivateKeyPath)
+}
+
+// writePrivateKey writes a new RSA private key in PKCS #8 form to a temporary file.
+func writePrivateKey(t *testing.T) (*rsa.PrivateKey, string, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	// x509.MarshalPKCS8PrivateKey requires Go 1.10.
+	der, err := asn1.Marshal(struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, Parameters: asn1.NullRawValue},
+		PrivateKey: x509.MarshalPKCS1PrivateKey(key),
+	})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "transip")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "transip.key")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	return key, path, func() { os.RemoveAll(dir) }
+}
+
+// mockServer is a TransIP API with the domain example.com, checking the signature of the authentication requests.
+type mockServer struct {
+	t         *testing.T
+	publicKey *rsa.PublicKey
+	mu        sync.Mutex
+	auths     []authRequest
+	entries   []dnsEntry
+	requests  []string
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	if r.URL.Path == "/auth" {
+		signature, err := base64.StdEncoding.DecodeString(r.Header.Get("Signature"))
+		require.NoError(s.t, err)
+
+		digest := sha256.Sum256(body)
+		if err = rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(APIError{Message: "Invalid signature"})
+			return
+		}
+
+		var auth authRequest
+		require.NoError(s.t, json.Unmarshal(body, &auth))
+		s.auths = append(s.auths, auth)
+
+		json.NewEncoder(w).Encode(authResponse{Token: "jwt"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer jwt" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(APIError{Message: "Your access token is invalid"})
+		return
+	}
+
+	if r.URL.Path != "/domains/example.com/dns" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIError{Message: "Domain not found"})
+		return
+	}
+
+	var request dnsEntryRequest
+	require.NoError(s.t, json.Unmarshal(body, &request))
+
+	switch r.Method {
+	case http.MethodPatch:
+		s.entries = append(s.entries, request.DNSEntry)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		for i, entry := range s.entries {
+			if entry == request.DNSEntry {
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIError{Message: "Dns entry not found"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	_, path, removeKey := writePrivateKey(t)
+	defer removeKey()
+
+	defer restoreEnv()
+	os.Setenv("TRANSIP_ACCOUNT_NAME", "account")
+	os.Setenv("TRANSIP_PRIVATE_KEY_PATH", path)
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("TRANSIP_ACCOUNT_NAME", "")
+	os.Setenv("TRANSIP_PRIVATE_KEY_PATH", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "transip: some credentials information are missing: TRANSIP_ACCOUNT_NAME,TRANSIP_PRIVATE_KEY_PATH")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "transip: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderConfig(NewDefaultConfig())
+	assert.EqualError(t, err, "transip: credentials missing")
+
+	_, err = NewDNSProviderCredentials("account", "/does/not/exist.key")
+	assert.Error(t, err)
+}
+
+func TestLoadPrivateKeyPKCS1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	file, err := ioutil.TempFile("", "transip")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	pem.Encode(file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	file.Close()
+
+	loaded, err := loadPrivateKey(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, key.N, loaded.N)
+}
+
+func TestSignBody(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	body := []byte(`{"login":"account","nonce":"abc"}`)
+
+	signature, err := signBody(key, body)
+	require.NoError(t, err)
+
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(body)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], raw))
+}
+
+func TestExtractRecordName(t *testing.T) {
+	assert.Equal(t, "_acme-challenge", extractRecordName("_acme-challenge.example.com.", "example.com"))
+	assert.Equal(t, "_acme-challenge.www", extractRecordName("_acme-challenge.www.example.com.", "example.com"))
+	assert.Equal(t, "@", extractRecordName("example.com.", "example.com"))
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	key, path, removeKey := writePrivateKey(t)
+	defer removeKey()
+
+	server := &mockServer{t: t, publicKey: &key.PublicKey}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccountName = "account"
+	config.PrivateKeyPath = path
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := dnsEntry{
+		Name:    "_acme-challenge.www",
+		Expire:  300,
+		Type:    "TXT",
+		Content: "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+	}
+	assert.Equal(t, []dnsEntry{expected}, server.entries)
+
+	require.Len(t, server.auths, 1)
+	assert.Equal(t, "account", server.auths[0].Login)
+	assert.NotEmpty(t, server.auths[0].Nonce)
+	assert.Equal(t, "30 minutes", server.auths[0].ExpirationTime)
+
+	// the entry is removed even if the TTL was changed since it was added.
+	provider.config.TTL = 600
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	assert.Empty(t, server.entries)
+	// the token is reused.
+	assert.Equal(t, []string{
+		"POST /auth",
+		"PATCH /domains/example.com/dns",
+		"DELETE /domains/example.com/dns",
+	}, server.requests)
+}
+
+func TestDNSProvider_CleanUpUnknownEntry(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	key, path, removeKey := writePrivateKey(t)
+	defer removeKey()
+
+	server := &mockServer{t: t, publicKey: &key.PublicKey}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccountName = "account"
+	config.PrivateKeyPath = path
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.EqualError(t, err, `transip: unknown DNS entry for "_acme-challenge.example.com."`)
+	assert.Empty(t, server.requests)
+}
+
+func TestDNSProvider_InvalidSignature(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	key, path, removeKey := writePrivateKey(t)
+	defer removeKey()
+
+	ts := httptest.NewServer(&mockServer{t: t, publicKey: &key.PublicKey})
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.AccountName = "account"
+	config.PrivateKeyPath = path
+	config.BaseURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	provider.privateKey = otherKey
+
+	err = provider.Present("example.com", "", "keyAuth")
+	assert.EqualError(t, err, "transip: failed to add the DNS entry: could not get an access token: API error (HTTP 401): Invalid signature")
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
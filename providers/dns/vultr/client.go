@@ -0,0 +1,173 @@
+package vultr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// perPage is the number of domains or records requested per page of a list.
+const perPage = 500
+
+// dnsDomain represents a Vultr DNS domain.
+type dnsDomain struct {
+	Domain string `json:"domain"`
+}
+
+// dnsRecord represents a Vultr DNS record, its name is relative to its domain.
+type dnsRecord struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Priority int    `json:"priority"`
+	TTL      int    `json:"ttl,omitempty"`
+}
+
+// meta holds the cursor of the next page of a list, empty on the last page.
+type meta struct {
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+type domainsPage struct {
+	Domains []dnsDomain `json:"domains"`
+	Meta    meta        `json:"meta"`
+}
+
+type recordsPage struct {
+	Records []dnsRecord `json:"records"`
+	Meta    meta        `json:"meta"`
+}
+
+type apiError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// listDomains pages through the domains of the account.
+func (d *DNSProvider) listDomains() ([]dnsDomain, error) {
+	var domains []dnsDomain
+
+	cursor := ""
+	for {
+		var page domainsPage
+		err := d.do(http.MethodGet, "/domains?"+pageQuery(cursor), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		domains = append(domains, page.Domains...)
+
+		cursor = page.Meta.Links.Next
+		if cursor == "" {
+			return domains, nil
+		}
+	}
+}
+
+// listRecords pages through the records of the domain.
+func (d *DNSProvider) listRecords(domain string) ([]dnsRecord, error) {
+	var records []dnsRecord
+
+	cursor := ""
+	for {
+		var page recordsPage
+		err := d.do(http.MethodGet, fmt.Sprintf("/domains/%s/records?%s", domain, pageQuery(cursor)), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, page.Records...)
+
+		cursor = page.Meta.Links.Next
+		if cursor == "" {
+			return records, nil
+		}
+	}
+}
+
+// createRecord creates the record in the domain and returns its ID.
+func (d *DNSProvider) createRecord(domain string, record dnsRecord) (string, error) {
+	var result struct {
+		Record dnsRecord `json:"record"`
+	}
+
+	err := d.do(http.MethodPost, fmt.Sprintf("/domains/%s/records", domain), record, &result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.Record.ID == "" {
+		return "", fmt.Errorf("no ID returned for the record %s", record.Name)
+	}
+	return result.Record.ID, nil
+}
+
+// deleteRecord deletes the record with the given ID from the domain.
+func (d *DNSProvider) deleteRecord(domain, recordID string) error {
+	return d.do(http.MethodDelete, fmt.Sprintf("/domains/%s/records/%s", domain, recordID), nil, nil)
+}
+
+func pageQuery(cursor string) string {
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(perPage))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	return query.Encode()
+}
+
+func (d *DNSProvider) do(method, uri string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		content, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+d.config.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		raw, _ := ioutil.ReadAll(resp.Body)
+
+		var errInfo apiError
+		if json.Unmarshal(raw, &errInfo) == nil && errInfo.Error != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errInfo.Error)
+		}
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(raw))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not decode the response of %s %s: %v", method, uri, err)
+	}
+	return nil
+}
@@ -1,20 +1,50 @@
 // Package vultr implements a DNS provider for solving the DNS-01 challenge using
 // the vultr DNS.
-// See https://www.vultr.com/api/#dns
+// See https://www.vultr.com/api/#tag/dns
 package vultr
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
-	vultr "github.com/JamesClonk/vultr/lib"
 	"github.com/xenolf/lego/acme"
 	"github.com/xenolf/lego/platform/config/env"
 )
 
+// defaultBaseURL is the endpoint of the Vultr API v2.
+const defaultBaseURL = "https://api.vultr.com/v2"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	APIKey             string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("VULTR_PROPAGATION_TIMEOUT", 60*time.Second),
+		PollingInterval:    env.GetOrDefaultSecond("VULTR_POLLING_INTERVAL", 2*time.Second),
+		TTL:                env.GetOrDefaultInt("VULTR_TTL", 120),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("VULTR_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface.
 type DNSProvider struct {
-	client *vultr.Client
+	config *Config
+	// recordIDs are the IDs of the created records, by fqdn and value.
+	recordIDs   map[string]string
+	recordIDsMu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance with a configured Vultr client.
@@ -25,103 +55,153 @@ func NewDNSProvider() (*DNSProvider, error) {
 		return nil, fmt.Errorf("Vultr: %v", err)
 	}
 
-	return NewDNSProviderCredentials(values["VULTR_API_KEY"])
+	config := NewDefaultConfig()
+	config.APIKey = values["VULTR_API_KEY"]
+
+	return NewDNSProviderConfig(config)
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a DNSProvider
 // instance configured for Vultr.
 func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
-	if apiKey == "" {
+	config := NewDefaultConfig()
+	config.APIKey = apiKey
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Vultr.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("Vultr: the configuration of the DNS provider is nil")
+	}
+
+	if config.APIKey == "" {
 		return nil, fmt.Errorf("Vultr credentials missing")
 	}
 
-	return &DNSProvider{client: vultr.NewClient(apiKey, nil)}, nil
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config, recordIDs: make(map[string]string)}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
 // Present creates a TXT record to fulfil the DNS-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	zoneDomain, err := d.getHostedZone(domain)
+	zoneDomain, err := d.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
 
-	name := d.extractRecordName(fqdn, zoneDomain)
+	record := dnsRecord{
+		Name: extractRecordName(fqdn, zoneDomain),
+		Type: "TXT",
+		Data: `"` + value + `"`,
+		TTL:  d.config.TTL,
+	}
 
-	err = d.client.CreateDNSRecord(zoneDomain, name, "TXT", `"`+value+`"`, 0, ttl)
+	recordID, err := d.createRecord(zoneDomain, record)
 	if err != nil {
 		return fmt.Errorf("Vultr API call failed: %v", err)
 	}
 
+	d.recordIDsMu.Lock()
+	d.recordIDs[fqdn+" "+value] = recordID
+	d.recordIDsMu.Unlock()
+
 	return nil
 }
 
 // CleanUp removes the TXT record matching the specified parameters.
+// The record is deleted by the ID stored at its creation, or looked up by its name and value otherwise.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	zoneDomain, records, err := d.findTxtRecords(domain, fqdn)
+	zoneDomain, err := d.getHostedZone(fqdn)
 	if err != nil {
 		return err
 	}
 
-	for _, rec := range records {
-		err := d.client.DeleteDNSRecord(zoneDomain, rec.RecordID)
+	d.recordIDsMu.Lock()
+	recordID, ok := d.recordIDs[fqdn+" "+value]
+	d.recordIDsMu.Unlock()
+
+	if !ok {
+		recordID, err = d.findTxtRecord(zoneDomain, extractRecordName(fqdn, zoneDomain), `"`+value+`"`)
 		if err != nil {
 			return err
 		}
 	}
+
+	err = d.deleteRecord(zoneDomain, recordID)
+	if err != nil {
+		return fmt.Errorf("Vultr API call failed: %v", err)
+	}
+
+	d.recordIDsMu.Lock()
+	delete(d.recordIDs, fqdn+" "+value)
+	d.recordIDsMu.Unlock()
+
 	return nil
 }
 
-func (d *DNSProvider) getHostedZone(domain string) (string, error) {
-	domains, err := d.client.GetDNSDomains()
+// getHostedZone returns the longest domain of the account matching the fqdn.
+func (d *DNSProvider) getHostedZone(fqdn string) (string, error) {
+	domains, err := d.listDomains()
 	if err != nil {
 		return "", fmt.Errorf("Vultr API call failed: %v", err)
 	}
 
-	var hostedDomain vultr.DNSDomain
+	name := acme.UnFqdn(fqdn)
+
+	var hostedDomain string
 	for _, dom := range domains {
-		if strings.HasSuffix(domain, dom.Domain) {
-			if len(dom.Domain) > len(hostedDomain.Domain) {
-				hostedDomain = dom
-			}
+		if (name == dom.Domain || strings.HasSuffix(name, "."+dom.Domain)) && len(dom.Domain) > len(hostedDomain) {
+			hostedDomain = dom.Domain
 		}
 	}
-	if hostedDomain.Domain == "" {
-		return "", fmt.Errorf("No matching Vultr domain found for domain %s", domain)
+
+	if hostedDomain == "" {
+		return "", fmt.Errorf("No matching Vultr domain found for domain %s", fqdn)
 	}
 
-	return hostedDomain.Domain, nil
+	return hostedDomain, nil
 }
 
-func (d *DNSProvider) findTxtRecords(domain, fqdn string) (string, []vultr.DNSRecord, error) {
-	zoneDomain, err := d.getHostedZone(domain)
+// findTxtRecord returns the ID of the TXT record of the domain with the given name and data.
+func (d *DNSProvider) findTxtRecord(zoneDomain, name, data string) (string, error) {
+	records, err := d.listRecords(zoneDomain)
 	if err != nil {
-		return "", nil, err
-	}
-
-	var records []vultr.DNSRecord
-	result, err := d.client.GetDNSRecords(zoneDomain)
-	if err != nil {
-		return "", records, fmt.Errorf("Vultr API call has failed: %v", err)
+		return "", fmt.Errorf("Vultr API call failed: %v", err)
 	}
 
-	recordName := d.extractRecordName(fqdn, zoneDomain)
-	for _, record := range result {
-		if record.Type == "TXT" && record.Name == recordName {
-			records = append(records, record)
+	for _, record := range records {
+		if record.Type == "TXT" && extractRecordName(record.Name, zoneDomain) == name && record.Data == data {
+			return record.ID, nil
 		}
 	}
 
-	return zoneDomain, records, nil
+	return "", fmt.Errorf("Vultr: no TXT record %s found in the domain %s", name, zoneDomain)
 }
 
-func (d *DNSProvider) extractRecordName(fqdn, domain string) string {
+// extractRecordName returns the name of the record relative to the domain.
+// Vultr strips the domain from the names of the records, the name of the domain itself being empty.
+func extractRecordName(fqdn, domain string) string {
 	name := acme.UnFqdn(fqdn)
-	if idx := strings.Index(name, "."+domain); idx != -1 {
-		return name[:idx]
+	if name == domain {
+		return ""
 	}
-	return name
+	return strings.TrimSuffix(name, "."+domain)
 }
@@ -1,11 +1,20 @@
 package vultr
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
 )
 
 var (
@@ -24,6 +33,170 @@ func restoreEnv() {
 	os.Setenv("VULTR_API_KEY", apiKey)
 }
 
+// mockServer is a minimal in-memory implementation of the DNS API v2 of Vultr,
+// listing one domain per page.
+type mockServer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	domains []string
+	records map[string][]dnsRecord
+	nextID  int
+	// requests are the "METHOD path" of the received requests.
+	requests []string
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Equal(m.t, "Bearer secret", r.Header.Get("Authorization"))
+	m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/domains":
+		index, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+
+		page := domainsPage{Domains: []dnsDomain{{Domain: m.domains[index]}}}
+		if index+1 < len(m.domains) {
+			page.Meta.Links.Next = strconv.Itoa(index + 1)
+		}
+		json.NewEncoder(w).Encode(page)
+
+	case len(parts) == 3 && parts[2] == "records" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(recordsPage{Records: m.records[parts[1]]})
+
+	case len(parts) == 3 && parts[2] == "records" && r.Method == http.MethodPost:
+		var record dnsRecord
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(&record))
+
+		// Vultr strips the domain from the name of the record.
+		record.Name = strings.TrimSuffix(record.Name, "."+parts[1])
+
+		m.nextID++
+		record.ID = fmt.Sprintf("cb676a46-66fd-4dfb-b839-%012d", m.nextID)
+		m.records[parts[1]] = append(m.records[parts[1]], record)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]dnsRecord{"record": record})
+
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		for i, record := range m.records[parts[1]] {
+			if record.ID == parts[3] {
+				m.records[parts[1]] = append(m.records[parts[1]][:i], m.records[parts[1]][i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": "Invalid record.", "status": 404}`)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestExtractRecordName(t *testing.T) {
+	testCases := []struct {
+		fqdn     string
+		domain   string
+		expected string
+	}{
+		{fqdn: "_acme-challenge.example.com.", domain: "example.com", expected: "_acme-challenge"},
+		{fqdn: "_acme-challenge.www.example.com.", domain: "example.com", expected: "_acme-challenge.www"},
+		{fqdn: "_acme-challenge.example.com.example.com", domain: "example.com", expected: "_acme-challenge.example.com"},
+		{fqdn: "_acme-challenge", domain: "example.com", expected: "_acme-challenge"},
+		{fqdn: "example.com.", domain: "example.com", expected: ""},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, extractRecordName(test.fqdn, test.domain), test.fqdn)
+	}
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		domains: []string{"example.org", "example.com", "sub.example.com"},
+		records: map[string][]dnsRecord{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	_, value, _ := acme.DNS01Record("www.example.com", "keyAuth")
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	expected := []dnsRecord{{ID: "cb676a46-66fd-4dfb-b839-000000000001", Type: "TXT", Name: "_acme-challenge.www", Data: `"` + value + `"`, TTL: 120}}
+	assert.Equal(t, expected, server.records["example.com"])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	assert.Empty(t, server.records["example.com"])
+
+	// the record is deleted by the ID stored at its creation.
+	assert.Equal(t, "DELETE /domains/example.com/records/cb676a46-66fd-4dfb-b839-000000000001", server.requests[len(server.requests)-1])
+	assert.NotContains(t, server.requests, "GET /domains/example.com/records")
+}
+
+func TestDNSProvider_CleanUpUnknownRecordID(t *testing.T) {
+	server := &mockServer{
+		t:       t,
+		domains: []string{"example.com", "sub.example.com"},
+		records: map[string][]dnsRecord{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	require.Len(t, server.records["sub.example.com"], 1)
+	assert.Equal(t, "_acme-challenge", server.records["sub.example.com"][0].Name)
+
+	// a new provider does not know the ID of the record and looks it up.
+	other, err := NewDNSProviderConfig(provider.config)
+	require.NoError(t, err)
+
+	err = other.CleanUp("sub.example.com", "", "keyAuth")
+	require.NoError(t, err)
+	assert.Empty(t, server.records["sub.example.com"])
+
+	err = other.CleanUp("sub.example.com", "", "keyAuth")
+	assert.EqualError(t, err, "Vultr: no TXT record _acme-challenge found in the domain sub.example.com")
+}
+
+func TestDNSProvider_PresentNoDomain(t *testing.T) {
+	server := &mockServer{t: t, domains: []string{"example.com"}, records: map[string][]dnsRecord{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.APIKey = "secret"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("example.net", "", "keyAuth")
+	assert.EqualError(t, err, "No matching Vultr domain found for domain _acme-challenge.example.net.")
+}
+
 func TestNewDNSProviderValidEnv(t *testing.T) {
 	defer restoreEnv()
 	os.Setenv("VULTR_API_KEY", "123")
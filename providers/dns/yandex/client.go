@@ -0,0 +1,141 @@
+package yandex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errorMessages are the messages of the errors of the API, by error code.
+var errorMessages = map[string]string{
+	"unknown":       "temporary error of the API",
+	"no_token":      "the PDD token is missing",
+	"no_auth":       "the PDD token is missing",
+	"bad_token":     "the PDD token is invalid",
+	"no_domain":     "the domain is missing",
+	"bad_domain":    "the domain is invalid",
+	"prohibited":    "the domain does not belong to the account of the PDD token",
+	"bad_record":    "the record is invalid",
+	"no_record":     "the record does not exist",
+	"bad_subdomain": "the subdomain is invalid",
+	"bad_type":      "the type of the record is invalid",
+	"bad_ttl":       "the TTL of the record is invalid",
+	"no_content":    "the content of the record is missing",
+	"no_ip":         "the IP address is missing",
+}
+
+// APIError is an error returned by the API, with its code.
+type APIError struct {
+	Code string
+}
+
+func (e APIError) Error() string {
+	if msg, ok := errorMessages[e.Code]; ok {
+		return fmt.Sprintf("API error %s: %s", e.Code, msg)
+	}
+	return fmt.Sprintf("API error %s", e.Code)
+}
+
+// Record is a record of a domain.
+type Record struct {
+	ID        int64  `json:"record_id"`
+	Domain    string `json:"domain"`
+	SubDomain string `json:"subdomain"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	TTL       int    `json:"ttl"`
+}
+
+// response is the envelope of the responses: success is "ok", or "error" with the error code.
+type response struct {
+	Success string `json:"success"`
+	Error   string `json:"error"`
+}
+
+type addResponse struct {
+	response
+	Record Record `json:"record"`
+}
+
+type listResponse struct {
+	response
+	Records []Record `json:"records"`
+}
+
+// addRecord adds the record to its domain.
+func (d *DNSProvider) addRecord(record Record) (*Record, error) {
+	data := url.Values{
+		"domain":    {record.Domain},
+		"type":      {record.Type},
+		"subdomain": {record.SubDomain},
+		"content":   {record.Content},
+		"ttl":       {strconv.Itoa(record.TTL)},
+	}
+
+	var resp addResponse
+	if err := d.doRequest(http.MethodPost, "/add", strings.NewReader(data.Encode()), &resp, &resp.response); err != nil {
+		return nil, err
+	}
+	return &resp.Record, nil
+}
+
+// listRecords returns the records of the domain.
+func (d *DNSProvider) listRecords(domain string) ([]Record, error) {
+	var resp listResponse
+	if err := d.doRequest(http.MethodGet, "/list?domain="+url.QueryEscape(domain), nil, &resp, &resp.response); err != nil {
+		return nil, err
+	}
+	return resp.Records, nil
+}
+
+// deleteRecord deletes the record of the domain.
+func (d *DNSProvider) deleteRecord(domain string, recordID int64) error {
+	data := url.Values{
+		"domain":    {domain},
+		"record_id": {strconv.FormatInt(recordID, 10)},
+	}
+
+	var resp response
+	return d.doRequest(http.MethodPost, "/del", strings.NewReader(data.Encode()), &resp, &resp)
+}
+
+// doRequest sends the request and decodes the JSON response into result,
+// returning the error of its envelope if it is not successful.
+func (d *DNSProvider) doRequest(method, uri string, body io.Reader, result interface{}, envelope *response) error {
+	req, err := http.NewRequest(method, d.config.BaseURL+uri, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PddToken", d.config.PddToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read API response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if err = json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode API response (HTTP %d): %v: %s", resp.StatusCode, err, string(raw))
+	}
+
+	if envelope.Success != "ok" {
+		if envelope.Error == "" {
+			return fmt.Errorf("unexpected API response (HTTP %d): %s", resp.StatusCode, string(raw))
+		}
+		return APIError{Code: envelope.Error}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package yandex implements a DNS provider for solving the DNS-01 challenge using Yandex PDD.
+// See https://yandex.com/dev/pdd/doc/concepts/api-dns.html
+package yandex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/platform/config/env"
+)
+
+// defaultBaseURL represents the API endpoint to call.
+const defaultBaseURL = "https://pddimp.yandex.ru/api2/admin/dns"
+
+// Config is used to configure the creation of the DNSProvider
+type Config struct {
+	PddToken           string
+	BaseURL            string
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	TTL                int
+	HTTPClient         *http.Client
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider
+func NewDefaultConfig() *Config {
+	return &Config{
+		BaseURL:            defaultBaseURL,
+		PropagationTimeout: env.GetOrDefaultSecond("YANDEX_PROPAGATION_TIMEOUT", 10*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond("YANDEX_POLLING_INTERVAL", 10*time.Second),
+		TTL:                env.GetOrDefaultInt("YANDEX_TTL", 300),
+		HTTPClient:         acme.NewHTTPClient(env.GetOrDefaultSecond("YANDEX_HTTP_TIMEOUT", 30*time.Second)),
+	}
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Yandex PDD API to manage TXT records for a domain.
+type DNSProvider struct {
+	config *Config
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Yandex PDD.
+// The PDD token must be passed in the environment variable YANDEX_PDD_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	values, err := env.Get("YANDEX_PDD_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("yandex: %v", err)
+	}
+
+	config := NewDefaultConfig()
+	config.PddToken = values["YANDEX_PDD_TOKEN"]
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderCredentials uses the supplied PDD token to return a
+// DNSProvider instance configured for Yandex PDD.
+func NewDNSProviderCredentials(pddToken string) (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.PddToken = pddToken
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Yandex PDD.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("yandex: the configuration of the DNS provider is nil")
+	}
+
+	if config.PddToken == "" {
+		return nil, errors.New("yandex: credentials missing")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = acme.NewHTTPClient(30 * time.Second)
+	}
+
+	return &DNSProvider{config: config}, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+// Adjusting here to cope with spikes in propagation times.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates a TXT record to fulfil the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	rootDomain, subDomain, err := splitFqdn(fqdn)
+	if err != nil {
+		return fmt.Errorf("yandex: %v", err)
+	}
+
+	_, err = d.addRecord(Record{
+		Domain:    rootDomain,
+		SubDomain: subDomain,
+		Type:      "TXT",
+		Content:   value,
+		TTL:       d.config.TTL,
+	})
+	if err != nil {
+		return fmt.Errorf("yandex: failed to add the TXT record: %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+// The ID of the record is found in the list of the records of its domain.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	rootDomain, subDomain, err := splitFqdn(fqdn)
+	if err != nil {
+		return fmt.Errorf("yandex: %v", err)
+	}
+
+	records, err := d.listRecords(rootDomain)
+	if err != nil {
+		return fmt.Errorf("yandex: failed to list the records: %v", err)
+	}
+
+	for _, record := range records {
+		if record.Type == "TXT" && record.SubDomain == subDomain && record.Content == value {
+			if err = d.deleteRecord(rootDomain, record.ID); err != nil {
+				return fmt.Errorf("yandex: failed to delete the TXT record: %v", err)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("yandex: unknown TXT record for %q", fqdn)
+}
+
+// splitFqdn returns the domain of the fqdn and the subdomain of the record relative to it, "@" for the domain itself.
+func splitFqdn(fqdn string) (string, string, error) {
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", err
+	}
+
+	domain := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == domain {
+		return domain, "@", nil
+	}
+	return domain, strings.TrimSuffix(name, "."+domain), nil
+}
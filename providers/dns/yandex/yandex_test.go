@@ -0,0 +1,236 @@
+package yandex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/platform/tester"
+)
+
+var (
+	liveTest        bool
+	envTestPddToken string
+	envTestDomain   string
+)
+
+func init() {
+	envTestPddToken = os.Getenv("YANDEX_PDD_TOKEN")
+	envTestDomain = os.Getenv("YANDEX_DOMAIN")
+	liveTest = len(envTestPddToken) > 0 && len(envTestDomain) > 0
+}
+
+func restoreEnv() {
+	os.Setenv("YANDEX_PDD_TOKEN", envTestPddToken)
+}
+
+// mockServer is a Yandex PDD API with the domain example.com.
+type mockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	records  []Record
+	nextID   int64
+	requests []string
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	require.NoError(s.t, r.ParseForm())
+
+	if r.Header.Get("PddToken") != "token" {
+		json.NewEncoder(w).Encode(response{Success: "error", Error: "bad_token"})
+		return
+	}
+
+	if r.Form.Get("domain") != "example.com" {
+		json.NewEncoder(w).Encode(response{Success: "error", Error: "prohibited"})
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /list":
+		json.NewEncoder(w).Encode(listResponse{response: response{Success: "ok"}, Records: s.records})
+	case "POST /add":
+		ttl, err := strconv.Atoi(r.PostForm.Get("ttl"))
+		require.NoError(s.t, err)
+
+		s.nextID++
+		record := Record{
+			ID:        s.nextID,
+			Domain:    "example.com",
+			SubDomain: r.PostForm.Get("subdomain"),
+			Type:      r.PostForm.Get("type"),
+			Content:   r.PostForm.Get("content"),
+			TTL:       ttl,
+		}
+		s.records = append(s.records, record)
+		json.NewEncoder(w).Encode(addResponse{response: response{Success: "ok"}, Record: record})
+	case "POST /del":
+		id, err := strconv.ParseInt(r.PostForm.Get("record_id"), 10, 64)
+		require.NoError(s.t, err)
+
+		for i, record := range s.records {
+			if record.ID == id {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				json.NewEncoder(w).Encode(response{Success: "ok"})
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(response{Success: "error", Error: "no_record"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("YANDEX_PDD_TOKEN", "token")
+
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	defer restoreEnv()
+	os.Setenv("YANDEX_PDD_TOKEN", "")
+
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "yandex: some credentials information are missing: YANDEX_PDD_TOKEN")
+}
+
+func TestNewDNSProviderConfig(t *testing.T) {
+	_, err := NewDNSProviderConfig(nil)
+	assert.EqualError(t, err, "yandex: the configuration of the DNS provider is nil")
+
+	_, err = NewDNSProviderCredentials("")
+	assert.EqualError(t, err, "yandex: credentials missing")
+}
+
+func TestAPIError(t *testing.T) {
+	assert.EqualError(t, APIError{Code: "bad_token"}, "API error bad_token: the PDD token is invalid")
+	assert.EqualError(t, APIError{Code: "prohibited"}, "API error prohibited: the domain does not belong to the account of the PDD token")
+	assert.EqualError(t, APIError{Code: "new_error"}, "API error new_error")
+}
+
+func TestSplitFqdn(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	domain, subDomain, err := splitFqdn("_acme-challenge.www.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+	assert.Equal(t, "_acme-challenge.www", subDomain)
+
+	_, subDomain, err = splitFqdn("example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "@", subDomain)
+}
+
+func TestDNSProvider_PresentAndCleanUp(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t, nextID: 1, records: []Record{
+		{ID: 1, Domain: "example.com", SubDomain: "@", Type: "A", Content: "192.0.2.1", TTL: 21600},
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.PddToken = "token"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	require.Len(t, server.records, 2)
+	assert.Equal(t, Record{
+		ID:        2,
+		Domain:    "example.com",
+		SubDomain: "_acme-challenge.www",
+		Type:      "TXT",
+		Content:   "pW9ZKG0xz_PCriK-nCMOjADy9eJcgGWIzkkj2fN4uZM",
+		TTL:       300,
+	}, server.records[1])
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	require.NoError(t, err)
+
+	// only the TXT record is deleted, found by its ID in the list.
+	require.Len(t, server.records, 1)
+	assert.Equal(t, "A", server.records[0].Type)
+	assert.Equal(t, []string{"POST /add", "GET /list", "POST /del"}, server.requests)
+}
+
+func TestDNSProvider_CleanUpUnknownRecord(t *testing.T) {
+	defer tester.MockZones(t, "example.com.")()
+
+	server := &mockServer{t: t}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.PddToken = "token"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, `yandex: unknown TXT record for "_acme-challenge.www.example.com."`)
+	assert.Equal(t, []string{"GET /list"}, server.requests)
+}
+
+func TestDNSProvider_Errors(t *testing.T) {
+	defer tester.MockZones(t, "example.com.", "example.org.")()
+
+	ts := httptest.NewServer(&mockServer{t: t})
+	defer ts.Close()
+
+	config := NewDefaultConfig()
+	config.PddToken = "invalid"
+	config.BaseURL = ts.URL
+
+	provider, err := NewDNSProviderConfig(config)
+	require.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "keyAuth")
+	assert.EqualError(t, err, "yandex: failed to add the TXT record: API error bad_token: the PDD token is invalid")
+
+	provider.config.PddToken = "token"
+
+	err = provider.CleanUp("www.example.org", "", "keyAuth")
+	assert.EqualError(t, err, "yandex: failed to list the records: API error prohibited: the domain does not belong to the account of the PDD token")
+
+	_, err = provider.listRecords("example.org")
+	assert.Equal(t, APIError{Code: "prohibited"}, err)
+}
+
+func TestLivePresentAndCleanUp(t *testing.T) {
+	if !liveTest {
+		t.Skip("skipping live test")
+	}
+
+	restoreEnv()
+	provider, err := NewDNSProvider()
+	require.NoError(t, err)
+
+	err = provider.Present(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+
+	err = provider.CleanUp(envTestDomain, "", "123d==")
+	require.NoError(t, err)
+}
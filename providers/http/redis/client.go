@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNil is returned for the nil bulk string reply, e.g. when a key does not exist.
+var errNil = errors.New("redis: nil")
+
+// conn is a connection to a Redis server speaking the RESP protocol,
+// only implementing what the provider and the handler need.
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// dial connects to the Redis server of the config, authenticates and selects the database.
+func dial(config *Config) (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", config.Addr, config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: could not connect to %s: %v", config.Addr, err)
+	}
+
+	c := &conn{netConn: netConn, reader: bufio.NewReader(netConn), timeout: config.Timeout}
+
+	if config.Password != "" {
+		if _, err = c.do("AUTH", config.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if config.DB != 0 {
+		if _, err = c.do("SELECT", strconv.Itoa(config.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	return c.netConn.Close()
+}
+
+// do sends the command and returns its reply: a string for the simple and bulk strings, or an int64.
+func (c *conn) do(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		c.netConn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := c.netConn.Write(b.Bytes()); err != nil {
+		return nil, fmt.Errorf("redis: could not send %s: %v", args[0], err)
+	}
+
+	reply, err := c.readReply()
+	if err != nil && err != errNil {
+		return nil, fmt.Errorf("redis: %s failed: %v", args[0], err)
+	}
+	return reply, err
+}
+
+func (c *conn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string size %q", line[1:])
+		}
+		if size < 0 {
+			return nil, errNil
+		}
+
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	default:
+		return nil, fmt.Errorf("unsupported reply %q", line)
+	}
+}
@@ -0,0 +1,164 @@
+// Package redis implements a HTTP provider for solving the HTTP-01 challenge using Redis
+// in combination with the web servers, e.g. when they are behind a load balancer:
+// the provider stores the key authorizations in Redis and the Handler of each web server serves them.
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/log"
+)
+
+// Config is used to configure the creation of the HTTPProvider and of the Handler.
+type Config struct {
+	// Addr is the host:port of the Redis server.
+	Addr     string
+	Password string
+	DB       int
+	// TTL is the expiration of the stored key authorizations, in case CleanUp is not called.
+	TTL time.Duration
+	// KeyPrefix is prepended to the tokens to build the Redis keys.
+	KeyPrefix string
+	// Timeout applies to the connection and to each command.
+	Timeout time.Duration
+}
+
+// NewDefaultConfig returns a default configuration for the HTTPProvider and the Handler.
+func NewDefaultConfig() *Config {
+	return &Config{
+		Addr:      "localhost:6379",
+		TTL:       10 * time.Minute,
+		KeyPrefix: "lego:http-01:",
+		Timeout:   5 * time.Second,
+	}
+}
+
+func (c *Config) key(token string) string {
+	return c.KeyPrefix + token
+}
+
+func checkConfig(config *Config) error {
+	if config == nil {
+		return errors.New("redis: the configuration is nil")
+	}
+	if config.Addr == "" {
+		return errors.New("redis: no address provided")
+	}
+	if config.TTL < time.Millisecond {
+		return fmt.Errorf("redis: invalid TTL %s, must be at least 1ms", config.TTL)
+	}
+	return nil
+}
+
+// HTTPProvider implements ChallengeProvider for `http-01` challenge
+// by storing the key authorizations in Redis.
+type HTTPProvider struct {
+	config *Config
+}
+
+// NewHTTPProvider returns a HTTPProvider instance storing the key authorizations in the configured Redis server.
+func NewHTTPProvider(config *Config) (*HTTPProvider, error) {
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+
+	return &HTTPProvider{config: config}, nil
+}
+
+// Present stores the key authorization of the token in Redis, with the configured TTL.
+func (p *HTTPProvider) Present(domain, token, keyAuth string) error {
+	c, err := dial(p.config)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ttl := strconv.FormatInt(int64(p.config.TTL/time.Millisecond), 10)
+	_, err = c.do("SET", p.config.key(token), keyAuth, "PX", ttl)
+	return err
+}
+
+// CleanUp removes the key authorization of the token from Redis.
+func (p *HTTPProvider) CleanUp(domain, token, keyAuth string) error {
+	c, err := dial(p.config)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.do("DEL", p.config.key(token))
+	return err
+}
+
+// Handler is an http.Handler serving the key authorizations stored in Redis by the HTTPProvider,
+// to run on each web server which may receive the challenge requests.
+// It is to register for the `/.well-known/acme-challenge/` path of the router of the server,
+// or to wrap the whole router.
+type Handler struct {
+	config *Config
+	next   http.Handler
+}
+
+// NewHandler creates a new Handler reading the key authorizations from the configured Redis server.
+// The requests which are not for a stored token are passed to next,
+// or answered with a 404 status if next is nil.
+func NewHandler(config *Config, next http.Handler) (*Handler, error) {
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+
+	return &Handler{config: config, next: next}, nil
+}
+
+// ServeHTTP serves the key authorization of the tokens stored in Redis.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if token := strings.TrimPrefix(r.URL.Path, acme.HTTP01ChallengePath("")); token != r.URL.Path && token != "" {
+			keyAuth, err := h.get(token)
+			if err != nil {
+				log.Warnf("[%s] Could not read the key authentication: %v", r.Host, err)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			if keyAuth != "" {
+				w.Header().Add("Content-Type", "text/plain")
+				w.Write([]byte(keyAuth))
+				log.Infof("[%s] Served key authentication", r.Host)
+				return
+			}
+		}
+	}
+
+	if h.next == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// get returns the key authorization of the token, or an empty string if it is not stored.
+func (h *Handler) get(token string) (string, error) {
+	c, err := dial(h.config)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	reply, err := c.do("GET", h.config.key(token))
+	if err == errNil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	keyAuth, _ := reply.(string)
+	return keyAuth, nil
+}
@@ -0,0 +1,302 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xenolf/lego/acme"
+)
+
+const (
+	domain  = "lego.test"
+	token   = "foo"
+	keyAuth = "bar"
+)
+
+// fakeRedis is a Redis server supporting AUTH, SELECT, SET with PX, GET and DEL.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[int]map[string]string
+	ttls     map[int]map[string]int64
+	commands []string
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeRedis{
+		listener: listener,
+		password: password,
+		data:     make(map[int]map[string]string),
+		ttls:     make(map[int]map[string]int64),
+	}
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeRedis) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeRedis) get(db int, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[db][key]
+	return value, ok
+}
+
+func (s *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+
+	reader := bufio.NewReader(c)
+	authenticated := s.password == ""
+	db := 0
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.ToUpper(args[0]))
+
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[1] != s.password {
+				reply = "-WRONGPASS invalid password\r\n"
+			} else {
+				authenticated = true
+				reply = "+OK\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			reply = "+OK\r\n"
+		case cmd == "SET" && len(args) == 5 && strings.ToUpper(args[3]) == "PX":
+			if s.data[db] == nil {
+				s.data[db] = make(map[string]string)
+				s.ttls[db] = make(map[string]int64)
+			}
+			s.data[db][args[1]] = args[2]
+			s.ttls[db][args[1]], _ = strconv.ParseInt(args[4], 10, 64)
+			reply = "+OK\r\n"
+		case cmd == "GET":
+			if value, ok := s.data[db][args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case cmd == "DEL":
+			_, ok := s.data[db][args[1]]
+			delete(s.data[db], args[1])
+			if ok {
+				reply = ":1\r\n"
+			} else {
+				reply = ":0\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mu.Unlock()
+
+		if _, err = io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func newTestConfig(server *fakeRedis) *Config {
+	config := NewDefaultConfig()
+	config.Addr = server.Addr()
+	config.Password = "secret"
+	config.DB = 2
+	config.TTL = 90 * time.Second
+	return config
+}
+
+func serveChallenge(t *testing.T, handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://"+domain+acme.HTTP01ChallengePath(token), nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNewHTTPProviderInvalidConfig(t *testing.T) {
+	_, err := NewHTTPProvider(nil)
+	assert.EqualError(t, err, "redis: the configuration is nil")
+
+	config := NewDefaultConfig()
+	config.Addr = ""
+	_, err = NewHTTPProvider(config)
+	assert.EqualError(t, err, "redis: no address provided")
+
+	config = NewDefaultConfig()
+	config.TTL = 0
+	_, err = NewHandler(config, nil)
+	assert.EqualError(t, err, "redis: invalid TTL 0s, must be at least 1ms")
+}
+
+func TestHTTPProvider_PresentServeAndCleanUp(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	defer server.Close()
+
+	config := newTestConfig(server)
+
+	provider, err := NewHTTPProvider(config)
+	require.NoError(t, err)
+
+	handler, err := NewHandler(config, nil)
+	require.NoError(t, err)
+
+	err = provider.Present(domain, token, keyAuth)
+	require.NoError(t, err)
+
+	value, ok := server.get(2, "lego:http-01:"+token)
+	assert.True(t, ok)
+	assert.Equal(t, keyAuth, value)
+	server.mu.Lock()
+	assert.Equal(t, int64(90000), server.ttls[2]["lego:http-01:"+token])
+	server.mu.Unlock()
+
+	rec := serveChallenge(t, handler, token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, keyAuth, rec.Body.String())
+
+	err = provider.CleanUp(domain, token, keyAuth)
+	require.NoError(t, err)
+
+	_, ok = server.get(2, "lego:http-01:"+token)
+	assert.False(t, ok)
+
+	rec = serveChallenge(t, handler, token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandler_Next(t *testing.T) {
+	server := startFakeRedis(t, "")
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.Addr = server.Addr()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	handler, err := NewHandler(config, next)
+	require.NoError(t, err)
+
+	// unknown tokens are passed to next.
+	rec := serveChallenge(t, handler, "unknown")
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	// the other paths do not query Redis.
+	req := httptest.NewRequest(http.MethodGet, "http://"+domain+"/index.html", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	server.mu.Lock()
+	assert.Equal(t, []string{"GET"}, server.commands)
+	server.mu.Unlock()
+}
+
+func TestHTTPProvider_InvalidPassword(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	defer server.Close()
+
+	config := newTestConfig(server)
+	config.Password = "other"
+
+	provider, err := NewHTTPProvider(config)
+	require.NoError(t, err)
+
+	err = provider.Present(domain, token, keyAuth)
+	assert.EqualError(t, err, "redis: AUTH failed: WRONGPASS invalid password")
+
+	handler, err := NewHandler(config, nil)
+	require.NoError(t, err)
+
+	rec := serveChallenge(t, handler, token)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestHTTPProvider_Unreachable(t *testing.T) {
+	server := startFakeRedis(t, "")
+	addr := server.Addr()
+	server.Close()
+
+	config := NewDefaultConfig()
+	config.Addr = addr
+
+	provider, err := NewHTTPProvider(config)
+	require.NoError(t, err)
+
+	err = provider.Present(domain, token, keyAuth)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "redis: could not connect to "+addr)
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 
 	"github.com/xenolf/lego/acme"
 )
@@ -32,15 +32,15 @@ func NewHTTPProvider(path string) (*HTTPProvider, error) {
 func (w *HTTPProvider) Present(domain, token, keyAuth string) error {
 	var err error
 
-	challengeFilePath := path.Join(w.path, acme.HTTP01ChallengePath(token))
-	err = os.MkdirAll(path.Dir(challengeFilePath), 0755)
+	challengeFilePath := filepath.Join(w.path, acme.HTTP01ChallengePath(token))
+	err = os.MkdirAll(filepath.Dir(challengeFilePath), 0755)
 	if err != nil {
-		return fmt.Errorf("could not create required directories in webroot for HTTP challenge -> %v", err)
+		return fmt.Errorf("could not create required directories in webroot for HTTP challenge -> %v", permissionHint(err))
 	}
 
-	err = ioutil.WriteFile(challengeFilePath, []byte(keyAuth), 0644)
+	err = writeFileAtomic(challengeFilePath, []byte(keyAuth), 0644)
 	if err != nil {
-		return fmt.Errorf("could not write file in webroot for HTTP challenge -> %v", err)
+		return fmt.Errorf("could not write file in webroot for HTTP challenge -> %v", permissionHint(err))
 	}
 
 	return nil
@@ -48,10 +48,44 @@ func (w *HTTPProvider) Present(domain, token, keyAuth string) error {
 
 // CleanUp removes the file created for the challenge
 func (w *HTTPProvider) CleanUp(domain, token, keyAuth string) error {
-	err := os.Remove(path.Join(w.path, acme.HTTP01ChallengePath(token)))
+	err := os.Remove(filepath.Join(w.path, acme.HTTP01ChallengePath(token)))
 	if err != nil {
-		return fmt.Errorf("could not remove file in webroot after HTTP challenge -> %v", err)
+		return fmt.Errorf("could not remove file in webroot after HTTP challenge -> %v", permissionHint(err))
 	}
 
 	return nil
 }
+
+// writeFileAtomic writes the data to a temporary file next to filename and renames it,
+// so that the web server never serves a partially written challenge file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// permissionHint makes permission errors explicit about the user lego runs as.
+func permissionHint(err error) error {
+	if os.IsPermission(err) {
+		return fmt.Errorf("%v (make sure the webroot is writable by the user running lego, uid %d)", err, os.Getuid())
+	}
+	return err
+}
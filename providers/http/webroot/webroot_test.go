@@ -3,6 +3,8 @@ package webroot
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -44,3 +46,69 @@ func TestHTTPProvider(t *testing.T) {
 		t.Errorf("Webroot provider CleanUp() error: got %v, want nil", err)
 	}
 }
+
+func TestHTTPProviderCreatesDirectories(t *testing.T) {
+	webroot, err := ioutil.TempDir("", "webroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(webroot)
+
+	provider, err := NewHTTPProvider(webroot)
+	if err != nil {
+		t.Fatalf("Webroot provider error: got %v, want nil", err)
+	}
+
+	err = provider.Present("domain", "token", "keyAuth")
+	if err != nil {
+		t.Fatalf("Webroot provider present() error: got %v, want nil", err)
+	}
+
+	challengeDir := filepath.Join(webroot, ".well-known", "acme-challenge")
+	files, err := ioutil.ReadDir(challengeDir)
+	if err != nil {
+		t.Fatalf("Challenge directory was not created in webroot: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "token" {
+		t.Errorf("Expected only the challenge file in the webroot, got %v", files)
+	}
+	if mode := files[0].Mode().Perm(); mode != 0644 {
+		t.Errorf("Challenge file mode: got %v, want %v", mode, os.FileMode(0644))
+	}
+
+	err = provider.CleanUp("domain", "token", "keyAuth")
+	if err != nil {
+		t.Errorf("Webroot provider CleanUp() error: got %v, want nil", err)
+	}
+	if _, err := os.Stat(filepath.Join(challengeDir, "token")); !os.IsNotExist(err) {
+		t.Error("Challenge file was not removed from webroot")
+	}
+}
+
+func TestHTTPProviderPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+
+	webroot, err := ioutil.TempDir("", "webroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(webroot)
+
+	err = os.Chmod(webroot, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(webroot, 0755)
+
+	provider, err := NewHTTPProvider(webroot)
+	if err != nil {
+		t.Fatalf("Webroot provider error: got %v, want nil", err)
+	}
+
+	err = provider.Present("domain", "token", "keyAuth")
+	if err == nil || !strings.Contains(err.Error(), "writable by the user running lego") {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+}